@@ -0,0 +1,135 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// Keys of the Secret the credentials of an ApiKey are written to
+const (
+	// APIKeySecretNameKey holds the name of the machine identity
+	APIKeySecretNameKey = "name"
+	// APIKeySecretKeyKey holds the generated key
+	APIKeySecretKeyKey = "key"
+)
+
+// APIKeyRotation configures periodic replacement of the key
+type APIKeyRotation struct {
+	// Enabled turns on key rotation
+	Enabled bool `json:"enabled,omitempty"`
+	// Interval between two rotations
+	// +kubebuilder:default="720h"
+	// +optional
+	Interval metav1.Duration `json:"interval,omitempty"`
+}
+
+// ApiKeySpec defines the desired state of ApiKey
+type ApiKeySpec struct {
+	// Name of the machine identity in the identity system
+	Name string `json:"name"`
+	// Description of the machine identity
+	// +optional
+	Description string `json:"description,omitempty"`
+
+	// SecretName is the name of the Secret in the namespace of the ApiKey the name and
+	// the generated key are written to. The Secret is owned by the ApiKey.
+	SecretName string `json:"secretName"`
+
+	// Rotation makes the operator periodically replace the key
+	// +optional
+	Rotation *APIKeyRotation `json:"rotation,omitempty"`
+
+	// InstanceRef references the IdentityInstance the key is managed in.
+	// When omitted the operator-level configuration is used.
+	// +optional
+	InstanceRef *IdentityInstanceReference `json:"instanceRef,omitempty"`
+
+	// Paused stops reconciliation, including revocation of the key,
+	// e.g. during manual maintenance of the identity system
+	// +optional
+	Paused bool `json:"paused,omitempty"`
+}
+
+// ApiKeyStatus defines the observed state of ApiKey
+type ApiKeyStatus struct {
+	// ID of the key in the identity system
+	ID string `json:"id,omitempty"`
+
+	// ObservedGeneration is the generation of the spec last synced to the identity system
+	// +optional
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+	// LastSyncTime is the time of the last successful sync with the identity system
+	// +optional
+	LastSyncTime *metav1.Time `json:"lastSyncTime,omitempty"`
+
+	// LastRotationTime is when the key currently stored in the Secret was generated
+	// +optional
+	LastRotationTime *metav1.Time `json:"lastRotationTime,omitempty"`
+
+	// Conditions represent the latest available observations of the ApiKey's state
+	// +optional
+	// +listType=map
+	// +listMapKey=type
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+//+kubebuilder:subresource:status
+//+kubebuilder:resource:categories=idm
+//+kubebuilder:printcolumn:name="Name",type=string,JSONPath=`.spec.name`
+//+kubebuilder:printcolumn:name="Secret",type=string,JSONPath=`.spec.secretName`
+//+kubebuilder:printcolumn:name="Ready",type=string,JSONPath=`.status.conditions[?(@.type=="Ready")].status`
+//+kubebuilder:printcolumn:name="Rotated",type=date,JSONPath=`.status.lastRotationTime`
+
+// ApiKey is the Schema for the apikeys API
+type ApiKey struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   ApiKeySpec   `json:"spec,omitempty"`
+	Status ApiKeyStatus `json:"status,omitempty"`
+}
+
+// GetConditions returns the conditions of the ApiKey status
+func (k *ApiKey) GetConditions() []metav1.Condition {
+	return k.Status.Conditions
+}
+
+// SetConditions replaces the conditions of the ApiKey status
+func (k *ApiKey) SetConditions(conditions []metav1.Condition) {
+	k.Status.Conditions = conditions
+}
+
+// SetLastSync records the generation and time of the last successful sync of the ApiKey
+func (k *ApiKey) SetLastSync(generation int64, at metav1.Time) {
+	k.Status.ObservedGeneration = generation
+	k.Status.LastSyncTime = &at
+}
+
+//+kubebuilder:object:root=true
+
+// ApiKeyList contains a list of ApiKey
+type ApiKeyList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []ApiKey `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&ApiKey{}, &ApiKeyList{})
+}
@@ -0,0 +1,121 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// GroupSpec defines the desired state of Group
+type GroupSpec struct {
+	// Name of the group in the identity system
+	Name string `json:"name"`
+	// Description of the group
+	// +optional
+	Description string `json:"description,omitempty"`
+
+	// InstanceRef references the IdentityInstance the group is managed in.
+	// When omitted the operator-level configuration is used.
+	// +optional
+	InstanceRef *IdentityInstanceReference `json:"instanceRef,omitempty"`
+
+	// ParentGroupRef references the Group this group is nested in. Nesting is supported
+	// by SCIM and Keycloak identity systems, the parent must be managed in the same one.
+	// +optional
+	ParentGroupRef *GroupReference `json:"parentGroupRef,omitempty"`
+
+	// MemberSelector selects the Users of the namespace that are members of the group, next
+	// to the ones bound by GroupBindings. Users managed in another identity instance than
+	// the group are not selected.
+	// +optional
+	MemberSelector *metav1.LabelSelector `json:"memberSelector,omitempty"`
+
+	// Paused stops reconciliation, including deletion of the external group,
+	// e.g. during manual maintenance of the identity system
+	// +optional
+	Paused bool `json:"paused,omitempty"`
+}
+
+// GroupStatus defines the observed state of Group
+type GroupStatus struct {
+	// ID of the group in the identity system
+	ID string `json:"id,omitempty"`
+
+	// ObservedGeneration is the generation of the spec last synced to the identity system
+	// +optional
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+	// LastSyncTime is the time of the last successful sync with the identity system
+	// +optional
+	LastSyncTime *metav1.Time `json:"lastSyncTime,omitempty"`
+
+	// ParentID is the ID of the group in the identity system this group is nested in
+	// +optional
+	ParentID string `json:"parentId,omitempty"`
+
+	// Members are the IDs of the external users added to the group for memberSelector.
+	// Only these are removed again once their Users are no longer selected.
+	// +optional
+	Members []string `json:"members,omitempty"`
+
+	// Conditions represent the latest available observations of the Group's state
+	// +optional
+	// +listType=map
+	// +listMapKey=type
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+//+kubebuilder:subresource:status
+//+kubebuilder:resource:categories=idm
+
+// Group is the Schema for the groups API
+type Group struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   GroupSpec   `json:"spec,omitempty"`
+	Status GroupStatus `json:"status,omitempty"`
+}
+
+// GetConditions returns the conditions of the Group status
+func (g *Group) GetConditions() []metav1.Condition {
+	return g.Status.Conditions
+}
+
+// SetConditions replaces the conditions of the Group status
+func (g *Group) SetConditions(conditions []metav1.Condition) {
+	g.Status.Conditions = conditions
+}
+
+// SetLastSync records the generation and time of the last successful sync of the Group
+func (g *Group) SetLastSync(generation int64, at metav1.Time) {
+	g.Status.ObservedGeneration = generation
+	g.Status.LastSyncTime = &at
+}
+
+//+kubebuilder:object:root=true
+
+// GroupList contains a list of Group
+type GroupList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []Group `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&Group{}, &GroupList{})
+}
@@ -0,0 +1,97 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	"context"
+	"fmt"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/webhook"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+// SetupWebhookWithManager registers the webhook rejecting cycles of nested Groups
+func (r *Group) SetupWebhookWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewWebhookManagedBy(mgr).
+		For(r).
+		WithValidator(&groupHierarchyValidator{client: mgr.GetAPIReader()}).
+		Complete()
+}
+
+//+kubebuilder:webhook:path=/validate-idm-micze-io-v1-group,mutating=false,failurePolicy=fail,sideEffects=None,groups=idm.micze.io,resources=groups,verbs=create;update,versions=v1,name=vgroup-hierarchy.kb.io,admissionReviewVersions=v1
+
+// groupHierarchyValidator rejects Groups whose parentGroupRef leads back to themselves.
+// It reads from the API server, the cache may be restricted to a subset of the Groups.
+type groupHierarchyValidator struct {
+	client client.Reader
+}
+
+var _ webhook.CustomValidator = &groupHierarchyValidator{}
+
+// ValidateCreate rejects the Group when nesting it closes a cycle
+func (v *groupHierarchyValidator) ValidateCreate(ctx context.Context, obj runtime.Object) (admission.Warnings, error) {
+	group, ok := obj.(*Group)
+	if !ok {
+		return nil, fmt.Errorf("expected a Group but got %T", obj)
+	}
+	return nil, v.validate(ctx, group)
+}
+
+// ValidateUpdate rejects a change of the parent that closes a cycle
+func (v *groupHierarchyValidator) ValidateUpdate(ctx context.Context, oldObj, newObj runtime.Object) (admission.Warnings, error) {
+	group, ok := newObj.(*Group)
+	if !ok {
+		return nil, fmt.Errorf("expected a Group but got %T", newObj)
+	}
+	return nil, v.validate(ctx, group)
+}
+
+// ValidateDelete allows every deletion
+func (v *groupHierarchyValidator) ValidateDelete(ctx context.Context, obj runtime.Object) (admission.Warnings, error) {
+	return nil, nil
+}
+
+// validate follows the parents of the group until it reaches a top level group, a Group
+// that does not exist yet or the group itself
+func (v *groupHierarchyValidator) validate(ctx context.Context, group *Group) error {
+	path := []string{group.Name}
+	visited := map[string]bool{group.Name: true}
+	for ref := group.Spec.ParentGroupRef; ref != nil; {
+		path = append(path, ref.Name)
+		if visited[ref.Name] {
+			return apierrors.NewForbidden(GroupVersion.WithResource("groups").GroupResource(), group.Name,
+				fmt.Errorf("parentGroupRef forms a cycle: %v", path))
+		}
+		visited[ref.Name] = true
+
+		parent := &Group{}
+		err := v.client.Get(ctx, types.NamespacedName{Namespace: group.Namespace, Name: ref.Name}, parent)
+		if apierrors.IsNotFound(err) {
+			return nil
+		}
+		if err != nil {
+			return apierrors.NewInternalError(err)
+		}
+		ref = parent.Spec.ParentGroupRef
+	}
+	return nil
+}
@@ -0,0 +1,62 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	"context"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+var _ = Describe("Group hierarchy validator", func() {
+	ctx := context.Background()
+
+	// newGroup returns a Group nested in parent, a top level group when parent is empty
+	newGroup := func(name, parent string) *Group {
+		group := &Group{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: name}}
+		if parent != "" {
+			group.Spec.ParentGroupRef = &GroupReference{Name: parent}
+		}
+		return group
+	}
+
+	It("accepts nesting without cycles", func() {
+		v := &groupHierarchyValidator{client: fake.NewClientBuilder().WithScheme(newScheme()).
+			WithObjects(newGroup("engineering", ""), newGroup("platform", "engineering")).Build()}
+
+		_, err := v.ValidateCreate(ctx, newGroup("sre", "platform"))
+		Expect(err).NotTo(HaveOccurred())
+		_, err = v.ValidateCreate(ctx, newGroup("sre", "missing"))
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	It("rejects a parent leading back to the group", func() {
+		v := &groupHierarchyValidator{client: fake.NewClientBuilder().WithScheme(newScheme()).
+			WithObjects(newGroup("engineering", ""), newGroup("platform", "engineering")).Build()}
+
+		_, err := v.ValidateUpdate(ctx, newGroup("engineering", ""), newGroup("engineering", "platform"))
+		Expect(apierrors.IsForbidden(err)).To(BeTrue())
+		Expect(err.Error()).To(ContainSubstring("[engineering platform engineering]"))
+		_, err = v.ValidateCreate(ctx, newGroup("self", "self"))
+		Expect(apierrors.IsForbidden(err)).To(BeTrue())
+	})
+})
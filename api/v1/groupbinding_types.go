@@ -0,0 +1,99 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// GroupReference references a Group in the namespace of the referencing object
+type GroupReference struct {
+	// Name of the Group
+	Name string `json:"name"`
+}
+
+// UserReference references a User in the namespace of the referencing object
+type UserReference struct {
+	// Name of the User
+	Name string `json:"name"`
+}
+
+// GroupBindingSpec defines the desired state of GroupBinding
+type GroupBindingSpec struct {
+	// GroupRef references the Group the users are bound to
+	GroupRef GroupReference `json:"groupRef"`
+	// Users bound to the group
+	// +optional
+	// +listType=map
+	// +listMapKey=name
+	Users []UserReference `json:"users,omitempty"`
+
+	// Paused stops reconciliation, including removal of the members added by the binding,
+	// e.g. during manual maintenance of the identity system
+	// +optional
+	Paused bool `json:"paused,omitempty"`
+}
+
+// GroupBindingStatus defines the observed state of GroupBinding
+type GroupBindingStatus struct {
+	// Members are the IDs of the external users added to the group by this binding.
+	// Only these are removed again, members added by other means are left untouched.
+	// +optional
+	Members []string `json:"members,omitempty"`
+
+	// Conditions represent the latest available observations of the GroupBinding's state
+	// +optional
+	// +listType=map
+	// +listMapKey=type
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+//+kubebuilder:subresource:status
+//+kubebuilder:resource:categories=idm
+
+// GroupBinding is the Schema for the groupbindings API
+type GroupBinding struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   GroupBindingSpec   `json:"spec,omitempty"`
+	Status GroupBindingStatus `json:"status,omitempty"`
+}
+
+// GetConditions returns the conditions of the GroupBinding status
+func (b *GroupBinding) GetConditions() []metav1.Condition {
+	return b.Status.Conditions
+}
+
+// SetConditions replaces the conditions of the GroupBinding status
+func (b *GroupBinding) SetConditions(conditions []metav1.Condition) {
+	b.Status.Conditions = conditions
+}
+
+//+kubebuilder:object:root=true
+
+// GroupBindingList contains a list of GroupBinding
+type GroupBindingList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []GroupBinding `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&GroupBinding{}, &GroupBindingList{})
+}
@@ -0,0 +1,97 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// IdentityAuditSpec defines the desired state of IdentityAudit
+type IdentityAuditSpec struct {
+	// InstanceRef references the IdentityInstance whose users are audited.
+	// When omitted the operator-level configuration is audited.
+	// +optional
+	InstanceRef *IdentityInstanceReference `json:"instanceRef,omitempty"`
+	// Interval between two scans of the identity system
+	// +kubebuilder:default="1h"
+	// +optional
+	Interval metav1.Duration `json:"interval,omitempty"`
+	// IgnoreNames lists external users that are never reported, e.g. built-in administrators
+	// +optional
+	IgnoreNames []string `json:"ignoreNames,omitempty"`
+}
+
+// OrphanedUser is an external user that is not managed by any User
+type OrphanedUser struct {
+	// ID of the user in the identity system
+	ID string `json:"id"`
+	// Name of the user in the identity system
+	// +optional
+	Name string `json:"name,omitempty"`
+}
+
+// IdentityAuditStatus defines the observed state of IdentityAudit
+type IdentityAuditStatus struct {
+	// LastScanTime is the time of the last successful scan
+	// +optional
+	LastScanTime *metav1.Time `json:"lastScanTime,omitempty"`
+	// ExternalUsers is the number of users found in the identity system
+	// +optional
+	ExternalUsers int `json:"externalUsers,omitempty"`
+	// OrphanCount is the number of orphaned users
+	// +optional
+	OrphanCount int `json:"orphanCount,omitempty"`
+	// Orphans are the external users without a User, sorted by name
+	// +optional
+	Orphans []OrphanedUser `json:"orphans,omitempty"`
+
+	// Conditions represent the latest available observations of the IdentityAudit's state
+	// +optional
+	// +listType=map
+	// +listMapKey=type
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+//+kubebuilder:subresource:status
+//+kubebuilder:resource:scope=Cluster,categories=idm
+//+kubebuilder:printcolumn:name="Orphans",type=integer,JSONPath=`.status.orphanCount`
+//+kubebuilder:printcolumn:name="Last Scan",type=date,JSONPath=`.status.lastScanTime`
+//+kubebuilder:printcolumn:name="Age",type=date,JSONPath=`.metadata.creationTimestamp`
+
+// IdentityAudit periodically reports the users of an identity system that are not
+// managed by any User, e.g. accounts created outside Kubernetes
+type IdentityAudit struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   IdentityAuditSpec   `json:"spec,omitempty"`
+	Status IdentityAuditStatus `json:"status,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+
+// IdentityAuditList contains a list of IdentityAudit
+type IdentityAuditList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []IdentityAudit `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&IdentityAudit{}, &IdentityAuditList{})
+}
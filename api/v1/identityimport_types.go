@@ -0,0 +1,109 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// LabelImportedBy is set on Users created by an IdentityImport to the name of the import
+const LabelImportedBy = "idm.micze.io/imported-by"
+
+// ConditionComplete indicates the IdentityImport finished for the current generation
+const ConditionComplete = "Complete"
+
+// IdentityImportSpec defines the desired state of IdentityImport
+type IdentityImportSpec struct {
+	// InstanceRef references the IdentityInstance the users are imported from.
+	// When omitted the operator-level configuration is used.
+	// +optional
+	InstanceRef *IdentityInstanceReference `json:"instanceRef,omitempty"`
+	// Names restricts the import to the external users with these names.
+	// All users not managed by a User yet are imported when empty.
+	// +optional
+	Names []string `json:"names,omitempty"`
+	// IgnoreNames lists external users that are never imported, e.g. built-in administrators
+	// +optional
+	IgnoreNames []string `json:"ignoreNames,omitempty"`
+	// DeletionPolicy of the created Users. Defaults to Orphan, so deleting an imported
+	// User does not remove the account it was imported from.
+	// +kubebuilder:default=Orphan
+	// +optional
+	DeletionPolicy DeletionPolicy `json:"deletionPolicy,omitempty"`
+}
+
+// ImportedUser is a User created by an IdentityImport
+type ImportedUser struct {
+	// Name of the created User
+	Name string `json:"name"`
+	// ID of the user in the identity system
+	ID string `json:"id"`
+}
+
+// IdentityImportStatus defines the observed state of IdentityImport
+type IdentityImportStatus struct {
+	// CompletionTime is the time the import finished
+	// +optional
+	CompletionTime *metav1.Time `json:"completionTime,omitempty"`
+	// ImportedCount is the number of Users created by the import
+	// +optional
+	ImportedCount int `json:"importedCount,omitempty"`
+	// Imported are the Users created by the import
+	// +optional
+	Imported []ImportedUser `json:"imported,omitempty"`
+	// Skipped are the names of external users that could not be imported, e.g. because
+	// a User with the same object name already exists
+	// +optional
+	Skipped []string `json:"skipped,omitempty"`
+
+	// Conditions represent the latest available observations of the IdentityImport's state
+	// +optional
+	// +listType=map
+	// +listMapKey=type
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+//+kubebuilder:subresource:status
+//+kubebuilder:resource:categories=idm
+//+kubebuilder:printcolumn:name="Imported",type=integer,JSONPath=`.status.importedCount`
+//+kubebuilder:printcolumn:name="Completed",type=date,JSONPath=`.status.completionTime`
+//+kubebuilder:printcolumn:name="Age",type=date,JSONPath=`.metadata.creationTimestamp`
+
+// IdentityImport creates a User, with the external ID already in its status, for every
+// existing account of an identity system that is not managed yet. It runs once per
+// generation, like a Job.
+type IdentityImport struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   IdentityImportSpec   `json:"spec,omitempty"`
+	Status IdentityImportStatus `json:"status,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+
+// IdentityImportList contains a list of IdentityImport
+type IdentityImportList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []IdentityImport `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&IdentityImport{}, &IdentityImportList{})
+}
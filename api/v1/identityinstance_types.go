@@ -0,0 +1,323 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// SecretReference references a Secret in a given namespace
+type SecretReference struct {
+	// Name of the Secret
+	Name string `json:"name"`
+	// Namespace of the Secret
+	Namespace string `json:"namespace"`
+}
+
+// NamespaceCredentialsSecret is the name of the Secret with IDM_USER and IDM_PASS keys, or
+// an IDM_TOKEN key, that objects of its namespace without an instanceRef log in with to the
+// operator-level identity system. Namespaces without it use the operator credentials.
+const NamespaceCredentialsSecret = "idm-credentials"
+
+// IdentityInstanceReference references a cluster-scoped IdentityInstance
+type IdentityInstanceReference struct {
+	// Name of the IdentityInstance
+	Name string `json:"name"`
+}
+
+// CABundleReference selects a key of a ConfigMap or Secret holding PEM encoded CA certificates
+type CABundleReference struct {
+	// Kind of the referenced object
+	// +kubebuilder:validation:Enum=ConfigMap;Secret
+	Kind string `json:"kind"`
+	// Name of the referenced object
+	Name string `json:"name"`
+	// Namespace of the referenced object
+	Namespace string `json:"namespace"`
+	// Key holding the CA certificates
+	// +kubebuilder:default=ca.crt
+	// +optional
+	Key string `json:"key,omitempty"`
+}
+
+// IdentityInstanceTLS configures HTTPS towards the identity system
+type IdentityInstanceTLS struct {
+	// Enabled switches the connection scheme from http to https
+	Enabled bool `json:"enabled,omitempty"`
+	// CABundleRef references the CA certificates used to verify the identity system.
+	// The system trust store is used when omitted.
+	// +optional
+	CABundleRef *CABundleReference `json:"caBundleRef,omitempty"`
+	// InsecureSkipVerify disables verification of the identity system certificate
+	// +optional
+	InsecureSkipVerify bool `json:"insecureSkipVerify,omitempty"`
+	// ClientCertificateSecretRef references a kubernetes.io/tls Secret whose tls.crt and
+	// tls.key are presented to the identity system for mutual TLS
+	// +optional
+	ClientCertificateSecretRef *SecretReference `json:"clientCertificateSecretRef,omitempty"`
+}
+
+// IdentityInstanceClientCredentials configures authentication with access tokens obtained
+// with the OAuth2 client credentials grant
+type IdentityInstanceClientCredentials struct {
+	// TokenURL is the token endpoint of the authorization server
+	// +kubebuilder:validation:Pattern=`^https?://`
+	TokenURL string `json:"tokenURL"`
+	// SecretRef references a Secret with IDM_CLIENT_ID and IDM_CLIENT_SECRET keys
+	SecretRef SecretReference `json:"secretRef"`
+	// Scopes requested for the access token
+	// +optional
+	Scopes []string `json:"scopes,omitempty"`
+}
+
+// IdentityInstanceProxy configures the forward proxy the identity system is reached through
+type IdentityInstanceProxy struct {
+	// URL of the proxy, e.g. http://proxy.example.com:3128
+	// +kubebuilder:validation:Pattern=`^https?://`
+	URL string `json:"url"`
+	// NoProxy lists the hosts, domains and CIDR ranges reached without the proxy, in the
+	// format of the NO_PROXY environment variable
+	// +optional
+	NoProxy []string `json:"noProxy,omitempty"`
+	// CredentialsSecretRef references a Secret with IDM_PROXY_USER and IDM_PROXY_PASS keys
+	// used to authenticate to the proxy
+	// +optional
+	CredentialsSecretRef *SecretReference `json:"credentialsSecretRef,omitempty"`
+}
+
+// IdentityInstanceConnection tunes the connections to the identity system, e.g. for
+// appliances limiting the connections per client
+type IdentityInstanceConnection struct {
+	// HTTP2 set to false sends the requests with HTTP/1.1 even when the identity system
+	// offers HTTP/2
+	// +optional
+	HTTP2 *bool `json:"http2,omitempty"`
+	// MaxConnsPerHost limits the connections to the identity system, including those in
+	// use; requests beyond the limit wait for a connection
+	// +kubebuilder:validation:Minimum=1
+	// +optional
+	MaxConnsPerHost int `json:"maxConnsPerHost,omitempty"`
+	// IdleConnTimeout is how long an idle connection is kept for reuse
+	// +optional
+	IdleConnTimeout *metav1.Duration `json:"idleConnTimeout,omitempty"`
+	// TLSSessionCacheSize is the number of TLS sessions kept for resumption, which saves
+	// the full handshake of new connections
+	// +kubebuilder:validation:Minimum=0
+	// +optional
+	TLSSessionCacheSize int `json:"tlsSessionCacheSize,omitempty"`
+}
+
+// IdentityInstanceType selects the API spoken by the identity system
+// +kubebuilder:validation:Enum=Native;SCIM;Keycloak;Okta;Graph;LDAP
+type IdentityInstanceType string
+
+const (
+	// IdentityInstanceTypeNative is the REST API of the identity app
+	IdentityInstanceTypeNative IdentityInstanceType = "Native"
+	// IdentityInstanceTypeSCIM is a SCIM 2.0 service provider
+	IdentityInstanceTypeSCIM IdentityInstanceType = "SCIM"
+	// IdentityInstanceTypeKeycloak is the admin REST API of Keycloak
+	IdentityInstanceTypeKeycloak IdentityInstanceType = "Keycloak"
+	// IdentityInstanceTypeOkta is the management API of Okta, authenticated with an API
+	// token given as IDM_TOKEN in the credentials Secret
+	IdentityInstanceTypeOkta IdentityInstanceType = "Okta"
+	// IdentityInstanceTypeGraph is Microsoft Graph managing a Microsoft Entra ID tenant,
+	// authenticated with clientCredentials of an app registration, e.g. with host
+	// graph.microsoft.com, port 443 and basePath /v1.0
+	IdentityInstanceTypeGraph IdentityInstanceType = "Graph"
+	// IdentityInstanceTypeLDAP is an LDAP directory such as OpenLDAP or Active Directory,
+	// bound to with the DN and password given as IDM_USER and IDM_PASS in the credentials
+	// Secret, e.g. with port 389, or 636 with tls for LDAPS
+	IdentityInstanceTypeLDAP IdentityInstanceType = "LDAP"
+)
+
+// IdentityInstanceLDAPSchema selects the entries users and groups are kept in
+// +kubebuilder:validation:Enum=InetOrgPerson;ActiveDirectory
+type IdentityInstanceLDAPSchema string
+
+const (
+	// IdentityInstanceLDAPSchemaInetOrgPerson keeps users in inetOrgPerson entries named by
+	// their uid and groups in groupOfNames entries, e.g. in OpenLDAP
+	IdentityInstanceLDAPSchemaInetOrgPerson IdentityInstanceLDAPSchema = "InetOrgPerson"
+	// IdentityInstanceLDAPSchemaActiveDirectory keeps users and groups in the user and group
+	// entries of Active Directory, which only accepts passwords over LDAPS
+	IdentityInstanceLDAPSchemaActiveDirectory IdentityInstanceLDAPSchema = "ActiveDirectory"
+)
+
+// IdentityInstanceLDAP locates the users and groups in an LDAP directory
+type IdentityInstanceLDAP struct {
+	// UsersDN is the DN of the entry users are created and searched below, e.g.
+	// ou=people,dc=example,dc=com
+	UsersDN string `json:"usersDN"`
+	// GroupsDN is the DN of the entry groups are created and searched below, the UsersDN
+	// when omitted
+	// +optional
+	GroupsDN string `json:"groupsDN,omitempty"`
+	// Schema of the entries of users and groups. Group membership is read back through the
+	// memberOf attribute of the users, which OpenLDAP maintains with the memberof overlay.
+	// +kubebuilder:default=InetOrgPerson
+	// +optional
+	Schema IdentityInstanceLDAPSchema `json:"schema,omitempty"`
+}
+
+// IdentityInstanceUpdateMethod selects how users are updated in the identity system
+// +kubebuilder:validation:Enum=Patch;Put
+type IdentityInstanceUpdateMethod string
+
+const (
+	// IdentityInstanceUpdateMethodPatch sends only the changed fields with PATCH
+	IdentityInstanceUpdateMethodPatch IdentityInstanceUpdateMethod = "Patch"
+	// IdentityInstanceUpdateMethodPut replaces the whole user with PUT, for identity
+	// systems without PATCH support
+	IdentityInstanceUpdateMethodPut IdentityInstanceUpdateMethod = "Put"
+)
+
+// ConditionBackendAvailable indicates the identity system was reachable at the latest probe
+const ConditionBackendAvailable = "BackendAvailable"
+
+// ConditionCredentialsInvalid indicates the identity system rejected the credentials the
+// operator logs in with; it is set on the IdentityInstance and on the affected Users
+const ConditionCredentialsInvalid = "CredentialsInvalid"
+
+// ConditionCircuitOpen indicates requests to the identity system fail fast because
+// too many consecutive requests failed
+const ConditionCircuitOpen = "CircuitOpen"
+
+// IdentityInstanceSpec defines the desired state of IdentityInstance
+// +kubebuilder:validation:XValidation:rule="!(has(self.credentialsSecretRef) && has(self.clientCredentials))",message="credentialsSecretRef and clientCredentials are mutually exclusive"
+// +kubebuilder:validation:XValidation:rule="!has(self.type) || self.type != 'LDAP' || has(self.ldap)",message="ldap is required for the LDAP type"
+type IdentityInstanceSpec struct {
+	// Type of the identity system
+	// +kubebuilder:default=Native
+	// +optional
+	Type IdentityInstanceType `json:"type,omitempty"`
+	// Host of the identity system
+	Host string `json:"host"`
+	// Port of the identity system
+	// +kubebuilder:default=8080
+	// +optional
+	Port int `json:"port,omitempty"`
+	// BasePath is prepended to the path of every request, e.g. /scim/v2
+	// +optional
+	BasePath string `json:"basePath,omitempty"`
+	// Realm managed in the identity system, required for Keycloak
+	// +optional
+	Realm string `json:"realm,omitempty"`
+	// LDAP locates the users and groups in the directory, required for LDAP
+	// +optional
+	LDAP *IdentityInstanceLDAP `json:"ldap,omitempty"`
+	// TLS configures HTTPS towards the identity system
+	// +optional
+	TLS *IdentityInstanceTLS `json:"tls,omitempty"`
+	// Proxy sends the requests to the identity system through a forward proxy. Without it,
+	// the HTTP_PROXY, HTTPS_PROXY and NO_PROXY environment variables of the operator apply.
+	// +optional
+	Proxy *IdentityInstanceProxy `json:"proxy,omitempty"`
+	// Connection tunes the connections to the identity system. Settings left out keep the
+	// ones of the operator, given by its IDM_* environment variables.
+	// +optional
+	Connection *IdentityInstanceConnection `json:"connection,omitempty"`
+	// CredentialsSecretRef references a Secret with IDM_USER and IDM_PASS keys
+	// used to log in to the identity system, or an IDM_TOKEN key holding a bearer token
+	// +optional
+	CredentialsSecretRef *SecretReference `json:"credentialsSecretRef,omitempty"`
+	// ClientCredentials authenticates with OAuth2 access tokens of a client instead of
+	// logging in with the credentials of a user
+	// +optional
+	ClientCredentials *IdentityInstanceClientCredentials `json:"clientCredentials,omitempty"`
+	// PasswordPolicyRef references the PasswordPolicy the passwords of the Users managed in
+	// the identity system must follow
+	// +optional
+	PasswordPolicyRef *PasswordPolicyReference `json:"passwordPolicyRef,omitempty"`
+	// AllowedRoles are the built-in roles of the identity system the Users managed in it
+	// may be assigned with role and roles. Any role is accepted when empty.
+	// +kubebuilder:validation:MaxItems=64
+	// +listType=set
+	// +optional
+	AllowedRoles []string `json:"allowedRoles,omitempty"`
+	// UpdateMethod selects whether users are updated with PATCH requests carrying only
+	// the drifted fields or with PUT requests replacing the whole user
+	// +kubebuilder:default=Patch
+	// +optional
+	UpdateMethod IdentityInstanceUpdateMethod `json:"updateMethod,omitempty"`
+	// AttributeMapping maps fields of the User spec to nonstandard attributes of the users
+	// in the identity system, as dot separated paths into their JSON representation, e.g.
+	// firstname: profile.given_name. Fields not mapped keep the attributes of the Type.
+	// +kubebuilder:validation:XValidation:rule="self.all(f, f in ['firstname', 'lastname', 'email', 'phone', 'displayName', 'age'])",message="only firstname, lastname, email, phone, displayName and age can be mapped"
+	// +optional
+	AttributeMapping map[string]string `json:"attributeMapping,omitempty"`
+}
+
+// IdentityInstanceCapabilities lists the optional operations supported by the identity system
+type IdentityInstanceCapabilities struct {
+	// SupportsPATCH reports whether users can be updated field by field instead of replaced
+	SupportsPATCH bool `json:"supportsPATCH"`
+	// SupportsGroups reports whether the identity system manages groups
+	SupportsGroups bool `json:"supportsGroups"`
+}
+
+// IdentityInstanceStatus defines the observed state of IdentityInstance
+type IdentityInstanceStatus struct {
+	// BackendVersion is the version reported by the identity system
+	// +optional
+	BackendVersion string `json:"backendVersion,omitempty"`
+
+	// LastHealthCheck is the time the identity system was last probed
+	// +optional
+	LastHealthCheck *metav1.Time `json:"lastHealthCheck,omitempty"`
+
+	// Capabilities of the identity system, the controllers of the objects managed in it
+	// choose their requests accordingly
+	// +optional
+	Capabilities *IdentityInstanceCapabilities `json:"capabilities,omitempty"`
+
+	// Conditions represent the latest available observations of the IdentityInstance's state
+	// +optional
+	// +listType=map
+	// +listMapKey=type
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+//+kubebuilder:subresource:status
+//+kubebuilder:resource:scope=Cluster,categories=idm
+//+kubebuilder:printcolumn:name="Type",type=string,JSONPath=`.spec.type`
+//+kubebuilder:printcolumn:name="Version",type=string,JSONPath=`.status.backendVersion`
+//+kubebuilder:printcolumn:name="Ready",type=string,JSONPath=`.status.conditions[?(@.type=="Ready")].status`
+//+kubebuilder:printcolumn:name="Last Check",type=date,JSONPath=`.status.lastHealthCheck`
+
+// IdentityInstance is the Schema for the identityinstances API
+type IdentityInstance struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   IdentityInstanceSpec   `json:"spec,omitempty"`
+	Status IdentityInstanceStatus `json:"status,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+
+// IdentityInstanceList contains a list of IdentityInstance
+type IdentityInstanceList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []IdentityInstance `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&IdentityInstance{}, &IdentityInstanceList{})
+}
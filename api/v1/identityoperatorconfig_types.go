@@ -0,0 +1,111 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// IdentityOperatorConfigSpec defines the desired state of IdentityOperatorConfig. Every
+// field overrides the flag of the same name while set, unset fields keep the flag value.
+type IdentityOperatorConfigSpec struct {
+	// DriftResyncPeriod is the interval after which synced objects are compared with the
+	// identity system again, 0 disables periodic resync
+	// +optional
+	DriftResyncPeriod *metav1.Duration `json:"driftResyncPeriod,omitempty"`
+
+	// DriftIgnoredAttributes are the custom attributes of users that are not compared with
+	// spec.attributes, e.g. attributes maintained by the identity system itself
+	// +listType=set
+	// +optional
+	DriftIgnoredAttributes []string `json:"driftIgnoredAttributes,omitempty"`
+
+	// ForceFinalizeAfter is the time after which the finalizer of a deleted User is removed
+	// even though deleting the external user keeps failing, 0 keeps the User until it succeeds
+	// +optional
+	ForceFinalizeAfter *metav1.Duration `json:"forceFinalizeAfter,omitempty"`
+
+	// RequeueBaseDelay is the initial delay of the exponential backoff applied to objects
+	// failing with retryable errors
+	// +optional
+	RequeueBaseDelay *metav1.Duration `json:"requeueBaseDelay,omitempty"`
+
+	// RequeueMaxDelay is the maximum delay of the exponential backoff
+	// +optional
+	RequeueMaxDelay *metav1.Duration `json:"requeueMaxDelay,omitempty"`
+
+	// RateLimiterQPS is the overall rate of retries per controller, in requeues per second
+	// +kubebuilder:validation:Minimum=1
+	// +optional
+	RateLimiterQPS *int32 `json:"rateLimiterQPS,omitempty"`
+
+	// RateLimiterBurst is the burst of retries per controller allowed on top of rateLimiterQPS
+	// +kubebuilder:validation:Minimum=1
+	// +optional
+	RateLimiterBurst *int32 `json:"rateLimiterBurst,omitempty"`
+
+	// DryRun records the writes to the identity systems as events instead of performing them
+	// +optional
+	DryRun *bool `json:"dryRun,omitempty"`
+
+	// DefaultInstanceRef references the IdentityInstance objects without an instanceRef are
+	// managed in, instead of the identity system configured by the environment of the operator
+	// +optional
+	DefaultInstanceRef *IdentityInstanceReference `json:"defaultInstanceRef,omitempty"`
+}
+
+// IdentityOperatorConfigStatus defines the observed state of IdentityOperatorConfig
+type IdentityOperatorConfigStatus struct {
+	// ObservedGeneration is the generation of the spec last applied by the operator
+	// +optional
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+
+	// Conditions represent the latest available observations of the IdentityOperatorConfig's state
+	// +optional
+	// +listType=map
+	// +listMapKey=type
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+//+kubebuilder:subresource:status
+//+kubebuilder:resource:scope=Cluster,categories=idm
+//+kubebuilder:printcolumn:name="Ready",type=string,JSONPath=`.status.conditions[?(@.type=="Ready")].status`
+//+kubebuilder:printcolumn:name="Age",type=date,JSONPath=`.metadata.creationTimestamp`
+
+// IdentityOperatorConfig is the Schema for the identityoperatorconfigs API. The object
+// named by the --operator-config flag tunes the running operator without a restart.
+type IdentityOperatorConfig struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   IdentityOperatorConfigSpec   `json:"spec,omitempty"`
+	Status IdentityOperatorConfigStatus `json:"status,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+
+// IdentityOperatorConfigList contains a list of IdentityOperatorConfig
+type IdentityOperatorConfigList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []IdentityOperatorConfig `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&IdentityOperatorConfig{}, &IdentityOperatorConfigList{})
+}
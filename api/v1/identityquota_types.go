@@ -0,0 +1,80 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// IdentityQuotaSpec defines the desired state of IdentityQuota
+type IdentityQuotaSpec struct {
+	// MaxUsers limits the number of Users in the namespace
+	// +kubebuilder:validation:Minimum=0
+	// +optional
+	MaxUsers *int32 `json:"maxUsers,omitempty"`
+
+	// MaxUsersPerRole limits the number of Users per role. Users are counted under their
+	// spec.role, or under the name of the Role they reference with spec.roleRef.
+	// +optional
+	MaxUsersPerRole map[string]int32 `json:"maxUsersPerRole,omitempty"`
+}
+
+// IdentityQuotaStatus defines the observed state of IdentityQuota
+type IdentityQuotaStatus struct {
+	// Users is the number of Users in the namespace
+	Users int32 `json:"users,omitempty"`
+
+	// UsersPerRole is the number of Users per role limited by the quota
+	// +optional
+	UsersPerRole map[string]int32 `json:"usersPerRole,omitempty"`
+
+	// Conditions represent the latest available observations of the IdentityQuota's state
+	// +optional
+	// +listType=map
+	// +listMapKey=type
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+//+kubebuilder:subresource:status
+//+kubebuilder:resource:categories=idm
+//+kubebuilder:printcolumn:name="Users",type=integer,JSONPath=`.status.users`
+//+kubebuilder:printcolumn:name="Max Users",type=integer,JSONPath=`.spec.maxUsers`
+//+kubebuilder:printcolumn:name="Ready",type=string,JSONPath=`.status.conditions[?(@.type=="Ready")].status`
+
+// IdentityQuota is the Schema for the identityquotas API. It limits the Users a
+// namespace may create, so a tenant cannot exhaust the seats of the identity system.
+type IdentityQuota struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   IdentityQuotaSpec   `json:"spec,omitempty"`
+	Status IdentityQuotaStatus `json:"status,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+
+// IdentityQuotaList contains a list of IdentityQuota
+type IdentityQuotaList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []IdentityQuota `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&IdentityQuota{}, &IdentityQuotaList{})
+}
@@ -0,0 +1,124 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// PasswordPolicyReference references a cluster-scoped PasswordPolicy
+type PasswordPolicyReference struct {
+	// Name of the PasswordPolicy
+	Name string `json:"name"`
+}
+
+// PasswordPolicySpec defines the rules passwords of the identity system must follow
+type PasswordPolicySpec struct {
+	// MinLength is the minimum number of characters
+	// +kubebuilder:validation:Minimum=1
+	// +kubebuilder:default=8
+	// +optional
+	MinLength int32 `json:"minLength,omitempty"`
+	// RequireUppercase requires at least one uppercase letter
+	// +optional
+	RequireUppercase bool `json:"requireUppercase,omitempty"`
+	// RequireLowercase requires at least one lowercase letter
+	// +optional
+	RequireLowercase bool `json:"requireLowercase,omitempty"`
+	// RequireDigits requires at least one digit
+	// +optional
+	RequireDigits bool `json:"requireDigits,omitempty"`
+	// RequireSymbols requires at least one character other than a letter or digit
+	// +optional
+	RequireSymbols bool `json:"requireSymbols,omitempty"`
+	// BannedWords must not occur in passwords, regardless of case
+	// +optional
+	BannedWords []string `json:"bannedWords,omitempty"`
+}
+
+// Violations returns the rules of the policy the password breaks, none if it complies
+func (s *PasswordPolicySpec) Violations(password string) []string {
+	var violations []string
+	if length := len([]rune(password)); length < int(s.MinLength) {
+		violations = append(violations, fmt.Sprintf("shorter than %d characters", s.MinLength))
+	}
+
+	var upper, lower, digit, symbol bool
+	for _, c := range password {
+		switch {
+		case unicode.IsUpper(c):
+			upper = true
+		case unicode.IsLower(c):
+			lower = true
+		case unicode.IsDigit(c):
+			digit = true
+		case !unicode.IsLetter(c):
+			symbol = true
+		}
+	}
+	if s.RequireUppercase && !upper {
+		violations = append(violations, "no uppercase letter")
+	}
+	if s.RequireLowercase && !lower {
+		violations = append(violations, "no lowercase letter")
+	}
+	if s.RequireDigits && !digit {
+		violations = append(violations, "no digit")
+	}
+	if s.RequireSymbols && !symbol {
+		violations = append(violations, "no symbol")
+	}
+
+	for _, word := range s.BannedWords {
+		if word != "" && strings.Contains(strings.ToLower(password), strings.ToLower(word)) {
+			violations = append(violations, fmt.Sprintf("contains banned word %q", word))
+		}
+	}
+	return violations
+}
+
+//+kubebuilder:object:root=true
+//+kubebuilder:resource:scope=Cluster,categories=idm
+//+kubebuilder:printcolumn:name="Min Length",type=integer,JSONPath=`.spec.minLength`
+//+kubebuilder:printcolumn:name="Age",type=date,JSONPath=`.metadata.creationTimestamp`
+
+// PasswordPolicy is the Schema for the passwordpolicies API. IdentityInstances reference it
+// to have the passwords of their Users checked at admission and before creation, and the
+// passwords generated for their Users follow it.
+type PasswordPolicy struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec PasswordPolicySpec `json:"spec,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+
+// PasswordPolicyList contains a list of PasswordPolicy
+type PasswordPolicyList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []PasswordPolicy `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&PasswordPolicy{}, &PasswordPolicyList{})
+}
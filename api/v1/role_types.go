@@ -0,0 +1,110 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// RoleReference references a Role in the namespace of the referencing object
+type RoleReference struct {
+	// Name of the Role
+	Name string `json:"name"`
+}
+
+// RoleSpec defines the desired state of Role
+type RoleSpec struct {
+	// Name of the role in the identity system
+	Name string `json:"name"`
+	// Description of the role
+	// +optional
+	Description string `json:"description,omitempty"`
+	// Permissions granted to users with the role
+	// +optional
+	Permissions []string `json:"permissions,omitempty"`
+
+	// InstanceRef references the IdentityInstance the role is managed in.
+	// When omitted the operator-level configuration is used.
+	// +optional
+	InstanceRef *IdentityInstanceReference `json:"instanceRef,omitempty"`
+
+	// Paused stops reconciliation, including deletion of the external role,
+	// e.g. during manual maintenance of the identity system
+	// +optional
+	Paused bool `json:"paused,omitempty"`
+}
+
+// RoleStatus defines the observed state of Role
+type RoleStatus struct {
+	// ID of the role in the identity system
+	ID string `json:"id,omitempty"`
+
+	// ObservedGeneration is the generation of the spec last synced to the identity system
+	// +optional
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+	// LastSyncTime is the time of the last successful sync with the identity system
+	// +optional
+	LastSyncTime *metav1.Time `json:"lastSyncTime,omitempty"`
+
+	// Conditions represent the latest available observations of the Role's state
+	// +optional
+	// +listType=map
+	// +listMapKey=type
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+//+kubebuilder:subresource:status
+//+kubebuilder:resource:categories=idm
+
+// Role is the Schema for the roles API
+type Role struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   RoleSpec   `json:"spec,omitempty"`
+	Status RoleStatus `json:"status,omitempty"`
+}
+
+// GetConditions returns the conditions of the Role status
+func (r *Role) GetConditions() []metav1.Condition {
+	return r.Status.Conditions
+}
+
+// SetConditions replaces the conditions of the Role status
+func (r *Role) SetConditions(conditions []metav1.Condition) {
+	r.Status.Conditions = conditions
+}
+
+// SetLastSync records the generation and time of the last successful sync of the Role
+func (r *Role) SetLastSync(generation int64, at metav1.Time) {
+	r.Status.ObservedGeneration = generation
+	r.Status.LastSyncTime = &at
+}
+
+//+kubebuilder:object:root=true
+
+// RoleList contains a list of Role
+type RoleList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []Role `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&Role{}, &RoleList{})
+}
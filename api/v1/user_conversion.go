@@ -0,0 +1,24 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+// AnnotationProfileAttributes kept the v2 profile attributes of a User as a JSON object
+// before v1 gained spec.attributes. It is still read when converting Users stored with it.
+const AnnotationProfileAttributes = "idm.micze.io/profile-attributes"
+
+// Hub marks this type as a conversion hub.
+func (*User) Hub() {}
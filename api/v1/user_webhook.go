@@ -0,0 +1,489 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/webhook"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+// UserNameIndex indexes Users by spec.name, to find the Users declaring the same user
+const UserNameIndex = "spec.name"
+
+// UserIdentity identifies the user a User declares in its identity system, the name of the
+// IdentityInstance and spec.name. Users without an instanceRef are managed in
+// defaultInstance, the default instance of the IdentityOperatorConfig, or in the
+// operator-level identity system when it is empty.
+func UserIdentity(user *User, defaultInstance string) string {
+	instance := defaultInstance
+	if user.Spec.InstanceRef != nil {
+		instance = user.Spec.InstanceRef.Name
+	}
+	return instance + "/" + user.Spec.Name
+}
+
+// indexUserName is the indexer of UserNameIndex
+func indexUserName(obj client.Object) []string {
+	if user := obj.(*User); user.Spec.Name != "" {
+		return []string{user.Spec.Name}
+	}
+	return nil
+}
+
+// SetupWebhookWithManager registers the conversion webhook serving all User versions
+// and the webhook validating Users against the IdentityQuotas of their namespace, the
+// PasswordPolicy and allowed roles of their IdentityInstance and the Users of the other
+// namespaces. operatorConfig is the name of the IdentityOperatorConfig in use, whose default
+// instance manages the Users without an instanceRef. allowedRoles are the built-in roles of
+// the operator-level identity system, any role is accepted when empty.
+func (r *User) SetupWebhookWithManager(mgr ctrl.Manager, operatorConfig string, allowedRoles []string) error {
+	err := mgr.GetFieldIndexer().IndexField(context.Background(), &User{}, UserNameIndex, indexUserName)
+	if err != nil {
+		return err
+	}
+
+	return ctrl.NewWebhookManagedBy(mgr).
+		For(r).
+		WithValidator(validators{
+			&userQuotaValidator{client: mgr.GetAPIReader()},
+			&userPasswordValidator{client: mgr.GetAPIReader()},
+			&userRoleValidator{client: mgr.GetAPIReader(), operatorConfig: operatorConfig, allowedRoles: allowedRoles},
+			&userNameValidator{client: mgr.GetClient(), operatorConfig: operatorConfig},
+		}).
+		Complete()
+}
+
+//+kubebuilder:webhook:path=/validate-idm-micze-io-v1-user,mutating=false,failurePolicy=fail,sideEffects=None,groups=idm.micze.io,resources=users,verbs=create;update,versions=v1,name=vuser.kb.io,admissionReviewVersions=v1
+
+// validators runs several validators of the same kind in order, as each kind has a single
+// validating webhook path. The first rejection wins, warnings are collected.
+type validators []webhook.CustomValidator
+
+var _ webhook.CustomValidator = validators{}
+
+func (vs validators) ValidateCreate(ctx context.Context, obj runtime.Object) (admission.Warnings, error) {
+	var warnings admission.Warnings
+	for _, v := range vs {
+		w, err := v.ValidateCreate(ctx, obj)
+		warnings = append(warnings, w...)
+		if err != nil {
+			return warnings, err
+		}
+	}
+	return warnings, nil
+}
+
+func (vs validators) ValidateUpdate(ctx context.Context, oldObj, newObj runtime.Object) (admission.Warnings, error) {
+	var warnings admission.Warnings
+	for _, v := range vs {
+		w, err := v.ValidateUpdate(ctx, oldObj, newObj)
+		warnings = append(warnings, w...)
+		if err != nil {
+			return warnings, err
+		}
+	}
+	return warnings, nil
+}
+
+func (vs validators) ValidateDelete(ctx context.Context, obj runtime.Object) (admission.Warnings, error) {
+	var warnings admission.Warnings
+	for _, v := range vs {
+		w, err := v.ValidateDelete(ctx, obj)
+		warnings = append(warnings, w...)
+		if err != nil {
+			return warnings, err
+		}
+	}
+	return warnings, nil
+}
+
+// userQuotaValidator rejects Users that would exceed an IdentityQuota of their namespace.
+// It reads from the API server, the cache may be restricted to a subset of the Users.
+type userQuotaValidator struct {
+	client client.Reader
+}
+
+var _ webhook.CustomValidator = &userQuotaValidator{}
+
+// QuotaRole returns the role a User is counted under by IdentityQuotas, the first of its roles
+func QuotaRole(user *User) string {
+	if user.Spec.RoleRef != nil {
+		return user.Spec.RoleRef.Name
+	}
+	if roles := user.Spec.AllRoles(); len(roles) > 0 {
+		return roles[0]
+	}
+	return ""
+}
+
+// ValidateCreate rejects the User when its namespace has no seat left, in total or for its role
+func (v *userQuotaValidator) ValidateCreate(ctx context.Context, obj runtime.Object) (admission.Warnings, error) {
+	user, ok := obj.(*User)
+	if !ok {
+		return nil, fmt.Errorf("expected a User but got %T", obj)
+	}
+	return nil, v.validate(ctx, user, true)
+}
+
+// ValidateUpdate rejects a change of role when the new role has no seat left
+func (v *userQuotaValidator) ValidateUpdate(ctx context.Context, oldObj, newObj runtime.Object) (admission.Warnings, error) {
+	oldUser, ok := oldObj.(*User)
+	if !ok {
+		return nil, fmt.Errorf("expected a User but got %T", oldObj)
+	}
+	user, ok := newObj.(*User)
+	if !ok {
+		return nil, fmt.Errorf("expected a User but got %T", newObj)
+	}
+	if QuotaRole(oldUser) == QuotaRole(user) {
+		return nil, nil
+	}
+	return nil, v.validate(ctx, user, false)
+}
+
+// ValidateDelete allows every deletion, it frees a seat
+func (v *userQuotaValidator) ValidateDelete(ctx context.Context, obj runtime.Object) (admission.Warnings, error) {
+	return nil, nil
+}
+
+// validate counts the other Users of the namespace against each IdentityQuota. The total
+// is only checked for new Users, a change of role keeps the number of Users. Cluster-scoped
+// Users belong to no namespace and are not limited by quotas.
+func (v *userQuotaValidator) validate(ctx context.Context, user *User, checkTotal bool) error {
+	if user.Namespace == "" {
+		return nil
+	}
+	quotas := &IdentityQuotaList{}
+	err := v.client.List(ctx, quotas, client.InNamespace(user.Namespace))
+	if err != nil {
+		return apierrors.NewInternalError(err)
+	}
+	if len(quotas.Items) == 0 {
+		return nil
+	}
+
+	users := &UserList{}
+	err = v.client.List(ctx, users, client.InNamespace(user.Namespace))
+	if err != nil {
+		return apierrors.NewInternalError(err)
+	}
+	role := QuotaRole(user)
+	var total, withRole int32
+	for i := range users.Items {
+		if users.Items[i].Name == user.Name {
+			continue
+		}
+		total++
+		if QuotaRole(&users.Items[i]) == role {
+			withRole++
+		}
+	}
+
+	for _, quota := range quotas.Items {
+		if limit := quota.Spec.MaxUsers; checkTotal && limit != nil && total >= *limit {
+			return apierrors.NewForbidden(GroupVersion.WithResource("users").GroupResource(), user.Name,
+				fmt.Errorf("exceeded quota %s: %d of %d users in use", quota.Name, total, *limit))
+		}
+		if limit, ok := quota.Spec.MaxUsersPerRole[role]; ok && withRole >= limit {
+			return apierrors.NewForbidden(GroupVersion.WithResource("users").GroupResource(), user.Name,
+				fmt.Errorf("exceeded quota %s: %d of %d users with role %s in use", quota.Name, withRole, limit, role))
+		}
+	}
+	return nil
+}
+
+// userPasswordValidator rejects Users whose password breaks the PasswordPolicy of the
+// IdentityInstance referenced by their instanceRef. Passwords in Secrets that do not exist
+// yet are checked by the controller before the user is created.
+type userPasswordValidator struct {
+	client client.Reader
+}
+
+var _ webhook.CustomValidator = &userPasswordValidator{}
+
+// ValidateCreate rejects the User when its password breaks the policy
+func (v *userPasswordValidator) ValidateCreate(ctx context.Context, obj runtime.Object) (admission.Warnings, error) {
+	user, ok := obj.(*User)
+	if !ok {
+		return nil, fmt.Errorf("expected a User but got %T", obj)
+	}
+	return nil, v.validate(ctx, user)
+}
+
+// ValidateUpdate rejects a change of the password or instance that breaks the policy
+func (v *userPasswordValidator) ValidateUpdate(ctx context.Context, oldObj, newObj runtime.Object) (admission.Warnings, error) {
+	oldUser, ok := oldObj.(*User)
+	if !ok {
+		return nil, fmt.Errorf("expected a User but got %T", oldObj)
+	}
+	user, ok := newObj.(*User)
+	if !ok {
+		return nil, fmt.Errorf("expected a User but got %T", newObj)
+	}
+	if oldUser.Spec.Password == user.Spec.Password &&
+		equality.Semantic.DeepEqual(oldUser.Spec.PasswordSecretRef, user.Spec.PasswordSecretRef) &&
+		equality.Semantic.DeepEqual(oldUser.Spec.InstanceRef, user.Spec.InstanceRef) {
+		return nil, nil
+	}
+	return nil, v.validate(ctx, user)
+}
+
+// ValidateDelete allows every deletion
+func (v *userPasswordValidator) ValidateDelete(ctx context.Context, obj runtime.Object) (admission.Warnings, error) {
+	return nil, nil
+}
+
+// validate checks the plaintext or referenced password of the user against the policy
+func (v *userPasswordValidator) validate(ctx context.Context, user *User) error {
+	if user.Spec.InstanceRef == nil {
+		return nil
+	}
+	instance := &IdentityInstance{}
+	err := v.client.Get(ctx, types.NamespacedName{Name: user.Spec.InstanceRef.Name}, instance)
+	if apierrors.IsNotFound(err) || (err == nil && instance.Spec.PasswordPolicyRef == nil) {
+		return nil
+	}
+	if err != nil {
+		return apierrors.NewInternalError(err)
+	}
+	policy := &PasswordPolicy{}
+	err = v.client.Get(ctx, types.NamespacedName{Name: instance.Spec.PasswordPolicyRef.Name}, policy)
+	if apierrors.IsNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		return apierrors.NewInternalError(err)
+	}
+
+	password := user.Spec.Password
+	if ref := user.Spec.PasswordSecretRef; ref != nil {
+		secret := &corev1.Secret{}
+		err = v.client.Get(ctx, types.NamespacedName{Namespace: user.Namespace, Name: ref.Name}, secret)
+		if apierrors.IsNotFound(err) {
+			return nil
+		}
+		if err != nil {
+			return apierrors.NewInternalError(err)
+		}
+		password = string(secret.Data[ref.Key])
+	}
+	// encrypted passwords are checked by the controller once decrypted
+	if password == "" || strings.HasPrefix(password, EncryptedValuePrefix) {
+		return nil
+	}
+
+	if violations := policy.Spec.Violations(password); len(violations) > 0 {
+		return apierrors.NewForbidden(GroupVersion.WithResource("users").GroupResource(), user.Name,
+			fmt.Errorf("password breaks policy %s: %s", policy.Name, strings.Join(violations, ", ")))
+	}
+	return nil
+}
+
+// userRoleValidator rejects Users assigned built-in roles their identity system does not
+// know, as the roles differ between the kinds of identity systems. The roles of Users in an
+// IdentityInstance are checked against its allowedRoles, the others against those of the
+// operator-level identity system.
+type userRoleValidator struct {
+	client client.Reader
+	// operatorConfig is the name of the IdentityOperatorConfig in use
+	operatorConfig string
+	// allowedRoles are the built-in roles of the operator-level identity system
+	allowedRoles []string
+}
+
+var _ webhook.CustomValidator = &userRoleValidator{}
+
+// ValidateCreate rejects the User when one of its roles is not allowed
+func (v *userRoleValidator) ValidateCreate(ctx context.Context, obj runtime.Object) (admission.Warnings, error) {
+	user, ok := obj.(*User)
+	if !ok {
+		return nil, fmt.Errorf("expected a User but got %T", obj)
+	}
+	return nil, v.validate(ctx, user)
+}
+
+// ValidateUpdate rejects a change of the roles or instance to roles that are not allowed
+func (v *userRoleValidator) ValidateUpdate(ctx context.Context, oldObj, newObj runtime.Object) (admission.Warnings, error) {
+	oldUser, ok := oldObj.(*User)
+	if !ok {
+		return nil, fmt.Errorf("expected a User but got %T", oldObj)
+	}
+	user, ok := newObj.(*User)
+	if !ok {
+		return nil, fmt.Errorf("expected a User but got %T", newObj)
+	}
+	if equality.Semantic.DeepEqual(oldUser.Spec.AllRoles(), user.Spec.AllRoles()) &&
+		equality.Semantic.DeepEqual(oldUser.Spec.InstanceRef, user.Spec.InstanceRef) {
+		return nil, nil
+	}
+	return nil, v.validate(ctx, user)
+}
+
+// ValidateDelete allows every deletion
+func (v *userRoleValidator) ValidateDelete(ctx context.Context, obj runtime.Object) (admission.Warnings, error) {
+	return nil, nil
+}
+
+// validate checks the roles of the user against the allowed roles of its identity system
+func (v *userRoleValidator) validate(ctx context.Context, user *User) error {
+	roles := user.Spec.AllRoles()
+	if len(roles) == 0 {
+		return nil
+	}
+	instanceName, err := operatorDefaultInstance(ctx, v.client, v.operatorConfig)
+	if err != nil {
+		return apierrors.NewInternalError(err)
+	}
+	if user.Spec.InstanceRef != nil {
+		instanceName = user.Spec.InstanceRef.Name
+	}
+
+	allowed, system := v.allowedRoles, "the operator-level identity system"
+	if instanceName != "" {
+		instance := &IdentityInstance{}
+		err := v.client.Get(ctx, types.NamespacedName{Name: instanceName}, instance)
+		if apierrors.IsNotFound(err) {
+			return nil
+		}
+		if err != nil {
+			return apierrors.NewInternalError(err)
+		}
+		allowed, system = instance.Spec.AllowedRoles, "IdentityInstance "+instanceName
+	}
+	if len(allowed) == 0 {
+		return nil
+	}
+
+	known := map[string]bool{}
+	for _, role := range allowed {
+		known[role] = true
+	}
+	for _, role := range roles {
+		if !known[role] {
+			return apierrors.NewForbidden(GroupVersion.WithResource("users").GroupResource(), user.Name,
+				fmt.Errorf("role %s is not allowed by %s, allowed roles are %s", role, system, strings.Join(allowed, ", ")))
+		}
+	}
+	return nil
+}
+
+// userNameValidator rejects Users declaring the same user of an identity system as another
+// User, in any namespace, which the controllers would otherwise overwrite in turn. The check
+// is best-effort: it reads the Users watched by the operator from the cache, by
+// UserNameIndex, so two Users created at the same time or before the cache caught up both
+// pass, and the controllers still report the conflict on the second one.
+type userNameValidator struct {
+	client client.Reader
+	// operatorConfig is the name of the IdentityOperatorConfig in use
+	operatorConfig string
+}
+
+var _ webhook.CustomValidator = &userNameValidator{}
+
+// ValidateCreate rejects the User when another User declares its user
+func (v *userNameValidator) ValidateCreate(ctx context.Context, obj runtime.Object) (admission.Warnings, error) {
+	user, ok := obj.(*User)
+	if !ok {
+		return nil, fmt.Errorf("expected a User but got %T", obj)
+	}
+	return nil, v.validate(ctx, user)
+}
+
+// ValidateUpdate rejects a change of the name or instance to a user declared by another User
+func (v *userNameValidator) ValidateUpdate(ctx context.Context, oldObj, newObj runtime.Object) (admission.Warnings, error) {
+	oldUser, ok := oldObj.(*User)
+	if !ok {
+		return nil, fmt.Errorf("expected a User but got %T", oldObj)
+	}
+	user, ok := newObj.(*User)
+	if !ok {
+		return nil, fmt.Errorf("expected a User but got %T", newObj)
+	}
+	if UserIdentity(oldUser, "") == UserIdentity(user, "") {
+		return nil, nil
+	}
+	return nil, v.validate(ctx, user)
+}
+
+// ValidateDelete allows every deletion
+func (v *userNameValidator) ValidateDelete(ctx context.Context, obj runtime.Object) (admission.Warnings, error) {
+	return nil, nil
+}
+
+// validate looks up the other Users with the name of the user and compares their identities,
+// with the default instance resolved for all of them
+func (v *userNameValidator) validate(ctx context.Context, user *User) error {
+	if user.Spec.Name == "" {
+		return nil
+	}
+	defaultInstance, err := operatorDefaultInstance(ctx, v.client, v.operatorConfig)
+	if err != nil {
+		return apierrors.NewInternalError(err)
+	}
+	users := &UserList{}
+	err = v.client.List(ctx, users, client.MatchingFields{UserNameIndex: user.Spec.Name})
+	if err != nil {
+		return apierrors.NewInternalError(err)
+	}
+	identity := UserIdentity(user, defaultInstance)
+	for _, other := range users.Items {
+		if other.Namespace == user.Namespace && other.Name == user.Name {
+			continue
+		}
+		if UserIdentity(&other, defaultInstance) != identity {
+			continue
+		}
+		instance := "the operator-level identity system"
+		if name, _, _ := strings.Cut(identity, "/"); name != "" {
+			instance = "IdentityInstance " + name
+		}
+		return apierrors.NewForbidden(GroupVersion.WithResource("users").GroupResource(), user.Name,
+			fmt.Errorf("user %s of %s is already declared by User %s", user.Spec.Name, instance, client.ObjectKeyFromObject(&other)))
+	}
+	return nil
+}
+
+// operatorDefaultInstance returns the name of the default instance of the
+// IdentityOperatorConfig with the given name, empty when there is none
+func operatorDefaultInstance(ctx context.Context, reader client.Reader, operatorConfig string) (string, error) {
+	if operatorConfig == "" {
+		return "", nil
+	}
+	config := &IdentityOperatorConfig{}
+	err := reader.Get(ctx, types.NamespacedName{Name: operatorConfig}, config)
+	if apierrors.IsNotFound(err) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	if ref := config.Spec.DefaultInstanceRef; ref != nil {
+		return ref.Name, nil
+	}
+	return "", nil
+}
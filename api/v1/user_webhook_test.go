@@ -0,0 +1,295 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	"context"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+// newScheme returns a scheme with the types of this package and Secrets
+func newScheme() *runtime.Scheme {
+	scheme := runtime.NewScheme()
+	Expect(AddToScheme(scheme)).To(Succeed())
+	Expect(corev1.AddToScheme(scheme)).To(Succeed())
+	return scheme
+}
+
+// newUser returns a User declaring the user name in the instance, the operator-level
+// identity system when instance is empty
+func newUser(namespace, name, userName, instance string) *User {
+	user := &User{
+		ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: name},
+		Spec:       UserSpec{Name: userName},
+	}
+	if instance != "" {
+		user.Spec.InstanceRef = &IdentityInstanceReference{Name: instance}
+	}
+	return user
+}
+
+var _ = Describe("User name validator", func() {
+	ctx := context.Background()
+
+	newValidator := func(objs ...client.Object) *userNameValidator {
+		c := fake.NewClientBuilder().
+			WithScheme(newScheme()).
+			WithObjects(objs...).
+			WithIndex(&User{}, UserNameIndex, indexUserName).
+			Build()
+		return &userNameValidator{client: c, operatorConfig: "default"}
+	}
+
+	It("rejects a User declaring the user of another namespace", func() {
+		v := newValidator(newUser("team-a", "jackr", "jackr", "keycloak"))
+
+		_, err := v.ValidateCreate(ctx, newUser("team-b", "jackr", "jackr", "keycloak"))
+		Expect(apierrors.IsForbidden(err)).To(BeTrue())
+		Expect(err.Error()).To(ContainSubstring("IdentityInstance keycloak"))
+		Expect(err.Error()).To(ContainSubstring("team-a/jackr"))
+	})
+
+	It("accepts the same user name in another instance", func() {
+		v := newValidator(newUser("team-a", "jackr", "jackr", "keycloak"))
+
+		_, err := v.ValidateCreate(ctx, newUser("team-b", "jackr", "jackr", "okta"))
+		Expect(err).NotTo(HaveOccurred())
+		_, err = v.ValidateCreate(ctx, newUser("team-b", "jackr", "jackr", ""))
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	It("does not reject the User itself", func() {
+		user := newUser("team-a", "jackr", "jackr", "")
+		v := newValidator(user)
+
+		_, err := v.ValidateCreate(ctx, user)
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	It("resolves the default instance for Users without an instanceRef", func() {
+		config := &IdentityOperatorConfig{
+			ObjectMeta: metav1.ObjectMeta{Name: "default"},
+			Spec: IdentityOperatorConfigSpec{
+				DefaultInstanceRef: &IdentityInstanceReference{Name: "keycloak"},
+			},
+		}
+		v := newValidator(config, newUser("team-a", "jackr", "jackr", "keycloak"))
+
+		_, err := v.ValidateCreate(ctx, newUser("team-b", "jackr", "jackr", ""))
+		Expect(apierrors.IsForbidden(err)).To(BeTrue())
+		Expect(err.Error()).To(ContainSubstring("IdentityInstance keycloak"))
+
+		v = newValidator(config, newUser("team-a", "jackr", "jackr", ""))
+		_, err = v.ValidateCreate(ctx, newUser("team-b", "jackr", "jackr", "keycloak"))
+		Expect(apierrors.IsForbidden(err)).To(BeTrue())
+	})
+
+	It("only validates updates changing the user or instance", func() {
+		v := newValidator(newUser("team-a", "jackr", "jackr", ""), newUser("team-b", "janer", "janer", ""))
+
+		old := newUser("team-b", "janer", "janer", "")
+		renamed := newUser("team-b", "janer", "jackr", "")
+		_, err := v.ValidateUpdate(ctx, old, renamed)
+		Expect(apierrors.IsForbidden(err)).To(BeTrue())
+
+		relabeled := old.DeepCopy()
+		relabeled.Labels = map[string]string{"team": "b"}
+		_, err = v.ValidateUpdate(ctx, old, relabeled)
+		Expect(err).NotTo(HaveOccurred())
+	})
+})
+
+var _ = Describe("User role validator", func() {
+	ctx := context.Background()
+
+	newValidator := func(objs ...client.Object) *userRoleValidator {
+		return &userRoleValidator{
+			client:         fake.NewClientBuilder().WithScheme(newScheme()).WithObjects(objs...).Build(),
+			operatorConfig: "default",
+			allowedRoles:   []string{"admin", "user", "tester"},
+		}
+	}
+
+	withRoles := func(user *User, roles ...string) *User {
+		user.Spec.Role, user.Spec.Roles = roles[0], roles[1:]
+		return user
+	}
+
+	It("rejects roles the operator-level identity system does not know", func() {
+		v := newValidator()
+
+		_, err := v.ValidateCreate(ctx, withRoles(newUser("team-a", "jackr", "jackr", ""), "admin", "tester"))
+		Expect(err).NotTo(HaveOccurred())
+		_, err = v.ValidateCreate(ctx, withRoles(newUser("team-a", "jackr", "jackr", ""), "user", "realm-admin"))
+		Expect(apierrors.IsForbidden(err)).To(BeTrue())
+		Expect(err.Error()).To(ContainSubstring("role realm-admin is not allowed by the operator-level identity system"))
+	})
+
+	It("checks the roles against the allowed roles of the IdentityInstance", func() {
+		keycloak := &IdentityInstance{
+			ObjectMeta: metav1.ObjectMeta{Name: "keycloak"},
+			Spec:       IdentityInstanceSpec{AllowedRoles: []string{"realm-admin", "offline_access"}},
+		}
+		okta := &IdentityInstance{ObjectMeta: metav1.ObjectMeta{Name: "okta"}}
+		v := newValidator(keycloak, okta)
+
+		_, err := v.ValidateCreate(ctx, withRoles(newUser("team-a", "jackr", "jackr", "keycloak"), "realm-admin"))
+		Expect(err).NotTo(HaveOccurred())
+		_, err = v.ValidateCreate(ctx, withRoles(newUser("team-a", "jackr", "jackr", "keycloak"), "admin"))
+		Expect(apierrors.IsForbidden(err)).To(BeTrue())
+		Expect(err.Error()).To(ContainSubstring("IdentityInstance keycloak"))
+
+		By("accepting any role of instances without allowed roles")
+		_, err = v.ValidateCreate(ctx, withRoles(newUser("team-a", "jackr", "jackr", "okta"), "SUPER_ADMIN"))
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	It("resolves the default instance for Users without an instanceRef", func() {
+		config := &IdentityOperatorConfig{
+			ObjectMeta: metav1.ObjectMeta{Name: "default"},
+			Spec: IdentityOperatorConfigSpec{
+				DefaultInstanceRef: &IdentityInstanceReference{Name: "keycloak"},
+			},
+		}
+		keycloak := &IdentityInstance{
+			ObjectMeta: metav1.ObjectMeta{Name: "keycloak"},
+			Spec:       IdentityInstanceSpec{AllowedRoles: []string{"realm-admin"}},
+		}
+		v := newValidator(config, keycloak)
+
+		_, err := v.ValidateCreate(ctx, withRoles(newUser("team-a", "jackr", "jackr", ""), "realm-admin"))
+		Expect(err).NotTo(HaveOccurred())
+		_, err = v.ValidateCreate(ctx, withRoles(newUser("team-a", "jackr", "jackr", ""), "admin"))
+		Expect(apierrors.IsForbidden(err)).To(BeTrue())
+	})
+
+	It("only validates updates changing the roles or instance", func() {
+		v := newValidator()
+
+		old := withRoles(newUser("team-a", "jackr", "jackr", ""), "realm-admin")
+		relabeled := old.DeepCopy()
+		relabeled.Labels = map[string]string{"team": "a"}
+		_, err := v.ValidateUpdate(ctx, old, relabeled)
+		Expect(err).NotTo(HaveOccurred())
+
+		promoted := withRoles(old.DeepCopy(), "realm-admin", "owner")
+		_, err = v.ValidateUpdate(ctx, old, promoted)
+		Expect(apierrors.IsForbidden(err)).To(BeTrue())
+	})
+})
+
+var _ = Describe("User quota validator", func() {
+	ctx := context.Background()
+
+	newValidator := func(objs ...client.Object) *userQuotaValidator {
+		return &userQuotaValidator{client: fake.NewClientBuilder().WithScheme(newScheme()).WithObjects(objs...).Build()}
+	}
+	maxUsers := int32(1)
+	quota := &IdentityQuota{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "team-a", Name: "quota"},
+		Spec:       IdentityQuotaSpec{MaxUsers: &maxUsers, MaxUsersPerRole: map[string]int32{"admin": 1}},
+	}
+
+	It("rejects Users beyond the total of the namespace", func() {
+		v := newValidator(quota, newUser("team-a", "jackr", "jackr", ""))
+
+		_, err := v.ValidateCreate(ctx, newUser("team-a", "janer", "janer", ""))
+		Expect(apierrors.IsForbidden(err)).To(BeTrue())
+		Expect(err.Error()).To(ContainSubstring("1 of 1 users"))
+		_, err = v.ValidateCreate(ctx, newUser("team-b", "janer", "janer", ""))
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	It("rejects a change to a role without seats left", func() {
+		admin := newUser("team-a", "jackr", "jackr", "")
+		admin.Spec.Role = "admin"
+		v := newValidator(quota, admin)
+
+		user := newUser("team-a", "jackr", "jackr", "")
+		_, err := v.ValidateUpdate(ctx, admin, user)
+		Expect(err).NotTo(HaveOccurred())
+
+		other := newUser("team-a", "janer", "janer", "")
+		promoted := other.DeepCopy()
+		promoted.Spec.Role = "admin"
+		_, err = v.ValidateUpdate(ctx, other, promoted)
+		Expect(apierrors.IsForbidden(err)).To(BeTrue())
+		Expect(err.Error()).To(ContainSubstring("with role admin"))
+	})
+})
+
+var _ = Describe("User password validator", func() {
+	ctx := context.Background()
+
+	var v *userPasswordValidator
+
+	BeforeEach(func() {
+		policy := &PasswordPolicy{
+			ObjectMeta: metav1.ObjectMeta{Name: "strict"},
+			Spec:       PasswordPolicySpec{MinLength: 12, RequireDigits: true},
+		}
+		instance := &IdentityInstance{
+			ObjectMeta: metav1.ObjectMeta{Name: "keycloak"},
+			Spec:       IdentityInstanceSpec{Host: "keycloak.example.com", PasswordPolicyRef: &PasswordPolicyReference{Name: policy.Name}},
+		}
+		secret := &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "team-a", Name: "password"},
+			Data:       map[string][]byte{"password": []byte("short")},
+		}
+		v = &userPasswordValidator{client: fake.NewClientBuilder().WithScheme(newScheme()).WithObjects(policy, instance, secret).Build()}
+	})
+
+	It("rejects passwords breaking the policy of the instance", func() {
+		user := newUser("team-a", "jackr", "jackr", "keycloak")
+		user.Spec.Password = "short"
+		_, err := v.ValidateCreate(ctx, user)
+		Expect(apierrors.IsForbidden(err)).To(BeTrue())
+		Expect(err.Error()).To(ContainSubstring("policy strict"))
+
+		user.Spec.Password = "long enough 1"
+		_, err = v.ValidateCreate(ctx, user)
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	It("checks passwords referenced from Secrets", func() {
+		user := newUser("team-a", "jackr", "jackr", "keycloak")
+		user.Spec.PasswordSecretRef = &SecretKeyReference{Name: "password", Key: "password"}
+		_, err := v.ValidateCreate(ctx, user)
+		Expect(apierrors.IsForbidden(err)).To(BeTrue())
+	})
+
+	It("leaves encrypted passwords and Users without an instance to the controller", func() {
+		user := newUser("team-a", "jackr", "jackr", "keycloak")
+		user.Spec.Password = EncryptedValuePrefix + "secret:key:wrapped:sealed"
+		_, err := v.ValidateCreate(ctx, user)
+		Expect(err).NotTo(HaveOccurred())
+
+		user = newUser("team-a", "jackr", "jackr", "")
+		user.Spec.Password = "short"
+		_, err = v.ValidateCreate(ctx, user)
+		Expect(err).NotTo(HaveOccurred())
+	})
+})
@@ -0,0 +1,116 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// BoundRole is a role assigned to the user of a UserRoleBinding
+// +kubebuilder:validation:XValidation:rule="has(self.name) != has(self.roleRef)",message="exactly one of name and roleRef must be set"
+type BoundRole struct {
+	// Name of the role in the identity system
+	// +optional
+	Name string `json:"name,omitempty"`
+	// RoleRef references a managed Role whose name is assigned
+	// +optional
+	RoleRef *RoleReference `json:"roleRef,omitempty"`
+	// Scope restricts the assignment, e.g. to the client of a Keycloak client role or to the
+	// type of a SCIM role. With the apps scope, Okta assigns the user to the application
+	// whose ID is the role name, Graph assigns the directory role in the directory scope,
+	// e.g. /administrativeUnits/<id>. The role is assigned without restriction when omitted.
+	// +optional
+	Scope string `json:"scope,omitempty"`
+}
+
+// AssignedRole is a role assigned in the identity system
+type AssignedRole struct {
+	// Name of the role in the identity system
+	Name string `json:"name"`
+	// Scope of the assignment
+	// +optional
+	Scope string `json:"scope,omitempty"`
+}
+
+// UserRoleBindingSpec defines the desired state of UserRoleBinding
+type UserRoleBindingSpec struct {
+	// UserRef references the User the roles are assigned to
+	UserRef UserReference `json:"userRef"`
+	// Roles assigned to the user
+	// +optional
+	Roles []BoundRole `json:"roles,omitempty"`
+
+	// Paused stops reconciliation, including removal of the roles assigned by the binding,
+	// e.g. during manual maintenance of the identity system
+	// +optional
+	Paused bool `json:"paused,omitempty"`
+}
+
+// UserRoleBindingStatus defines the observed state of UserRoleBinding
+type UserRoleBindingStatus struct {
+	// Assigned are the roles assigned to the user by this binding. Only these are removed
+	// again, roles assigned by other means are left untouched.
+	// +optional
+	Assigned []AssignedRole `json:"assigned,omitempty"`
+
+	// Conditions represent the latest available observations of the UserRoleBinding's state
+	// +optional
+	// +listType=map
+	// +listMapKey=type
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+//+kubebuilder:subresource:status
+//+kubebuilder:resource:categories=idm
+//+kubebuilder:printcolumn:name="User",type=string,JSONPath=`.spec.userRef.name`
+//+kubebuilder:printcolumn:name="Ready",type=string,JSONPath=`.status.conditions[?(@.type=="Ready")].status`
+
+// UserRoleBinding is the Schema for the userrolebindings API. It assigns any number of
+// roles to a User, so several bindings, e.g. one per team, can grant roles to the same
+// User. Users whose roles are bound should leave spec.role empty, an empty role leaves
+// the roles of the external user to the bindings.
+type UserRoleBinding struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   UserRoleBindingSpec   `json:"spec,omitempty"`
+	Status UserRoleBindingStatus `json:"status,omitempty"`
+}
+
+// GetConditions returns the conditions of the UserRoleBinding status
+func (b *UserRoleBinding) GetConditions() []metav1.Condition {
+	return b.Status.Conditions
+}
+
+// SetConditions replaces the conditions of the UserRoleBinding status
+func (b *UserRoleBinding) SetConditions(conditions []metav1.Condition) {
+	b.Status.Conditions = conditions
+}
+
+//+kubebuilder:object:root=true
+
+// UserRoleBindingList contains a list of UserRoleBinding
+type UserRoleBindingList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []UserRoleBinding `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&UserRoleBinding{}, &UserRoleBindingList{})
+}
@@ -0,0 +1,146 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// LabelUserTemplate is set on Users stamped out by a UserTemplate to the name of the template
+const LabelUserTemplate = "idm.micze.io/user-template"
+
+// TemplateNamePlaceholder in the Secret names of a UserTemplate is replaced by the name of the identity
+const TemplateNamePlaceholder = "{name}"
+
+// UserTemplateIdentity is an identity stamped out as a User. Its fields override the template.
+type UserTemplateIdentity struct {
+	// Name of the user in the identity system
+	Name string `json:"name"`
+	// Firstname of the user
+	// +optional
+	Firstname string `json:"firstname,omitempty"`
+	// Lastname of the user
+	// +optional
+	Lastname string `json:"lastname,omitempty"`
+	// Email address of the user
+	// +kubebuilder:validation:Format=email
+	// +optional
+	Email string `json:"email,omitempty"`
+	// DisplayName shown for the user in the identity system
+	// +optional
+	DisplayName string `json:"displayName,omitempty"`
+}
+
+// UserGenerator generates identities from a naming pattern
+type UserGenerator struct {
+	// Count of generated identities
+	// +kubebuilder:validation:Minimum=0
+	Count int `json:"count"`
+	// NamePattern of the generated identities, {index} is replaced by the index, e.g. load-test-{index}
+	// +kubebuilder:validation:Pattern=`\{index\}`
+	NamePattern string `json:"namePattern"`
+	// StartIndex is the index of the first identity
+	// +kubebuilder:default=1
+	// +optional
+	StartIndex int `json:"startIndex,omitempty"`
+}
+
+// ConfigMapKeyReference selects a key of a ConfigMap in the namespace of the referencing object
+type ConfigMapKeyReference struct {
+	// Name of the ConfigMap
+	Name string `json:"name"`
+	// Key within the ConfigMap
+	Key string `json:"key"`
+}
+
+// UserTemplateUser describes the Users stamped out by a UserTemplate
+type UserTemplateUser struct {
+	// Labels added to the Users
+	// +optional
+	Labels map[string]string `json:"labels,omitempty"`
+	// Annotations added to the Users
+	// +optional
+	Annotations map[string]string `json:"annotations,omitempty"`
+	// Spec of the Users. The name is taken from the identity, {name} in the names of the
+	// password Secrets is replaced by the name of the identity.
+	Spec UserSpec `json:"spec"`
+}
+
+// UserTemplateSpec defines the desired state of UserTemplate
+type UserTemplateSpec struct {
+	// Template of the Users
+	Template UserTemplateUser `json:"template"`
+
+	// Identities lists the identities explicitly
+	// +optional
+	Identities []UserTemplateIdentity `json:"identities,omitempty"`
+	// IdentitiesFrom selects a ConfigMap key holding one identity name per line,
+	// empty lines and lines starting with # are ignored
+	// +optional
+	IdentitiesFrom *ConfigMapKeyReference `json:"identitiesFrom,omitempty"`
+	// Generator generates identities from a naming pattern
+	// +optional
+	Generator *UserGenerator `json:"generator,omitempty"`
+
+	// Paused stops stamping out, updating and deleting the Users
+	// +optional
+	Paused bool `json:"paused,omitempty"`
+}
+
+// UserTemplateStatus defines the observed state of UserTemplate
+type UserTemplateStatus struct {
+	// Users is the number of Users stamped out by the template
+	// +optional
+	Users int `json:"users,omitempty"`
+	// ReadyUsers is the number of those Users that are ready
+	// +optional
+	ReadyUsers int `json:"readyUsers,omitempty"`
+
+	// Conditions represent the latest available observations of the UserTemplate's state
+	// +optional
+	// +listType=map
+	// +listMapKey=type
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+//+kubebuilder:subresource:status
+//+kubebuilder:resource:categories=idm
+//+kubebuilder:printcolumn:name="Users",type=integer,JSONPath=`.status.users`
+//+kubebuilder:printcolumn:name="Ready",type=integer,JSONPath=`.status.readyUsers`
+
+// UserTemplate is the Schema for the usertemplates API
+type UserTemplate struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   UserTemplateSpec   `json:"spec,omitempty"`
+	Status UserTemplateStatus `json:"status,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+
+// UserTemplateList contains a list of UserTemplate
+type UserTemplateList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []UserTemplate `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&UserTemplate{}, &UserTemplateList{})
+}
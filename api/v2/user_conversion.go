@@ -0,0 +1,170 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v2
+
+import (
+	"encoding/json"
+
+	"sigs.k8s.io/controller-runtime/pkg/conversion"
+
+	v1 "github.com/m15ch4/go-identity-operator/api/v1"
+)
+
+// ConvertTo converts this User to the Hub version (v1). Profile attributes map to the
+// v1 attributes, the annotation that kept them before is dropped. A single role maps to
+// the v1 role, more roles to the v1 roles.
+func (src *User) ConvertTo(dstRaw conversion.Hub) error {
+	dst := dstRaw.(*v1.User)
+
+	dst.ObjectMeta = *src.ObjectMeta.DeepCopy()
+	delete(dst.Annotations, v1.AnnotationProfileAttributes)
+
+	dst.Spec = v1.UserSpec{
+		Name:           src.Spec.Name,
+		Password:       src.Spec.Password,
+		Firstname:      src.Spec.Profile.Firstname,
+		Lastname:       src.Spec.Profile.Lastname,
+		Age:            src.Spec.Profile.Age,
+		Email:          src.Spec.Profile.Email,
+		Phone:          src.Spec.Profile.Phone,
+		DisplayName:    src.Spec.Profile.DisplayName,
+		Attributes:     src.Spec.Profile.Attributes,
+		AdoptExisting:  src.Spec.AdoptExisting,
+		DeletionPolicy: v1.DeletionPolicy(src.Spec.DeletionPolicy),
+		UpdatePolicy:   v1.UpdatePolicy(src.Spec.UpdatePolicy),
+		RecreatePolicy: v1.RecreatePolicy(src.Spec.RecreatePolicy),
+		Paused:         src.Spec.Paused,
+		Enabled:        src.Spec.Enabled,
+	}
+	if len(src.Spec.Roles) == 1 {
+		dst.Spec.Role = src.Spec.Roles[0]
+	} else {
+		dst.Spec.Roles = append([]string(nil), src.Spec.Roles...)
+	}
+	for _, field := range src.Spec.ManagedFields {
+		dst.Spec.ManagedFields = append(dst.Spec.ManagedFields, v1.UserField(field))
+	}
+	if ref := src.Spec.PasswordSecretRef; ref != nil {
+		dst.Spec.PasswordSecretRef = &v1.SecretKeyReference{Name: ref.Name, Key: ref.Key}
+	}
+	if ref := src.Spec.RoleRef; ref != nil {
+		dst.Spec.RoleRef = &v1.RoleReference{Name: ref.Name}
+	}
+	if ref := src.Spec.InstanceRef; ref != nil {
+		dst.Spec.InstanceRef = &v1.IdentityInstanceReference{Name: ref.Name}
+	}
+	if rotation := src.Spec.PasswordRotation; rotation != nil {
+		dst.Spec.PasswordRotation = &v1.PasswordRotation{
+			Enabled:   rotation.Enabled,
+			Interval:  rotation.Interval,
+			SecretRef: v1.SecretKeyReference{Name: rotation.SecretRef.Name, Key: rotation.SecretRef.Key},
+		}
+	}
+	if expiration := src.Spec.Expiration; expiration != nil {
+		dst.Spec.Expiration = &v1.UserExpiration{
+			At:             expiration.At,
+			TTL:            expiration.TTL,
+			Action:         v1.ExpirationAction(expiration.Action),
+			DeleteResource: expiration.DeleteResource,
+		}
+	}
+
+	dst.Status = v1.UserStatus{}
+	return convertStatus(&src.Status, &dst.Status)
+}
+
+// ConvertFrom converts from the Hub version (v1) to this version. Users stored with the
+// profile attributes annotation keep its attributes until they are set in the spec.
+func (dst *User) ConvertFrom(srcRaw conversion.Hub) error {
+	src := srcRaw.(*v1.User)
+
+	dst.ObjectMeta = *src.ObjectMeta.DeepCopy()
+	attributes := src.Spec.Attributes
+	if value, ok := dst.Annotations[v1.AnnotationProfileAttributes]; ok {
+		if len(attributes) == 0 {
+			err := json.Unmarshal([]byte(value), &attributes)
+			if err != nil {
+				return err
+			}
+		}
+		delete(dst.Annotations, v1.AnnotationProfileAttributes)
+		if len(dst.Annotations) == 0 {
+			dst.Annotations = nil
+		}
+	}
+
+	dst.Spec = UserSpec{
+		Name:     src.Spec.Name,
+		Password: src.Spec.Password,
+		Roles:    src.Spec.AllRoles(),
+		Profile: UserProfile{
+			Firstname:   src.Spec.Firstname,
+			Lastname:    src.Spec.Lastname,
+			Age:         src.Spec.Age,
+			Email:       src.Spec.Email,
+			Phone:       src.Spec.Phone,
+			DisplayName: src.Spec.DisplayName,
+			Attributes:  attributes,
+		},
+		AdoptExisting:  src.Spec.AdoptExisting,
+		DeletionPolicy: DeletionPolicy(src.Spec.DeletionPolicy),
+		UpdatePolicy:   UpdatePolicy(src.Spec.UpdatePolicy),
+		RecreatePolicy: RecreatePolicy(src.Spec.RecreatePolicy),
+		Paused:         src.Spec.Paused,
+		Enabled:        src.Spec.Enabled,
+	}
+	for _, field := range src.Spec.ManagedFields {
+		dst.Spec.ManagedFields = append(dst.Spec.ManagedFields, UserField(field))
+	}
+	if ref := src.Spec.PasswordSecretRef; ref != nil {
+		dst.Spec.PasswordSecretRef = &SecretKeyReference{Name: ref.Name, Key: ref.Key}
+	}
+	if ref := src.Spec.RoleRef; ref != nil {
+		dst.Spec.RoleRef = &RoleReference{Name: ref.Name}
+	}
+	if ref := src.Spec.InstanceRef; ref != nil {
+		dst.Spec.InstanceRef = &IdentityInstanceReference{Name: ref.Name}
+	}
+	if rotation := src.Spec.PasswordRotation; rotation != nil {
+		dst.Spec.PasswordRotation = &PasswordRotation{
+			Enabled:   rotation.Enabled,
+			Interval:  rotation.Interval,
+			SecretRef: SecretKeyReference{Name: rotation.SecretRef.Name, Key: rotation.SecretRef.Key},
+		}
+	}
+	if expiration := src.Spec.Expiration; expiration != nil {
+		dst.Spec.Expiration = &UserExpiration{
+			At:             expiration.At,
+			TTL:            expiration.TTL,
+			Action:         ExpirationAction(expiration.Action),
+			DeleteResource: expiration.DeleteResource,
+		}
+	}
+
+	dst.Status = UserStatus{}
+	return convertStatus(&src.Status, &dst.Status)
+}
+
+// convertStatus copies the status between the versions, which share its schema but not
+// the Go types of its nested fields
+func convertStatus(src, dst interface{}) error {
+	data, err := json.Marshal(src)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, dst)
+}
@@ -0,0 +1,100 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v2
+
+import (
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	v1 "github.com/m15ch4/go-identity-operator/api/v1"
+)
+
+var _ = Describe("User conversion", func() {
+	enabled := false
+
+	newUser := func() *User {
+		return &User{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "jackr", Labels: map[string]string{"team": "platform"}},
+			Spec: UserSpec{
+				Name:  "jackr",
+				Roles: []string{"admin", "tester"},
+				Profile: UserProfile{
+					Firstname:  "Jack",
+					Lastname:   "Reacher",
+					Age:        42,
+					Email:      "jack@example.com",
+					Attributes: map[string]string{"department": "platform"},
+				},
+				PasswordSecretRef: &SecretKeyReference{Name: "jackr", Key: "password"},
+				InstanceRef:       &IdentityInstanceReference{Name: "keycloak"},
+				DeletionPolicy:    DeletionPolicyOrphan,
+				ManagedFields:     []UserField{"email"},
+				Enabled:           &enabled,
+				Expiration:        &UserExpiration{TTL: &metav1.Duration{Duration: time.Hour}},
+			},
+			Status: UserStatus{ID: "1", State: "Ready", Recreations: 1},
+		}
+	}
+
+	It("converts a User to v1 and back without loss", func() {
+		src := newUser()
+		hub := &v1.User{}
+		Expect(src.ConvertTo(hub)).To(Succeed())
+		Expect(hub.Spec.Firstname).To(Equal("Jack"))
+		Expect(hub.Spec.AllRoles()).To(Equal([]string{"admin", "tester"}))
+		Expect(hub.Spec.Attributes).To(HaveKeyWithValue("department", "platform"))
+		Expect(hub.Spec.DeletionPolicy).To(Equal(v1.DeletionPolicyOrphan))
+		Expect(hub.Status.ID).To(Equal("1"))
+
+		dst := &User{}
+		Expect(dst.ConvertFrom(hub)).To(Succeed())
+		Expect(dst).To(Equal(src))
+	})
+
+	It("keeps a single role in the v1 role", func() {
+		src := newUser()
+		src.Spec.Roles = []string{"admin"}
+		hub := &v1.User{}
+		Expect(src.ConvertTo(hub)).To(Succeed())
+		Expect(hub.Spec.Role).To(Equal("admin"))
+		Expect(hub.Spec.Roles).To(BeEmpty())
+	})
+
+	It("restores the attributes of Users stored with the profile attributes annotation", func() {
+		hub := &v1.User{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:        "jackr",
+				Annotations: map[string]string{v1.AnnotationProfileAttributes: `{"department":"platform"}`},
+			},
+			Spec: v1.UserSpec{Name: "jackr", Role: "admin"},
+		}
+		dst := &User{}
+		Expect(dst.ConvertFrom(hub)).To(Succeed())
+		Expect(dst.Spec.Profile.Attributes).To(Equal(map[string]string{"department": "platform"}))
+		Expect(dst.Annotations).To(BeNil())
+		Expect(dst.Spec.Roles).To(Equal([]string{"admin"}))
+
+		By("preferring the attributes of the spec")
+		hub.Spec.Attributes = map[string]string{"department": "sales"}
+		Expect(dst.ConvertFrom(hub)).To(Succeed())
+		Expect(dst.Spec.Profile.Attributes).To(Equal(map[string]string{"department": "sales"}))
+	})
+})
@@ -0,0 +1,357 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v2
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// SecretKeyReference selects a key of a Secret in the namespace of the referencing object
+type SecretKeyReference struct {
+	// Name of the Secret
+	Name string `json:"name"`
+	// Key within the Secret
+	Key string `json:"key"`
+}
+
+// RoleReference references a Role in the namespace of the referencing object
+type RoleReference struct {
+	// Name of the Role
+	Name string `json:"name"`
+}
+
+// IdentityInstanceReference references a cluster-scoped IdentityInstance
+type IdentityInstanceReference struct {
+	// Name of the IdentityInstance
+	Name string `json:"name"`
+}
+
+// DeletionPolicy controls what happens to the external user when the User is deleted
+// +kubebuilder:validation:Enum=Delete;Orphan;Retain
+type DeletionPolicy string
+
+const (
+	// DeletionPolicyDelete removes the external user together with the User
+	DeletionPolicyDelete DeletionPolicy = "Delete"
+	// DeletionPolicyOrphan runs finalization but leaves the external user untouched
+	DeletionPolicyOrphan DeletionPolicy = "Orphan"
+	// DeletionPolicyRetain does not protect the User with a finalizer at all,
+	// so deleting it never touches the external user
+	DeletionPolicyRetain DeletionPolicy = "Retain"
+)
+
+// UpdatePolicy controls how changes of the external user are pushed to the identity system
+// +kubebuilder:validation:Enum=Automatic;Manual
+type UpdatePolicy string
+
+const (
+	// UpdatePolicyAutomatic updates the external user as soon as it differs from the spec
+	UpdatePolicyAutomatic UpdatePolicy = "Automatic"
+	// UpdatePolicyManual records the changes in status.pendingChanges and updates the
+	// external user only once they are approved with the approve-changes annotation
+	UpdatePolicyManual UpdatePolicy = "Manual"
+)
+
+// RecreatePolicy controls what happens when the external user was deleted out of band
+// +kubebuilder:validation:Enum=Recreate;Report
+type RecreatePolicy string
+
+const (
+	// RecreatePolicyRecreate creates the external user again and records its new ID
+	RecreatePolicyRecreate RecreatePolicy = "Recreate"
+	// RecreatePolicyReport sets the Missing condition and leaves the user alone until the
+	// external user is restored or the policy is changed to Recreate
+	RecreatePolicyReport RecreatePolicy = "Report"
+)
+
+// UserFieldChange is a change of an attribute of the external user
+type UserFieldChange struct {
+	// Field is the JSON name of the attribute
+	Field string `json:"field"`
+	// Current is the value in the identity system
+	// +optional
+	Current string `json:"current,omitempty"`
+	// Desired is the value in the spec
+	// +optional
+	Desired string `json:"desired,omitempty"`
+}
+
+// UserField names an attribute of the user in the identity system
+// +kubebuilder:validation:Enum=name;firstname;lastname;role;age;email;phone;displayName;enabled;attributes
+type UserField string
+
+// PasswordRotation configures periodic replacement of the user's password
+type PasswordRotation struct {
+	// Enabled turns on password rotation
+	Enabled bool `json:"enabled,omitempty"`
+	// Interval between two rotations
+	// +kubebuilder:default="720h"
+	// +optional
+	Interval metav1.Duration `json:"interval,omitempty"`
+	// SecretRef selects the Secret key the generated password is written to.
+	// The Secret is created in the namespace of the User if it does not exist.
+	SecretRef SecretKeyReference `json:"secretRef"`
+}
+
+// ExpirationAction selects what happens to the external user once the User expires
+// +kubebuilder:validation:Enum=Disable;Delete
+type ExpirationAction string
+
+const (
+	// ExpirationActionDisable keeps the external user but disables it
+	ExpirationActionDisable ExpirationAction = "Disable"
+	// ExpirationActionDelete removes the external user while the User is kept
+	ExpirationActionDelete ExpirationAction = "Delete"
+)
+
+// UserExpiration limits the lifetime of a user, e.g. of contractors or temporary accounts
+// +kubebuilder:validation:XValidation:rule="has(self.at) != has(self.ttl)",message="exactly one of at and ttl must be set"
+type UserExpiration struct {
+	// At is the time the user expires
+	// +optional
+	At *metav1.Time `json:"at,omitempty"`
+	// TTL is the lifetime of the user counted from the creation of the User
+	// +optional
+	TTL *metav1.Duration `json:"ttl,omitempty"`
+	// Action taken on the external user once expired. Disabling requires an identity
+	// system with a notion of disabled users.
+	// +kubebuilder:default=Disable
+	// +optional
+	Action ExpirationAction `json:"action,omitempty"`
+	// DeleteResource deletes the User itself once expired, its external user is then
+	// handled according to the deletionPolicy
+	// +optional
+	DeleteResource bool `json:"deleteResource,omitempty"`
+}
+
+// UserProfile holds the personal details of the user
+// +kubebuilder:validation:XValidation:rule="has(self.firstname) == has(self.lastname)",message="firstname and lastname must be set together"
+type UserProfile struct {
+	// Firstname of the user
+	// +optional
+	Firstname string `json:"firstname,omitempty"`
+	// Lastname of the user
+	// +optional
+	Lastname string `json:"lastname,omitempty"`
+	// Age of the user
+	// +kubebuilder:validation:Minimum=0
+	// +kubebuilder:validation:Maximum=150
+	// +optional
+	Age int `json:"age,omitempty"`
+	// Email address of the user
+	// +kubebuilder:validation:Format=email
+	// +kubebuilder:validation:MaxLength=254
+	// +optional
+	Email string `json:"email,omitempty"`
+	// Phone number of the user in E.164 format, e.g. +48123456789
+	// +kubebuilder:validation:Pattern=`^\+[1-9][0-9]{1,14}$`
+	// +optional
+	Phone string `json:"phone,omitempty"`
+	// DisplayName shown for the user in the identity system
+	// +kubebuilder:validation:MaxLength=256
+	// +optional
+	DisplayName string `json:"displayName,omitempty"`
+	// Attributes are additional profile attributes without a dedicated field
+	// +optional
+	Attributes map[string]string `json:"attributes,omitempty"`
+}
+
+// UserSpec defines the desired state of User
+// +kubebuilder:validation:XValidation:rule="has(self.password) != has(self.passwordSecretRef) || (!has(self.password) && has(self.adoptExisting) && self.adoptExisting)",message="exactly one of password or passwordSecretRef must be set unless adoptExisting is set"
+// +kubebuilder:validation:XValidation:rule="!(has(self.roles) && has(self.roleRef))",message="roles and roleRef are mutually exclusive"
+type UserSpec struct {
+	// Name of the user in the identity system
+	// +kubebuilder:validation:MaxLength=64
+	// +kubebuilder:validation:Pattern=`^[a-zA-Z0-9]([a-zA-Z0-9._@-]*[a-zA-Z0-9])?$`
+	Name string `json:"name,omitempty"`
+	// Password is stored in plaintext in etcd.
+	// Deprecated: use PasswordSecretRef instead.
+	// +optional
+	Password string `json:"password,omitempty"`
+	// Roles are built-in roles of the identity system assigned to the user, use RoleRef for
+	// a role managed with a Role object. They are compared with the identity system as a
+	// set, identity systems with a single role per user keep them in that one field.
+	// +kubebuilder:validation:MaxItems=16
+	// +listType=set
+	// +optional
+	Roles []string `json:"roles,omitempty"`
+
+	// Profile holds the personal details of the user
+	// +optional
+	Profile UserProfile `json:"profile,omitempty"`
+
+	// PasswordSecretRef references the Secret key holding the user's password.
+	// Exactly one of password or passwordSecretRef is required, only Users with
+	// adoptExisting may omit both to leave the password of the external user untouched.
+	// +optional
+	PasswordSecretRef *SecretKeyReference `json:"passwordSecretRef,omitempty"`
+
+	// RoleRef references a managed Role whose name is assigned to the user instead of Roles
+	// +optional
+	RoleRef *RoleReference `json:"roleRef,omitempty"`
+
+	// InstanceRef references the IdentityInstance the user is managed in.
+	// When omitted the operator-level configuration is used.
+	// +optional
+	InstanceRef *IdentityInstanceReference `json:"instanceRef,omitempty"`
+
+	// AdoptExisting makes the controller take ownership of an existing external user
+	// with the same name instead of creating a new one
+	// +optional
+	AdoptExisting bool `json:"adoptExisting,omitempty"`
+
+	// DeletionPolicy controls what happens to the external user when the User is deleted
+	// +kubebuilder:default=Delete
+	// +optional
+	DeletionPolicy DeletionPolicy `json:"deletionPolicy,omitempty"`
+
+	// UpdatePolicy set to Manual holds back updates of the external user until the changes
+	// listed in status.pendingChanges are approved, e.g. for sensitive accounts
+	// +kubebuilder:default=Automatic
+	// +optional
+	UpdatePolicy UpdatePolicy `json:"updatePolicy,omitempty"`
+
+	// RecreatePolicy controls what happens when the external user was deleted from the
+	// identity system by someone else than the operator
+	// +kubebuilder:default=Recreate
+	// +optional
+	RecreatePolicy RecreatePolicy `json:"recreatePolicy,omitempty"`
+
+	// PasswordRotation makes the operator periodically generate a new password,
+	// set it in the identity system and write it to a Secret
+	// +optional
+	PasswordRotation *PasswordRotation `json:"passwordRotation,omitempty"`
+
+	// Paused stops reconciliation, including deletion of the external user,
+	// e.g. during manual maintenance of the identity system
+	// +optional
+	Paused bool `json:"paused,omitempty"`
+
+	// Enabled set to false suspends the external user, it is disabled in the identity
+	// system instead of being deleted and can be enabled again
+	// +kubebuilder:default=true
+	// +optional
+	Enabled *bool `json:"enabled,omitempty"`
+
+	// ManagedFields lists the attributes of the external user owned by the operator. Drift
+	// of the other attributes is left alone, so they can be edited in the identity system,
+	// e.g. by help-desk tools. All attributes are managed when omitted.
+	// +listType=set
+	// +optional
+	ManagedFields []UserField `json:"managedFields,omitempty"`
+
+	// Expiration disables or deletes the external user once the given time has passed
+	// +optional
+	Expiration *UserExpiration `json:"expiration,omitempty"`
+}
+
+// UserStatus defines the observed state of User
+type UserStatus struct {
+	// State is a human readable summary of the conditions
+	State string `json:"state,omitempty"`
+	ID    string `json:"id,omitempty"`
+
+	// ObservedGeneration is the generation of the spec last synced to the identity system
+	// +optional
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+	// LastSyncTime is the time of the last successful sync with the identity system
+	// +optional
+	LastSyncTime *metav1.Time `json:"lastSyncTime,omitempty"`
+	// ExternalName is the name of the user in the identity system
+	// +optional
+	ExternalName string `json:"externalName,omitempty"`
+	// LastPasswordRotation is the time the password was last rotated
+	// +optional
+	LastPasswordRotation *metav1.Time `json:"lastPasswordRotation,omitempty"`
+	// CredentialsSecret is the name of the Secret holding the username, ID and password
+	// of the user, for applications to mount
+	// +optional
+	CredentialsSecret string `json:"credentialsSecret,omitempty"`
+	// SyncedSpecHash is the hash of the spec last synced to the identity system. The external
+	// user is not read again until the hash changes or the drift resync period elapses.
+	// +optional
+	SyncedSpecHash string `json:"syncedSpecHash,omitempty"`
+	// Recreations counts the times the external user was created again after it was deleted
+	// out of band
+	// +optional
+	Recreations int32 `json:"recreations,omitempty"`
+
+	// LastError is the error of the last failed attempt, cleared by the next successful sync
+	// +optional
+	LastError string `json:"lastError,omitempty"`
+	// RetryCount is the number of consecutive failed attempts
+	// +optional
+	RetryCount int32 `json:"retryCount,omitempty"`
+	// LastAttemptTime is the time of the last attempt to sync with the identity system,
+	// successful or not
+	// +optional
+	LastAttemptTime *metav1.Time `json:"lastAttemptTime,omitempty"`
+	// Backoff is the delay before the next attempt after the last failed attempt, growing
+	// exponentially with the consecutive failures up to the maximum requeue delay
+	// +optional
+	Backoff *metav1.Duration `json:"backoff,omitempty"`
+	// NextAttemptTime is the time of the next attempt after the last failed attempt
+	// +optional
+	NextAttemptTime *metav1.Time `json:"nextAttemptTime,omitempty"`
+	// PendingChanges are the changes of the external user waiting for approval under the
+	// Manual update policy
+	// +optional
+	PendingChanges []UserFieldChange `json:"pendingChanges,omitempty"`
+	// PendingChangesHash identifies the pending changes, setting the approve-changes
+	// annotation to it approves exactly these changes
+	// +optional
+	PendingChangesHash string `json:"pendingChangesHash,omitempty"`
+
+	// Conditions represent the latest available observations of the User's state
+	// +optional
+	// +listType=map
+	// +listMapKey=type
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+//+kubebuilder:subresource:status
+//+kubebuilder:resource:shortName=usr,categories=idm
+//+kubebuilder:printcolumn:name="State",type=string,JSONPath=`.status.state`
+//+kubebuilder:printcolumn:name="External ID",type=string,JSONPath=`.status.id`
+//+kubebuilder:printcolumn:name="Roles",type=string,JSONPath=`.spec.roles`
+//+kubebuilder:printcolumn:name="Retries",type=integer,JSONPath=`.status.retryCount`,priority=1
+//+kubebuilder:printcolumn:name="Backoff",type=string,JSONPath=`.status.backoff`,priority=1
+//+kubebuilder:printcolumn:name="Last Error",type=string,JSONPath=`.status.lastError`,priority=1
+//+kubebuilder:printcolumn:name="Age",type=date,JSONPath=`.metadata.creationTimestamp`
+
+// User is the Schema for the users API
+type User struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   UserSpec   `json:"spec,omitempty"`
+	Status UserStatus `json:"status,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+
+// UserList contains a list of User
+type UserList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []User `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&User{}, &UserList{})
+}
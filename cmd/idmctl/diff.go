@@ -0,0 +1,148 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"strconv"
+	"text/tabwriter"
+
+	"k8s.io/apimachinery/pkg/types"
+
+	idmv1 "github.com/m15ch4/go-identity-operator/api/v1"
+	"github.com/m15ch4/go-identity-operator/internal/controller"
+	idmsvc "github.com/m15ch4/go-identity-operator/pkg/identityclient"
+)
+
+// errDrifted makes idmctl exit with status 1 without an error message, like diff does
+// when the compared inputs differ
+var errDrifted = errors.New("user drifted")
+
+// runDiff compares the User with its external user like the operator does and prints the
+// drifted fields. The identity system of the User is reached with the same configuration
+// as the operator, the operator-level one is read from the environment.
+func runDiff(ctx context.Context, args []string, out io.Writer) error {
+	fs := flag.NewFlagSet("diff", flag.ContinueOnError)
+	var opts clusterOptions
+	opts.bind(fs)
+	credentialsSecret := fs.String("credentials-secret", "",
+		"Secret in namespace/name form holding IDM_USER and IDM_PASS of the operator-level identity system, like the flag of the operator.")
+	ignoredAttributes := fs.String("drift-ignored-attributes", "",
+		"Comma separated custom attributes that are not compared, like the flag of the operator.")
+	operatorConfig := fs.String("operator-config", "default",
+		"Name of the IdentityOperatorConfig whose settings override the flags.")
+	args, err := parseArgs(fs, args)
+	if err != nil {
+		return err
+	}
+	name, err := userArg("diff", args)
+	if err != nil {
+		return err
+	}
+	secret, err := namespacedName(*credentialsSecret)
+	if err != nil {
+		return err
+	}
+
+	c, namespace, err := opts.client()
+	if err != nil {
+		return err
+	}
+	user := &idmv1.User{}
+	if err := c.Get(ctx, types.NamespacedName{Namespace: namespace, Name: name}, user); err != nil {
+		return err
+	}
+
+	config := &controller.OperatorConfig{}
+	if err := config.Load(ctx, c, *operatorConfig); err != nil {
+		return err
+	}
+	identityConfig := idmsvc.NewIdentityConfig()
+	reconciler := &controller.UserReconciler{
+		Client:                 c,
+		Scheme:                 scheme,
+		IdentityService:        idmsvc.NewIdentityService(&identityConfig),
+		Options:                controller.ControllerOptions{Config: config},
+		DriftIgnoredAttributes: splitList(*ignoredAttributes),
+		CredentialsSecret:      secret,
+	}
+	diff, err := reconciler.Diff(ctx, user)
+	if err != nil {
+		return err
+	}
+
+	if diff.External == nil {
+		fmt.Fprintf(out, "user/%s: external user %s does not exist in the identity system\n", name, user.Spec.Name)
+		return errDrifted
+	}
+	if len(diff.Drifted) == 0 {
+		fmt.Fprintf(out, "user/%s matches external user %s\n", name, diff.External.ID)
+		return nil
+	}
+
+	desired := idmsvc.UserPatch(diff.Desired, diff.Drifted)
+	actual, err := jsonFields(diff.External)
+	if err != nil {
+		return err
+	}
+	w := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "FIELD\tUSER\tIDENTITY SYSTEM")
+	for _, field := range diff.Drifted {
+		want := desired[field]
+		if field == "enabled" {
+			want = diff.Desired.IsEnabled()
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\n", field, formatValue(want), formatValue(actual[field]))
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	return errDrifted
+}
+
+// jsonFields returns the fields of the external user keyed by their JSON names
+func jsonFields(usr *idmsvc.IdentityUser) (map[string]interface{}, error) {
+	data, err := json.Marshal(usr)
+	if err != nil {
+		return nil, err
+	}
+	fields := map[string]interface{}{}
+	return fields, json.Unmarshal(data, &fields)
+}
+
+// formatValue renders a field value for the diff table
+func formatValue(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return "<unset>"
+	case string:
+		if v == "" {
+			return "<unset>"
+		}
+		return strconv.Quote(v)
+	}
+	data, err := json.Marshal(value)
+	if err != nil || string(data) == "null" {
+		return "<unset>"
+	}
+	return string(data)
+}
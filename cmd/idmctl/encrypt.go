@@ -0,0 +1,92 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/m15ch4/go-identity-operator/internal/encryption"
+)
+
+// runEncrypt encrypts the value read from standard input with the key encryption key the
+// operator is configured with, for use in fields such as spec.password of a User
+func runEncrypt(ctx context.Context, args []string, in io.Reader, out io.Writer) error {
+	fs := flag.NewFlagSet("encrypt", flag.ContinueOnError)
+	var opts clusterOptions
+	opts.bind(fs)
+	keySecret := fs.String("key-secret", "", "Secret in namespace/name form holding the keys, like --encryption-key-secret of the operator.")
+	keyName := fs.String("key-name", encryption.DefaultSecretKeyName, "Key of the Secret to encrypt with.")
+	vaultAddress := fs.String("vault-address", "", "Address of Vault, like --encryption-vault-address of the operator. The token is read from VAULT_TOKEN.")
+	vaultMount := fs.String("vault-mount", encryption.DefaultVaultMount, "Path the transit secrets engine is mounted at.")
+	vaultKey := fs.String("vault-key", "", "Name of the transit key to encrypt with.")
+	args, err := parseArgs(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(args) != 0 {
+		return fmt.Errorf("usage: idmctl encrypt [flags] < value")
+	}
+
+	envelope := &encryption.Envelope{}
+	switch {
+	case *keySecret != "" && *vaultAddress != "":
+		return errors.New("--key-secret and --vault-address are mutually exclusive")
+	case *keySecret != "":
+		secret, err := namespacedName(*keySecret)
+		if err != nil {
+			return err
+		}
+		c, _, err := opts.client()
+		if err != nil {
+			return err
+		}
+		envelope.Wrapper = &encryption.SecretKeyWrapper{Reader: c, Secret: secret, KeyName: *keyName}
+	case *vaultAddress != "":
+		envelope.Wrapper = &encryption.VaultTransitWrapper{
+			Address: *vaultAddress,
+			Mount:   *vaultMount,
+			Key:     *vaultKey,
+			Token:   os.Getenv("VAULT_TOKEN"),
+		}
+	default:
+		return errors.New("one of --key-secret or --vault-address is required")
+	}
+
+	// the value ends at the first line break, so echo and here-strings work
+	value, err := bufio.NewReader(in).ReadString('\n')
+	if err != nil && err != io.EOF {
+		return err
+	}
+	value = strings.TrimRight(value, "\r\n")
+	if value == "" {
+		return errors.New("no value given on standard input")
+	}
+
+	encrypted, err := envelope.Encrypt(ctx, value)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintln(out, encrypted)
+	return nil
+}
@@ -0,0 +1,106 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"time"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	idmv1 "github.com/m15ch4/go-identity-operator/api/v1"
+)
+
+// runImport creates an IdentityImport, which has the operator create a User for every
+// unmanaged user of the identity system, and waits for it to complete
+func runImport(ctx context.Context, args []string, out io.Writer) error {
+	fs := flag.NewFlagSet("import", flag.ContinueOnError)
+	var opts clusterOptions
+	opts.bind(fs)
+	name := fs.String("name", "", "Name of the IdentityImport, generated when empty.")
+	instance := fs.String("instance", "", "IdentityInstance the users are imported from, the operator-level identity system when empty.")
+	names := fs.String("names", "", "Comma separated names of the external users to import, all unmanaged users when empty.")
+	ignore := fs.String("ignore", "", "Comma separated names of external users that are never imported.")
+	deletionPolicy := fs.String("deletion-policy", "", "Deletion policy of the created Users, Orphan when empty.")
+	waitForImport := fs.Bool("wait", true, "Wait until the import completed.")
+	timeout := fs.Duration("timeout", 5*time.Minute, "Time to wait for the import.")
+	args, err := parseArgs(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(args) != 0 {
+		return fmt.Errorf("usage: idmctl import [flags]")
+	}
+
+	c, namespace, err := opts.client()
+	if err != nil {
+		return err
+	}
+	imp := &idmv1.IdentityImport{
+		ObjectMeta: metav1.ObjectMeta{Name: *name, Namespace: namespace},
+		Spec: idmv1.IdentityImportSpec{
+			Names:          splitList(*names),
+			IgnoreNames:    splitList(*ignore),
+			DeletionPolicy: idmv1.DeletionPolicy(*deletionPolicy),
+		},
+	}
+	if imp.Name == "" {
+		imp.GenerateName = "idmctl-import-"
+	}
+	if *instance != "" {
+		imp.Spec.InstanceRef = &idmv1.IdentityInstanceReference{Name: *instance}
+	}
+	if err := c.Create(ctx, imp); err != nil {
+		return err
+	}
+	fmt.Fprintf(out, "identityimport/%s created\n", imp.Name)
+	if !*waitForImport {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, *timeout)
+	defer cancel()
+	var complete *metav1.Condition
+	err = wait.PollUntilContextCancel(ctx, time.Second, true, func(ctx context.Context) (bool, error) {
+		if err := c.Get(ctx, client.ObjectKeyFromObject(imp), imp); err != nil {
+			return false, err
+		}
+		complete = meta.FindStatusCondition(imp.Status.Conditions, idmv1.ConditionComplete)
+		return complete != nil && complete.ObservedGeneration == imp.Generation, nil
+	})
+	if err != nil {
+		return fmt.Errorf("identityimport/%s did not complete within %s", imp.Name, *timeout)
+	}
+	if complete.Status != metav1.ConditionTrue {
+		return fmt.Errorf("identityimport/%s failed, the operator retries it: %s", imp.Name, complete.Message)
+	}
+
+	for _, imported := range imp.Status.Imported {
+		fmt.Fprintf(out, "user/%s imported from external user %s\n", imported.Name, imported.ID)
+	}
+	for _, skipped := range imp.Status.Skipped {
+		fmt.Fprintf(out, "external user %s skipped\n", skipped)
+	}
+	fmt.Fprintf(out, "identityimport/%s complete: %s\n", imp.Name, complete.Message)
+	return nil
+}
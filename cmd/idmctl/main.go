@@ -0,0 +1,188 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// idmctl inspects and drives the objects of the identity operator. Installed as
+// kubectl-idm on the PATH it also works as the kubectl plugin "kubectl idm".
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/clientcmd"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	idmv1 "github.com/m15ch4/go-identity-operator/api/v1"
+)
+
+const usage = `idmctl inspects and drives the objects of the identity operator.
+
+Usage:
+  idmctl sync user NAME [flags]   compare the User with the identity system right away
+  idmctl diff user NAME [flags]   show the fields of the User that differ from the identity system
+  idmctl plan [flags]             show what the operator would change in the identity systems
+  idmctl import [flags]           import the unmanaged users of an identity system as Users
+  idmctl encrypt [flags] < VALUE  encrypt a value, e.g. a password, for the operator
+  idmctl schema [KIND] [flags]    print the OpenAPI schemas of the custom resources
+
+Common flags:
+  --kubeconfig PATH   kubeconfig file, defaults to $KUBECONFIG or ~/.kube/config
+  --context NAME      kubeconfig context to use
+  -n, --namespace NS  namespace of the objects, defaults to the namespace of the context
+
+Run "idmctl COMMAND -h" for the flags of a command.
+`
+
+var scheme = runtime.NewScheme()
+
+func init() {
+	utilruntime.Must(clientgoscheme.AddToScheme(scheme))
+	utilruntime.Must(apiextensionsv1.AddToScheme(scheme))
+	utilruntime.Must(idmv1.AddToScheme(scheme))
+}
+
+func main() {
+	err := run(context.Background(), os.Args[1:], os.Stdout)
+	if errors.Is(err, flag.ErrHelp) {
+		return
+	}
+	if errors.Is(err, errDrifted) {
+		os.Exit(1)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "error:", err)
+		os.Exit(1)
+	}
+}
+
+// run executes the command given by args and writes its output to out
+func run(ctx context.Context, args []string, out io.Writer) error {
+	if len(args) == 0 || args[0] == "-h" || args[0] == "--help" || args[0] == "help" {
+		fmt.Fprint(out, usage)
+		return nil
+	}
+
+	switch args[0] {
+	case "sync":
+		return runSync(ctx, args[1:], out)
+	case "diff":
+		return runDiff(ctx, args[1:], out)
+	case "plan":
+		return runPlan(ctx, args[1:], out)
+	case "import":
+		return runImport(ctx, args[1:], out)
+	case "encrypt":
+		return runEncrypt(ctx, args[1:], os.Stdin, out)
+	case "schema":
+		return runSchema(ctx, args[1:], out)
+	default:
+		return fmt.Errorf("unknown command %q, run idmctl --help for usage", args[0])
+	}
+}
+
+// clusterOptions are the flags selecting the cluster and namespace, shared by all commands
+type clusterOptions struct {
+	kubeconfig string
+	context    string
+	namespace  string
+}
+
+func (o *clusterOptions) bind(fs *flag.FlagSet) {
+	fs.StringVar(&o.kubeconfig, "kubeconfig", "", "Path to the kubeconfig file.")
+	fs.StringVar(&o.context, "context", "", "Name of the kubeconfig context to use.")
+	fs.StringVar(&o.namespace, "namespace", "", "Namespace of the objects.")
+	fs.StringVar(&o.namespace, "n", "", "Namespace of the objects (shorthand).")
+}
+
+// client returns a client of the cluster and the namespace to work in
+func (o *clusterOptions) client() (client.Client, string, error) {
+	rules := clientcmd.NewDefaultClientConfigLoadingRules()
+	rules.ExplicitPath = o.kubeconfig
+	overrides := &clientcmd.ConfigOverrides{CurrentContext: o.context}
+	overrides.Context.Namespace = o.namespace
+	config := clientcmd.NewNonInteractiveDeferredLoadingClientConfig(rules, overrides)
+
+	restConfig, err := config.ClientConfig()
+	if err != nil {
+		return nil, "", err
+	}
+	namespace, _, err := config.Namespace()
+	if err != nil {
+		return nil, "", err
+	}
+	c, err := client.New(restConfig, client.Options{Scheme: scheme})
+	if err != nil {
+		return nil, "", err
+	}
+	return c, namespace, nil
+}
+
+// parseArgs parses the flags of fs, which may be given before, between and after the
+// positional arguments as kubectl allows, and returns the positional arguments
+func parseArgs(fs *flag.FlagSet, args []string) ([]string, error) {
+	var positional []string
+	for {
+		if err := fs.Parse(args); err != nil {
+			return nil, err
+		}
+		if fs.NArg() == 0 {
+			return positional, nil
+		}
+		positional = append(positional, fs.Arg(0))
+		args = fs.Args()[1:]
+	}
+}
+
+// userArg returns the name of the User given as "user NAME"
+func userArg(command string, args []string) (string, error) {
+	if len(args) != 2 || (args[0] != "user" && args[0] != "users") {
+		return "", fmt.Errorf("usage: idmctl %s user NAME", command)
+	}
+	return args[1], nil
+}
+
+// splitList splits a comma separated flag value, dropping empty items
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// namespacedName parses a namespace/name flag value
+func namespacedName(value string) (types.NamespacedName, error) {
+	if value == "" {
+		return types.NamespacedName{}, nil
+	}
+	namespace, name, ok := strings.Cut(value, "/")
+	if !ok || namespace == "" || name == "" {
+		return types.NamespacedName{}, fmt.Errorf("%q is not in namespace/name form", value)
+	}
+	return types.NamespacedName{Namespace: namespace, Name: name}, nil
+}
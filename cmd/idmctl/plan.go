@@ -0,0 +1,142 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	idmv1 "github.com/m15ch4/go-identity-operator/api/v1"
+	"github.com/m15ch4/go-identity-operator/internal/controller"
+	idmsvc "github.com/m15ch4/go-identity-operator/pkg/identityclient"
+)
+
+// runPlan computes what the operator would create, update and delete in the identity
+// systems for the Users and Groups and prints the changes with a summary, e.g. for a
+// review before the operator is started in a new environment. Nothing is changed.
+func runPlan(ctx context.Context, args []string, out io.Writer) error {
+	fs := flag.NewFlagSet("plan", flag.ContinueOnError)
+	var opts clusterOptions
+	opts.bind(fs)
+	var allNamespaces bool
+	fs.BoolVar(&allNamespaces, "all-namespaces", false, "Plan the objects of all namespaces.")
+	fs.BoolVar(&allNamespaces, "A", false, "Plan the objects of all namespaces (shorthand).")
+	credentialsSecret := fs.String("credentials-secret", "",
+		"Secret in namespace/name form holding IDM_USER and IDM_PASS of the operator-level identity system, like the flag of the operator.")
+	ignoredAttributes := fs.String("drift-ignored-attributes", "",
+		"Comma separated custom attributes that are not compared, like the flag of the operator.")
+	operatorConfig := fs.String("operator-config", "default",
+		"Name of the IdentityOperatorConfig whose settings override the flags.")
+	args, err := parseArgs(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(args) > 0 {
+		return fmt.Errorf("usage: idmctl plan [flags]")
+	}
+	secret, err := namespacedName(*credentialsSecret)
+	if err != nil {
+		return err
+	}
+
+	c, namespace, err := opts.client()
+	if err != nil {
+		return err
+	}
+	var listOpts []client.ListOption
+	if !allNamespaces {
+		listOpts = append(listOpts, client.InNamespace(namespace))
+	}
+	users := &idmv1.UserList{}
+	if err := c.List(ctx, users, listOpts...); err != nil {
+		return err
+	}
+	groups := &idmv1.GroupList{}
+	if err := c.List(ctx, groups, listOpts...); err != nil {
+		return err
+	}
+
+	config := &controller.OperatorConfig{}
+	if err := config.Load(ctx, c, *operatorConfig); err != nil {
+		return err
+	}
+	identityConfig := idmsvc.NewIdentityConfig()
+	identityService := idmsvc.NewIdentityService(&identityConfig)
+	userReconciler := &controller.UserReconciler{
+		Client:                 c,
+		Scheme:                 scheme,
+		IdentityService:        identityService,
+		Options:                controller.ControllerOptions{Config: config},
+		DriftIgnoredAttributes: splitList(*ignoredAttributes),
+		CredentialsSecret:      secret,
+	}
+	groupReconciler := &controller.GroupReconciler{
+		Client:            c,
+		Scheme:            scheme,
+		IdentityService:   identityService,
+		Options:           controller.ControllerOptions{Config: config},
+		CredentialsSecret: secret,
+	}
+
+	counts := map[controller.PlanAction]int{}
+	failed := 0
+	w := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "ACTION\tOBJECT\tDETAILS")
+	record := func(object string, change *controller.PlannedChange, err error) {
+		switch {
+		case err != nil:
+			failed++
+			fmt.Fprintf(w, "error\t%s\t%v\n", object, err)
+		case change.Action == controller.PlanActionNone:
+			counts[change.Action]++
+		default:
+			counts[change.Action]++
+			details := change.Reason
+			if len(change.Fields) > 0 {
+				details = strings.Join(change.Fields, ", ")
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\n", change.Action, object, details)
+		}
+	}
+	for i := range users.Items {
+		user := &users.Items[i]
+		change, err := userReconciler.Plan(ctx, user)
+		record(fmt.Sprintf("user/%s/%s", user.Namespace, user.Name), change, err)
+	}
+	for i := range groups.Items {
+		group := &groups.Items[i]
+		change, err := groupReconciler.Plan(ctx, group)
+		record(fmt.Sprintf("group/%s/%s", group.Namespace, group.Name), change, err)
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+
+	fmt.Fprintf(out, "\nPlan: %d to create, %d to adopt, %d to update, %d to delete, %d unchanged, %d skipped.\n",
+		counts[controller.PlanActionCreate], counts[controller.PlanActionAdopt], counts[controller.PlanActionUpdate],
+		counts[controller.PlanActionDelete], counts[controller.PlanActionNone], counts[controller.PlanActionSkip])
+	if failed > 0 {
+		return fmt.Errorf("%d objects could not be planned", failed)
+	}
+	return nil
+}
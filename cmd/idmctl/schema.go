@@ -0,0 +1,155 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"strings"
+
+	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+
+	idmv1 "github.com/m15ch4/go-identity-operator/api/v1"
+)
+
+// runSchema prints the OpenAPI v3 schemas of the custom resources of the operator as
+// installed in the cluster, including their validation rules, e.g. for portals generating
+// forms to create Users. Given a kind, it prints the JSON Schema of that kind alone.
+func runSchema(ctx context.Context, args []string, out io.Writer) error {
+	fs := flag.NewFlagSet("schema", flag.ContinueOnError)
+	var opts clusterOptions
+	opts.bind(fs)
+	version := fs.String("version", "", "API version of the schemas, defaults to the storage version of every kind.")
+	args, err := parseArgs(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(args) > 1 {
+		return fmt.Errorf("usage: idmctl schema [KIND] [flags]")
+	}
+
+	c, _, err := opts.client()
+	if err != nil {
+		return err
+	}
+	crds := &apiextensionsv1.CustomResourceDefinitionList{}
+	if err := c.List(ctx, crds); err != nil {
+		return err
+	}
+
+	schemas := map[string]interface{}{}
+	var kindSchema interface{}
+	for i := range crds.Items {
+		crd := &crds.Items[i]
+		if crd.Spec.Group != idmv1.GroupVersion.Group {
+			continue
+		}
+		if len(args) == 1 && !matchesKind(crd, args[0]) {
+			continue
+		}
+		for j := range crd.Spec.Versions {
+			v := &crd.Spec.Versions[j]
+			if !v.Served || v.Schema == nil || v.Schema.OpenAPIV3Schema == nil {
+				continue
+			}
+			if (*version == "" && !v.Storage) || (*version != "" && v.Name != *version) {
+				continue
+			}
+			schema, err := kindSchemaOf(crd, v)
+			if err != nil {
+				return err
+			}
+			schemas[schemaName(crd.Spec.Group, v.Name, crd.Spec.Names.Kind)] = schema
+			kindSchema = schema
+		}
+	}
+
+	encoder := json.NewEncoder(out)
+	encoder.SetIndent("", "  ")
+	if len(args) == 1 {
+		if kindSchema == nil {
+			return fmt.Errorf("no served schema of kind %s found in the cluster", args[0])
+		}
+		schema := kindSchema.(map[string]interface{})
+		schema["$schema"] = "http://json-schema.org/draft-04/schema#"
+		return encoder.Encode(schema)
+	}
+	if len(schemas) == 0 {
+		return fmt.Errorf("no CustomResourceDefinitions of %s found in the cluster", idmv1.GroupVersion.Group)
+	}
+	return encoder.Encode(map[string]interface{}{
+		"openapi": "3.0.0",
+		"info": map[string]interface{}{
+			"title":   "go-identity-operator",
+			"version": idmv1.GroupVersion.Version,
+		},
+		"paths":      map[string]interface{}{},
+		"components": map[string]interface{}{"schemas": schemas},
+	})
+}
+
+// matchesKind reports whether the CustomResourceDefinition is the one of kind, given by its
+// kind, plural, singular or short name as kubectl accepts it
+func matchesKind(crd *apiextensionsv1.CustomResourceDefinition, kind string) bool {
+	names := crd.Spec.Names
+	candidates := append([]string{names.Kind, names.Plural, names.Singular}, names.ShortNames...)
+	for _, candidate := range candidates {
+		if strings.EqualFold(candidate, kind) {
+			return true
+		}
+	}
+	return false
+}
+
+// kindSchemaOf returns the schema of the version of the CustomResourceDefinition as generic
+// JSON, extended with the group, version and kind and the scope of its objects the way the
+// API server publishes them
+func kindSchemaOf(crd *apiextensionsv1.CustomResourceDefinition, v *apiextensionsv1.CustomResourceDefinitionVersion) (interface{}, error) {
+	data, err := json.Marshal(v.Schema.OpenAPIV3Schema)
+	if err != nil {
+		return nil, err
+	}
+	var schema map[string]interface{}
+	if err := json.Unmarshal(data, &schema); err != nil {
+		return nil, err
+	}
+	schema["x-kubernetes-group-version-kind"] = []interface{}{map[string]interface{}{
+		"group":   crd.Spec.Group,
+		"version": v.Name,
+		"kind":    crd.Spec.Names.Kind,
+	}}
+	schema["x-kubernetes-scope"] = string(crd.Spec.Scope)
+	// the apiVersion and kind of objects are fixed, forms can fill them in
+	if properties, ok := schema["properties"].(map[string]interface{}); ok {
+		properties["apiVersion"] = map[string]interface{}{"type": "string", "enum": []string{crd.Spec.Group + "/" + v.Name}}
+		properties["kind"] = map[string]interface{}{"type": "string", "enum": []string{crd.Spec.Names.Kind}}
+	}
+	return schema, nil
+}
+
+// schemaName is the name of the schema in the components of the document, in the reversed
+// domain notation of the OpenAPI document of the API server, e.g. io.micze.idm.v1.User
+func schemaName(group, version, kind string) string {
+	parts := strings.Split(group, ".")
+	for i, j := 0, len(parts)-1; i < j; i, j = i+1, j-1 {
+		parts[i], parts[j] = parts[j], parts[i]
+	}
+	return strings.Join(append(parts, version, kind), ".")
+}
@@ -0,0 +1,100 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"time"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	idmv1 "github.com/m15ch4/go-identity-operator/api/v1"
+)
+
+// runSync asks the operator to compare the User with the identity system right away,
+// even though its spec did not change, and waits for the outcome
+func runSync(ctx context.Context, args []string, out io.Writer) error {
+	fs := flag.NewFlagSet("sync", flag.ContinueOnError)
+	var opts clusterOptions
+	opts.bind(fs)
+	waitForSync := fs.Bool("wait", true, "Wait until the operator synced the User.")
+	timeout := fs.Duration("timeout", time.Minute, "Time to wait for the sync.")
+	args, err := parseArgs(fs, args)
+	if err != nil {
+		return err
+	}
+	name, err := userArg("sync", args)
+	if err != nil {
+		return err
+	}
+
+	c, namespace, err := opts.client()
+	if err != nil {
+		return err
+	}
+	key := types.NamespacedName{Namespace: namespace, Name: name}
+	user := &idmv1.User{}
+	if err := c.Get(ctx, key, user); err != nil {
+		return err
+	}
+
+	// the annotation has second precision, like the last sync time it is compared with
+	requested := time.Now().UTC().Truncate(time.Second)
+	patch := client.MergeFrom(user.DeepCopy())
+	annotations := user.GetAnnotations()
+	if annotations == nil {
+		annotations = map[string]string{}
+	}
+	annotations[idmv1.AnnotationSyncRequested] = requested.Format(time.RFC3339)
+	user.SetAnnotations(annotations)
+	if err := c.Patch(ctx, user, patch); err != nil {
+		return err
+	}
+	fmt.Fprintf(out, "user/%s sync requested\n", name)
+	if !*waitForSync {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, *timeout)
+	defer cancel()
+	err = wait.PollUntilContextCancel(ctx, time.Second, true, func(ctx context.Context) (bool, error) {
+		if err := c.Get(ctx, key, user); err != nil {
+			return false, client.IgnoreNotFound(err)
+		}
+		if user.Status.LastSyncTime != nil && !user.Status.LastSyncTime.Time.Before(requested) {
+			return true, nil
+		}
+		degraded := meta.FindStatusCondition(user.Status.Conditions, idmv1.ConditionDegraded)
+		return degraded != nil && degraded.Status == metav1.ConditionTrue && !degraded.LastTransitionTime.Time.Before(requested), nil
+	})
+	if err != nil {
+		return fmt.Errorf("user/%s was not synced within %s", name, *timeout)
+	}
+
+	if degraded := meta.FindStatusCondition(user.Status.Conditions, idmv1.ConditionDegraded); degraded != nil && degraded.Status == metav1.ConditionTrue {
+		return fmt.Errorf("user/%s failed to sync: %s", name, degraded.Message)
+	}
+	fmt.Fprintf(out, "user/%s synced, state %s\n", name, user.Status.State)
+	return nil
+}
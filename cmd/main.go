@@ -17,23 +17,44 @@ limitations under the License.
 package main
 
 import (
+	"context"
+	"errors"
 	"flag"
+	"fmt"
+	"net/http"
 	"os"
+	"path/filepath"
+	"strings"
+	"time"
 
 	// Import all Kubernetes client auth plugins (e.g. Azure, GCP, OIDC, etc.)
 	// to ensure that exec-entrypoint and run can make use of them.
 	_ "k8s.io/client-go/plugin/pkg/client/auth"
 
+	uberzap "go.uber.org/zap"
+	corev1 "k8s.io/api/core/v1"
+	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
 	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
 	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
+	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/healthz"
 	"sigs.k8s.io/controller-runtime/pkg/log/zap"
 	metricsserver "sigs.k8s.io/controller-runtime/pkg/metrics/server"
+	"sigs.k8s.io/controller-runtime/pkg/webhook"
 
 	idmv1 "github.com/m15ch4/go-identity-operator/api/v1"
+	idmv2 "github.com/m15ch4/go-identity-operator/api/v2"
 	"github.com/m15ch4/go-identity-operator/internal/controller"
+	"github.com/m15ch4/go-identity-operator/internal/encryption"
+	"github.com/m15ch4/go-identity-operator/internal/notify"
+	"github.com/m15ch4/go-identity-operator/internal/webhookcert"
+	idmsvc "github.com/m15ch4/go-identity-operator/pkg/identityclient"
+	"github.com/m15ch4/go-identity-operator/pkg/tracing"
 	//+kubebuilder:scaffold:imports
 )
 
@@ -44,58 +65,601 @@ var (
 
 func init() {
 	utilruntime.Must(clientgoscheme.AddToScheme(scheme))
+	utilruntime.Must(apiextensionsv1.AddToScheme(scheme))
 
 	utilruntime.Must(idmv1.AddToScheme(scheme))
+	utilruntime.Must(idmv2.AddToScheme(scheme))
 	//+kubebuilder:scaffold:scheme
 }
 
 func main() {
 	var metricsAddr string
 	var enableLeaderElection bool
+	var leaderElectionNamespace string
+	var leaseDuration time.Duration
+	var renewDeadline time.Duration
+	var retryPeriod time.Duration
+	var gracefulShutdownTimeout time.Duration
+	var shutdownDrainTimeout time.Duration
 	var probeAddr string
+	var credentialsSecret string
+	var requeueBaseDelay time.Duration
+	var requeueMaxDelay time.Duration
+	var driftResyncPeriod time.Duration
+	var driftIgnoredAttributes string
+	var maxConcurrentReconciles int
+	var userMaxConcurrentReconciles int
+	var groupMaxConcurrentReconciles int
+	var bulkCreateWindow time.Duration
+	var bulkCreateMaxSize int
+	var rateLimiterQPS float64
+	var rateLimiterBurst int
+	var logLevelConfigMap string
+	var watchNamespaces string
+	var userScope string
+	var clusterUserNamespace string
+	var forceFinalizeAfter time.Duration
+	var janitorThreshold time.Duration
+	var janitorRemoveFinalizers bool
+	var syncServiceAccounts bool
+	var bootstrapSecret string
+	var bootstrapUsername string
+	var webhookCertDir string
+	var webhookCertSecret string
+	var webhookService string
+	var watchLabelSelector string
+	var backendProbeInterval time.Duration
+	var backendFailureThreshold float64
+	var notificationURL string
+	var changeFeedAddr string
+	var dryRun bool
+	var operatorConfig string
+	var allowedUserRoles string
+	var encryptionKeySecret string
+	var encryptionKeyName string
+	var encryptionVaultAddress string
+	var encryptionVaultMount string
+	var encryptionVaultKey string
 	flag.StringVar(&metricsAddr, "metrics-bind-address", ":8080", "The address the metric endpoint binds to.")
 	flag.StringVar(&probeAddr, "health-probe-bind-address", ":8081", "The address the probe endpoint binds to.")
 	flag.BoolVar(&enableLeaderElection, "leader-elect", false,
 		"Enable leader election for controller manager. "+
 			"Enabling this will ensure there is only one active controller manager.")
+	flag.StringVar(&leaderElectionNamespace, "leader-elect-namespace", "",
+		"Namespace of the leader election lease. Defaults to the namespace the manager runs in.")
+	flag.DurationVar(&leaseDuration, "leader-elect-lease-duration", 15*time.Second,
+		"Duration non-leader replicas wait before trying to acquire a lease that was not renewed.")
+	flag.DurationVar(&renewDeadline, "leader-elect-renew-deadline", 10*time.Second,
+		"Duration the leader keeps retrying to renew the lease before giving up leadership.")
+	flag.DurationVar(&retryPeriod, "leader-elect-retry-period", 2*time.Second,
+		"Interval between attempts to acquire or renew the lease.")
+	flag.DurationVar(&gracefulShutdownTimeout, "graceful-shutdown-timeout", 30*time.Second,
+		"Time given to in-flight reconciliations to finish on shutdown before the lease is released.")
+	flag.DurationVar(&shutdownDrainTimeout, "shutdown-drain-timeout", 20*time.Second,
+		"Time in-flight requests to the identity systems are given to finish on shutdown, after which their status is "+
+			"written. Must be shorter than --graceful-shutdown-timeout.")
+	flag.StringVar(&credentialsSecret, "credentials-secret", "",
+		"Secret in namespace/name form holding IDM_USER and IDM_PASS used to log in to the identity system. "+
+			"Changes to the Secret are picked up without restarting the manager.")
+	flag.DurationVar(&requeueBaseDelay, "requeue-base-delay", 5*time.Millisecond,
+		"Initial delay of the exponential backoff applied to objects failing with retryable errors.")
+	flag.DurationVar(&requeueMaxDelay, "requeue-max-delay", 1000*time.Second,
+		"Maximum delay of the exponential backoff applied to objects failing with retryable errors.")
+	flag.DurationVar(&driftResyncPeriod, "drift-resync-period", 10*time.Minute,
+		"Interval after which every User is compared with the identity system again to correct out-of-band changes. "+
+			"Set to 0 to disable periodic resync.")
+	flag.StringVar(&driftIgnoredAttributes, "drift-ignored-attributes", "",
+		"Comma separated custom attributes of users that are not compared with spec.attributes, e.g. attributes "+
+			"maintained by the identity system itself. Their values in the identity system are kept on updates.")
+	flag.DurationVar(&forceFinalizeAfter, "force-finalize-after", 0,
+		"Time after which the finalizer of a deleted User is removed even though deleting the external user keeps failing. "+
+			"Set to 0 to keep the User until the deletion succeeds.")
+	flag.DurationVar(&janitorThreshold, "janitor-threshold", time.Hour,
+		"Time after which a User stuck in Terminating is reported with a StuckTerminating event. "+
+			"Set to 0 to disable the janitor.")
+	flag.BoolVar(&janitorRemoveFinalizers, "janitor-remove-finalizers", false,
+		"Remove the finalizer of Users stuck in Terminating for longer than --janitor-threshold, "+
+			"leaving their external user behind.")
+	flag.BoolVar(&syncServiceAccounts, "sync-service-accounts", false,
+		"Provision a machine user in the identity system for every ServiceAccount annotated with "+
+			idmv1.AnnotationServiceAccountSync+"=true.")
+	flag.StringVar(&bootstrapSecret, "bootstrap-secret", "",
+		"Secret in namespace/name form holding the IDM_USER and IDM_PASS, or IDM_TOKEN, of an admin of the identity system. "+
+			"The operator creates its own account with them, writes its credentials to --credentials-secret and deletes the Secret.")
+	flag.StringVar(&webhookCertDir, "webhook-cert-dir", filepath.Join(os.TempDir(), "k8s-webhook-server", "serving-certs"),
+		"Directory of the tls.crt and tls.key of the webhook server, e.g. mounted from the Secret issued by cert-manager. "+
+			"Changed files are picked up without a restart.")
+	flag.StringVar(&webhookCertSecret, "webhook-cert-secret", "",
+		"Secret in namespace/name form a self-signed webhook serving certificate is generated into when --webhook-cert-dir "+
+			"holds none, e.g. in clusters without cert-manager. Its CA is injected into the webhooks calling --webhook-service "+
+			"and it is rotated before it expires.")
+	flag.StringVar(&webhookService, "webhook-service", "",
+		"Service in namespace/name form the self-signed webhook serving certificate is issued for.")
+	flag.StringVar(&bootstrapUsername, "bootstrap-username", controller.DefaultBootstrapUsername,
+		"Name of the account and role the operator creates for itself from --bootstrap-secret.")
+	flag.DurationVar(&backendProbeInterval, "backend-probe-interval", 30*time.Second,
+		"Interval at which the identity systems are probed for availability. Objects are not reconciled against "+
+			"an unavailable identity system and the operator reports not ready while the default one is unavailable. "+
+			"The default one is only probed when IDM_HOST is set. Set to 0 to disable probing.")
+	flag.Float64Var(&backendFailureThreshold, "backend-failure-threshold", 0.9,
+		"Share of the requests to the identity systems within the last minutes that may fail before the operator "+
+			"reports not ready. Set to 0 to disable the check.")
+	flag.StringVar(&notificationURL, "notification-url", "",
+		"URL the operator posts user created, updated and deleted events to. Requests are signed with the "+
+			"HMAC key in the NOTIFICATION_HMAC_KEY environment variable, if set. Empty disables notifications.")
+	flag.StringVar(&changeFeedAddr, "change-feed-bind-address", "0",
+		"The address the identity system posts change notifications of users to, e.g. :8090. Requests are verified "+
+			"with the HMAC key in the CHANGE_FEED_HMAC_KEY environment variable, if set. Set to 0 to disable the change feed.")
+	flag.BoolVar(&dryRun, "dry-run", false,
+		"Read and compare the identity systems without changing them. Intended creates, updates and deletions "+
+			"are recorded as DryRun events. Objects annotated with idm.micze.io/dry-run=true are always run dry.")
+	flag.StringVar(&operatorConfig, "operator-config", "default",
+		"Name of the cluster-scoped IdentityOperatorConfig whose settings override the flags of the same name. "+
+			"Changes are applied without restarting the manager.")
+	flag.StringVar(&allowedUserRoles, "allowed-user-roles", "admin,user,tester",
+		"Comma separated built-in roles of the operator-level identity system the webhook accepts for Users "+
+			"without an IdentityInstance. Set to an empty value to accept any role.")
+	flag.StringVar(&encryptionKeySecret, "encryption-key-secret", "",
+		"Secret in namespace/name form holding the 32 byte keys that wrap the keys of the passwords encrypted with idmctl encrypt.")
+	flag.StringVar(&encryptionKeyName, "encryption-key-name", encryption.DefaultSecretKeyName,
+		"Key of the --encryption-key-secret new values are encrypted with. Older keys are kept in the Secret to decrypt existing values.")
+	flag.StringVar(&encryptionVaultAddress, "encryption-vault-address", "",
+		"Address of the Vault whose transit secrets engine wraps the keys of encrypted passwords, instead of --encryption-key-secret. "+
+			"Requests are authenticated with the token in the VAULT_TOKEN environment variable.")
+	flag.StringVar(&encryptionVaultMount, "encryption-vault-mount", encryption.DefaultVaultMount,
+		"Path the transit secrets engine is mounted at.")
+	flag.StringVar(&encryptionVaultKey, "encryption-vault-key", "",
+		"Name of the transit key new values are encrypted with.")
+	flag.IntVar(&maxConcurrentReconciles, "max-concurrent-reconciles", 1,
+		"Number of objects each controller reconciles in parallel.")
+	flag.IntVar(&userMaxConcurrentReconciles, "user-max-concurrent-reconciles", 0,
+		"Number of Users reconciled in parallel. Defaults to --max-concurrent-reconciles.")
+	flag.IntVar(&groupMaxConcurrentReconciles, "group-max-concurrent-reconciles", 0,
+		"Number of Groups and GroupBindings reconciled in parallel. Defaults to --max-concurrent-reconciles.")
+	flag.DurationVar(&bulkCreateWindow, "bulk-create-window", 0,
+		"Time a User creation waits for the creations of concurrently reconciled Users to send them in one bulk request "+
+			"to identity systems supporting it. Takes effect with --user-max-concurrent-reconciles above 1. Zero disables batching.")
+	flag.IntVar(&bulkCreateMaxSize, "bulk-create-max-size", 100,
+		"Maximum number of Users created in one bulk request.")
+	flag.Float64Var(&rateLimiterQPS, "rate-limiter-qps", 10,
+		"Overall rate of retries per controller, in requeues per second.")
+	flag.IntVar(&rateLimiterBurst, "rate-limiter-burst", 100,
+		"Burst of retries per controller allowed on top of --rate-limiter-qps.")
+	flag.StringVar(&logLevelConfigMap, "log-level-configmap", "",
+		"ConfigMap in namespace/name form whose logLevel key (debug, info, error or a verbosity such as 2) "+
+			"changes the log level at runtime. Removing the key restores the level given by --zap-log-level.")
+	flag.StringVar(&watchNamespaces, "watch-namespaces", "",
+		"Comma separated namespaces whose Users, Roles, Groups, GroupBindings, IdentityImports and ApiKeys are reconciled. "+
+			"Defaults to all namespaces.")
+	flag.StringVar(&userScope, "user-scope", string(controller.UserScopeNamespaced),
+		"Scope the User CRD is installed with, Namespaced or Cluster. Cluster-scoped Users need the CRD patched with "+
+			"config/crd/patches/cluster_scope_in_users.yaml.")
+	flag.StringVar(&clusterUserNamespace, "cluster-user-namespace", "",
+		"Namespace of the Secrets and Roles referenced by cluster-scoped Users and of the Secrets created for them. "+
+			"Required with --user-scope=Cluster.")
+	flag.StringVar(&watchLabelSelector, "watch-label-selector", "",
+		"Label selector, e.g. team=payments, restricting the Users that are reconciled. Defaults to all Users.")
 	opts := zap.Options{
 		Development: true,
 	}
 	opts.BindFlags(flag.CommandLine)
 	flag.Parse()
 
+	// Keep a handle on the log level so it can be changed at runtime
+	logLevel := uberzap.NewAtomicLevelAt(uberzap.InfoLevel)
+	if opts.Development {
+		logLevel.SetLevel(uberzap.DebugLevel)
+	}
+	if level, ok := opts.Level.(uberzap.AtomicLevel); ok {
+		logLevel = level
+	}
+	defaultLogLevel := logLevel.Level()
+	opts.Level = logLevel
+
 	ctrl.SetLogger(zap.New(zap.UseFlagOptions(&opts)))
 
+	switch controller.UserScope(userScope) {
+	case controller.UserScopeNamespaced:
+		clusterUserNamespace = ""
+	case controller.UserScopeCluster:
+		if clusterUserNamespace == "" {
+			setupLog.Error(nil, "--cluster-user-namespace is required with --user-scope=Cluster")
+			os.Exit(1)
+		}
+	default:
+		setupLog.Error(nil, "invalid --user-scope, expected Namespaced or Cluster", "value", userScope)
+		os.Exit(1)
+	}
+
+	cacheOptions, err := watchCacheOptions(watchNamespaces, watchLabelSelector, controller.UserScope(userScope))
+	if err != nil {
+		setupLog.Error(err, "invalid watch filter")
+		os.Exit(1)
+	}
+
+	// Without a mounted certificate the webhook server serves a self-signed one
+	var certGenerator *webhookcert.Generator
+	if os.Getenv("ENABLE_WEBHOOKS") != "false" && webhookCertSecret != "" && !webhookcert.Mounted(webhookCertDir) {
+		secretNamespace, secretName, ok := strings.Cut(webhookCertSecret, "/")
+		if !ok || secretNamespace == "" || secretName == "" {
+			setupLog.Error(nil, "invalid --webhook-cert-secret, expected namespace/name", "value", webhookCertSecret)
+			os.Exit(1)
+		}
+		serviceNamespace, serviceName, ok := strings.Cut(webhookService, "/")
+		if !ok || serviceNamespace == "" || serviceName == "" {
+			setupLog.Error(nil, "invalid --webhook-service, expected namespace/name", "value", webhookService)
+			os.Exit(1)
+		}
+		secret := types.NamespacedName{Namespace: secretNamespace, Name: secretName}
+		service := types.NamespacedName{Namespace: serviceNamespace, Name: serviceName}
+		certClient, err := client.New(ctrl.GetConfigOrDie(), client.Options{Scheme: scheme})
+		if err != nil {
+			setupLog.Error(err, "unable to create client")
+			os.Exit(1)
+		}
+		webhookCertDir = filepath.Join(os.TempDir(), "idm-webhook-serving-certs")
+		certGenerator = &webhookcert.Generator{
+			Client:  certClient,
+			Secret:  secret,
+			Service: service,
+			CertDir: webhookCertDir,
+		}
+		if err := certGenerator.Ensure(ctrl.LoggerInto(context.Background(), setupLog)); err != nil {
+			setupLog.Error(err, "unable to generate webhook serving certificate")
+			os.Exit(1)
+		}
+		setupLog.Info("Serving webhooks with a self-signed certificate", "secret", secret)
+	}
+
 	mgr, err := ctrl.NewManager(ctrl.GetConfigOrDie(), ctrl.Options{
-		Scheme:                 scheme,
-		Metrics:                metricsserver.Options{BindAddress: metricsAddr},
-		HealthProbeBindAddress: probeAddr,
-		LeaderElection:         enableLeaderElection,
-		LeaderElectionID:       "1e35a793.micze.io",
-		// LeaderElectionReleaseOnCancel defines if the leader should step down voluntarily
-		// when the Manager ends. This requires the binary to immediately end when the
-		// Manager is stopped, otherwise, this setting is unsafe. Setting this significantly
-		// speeds up voluntary leader transitions as the new leader don't have to wait
-		// LeaseDuration time first.
-		//
-		// In the default scaffold provided, the program ends immediately after
-		// the manager stops, so would be fine to enable this option. However,
-		// if you are doing or is intended to do any operation such as perform cleanups
-		// after the manager stops then its usage might be unsafe.
-		// LeaderElectionReleaseOnCancel: true,
+		Scheme:                  scheme,
+		Cache:                   cacheOptions,
+		Metrics:                 metricsserver.Options{BindAddress: metricsAddr},
+		WebhookServer:           webhook.NewServer(webhook.Options{CertDir: webhookCertDir}),
+		HealthProbeBindAddress:  probeAddr,
+		LeaderElection:          enableLeaderElection,
+		LeaderElectionID:        "1e35a793.micze.io",
+		LeaderElectionNamespace: leaderElectionNamespace,
+		LeaseDuration:           &leaseDuration,
+		RenewDeadline:           &renewDeadline,
+		RetryPeriod:             &retryPeriod,
+		// The manager waits up to GracefulShutdownTimeout for in-flight reconciliations
+		// and then releases the lease, so another replica takes over without waiting
+		// LeaseDuration. This is safe since the program ends right after the manager stops.
+		LeaderElectionReleaseOnCancel: true,
+		GracefulShutdownTimeout:       &gracefulShutdownTimeout,
 	})
 	if err != nil {
 		setupLog.Error(err, "unable to start manager")
 		os.Exit(1)
 	}
+	if err := controller.CheckUserScope(mgr.GetRESTMapper(), controller.UserScope(userScope)); err != nil {
+		setupLog.Error(err, "invalid --user-scope")
+		os.Exit(1)
+	}
 
-	if err = (&controller.UserReconciler{
+	var credentialsSecretName types.NamespacedName
+	if credentialsSecret != "" {
+		namespace, name, ok := strings.Cut(credentialsSecret, "/")
+		if !ok || namespace == "" || name == "" {
+			setupLog.Error(nil, "invalid --credentials-secret, expected namespace/name", "value", credentialsSecret)
+			os.Exit(1)
+		}
+		credentialsSecretName = types.NamespacedName{Namespace: namespace, Name: name}
+	}
+
+	var bootstrapSecretName types.NamespacedName
+	if bootstrapSecret != "" {
+		namespace, name, ok := strings.Cut(bootstrapSecret, "/")
+		if !ok || namespace == "" || name == "" {
+			setupLog.Error(nil, "invalid --bootstrap-secret, expected namespace/name", "value", bootstrapSecret)
+			os.Exit(1)
+		}
+		if credentialsSecret == "" {
+			setupLog.Error(nil, "--bootstrap-secret requires --credentials-secret")
+			os.Exit(1)
+		}
+		bootstrapSecretName = types.NamespacedName{Namespace: namespace, Name: name}
+	}
+
+	if logLevelConfigMap != "" {
+		namespace, name, ok := strings.Cut(logLevelConfigMap, "/")
+		if !ok || namespace == "" || name == "" {
+			setupLog.Error(nil, "invalid --log-level-configmap, expected namespace/name", "value", logLevelConfigMap)
+			os.Exit(1)
+		}
+		if err = (&controller.LogLevelReconciler{
+			Client:    mgr.GetClient(),
+			ConfigMap: types.NamespacedName{Namespace: namespace, Name: name},
+			Level:     logLevel,
+			Default:   defaultLogLevel,
+		}).SetupWithManager(mgr); err != nil {
+			setupLog.Error(err, "unable to create controller", "controller", "LogLevel")
+			os.Exit(1)
+		}
+	}
+
+	config := &controller.OperatorConfig{}
+	if err = (&controller.IdentityOperatorConfigReconciler{
 		Client: mgr.GetClient(),
 		Scheme: mgr.GetScheme(),
+		Name:   operatorConfig,
+		Config: config,
+	}).SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "IdentityOperatorConfig")
+		os.Exit(1)
+	}
+
+	controllerOptions := controller.ControllerOptions{
+		MaxConcurrentReconciles: maxConcurrentReconciles,
+		RequeueBaseDelay:        requeueBaseDelay,
+		RequeueMaxDelay:         requeueMaxDelay,
+		RateLimiterQPS:          rateLimiterQPS,
+		RateLimiterBurst:        rateLimiterBurst,
+		DryRun:                  dryRun,
+		Config:                  config,
+	}
+
+	var envelope *encryption.Envelope
+	switch {
+	case encryptionKeySecret != "" && encryptionVaultAddress != "":
+		setupLog.Error(nil, "--encryption-key-secret and --encryption-vault-address are mutually exclusive")
+		os.Exit(1)
+	case encryptionKeySecret != "":
+		namespace, name, ok := strings.Cut(encryptionKeySecret, "/")
+		if !ok || namespace == "" || name == "" {
+			setupLog.Error(nil, "invalid --encryption-key-secret, expected namespace/name", "value", encryptionKeySecret)
+			os.Exit(1)
+		}
+		envelope = &encryption.Envelope{Wrapper: &encryption.SecretKeyWrapper{
+			Reader:  mgr.GetClient(),
+			Secret:  types.NamespacedName{Namespace: namespace, Name: name},
+			KeyName: encryptionKeyName,
+		}}
+	case encryptionVaultAddress != "":
+		envelope = &encryption.Envelope{Wrapper: &encryption.VaultTransitWrapper{
+			Address: encryptionVaultAddress,
+			Mount:   encryptionVaultMount,
+			Key:     encryptionVaultKey,
+			Token:   os.Getenv("VAULT_TOKEN"),
+		}}
+	}
+
+	// spans of the reconciliations and the identity API requests are exported when the
+	// OTEL_* environment variables configure an exporter
+	traceProvider, err := tracing.NewProviderFromEnvironment(context.Background())
+	if err != nil {
+		setupLog.Error(err, "invalid tracing configuration")
+		os.Exit(1)
+	}
+	if traceProvider != nil {
+		if err := mgr.Add(traceProvider); err != nil {
+			setupLog.Error(err, "unable to set up tracing")
+			os.Exit(1)
+		}
+		tracing.SetProvider(traceProvider)
+		setupLog.Info("Exporting traces", "endpoint", traceProvider.Endpoint())
+	}
+
+	identityConfig := idmsvc.NewIdentityConfig()
+	if err := identityConfig.Err(); err != nil {
+		setupLog.Error(err, "invalid identity app configuration")
+		os.Exit(1)
+	}
+	identityService := idmsvc.NewIdentityService(&identityConfig)
+
+	var notifier notify.Notifier
+	if notificationURL != "" {
+		webhook := notify.NewWebhook(notificationURL, []byte(os.Getenv("NOTIFICATION_HMAC_KEY")))
+		if err := mgr.Add(webhook); err != nil {
+			setupLog.Error(err, "unable to set up notifications")
+			os.Exit(1)
+		}
+		notifier = webhook
+	}
+
+	var changeFeed *controller.ChangeFeed
+	if changeFeedAddr != "0" {
+		changeFeed = &controller.ChangeFeed{
+			Addr:   changeFeedAddr,
+			Secret: []byte(os.Getenv("CHANGE_FEED_HMAC_KEY")),
+			Reader: mgr.GetClient(),
+		}
+		if err := mgr.Add(changeFeed); err != nil {
+			setupLog.Error(err, "unable to set up change feed")
+			os.Exit(1)
+		}
+	}
+
+	var ignoredAttributes []string
+	for _, attribute := range strings.Split(driftIgnoredAttributes, ",") {
+		if attribute = strings.TrimSpace(attribute); attribute != "" {
+			ignoredAttributes = append(ignoredAttributes, attribute)
+		}
+	}
+	if err = (&controller.UserReconciler{
+		Client:                 mgr.GetClient(),
+		Scheme:                 mgr.GetScheme(),
+		Recorder:               mgr.GetEventRecorderFor("user-controller"),
+		IdentityService:        identityService,
+		Options:                controllerOptions.WithMaxConcurrentReconciles(userMaxConcurrentReconciles),
+		DriftResyncPeriod:      driftResyncPeriod,
+		DriftIgnoredAttributes: ignoredAttributes,
+		CredentialsSecret:      credentialsSecretName,
+		ForceFinalizeAfter:     forceFinalizeAfter,
+		Notifier:               notifier,
+		ChangeFeed:             changeFeed,
+		Encryption:             envelope,
+		Batcher:                &controller.UserBatcher{Window: bulkCreateWindow, MaxSize: bulkCreateMaxSize},
+		ClusterUserNamespace:   clusterUserNamespace,
 	}).SetupWithManager(mgr); err != nil {
 		setupLog.Error(err, "unable to create controller", "controller", "User")
 		os.Exit(1)
 	}
+	if err = (&controller.RoleReconciler{
+		Client:            mgr.GetClient(),
+		Scheme:            mgr.GetScheme(),
+		Recorder:          mgr.GetEventRecorderFor("role-controller"),
+		IdentityService:   identityService,
+		Options:           controllerOptions,
+		DriftResyncPeriod: driftResyncPeriod,
+		CredentialsSecret: credentialsSecretName,
+	}).SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "Role")
+		os.Exit(1)
+	}
+	if err = (&controller.GroupReconciler{
+		Client:            mgr.GetClient(),
+		Scheme:            mgr.GetScheme(),
+		Recorder:          mgr.GetEventRecorderFor("group-controller"),
+		IdentityService:   identityService,
+		Options:           controllerOptions.WithMaxConcurrentReconciles(groupMaxConcurrentReconciles),
+		DriftResyncPeriod: driftResyncPeriod,
+		CredentialsSecret: credentialsSecretName,
+	}).SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "Group")
+		os.Exit(1)
+	}
+	if err = (&controller.GroupBindingReconciler{
+		Client:            mgr.GetClient(),
+		Scheme:            mgr.GetScheme(),
+		Recorder:          mgr.GetEventRecorderFor("groupbinding-controller"),
+		IdentityService:   identityService,
+		Options:           controllerOptions.WithMaxConcurrentReconciles(groupMaxConcurrentReconciles),
+		DriftResyncPeriod: driftResyncPeriod,
+		CredentialsSecret: credentialsSecretName,
+	}).SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "GroupBinding")
+		os.Exit(1)
+	}
+	if err = (&controller.UserRoleBindingReconciler{
+		Client:            mgr.GetClient(),
+		Scheme:            mgr.GetScheme(),
+		Recorder:          mgr.GetEventRecorderFor("userrolebinding-controller"),
+		IdentityService:   identityService,
+		Options:           controllerOptions,
+		DriftResyncPeriod: driftResyncPeriod,
+		CredentialsSecret: credentialsSecretName,
+	}).SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "UserRoleBinding")
+		os.Exit(1)
+	}
+	if err = (&controller.IdentityInstanceReconciler{
+		Client:        mgr.GetClient(),
+		Scheme:        mgr.GetScheme(),
+		Recorder:      mgr.GetEventRecorderFor("identityinstance-controller"),
+		ProbeInterval: backendProbeInterval,
+	}).SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "IdentityInstance")
+		os.Exit(1)
+	}
+	if err = (&controller.IdentityAuditReconciler{
+		Client:            mgr.GetClient(),
+		Scheme:            mgr.GetScheme(),
+		IdentityService:   identityService,
+		CredentialsSecret: credentialsSecretName,
+		Config:            config,
+	}).SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "IdentityAudit")
+		os.Exit(1)
+	}
+	if err = (&controller.IdentityImportReconciler{
+		Client:            mgr.GetClient(),
+		Scheme:            mgr.GetScheme(),
+		Recorder:          mgr.GetEventRecorderFor("identityimport-controller"),
+		IdentityService:   identityService,
+		CredentialsSecret: credentialsSecretName,
+		Config:            config,
+	}).SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "IdentityImport")
+		os.Exit(1)
+	}
+	if err = (&controller.ApiKeyReconciler{
+		Client:            mgr.GetClient(),
+		Scheme:            mgr.GetScheme(),
+		Recorder:          mgr.GetEventRecorderFor("apikey-controller"),
+		IdentityService:   identityService,
+		Options:           controllerOptions,
+		CredentialsSecret: credentialsSecretName,
+	}).SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "ApiKey")
+		os.Exit(1)
+	}
+	// UserTemplates own the Users they generate, which a namespaced object cannot do for
+	// cluster-scoped ones
+	if controller.UserScope(userScope) == controller.UserScopeNamespaced {
+		if err = (&controller.UserTemplateReconciler{
+			Client:   mgr.GetClient(),
+			Scheme:   mgr.GetScheme(),
+			Recorder: mgr.GetEventRecorderFor("usertemplate-controller"),
+			Options:  controllerOptions,
+		}).SetupWithManager(mgr); err != nil {
+			setupLog.Error(err, "unable to create controller", "controller", "UserTemplate")
+			os.Exit(1)
+		}
+	}
+	if err = (&controller.IdentityQuotaReconciler{
+		Client:  mgr.GetClient(),
+		Scheme:  mgr.GetScheme(),
+		Options: controllerOptions,
+	}).SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "IdentityQuota")
+		os.Exit(1)
+	}
+	if janitorThreshold > 0 {
+		if err = (&controller.UserJanitorReconciler{
+			Client:           mgr.GetClient(),
+			Recorder:         mgr.GetEventRecorderFor("user-janitor"),
+			Threshold:        janitorThreshold,
+			RemoveFinalizers: janitorRemoveFinalizers,
+		}).SetupWithManager(mgr); err != nil {
+			setupLog.Error(err, "unable to create controller", "controller", "UserJanitor")
+			os.Exit(1)
+		}
+	}
+	if syncServiceAccounts {
+		if err = (&controller.ServiceAccountReconciler{
+			Client:            mgr.GetClient(),
+			Scheme:            mgr.GetScheme(),
+			Recorder:          mgr.GetEventRecorderFor("serviceaccount-controller"),
+			IdentityService:   identityService,
+			Options:           controllerOptions,
+			DriftResyncPeriod: driftResyncPeriod,
+			CredentialsSecret: credentialsSecretName,
+		}).SetupWithManager(mgr); err != nil {
+			setupLog.Error(err, "unable to create controller", "controller", "ServiceAccount")
+			os.Exit(1)
+		}
+	}
+	if bootstrapSecret != "" {
+		if err = (&controller.BootstrapReconciler{
+			Client:            mgr.GetClient(),
+			Recorder:          mgr.GetEventRecorderFor("bootstrap-controller"),
+			IdentityService:   identityService,
+			BootstrapSecret:   bootstrapSecretName,
+			CredentialsSecret: credentialsSecretName,
+			Username:          bootstrapUsername,
+		}).SetupWithManager(mgr); err != nil {
+			setupLog.Error(err, "unable to create controller", "controller", "Bootstrap")
+			os.Exit(1)
+		}
+	}
+	if os.Getenv("ENABLE_WEBHOOKS") != "false" {
+		var allowedRoles []string
+		for _, role := range strings.Split(allowedUserRoles, ",") {
+			if role = strings.TrimSpace(role); role != "" {
+				allowedRoles = append(allowedRoles, role)
+			}
+		}
+		if err = (&idmv1.User{}).SetupWebhookWithManager(mgr, operatorConfig, allowedRoles); err != nil {
+			setupLog.Error(err, "unable to create webhook", "webhook", "User")
+			os.Exit(1)
+		}
+		if err = (&idmv1.Group{}).SetupWebhookWithManager(mgr); err != nil {
+			setupLog.Error(err, "unable to create webhook", "webhook", "Group")
+			os.Exit(1)
+		}
+	}
 	//+kubebuilder:scaffold:builder
 
 	if err := mgr.AddHealthzCheck("healthz", healthz.Ping); err != nil {
@@ -106,6 +670,64 @@ func main() {
 		setupLog.Error(err, "unable to set up ready check")
 		os.Exit(1)
 	}
+	if os.Getenv("ENABLE_WEBHOOKS") != "false" {
+		// admission requests fail until the webhook server serves its certificate
+		if err := mgr.AddHealthzCheck("webhook", mgr.GetWebhookServer().StartedChecker()); err != nil {
+			setupLog.Error(err, "unable to set up webhook health check")
+			os.Exit(1)
+		}
+		if err := mgr.AddReadyzCheck("webhook", mgr.GetWebhookServer().StartedChecker()); err != nil {
+			setupLog.Error(err, "unable to set up webhook ready check")
+			os.Exit(1)
+		}
+	}
+	if shutdownDrainTimeout >= gracefulShutdownTimeout {
+		setupLog.Error(nil, "--shutdown-drain-timeout must be shorter than --graceful-shutdown-timeout",
+			"drainTimeout", shutdownDrainTimeout, "gracefulShutdownTimeout", gracefulShutdownTimeout)
+		os.Exit(1)
+	}
+	if err := mgr.Add(&controller.ShutdownDrainer{Timeout: shutdownDrainTimeout}); err != nil {
+		setupLog.Error(err, "unable to set up shutdown drain")
+		os.Exit(1)
+	}
+	if certGenerator != nil {
+		if err := mgr.Add(certGenerator); err != nil {
+			setupLog.Error(err, "unable to set up webhook certificate rotation")
+			os.Exit(1)
+		}
+	}
+	if err := mgr.AddReadyzCheck("informer-cache", cacheSyncCheck(mgr.GetCache())); err != nil {
+		setupLog.Error(err, "unable to set up informer cache check")
+		os.Exit(1)
+	}
+	if err := mgr.AddReadyzCheck("identity-backend-failures", controller.BackendFailureChecker(backendFailureThreshold)); err != nil {
+		setupLog.Error(err, "unable to set up backend failure check")
+		os.Exit(1)
+	}
+	backendProber := &controller.BackendProber{
+		Service:    identityService,
+		Configured: identityConfig.HasHost(),
+		Interval:   backendProbeInterval,
+	}
+	if err := mgr.Add(backendProber); err != nil {
+		setupLog.Error(err, "unable to set up backend prober")
+		os.Exit(1)
+	}
+	if err := mgr.AddReadyzCheck("identity-backend", backendProber.Checker); err != nil {
+		setupLog.Error(err, "unable to set up backend check")
+		os.Exit(1)
+	}
+	if enableLeaderElection {
+		// only the leader reconciles, standby replicas report not ready on /readyz/leader until
+		// elected. The readiness probe of the Deployment excludes the check: standby replicas
+		// serve the webhooks, and a rollout would wait forever for a new replica that cannot
+		// be elected while the old leader holds the lease.
+		if err := mgr.AddReadyzCheck("leader", leaderCheck(mgr.Elected())); err != nil {
+			setupLog.Error(err, "unable to set up leader check")
+			os.Exit(1)
+		}
+	}
+	controller.RecordLeadership(mgr.Elected())
 
 	setupLog.Info("starting manager")
 	if err := mgr.Start(ctrl.SetupSignalHandler()); err != nil {
@@ -113,3 +735,79 @@ func main() {
 		os.Exit(1)
 	}
 }
+
+// leaderCheck reports ready once the manager was elected leader
+func leaderCheck(elected <-chan struct{}) healthz.Checker {
+	return func(_ *http.Request) error {
+		select {
+		case <-elected:
+			return nil
+		default:
+			return errors.New("not the leader")
+		}
+	}
+}
+
+// cacheSyncCheck reports ready once the informers of the watched objects are synced, so a
+// new replica is not considered ready while it would act on an incomplete view
+func cacheSyncCheck(informers cache.Cache) healthz.Checker {
+	return func(req *http.Request) error {
+		ctx, cancel := context.WithTimeout(req.Context(), time.Second)
+		defer cancel()
+		if !informers.WaitForCacheSync(ctx) {
+			return errors.New("informer caches are not synced")
+		}
+		return nil
+	}
+}
+
+// watchCacheOptions restricts the cache, and thereby the reconciled objects, to the managed
+// objects in the given namespaces and the Users matching the label selector. Secrets,
+// ConfigMaps and cluster-scoped objects are cached in all namespaces.
+func watchCacheOptions(namespaces, labelSelector string, userScope controller.UserScope) (cache.Options, error) {
+	var watched map[string]cache.Config
+	for _, namespace := range strings.Split(namespaces, ",") {
+		namespace = strings.TrimSpace(namespace)
+		if namespace == "" {
+			continue
+		}
+		if watched == nil {
+			watched = map[string]cache.Config{}
+		}
+		watched[namespace] = cache.Config{}
+	}
+
+	var selector labels.Selector
+	if labelSelector != "" {
+		var err error
+		selector, err = labels.Parse(labelSelector)
+		if err != nil {
+			return cache.Options{}, fmt.Errorf("invalid --watch-label-selector: %w", err)
+		}
+	}
+
+	if watched == nil && selector == nil {
+		return cache.Options{}, nil
+	}
+
+	// cluster-scoped Users are cached regardless of their namespace
+	userNamespaces := watched
+	if userScope == controller.UserScopeCluster {
+		userNamespaces = nil
+	}
+
+	return cache.Options{
+		ByObject: map[client.Object]cache.ByObject{
+			&idmv1.User{}:            {Namespaces: userNamespaces, Label: selector},
+			&idmv1.Role{}:            {Namespaces: watched},
+			&idmv1.Group{}:           {Namespaces: watched},
+			&idmv1.GroupBinding{}:    {Namespaces: watched},
+			&idmv1.IdentityImport{}:  {Namespaces: watched},
+			&idmv1.ApiKey{}:          {Namespaces: watched},
+			&idmv1.UserTemplate{}:    {Namespaces: watched},
+			&idmv1.IdentityQuota{}:   {Namespaces: watched},
+			&idmv1.UserRoleBinding{}: {Namespaces: watched},
+			&corev1.ServiceAccount{}: {Namespaces: watched},
+		},
+	}, nil
+}
@@ -0,0 +1,272 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	idmv1 "github.com/m15ch4/go-identity-operator/api/v1"
+	"github.com/m15ch4/go-identity-operator/internal/status"
+	idmsvc "github.com/m15ch4/go-identity-operator/pkg/identityclient"
+)
+
+const apiKeyFinalizer = "micze.io/apikey-finalizer"
+
+// ApiKeyReconciler reconciles an ApiKey object
+type ApiKeyReconciler struct {
+	client.Client
+	Scheme *runtime.Scheme
+
+	// Recorder emits Events on ApiKeys
+	Recorder record.EventRecorder
+
+	// IdentityService is the long-lived service used for ApiKeys without an instanceRef
+	IdentityService idmsvc.IdentityAPI
+
+	// Options tunes the workers and the rate limiter of the controller
+	Options ControllerOptions
+
+	// CredentialsSecret optionally references a Secret with IDM_USER and IDM_PASS keys
+	// used to log in to the identity system
+	CredentialsSecret types.NamespacedName
+}
+
+//+kubebuilder:rbac:groups=idm.micze.io,resources=apikeys,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=idm.micze.io,resources=apikeys/status,verbs=get;update;patch
+//+kubebuilder:rbac:groups=idm.micze.io,resources=apikeys/finalizers,verbs=update
+
+// Reconcile provisions the machine credentials in the identity system, keeps the generated
+// key in the Secret of the ApiKey, rotates it when due and revokes it on deletion.
+func (r *ApiKeyReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	log := log.FromContext(ctx)
+
+	apiKey := &idmv1.ApiKey{}
+	err := r.Get(ctx, req.NamespacedName, apiKey)
+	if err != nil {
+		if errors.IsNotFound(err) {
+			log.Info("ApiKey resource not found. Ignoring since object must be deleted")
+			return ctrl.Result{}, nil
+		}
+		log.Error(err, "Failed to get ApiKey")
+		return ctrl.Result{}, err
+	}
+	original := apiKey.DeepCopy()
+
+	// Leave the identity system alone while paused, revocation waits for the resume
+	isPaused := paused(apiKey, apiKey.Spec.Paused)
+	setPaused(&apiKey.Status.Conditions, apiKey.Generation, isPaused)
+	if isPaused {
+		log.Info("Reconciliation is paused")
+		if !equality.Semantic.DeepEqual(original.Status, apiKey.Status) {
+			err = patchStatus(ctx, r.Client, apiKey, original)
+			if err != nil {
+				log.Info("Failed to update apikey status")
+				return ctrl.Result{}, err
+			}
+		}
+		return ctrl.Result{}, nil
+	}
+
+	svc, err := identityServiceFor(ctx, r.Client, apiKey.Namespace, r.Options.Config.instanceRef(apiKey.Spec.InstanceRef), r.IdentityService, r.CredentialsSecret)
+	if err != nil {
+		r.setDegraded(ctx, apiKey, original, "ConfigurationFailed", err)
+		return requeueFor(ctx, err)
+	}
+	svc = withDryRun(svc, apiKey, r.Recorder, r.Options)
+
+	// Revoke the key before letting the ApiKey go, the Secret is garbage collected
+	if !apiKey.ObjectMeta.DeletionTimestamp.IsZero() {
+		if containsString(apiKey.GetFinalizers(), apiKeyFinalizer) {
+			if apiKey.Status.ID != "" {
+				err := svc.DeleteAPIKey(ctx, apiKey.Status.ID)
+				if err != nil && !idmsvc.IsNotFound(err) {
+					r.setDegraded(ctx, apiKey, original, "RevokeFailed", err)
+					return requeueFor(ctx, err)
+				}
+				r.Recorder.Eventf(apiKey, corev1.EventTypeNormal, "ApiKeyRevoked", "Revoked key %s in identity system", apiKey.Status.ID)
+			}
+
+			err = patchWithRetry(ctx, r.Client, apiKey, func() {
+				controllerutil.RemoveFinalizer(apiKey, apiKeyFinalizer)
+			})
+			if err != nil {
+				return ctrl.Result{}, err
+			}
+		}
+		return ctrl.Result{}, nil
+	}
+
+	// Protect the key before it is created, so it is never leaked by a deletion in between
+	if !containsString(apiKey.GetFinalizers(), apiKeyFinalizer) {
+		if err := patchWithRetry(ctx, r.Client, apiKey, func() {
+			controllerutil.AddFinalizer(apiKey, apiKeyFinalizer)
+		}); err != nil {
+			return ctrl.Result{}, err
+		}
+	}
+
+	// Terminal errors are not retried until the spec changes
+	if stalled := status.Stalled(apiKey); stalled != nil {
+		log.Info("ApiKey is stalled, waiting for a spec change", "reason", stalled.Reason)
+		return ctrl.Result{}, nil
+	}
+
+	stored, err := r.storedKey(ctx, apiKey)
+	if err != nil {
+		r.setDegraded(ctx, apiKey, original, "SecretFailed", err)
+		return requeueFor(ctx, err)
+	}
+
+	var generated *idmsvc.IdentityAPIKey
+	switch {
+	case apiKey.Status.ID == "":
+		log.Info("Creating api key")
+		generated, err = svc.CreateAPIKey(ctx, &apiKey.Spec)
+		if err != nil {
+			r.setDegraded(ctx, apiKey, original, "CreateFailed", err)
+			return requeueFor(ctx, err)
+		}
+		apiKey.Status.ID = generated.ID
+		r.Recorder.Eventf(apiKey, corev1.EventTypeNormal, "ApiKeyCreated", "Created key %s in identity system", generated.ID)
+	case !stored || apiKeyRotationDue(apiKey):
+		// the key cannot be read back, a lost Secret is repopulated with a fresh key
+		log.Info("Rotating api key", "secretPopulated", stored)
+		generated, err = svc.RotateAPIKey(ctx, apiKey.Status.ID)
+		if idmsvc.IsNotFound(err) {
+			// the key was revoked out of band, create a new one on the next reconcile
+			log.Info("Api key not found in identity system, recreating it", "id", apiKey.Status.ID)
+			apiKey.Status.ID = ""
+			apiKey.Status.LastRotationTime = nil
+			err = patchStatus(ctx, r.Client, apiKey, original)
+			return ctrl.Result{Requeue: true}, err
+		}
+		if err != nil {
+			r.setDegraded(ctx, apiKey, original, "RotateFailed", err)
+			return requeueFor(ctx, err)
+		}
+		r.Recorder.Eventf(apiKey, corev1.EventTypeNormal, "ApiKeyRotated", "Rotated key %s, stored in secret %s", apiKey.Status.ID, apiKey.Spec.SecretName)
+	}
+
+	if generated != nil {
+		err = r.writeSecret(ctx, apiKey, generated)
+		if err != nil {
+			// the status keeps the ID, so the next reconcile rotates the unknown key
+			r.setDegraded(ctx, apiKey, original, "SecretFailed", err)
+			return requeueFor(ctx, err)
+		}
+		now := metav1.Now()
+		apiKey.Status.LastRotationTime = &now
+	}
+	status.MarkSynced(apiKey, "Provisioned", "Key is stored in secret "+apiKey.Spec.SecretName)
+
+	if !equality.Semantic.DeepEqual(original.Status, apiKey.Status) {
+		err = patchStatus(ctx, r.Client, apiKey, original)
+		if err != nil {
+			log.Info("Failed to update apikey status")
+			return ctrl.Result{}, err
+		}
+	}
+
+	if next, ok := nextAPIKeyRotation(apiKey); ok {
+		return ctrl.Result{RequeueAfter: time.Until(next)}, nil
+	}
+	return ctrl.Result{}, nil
+}
+
+// storedKey reports whether the Secret of the ApiKey holds a key
+func (r *ApiKeyReconciler) storedKey(ctx context.Context, apiKey *idmv1.ApiKey) (bool, error) {
+	secret := &corev1.Secret{}
+	err := r.Get(ctx, types.NamespacedName{Namespace: apiKey.Namespace, Name: apiKey.Spec.SecretName}, secret)
+	if errors.IsNotFound(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return len(secret.Data[idmv1.APIKeySecretKeyKey]) > 0, nil
+}
+
+// writeSecret stores the name and the generated key in the Secret owned by the ApiKey
+func (r *ApiKeyReconciler) writeSecret(ctx context.Context, apiKey *idmv1.ApiKey, generated *idmsvc.IdentityAPIKey) error {
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Namespace: apiKey.Namespace, Name: apiKey.Spec.SecretName},
+	}
+	_, err := controllerutil.CreateOrUpdate(ctx, r.Client, secret, func() error {
+		secret.Data = map[string][]byte{
+			idmv1.APIKeySecretNameKey: []byte(apiKey.Spec.Name),
+			idmv1.APIKeySecretKeyKey:  []byte(generated.Key),
+		}
+		return controllerutil.SetControllerReference(apiKey, secret, r.Scheme)
+	})
+	return err
+}
+
+// nextAPIKeyRotation returns the time the key is rotated next
+func nextAPIKeyRotation(apiKey *idmv1.ApiKey) (time.Time, bool) {
+	rotation := apiKey.Spec.Rotation
+	if rotation == nil || !rotation.Enabled || apiKey.Status.LastRotationTime == nil {
+		return time.Time{}, false
+	}
+
+	interval := rotation.Interval.Duration
+	if interval <= 0 {
+		interval = defaultRotationInterval
+	}
+	return apiKey.Status.LastRotationTime.Add(interval), true
+}
+
+// apiKeyRotationDue reports whether the key has to be rotated now
+func apiKeyRotationDue(apiKey *idmv1.ApiKey) bool {
+	next, ok := nextAPIKeyRotation(apiKey)
+	return ok && !time.Now().Before(next)
+}
+
+// setDegraded records the failure on the apikey status; errors updating the status are only logged
+func (r *ApiKeyReconciler) setDegraded(ctx context.Context, apiKey, original *idmv1.ApiKey, reason string, cause error) {
+	log := log.FromContext(ctx)
+
+	r.Recorder.Event(apiKey, corev1.EventTypeWarning, "ExternalAPIError", cause.Error())
+
+	reason = failureReason(cause, reason)
+	status.MarkFailed(apiKey, reason, cause.Error(), idmsvc.IsTerminal(cause))
+
+	if err := patchStatus(ctx, r.Client, apiKey, original); err != nil {
+		log.Error(err, "Failed to update apikey status")
+	}
+}
+
+// SetupWithManager sets up the controller with the Manager.
+func (r *ApiKeyReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&idmv1.ApiKey{}).
+		Owns(&corev1.Secret{}).
+		WithOptions(r.Options.controllerOptions()).
+		Complete(withDrain(withCorrelationID(r)))
+}
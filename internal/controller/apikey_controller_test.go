@@ -0,0 +1,131 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	idmv1 "github.com/m15ch4/go-identity-operator/api/v1"
+	"github.com/m15ch4/go-identity-operator/pkg/identityclient/fake"
+)
+
+var _ = Describe("ApiKey controller", func() {
+	var (
+		ctx        context.Context
+		svc        *fake.IdentityService
+		reconciler *ApiKeyReconciler
+		apiKey     *idmv1.ApiKey
+	)
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		svc = fake.NewIdentityService()
+		reconciler = &ApiKeyReconciler{
+			Client:          k8sClient,
+			Scheme:          k8sClient.Scheme(),
+			Recorder:        record.NewFakeRecorder(100),
+			IdentityService: svc,
+		}
+
+		apiKey = &idmv1.ApiKey{
+			ObjectMeta: metav1.ObjectMeta{GenerateName: "apikey-", Namespace: "default"},
+			Spec: idmv1.ApiKeySpec{
+				Name:       "ci",
+				SecretName: "ci-apikey",
+				Rotation:   &idmv1.APIKeyRotation{Enabled: true, Interval: metav1.Duration{Duration: time.Hour}},
+			},
+		}
+		Expect(k8sClient.Create(ctx, apiKey)).To(Succeed())
+	})
+
+	AfterEach(func() {
+		current := &idmv1.ApiKey{}
+		err := k8sClient.Get(ctx, client.ObjectKeyFromObject(apiKey), current)
+		if err == nil {
+			current.SetFinalizers(nil)
+			Expect(k8sClient.Update(ctx, current)).To(Succeed())
+			Expect(client.IgnoreNotFound(k8sClient.Delete(ctx, current))).To(Succeed())
+		} else {
+			Expect(errors.IsNotFound(err)).To(BeTrue())
+		}
+		// the test environment runs no garbage collector for the owned Secret
+		secret := &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "ci-apikey"}}
+		Expect(client.IgnoreNotFound(k8sClient.Delete(ctx, secret))).To(Succeed())
+	})
+
+	reconcileApiKey := func() *idmv1.ApiKey {
+		_, err := reconciler.Reconcile(ctx, reconcile.Request{NamespacedName: client.ObjectKeyFromObject(apiKey)})
+		Expect(err).NotTo(HaveOccurred())
+		current := &idmv1.ApiKey{}
+		err = k8sClient.Get(ctx, client.ObjectKeyFromObject(apiKey), current)
+		if errors.IsNotFound(err) {
+			return nil
+		}
+		Expect(err).NotTo(HaveOccurred())
+		return current
+	}
+
+	storedKey := func() string {
+		secret := &corev1.Secret{}
+		Expect(k8sClient.Get(ctx, types.NamespacedName{Namespace: "default", Name: "ci-apikey"}, secret)).To(Succeed())
+		return string(secret.Data[idmv1.APIKeySecretKeyKey])
+	}
+
+	It("creates, rotates and revokes the external key", func() {
+		By("creating the key and storing it in the Secret")
+		current := reconcileApiKey()
+		Expect(current.Finalizers).To(ContainElement(apiKeyFinalizer))
+		Expect(meta.IsStatusConditionTrue(current.Status.Conditions, idmv1.ConditionReady)).To(BeTrue())
+		id := current.Status.ID
+		Expect(svc.APIKeys).To(HaveKey(id))
+		Expect(storedKey()).To(Equal(svc.APIKeys[id].Key))
+
+		By("keeping the key until the rotation is due")
+		current = reconcileApiKey()
+		Expect(svc.Calls["CreateAPIKey"]).To(Equal(1))
+		Expect(svc.Calls["RotateAPIKey"]).To(Equal(0))
+
+		By("rotating the key once the interval passed")
+		rotated := metav1.NewTime(time.Now().Add(-2 * time.Hour))
+		current.Status.LastRotationTime = &rotated
+		Expect(k8sClient.Status().Update(ctx, current)).To(Succeed())
+		previous := storedKey()
+		current = reconcileApiKey()
+		Expect(svc.Calls["RotateAPIKey"]).To(Equal(1))
+		Expect(current.Status.ID).To(Equal(id))
+		Expect(storedKey()).To(Equal(svc.APIKeys[id].Key))
+		Expect(storedKey()).NotTo(Equal(previous))
+
+		By("revoking the key when the ApiKey is deleted")
+		Expect(k8sClient.Delete(ctx, current)).To(Succeed())
+		Expect(reconcileApiKey()).To(BeNil())
+		Expect(svc.APIKeys).NotTo(HaveKey(id))
+	})
+})
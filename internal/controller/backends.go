@@ -0,0 +1,88 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"sync"
+
+	corev1 "k8s.io/api/core/v1"
+
+	idmv1 "github.com/m15ch4/go-identity-operator/api/v1"
+	idmsvc "github.com/m15ch4/go-identity-operator/pkg/identityclient"
+)
+
+// backends caches the identity service of each IdentityInstance, so reconciles of all
+// objects referencing the instance share its HTTP connections and cached token
+var backends = &backendCache{entries: map[string]cachedBackend{}}
+
+// backendCache maps IdentityInstance names to their identity service. An entry is
+// replaced once the spec of the instance or an object it references changes, e.g. after
+// its credentials Secret was updated; idle connections of the replaced service time out.
+type backendCache struct {
+	mu      sync.Mutex
+	entries map[string]cachedBackend
+}
+
+type cachedBackend struct {
+	fingerprint string
+	backend     idmsvc.IdentityAPI
+}
+
+// get returns the cached identity service of the instance, building a new one when
+// there is none yet or the fingerprint of its configuration changed
+func (c *backendCache) get(instance *idmv1.IdentityInstance, fingerprint string, opts func() ([]idmsvc.ConfigOpts, error)) (idmsvc.IdentityAPI, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if entry, ok := c.entries[instance.Name]; ok && entry.fingerprint == fingerprint {
+		return entry.backend, nil
+	}
+	resolved, err := opts()
+	if err != nil {
+		return nil, err
+	}
+	backend := newIdentityBackend(instance, resolved)
+	c.entries[instance.Name] = cachedBackend{fingerprint: fingerprint, backend: backend}
+	return backend, nil
+}
+
+// getNamespaced returns the cached operator-level identity service logging in with the
+// credentials Secret of the namespace, building a new one when there is none yet or the
+// Secret changed
+func (c *backendCache) getNamespaced(secret *corev1.Secret) idmsvc.IdentityAPI {
+	// IdentityInstance names cannot contain a slash
+	key := "namespace/" + secret.Namespace
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if entry, ok := c.entries[key]; ok && entry.fingerprint == secret.ResourceVersion {
+		return entry.backend
+	}
+	cfg := idmsvc.NewIdentityConfig(credentialsConfigOpts(secret)...)
+	backend := idmsvc.NewIdentityService(&cfg)
+	c.entries[key] = cachedBackend{fingerprint: secret.ResourceVersion, backend: backend}
+	return backend
+}
+
+// forget drops the identity service of a deleted instance
+func (c *backendCache) forget(name string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	delete(c.entries, name)
+}
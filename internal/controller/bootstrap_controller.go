@@ -0,0 +1,226 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+
+	idmv1 "github.com/m15ch4/go-identity-operator/api/v1"
+	idmsvc "github.com/m15ch4/go-identity-operator/pkg/identityclient"
+)
+
+// bootstrapRoleIDAnnotation keeps the ID of the role created for the operator account on
+// the credentials Secret, so an interrupted bootstrap does not create the role twice
+const bootstrapRoleIDAnnotation = "idm.micze.io/bootstrap-role-id"
+
+// DefaultBootstrapUsername is the name of the account the operator creates for itself
+const DefaultBootstrapUsername = "idm-operator"
+
+// operatorPermissions are the permissions of the role of the operator account, covering
+// the objects the operator manages and nothing else
+var operatorPermissions = []string{
+	"users:read", "users:write",
+	"roles:read", "roles:write",
+	"groups:read", "groups:write",
+	"apikeys:write",
+}
+
+// BootstrapReconciler creates the operator's own account in the operator-level identity
+// system. Given a one-time admin credential in the bootstrap Secret, it creates a role with
+// the permissions the operator needs and a user with that role, stores the credentials of
+// the user in the credentials Secret and switches the identity service to them. The
+// bootstrap Secret is deleted afterwards, so the admin credential does not stay around.
+type BootstrapReconciler struct {
+	client.Client
+
+	// Recorder emits Events on the bootstrap Secret
+	Recorder record.EventRecorder
+
+	// IdentityService is the long-lived operator-level service switched to the new account
+	IdentityService idmsvc.IdentityAPI
+
+	// BootstrapSecret references the Secret with the IDM_USER and IDM_PASS keys, or the
+	// IDM_TOKEN key, of an admin of the identity system
+	BootstrapSecret types.NamespacedName
+
+	// CredentialsSecret references the Secret the IDM_USER and IDM_PASS of the operator
+	// account are written to
+	CredentialsSecret types.NamespacedName
+
+	// Username is the name of the operator account and its role, DefaultBootstrapUsername
+	// when empty
+	Username string
+
+	// NewAdminService returns the identity service logging in with the admin credential of
+	// the bootstrap Secret. A service for the operator-level identity system is built when nil.
+	NewAdminService func(secret *corev1.Secret) idmsvc.IdentityAPI
+}
+
+//+kubebuilder:rbac:groups=core,resources=secrets,verbs=get;list;watch;create;update;patch;delete
+
+// Reconcile bootstraps the operator account once the bootstrap Secret exists
+func (r *BootstrapReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	log := log.FromContext(ctx)
+
+	bootstrap := &corev1.Secret{}
+	err := r.Get(ctx, r.BootstrapSecret, bootstrap)
+	if err != nil {
+		return ctrl.Result{}, client.IgnoreNotFound(err)
+	}
+
+	admin := r.adminService(bootstrap)
+	username := r.username()
+
+	credentials := &corev1.Secret{}
+	err = r.Get(ctx, r.CredentialsSecret, credentials)
+	if err != nil && !errors.IsNotFound(err) {
+		return ctrl.Result{}, err
+	}
+
+	// the password of an interrupted bootstrap may already be set in the identity system
+	password := string(credentials.Data["IDM_PASS"])
+	if string(credentials.Data["IDM_USER"]) != username || password == "" {
+		password, err = generatePassword(nil)
+		if err != nil {
+			return ctrl.Result{}, err
+		}
+	}
+
+	roleID, err := r.ensureRole(ctx, admin, credentials.Annotations[bootstrapRoleIDAnnotation])
+	if err != nil {
+		r.Recorder.Event(bootstrap, corev1.EventTypeWarning, "BootstrapFailed", err.Error())
+		return requeueFor(ctx, err)
+	}
+
+	// The credentials are stored before the account is created, so a password accepted by
+	// the identity system is never lost
+	credentials = &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Namespace: r.CredentialsSecret.Namespace, Name: r.CredentialsSecret.Name},
+	}
+	_, err = controllerutil.CreateOrUpdate(ctx, r.Client, credentials, func() error {
+		if credentials.Data == nil {
+			credentials.Data = map[string][]byte{}
+		}
+		credentials.Data["IDM_USER"] = []byte(username)
+		credentials.Data["IDM_PASS"] = []byte(password)
+		if roleID != "" {
+			metav1.SetMetaDataAnnotation(&credentials.ObjectMeta, bootstrapRoleIDAnnotation, roleID)
+		}
+		return nil
+	})
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+
+	spec := &idmv1.UserSpec{
+		Name:        username,
+		Password:    password,
+		Role:        username,
+		DisplayName: "Identity operator",
+	}
+	extUser, err := admin.FindUserByName(ctx, username)
+	if err == nil && extUser != nil {
+		_, err = admin.UpdateUser(ctx, extUser.ID, spec)
+	} else if err == nil {
+		extUser, err = admin.CreateUser(ctx, spec)
+	}
+	if err != nil {
+		r.Recorder.Event(bootstrap, corev1.EventTypeWarning, "BootstrapFailed", err.Error())
+		return requeueFor(ctx, err)
+	}
+
+	// switch over to the new account, which has to be able to log in
+	r.IdentityService.SetCredentials(username, password)
+	if _, err := r.IdentityService.GetToken(ctx); err != nil {
+		r.Recorder.Event(bootstrap, corev1.EventTypeWarning, "BootstrapFailed", fmt.Sprintf("Failed to log in as %s: %v", username, err))
+		return requeueFor(ctx, err)
+	}
+
+	log.Info("Bootstrapped operator account", "id", extUser.ID, "name", username, "credentialsSecret", r.CredentialsSecret)
+	r.Recorder.Eventf(bootstrap, corev1.EventTypeNormal, "Bootstrapped", "Created operator account %s, its credentials are stored in secret %s", username, r.CredentialsSecret)
+
+	// the admin credential is needed only once
+	err = r.Delete(ctx, bootstrap)
+	return ctrl.Result{}, client.IgnoreNotFound(err)
+}
+
+// ensureRole creates the role of the operator account unless the role with the given ID
+// exists. A role of the same name created outside the bootstrap is used as is.
+func (r *BootstrapReconciler) ensureRole(ctx context.Context, admin idmsvc.IdentityAPI, roleID string) (string, error) {
+	if roleID != "" {
+		_, err := admin.GetRole(ctx, roleID)
+		if err == nil {
+			return roleID, nil
+		}
+		if !idmsvc.IsNotFound(err) {
+			return "", err
+		}
+	}
+
+	role, err := admin.CreateRole(ctx, &idmv1.RoleSpec{
+		Name:        r.username(),
+		Description: "Account of the identity operator",
+		Permissions: operatorPermissions,
+	})
+	if idmsvc.IsConflict(err) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	return role.ID, nil
+}
+
+// username returns the name of the operator account
+func (r *BootstrapReconciler) username() string {
+	if r.Username != "" {
+		return r.Username
+	}
+	return DefaultBootstrapUsername
+}
+
+// adminService returns the identity service logging in with the admin credential
+func (r *BootstrapReconciler) adminService(secret *corev1.Secret) idmsvc.IdentityAPI {
+	if r.NewAdminService != nil {
+		return r.NewAdminService(secret)
+	}
+	return idmsvc.New(credentialsConfigOpts(secret)...)
+}
+
+// SetupWithManager sets up the controller with the Manager. Only the bootstrap Secret is
+// reconciled.
+func (r *BootstrapReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		Named("bootstrap").
+		For(&corev1.Secret{}, builder.WithPredicates(predicate.NewPredicateFuncs(func(obj client.Object) bool {
+			return client.ObjectKeyFromObject(obj) == r.BootstrapSecret
+		}))).
+		Complete(withDrain(withCorrelationID(r)))
+}
@@ -0,0 +1,97 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	idmsvc "github.com/m15ch4/go-identity-operator/pkg/identityclient"
+	"github.com/m15ch4/go-identity-operator/pkg/identityclient/fake"
+)
+
+var _ = Describe("Bootstrap controller", func() {
+	var (
+		ctx context.Context
+		svc *fake.IdentityService
+	)
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		svc = fake.NewIdentityService()
+	})
+
+	It("bootstraps the operator account from the admin credential", func() {
+		bootstrap := &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{GenerateName: "idm-bootstrap-", Namespace: "default"},
+			Data:       map[string][]byte{"IDM_USER": []byte("admin"), "IDM_PASS": []byte("secret")},
+		}
+		Expect(k8sClient.Create(ctx, bootstrap)).To(Succeed())
+		bootstrapKey := types.NamespacedName{Namespace: bootstrap.Namespace, Name: bootstrap.Name}
+		credentialsKey := types.NamespacedName{Namespace: "default", Name: bootstrap.Name + "-operator"}
+		var admin *corev1.Secret
+		bootstrapper := &BootstrapReconciler{
+			Client:            k8sClient,
+			Recorder:          record.NewFakeRecorder(100),
+			IdentityService:   svc,
+			BootstrapSecret:   bootstrapKey,
+			CredentialsSecret: credentialsKey,
+			NewAdminService: func(secret *corev1.Secret) idmsvc.IdentityAPI {
+				admin = secret
+				return svc
+			},
+		}
+
+		_, err := bootstrapper.Reconcile(ctx, reconcile.Request{NamespacedName: bootstrapKey})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(string(admin.Data["IDM_USER"])).To(Equal("admin"))
+
+		credentials := &corev1.Secret{}
+		Expect(k8sClient.Get(ctx, credentialsKey, credentials)).To(Succeed())
+		Expect(string(credentials.Data["IDM_USER"])).To(Equal(DefaultBootstrapUsername))
+		Expect(credentials.Data["IDM_PASS"]).NotTo(BeEmpty())
+		roleID := credentials.Annotations[bootstrapRoleIDAnnotation]
+		Expect(svc.Roles[roleID].Name).To(Equal(DefaultBootstrapUsername))
+		Expect(svc.Roles[roleID].Permissions).To(ContainElement("users:write"))
+		extUser, err := svc.FindUserByName(ctx, DefaultBootstrapUsername)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(extUser.Role).To(Equal(DefaultBootstrapUsername))
+
+		By("deleting the admin credential once the account exists")
+		err = k8sClient.Get(ctx, bootstrapKey, &corev1.Secret{})
+		Expect(errors.IsNotFound(err)).To(BeTrue())
+
+		By("resuming an interrupted bootstrap without creating the account twice")
+		bootstrap.ResourceVersion = ""
+		Expect(k8sClient.Create(ctx, bootstrap)).To(Succeed())
+		_, err = bootstrapper.Reconcile(ctx, reconcile.Request{NamespacedName: bootstrapKey})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(svc.Calls["CreateRole"]).To(Equal(1))
+		Expect(svc.Calls["CreateUser"]).To(Equal(1))
+		Expect(svc.Calls["UpdateUser"]).To(Equal(1))
+		Expect(k8sClient.Delete(ctx, credentials)).To(Succeed())
+	})
+})
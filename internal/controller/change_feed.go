@@ -0,0 +1,189 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"crypto/hmac"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+
+	idmv1 "github.com/m15ch4/go-identity-operator/api/v1"
+	"github.com/m15ch4/go-identity-operator/internal/notify"
+)
+
+const (
+	// userExternalIDIndex indexes Users by the ID of their external user
+	userExternalIDIndex = "status.id"
+
+	// maxChangeNotificationSize bounds the body of a change notification
+	maxChangeNotificationSize = 1 << 20
+	// changeFeedQueueSize is the number of changed Users buffered for the controller
+	changeFeedQueueSize = 1000
+)
+
+// ChangeNotification is posted by the identity system to the change feed when users
+// changed, so their Users are synced right away instead of at the next drift resync
+type ChangeNotification struct {
+	// IDs of the changed users in the identity system
+	IDs []string `json:"ids"`
+}
+
+// ChangeFeed receives change notifications of the identity system and enqueues the Users
+// of the changed external users, which then sync even though their spec is unchanged.
+// Requests are verified with the HMAC-SHA256 of their body in the notify.SignatureHeader
+// when a secret is set. It runs on the leader; standby replicas are not ready and receive
+// no requests.
+type ChangeFeed struct {
+	// Addr is the address the receiver listens on
+	Addr string
+	// Secret verifies the signature of the requests, unsigned requests are accepted when empty
+	Secret []byte
+	// Reader finds the Users by external ID, it must be the cache holding the ID index
+	Reader client.Reader
+
+	once    sync.Once
+	events  chan event.GenericEvent
+	mu      sync.Mutex
+	changed map[types.NamespacedName]bool
+}
+
+var _ manager.LeaderElectionRunnable = &ChangeFeed{}
+
+func (f *ChangeFeed) init() {
+	f.once.Do(func() {
+		f.events = make(chan event.GenericEvent, changeFeedQueueSize)
+		f.changed = map[types.NamespacedName]bool{}
+	})
+}
+
+// Start serves the change notifications until the context is cancelled
+func (f *ChangeFeed) Start(ctx context.Context) error {
+	f.init()
+	server := &http.Server{
+		Addr:              f.Addr,
+		Handler:           f,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		_ = server.Shutdown(shutdownCtx)
+	}()
+
+	log.FromContext(ctx).WithName("change-feed").Info("Receiving change notifications", "addr", f.Addr)
+	if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+// NeedLeaderElection is true, only the leader reconciles the enqueued Users
+func (f *ChangeFeed) NeedLeaderElection() bool {
+	return true
+}
+
+// ServeHTTP enqueues the Users of the external users in a ChangeNotification
+func (f *ChangeFeed) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	f.init()
+	log := log.FromContext(req.Context()).WithName("change-feed")
+
+	if req.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	body, err := io.ReadAll(io.LimitReader(req.Body, maxChangeNotificationSize))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if len(f.Secret) > 0 {
+		signature := strings.TrimPrefix(req.Header.Get(notify.SignatureHeader), "sha256=")
+		if !hmac.Equal([]byte(signature), []byte(notify.Sign(f.Secret, body))) {
+			http.Error(w, "invalid signature", http.StatusUnauthorized)
+			return
+		}
+	}
+	var notification ChangeNotification
+	if err := json.Unmarshal(body, &notification); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	for _, id := range notification.IDs {
+		// IDs of different IdentityInstances may collide, every matching User is synced
+		users := &idmv1.UserList{}
+		err := f.Reader.List(req.Context(), users, client.MatchingFields{userExternalIDIndex: id})
+		if err != nil {
+			log.Error(err, "Failed to find the Users of a changed external user", "id", id)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		for i := range users.Items {
+			f.enqueue(&users.Items[i])
+		}
+	}
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// enqueue marks the User as changed externally and hands it to the controller, dropping
+// it when the queue is full; the drift resync catches up on dropped Users
+func (f *ChangeFeed) enqueue(user *idmv1.User) {
+	f.mu.Lock()
+	f.changed[client.ObjectKeyFromObject(user)] = true
+	f.mu.Unlock()
+
+	select {
+	case f.events <- event.GenericEvent{Object: user}:
+	default:
+	}
+}
+
+// source returns the channel the controller of the Users watches
+func (f *ChangeFeed) source() source.Source {
+	f.init()
+	return &source.Channel{Source: f.events}
+}
+
+// take reports whether the User was changed externally since it was last synced and
+// clears the mark; it is false for a nil feed
+func (f *ChangeFeed) take(name types.NamespacedName) bool {
+	if f == nil {
+		return false
+	}
+	f.init()
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	changed := f.changed[name]
+	delete(f.changed, name)
+	return changed
+}
@@ -0,0 +1,155 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+// statusPersistTimeout bounds the status writes of reconciles cut off by the end of the drain
+const statusPersistTimeout = 5 * time.Second
+
+// shutdownDrain tracks the shutdown of the manager. Once it is draining, reconciles
+// already running keep their requests to the identity systems going until the drain
+// timeout passes, instead of cancelling them with the manager, so a user created right
+// before the shutdown still gets its ID recorded in the status.
+type shutdownDrain struct {
+	mu       sync.Mutex
+	enabled  bool
+	draining bool
+	// drained is closed once the drain timeout passed
+	drained     chan struct{}
+	drainedOnce sync.Once
+}
+
+// drain is the shutdown drain of the manager, enabled by a ShutdownDrainer
+var drain = &shutdownDrain{drained: make(chan struct{})}
+
+func (d *shutdownDrain) enable() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.enabled = true
+}
+
+// begin starts draining, the drained channel is closed after timeout
+func (d *shutdownDrain) begin(timeout time.Duration) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.draining {
+		return
+	}
+	d.draining = true
+	time.AfterFunc(timeout, d.finish)
+}
+
+// finish ends the drain, cancelling the reconciles still running
+func (d *shutdownDrain) finish() {
+	d.drainedOnce.Do(func() { close(d.drained) })
+}
+
+func (d *shutdownDrain) state() (enabled, draining bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.enabled, d.draining
+}
+
+// isDrained reports whether the drain timeout passed
+func (d *shutdownDrain) isDrained() bool {
+	select {
+	case <-d.drained:
+		return true
+	default:
+		return false
+	}
+}
+
+// detachedContext carries the values of its parent, such as the logger and the
+// correlation ID, without being cancelled together with it
+type detachedContext struct {
+	parent context.Context
+	done   <-chan struct{}
+}
+
+func (c detachedContext) Deadline() (time.Time, bool) { return time.Time{}, false }
+func (c detachedContext) Done() <-chan struct{}       { return c.done }
+func (c detachedContext) Value(key interface{}) interface{} {
+	return c.parent.Value(key)
+}
+func (c detachedContext) Err() error {
+	select {
+	case <-c.done:
+		return context.Canceled
+	default:
+		return nil
+	}
+}
+
+// ShutdownDrainer lets in-flight reconciles finish on shutdown. When the manager stops,
+// no new reconcile starts and the running ones keep going for up to Timeout; their status
+// is written even when the timeout cuts them off. Timeout must leave time to the graceful
+// shutdown timeout of the manager.
+type ShutdownDrainer struct {
+	Timeout time.Duration
+}
+
+var _ manager.LeaderElectionRunnable = &ShutdownDrainer{}
+
+// Start waits for the manager to stop and begins draining
+func (d *ShutdownDrainer) Start(ctx context.Context) error {
+	drain.enable()
+	<-ctx.Done()
+	log.FromContext(ctx).Info("Draining in-flight reconciles", "timeout", d.Timeout)
+	drain.begin(d.Timeout)
+	return nil
+}
+
+// NeedLeaderElection is false, standby replicas have no reconciles to drain but must not
+// block the shutdown either
+func (d *ShutdownDrainer) NeedLeaderElection() bool {
+	return false
+}
+
+// withDrain runs the reconciles of r with a context that outlives the shutdown of the
+// manager until the drain timeout passes. Reconciles dequeued after the shutdown began
+// are requeued for the next leader instead of started.
+func withDrain(r reconcile.Reconciler) reconcile.Reconciler {
+	return reconcile.Func(func(ctx context.Context, req reconcile.Request) (reconcile.Result, error) {
+		enabled, draining := drain.state()
+		if !enabled {
+			return r.Reconcile(ctx, req)
+		}
+		if draining || ctx.Err() != nil {
+			return reconcile.Result{Requeue: true}, nil
+		}
+		return r.Reconcile(detachedContext{parent: ctx, done: drain.drained}, req)
+	})
+}
+
+// persistContext returns a context for writing the outcome of a reconcile cut off by the
+// end of the drain, so the IDs of the users it created are not lost
+func persistContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	if ctx.Err() == nil || !drain.isDrained() {
+		return ctx, func() {}
+	}
+	return context.WithTimeout(detachedContext{parent: ctx}, statusPersistTimeout)
+}
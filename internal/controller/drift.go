@@ -0,0 +1,207 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	idmv1 "github.com/m15ch4/go-identity-operator/api/v1"
+	idmsvc "github.com/m15ch4/go-identity-operator/pkg/identityclient"
+)
+
+// driftIgnoredFields are the fields of the external user that are not compared by name,
+// the ID is assigned by the identity system and the password cannot be read back.
+// Enabled is compared by userDrift itself, as unset means enabled in the spec and
+// unknown in identity systems without a notion of suspension. So are the roles, compared
+// as a set as no roles leave the roles assigned by UserRoleBindings alone, and the
+// attributes, as unset attributes leave those of the external user alone.
+var driftIgnoredFields = map[string]bool{
+	"ID":         true,
+	"Password":   true,
+	"Enabled":    true,
+	"Role":       true,
+	"Roles":      true,
+	"Attributes": true,
+}
+
+// userDrift returns the JSON names of the managed fields of the external user that differ
+// from the spec. Every field of IdentityUser is compared with the UserSpec field of the same
+// name and type, so new fields are picked up by adding them to both structs.
+func userDrift(spec *idmv1.UserSpec, extUser *idmsvc.IdentityUser) []string {
+	desired := reflect.ValueOf(spec).Elem()
+	actual := reflect.ValueOf(extUser).Elem()
+
+	var drifted []string
+	for i := 0; i < actual.NumField(); i++ {
+		field := actual.Type().Field(i)
+		if !field.IsExported() || driftIgnoredFields[field.Name] {
+			continue
+		}
+		want := desired.FieldByName(field.Name)
+		if !want.IsValid() || want.Type() != field.Type {
+			continue
+		}
+		if !reflect.DeepEqual(want.Interface(), actual.Field(i).Interface()) {
+			drifted = append(drifted, jsonName(field))
+		}
+	}
+	if roles := spec.AllRoles(); len(roles) > 0 && !equalRoles(roles, extUser.AllRoles()) {
+		drifted = append(drifted, "role")
+	}
+	if extUser.Enabled != nil && spec.IsEnabled() != *extUser.Enabled {
+		drifted = append(drifted, "enabled")
+	}
+	if spec.Attributes != nil && !equalAttributes(spec.Attributes, extUser.Attributes) {
+		drifted = append(drifted, "attributes")
+	}
+
+	managed := drifted[:0]
+	for _, name := range drifted {
+		if spec.Manages(name) {
+			managed = append(managed, name)
+		}
+	}
+	return managed
+}
+
+// fieldChanges returns the values of the drifted fields, as named by userDrift, in the
+// identity system and in the spec, for a human to review
+func fieldChanges(spec *idmv1.UserSpec, extUser *idmsvc.IdentityUser, drifted []string) []idmv1.UserFieldChange {
+	desired := reflect.ValueOf(spec).Elem()
+	actual := reflect.ValueOf(extUser).Elem()
+
+	changes := make([]idmv1.UserFieldChange, 0, len(drifted))
+	for _, name := range drifted {
+		change := idmv1.UserFieldChange{Field: name}
+		switch name {
+		case "role":
+			change.Current = strings.Join(extUser.AllRoles(), ",")
+			change.Desired = strings.Join(spec.AllRoles(), ",")
+		case "enabled":
+			change.Current = strconv.FormatBool(extUser.Enabled != nil && *extUser.Enabled)
+			change.Desired = strconv.FormatBool(spec.IsEnabled())
+		case "attributes":
+			change.Current = formatAttributes(extUser.Attributes)
+			change.Desired = formatAttributes(spec.Attributes)
+		default:
+			for i := 0; i < actual.NumField(); i++ {
+				field := actual.Type().Field(i)
+				if jsonName(field) == name {
+					change.Current = fmt.Sprint(actual.Field(i).Interface())
+					change.Desired = fmt.Sprint(desired.FieldByName(field.Name).Interface())
+				}
+			}
+		}
+		changes = append(changes, change)
+	}
+	return changes
+}
+
+// formatAttributes renders the attributes as key=value pairs sorted by key
+func formatAttributes(attributes map[string]string) string {
+	pairs := make([]string, 0, len(attributes))
+	for key, value := range attributes {
+		pairs = append(pairs, key+"="+value)
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
+
+// preserveUnmanaged copies the fields the spec does not manage from the external user, so
+// replacing the whole user keeps the values set in the identity system
+func preserveUnmanaged(spec *idmv1.UserSpec, extUser *idmsvc.IdentityUser) {
+	if len(spec.ManagedFields) == 0 {
+		return
+	}
+	desired := reflect.ValueOf(spec).Elem()
+	actual := reflect.ValueOf(extUser).Elem()
+
+	for i := 0; i < actual.NumField(); i++ {
+		field := actual.Type().Field(i)
+		name := jsonName(field)
+		// the roles are managed together with the role
+		if name == "roles" {
+			name = "role"
+		}
+		if !field.IsExported() || field.Name == "ID" || field.Name == "Password" || spec.Manages(name) {
+			continue
+		}
+		want := desired.FieldByName(field.Name)
+		if !want.IsValid() || want.Type() != field.Type || !want.CanSet() {
+			continue
+		}
+		if field.Name == "Enabled" && extUser.Enabled == nil {
+			continue
+		}
+		want.Set(actual.Field(i))
+	}
+}
+
+// keepIgnoredAttributes replaces the ignored attributes of the spec with those of the
+// external user, so they neither drift nor change when the attributes are written
+func keepIgnoredAttributes(spec *idmv1.UserSpec, extUser *idmsvc.IdentityUser, ignored []string) {
+	if spec.Attributes == nil {
+		return
+	}
+	for _, attribute := range ignored {
+		if value, ok := extUser.Attributes[attribute]; ok {
+			spec.Attributes[attribute] = value
+		} else {
+			delete(spec.Attributes, attribute)
+		}
+	}
+}
+
+// equalAttributes reports whether both sets of attributes hold the same values, nil and
+// empty being equal
+func equalAttributes(a, b map[string]string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for key, value := range a {
+		if other, ok := b[key]; !ok || other != value {
+			return false
+		}
+	}
+	return true
+}
+
+// jsonName returns the name of the field in its JSON representation
+func jsonName(field reflect.StructField) string {
+	name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+	if name == "" {
+		return field.Name
+	}
+	return name
+}
+
+// equalRoles reports whether both lists hold the same roles, in any order
+func equalRoles(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for _, role := range a {
+		if !containsString(b, role) {
+			return false
+		}
+	}
+	return true
+}
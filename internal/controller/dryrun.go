@@ -0,0 +1,175 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	idmv1 "github.com/m15ch4/go-identity-operator/api/v1"
+	idmsvc "github.com/m15ch4/go-identity-operator/pkg/identityclient"
+)
+
+// dryRunError is returned instead of the result of a create or update skipped in dry-run mode
+type dryRunError struct {
+	operation string
+}
+
+func (e *dryRunError) Error() string {
+	return "dry run, would " + e.operation
+}
+
+// isDryRun reports whether err was returned for a write skipped in dry-run mode
+func isDryRun(err error) bool {
+	var dryRunErr *dryRunError
+	return errors.As(err, &dryRunErr)
+}
+
+// dryRun reports whether writes for obj are skipped, by the operator-wide option or its annotation
+func dryRun(obj client.Object, opts ControllerOptions) bool {
+	return opts.effective().DryRun || obj.GetAnnotations()[idmv1.AnnotationDryRun] == "true"
+}
+
+// withDryRun returns svc unchanged unless writes for obj are skipped, then a service that
+// performs all reads but records every write as a DryRun event on obj instead
+func withDryRun(svc idmsvc.IdentityAPI, obj client.Object, recorder record.EventRecorder, opts ControllerOptions) idmsvc.IdentityAPI {
+	if !dryRun(obj, opts) {
+		return svc
+	}
+	return &dryRunService{IdentityAPI: svc, obj: obj, recorder: recorder}
+}
+
+// dryRunService skips the writes of the wrapped identity service. Creates and updates fail
+// with a dryRunError, as there is no result to return. Deletions and removals report
+// success, so finalizers do not hold deleted objects.
+type dryRunService struct {
+	idmsvc.IdentityAPI
+
+	obj      client.Object
+	recorder record.EventRecorder
+}
+
+// skip records the write that would have been performed
+func (s *dryRunService) skip(format string, args ...interface{}) string {
+	operation := fmt.Sprintf(format, args...)
+	s.recorder.Event(s.obj, corev1.EventTypeNormal, "DryRun", "Would "+operation)
+	return operation
+}
+
+func (s *dryRunService) CreateUser(ctx context.Context, user *idmv1.UserSpec) (*idmsvc.IdentityUser, error) {
+	return nil, &dryRunError{s.skip("create user %s", user.Name)}
+}
+
+func (s *dryRunService) CreateUsers(ctx context.Context, users []*idmv1.UserSpec) ([]idmsvc.BulkResult, error) {
+	results := make([]idmsvc.BulkResult, len(users))
+	for i, user := range users {
+		results[i].Err = &dryRunError{s.skip("create user %s", user.Name)}
+	}
+	return results, nil
+}
+
+func (s *dryRunService) UpdateUser(ctx context.Context, userID string, user *idmv1.UserSpec) (*idmsvc.IdentityUser, error) {
+	return nil, &dryRunError{s.skip("update user %s", userID)}
+}
+
+func (s *dryRunService) PatchUser(ctx context.Context, userID string, user *idmv1.UserSpec, fields []string) (*idmsvc.IdentityUser, error) {
+	return nil, &dryRunError{s.skip("update fields %v of user %s", fields, userID)}
+}
+
+func (s *dryRunService) DeleteUser(ctx context.Context, userID string) error {
+	s.skip("delete user %s", userID)
+	return nil
+}
+
+func (s *dryRunService) CreateRole(ctx context.Context, role *idmv1.RoleSpec) (*idmsvc.IdentityRole, error) {
+	return nil, &dryRunError{s.skip("create role %s", role.Name)}
+}
+
+func (s *dryRunService) UpdateRole(ctx context.Context, roleID string, role *idmv1.RoleSpec) (*idmsvc.IdentityRole, error) {
+	return nil, &dryRunError{s.skip("update role %s", roleID)}
+}
+
+func (s *dryRunService) DeleteRole(ctx context.Context, roleID string) error {
+	s.skip("delete role %s", roleID)
+	return nil
+}
+
+func (s *dryRunService) AddUserRole(ctx context.Context, userID, scope, role string) error {
+	return &dryRunError{s.skip("assign role %s%s to user %s", role, scopeSuffix(scope), userID)}
+}
+
+func (s *dryRunService) RemoveUserRole(ctx context.Context, userID, scope, role string) error {
+	s.skip("unassign role %s%s from user %s", role, scopeSuffix(scope), userID)
+	return nil
+}
+
+func (s *dryRunService) CreateGroup(ctx context.Context, group *idmv1.GroupSpec) (*idmsvc.IdentityGroup, error) {
+	return nil, &dryRunError{s.skip("create group %s", group.Name)}
+}
+
+func (s *dryRunService) UpdateGroup(ctx context.Context, groupID string, group *idmv1.GroupSpec) (*idmsvc.IdentityGroup, error) {
+	return nil, &dryRunError{s.skip("update group %s", groupID)}
+}
+
+func (s *dryRunService) DeleteGroup(ctx context.Context, groupID string) error {
+	s.skip("delete group %s", groupID)
+	return nil
+}
+
+func (s *dryRunService) AddGroupMember(ctx context.Context, groupID, userID string) error {
+	return &dryRunError{s.skip("add user %s to group %s", userID, groupID)}
+}
+
+func (s *dryRunService) RemoveGroupMember(ctx context.Context, groupID, userID string) error {
+	s.skip("remove user %s from group %s", userID, groupID)
+	return nil
+}
+
+func (s *dryRunService) AddChildGroup(ctx context.Context, parentID, groupID string) error {
+	return &dryRunError{s.skip("nest group %s in group %s", groupID, parentID)}
+}
+
+func (s *dryRunService) RemoveChildGroup(ctx context.Context, parentID, groupID string) error {
+	s.skip("remove group %s from group %s", groupID, parentID)
+	return nil
+}
+
+func (s *dryRunService) CreateAPIKey(ctx context.Context, key *idmv1.ApiKeySpec) (*idmsvc.IdentityAPIKey, error) {
+	return nil, &dryRunError{s.skip("create API key %s", key.Name)}
+}
+
+func (s *dryRunService) RotateAPIKey(ctx context.Context, keyID string) (*idmsvc.IdentityAPIKey, error) {
+	return nil, &dryRunError{s.skip("rotate API key %s", keyID)}
+}
+
+func (s *dryRunService) DeleteAPIKey(ctx context.Context, keyID string) error {
+	s.skip("delete API key %s", keyID)
+	return nil
+}
+
+// scopeSuffix describes the scope of a role assignment in DryRun events
+func scopeSuffix(scope string) string {
+	if scope == "" {
+		return ""
+	}
+	return " in scope " + scope
+}
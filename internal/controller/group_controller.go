@@ -0,0 +1,413 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	idmv1 "github.com/m15ch4/go-identity-operator/api/v1"
+	"github.com/m15ch4/go-identity-operator/internal/status"
+	idmsvc "github.com/m15ch4/go-identity-operator/pkg/identityclient"
+)
+
+const groupFinalizer = "micze.io/group-finalizer"
+
+// GroupReconciler reconciles a Group object
+type GroupReconciler struct {
+	client.Client
+	Scheme *runtime.Scheme
+
+	// Recorder emits Events on Groups
+	Recorder record.EventRecorder
+
+	// IdentityService is the long-lived service used for Groups without an instanceRef
+	IdentityService idmsvc.IdentityAPI
+
+	// Options tunes the workers and the rate limiter of the controller
+	Options ControllerOptions
+
+	// DriftResyncPeriod is the interval after which a synced Group is compared with the
+	// external group again. Zero disables periodic resync.
+	DriftResyncPeriod time.Duration
+
+	// CredentialsSecret optionally references a Secret with IDM_USER and IDM_PASS keys
+	// used to log in to the identity system
+	CredentialsSecret types.NamespacedName
+}
+
+//+kubebuilder:rbac:groups=idm.micze.io,resources=groups,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=idm.micze.io,resources=groups/status,verbs=get;update;patch
+//+kubebuilder:rbac:groups=idm.micze.io,resources=groups/finalizers,verbs=update
+//+kubebuilder:rbac:groups=idm.micze.io,resources=users,verbs=get;list;watch
+//+kubebuilder:rbac:groups=idm.micze.io,resources=groupbindings,verbs=get;list;watch
+
+// Reconcile creates, updates and deletes the group in the identity system so that it
+// matches the Group spec, including the members selected by spec.memberSelector.
+func (r *GroupReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	log := log.FromContext(ctx)
+
+	group := &idmv1.Group{}
+	err := r.Get(ctx, req.NamespacedName, group)
+	if err != nil {
+		if errors.IsNotFound(err) {
+			log.Info("Group resource not found. Ignoring since object must be deleted")
+			return ctrl.Result{}, nil
+		}
+		log.Error(err, "Failed to get Group")
+		return ctrl.Result{}, err
+	}
+	original := group.DeepCopy()
+
+	// Leave the identity system alone while paused, deletion waits for the resume
+	isPaused := paused(group, group.Spec.Paused)
+	setPaused(&group.Status.Conditions, group.Generation, isPaused)
+	if isPaused {
+		log.Info("Reconciliation is paused")
+		if !equality.Semantic.DeepEqual(original.Status, group.Status) {
+			err = patchStatus(ctx, r.Client, group, original)
+			if err != nil {
+				log.Info("Failed to update group status")
+				return ctrl.Result{}, err
+			}
+		}
+		return ctrl.Result{}, nil
+	}
+
+	svc, err := identityServiceFor(ctx, r.Client, group.Namespace, r.Options.Config.instanceRef(group.Spec.InstanceRef), r.IdentityService, r.CredentialsSecret)
+	if err != nil {
+		r.setDegraded(ctx, group, original, "ConfigurationFailed", err)
+		return requeueFor(ctx, err)
+	}
+	svc = withDryRun(svc, group, r.Recorder, r.Options)
+
+	// Remove the external group before letting the Group go
+	if !group.ObjectMeta.DeletionTimestamp.IsZero() {
+		if containsString(group.GetFinalizers(), groupFinalizer) {
+			if group.Status.ID != "" {
+				err := svc.DeleteGroup(ctx, group.Status.ID)
+				if err != nil && !idmsvc.IsNotFound(err) {
+					r.setDegraded(ctx, group, original, "FinalizeFailed", err)
+					return requeueFor(ctx, err)
+				}
+			}
+
+			err = patchWithRetry(ctx, r.Client, group, func() {
+				controllerutil.RemoveFinalizer(group, groupFinalizer)
+			})
+			if err != nil {
+				return ctrl.Result{}, err
+			}
+		}
+		return ctrl.Result{}, nil
+	}
+
+	// Terminal errors are not retried until the spec changes
+	if stalled := status.Stalled(group); stalled != nil {
+		log.Info("Group is stalled, waiting for a spec change", "reason", stalled.Reason)
+		return ctrl.Result{}, nil
+	}
+
+	// Identity systems without groups would reject every request
+	instanceRef := r.Options.Config.instanceRef(group.Spec.InstanceRef)
+	caps, err := instanceCapabilities(ctx, r.Client, instanceRef)
+	if err != nil {
+		r.setDegraded(ctx, group, original, "ConfigurationFailed", err)
+		return requeueFor(ctx, err)
+	}
+	if caps != nil && !caps.SupportsGroups {
+		err := fmt.Errorf("%w: identity instance %s does not manage groups", idmsvc.ErrNotSupported, instanceRef.Name)
+		r.setDegraded(ctx, group, original, "NotSupported", err)
+		return requeueFor(ctx, err)
+	}
+
+	if group.Status.ID == "" {
+		log.Info("Creating group")
+		extGroup, err := svc.CreateGroup(ctx, &group.Spec)
+		if err != nil {
+			r.setDegraded(ctx, group, original, "CreateFailed", err)
+			return requeueFor(ctx, err)
+		}
+		group.Status.ID = extGroup.ID
+		status.MarkSynced(group, "Created", "Group created in identity system")
+		r.Recorder.Eventf(group, corev1.EventTypeNormal, "GroupCreated", "Created group %s in identity system", extGroup.ID)
+	} else {
+		extGroup, err := svc.GetGroup(ctx, group.Status.ID)
+		if err != nil {
+			r.setDegraded(ctx, group, original, "GetFailed", err)
+			return requeueFor(ctx, err)
+		}
+
+		// compare fields of the external group with the spec fields of the Group
+		if extGroup.Name != group.Spec.Name || extGroup.Description != group.Spec.Description {
+			log.Info("Updating group")
+			_, err = svc.UpdateGroup(ctx, group.Status.ID, &group.Spec)
+			if err != nil {
+				r.setDegraded(ctx, group, original, "UpdateFailed", err)
+				return requeueFor(ctx, err)
+			}
+			status.MarkSynced(group, "Updated", "Group updated in identity system")
+			r.Recorder.Eventf(group, corev1.EventTypeNormal, "GroupUpdated", "Updated group %s in identity system", group.Status.ID)
+		} else {
+			status.MarkSynced(group, "UpToDate", "Group matches the identity system")
+		}
+	}
+
+	err = r.syncParent(ctx, svc, group)
+	if err != nil {
+		r.setDegraded(ctx, group, original, "NestFailed", err)
+		return requeueFor(ctx, err)
+	}
+
+	err = r.syncMembers(ctx, svc, group)
+	if err != nil {
+		r.setDegraded(ctx, group, original, "SyncMembersFailed", err)
+		return requeueFor(ctx, err)
+	}
+
+	if !equality.Semantic.DeepEqual(original.Status, group.Status) {
+		err = patchStatus(ctx, r.Client, group, original)
+		if err != nil {
+			log.Info("Failed to update group status")
+			return ctrl.Result{}, err
+		}
+	}
+
+	if !containsString(group.GetFinalizers(), groupFinalizer) {
+		if err := patchWithRetry(ctx, r.Client, group, func() {
+			controllerutil.AddFinalizer(group, groupFinalizer)
+		}); err != nil {
+			return ctrl.Result{}, err
+		}
+	}
+
+	return ctrl.Result{RequeueAfter: r.Options.Config.driftResyncPeriod(r.DriftResyncPeriod)}, nil
+}
+
+// syncParent nests the external group in the external group of spec.parentGroupRef,
+// taking it out of the group it was nested in before
+func (r *GroupReconciler) syncParent(ctx context.Context, svc idmsvc.IdentityAPI, group *idmv1.Group) error {
+	parentID := ""
+	if ref := group.Spec.ParentGroupRef; ref != nil {
+		parent := &idmv1.Group{}
+		err := r.Get(ctx, types.NamespacedName{Namespace: group.Namespace, Name: ref.Name}, parent)
+		if err != nil {
+			return err
+		}
+		if !equality.Semantic.DeepEqual(parent.Spec.InstanceRef, group.Spec.InstanceRef) {
+			return fmt.Errorf("parent group %s/%s is managed in a different identity instance", group.Namespace, parent.Name)
+		}
+		if parent.Status.ID == "" {
+			return fmt.Errorf("parent group %s/%s is not created in identity system yet", group.Namespace, parent.Name)
+		}
+		parentID = parent.Status.ID
+	}
+	if parentID == group.Status.ParentID {
+		return nil
+	}
+
+	if group.Status.ParentID != "" {
+		err := svc.RemoveChildGroup(ctx, group.Status.ParentID, group.Status.ID)
+		if err != nil && !idmsvc.IsNotFound(err) {
+			return err
+		}
+		group.Status.ParentID = ""
+	}
+	if parentID != "" {
+		err := svc.AddChildGroup(ctx, parentID, group.Status.ID)
+		if err != nil {
+			return err
+		}
+		group.Status.ParentID = parentID
+		r.Recorder.Eventf(group, corev1.EventTypeNormal, "GroupNested", "Nested group %s in group %s in identity system", group.Status.ID, parentID)
+	}
+	return nil
+}
+
+// syncMembers adds the external users of the Users selected by spec.memberSelector to the
+// external group and removes the ones it added before whose Users are no longer selected.
+// Users not created in the identity system yet are added once their ID is known.
+func (r *GroupReconciler) syncMembers(ctx context.Context, svc idmsvc.IdentityAPI, group *idmv1.Group) error {
+	if group.Spec.MemberSelector == nil && len(group.Status.Members) == 0 {
+		return nil
+	}
+
+	desired := map[string]bool{}
+	if group.Spec.MemberSelector != nil {
+		selector, err := metav1.LabelSelectorAsSelector(group.Spec.MemberSelector)
+		if err != nil {
+			return fmt.Errorf("invalid memberSelector: %w", err)
+		}
+		users := &idmv1.UserList{}
+		err = r.List(ctx, users, client.InNamespace(group.Namespace), client.MatchingLabelsSelector{Selector: selector})
+		if err != nil {
+			return err
+		}
+		for _, user := range users.Items {
+			if user.Status.ID == "" || !user.DeletionTimestamp.IsZero() ||
+				!equality.Semantic.DeepEqual(user.Spec.InstanceRef, group.Spec.InstanceRef) {
+				continue
+			}
+			desired[user.Status.ID] = true
+		}
+	}
+
+	members, err := svc.ListGroupMembers(ctx, group.Status.ID)
+	if err != nil {
+		return err
+	}
+	current := map[string]bool{}
+	for _, member := range members {
+		current[member] = true
+	}
+
+	// members bound by GroupBindings stay when they are no longer selected
+	bindings := &idmv1.GroupBindingList{}
+	if err := r.List(ctx, bindings, client.InNamespace(group.Namespace)); err != nil {
+		return err
+	}
+	bound := map[string]bool{}
+	for _, binding := range bindings.Items {
+		if binding.Spec.GroupRef.Name == group.Name {
+			for _, id := range binding.Status.Members {
+				bound[id] = true
+			}
+		}
+	}
+
+	added, removed := 0, 0
+	for id := range desired {
+		if current[id] {
+			continue
+		}
+		if err := svc.AddGroupMember(ctx, group.Status.ID, id); err != nil {
+			return err
+		}
+		added++
+	}
+	for _, id := range group.Status.Members {
+		if desired[id] || bound[id] || !current[id] {
+			continue
+		}
+		err := svc.RemoveGroupMember(ctx, group.Status.ID, id)
+		if err != nil && !idmsvc.IsNotFound(err) {
+			return err
+		}
+		removed++
+	}
+	if added > 0 || removed > 0 {
+		log.FromContext(ctx).Info("Updated selected group members", "added", added, "removed", removed)
+		r.Recorder.Eventf(group, corev1.EventTypeNormal, "MembershipUpdated", "Added %d and removed %d members of group %s", added, removed, group.Status.ID)
+	}
+
+	group.Status.Members = make([]string, 0, len(desired))
+	for id := range desired {
+		group.Status.Members = append(group.Status.Members, id)
+	}
+	sort.Strings(group.Status.Members)
+	if len(group.Status.Members) == 0 {
+		group.Status.Members = nil
+	}
+	return nil
+}
+
+// userToGroups maps a User to the Groups of its namespace whose memberSelector matches its
+// labels. Updates are mapped with the old and the new labels, so a Group also reconciles
+// when a User stops matching.
+func (r *GroupReconciler) userToGroups(ctx context.Context, obj client.Object) []reconcile.Request {
+	groups := &idmv1.GroupList{}
+	if err := r.List(ctx, groups, client.InNamespace(obj.GetNamespace())); err != nil {
+		log.FromContext(ctx).Error(err, "Failed to list Groups")
+		return nil
+	}
+
+	var requests []reconcile.Request
+	for _, group := range groups.Items {
+		if group.Spec.MemberSelector == nil {
+			continue
+		}
+		selector, err := metav1.LabelSelectorAsSelector(group.Spec.MemberSelector)
+		if err != nil || !selector.Matches(labels.Set(obj.GetLabels())) {
+			continue
+		}
+		requests = append(requests, reconcile.Request{
+			NamespacedName: types.NamespacedName{Namespace: group.Namespace, Name: group.Name},
+		})
+	}
+	return requests
+}
+
+// groupToChildren maps a Group to the Groups nested in it, so they are nested as soon as
+// the parent is created in the identity system
+func (r *GroupReconciler) groupToChildren(ctx context.Context, obj client.Object) []reconcile.Request {
+	groups := &idmv1.GroupList{}
+	if err := r.List(ctx, groups, client.InNamespace(obj.GetNamespace())); err != nil {
+		log.FromContext(ctx).Error(err, "Failed to list Groups")
+		return nil
+	}
+
+	var requests []reconcile.Request
+	for _, group := range groups.Items {
+		if group.Spec.ParentGroupRef != nil && group.Spec.ParentGroupRef.Name == obj.GetName() {
+			requests = append(requests, reconcile.Request{
+				NamespacedName: types.NamespacedName{Namespace: group.Namespace, Name: group.Name},
+			})
+		}
+	}
+	return requests
+}
+
+// setDegraded records the failure on the group status; errors updating the status are only logged
+func (r *GroupReconciler) setDegraded(ctx context.Context, group, original *idmv1.Group, reason string, cause error) {
+	log := log.FromContext(ctx)
+
+	r.Recorder.Event(group, corev1.EventTypeWarning, "ExternalAPIError", cause.Error())
+
+	reason = failureReason(cause, reason)
+	status.MarkFailed(group, reason, cause.Error(), idmsvc.IsTerminal(cause))
+
+	if err := patchStatus(ctx, r.Client, group, original); err != nil {
+		log.Error(err, "Failed to update group status")
+	}
+}
+
+// SetupWithManager sets up the controller with the Manager.
+func (r *GroupReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&idmv1.Group{}).
+		Watches(&idmv1.Group{}, handler.EnqueueRequestsFromMapFunc(r.groupToChildren)).
+		Watches(&idmv1.User{}, handler.EnqueueRequestsFromMapFunc(r.userToGroups)).
+		WithOptions(r.Options.controllerOptions()).
+		Complete(withDrain(withCorrelationID(r)))
+}
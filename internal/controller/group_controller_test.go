@@ -0,0 +1,106 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	idmv1 "github.com/m15ch4/go-identity-operator/api/v1"
+	"github.com/m15ch4/go-identity-operator/pkg/identityclient/fake"
+)
+
+var _ = Describe("Group controller", func() {
+	var (
+		ctx context.Context
+		svc *fake.IdentityService
+	)
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		svc = fake.NewIdentityService()
+	})
+
+	It("keeps the members selected by the memberSelector of a Group in sync", func() {
+		extUser, err := svc.CreateUser(ctx, &idmv1.UserSpec{Name: "jackr", Role: "user"})
+		Expect(err).NotTo(HaveOccurred())
+		current := &idmv1.User{
+			ObjectMeta: metav1.ObjectMeta{
+				GenerateName: "user-",
+				Namespace:    "default",
+				Labels:       map[string]string{"team": "platform"},
+			},
+			Spec: idmv1.UserSpec{Name: "jackr", Password: "secret", Role: "user"},
+		}
+		Expect(k8sClient.Create(ctx, current)).To(Succeed())
+		DeferCleanup(func() {
+			Expect(client.IgnoreNotFound(k8sClient.Delete(ctx, current))).To(Succeed())
+		})
+		current.Status.ID = extUser.ID
+		Expect(k8sClient.Status().Update(ctx, current)).To(Succeed())
+
+		group := &idmv1.Group{
+			ObjectMeta: metav1.ObjectMeta{GenerateName: "group-", Namespace: "default"},
+			Spec: idmv1.GroupSpec{
+				Name:           "platform",
+				MemberSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"team": "platform"}},
+			},
+		}
+		Expect(k8sClient.Create(ctx, group)).To(Succeed())
+		DeferCleanup(func() {
+			current := &idmv1.Group{}
+			Expect(k8sClient.Get(ctx, client.ObjectKeyFromObject(group), current)).To(Succeed())
+			current.SetFinalizers(nil)
+			Expect(k8sClient.Update(ctx, current)).To(Succeed())
+			Expect(k8sClient.Delete(ctx, current)).To(Succeed())
+		})
+		groupReconciler := &GroupReconciler{
+			Client:          k8sClient,
+			Scheme:          k8sClient.Scheme(),
+			Recorder:        record.NewFakeRecorder(100),
+			IdentityService: svc,
+		}
+		reconcileGroup := func() *idmv1.Group {
+			_, err := groupReconciler.Reconcile(ctx, reconcile.Request{NamespacedName: client.ObjectKeyFromObject(group)})
+			Expect(err).NotTo(HaveOccurred())
+			current := &idmv1.Group{}
+			Expect(k8sClient.Get(ctx, client.ObjectKeyFromObject(group), current)).To(Succeed())
+			return current
+		}
+
+		By("adding the selected user")
+		synced := reconcileGroup()
+		Expect(synced.Status.Members).To(ConsistOf(current.Status.ID))
+		Expect(svc.Members[synced.Status.ID]).To(HaveKey(current.Status.ID))
+		Expect(groupReconciler.userToGroups(ctx, current)).To(ConsistOf(reconcile.Request{NamespacedName: client.ObjectKeyFromObject(group)}))
+
+		By("removing the user once its labels no longer match")
+		Expect(k8sClient.Get(ctx, client.ObjectKeyFromObject(current), current)).To(Succeed())
+		current.Labels = nil
+		Expect(k8sClient.Update(ctx, current)).To(Succeed())
+		synced = reconcileGroup()
+		Expect(synced.Status.Members).To(BeEmpty())
+		Expect(svc.Members[synced.Status.ID]).NotTo(HaveKey(current.Status.ID))
+	})
+})
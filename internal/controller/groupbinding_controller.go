@@ -0,0 +1,331 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	idmv1 "github.com/m15ch4/go-identity-operator/api/v1"
+	"github.com/m15ch4/go-identity-operator/internal/status"
+	idmsvc "github.com/m15ch4/go-identity-operator/pkg/identityclient"
+)
+
+const groupBindingFinalizer = "micze.io/groupbinding-finalizer"
+
+// GroupBindingReconciler reconciles a GroupBinding object
+type GroupBindingReconciler struct {
+	client.Client
+	Scheme *runtime.Scheme
+
+	// Recorder emits Events on GroupBindings
+	Recorder record.EventRecorder
+
+	// IdentityService is the long-lived service used for Groups without an instanceRef
+	IdentityService idmsvc.IdentityAPI
+
+	// Options tunes the workers and the rate limiter of the controller
+	Options ControllerOptions
+
+	// DriftResyncPeriod is the interval after which the group membership is compared
+	// with the binding again. Zero disables periodic resync.
+	DriftResyncPeriod time.Duration
+
+	// CredentialsSecret optionally references a Secret with IDM_USER and IDM_PASS keys
+	// used to log in to the identity system
+	CredentialsSecret types.NamespacedName
+}
+
+//+kubebuilder:rbac:groups=idm.micze.io,resources=groupbindings,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=idm.micze.io,resources=groupbindings/status,verbs=get;update;patch
+//+kubebuilder:rbac:groups=idm.micze.io,resources=groupbindings/finalizers,verbs=update
+//+kubebuilder:rbac:groups=idm.micze.io,resources=groups,verbs=get;list;watch
+//+kubebuilder:rbac:groups=idm.micze.io,resources=users,verbs=get;list;watch
+
+// Reconcile adds the bound users to the group in the identity system and removes the
+// users that were bound before but no longer are. Members of the group that were not
+// added by the binding are left untouched.
+func (r *GroupBindingReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	log := log.FromContext(ctx)
+
+	binding := &idmv1.GroupBinding{}
+	err := r.Get(ctx, req.NamespacedName, binding)
+	if err != nil {
+		if errors.IsNotFound(err) {
+			log.Info("GroupBinding resource not found. Ignoring since object must be deleted")
+			return ctrl.Result{}, nil
+		}
+		log.Error(err, "Failed to get GroupBinding")
+		return ctrl.Result{}, err
+	}
+	original := binding.DeepCopy()
+
+	// Leave the identity system alone while paused, deletion waits for the resume
+	isPaused := paused(binding, binding.Spec.Paused)
+	setPaused(&binding.Status.Conditions, binding.Generation, isPaused)
+	if isPaused {
+		log.Info("Reconciliation is paused")
+		if !equality.Semantic.DeepEqual(original.Status, binding.Status) {
+			err = patchStatus(ctx, r.Client, binding, original)
+			if err != nil {
+				log.Info("Failed to update group binding status")
+				return ctrl.Result{}, err
+			}
+		}
+		return ctrl.Result{}, nil
+	}
+
+	// Fetch the Group, the binding is reconciled again once it exists in the identity system
+	group := &idmv1.Group{}
+	err = r.Get(ctx, types.NamespacedName{Namespace: binding.Namespace, Name: binding.Spec.GroupRef.Name}, group)
+	if err != nil && !errors.IsNotFound(err) {
+		return ctrl.Result{}, err
+	}
+	groupReady := err == nil && group.Status.ID != "" && group.DeletionTimestamp.IsZero()
+
+	// Remove the members added by the binding before letting it go
+	if !binding.ObjectMeta.DeletionTimestamp.IsZero() {
+		if containsString(binding.GetFinalizers(), groupBindingFinalizer) {
+			if groupReady {
+				svc, err := identityServiceFor(ctx, r.Client, group.Namespace, r.Options.Config.instanceRef(group.Spec.InstanceRef), r.IdentityService, r.CredentialsSecret)
+				if err != nil {
+					return requeueFor(ctx, err)
+				}
+				svc = withDryRun(svc, binding, r.Recorder, r.Options)
+				for _, member := range binding.Status.Members {
+					if containsString(group.Status.Members, member) {
+						continue
+					}
+					err := svc.RemoveGroupMember(ctx, group.Status.ID, member)
+					if err != nil && !idmsvc.IsNotFound(err) {
+						r.setDegraded(ctx, binding, original, "FinalizeFailed", err)
+						return requeueFor(ctx, err)
+					}
+				}
+			}
+
+			err = patchWithRetry(ctx, r.Client, binding, func() {
+				controllerutil.RemoveFinalizer(binding, groupBindingFinalizer)
+			})
+			if err != nil {
+				return ctrl.Result{}, err
+			}
+		}
+		return ctrl.Result{}, nil
+	}
+
+	if !groupReady {
+		r.setCondition(binding, idmv1.ConditionReady, metav1.ConditionFalse, "GroupNotReady",
+			fmt.Sprintf("Group %s is not created in identity system yet", binding.Spec.GroupRef.Name))
+		return ctrl.Result{}, r.updateStatus(ctx, original, binding)
+	}
+
+	svc, err := identityServiceFor(ctx, r.Client, group.Namespace, r.Options.Config.instanceRef(group.Spec.InstanceRef), r.IdentityService, r.CredentialsSecret)
+	if err != nil {
+		r.setDegraded(ctx, binding, original, "ConfigurationFailed", err)
+		return requeueFor(ctx, err)
+	}
+	svc = withDryRun(svc, binding, r.Recorder, r.Options)
+
+	// Resolve the bound Users to external user IDs
+	desired := map[string]bool{}
+	var pending []string
+	for _, ref := range binding.Spec.Users {
+		user := &idmv1.User{}
+		err := r.Get(ctx, types.NamespacedName{Namespace: binding.Namespace, Name: ref.Name}, user)
+		if err != nil && !errors.IsNotFound(err) {
+			return ctrl.Result{}, err
+		}
+		if err != nil || user.Status.ID == "" {
+			pending = append(pending, ref.Name)
+			continue
+		}
+		desired[user.Status.ID] = true
+	}
+
+	members, err := svc.ListGroupMembers(ctx, group.Status.ID)
+	if err != nil {
+		r.setDegraded(ctx, binding, original, "ListMembersFailed", err)
+		return requeueFor(ctx, err)
+	}
+	current := map[string]bool{}
+	for _, member := range members {
+		current[member] = true
+	}
+
+	// Add the missing members
+	added := 0
+	for id := range desired {
+		if current[id] {
+			continue
+		}
+		err := svc.AddGroupMember(ctx, group.Status.ID, id)
+		if err != nil {
+			r.setDegraded(ctx, binding, original, "AddMemberFailed", err)
+			return requeueFor(ctx, err)
+		}
+		added++
+	}
+
+	// Remove the members previously added by this binding that are no longer bound, unless
+	// the memberSelector of the Group selects them
+	removed := 0
+	for _, id := range binding.Status.Members {
+		if desired[id] || !current[id] || containsString(group.Status.Members, id) {
+			continue
+		}
+		err := svc.RemoveGroupMember(ctx, group.Status.ID, id)
+		if err != nil && !idmsvc.IsNotFound(err) {
+			r.setDegraded(ctx, binding, original, "RemoveMemberFailed", err)
+			return requeueFor(ctx, err)
+		}
+		removed++
+	}
+
+	if added > 0 || removed > 0 {
+		log.Info("Updated group membership", "added", added, "removed", removed)
+		r.Recorder.Eventf(binding, corev1.EventTypeNormal, "MembershipUpdated", "Added %d and removed %d members of group %s", added, removed, group.Status.ID)
+	}
+
+	binding.Status.Members = make([]string, 0, len(desired))
+	for id := range desired {
+		binding.Status.Members = append(binding.Status.Members, id)
+	}
+	sort.Strings(binding.Status.Members)
+
+	if len(pending) > 0 {
+		r.setCondition(binding, idmv1.ConditionReady, metav1.ConditionFalse, "UsersPending",
+			fmt.Sprintf("Users not created in identity system yet: %s", strings.Join(pending, ", ")))
+	} else {
+		r.setCondition(binding, idmv1.ConditionReady, metav1.ConditionTrue, "Bound", "All users are members of the group")
+	}
+	r.setCondition(binding, idmv1.ConditionDegraded, metav1.ConditionFalse, "Bound", "Group membership is in sync")
+
+	err = r.updateStatus(ctx, original, binding)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+
+	if !containsString(binding.GetFinalizers(), groupBindingFinalizer) {
+		if err := patchWithRetry(ctx, r.Client, binding, func() {
+			controllerutil.AddFinalizer(binding, groupBindingFinalizer)
+		}); err != nil {
+			return ctrl.Result{}, err
+		}
+	}
+
+	return ctrl.Result{RequeueAfter: r.Options.Config.driftResyncPeriod(r.DriftResyncPeriod)}, nil
+}
+
+// updateStatus writes the status of the binding if it changed
+func (r *GroupBindingReconciler) updateStatus(ctx context.Context, original, binding *idmv1.GroupBinding) error {
+	if equality.Semantic.DeepEqual(original.Status, binding.Status) {
+		return nil
+	}
+
+	err := patchStatus(ctx, r.Client, binding, original)
+	if err != nil {
+		log.FromContext(ctx).Info("Failed to update group binding status")
+	}
+	return err
+}
+
+// setCondition sets the given condition on the binding status, observed at the current generation
+func (r *GroupBindingReconciler) setCondition(binding *idmv1.GroupBinding, conditionType string, conditionStatus metav1.ConditionStatus, reason, message string) {
+	status.SetCondition(binding, conditionType, conditionStatus, reason, message)
+}
+
+// setDegraded records the failure on the binding status; errors updating the status are only logged
+func (r *GroupBindingReconciler) setDegraded(ctx context.Context, binding, original *idmv1.GroupBinding, reason string, cause error) {
+	log := log.FromContext(ctx)
+
+	r.Recorder.Event(binding, corev1.EventTypeWarning, "ExternalAPIError", cause.Error())
+
+	reason = failureReason(cause, reason)
+	r.setCondition(binding, idmv1.ConditionReady, metav1.ConditionFalse, reason, cause.Error())
+	r.setCondition(binding, idmv1.ConditionDegraded, metav1.ConditionTrue, reason, cause.Error())
+
+	if err := patchStatus(ctx, r.Client, binding, original); err != nil {
+		log.Error(err, "Failed to update group binding status")
+	}
+}
+
+// bindingsFor enqueues the GroupBindings in the namespace of obj that match
+func (r *GroupBindingReconciler) bindingsFor(ctx context.Context, obj client.Object, match func(*idmv1.GroupBinding) bool) []reconcile.Request {
+	bindings := &idmv1.GroupBindingList{}
+	if err := r.List(ctx, bindings, client.InNamespace(obj.GetNamespace())); err != nil {
+		log.FromContext(ctx).Error(err, "Failed to list GroupBindings")
+		return nil
+	}
+
+	var requests []reconcile.Request
+	for i := range bindings.Items {
+		if match(&bindings.Items[i]) {
+			requests = append(requests, reconcile.Request{
+				NamespacedName: types.NamespacedName{Namespace: bindings.Items[i].Namespace, Name: bindings.Items[i].Name},
+			})
+		}
+	}
+	return requests
+}
+
+// groupToBindings enqueues the GroupBindings referencing a Group
+func (r *GroupBindingReconciler) groupToBindings(ctx context.Context, obj client.Object) []reconcile.Request {
+	return r.bindingsFor(ctx, obj, func(binding *idmv1.GroupBinding) bool {
+		return binding.Spec.GroupRef.Name == obj.GetName()
+	})
+}
+
+// userToBindings enqueues the GroupBindings binding a User, so they pick up its ID
+// once it is created
+func (r *GroupBindingReconciler) userToBindings(ctx context.Context, obj client.Object) []reconcile.Request {
+	return r.bindingsFor(ctx, obj, func(binding *idmv1.GroupBinding) bool {
+		for _, ref := range binding.Spec.Users {
+			if ref.Name == obj.GetName() {
+				return true
+			}
+		}
+		return false
+	})
+}
+
+// SetupWithManager sets up the controller with the Manager.
+func (r *GroupBindingReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&idmv1.GroupBinding{}).
+		WithOptions(r.Options.controllerOptions()).
+		Watches(&idmv1.Group{}, handler.EnqueueRequestsFromMapFunc(r.groupToBindings)).
+		Watches(&idmv1.User{}, handler.EnqueueRequestsFromMapFunc(r.userToBindings)).
+		Complete(withDrain(withCorrelationID(r)))
+}
@@ -0,0 +1,135 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	idmv1 "github.com/m15ch4/go-identity-operator/api/v1"
+	"github.com/m15ch4/go-identity-operator/pkg/identityclient/fake"
+)
+
+var _ = Describe("GroupBinding controller", func() {
+	var (
+		ctx        context.Context
+		svc        *fake.IdentityService
+		reconciler *GroupBindingReconciler
+	)
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		svc = fake.NewIdentityService()
+		reconciler = &GroupBindingReconciler{
+			Client:            k8sClient,
+			Scheme:            k8sClient.Scheme(),
+			Recorder:          record.NewFakeRecorder(100),
+			IdentityService:   svc,
+			DriftResyncPeriod: time.Minute,
+		}
+	})
+
+	// createSynced creates the object and sets the ID of its external counterpart in its status
+	createSynced := func(obj client.Object, setID func()) {
+		Expect(k8sClient.Create(ctx, obj)).To(Succeed())
+		DeferCleanup(func() {
+			Expect(client.IgnoreNotFound(k8sClient.Delete(ctx, obj))).To(Succeed())
+		})
+		setID()
+		Expect(k8sClient.Status().Update(ctx, obj)).To(Succeed())
+	}
+
+	createUser := func(name string) *idmv1.User {
+		extUser, err := svc.CreateUser(ctx, &idmv1.UserSpec{Name: name, Role: "user"})
+		Expect(err).NotTo(HaveOccurred())
+		user := &idmv1.User{
+			ObjectMeta: metav1.ObjectMeta{GenerateName: "user-", Namespace: "default"},
+			Spec:       idmv1.UserSpec{Name: name, Password: "secret", Role: "user"},
+		}
+		createSynced(user, func() { user.Status.ID = extUser.ID })
+		return user
+	}
+
+	It("adds, removes and releases the bound members of the group", func() {
+		extGroup, err := svc.CreateGroup(ctx, &idmv1.GroupSpec{Name: "platform"})
+		Expect(err).NotTo(HaveOccurred())
+		group := &idmv1.Group{
+			ObjectMeta: metav1.ObjectMeta{GenerateName: "group-", Namespace: "default"},
+			Spec:       idmv1.GroupSpec{Name: "platform"},
+		}
+		createSynced(group, func() { group.Status.ID = extGroup.ID })
+		jack := createUser("jackr")
+		jane := createUser("janed")
+
+		binding := &idmv1.GroupBinding{
+			ObjectMeta: metav1.ObjectMeta{GenerateName: "binding-", Namespace: "default"},
+			Spec: idmv1.GroupBindingSpec{
+				GroupRef: idmv1.GroupReference{Name: group.Name},
+				Users:    []idmv1.UserReference{{Name: jack.Name}, {Name: jane.Name}},
+			},
+		}
+		Expect(k8sClient.Create(ctx, binding)).To(Succeed())
+		key := client.ObjectKeyFromObject(binding)
+		reconcileBinding := func() *idmv1.GroupBinding {
+			_, err := reconciler.Reconcile(ctx, reconcile.Request{NamespacedName: key})
+			Expect(err).NotTo(HaveOccurred())
+			current := &idmv1.GroupBinding{}
+			err = k8sClient.Get(ctx, key, current)
+			if errors.IsNotFound(err) {
+				return nil
+			}
+			Expect(err).NotTo(HaveOccurred())
+			return current
+		}
+
+		By("adding the bound users to the external group")
+		current := reconcileBinding()
+		Expect(current.Finalizers).To(ContainElement(groupBindingFinalizer))
+		Expect(meta.IsStatusConditionTrue(current.Status.Conditions, idmv1.ConditionReady)).To(BeTrue())
+		Expect(current.Status.Members).To(ConsistOf(jack.Status.ID, jane.Status.ID))
+		Expect(svc.Members[extGroup.ID]).To(HaveKey(jack.Status.ID))
+		Expect(svc.Members[extGroup.ID]).To(HaveKey(jane.Status.ID))
+
+		By("removing the users no longer bound")
+		current.Spec.Users = current.Spec.Users[:1]
+		Expect(k8sClient.Update(ctx, current)).To(Succeed())
+		current = reconcileBinding()
+		Expect(current.Status.Members).To(ConsistOf(jack.Status.ID))
+		Expect(svc.Members[extGroup.ID]).NotTo(HaveKey(jane.Status.ID))
+
+		By("leaving members added outside the binding alone")
+		Expect(svc.AddGroupMember(ctx, extGroup.ID, jane.Status.ID)).To(Succeed())
+		reconcileBinding()
+		Expect(svc.Members[extGroup.ID]).To(HaveKey(jane.Status.ID))
+
+		By("removing the bound members when the binding is deleted")
+		Expect(k8sClient.Delete(ctx, current)).To(Succeed())
+		Expect(reconcileBinding()).To(BeNil())
+		Expect(svc.Members[extGroup.ID]).NotTo(HaveKey(jack.Status.ID))
+		Expect(svc.Members[extGroup.ID]).To(HaveKey(jane.Status.ID))
+	})
+})
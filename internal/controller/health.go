@@ -0,0 +1,229 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"sigs.k8s.io/controller-runtime/pkg/healthz"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+
+	idmsvc "github.com/m15ch4/go-identity-operator/pkg/identityclient"
+)
+
+// backendUnavailableRequeue is the delay after which objects are reconciled again while
+// their identity system is unavailable
+const backendUnavailableRequeue = 30 * time.Second
+
+// credentialsInvalidRequeue is the delay after which objects are reconciled again while
+// the identity system rejects the credentials of the operator. It is long to avoid locking
+// the account, changes of the credentials Secret trigger a reconcile right away.
+const credentialsInvalidRequeue = 10 * time.Minute
+
+// backendFailureMinRequests is the number of requests within the failure window below
+// which the failure rate of the identity systems does not affect readiness
+const backendFailureMinRequests = 20
+
+// backendUnavailableError is returned instead of an identity service while the latest
+// probe found its identity system unreachable, so reconciles wait for it to recover
+// instead of each failing on its own requests
+type backendUnavailableError struct {
+	// instance is the name of the IdentityInstance, empty for the operator-level service
+	instance string
+	reason   string
+}
+
+func (e *backendUnavailableError) Error() string {
+	if e.instance == "" {
+		return "identity system is unavailable: " + e.reason
+	}
+	return fmt.Sprintf("identity system of instance %s is unavailable: %s", e.instance, e.reason)
+}
+
+// isBackendUnavailable reports whether err was returned instead of an identity service
+// because its identity system is unavailable
+func isBackendUnavailable(err error) bool {
+	var unavailable *backendUnavailableError
+	return errors.As(err, &unavailable)
+}
+
+// instanceNotReadyError is returned instead of the identity service of an IdentityInstance
+// whose Ready condition is False. Objects are not requeued for it, the controllers watch
+// the instance and enqueue them once it becomes Ready.
+type instanceNotReadyError struct {
+	instance string
+	reason   string
+}
+
+func (e *instanceNotReadyError) Error() string {
+	return fmt.Sprintf("instance %s is not ready: %s", e.instance, e.reason)
+}
+
+// instanceConfigError is returned instead of logging in to the identity system of an
+// IdentityInstance whose configuration, e.g. a referenced Secret, cannot be read. The
+// identity system was not contacted and is neither reachable nor unreachable.
+type instanceConfigError struct {
+	err error
+}
+
+func (e *instanceConfigError) Error() string {
+	return "invalid configuration: " + e.err.Error()
+}
+
+func (e *instanceConfigError) Unwrap() error {
+	return e.err
+}
+
+// isInstanceConfigError reports whether err was returned because the configuration of an
+// IdentityInstance cannot be read
+func isInstanceConfigError(err error) bool {
+	var configErr *instanceConfigError
+	return errors.As(err, &configErr)
+}
+
+// waitsForInstance reports whether err was returned instead of the identity service of an
+// IdentityInstance that is not ready or whose identity system is unavailable
+func waitsForInstance(err error) bool {
+	var notReady *instanceNotReadyError
+	if errors.As(err, &notReady) {
+		return true
+	}
+	var unavailable *backendUnavailableError
+	return errors.As(err, &unavailable) && unavailable.instance != ""
+}
+
+// backendHealth holds the outcome of the latest probe of an identity system, nil while
+// it is available or was not probed yet
+type backendHealth struct {
+	mu  sync.RWMutex
+	err error
+}
+
+func (h *backendHealth) get() error {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	return h.err
+}
+
+// set records the outcome of a probe and reports whether the availability changed
+func (h *backendHealth) set(err error) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	changed := (h.err == nil) != (err == nil)
+	h.err = err
+	return changed
+}
+
+// sharedBackendHealth is the availability of the operator-level identity service
+var sharedBackendHealth = &backendHealth{}
+
+// BackendProber probes the operator-level identity service at startup and periodically.
+// Objects without an IdentityInstance are not reconciled against it while it is
+// unreachable and the manager reports not ready.
+type BackendProber struct {
+	Service *idmsvc.IdentityService
+	// Configured is false when no operator-level identity app is configured, e.g. when all
+	// objects reference an IdentityInstance; the default endpoint is not probed then
+	Configured bool
+	// Interval between two probes, zero disables probing
+	Interval time.Duration
+}
+
+var _ manager.LeaderElectionRunnable = &BackendProber{}
+
+// Start probes the identity service until the context is cancelled
+func (p *BackendProber) Start(ctx context.Context) error {
+	if p.Interval <= 0 || !p.Configured {
+		return nil
+	}
+	p.probe(ctx)
+
+	ticker := time.NewTicker(p.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+			p.probe(ctx)
+		}
+	}
+}
+
+// NeedLeaderElection is false, standby replicas report their readiness as well
+func (p *BackendProber) NeedLeaderElection() bool {
+	return false
+}
+
+// probe obtains a token of the identity service, logging in only once the cached token
+// expires so probes do not add logins. Rejected credentials still prove the identity system
+// reachable, they surface on the reconciled objects instead.
+func (p *BackendProber) probe(ctx context.Context) {
+	log := log.FromContext(ctx).WithName("backend-prober")
+
+	probeCtx, cancel := context.WithTimeout(ctx, p.Interval)
+	defer cancel()
+
+	_, err := p.Service.Token(probeCtx)
+	if ctx.Err() != nil {
+		// the manager is shutting down
+		return
+	}
+	if !idmsvc.IsUnavailable(err) {
+		err = nil
+	}
+
+	if sharedBackendHealth.set(err) {
+		if err != nil {
+			log.Error(err, "Identity system became unavailable")
+		} else {
+			log.Info("Identity system is available")
+		}
+	}
+}
+
+// Checker reports not ready while the identity service is unavailable
+func (p *BackendProber) Checker(_ *http.Request) error {
+	if err := sharedBackendHealth.get(); err != nil {
+		return fmt.Errorf("identity system unavailable: %w", err)
+	}
+	return nil
+}
+
+// BackendFailureChecker reports not ready while the share of requests to the identity
+// systems failing within the last minutes reaches the threshold, zero disables the check.
+// It covers the IdentityInstances the BackendProber does not probe.
+func BackendFailureChecker(threshold float64) healthz.Checker {
+	return func(_ *http.Request) error {
+		if threshold <= 0 {
+			return nil
+		}
+		rate, requests := idmsvc.FailureRate()
+		if requests >= backendFailureMinRequests && rate >= threshold {
+			return fmt.Errorf("%.0f%% of the last %d requests to the identity systems failed within %s", rate*100, requests, idmsvc.FailureWindow)
+		}
+		return nil
+	}
+}
@@ -0,0 +1,198 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	idmv1 "github.com/m15ch4/go-identity-operator/api/v1"
+	idmsvc "github.com/m15ch4/go-identity-operator/pkg/identityclient"
+)
+
+// defaultAuditInterval applies when spec.interval is not set
+const defaultAuditInterval = time.Hour
+
+// IdentityAuditReconciler reconciles an IdentityAudit object
+type IdentityAuditReconciler struct {
+	client.Client
+	Scheme *runtime.Scheme
+
+	// IdentityService is the long-lived service used for audits without an instanceRef
+	IdentityService idmsvc.IdentityAPI
+
+	// CredentialsSecret optionally references a Secret with IDM_USER and IDM_PASS keys
+	// used to log in to the identity system. It takes precedence over the environment.
+	CredentialsSecret types.NamespacedName
+
+	// Config supplies the default instance of the IdentityOperatorConfig for audits
+	// and Users without an instanceRef
+	Config *OperatorConfig
+}
+
+//+kubebuilder:rbac:groups=idm.micze.io,resources=identityaudits,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=idm.micze.io,resources=identityaudits/status,verbs=get;update;patch
+//+kubebuilder:rbac:groups=idm.micze.io,resources=identityaudits/finalizers,verbs=update
+//+kubebuilder:rbac:groups=idm.micze.io,resources=users,verbs=get;list;watch
+
+// Reconcile lists the users of the identity system once per interval and reports those
+// not managed by any User referencing the same IdentityInstance.
+func (r *IdentityAuditReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	log := log.FromContext(ctx)
+
+	audit := &idmv1.IdentityAudit{}
+	err := r.Get(ctx, req.NamespacedName, audit)
+	if err != nil {
+		if errors.IsNotFound(err) {
+			log.Info("IdentityAudit resource not found. Ignoring since object must be deleted")
+			return ctrl.Result{}, nil
+		}
+		log.Error(err, "Failed to get IdentityAudit")
+		return ctrl.Result{}, err
+	}
+	original := audit.DeepCopy()
+
+	interval := audit.Spec.Interval.Duration
+	if interval <= 0 {
+		interval = defaultAuditInterval
+	}
+
+	// Scan again once the interval has passed or the spec changed
+	ready := meta.FindStatusCondition(audit.Status.Conditions, idmv1.ConditionReady)
+	if audit.Status.LastScanTime != nil && ready != nil && ready.ObservedGeneration == audit.Generation {
+		if next := time.Until(audit.Status.LastScanTime.Add(interval)); next > 0 {
+			return ctrl.Result{RequeueAfter: next}, nil
+		}
+	}
+
+	scanErr := r.scan(ctx, audit)
+	if scanErr != nil {
+		meta.SetStatusCondition(&audit.Status.Conditions, metav1.Condition{
+			Type:               idmv1.ConditionReady,
+			Status:             metav1.ConditionFalse,
+			ObservedGeneration: audit.Generation,
+			Reason:             failureReason(scanErr, "ScanFailed"),
+			Message:            scanErr.Error(),
+		})
+	} else {
+		meta.SetStatusCondition(&audit.Status.Conditions, metav1.Condition{
+			Type:               idmv1.ConditionReady,
+			Status:             metav1.ConditionTrue,
+			ObservedGeneration: audit.Generation,
+			Reason:             "ScanSucceeded",
+			Message:            fmt.Sprintf("Found %d orphaned users", audit.Status.OrphanCount),
+		})
+	}
+
+	if !equality.Semantic.DeepEqual(original.Status, audit.Status) {
+		err = patchStatus(ctx, r.Client, audit, original)
+		if err != nil {
+			log.Info("Failed to update IdentityAudit status")
+			return ctrl.Result{}, err
+		}
+	}
+
+	if scanErr != nil {
+		return requeueFor(ctx, scanErr)
+	}
+
+	log.Info("Audit finished", "externalUsers", audit.Status.ExternalUsers, "orphans", audit.Status.OrphanCount)
+	return ctrl.Result{RequeueAfter: interval}, nil
+}
+
+// scan compares the external users with the Users managed in the same identity system
+// and records the orphans on the audit status
+func (r *IdentityAuditReconciler) scan(ctx context.Context, audit *idmv1.IdentityAudit) error {
+	svc, err := identityServiceFor(ctx, r.Client, audit.Namespace, r.Config.instanceRef(audit.Spec.InstanceRef), r.IdentityService, r.CredentialsSecret)
+	if err != nil {
+		return err
+	}
+
+	users := &idmv1.UserList{}
+	err = r.List(ctx, users)
+	if err != nil {
+		return err
+	}
+
+	managed := map[string]bool{}
+	for _, user := range users.Items {
+		if user.Status.ID != "" && sameInstance(r.Config.instanceRef(user.Spec.InstanceRef), r.Config.instanceRef(audit.Spec.InstanceRef)) {
+			managed[user.Status.ID] = true
+		}
+	}
+
+	ignored := map[string]bool{}
+	for _, name := range audit.Spec.IgnoreNames {
+		ignored[name] = true
+	}
+
+	// the external users are read page by page, only the orphans are kept
+	externalUsers := 0
+	var orphans []idmv1.OrphanedUser
+	err = idmsvc.EachUser(ctx, svc, idmsvc.UserFilter{}, func(extUser *idmsvc.IdentityUser) error {
+		externalUsers++
+		if !managed[extUser.ID] && !ignored[extUser.Name] {
+			orphans = append(orphans, idmv1.OrphanedUser{ID: extUser.ID, Name: extUser.Name})
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	sort.Slice(orphans, func(i, j int) bool {
+		if orphans[i].Name != orphans[j].Name {
+			return orphans[i].Name < orphans[j].Name
+		}
+		return orphans[i].ID < orphans[j].ID
+	})
+
+	now := metav1.Now()
+	audit.Status.LastScanTime = &now
+	audit.Status.ExternalUsers = externalUsers
+	audit.Status.OrphanCount = len(orphans)
+	audit.Status.Orphans = orphans
+
+	return nil
+}
+
+// sameInstance reports whether two instance references select the same identity system,
+// where no reference stands for the operator-level configuration
+func sameInstance(a, b *idmv1.IdentityInstanceReference) bool {
+	if a == nil || b == nil {
+		return a == nil && b == nil
+	}
+	return a.Name == b.Name
+}
+
+// SetupWithManager sets up the controller with the Manager.
+func (r *IdentityAuditReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&idmv1.IdentityAudit{}).
+		Complete(withDrain(withCorrelationID(r)))
+}
@@ -0,0 +1,112 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	idmv1 "github.com/m15ch4/go-identity-operator/api/v1"
+	"github.com/m15ch4/go-identity-operator/pkg/identityclient/fake"
+)
+
+var _ = Describe("IdentityAudit controller", func() {
+	var (
+		ctx        context.Context
+		svc        *fake.IdentityService
+		reconciler *IdentityAuditReconciler
+	)
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		svc = fake.NewIdentityService()
+		reconciler = &IdentityAuditReconciler{
+			Client:          k8sClient,
+			Scheme:          k8sClient.Scheme(),
+			IdentityService: svc,
+		}
+	})
+
+	It("reports the external users no User manages once per interval", func() {
+		var managed string
+		for _, name := range []string{"jackr", "orphan", "robot"} {
+			extUser, err := svc.CreateUser(ctx, &idmv1.UserSpec{Name: name, Role: "user"})
+			Expect(err).NotTo(HaveOccurred())
+			if name == "jackr" {
+				managed = extUser.ID
+			}
+		}
+		user := &idmv1.User{
+			ObjectMeta: metav1.ObjectMeta{GenerateName: "user-", Namespace: "default"},
+			Spec:       idmv1.UserSpec{Name: "jackr", Password: "secret", Role: "user"},
+		}
+		Expect(k8sClient.Create(ctx, user)).To(Succeed())
+		DeferCleanup(k8sClient.Delete, ctx, user)
+		user.Status.ID = managed
+		Expect(k8sClient.Status().Update(ctx, user)).To(Succeed())
+
+		audit := &idmv1.IdentityAudit{
+			ObjectMeta: metav1.ObjectMeta{GenerateName: "audit-", Namespace: "default"},
+			Spec: idmv1.IdentityAuditSpec{
+				Interval:    metav1.Duration{Duration: time.Hour},
+				IgnoreNames: []string{"robot"},
+			},
+		}
+		Expect(k8sClient.Create(ctx, audit)).To(Succeed())
+		DeferCleanup(k8sClient.Delete, ctx, audit)
+		reconcileAudit := func() (ctrl.Result, *idmv1.IdentityAudit) {
+			result, err := reconciler.Reconcile(ctx, reconcile.Request{NamespacedName: client.ObjectKeyFromObject(audit)})
+			Expect(err).NotTo(HaveOccurred())
+			current := &idmv1.IdentityAudit{}
+			Expect(k8sClient.Get(ctx, client.ObjectKeyFromObject(audit), current)).To(Succeed())
+			return result, current
+		}
+
+		By("recording the orphans on the status")
+		result, current := reconcileAudit()
+		Expect(result.RequeueAfter).To(Equal(time.Hour))
+		Expect(meta.IsStatusConditionTrue(current.Status.Conditions, idmv1.ConditionReady)).To(BeTrue())
+		Expect(current.Status.ExternalUsers).To(Equal(3))
+		Expect(current.Status.Orphans).To(ConsistOf(HaveField("Name", "orphan")))
+
+		By("waiting for the interval before scanning again")
+		calls := svc.Calls["ListUsers"]
+		result, _ = reconcileAudit()
+		Expect(result.RequeueAfter).To(BeNumerically("<=", time.Hour))
+		Expect(svc.Calls["ListUsers"]).To(Equal(calls))
+
+		By("scanning again once the interval passed")
+		current.Spec.IgnoreNames = nil
+		Expect(k8sClient.Update(ctx, current)).To(Succeed())
+		scanned := metav1.NewTime(time.Now().Add(-2 * time.Hour))
+		current.Status.LastScanTime = &scanned
+		Expect(k8sClient.Status().Update(ctx, current)).To(Succeed())
+		_, current = reconcileAudit()
+		Expect(svc.Calls["ListUsers"]).To(BeNumerically(">", calls))
+		Expect(current.Status.OrphanCount).To(Equal(2))
+		Expect(current.Status.Orphans).To(ConsistOf(HaveField("Name", "orphan"), HaveField("Name", "robot")))
+	})
+})
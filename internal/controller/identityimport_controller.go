@@ -0,0 +1,254 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	idmv1 "github.com/m15ch4/go-identity-operator/api/v1"
+	idmsvc "github.com/m15ch4/go-identity-operator/pkg/identityclient"
+)
+
+// invalidNameChars matches the characters not allowed in object names
+var invalidNameChars = regexp.MustCompile(`[^a-z0-9.-]+`)
+
+// IdentityImportReconciler reconciles an IdentityImport object
+type IdentityImportReconciler struct {
+	client.Client
+	Scheme *runtime.Scheme
+
+	// Recorder emits Events on IdentityImports and the Users they create
+	Recorder record.EventRecorder
+
+	// IdentityService is the long-lived service used for imports without an instanceRef
+	IdentityService idmsvc.IdentityAPI
+
+	// CredentialsSecret optionally references a Secret with IDM_USER and IDM_PASS keys
+	// used to log in to the identity system. It takes precedence over the environment.
+	CredentialsSecret types.NamespacedName
+
+	// Config supplies the default instance of the IdentityOperatorConfig for imports
+	// and Users without an instanceRef
+	Config *OperatorConfig
+}
+
+//+kubebuilder:rbac:groups=idm.micze.io,resources=identityimports,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=idm.micze.io,resources=identityimports/status,verbs=get;update;patch
+//+kubebuilder:rbac:groups=idm.micze.io,resources=identityimports/finalizers,verbs=update
+
+// Reconcile creates the Users for the external users not managed yet, once per generation
+// of the IdentityImport.
+func (r *IdentityImportReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	log := log.FromContext(ctx)
+
+	imp := &idmv1.IdentityImport{}
+	err := r.Get(ctx, req.NamespacedName, imp)
+	if err != nil {
+		if errors.IsNotFound(err) {
+			log.Info("IdentityImport resource not found. Ignoring since object must be deleted")
+			return ctrl.Result{}, nil
+		}
+		log.Error(err, "Failed to get IdentityImport")
+		return ctrl.Result{}, err
+	}
+	original := imp.DeepCopy()
+
+	// Every generation is imported only once
+	complete := meta.FindStatusCondition(imp.Status.Conditions, idmv1.ConditionComplete)
+	if complete != nil && complete.Status == metav1.ConditionTrue && complete.ObservedGeneration == imp.Generation {
+		return ctrl.Result{}, nil
+	}
+
+	importErr := r.importUsers(ctx, imp)
+	if importErr != nil {
+		r.Recorder.Event(imp, corev1.EventTypeWarning, "ImportFailed", importErr.Error())
+		meta.SetStatusCondition(&imp.Status.Conditions, metav1.Condition{
+			Type:               idmv1.ConditionComplete,
+			Status:             metav1.ConditionFalse,
+			ObservedGeneration: imp.Generation,
+			Reason:             failureReason(importErr, "ImportFailed"),
+			Message:            importErr.Error(),
+		})
+	} else {
+		now := metav1.Now()
+		imp.Status.CompletionTime = &now
+		meta.SetStatusCondition(&imp.Status.Conditions, metav1.Condition{
+			Type:               idmv1.ConditionComplete,
+			Status:             metav1.ConditionTrue,
+			ObservedGeneration: imp.Generation,
+			Reason:             "Imported",
+			Message:            fmt.Sprintf("Imported %d users, skipped %d", imp.Status.ImportedCount, len(imp.Status.Skipped)),
+		})
+		r.Recorder.Eventf(imp, corev1.EventTypeNormal, "Imported", "Imported %d users from identity system", imp.Status.ImportedCount)
+	}
+
+	if !equality.Semantic.DeepEqual(original.Status, imp.Status) {
+		err = patchStatus(ctx, r.Client, imp, original)
+		if err != nil {
+			log.Info("Failed to update IdentityImport status")
+			return ctrl.Result{}, err
+		}
+	}
+
+	if importErr != nil {
+		return requeueFor(ctx, importErr)
+	}
+	return ctrl.Result{}, nil
+}
+
+// importUsers creates a User for every selected external user that is not managed by
+// a User of the same identity system yet. Users created before a failure are recorded,
+// so a retry continues where the previous attempt stopped.
+func (r *IdentityImportReconciler) importUsers(ctx context.Context, imp *idmv1.IdentityImport) error {
+	log := log.FromContext(ctx)
+
+	svc, err := identityServiceFor(ctx, r.Client, imp.Namespace, r.Config.instanceRef(imp.Spec.InstanceRef), r.IdentityService, r.CredentialsSecret)
+	if err != nil {
+		return err
+	}
+
+	users := &idmv1.UserList{}
+	err = r.List(ctx, users)
+	if err != nil {
+		return err
+	}
+	managed := map[string]bool{}
+	for _, user := range users.Items {
+		if user.Status.ID != "" && sameInstance(r.Config.instanceRef(user.Spec.InstanceRef), r.Config.instanceRef(imp.Spec.InstanceRef)) {
+			managed[user.Status.ID] = true
+		}
+	}
+
+	selected := map[string]bool{}
+	for _, name := range imp.Spec.Names {
+		selected[name] = true
+	}
+	ignored := map[string]bool{}
+	for _, name := range imp.Spec.IgnoreNames {
+		ignored[name] = true
+	}
+
+	imp.Status.Skipped = nil
+	importUser := func(listed *idmsvc.IdentityUser) error {
+		if managed[listed.ID] || ignored[listed.Name] || (len(selected) > 0 && !selected[listed.Name]) {
+			return nil
+		}
+
+		name := userObjectName(listed.Name)
+		if name == "" {
+			imp.Status.Skipped = append(imp.Status.Skipped, listed.Name)
+			return nil
+		}
+
+		// listings may omit details such as the role, read the complete user
+		extUser, err := svc.GetUser(ctx, listed.ID)
+		if err != nil {
+			return err
+		}
+
+		user := &idmv1.User{
+			ObjectMeta: metav1.ObjectMeta{
+				Namespace: imp.Namespace,
+				Name:      name,
+				Labels:    map[string]string{idmv1.LabelImportedBy: imp.Name},
+			},
+			Spec: idmv1.UserSpec{
+				Name:           extUser.Name,
+				Firstname:      extUser.Firstname,
+				Lastname:       extUser.Lastname,
+				Role:           extUser.Role,
+				Roles:          extUser.Roles,
+				Age:            extUser.Age,
+				Email:          extUser.Email,
+				Phone:          extUser.Phone,
+				DisplayName:    extUser.DisplayName,
+				InstanceRef:    imp.Spec.InstanceRef,
+				AdoptExisting:  true,
+				DeletionPolicy: imp.Spec.DeletionPolicy,
+			},
+		}
+		if user.Spec.DeletionPolicy == "" {
+			user.Spec.DeletionPolicy = idmv1.DeletionPolicyOrphan
+		}
+		err = r.Create(ctx, user)
+		if errors.IsAlreadyExists(err) {
+			log.Info("User already exists, skipping", "user", name)
+			imp.Status.Skipped = append(imp.Status.Skipped, listed.Name)
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		// Pre-populate the ID, adoptExisting covers a reconcile of the User before this
+		created := user.DeepCopy()
+		user.Status.ID = extUser.ID
+		user.Status.State = "Imported"
+		err = patchStatus(ctx, r.Client, user, created)
+		if err != nil {
+			return err
+		}
+
+		log.Info("Imported user", "user", name, "id", extUser.ID)
+		imp.Status.Imported = append(imp.Status.Imported, idmv1.ImportedUser{Name: name, ID: extUser.ID})
+		imp.Status.ImportedCount = len(imp.Status.Imported)
+		return nil
+	}
+
+	// selected users are looked up by name instead of listing the whole identity system
+	if len(selected) > 0 {
+		for _, name := range imp.Spec.Names {
+			err = idmsvc.EachUser(ctx, svc, idmsvc.UserFilter{Name: name}, importUser)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	return idmsvc.EachUser(ctx, svc, idmsvc.UserFilter{}, importUser)
+}
+
+// userObjectName derives a valid object name from the name of an external user
+func userObjectName(name string) string {
+	name = invalidNameChars.ReplaceAllString(strings.ToLower(name), "-")
+	if len(name) > 253 {
+		name = name[:253]
+	}
+	return strings.Trim(name, "-.")
+}
+
+// SetupWithManager sets up the controller with the Manager.
+func (r *IdentityImportReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&idmv1.IdentityImport{}).
+		Complete(withDrain(withCorrelationID(r)))
+}
@@ -0,0 +1,91 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	idmv1 "github.com/m15ch4/go-identity-operator/api/v1"
+	idmsvc "github.com/m15ch4/go-identity-operator/pkg/identityclient"
+	"github.com/m15ch4/go-identity-operator/pkg/identityclient/fake"
+)
+
+var _ = Describe("IdentityImport controller", func() {
+	var (
+		ctx context.Context
+		svc *fake.IdentityService
+	)
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		svc = fake.NewIdentityService()
+	})
+
+	It("imports selected external users without listing the whole identity system", func() {
+		for _, name := range []string{"paged-a", "paged-b", "paged-c"} {
+			_, err := svc.CreateUser(ctx, &idmv1.UserSpec{Name: name, Role: "user"})
+			Expect(err).NotTo(HaveOccurred())
+		}
+
+		By("paging through the external users")
+		var names []string
+		next := &idmsvc.PageOptions{Limit: 2}
+		for pages := 0; next != nil; pages++ {
+			Expect(pages).To(BeNumerically("<", 2))
+			page, err := svc.ListUsers(ctx, idmsvc.UserFilter{}, *next)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(len(page.Users)).To(BeNumerically("<=", 2))
+			for _, usr := range page.Users {
+				names = append(names, usr.Name)
+			}
+			next = page.Next
+		}
+		Expect(names).To(ConsistOf("paged-a", "paged-b", "paged-c"))
+
+		imp := &idmv1.IdentityImport{
+			ObjectMeta: metav1.ObjectMeta{GenerateName: "import-", Namespace: "default"},
+			Spec:       idmv1.IdentityImportSpec{Names: []string{"paged-b"}},
+		}
+		Expect(k8sClient.Create(ctx, imp)).To(Succeed())
+		importer := &IdentityImportReconciler{
+			Client:          k8sClient,
+			Scheme:          k8sClient.Scheme(),
+			Recorder:        record.NewFakeRecorder(100),
+			IdentityService: svc,
+		}
+		calls := svc.Calls["ListUsers"]
+		_, err := importer.Reconcile(ctx, reconcile.Request{NamespacedName: types.NamespacedName{Namespace: imp.Namespace, Name: imp.Name}})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(svc.Calls["ListUsers"]).To(Equal(calls + 1))
+
+		Expect(k8sClient.Get(ctx, types.NamespacedName{Namespace: imp.Namespace, Name: imp.Name}, imp)).To(Succeed())
+		Expect(imp.Status.Imported).To(HaveLen(1))
+		imported := &idmv1.User{}
+		Expect(k8sClient.Get(ctx, types.NamespacedName{Namespace: imp.Namespace, Name: imp.Status.Imported[0].Name}, imported)).To(Succeed())
+		Expect(imported.Spec.Name).To(Equal("paged-b"))
+		Expect(k8sClient.Delete(ctx, imported)).To(Succeed())
+		Expect(k8sClient.Delete(ctx, imp)).To(Succeed())
+	})
+})
@@ -0,0 +1,576 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	idmv1 "github.com/m15ch4/go-identity-operator/api/v1"
+	idmsvc "github.com/m15ch4/go-identity-operator/pkg/identityclient"
+	"github.com/m15ch4/go-identity-operator/pkg/identityclient/graph"
+	"github.com/m15ch4/go-identity-operator/pkg/identityclient/keycloak"
+	"github.com/m15ch4/go-identity-operator/pkg/identityclient/ldap"
+	"github.com/m15ch4/go-identity-operator/pkg/identityclient/okta"
+	"github.com/m15ch4/go-identity-operator/pkg/identityclient/scim"
+)
+
+// IdentityInstanceReconciler reconciles an IdentityInstance object
+type IdentityInstanceReconciler struct {
+	client.Client
+	Scheme *runtime.Scheme
+
+	// Recorder emits Events on IdentityInstances
+	Recorder record.EventRecorder
+
+	// ProbeInterval is the interval at which the identity system is probed again,
+	// zero probes only when the IdentityInstance changes
+	ProbeInterval time.Duration
+}
+
+//+kubebuilder:rbac:groups=idm.micze.io,resources=identityinstances,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=idm.micze.io,resources=identityinstances/status,verbs=get;update;patch
+//+kubebuilder:rbac:groups=idm.micze.io,resources=identityinstances/finalizers,verbs=update
+//+kubebuilder:rbac:groups=core,resources=configmaps,verbs=get;list;watch
+//+kubebuilder:rbac:groups=core,resources=secrets,verbs=get;list;watch
+//+kubebuilder:rbac:groups=core,resources=events,verbs=create;patch
+
+// Reconcile verifies that the operator can log in to the identity system described
+// by the IdentityInstance and reports the result in the Ready condition. Whether the
+// identity system is reachable at all is reported in the BackendAvailable condition.
+// Users referencing the instance wait while it is not Ready and resume once it is.
+func (r *IdentityInstanceReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	log := log.FromContext(ctx)
+
+	instance := &idmv1.IdentityInstance{}
+	err := r.Get(ctx, req.NamespacedName, instance)
+	if err != nil {
+		if errors.IsNotFound(err) {
+			backends.forget(req.Name)
+			log.Info("IdentityInstance resource not found. Ignoring since object must be deleted")
+			return ctrl.Result{}, nil
+		}
+		log.Error(err, "Failed to get IdentityInstance")
+		return ctrl.Result{}, err
+	}
+	original := instance.DeepCopy()
+
+	endpoint, backend, loginErr := r.login(ctx, instance)
+	configInvalid := isInstanceConfigError(loginErr)
+	if loginErr != nil {
+		reason := "LoginFailed"
+		if configInvalid {
+			reason = "ConfigInvalid"
+		}
+		meta.SetStatusCondition(&instance.Status.Conditions, metav1.Condition{
+			Type:               idmv1.ConditionReady,
+			Status:             metav1.ConditionFalse,
+			ObservedGeneration: instance.Generation,
+			Reason:             reason,
+			Message:            loginErr.Error(),
+		})
+	} else {
+		meta.SetStatusCondition(&instance.Status.Conditions, metav1.Condition{
+			Type:               idmv1.ConditionReady,
+			Status:             metav1.ConditionTrue,
+			ObservedGeneration: instance.Generation,
+			Reason:             "LoginSucceeded",
+			Message:            "Logged in to identity system",
+		})
+	}
+
+	// warn once when the credentials are rejected, not on every probe
+	if idmsvc.IsCredentialsInvalid(loginErr) {
+		if !meta.IsStatusConditionTrue(instance.Status.Conditions, idmv1.ConditionCredentialsInvalid) {
+			r.Recorder.Event(instance, corev1.EventTypeWarning, "CredentialsInvalid", loginErr.Error())
+		}
+		meta.SetStatusCondition(&instance.Status.Conditions, metav1.Condition{
+			Type:               idmv1.ConditionCredentialsInvalid,
+			Status:             metav1.ConditionTrue,
+			ObservedGeneration: instance.Generation,
+			Reason:             "LoginRejected",
+			Message:            loginErr.Error(),
+		})
+	} else if loginErr == nil {
+		meta.SetStatusCondition(&instance.Status.Conditions, metav1.Condition{
+			Type:               idmv1.ConditionCredentialsInvalid,
+			Status:             metav1.ConditionFalse,
+			ObservedGeneration: instance.Generation,
+			Reason:             "CredentialsAccepted",
+			Message:            "Identity system accepted the credentials",
+		})
+	}
+
+	if configInvalid {
+		meta.SetStatusCondition(&instance.Status.Conditions, metav1.Condition{
+			Type:               idmv1.ConditionBackendAvailable,
+			Status:             metav1.ConditionUnknown,
+			ObservedGeneration: instance.Generation,
+			Reason:             "ConfigInvalid",
+			Message:            "Identity system was not probed: " + loginErr.Error(),
+		})
+	} else if idmsvc.IsUnavailable(loginErr) {
+		meta.SetStatusCondition(&instance.Status.Conditions, metav1.Condition{
+			Type:               idmv1.ConditionBackendAvailable,
+			Status:             metav1.ConditionFalse,
+			ObservedGeneration: instance.Generation,
+			Reason:             "Unreachable",
+			Message:            loginErr.Error(),
+		})
+	} else {
+		meta.SetStatusCondition(&instance.Status.Conditions, metav1.Condition{
+			Type:               idmv1.ConditionBackendAvailable,
+			Status:             metav1.ConditionTrue,
+			ObservedGeneration: instance.Generation,
+			Reason:             "Reachable",
+			Message:            "Identity system responded to the probe",
+		})
+	}
+
+	now := metav1.Now()
+	instance.Status.LastHealthCheck = &now
+	if loginErr == nil {
+		info, err := backend.Info(ctx)
+		if err != nil {
+			log.Error(err, "Failed to read the identity system info")
+		} else {
+			instance.Status.BackendVersion = info.Version
+			instance.Status.Capabilities = &idmv1.IdentityInstanceCapabilities{
+				SupportsPATCH:  info.SupportsPatch,
+				SupportsGroups: info.SupportsGroups,
+			}
+		}
+	}
+
+	circuit := idmsvc.CircuitBreakerState(endpoint)
+	if circuit == idmsvc.CircuitClosed {
+		meta.SetStatusCondition(&instance.Status.Conditions, metav1.Condition{
+			Type:               idmv1.ConditionCircuitOpen,
+			Status:             metav1.ConditionFalse,
+			ObservedGeneration: instance.Generation,
+			Reason:             "Closed",
+			Message:            "Requests are sent to the identity system",
+		})
+	} else {
+		meta.SetStatusCondition(&instance.Status.Conditions, metav1.Condition{
+			Type:               idmv1.ConditionCircuitOpen,
+			Status:             metav1.ConditionTrue,
+			ObservedGeneration: instance.Generation,
+			Reason:             circuit.String(),
+			Message:            "Requests to the identity system fail fast after consecutive failures",
+		})
+	}
+
+	if !equality.Semantic.DeepEqual(original.Status, instance.Status) {
+		err = patchStatus(ctx, r.Client, instance, original)
+		if err != nil {
+			log.Info("Failed to update IdentityInstance status")
+			return ctrl.Result{}, err
+		}
+	}
+
+	// check again once the cool-down of the circuit breaker elapsed
+	if delay := idmsvc.RetryAfter(loginErr); idmsvc.IsCircuitOpen(loginErr) && delay > 0 {
+		return ctrl.Result{RequeueAfter: delay}, nil
+	}
+
+	// back off until the credentials Secret changes
+	if idmsvc.IsCredentialsInvalid(loginErr) {
+		log.Info("Identity system rejected the credentials", "error", loginErr.Error())
+		return ctrl.Result{RequeueAfter: credentialsInvalidRequeue}, nil
+	}
+
+	// the failed login is recorded in the status, it is probed again like a healthy one;
+	// changes of the referenced Secrets trigger a reconcile right away
+	requeue := r.ProbeInterval
+	if loginErr != nil {
+		log.Info("Failed to log in to the identity system", "error", loginErr.Error())
+		if requeue <= 0 {
+			requeue = backendUnavailableRequeue
+		}
+	}
+	return ctrl.Result{RequeueAfter: requeue}, nil
+}
+
+// login builds the identity service for the instance and obtains a token. It returns the
+// endpoint of the identity system, whose circuit breaker guards the login, and the service.
+func (r *IdentityInstanceReconciler) login(ctx context.Context, instance *idmv1.IdentityInstance) (string, idmsvc.IdentityAPI, error) {
+	opts, err := instanceConfigOpts(ctx, r.Client, instance)
+	if err != nil {
+		return "", nil, &instanceConfigError{err: err}
+	}
+
+	cfg := idmsvc.NewIdentityConfig(opts...)
+	backend := newIdentityBackend(instance, opts)
+	_, err = backend.GetToken(ctx)
+	return cfg.BaseURL(), backend, err
+}
+
+// newIdentityBackend builds the identity API implementation matching the type of the instance
+func newIdentityBackend(instance *idmv1.IdentityInstance, opts []idmsvc.ConfigOpts) idmsvc.IdentityAPI {
+	cfg := idmsvc.NewIdentityConfig(opts...)
+
+	switch instance.Spec.Type {
+	case idmv1.IdentityInstanceTypeSCIM:
+		return scim.NewService(&cfg)
+	case idmv1.IdentityInstanceTypeKeycloak:
+		return keycloak.NewService(&cfg)
+	case idmv1.IdentityInstanceTypeOkta:
+		return okta.NewService(&cfg)
+	case idmv1.IdentityInstanceTypeGraph:
+		return graph.NewService(&cfg)
+	case idmv1.IdentityInstanceTypeLDAP:
+		return ldap.NewService(&cfg)
+	default:
+		return idmsvc.NewIdentityService(&cfg)
+	}
+}
+
+// identityServiceFor returns the identity service for an object in the given namespace.
+// Objects referencing an IdentityInstance share the cached service of the instance. All
+// others use the operator-level identity system, logging in with the credentials of their
+// namespace if it has a NamespaceCredentialsSecret, otherwise they share the long-lived
+// operator-level service, optionally with credentials from the credentials Secret.
+func identityServiceFor(ctx context.Context, c client.Reader, namespace string, instanceRef *idmv1.IdentityInstanceReference, shared idmsvc.IdentityAPI, credentialsSecret types.NamespacedName) (idmsvc.IdentityAPI, error) {
+	if instanceRef != nil {
+		instance := &idmv1.IdentityInstance{}
+		err := c.Get(ctx, types.NamespacedName{Name: instanceRef.Name}, instance)
+		if err != nil {
+			return nil, err
+		}
+		if meta.IsStatusConditionFalse(instance.Status.Conditions, idmv1.ConditionBackendAvailable) {
+			cond := meta.FindStatusCondition(instance.Status.Conditions, idmv1.ConditionBackendAvailable)
+			return nil, &backendUnavailableError{instance: instance.Name, reason: cond.Message}
+		}
+		if meta.IsStatusConditionTrue(instance.Status.Conditions, idmv1.ConditionCredentialsInvalid) {
+			cond := meta.FindStatusCondition(instance.Status.Conditions, idmv1.ConditionCredentialsInvalid)
+			return nil, &idmsvc.CredentialsError{Err: fmt.Errorf("instance %s: %s", instance.Name, cond.Message)}
+		}
+		if meta.IsStatusConditionFalse(instance.Status.Conditions, idmv1.ConditionReady) {
+			cond := meta.FindStatusCondition(instance.Status.Conditions, idmv1.ConditionReady)
+			return nil, &instanceNotReadyError{instance: instance.Name, reason: cond.Message}
+		}
+		fingerprint, err := instanceFingerprint(ctx, c, instance)
+		if err != nil {
+			return nil, err
+		}
+		return backends.get(instance, fingerprint, func() ([]idmsvc.ConfigOpts, error) {
+			return instanceConfigOpts(ctx, c, instance)
+		})
+	}
+
+	if err := sharedBackendHealth.get(); err != nil {
+		return nil, &backendUnavailableError{reason: err.Error()}
+	}
+
+	if namespace != "" {
+		secret := &corev1.Secret{}
+		err := c.Get(ctx, types.NamespacedName{Namespace: namespace, Name: idmv1.NamespaceCredentialsSecret}, secret)
+		if err == nil {
+			return backends.getNamespaced(secret), nil
+		}
+		if !errors.IsNotFound(err) {
+			return nil, err
+		}
+	}
+
+	if credentialsSecret.Name != "" {
+		secret := &corev1.Secret{}
+		err := c.Get(ctx, credentialsSecret, secret)
+		if err != nil {
+			return nil, err
+		}
+		idmUser, userOK := secret.Data["IDM_USER"]
+		idmPass, passOK := secret.Data["IDM_PASS"]
+		if !userOK || !passOK {
+			return nil, fmt.Errorf("secret %s must contain IDM_USER and IDM_PASS", credentialsSecret)
+		}
+		shared.SetCredentials(string(idmUser), string(idmPass))
+	}
+
+	return shared, nil
+}
+
+// instanceCapabilities returns the capabilities reported on the referenced IdentityInstance.
+// It returns nil for objects using the operator-level configuration and for instances that
+// were not probed yet, the controllers then assume every operation is supported.
+func instanceCapabilities(ctx context.Context, c client.Reader, instanceRef *idmv1.IdentityInstanceReference) (*idmv1.IdentityInstanceCapabilities, error) {
+	if instanceRef == nil {
+		return nil, nil
+	}
+	instance := &idmv1.IdentityInstance{}
+	err := c.Get(ctx, types.NamespacedName{Name: instanceRef.Name}, instance)
+	if err != nil {
+		return nil, err
+	}
+	return instance.Status.Capabilities, nil
+}
+
+// instanceConfigOpts translates the IdentityInstance spec into identity config options
+func instanceConfigOpts(ctx context.Context, c client.Reader, instance *idmv1.IdentityInstance) ([]idmsvc.ConfigOpts, error) {
+	opts := []idmsvc.ConfigOpts{idmsvc.WithHost(instance.Spec.Host)}
+
+	if instance.Spec.Port != 0 {
+		opts = append(opts, idmsvc.WithPort(instance.Spec.Port))
+	}
+
+	if instance.Spec.BasePath != "" {
+		opts = append(opts, idmsvc.WithBasePath(instance.Spec.BasePath))
+	}
+
+	if instance.Spec.Realm != "" {
+		opts = append(opts, idmsvc.WithRealm(instance.Spec.Realm))
+	}
+
+	if spec := instance.Spec.LDAP; spec != nil {
+		opts = append(opts, idmsvc.WithLDAP(idmsvc.LDAPConfig{
+			UsersDN:         spec.UsersDN,
+			GroupsDN:        spec.GroupsDN,
+			ActiveDirectory: spec.Schema == idmv1.IdentityInstanceLDAPSchemaActiveDirectory,
+		}))
+	}
+
+	if instance.Spec.UpdateMethod != "" {
+		opts = append(opts, idmsvc.WithPatchUpdates(instance.Spec.UpdateMethod == idmv1.IdentityInstanceUpdateMethodPatch))
+	}
+
+	if spec := instance.Spec.Connection; spec != nil {
+		if spec.HTTP2 != nil {
+			opts = append(opts, idmsvc.WithHTTP2(*spec.HTTP2))
+		}
+		if spec.MaxConnsPerHost > 0 {
+			opts = append(opts, idmsvc.WithMaxConnsPerHost(spec.MaxConnsPerHost))
+		}
+		if spec.IdleConnTimeout != nil {
+			opts = append(opts, idmsvc.WithIdleConnTimeout(spec.IdleConnTimeout.Duration))
+		}
+		if spec.TLSSessionCacheSize > 0 {
+			opts = append(opts, idmsvc.WithTLSSessionCache(spec.TLSSessionCacheSize))
+		}
+	}
+
+	if len(instance.Spec.AttributeMapping) > 0 {
+		opts = append(opts, idmsvc.WithAttributeMapping(instance.Spec.AttributeMapping))
+	}
+
+	if instance.Spec.TLS != nil && instance.Spec.TLS.Enabled {
+		tlsOpts, err := tlsConfigOpts(ctx, c, instance.Spec.TLS)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, idmsvc.WithScheme("https"))
+		opts = append(opts, tlsOpts...)
+	}
+
+	if spec := instance.Spec.Proxy; spec != nil {
+		opts = append(opts, idmsvc.WithProxy(spec.URL, spec.NoProxy))
+		if ref := spec.CredentialsSecretRef; ref != nil {
+			secret := &corev1.Secret{}
+			err := c.Get(ctx, types.NamespacedName{Namespace: ref.Namespace, Name: ref.Name}, secret)
+			if err != nil {
+				return nil, err
+			}
+			proxyUser, proxyPass := secret.Data["IDM_PROXY_USER"], secret.Data["IDM_PROXY_PASS"]
+			if len(proxyUser) == 0 {
+				return nil, fmt.Errorf("secret %s/%s must hold IDM_PROXY_USER and IDM_PROXY_PASS keys", ref.Namespace, ref.Name)
+			}
+			opts = append(opts, idmsvc.WithProxyCredentials(string(proxyUser), string(proxyPass)))
+		}
+	}
+
+	if ref := instance.Spec.CredentialsSecretRef; ref != nil {
+		secret := &corev1.Secret{}
+		err := c.Get(ctx, types.NamespacedName{Namespace: ref.Namespace, Name: ref.Name}, secret)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, credentialsConfigOpts(secret)...)
+	}
+
+	if spec := instance.Spec.ClientCredentials; spec != nil {
+		secret := &corev1.Secret{}
+		err := c.Get(ctx, types.NamespacedName{Namespace: spec.SecretRef.Namespace, Name: spec.SecretRef.Name}, secret)
+		if err != nil {
+			return nil, err
+		}
+		clientID, clientSecret := secret.Data["IDM_CLIENT_ID"], secret.Data["IDM_CLIENT_SECRET"]
+		if len(clientID) == 0 || len(clientSecret) == 0 {
+			return nil, fmt.Errorf("secret %s/%s must hold IDM_CLIENT_ID and IDM_CLIENT_SECRET keys", spec.SecretRef.Namespace, spec.SecretRef.Name)
+		}
+		opts = append(opts, idmsvc.WithClientCredentials(spec.TokenURL, string(clientID), string(clientSecret), spec.Scopes))
+	}
+
+	return opts, nil
+}
+
+// instanceFingerprint digests the spec of the instance and the resourceVersions of the
+// Secrets and ConfigMaps it references, so the cached identity service is only rebuilt
+// once one of them changed
+func instanceFingerprint(ctx context.Context, c client.Reader, instance *idmv1.IdentityInstance) (string, error) {
+	spec, err := json.Marshal(instance.Spec)
+	if err != nil {
+		return "", err
+	}
+	digest := sha256.New()
+	digest.Write(spec)
+
+	var refs []client.Object
+	if ref := instance.Spec.CredentialsSecretRef; ref != nil {
+		refs = append(refs, &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Namespace: ref.Namespace, Name: ref.Name}})
+	}
+	if spec := instance.Spec.ClientCredentials; spec != nil {
+		refs = append(refs, &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Namespace: spec.SecretRef.Namespace, Name: spec.SecretRef.Name}})
+	}
+	if spec := instance.Spec.Proxy; spec != nil && spec.CredentialsSecretRef != nil {
+		ref := spec.CredentialsSecretRef
+		refs = append(refs, &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Namespace: ref.Namespace, Name: ref.Name}})
+	}
+	if spec := instance.Spec.TLS; spec != nil && spec.Enabled {
+		if ref := spec.CABundleRef; ref != nil {
+			objMeta := metav1.ObjectMeta{Namespace: ref.Namespace, Name: ref.Name}
+			if ref.Kind == "ConfigMap" {
+				refs = append(refs, &corev1.ConfigMap{ObjectMeta: objMeta})
+			} else {
+				refs = append(refs, &corev1.Secret{ObjectMeta: objMeta})
+			}
+		}
+		if ref := spec.ClientCertificateSecretRef; ref != nil {
+			refs = append(refs, &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Namespace: ref.Namespace, Name: ref.Name}})
+		}
+	}
+
+	for _, obj := range refs {
+		if err := c.Get(ctx, client.ObjectKeyFromObject(obj), obj); err != nil {
+			return "", err
+		}
+		fmt.Fprintf(digest, "/%s/%s", obj.GetUID(), obj.GetResourceVersion())
+	}
+	return hex.EncodeToString(digest.Sum(nil)), nil
+}
+
+// tlsConfigOpts resolves the CA bundle and client certificate referenced by the TLS settings
+func tlsConfigOpts(ctx context.Context, c client.Reader, spec *idmv1.IdentityInstanceTLS) ([]idmsvc.ConfigOpts, error) {
+	opts := []idmsvc.ConfigOpts{idmsvc.WithInsecureSkipVerify(spec.InsecureSkipVerify)}
+
+	if ref := spec.CABundleRef; ref != nil {
+		key := ref.Key
+		if key == "" {
+			key = "ca.crt"
+		}
+		name := types.NamespacedName{Namespace: ref.Namespace, Name: ref.Name}
+
+		var caBundle []byte
+		switch ref.Kind {
+		case "ConfigMap":
+			configMap := &corev1.ConfigMap{}
+			if err := c.Get(ctx, name, configMap); err != nil {
+				return nil, err
+			}
+			caBundle = []byte(configMap.Data[key])
+		case "Secret":
+			secret := &corev1.Secret{}
+			if err := c.Get(ctx, name, secret); err != nil {
+				return nil, err
+			}
+			caBundle = secret.Data[key]
+		default:
+			return nil, fmt.Errorf("unsupported CA bundle kind %q", ref.Kind)
+		}
+		if len(caBundle) == 0 {
+			return nil, fmt.Errorf("key %q not found in %s %s", key, ref.Kind, name)
+		}
+		opts = append(opts, idmsvc.WithCABundle(caBundle))
+	}
+
+	if ref := spec.ClientCertificateSecretRef; ref != nil {
+		secret := &corev1.Secret{}
+		err := c.Get(ctx, types.NamespacedName{Namespace: ref.Namespace, Name: ref.Name}, secret)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, idmsvc.WithClientCertificate(secret.Data[corev1.TLSCertKey], secret.Data[corev1.TLSPrivateKeyKey]))
+	}
+
+	return opts, nil
+}
+
+// credentialsConfigOpts reads the IDM_USER, IDM_PASS and IDM_TOKEN keys of a credentials Secret
+func credentialsConfigOpts(secret *corev1.Secret) []idmsvc.ConfigOpts {
+	var opts []idmsvc.ConfigOpts
+	if user, ok := secret.Data["IDM_USER"]; ok {
+		opts = append(opts, idmsvc.WithUser(string(user)))
+	}
+	if pass, ok := secret.Data["IDM_PASS"]; ok {
+		opts = append(opts, idmsvc.WithPass(string(pass)))
+	}
+	if token, ok := secret.Data["IDM_TOKEN"]; ok {
+		opts = append(opts, idmsvc.WithToken(string(token)))
+	}
+	return opts
+}
+
+// secretToInstances enqueues the IdentityInstances logging in with the credentials of a
+// Secret, so fixed credentials are probed right away
+func (r *IdentityInstanceReconciler) secretToInstances(ctx context.Context, obj client.Object) []reconcile.Request {
+	instances := &idmv1.IdentityInstanceList{}
+	if err := r.List(ctx, instances); err != nil {
+		log.FromContext(ctx).Error(err, "Failed to list IdentityInstances")
+		return nil
+	}
+
+	var requests []reconcile.Request
+	for _, instance := range instances.Items {
+		var refs []*idmv1.SecretReference
+		refs = append(refs, instance.Spec.CredentialsSecretRef)
+		if spec := instance.Spec.ClientCredentials; spec != nil {
+			refs = append(refs, &spec.SecretRef)
+		}
+		for _, ref := range refs {
+			if ref != nil && ref.Namespace == obj.GetNamespace() && ref.Name == obj.GetName() {
+				requests = append(requests, reconcile.Request{NamespacedName: types.NamespacedName{Name: instance.Name}})
+				break
+			}
+		}
+	}
+	return requests
+}
+
+// SetupWithManager sets up the controller with the Manager.
+func (r *IdentityInstanceReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&idmv1.IdentityInstance{}).
+		Watches(&corev1.Secret{}, handler.EnqueueRequestsFromMapFunc(r.secretToInstances)).
+		Complete(withDrain(withCorrelationID(r)))
+}
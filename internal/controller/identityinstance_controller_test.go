@@ -0,0 +1,121 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"net"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	idmv1 "github.com/m15ch4/go-identity-operator/api/v1"
+)
+
+var _ = Describe("IdentityInstance controller", func() {
+	var ctx context.Context
+
+	BeforeEach(func() {
+		ctx = context.Background()
+	})
+
+	It("reports IdentityInstances it cannot log in to in their status without failing", func() {
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		Expect(err).NotTo(HaveOccurred())
+		port := listener.Addr().(*net.TCPAddr).Port
+		Expect(listener.Close()).To(Succeed())
+
+		unreachable := &idmv1.IdentityInstance{
+			ObjectMeta: metav1.ObjectMeta{GenerateName: "instance-"},
+			Spec:       idmv1.IdentityInstanceSpec{Host: "127.0.0.1", Port: port},
+		}
+		misconfigured := &idmv1.IdentityInstance{
+			ObjectMeta: metav1.ObjectMeta{GenerateName: "instance-"},
+			Spec: idmv1.IdentityInstanceSpec{
+				Host:                 "idm.example.test",
+				CredentialsSecretRef: &idmv1.SecretReference{Namespace: "default", Name: "missing"},
+			},
+		}
+		instanceReconciler := &IdentityInstanceReconciler{
+			Client:        k8sClient,
+			Scheme:        k8sClient.Scheme(),
+			Recorder:      record.NewFakeRecorder(100),
+			ProbeInterval: time.Minute,
+		}
+		probe := func(instance *idmv1.IdentityInstance) *metav1.Condition {
+			Expect(k8sClient.Create(ctx, instance)).To(Succeed())
+			DeferCleanup(k8sClient.Delete, ctx, instance)
+
+			result, err := instanceReconciler.Reconcile(ctx, reconcile.Request{NamespacedName: client.ObjectKeyFromObject(instance)})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(result).To(Equal(ctrl.Result{RequeueAfter: time.Minute}))
+			current := &idmv1.IdentityInstance{}
+			Expect(k8sClient.Get(ctx, client.ObjectKeyFromObject(instance), current)).To(Succeed())
+			Expect(meta.IsStatusConditionFalse(current.Status.Conditions, idmv1.ConditionReady)).To(BeTrue())
+			return meta.FindStatusCondition(current.Status.Conditions, idmv1.ConditionBackendAvailable)
+		}
+
+		available := probe(unreachable)
+		Expect(available.Status).To(Equal(metav1.ConditionFalse))
+		Expect(available.Reason).To(Equal("Unreachable"))
+
+		available = probe(misconfigured)
+		Expect(available.Status).To(Equal(metav1.ConditionUnknown))
+		Expect(available.Reason).To(Equal("ConfigInvalid"))
+	})
+	It("shares the identity service of an instance until its credentials Secret changes", func() {
+		secret := &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{GenerateName: "credentials-", Namespace: "default"},
+			StringData: map[string]string{"IDM_USER": "admin", "IDM_PASS": "secret"},
+		}
+		Expect(k8sClient.Create(ctx, secret)).To(Succeed())
+		DeferCleanup(k8sClient.Delete, ctx, secret)
+		instance := &idmv1.IdentityInstance{
+			ObjectMeta: metav1.ObjectMeta{GenerateName: "instance-"},
+			Spec: idmv1.IdentityInstanceSpec{
+				Host:                 "idm.example.test",
+				CredentialsSecretRef: &idmv1.SecretReference{Namespace: "default", Name: secret.Name},
+			},
+		}
+		Expect(k8sClient.Create(ctx, instance)).To(Succeed())
+		DeferCleanup(k8sClient.Delete, ctx, instance)
+		DeferCleanup(backends.forget, instance.Name)
+
+		ref := &idmv1.IdentityInstanceReference{Name: instance.Name}
+		first, err := identityServiceFor(ctx, k8sClient, "default", ref, nil, types.NamespacedName{})
+		Expect(err).NotTo(HaveOccurred())
+		again, err := identityServiceFor(ctx, k8sClient, "default", ref, nil, types.NamespacedName{})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(again).To(BeIdenticalTo(first))
+
+		secret.StringData = map[string]string{"IDM_PASS": "rotated"}
+		Expect(k8sClient.Update(ctx, secret)).To(Succeed())
+		rotated, err := identityServiceFor(ctx, k8sClient, "default", ref, nil, types.NamespacedName{})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(rotated).NotTo(BeIdenticalTo(first))
+	})
+})
@@ -0,0 +1,199 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	idmv1 "github.com/m15ch4/go-identity-operator/api/v1"
+)
+
+// OperatorConfig holds the spec of the IdentityOperatorConfig in use, whose fields override
+// the flags of the operator while it exists. It is safe for concurrent use.
+type OperatorConfig struct {
+	mu   sync.RWMutex
+	spec *idmv1.IdentityOperatorConfigSpec
+	// version changes with every applied spec, so derived state can be rebuilt
+	version int64
+}
+
+// Spec returns the spec in use, or nil when the flags apply unchanged
+func (c *OperatorConfig) Spec() *idmv1.IdentityOperatorConfigSpec {
+	if c == nil {
+		return nil
+	}
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.spec
+}
+
+// Version returns a number that changes whenever a different spec is applied
+func (c *OperatorConfig) Version() int64 {
+	if c == nil {
+		return 0
+	}
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.version
+}
+
+// set applies the spec, nil restores the flags
+func (c *OperatorConfig) set(spec *idmv1.IdentityOperatorConfigSpec) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if equality.Semantic.DeepEqual(c.spec, spec) {
+		return false
+	}
+	c.spec = spec
+	c.version++
+	return true
+}
+
+// Load applies the spec of the IdentityOperatorConfig with the given name, or restores the
+// flags if it does not exist. It lets tools outside the operator see its configuration.
+func (c *OperatorConfig) Load(ctx context.Context, reader client.Reader, name string) error {
+	config := &idmv1.IdentityOperatorConfig{}
+	err := reader.Get(ctx, types.NamespacedName{Name: name}, config)
+	if errors.IsNotFound(err) {
+		c.set(nil)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	c.set(&config.Spec)
+	return nil
+}
+
+// driftResyncPeriod returns the drift resync period of the IdentityOperatorConfig, or period
+func (c *OperatorConfig) driftResyncPeriod(period time.Duration) time.Duration {
+	if spec := c.Spec(); spec != nil && spec.DriftResyncPeriod != nil {
+		return spec.DriftResyncPeriod.Duration
+	}
+	return period
+}
+
+// driftIgnoredAttributes returns the ignored attributes of the IdentityOperatorConfig, or attributes
+func (c *OperatorConfig) driftIgnoredAttributes(attributes []string) []string {
+	if spec := c.Spec(); spec != nil && spec.DriftIgnoredAttributes != nil {
+		return spec.DriftIgnoredAttributes
+	}
+	return attributes
+}
+
+// forceFinalizeAfter returns the force finalization delay of the IdentityOperatorConfig, or after
+func (c *OperatorConfig) forceFinalizeAfter(after time.Duration) time.Duration {
+	if spec := c.Spec(); spec != nil && spec.ForceFinalizeAfter != nil {
+		return spec.ForceFinalizeAfter.Duration
+	}
+	return after
+}
+
+// instanceRef returns ref, or the default instance of the IdentityOperatorConfig for objects
+// without an instanceRef
+func (c *OperatorConfig) instanceRef(ref *idmv1.IdentityInstanceReference) *idmv1.IdentityInstanceReference {
+	if ref != nil {
+		return ref
+	}
+	if spec := c.Spec(); spec != nil {
+		return spec.DefaultInstanceRef
+	}
+	return nil
+}
+
+// IdentityOperatorConfigReconciler applies the IdentityOperatorConfig to the running operator
+type IdentityOperatorConfigReconciler struct {
+	client.Client
+	Scheme *runtime.Scheme
+
+	// Name of the IdentityOperatorConfig in use, others are reported as ignored
+	Name string
+	// Config is shared with the other controllers through their options
+	Config *OperatorConfig
+}
+
+//+kubebuilder:rbac:groups=idm.micze.io,resources=identityoperatorconfigs,verbs=get;list;watch
+//+kubebuilder:rbac:groups=idm.micze.io,resources=identityoperatorconfigs/status,verbs=get;update;patch
+
+// Reconcile applies the spec of the IdentityOperatorConfig in use, or restores the flags once
+// it is deleted. The controllers pick up the change on their next reconcile.
+func (r *IdentityOperatorConfigReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	log := log.FromContext(ctx)
+
+	config := &idmv1.IdentityOperatorConfig{}
+	err := r.Get(ctx, req.NamespacedName, config)
+	if err != nil {
+		if errors.IsNotFound(err) {
+			if req.Name == r.Name && r.Config.set(nil) {
+				log.Info("IdentityOperatorConfig removed, using the flags of the operator")
+			}
+			return ctrl.Result{}, nil
+		}
+		log.Error(err, "Failed to get IdentityOperatorConfig")
+		return ctrl.Result{}, err
+	}
+	original := config.DeepCopy()
+
+	if config.Name != r.Name {
+		r.setCondition(config, idmv1.ConditionReady, metav1.ConditionFalse, "Ignored", "The operator uses the IdentityOperatorConfig named "+r.Name)
+	} else {
+		if r.Config.set(config.Spec.DeepCopy()) {
+			log.Info("Applied IdentityOperatorConfig", "generation", config.Generation)
+		}
+		config.Status.ObservedGeneration = config.Generation
+		r.setCondition(config, idmv1.ConditionReady, metav1.ConditionTrue, "Applied", "Configuration applied to the operator")
+	}
+
+	if !equality.Semantic.DeepEqual(original.Status, config.Status) {
+		err = patchStatus(ctx, r.Client, config, original)
+		if err != nil {
+			log.Info("Failed to update IdentityOperatorConfig status")
+			return ctrl.Result{}, err
+		}
+	}
+	return ctrl.Result{}, nil
+}
+
+// setCondition sets the given condition on the config status, observed at the current generation
+func (r *IdentityOperatorConfigReconciler) setCondition(config *idmv1.IdentityOperatorConfig, conditionType string, status metav1.ConditionStatus, reason, message string) {
+	meta.SetStatusCondition(&config.Status.Conditions, metav1.Condition{
+		Type:               conditionType,
+		Status:             status,
+		ObservedGeneration: config.Generation,
+		Reason:             reason,
+		Message:            message,
+	})
+}
+
+// SetupWithManager sets up the controller with the Manager.
+func (r *IdentityOperatorConfigReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&idmv1.IdentityOperatorConfig{}).
+		Complete(withDrain(withCorrelationID(r)))
+}
@@ -0,0 +1,93 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	idmv1 "github.com/m15ch4/go-identity-operator/api/v1"
+)
+
+var _ = Describe("IdentityOperatorConfig controller", func() {
+	var ctx context.Context
+
+	BeforeEach(func() {
+		ctx = context.Background()
+	})
+
+	It("applies the config in use until it is deleted and ignores the others", func() {
+		config := &idmv1.IdentityOperatorConfig{
+			ObjectMeta: metav1.ObjectMeta{GenerateName: "config-"},
+			Spec:       idmv1.IdentityOperatorConfigSpec{DriftResyncPeriod: &metav1.Duration{Duration: time.Minute}},
+		}
+		Expect(k8sClient.Create(ctx, config)).To(Succeed())
+		DeferCleanup(func() {
+			Expect(client.IgnoreNotFound(k8sClient.Delete(ctx, config))).To(Succeed())
+		})
+		other := &idmv1.IdentityOperatorConfig{ObjectMeta: metav1.ObjectMeta{GenerateName: "config-"}}
+		Expect(k8sClient.Create(ctx, other)).To(Succeed())
+		DeferCleanup(k8sClient.Delete, ctx, other)
+
+		reconciler := &IdentityOperatorConfigReconciler{
+			Client: k8sClient,
+			Scheme: k8sClient.Scheme(),
+			Name:   config.Name,
+			Config: &OperatorConfig{},
+		}
+		reconcileConfig := func(obj *idmv1.IdentityOperatorConfig) {
+			_, err := reconciler.Reconcile(ctx, reconcile.Request{NamespacedName: client.ObjectKeyFromObject(obj)})
+			Expect(err).NotTo(HaveOccurred())
+		}
+
+		By("applying the spec of the config in use")
+		reconcileConfig(config)
+		Expect(reconciler.Config.driftResyncPeriod(time.Hour)).To(Equal(time.Minute))
+		Expect(k8sClient.Get(ctx, client.ObjectKeyFromObject(config), config)).To(Succeed())
+		Expect(meta.IsStatusConditionTrue(config.Status.Conditions, idmv1.ConditionReady)).To(BeTrue())
+
+		By("applying the updated spec")
+		version := reconciler.Config.Version()
+		config.Spec.DriftResyncPeriod = &metav1.Duration{Duration: 5 * time.Minute}
+		Expect(k8sClient.Update(ctx, config)).To(Succeed())
+		reconcileConfig(config)
+		Expect(reconciler.Config.driftResyncPeriod(time.Hour)).To(Equal(5 * time.Minute))
+		Expect(reconciler.Config.Version()).To(BeNumerically(">", version))
+
+		By("reporting other configs as ignored")
+		reconcileConfig(other)
+		Expect(k8sClient.Get(ctx, client.ObjectKeyFromObject(other), other)).To(Succeed())
+		ready := meta.FindStatusCondition(other.Status.Conditions, idmv1.ConditionReady)
+		Expect(ready.Status).To(Equal(metav1.ConditionFalse))
+		Expect(ready.Reason).To(Equal("Ignored"))
+		Expect(reconciler.Config.driftResyncPeriod(time.Hour)).To(Equal(5 * time.Minute))
+
+		By("restoring the flags once the config is deleted")
+		Expect(k8sClient.Delete(ctx, config)).To(Succeed())
+		reconcileConfig(config)
+		Expect(reconciler.Config.Spec()).To(BeNil())
+		Expect(reconciler.Config.driftResyncPeriod(time.Hour)).To(Equal(time.Hour))
+	})
+})
@@ -0,0 +1,159 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	idmv1 "github.com/m15ch4/go-identity-operator/api/v1"
+)
+
+// IdentityQuotaReconciler reconciles an IdentityQuota object
+type IdentityQuotaReconciler struct {
+	client.Client
+	Scheme *runtime.Scheme
+
+	// Options tunes the workers and the rate limiter of the controller
+	Options ControllerOptions
+}
+
+//+kubebuilder:rbac:groups=idm.micze.io,resources=identityquotas,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=idm.micze.io,resources=identityquotas/status,verbs=get;update;patch
+//+kubebuilder:rbac:groups=idm.micze.io,resources=identityquotas/finalizers,verbs=update
+
+// Reconcile counts the Users of the namespace into the status of the IdentityQuota. The
+// quota is enforced by the User validating webhook, the Ready condition turns False when
+// Users created before the quota exceed it.
+func (r *IdentityQuotaReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	log := log.FromContext(ctx)
+
+	quota := &idmv1.IdentityQuota{}
+	err := r.Get(ctx, req.NamespacedName, quota)
+	if err != nil {
+		if errors.IsNotFound(err) {
+			log.Info("IdentityQuota resource not found. Ignoring since object must be deleted")
+			return ctrl.Result{}, nil
+		}
+		log.Error(err, "Failed to get IdentityQuota")
+		return ctrl.Result{}, err
+	}
+	original := quota.DeepCopy()
+
+	users := &idmv1.UserList{}
+	err = r.List(ctx, users, client.InNamespace(quota.Namespace))
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+
+	quota.Status.Users = int32(len(users.Items))
+	quota.Status.UsersPerRole = nil
+	if len(quota.Spec.MaxUsersPerRole) > 0 {
+		quota.Status.UsersPerRole = map[string]int32{}
+		for role := range quota.Spec.MaxUsersPerRole {
+			quota.Status.UsersPerRole[role] = 0
+		}
+		for i := range users.Items {
+			role := idmv1.QuotaRole(&users.Items[i])
+			if _, ok := quota.Spec.MaxUsersPerRole[role]; ok {
+				quota.Status.UsersPerRole[role]++
+			}
+		}
+	}
+
+	if exceeded := quotaExceeded(quota); len(exceeded) > 0 {
+		r.setCondition(quota, idmv1.ConditionReady, metav1.ConditionFalse, "Exceeded", "Quota exceeded for "+strings.Join(exceeded, ", "))
+	} else {
+		r.setCondition(quota, idmv1.ConditionReady, metav1.ConditionTrue, "WithinLimits", "Users are within the quota")
+	}
+
+	if !equality.Semantic.DeepEqual(original.Status, quota.Status) {
+		err = patchStatus(ctx, r.Client, quota, original)
+		if err != nil {
+			log.Info("Failed to update IdentityQuota status")
+			return ctrl.Result{}, err
+		}
+	}
+
+	return ctrl.Result{}, nil
+}
+
+// quotaExceeded returns the limits of the quota its usage exceeds
+func quotaExceeded(quota *idmv1.IdentityQuota) []string {
+	var exceeded []string
+	if limit := quota.Spec.MaxUsers; limit != nil && quota.Status.Users > *limit {
+		exceeded = append(exceeded, fmt.Sprintf("users (%d of %d)", quota.Status.Users, *limit))
+	}
+	for role, limit := range quota.Spec.MaxUsersPerRole {
+		if used := quota.Status.UsersPerRole[role]; used > limit {
+			exceeded = append(exceeded, fmt.Sprintf("role %s (%d of %d)", role, used, limit))
+		}
+	}
+	sort.Strings(exceeded)
+	return exceeded
+}
+
+// setCondition sets the given condition on the quota status, observed at the current generation
+func (r *IdentityQuotaReconciler) setCondition(quota *idmv1.IdentityQuota, conditionType string, status metav1.ConditionStatus, reason, message string) {
+	meta.SetStatusCondition(&quota.Status.Conditions, metav1.Condition{
+		Type:               conditionType,
+		Status:             status,
+		ObservedGeneration: quota.Generation,
+		Reason:             reason,
+		Message:            message,
+	})
+}
+
+// userToQuotas maps a User to the IdentityQuotas of its namespace
+func (r *IdentityQuotaReconciler) userToQuotas(ctx context.Context, obj client.Object) []reconcile.Request {
+	quotas := &idmv1.IdentityQuotaList{}
+	if err := r.List(ctx, quotas, client.InNamespace(obj.GetNamespace())); err != nil {
+		log.FromContext(ctx).Error(err, "Failed to list IdentityQuotas")
+		return nil
+	}
+
+	requests := make([]reconcile.Request, 0, len(quotas.Items))
+	for _, quota := range quotas.Items {
+		requests = append(requests, reconcile.Request{
+			NamespacedName: types.NamespacedName{Namespace: quota.Namespace, Name: quota.Name},
+		})
+	}
+	return requests
+}
+
+// SetupWithManager sets up the controller with the Manager.
+func (r *IdentityQuotaReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&idmv1.IdentityQuota{}).
+		Watches(&idmv1.User{}, handler.EnqueueRequestsFromMapFunc(r.userToQuotas)).
+		WithOptions(r.Options.controllerOptions()).
+		Complete(withDrain(withCorrelationID(r)))
+}
@@ -0,0 +1,108 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	idmv1 "github.com/m15ch4/go-identity-operator/api/v1"
+)
+
+var _ = Describe("IdentityQuota controller", func() {
+	var (
+		ctx        context.Context
+		reconciler *IdentityQuotaReconciler
+		namespace  string
+	)
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		reconciler = &IdentityQuotaReconciler{
+			Client: k8sClient,
+			Scheme: k8sClient.Scheme(),
+		}
+
+		// the quota counts all Users of its namespace, so it gets a namespace of its own
+		ns := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{GenerateName: "quota-"}}
+		Expect(k8sClient.Create(ctx, ns)).To(Succeed())
+		namespace = ns.Name
+	})
+
+	createUser := func(name, role string) *idmv1.User {
+		user := &idmv1.User{
+			ObjectMeta: metav1.ObjectMeta{GenerateName: "user-", Namespace: namespace},
+			Spec:       idmv1.UserSpec{Name: name, Password: "secret", Role: role},
+		}
+		Expect(k8sClient.Create(ctx, user)).To(Succeed())
+		DeferCleanup(func() {
+			Expect(client.IgnoreNotFound(k8sClient.Delete(ctx, user))).To(Succeed())
+		})
+		return user
+	}
+
+	It("counts the Users of its namespace against the limits", func() {
+		maxUsers := int32(1)
+		quota := &idmv1.IdentityQuota{
+			ObjectMeta: metav1.ObjectMeta{Name: "users", Namespace: namespace},
+			Spec: idmv1.IdentityQuotaSpec{
+				MaxUsers:        &maxUsers,
+				MaxUsersPerRole: map[string]int32{"admin": 1},
+			},
+		}
+		Expect(k8sClient.Create(ctx, quota)).To(Succeed())
+		DeferCleanup(k8sClient.Delete, ctx, quota)
+		reconcileQuota := func() *idmv1.IdentityQuota {
+			_, err := reconciler.Reconcile(ctx, reconcile.Request{NamespacedName: client.ObjectKeyFromObject(quota)})
+			Expect(err).NotTo(HaveOccurred())
+			current := &idmv1.IdentityQuota{}
+			Expect(k8sClient.Get(ctx, client.ObjectKeyFromObject(quota), current)).To(Succeed())
+			return current
+		}
+		createUser("jackr", "admin")
+		jane := createUser("janed", "user")
+
+		By("reporting the exceeded limits of Users created before the quota")
+		current := reconcileQuota()
+		Expect(current.Status.Users).To(Equal(int32(2)))
+		Expect(current.Status.UsersPerRole).To(Equal(map[string]int32{"admin": 1}))
+		ready := meta.FindStatusCondition(current.Status.Conditions, idmv1.ConditionReady)
+		Expect(ready.Status).To(Equal(metav1.ConditionFalse))
+		Expect(ready.Reason).To(Equal("Exceeded"))
+		Expect(ready.Message).To(ContainSubstring("users (2 of 1)"))
+
+		By("turning Ready once the limits are raised")
+		maxUsers = 2
+		current.Spec.MaxUsers = &maxUsers
+		Expect(k8sClient.Update(ctx, current)).To(Succeed())
+		current = reconcileQuota()
+		Expect(meta.IsStatusConditionTrue(current.Status.Conditions, idmv1.ConditionReady)).To(BeTrue())
+
+		By("releasing the quota of deleted Users")
+		Expect(k8sClient.Delete(ctx, jane)).To(Succeed())
+		current = reconcileQuota()
+		Expect(current.Status.Users).To(Equal(int32(1)))
+	})
+})
@@ -0,0 +1,107 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+
+	idmv1 "github.com/m15ch4/go-identity-operator/api/v1"
+)
+
+// UserJanitorReconciler watches for Users stuck in Terminating, e.g. because their identity
+// system is gone for good or their IdentityInstance was deleted, so that clusters do not
+// accumulate Users that cannot be deleted.
+type UserJanitorReconciler struct {
+	client.Client
+
+	// Recorder emits Events on stuck Users
+	Recorder record.EventRecorder
+
+	// Threshold is the time a User may be terminating before it is considered stuck
+	Threshold time.Duration
+
+	// RemoveFinalizers makes the janitor remove the finalizer of stuck Users, leaving their
+	// external user behind. Otherwise stuck Users are only reported.
+	RemoveFinalizers bool
+}
+
+// Reconcile reports a User that is terminating for longer than the threshold with a
+// Warning event and, if enabled, removes its finalizer.
+func (r *UserJanitorReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	log := log.FromContext(ctx)
+
+	user := &idmv1.User{}
+	err := r.Get(ctx, req.NamespacedName, user)
+	if err != nil {
+		if errors.IsNotFound(err) {
+			return ctrl.Result{}, nil
+		}
+		return ctrl.Result{}, err
+	}
+	if user.DeletionTimestamp.IsZero() || !containsString(user.GetFinalizers(), userFinalizer) {
+		return ctrl.Result{}, nil
+	}
+
+	terminating := time.Since(user.DeletionTimestamp.Time)
+	if terminating < r.Threshold {
+		return ctrl.Result{RequeueAfter: r.Threshold - terminating}, nil
+	}
+
+	reason := "the finalizer was not removed"
+	if degraded := meta.FindStatusCondition(user.Status.Conditions, idmv1.ConditionDegraded); degraded != nil && degraded.Message != "" {
+		reason = degraded.Message
+	}
+
+	if !r.RemoveFinalizers {
+		log.Info("User is stuck in Terminating", "terminating", terminating.Round(time.Second), "reason", reason)
+		r.Recorder.Eventf(user, corev1.EventTypeWarning, "StuckTerminating", "User is terminating for %s: %s", terminating.Round(time.Second), reason)
+		// remind again, events of the same reason are aggregated
+		return ctrl.Result{RequeueAfter: r.Threshold}, nil
+	}
+
+	log.Info("Removing finalizer of User stuck in Terminating", "terminating", terminating.Round(time.Second), "id", user.Status.ID)
+	err = patchWithRetry(ctx, r.Client, user, func() {
+		controllerutil.RemoveFinalizer(user, userFinalizer)
+	})
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	r.Recorder.Eventf(user, corev1.EventTypeWarning, "FinalizerRemoved", "Removed finalizer after terminating for %s, user %s may be left in identity system: %s", terminating.Round(time.Second), user.Status.ID, reason)
+	return ctrl.Result{}, nil
+}
+
+// SetupWithManager sets up the controller with the Manager.
+func (r *UserJanitorReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		Named("user-janitor").
+		For(&idmv1.User{}, builder.WithPredicates(predicate.NewPredicateFuncs(func(obj client.Object) bool {
+			return !obj.GetDeletionTimestamp().IsZero()
+		}))).
+		Complete(withDrain(withCorrelationID(r)))
+}
@@ -0,0 +1,100 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	idmv1 "github.com/m15ch4/go-identity-operator/api/v1"
+)
+
+var _ = Describe("UserJanitor controller", func() {
+	var (
+		ctx      context.Context
+		recorder *record.FakeRecorder
+		user     *idmv1.User
+	)
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		recorder = record.NewFakeRecorder(100)
+
+		user = &idmv1.User{
+			ObjectMeta: metav1.ObjectMeta{
+				GenerateName: "user-",
+				Namespace:    "default",
+				Finalizers:   []string{userFinalizer},
+			},
+			Spec: idmv1.UserSpec{Name: "jackr", Password: "secret", Role: "user"},
+		}
+		Expect(k8sClient.Create(ctx, user)).To(Succeed())
+	})
+
+	AfterEach(func() {
+		current := &idmv1.User{}
+		err := k8sClient.Get(ctx, client.ObjectKeyFromObject(user), current)
+		if errors.IsNotFound(err) {
+			return
+		}
+		Expect(err).NotTo(HaveOccurred())
+		current.SetFinalizers(nil)
+		Expect(k8sClient.Update(ctx, current)).To(Succeed())
+		Expect(client.IgnoreNotFound(k8sClient.Delete(ctx, current))).To(Succeed())
+	})
+
+	reconcileUser := func(janitor *UserJanitorReconciler) ctrl.Result {
+		result, err := janitor.Reconcile(ctx, reconcile.Request{NamespacedName: client.ObjectKeyFromObject(user)})
+		Expect(err).NotTo(HaveOccurred())
+		return result
+	}
+
+	It("reports and releases Users stuck in Terminating", func() {
+		janitor := &UserJanitorReconciler{Client: k8sClient, Recorder: recorder, Threshold: time.Hour}
+
+		By("leaving Users alone that are not terminating")
+		Expect(reconcileUser(janitor)).To(Equal(ctrl.Result{}))
+
+		By("waiting for the threshold of terminating Users")
+		Expect(k8sClient.Delete(ctx, user)).To(Succeed())
+		Expect(reconcileUser(janitor).RequeueAfter).To(BeNumerically(">", 59*time.Minute))
+		Expect(recorder.Events).To(BeEmpty())
+
+		By("reporting the User once it is stuck")
+		janitor.Threshold = time.Nanosecond
+		Expect(reconcileUser(janitor)).To(Equal(ctrl.Result{RequeueAfter: time.Nanosecond}))
+		Expect(recorder.Events).To(Receive(ContainSubstring("StuckTerminating")))
+		Expect(k8sClient.Get(ctx, client.ObjectKeyFromObject(user), &idmv1.User{})).To(Succeed())
+
+		By("removing the finalizer when enabled")
+		janitor.RemoveFinalizers = true
+		Expect(reconcileUser(janitor)).To(Equal(ctrl.Result{}))
+		Expect(recorder.Events).To(Receive(ContainSubstring("FinalizerRemoved")))
+		err := k8sClient.Get(ctx, client.ObjectKeyFromObject(user), &idmv1.User{})
+		Expect(errors.IsNotFound(err)).To(BeTrue())
+	})
+})
@@ -0,0 +1,66 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	idmsvc "github.com/m15ch4/go-identity-operator/pkg/identityclient"
+	"github.com/m15ch4/go-identity-operator/pkg/tracing"
+)
+
+// withCorrelationID wraps a reconciler so that every reconciliation gets a correlation ID,
+// logged as correlationID and sent to the identity app as X-Request-ID, which allows
+// tracing a reconciliation from the operator logs to the identity app and back. Every
+// reconciliation is recorded as a span, the parent of the spans of its identity API
+// requests; the correlation ID is the ID of its trace, or a random one when tracing is
+// disabled.
+func withCorrelationID(r reconcile.Reconciler) reconcile.Reconciler {
+	// the span is named after the reconciler, e.g. UserReconciler.Reconcile
+	name := fmt.Sprintf("%T", r)
+	name = name[strings.LastIndex(name, ".")+1:] + ".Reconcile"
+
+	return reconcile.Func(func(ctx context.Context, req reconcile.Request) (reconcile.Result, error) {
+		ctx, span := tracing.Tracer().Start(ctx, name, trace.WithAttributes(
+			attribute.String("k8s.namespace.name", req.Namespace),
+			attribute.String("k8s.object.name", req.Name),
+		))
+		defer span.End()
+
+		id := idmsvc.NewRequestID()
+		if traceID := span.SpanContext().TraceID(); traceID.IsValid() {
+			id = traceID.String()
+		}
+		ctx = idmsvc.WithRequestID(ctx, id)
+		ctx = log.IntoContext(ctx, log.FromContext(ctx).WithValues("correlationID", id))
+		result, err := r.Reconcile(ctx, req)
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+		}
+		span.SetAttributes(attribute.Bool("reconcile.requeue", result.Requeue || result.RequeueAfter > 0))
+		return result, err
+	})
+}
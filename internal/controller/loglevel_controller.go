@@ -0,0 +1,101 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+)
+
+// LogLevelKey is the ConfigMap key holding the log level of the operator
+const LogLevelKey = "logLevel"
+
+// LogLevelReconciler applies the log level stored in a ConfigMap at runtime
+type LogLevelReconciler struct {
+	client.Client
+	// ConfigMap holding the logLevel key
+	ConfigMap types.NamespacedName
+	// Level is the level of the operator logger
+	Level zap.AtomicLevel
+	// Default is restored when the ConfigMap or its key is removed
+	Default zapcore.Level
+}
+
+// Reconcile sets the log level from the ConfigMap. Invalid levels are logged and ignored.
+func (r *LogLevelReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	log := log.FromContext(ctx)
+
+	level := r.Default
+	configMap := &corev1.ConfigMap{}
+	err := r.Get(ctx, req.NamespacedName, configMap)
+	if err != nil && !errors.IsNotFound(err) {
+		log.Error(err, "Failed to get log level ConfigMap")
+		return ctrl.Result{}, err
+	}
+	if value, ok := configMap.Data[LogLevelKey]; ok && err == nil {
+		level, err = ParseLogLevel(value)
+		if err != nil {
+			log.Error(err, "Ignoring invalid log level", "value", value)
+			return ctrl.Result{}, nil
+		}
+	}
+
+	if r.Level.Level() != level {
+		log.Info("Changing log level", "from", r.Level.Level().String(), "to", level.String())
+		r.Level.SetLevel(level)
+	}
+	return ctrl.Result{}, nil
+}
+
+// ParseLogLevel parses a log level given either by name (debug, info, error) or as a
+// logr verbosity, e.g. 2 to include the messages logged with V(2)
+func ParseLogLevel(value string) (zapcore.Level, error) {
+	value = strings.TrimSpace(value)
+	if n, err := strconv.Atoi(value); err == nil {
+		if n < 0 {
+			return 0, fmt.Errorf("invalid log level %q", value)
+		}
+		return zapcore.Level(-n), nil
+	}
+
+	var level zapcore.Level
+	err := level.UnmarshalText([]byte(value))
+	return level, err
+}
+
+// SetupWithManager sets up the controller with the Manager.
+func (r *LogLevelReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		Named("loglevel").
+		For(&corev1.ConfigMap{}, builder.WithPredicates(predicate.NewPredicateFuncs(func(obj client.Object) bool {
+			return obj.GetNamespace() == r.ConfigMap.Namespace && obj.GetName() == r.ConfigMap.Name
+		}))).
+		Complete(r)
+}
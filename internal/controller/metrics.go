@@ -0,0 +1,98 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+
+	idmv1 "github.com/m15ch4/go-identity-operator/api/v1"
+)
+
+// usersDesc describes the gauge of Users per state
+var usersDesc = prometheus.NewDesc(
+	"identity_users",
+	"Number of Users by state.",
+	[]string{"state"}, nil,
+)
+
+// reconcileErrorsTotal counts failed User reconciliations by the reason recorded on the status
+var reconcileErrorsTotal = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "identity_reconcile_errors_total",
+		Help: "Number of failed User reconciliations by reason.",
+	},
+	[]string{"reason"},
+)
+
+// leader is 1 on the replica that was elected leader and reconciles
+var leader = prometheus.NewGauge(
+	prometheus.GaugeOpts{
+		Name: "identity_operator_leader",
+		Help: "Whether this replica is the elected leader.",
+	},
+)
+
+func init() {
+	metrics.Registry.MustRegister(reconcileErrorsTotal, leader)
+}
+
+// RecordLeadership sets the leader gauge once elected is closed. Without leader election
+// the manager closes it right away.
+func RecordLeadership(elected <-chan struct{}) {
+	go func() {
+		<-elected
+		leader.Set(1)
+	}()
+}
+
+// userStateCollector counts the Users in the informer cache by status.state on every scrape
+type userStateCollector struct {
+	reader client.Reader
+}
+
+func (c *userStateCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- usersDesc
+}
+
+func (c *userStateCollector) Collect(ch chan<- prometheus.Metric) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	users := &idmv1.UserList{}
+	if err := c.reader.List(ctx, users); err != nil {
+		ch <- prometheus.NewInvalidMetric(usersDesc, err)
+		return
+	}
+
+	counts := map[string]int{}
+	for _, user := range users.Items {
+		state := user.Status.State
+		if state == "" {
+			state = "Pending"
+		}
+		counts[state]++
+	}
+
+	for state, count := range counts {
+		ch <- prometheus.MustNewConstMetric(usersDesc, prometheus.GaugeValue, float64(count), state)
+	}
+}
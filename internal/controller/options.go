@@ -0,0 +1,163 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+	"k8s.io/client-go/util/workqueue"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
+)
+
+const (
+	defaultRequeueBaseDelay = 5 * time.Millisecond
+	defaultRequeueMaxDelay  = 1000 * time.Second
+	defaultRateLimiterQPS   = 10
+	defaultRateLimiterBurst = 100
+)
+
+// ControllerOptions tunes the workers and the rate limiter of a controller, and whether it
+// changes the identity system at all. Zero values fall back to the controller-runtime defaults.
+type ControllerOptions struct {
+	// MaxConcurrentReconciles is the number of objects reconciled in parallel
+	MaxConcurrentReconciles int
+
+	// RequeueBaseDelay and RequeueMaxDelay bound the exponential backoff applied to
+	// objects failing with retryable errors
+	RequeueBaseDelay time.Duration
+	RequeueMaxDelay  time.Duration
+
+	// RateLimiterQPS and RateLimiterBurst bound the overall rate of retries across all objects
+	RateLimiterQPS   float64
+	RateLimiterBurst int
+
+	// DryRun records the writes to the identity system as events instead of performing them
+	DryRun bool
+
+	// Config overrides the options, and the resync settings of the controller, with the
+	// IdentityOperatorConfig in use at the time they are read
+	Config *OperatorConfig
+}
+
+// effective returns a copy of the options with the overrides of the IdentityOperatorConfig applied
+func (o ControllerOptions) effective() ControllerOptions {
+	spec := o.Config.Spec()
+	if spec == nil {
+		return o
+	}
+	if spec.RequeueBaseDelay != nil {
+		o.RequeueBaseDelay = spec.RequeueBaseDelay.Duration
+	}
+	if spec.RequeueMaxDelay != nil {
+		o.RequeueMaxDelay = spec.RequeueMaxDelay.Duration
+	}
+	if spec.RateLimiterQPS != nil {
+		o.RateLimiterQPS = float64(*spec.RateLimiterQPS)
+	}
+	if spec.RateLimiterBurst != nil {
+		o.RateLimiterBurst = int(*spec.RateLimiterBurst)
+	}
+	if spec.DryRun != nil {
+		o.DryRun = *spec.DryRun
+	}
+	return o
+}
+
+// WithMaxConcurrentReconciles returns a copy of the options using n workers,
+// unless n is zero, so per-controller overrides can fall back to the global setting
+func (o ControllerOptions) WithMaxConcurrentReconciles(n int) ControllerOptions {
+	if n > 0 {
+		o.MaxConcurrentReconciles = n
+	}
+	return o
+}
+
+// controllerOptions converts the options into controller-runtime controller options
+func (o ControllerOptions) controllerOptions() controller.Options {
+	return controller.Options{
+		MaxConcurrentReconciles: o.MaxConcurrentReconciles,
+		RateLimiter:             newRateLimiter(o),
+	}
+}
+
+// newRateLimiter mirrors the controller-runtime default rate limiter with configurable
+// per-item exponential backoff and overall bucket size
+func newRateLimiter(o ControllerOptions) workqueue.RateLimiter {
+	if o.Config != nil {
+		return &configRateLimiter{options: o}
+	}
+	return buildRateLimiter(o)
+}
+
+// buildRateLimiter builds the rate limiter for fixed options
+func buildRateLimiter(o ControllerOptions) workqueue.RateLimiter {
+	baseDelay, maxDelay := o.RequeueBaseDelay, o.RequeueMaxDelay
+	if baseDelay <= 0 {
+		baseDelay = defaultRequeueBaseDelay
+	}
+	if maxDelay <= 0 {
+		maxDelay = defaultRequeueMaxDelay
+	}
+	qps, burst := o.RateLimiterQPS, o.RateLimiterBurst
+	if qps <= 0 {
+		qps = defaultRateLimiterQPS
+	}
+	if burst <= 0 {
+		burst = defaultRateLimiterBurst
+	}
+
+	return workqueue.NewMaxOfRateLimiter(
+		workqueue.NewItemExponentialFailureRateLimiter(baseDelay, maxDelay),
+		// this is only for retry speed and it's only the overall factor (not per item)
+		&workqueue.BucketRateLimiter{Limiter: rate.NewLimiter(rate.Limit(qps), burst)},
+	)
+}
+
+// configRateLimiter rebuilds the rate limiter whenever the IdentityOperatorConfig changes.
+// The backoff of the objects failing at that time starts over.
+type configRateLimiter struct {
+	options ControllerOptions
+
+	mu      sync.Mutex
+	version int64
+	limiter workqueue.RateLimiter
+}
+
+// current returns the rate limiter for the IdentityOperatorConfig in use
+func (l *configRateLimiter) current() workqueue.RateLimiter {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if version := l.options.Config.Version(); l.limiter == nil || version != l.version {
+		l.limiter = buildRateLimiter(l.options.effective())
+		l.version = version
+	}
+	return l.limiter
+}
+
+func (l *configRateLimiter) When(item interface{}) time.Duration {
+	return l.current().When(item)
+}
+
+func (l *configRateLimiter) Forget(item interface{}) {
+	l.current().Forget(item)
+}
+
+func (l *configRateLimiter) NumRequeues(item interface{}) int {
+	return l.current().NumRequeues(item)
+}
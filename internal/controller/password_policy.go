@@ -0,0 +1,88 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	idmv1 "github.com/m15ch4/go-identity-operator/api/v1"
+	"github.com/m15ch4/go-identity-operator/internal/encryption"
+	idmsvc "github.com/m15ch4/go-identity-operator/pkg/identityclient"
+)
+
+// passwordPolicyError is returned for passwords breaking the PasswordPolicy of their
+// IdentityInstance. It is terminal, the password has to be changed first.
+type passwordPolicyError struct {
+	policy     string
+	violations []string
+}
+
+func (e *passwordPolicyError) Error() string {
+	return fmt.Sprintf("password breaks policy %s: %s", e.policy, strings.Join(e.violations, ", "))
+}
+
+// isPasswordPolicyViolation reports whether err was returned for a password breaking its policy
+func isPasswordPolicyViolation(err error) bool {
+	var policyErr *passwordPolicyError
+	return errors.As(err, &policyErr)
+}
+
+// isTerminal reports whether err will not succeed without a change of the object, either
+// because the identity system rejected the request, the password breaks its policy or it
+// cannot be decrypted
+func isTerminal(err error) bool {
+	return idmsvc.IsTerminal(err) || isPasswordPolicyViolation(err) || encryption.IsPermanent(err)
+}
+
+// passwordPolicyFor returns the PasswordPolicy referenced by the IdentityInstance, or nil
+// for objects using the operator-level configuration and instances without a policy
+func passwordPolicyFor(ctx context.Context, c client.Reader, instanceRef *idmv1.IdentityInstanceReference) (*idmv1.PasswordPolicy, error) {
+	if instanceRef == nil {
+		return nil, nil
+	}
+	instance := &idmv1.IdentityInstance{}
+	err := c.Get(ctx, types.NamespacedName{Name: instanceRef.Name}, instance)
+	if err != nil {
+		return nil, err
+	}
+	if instance.Spec.PasswordPolicyRef == nil {
+		return nil, nil
+	}
+	policy := &idmv1.PasswordPolicy{}
+	err = c.Get(ctx, types.NamespacedName{Name: instance.Spec.PasswordPolicyRef.Name}, policy)
+	if err != nil {
+		return nil, err
+	}
+	return policy, nil
+}
+
+// checkPasswordPolicy returns a passwordPolicyError if the password breaks the policy
+func checkPasswordPolicy(policy *idmv1.PasswordPolicy, password string) error {
+	if policy == nil {
+		return nil
+	}
+	if violations := policy.Spec.Violations(password); len(violations) > 0 {
+		return &passwordPolicyError{policy: policy.Name, violations: violations}
+	}
+	return nil
+}
@@ -0,0 +1,222 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"crypto/rand"
+	"fmt"
+	"math/big"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	idmv1 "github.com/m15ch4/go-identity-operator/api/v1"
+)
+
+const (
+	// defaultRotationInterval applies when spec.passwordRotation.interval is not set
+	defaultRotationInterval = 30 * 24 * time.Hour
+
+	generatedPasswordLength  = 24
+	generatedPasswordUpper   = "ABCDEFGHIJKLMNOPQRSTUVWXYZ"
+	generatedPasswordLower   = "abcdefghijklmnopqrstuvwxyz"
+	generatedPasswordDigits  = "0123456789"
+	generatedPasswordSymbols = "!#%+-.:=?@_"
+	generatedPasswordCharset = generatedPasswordUpper + generatedPasswordLower + generatedPasswordDigits
+
+	// generatePasswordAttempts bounds the retries of passwords containing a banned word
+	generatePasswordAttempts = 10
+)
+
+// rotationEnabled reports whether the password of the user is rotated
+func rotationEnabled(user *idmv1.User) bool {
+	return user.Spec.PasswordRotation != nil && user.Spec.PasswordRotation.Enabled
+}
+
+// nextPasswordRotation returns the time the password of the user is rotated next. A user
+// that was never rotated is due immediately, so the target Secret is populated.
+func nextPasswordRotation(user *idmv1.User) (time.Time, bool) {
+	if !rotationEnabled(user) {
+		return time.Time{}, false
+	}
+	if user.Status.LastPasswordRotation == nil {
+		return time.Now(), true
+	}
+
+	interval := user.Spec.PasswordRotation.Interval.Duration
+	if interval <= 0 {
+		interval = defaultRotationInterval
+	}
+	return user.Status.LastPasswordRotation.Add(interval), true
+}
+
+// rotationDue reports whether the password of the user has to be rotated now
+func rotationDue(user *idmv1.User) bool {
+	next, ok := nextPasswordRotation(user)
+	return ok && !time.Now().Before(next)
+}
+
+// resyncAfter returns the delay until the user has to be reconciled again, which is the
+// drift resync period, the next password rotation or the expiration, whichever comes first
+func (r *UserReconciler) resyncAfter(user *idmv1.User) time.Duration {
+	delay := r.Options.Config.driftResyncPeriod(r.DriftResyncPeriod)
+	for _, next := range []func(*idmv1.User) (time.Time, bool){nextPasswordRotation, (*idmv1.User).ExpiresAt} {
+		at, ok := next(user)
+		if !ok {
+			continue
+		}
+		until := time.Until(at)
+		if until < time.Second {
+			until = time.Second
+		}
+		if delay == 0 || until < delay {
+			delay = until
+		}
+	}
+	return delay
+}
+
+// rotatePassword generates a new password, writes it to the target Secret and sets it
+// in the identity system. The Secret is written first so that a password accepted by
+// the identity system is never lost; a failed update is retried with a fresh password.
+func (r *UserReconciler) rotatePassword(ctx context.Context, user *idmv1.User) error {
+	log := log.FromContext(ctx)
+
+	policy, err := passwordPolicyFor(ctx, r.Client, r.Options.Config.instanceRef(user.Spec.InstanceRef))
+	if err != nil {
+		return err
+	}
+	password, err := generatePassword(policy)
+	if err != nil {
+		return err
+	}
+
+	ref := user.Spec.PasswordRotation.SecretRef
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Namespace: r.referenceNamespace(user), Name: ref.Name},
+	}
+	_, err = controllerutil.CreateOrUpdate(ctx, r.Client, secret, func() error {
+		if secret.Data == nil {
+			secret.Data = map[string][]byte{}
+		}
+		secret.Data[ref.Key] = []byte(password)
+		if secret.CreationTimestamp.IsZero() {
+			return controllerutil.SetControllerReference(user, secret, r.Scheme)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	spec, err := r.resolveSpec(ctx, user)
+	if err != nil {
+		return err
+	}
+	spec.Password = password
+
+	svc, err := r.identityService(ctx, user)
+	if err != nil {
+		return err
+	}
+
+	_, err = svc.PatchUser(ctx, user.Status.ID, spec, []string{"password"})
+	if err != nil {
+		return err
+	}
+
+	if user.Status.CredentialsSecret != "" {
+		err = r.writeCredentialsSecret(ctx, user, password)
+		if err != nil {
+			return err
+		}
+	}
+
+	now := metav1.Now()
+	user.Status.LastPasswordRotation = &now
+	log.Info("Password rotated", "secret", ref.Name)
+	r.Recorder.Eventf(user, corev1.EventTypeNormal, "PasswordRotated", "Rotated password of user %s, stored in secret %s", user.Status.ID, ref.Name)
+
+	return nil
+}
+
+// generatePassword returns a random password following the policy, if any. Passwords are
+// alphanumeric unless the policy requires symbols.
+func generatePassword(policy *idmv1.PasswordPolicy) (string, error) {
+	length := generatedPasswordLength
+	charset := generatedPasswordCharset
+	// a character of each class required by the policy is included
+	var required []string
+	if policy != nil {
+		if minLength := int(policy.Spec.MinLength); minLength > length {
+			length = minLength
+		}
+		if policy.Spec.RequireUppercase {
+			required = append(required, generatedPasswordUpper)
+		}
+		if policy.Spec.RequireLowercase {
+			required = append(required, generatedPasswordLower)
+		}
+		if policy.Spec.RequireDigits {
+			required = append(required, generatedPasswordDigits)
+		}
+		if policy.Spec.RequireSymbols {
+			required = append(required, generatedPasswordSymbols)
+			charset += generatedPasswordSymbols
+		}
+	}
+
+	for attempt := 0; attempt < generatePasswordAttempts; attempt++ {
+		password := make([]byte, length)
+		for i := range password {
+			set := charset
+			if i < len(required) {
+				set = required[i]
+			}
+			c, err := randomIndex(len(set))
+			if err != nil {
+				return "", err
+			}
+			password[i] = set[c]
+		}
+		// move the required characters to random positions
+		for i := len(password) - 1; i > 0; i-- {
+			j, err := randomIndex(i + 1)
+			if err != nil {
+				return "", err
+			}
+			password[i], password[j] = password[j], password[i]
+		}
+		if checkPasswordPolicy(policy, string(password)) == nil {
+			return string(password), nil
+		}
+	}
+	return "", fmt.Errorf("failed to generate a password following policy %s", policy.Name)
+}
+
+// randomIndex returns a cryptographically random number in [0, n)
+func randomIndex(n int) (int, error) {
+	i, err := rand.Int(rand.Reader, big.NewInt(int64(n)))
+	if err != nil {
+		return 0, err
+	}
+	return int(i.Int64()), nil
+}
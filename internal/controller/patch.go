@@ -0,0 +1,54 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/client-go/util/retry"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// patchStatus writes the status of obj as a merge patch against base, the object as it
+// was read at the start of the reconcile. The resourceVersion is left out of the patch,
+// so the status never conflicts with concurrent writes of the spec or metadata. The status
+// of a reconcile cut off by the end of the shutdown drain is still written.
+func patchStatus(ctx context.Context, c client.Client, obj, base client.Object) error {
+	ctx, cancel := persistContext(ctx)
+	defer cancel()
+	base = base.DeepCopyObject().(client.Object)
+	base.SetResourceVersion(obj.GetResourceVersion())
+	return c.Status().Patch(ctx, obj, client.MergeFrom(base))
+}
+
+// patchWithRetry applies mutate to obj and writes the change as a merge patch guarded by
+// the resourceVersion. On a conflict obj is read again and mutate is reapplied, so mutate
+// must be idempotent, e.g. adding or removing a finalizer.
+func patchWithRetry(ctx context.Context, c client.Client, obj client.Object, mutate func()) error {
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		base := obj.DeepCopyObject().(client.Object)
+		mutate()
+		err := c.Patch(ctx, obj, client.MergeFromWithOptions(base, client.MergeFromWithOptimisticLock{}))
+		if errors.IsConflict(err) {
+			if getErr := c.Get(ctx, client.ObjectKeyFromObject(obj), obj); getErr != nil {
+				return getErr
+			}
+		}
+		return err
+	})
+}
@@ -0,0 +1,54 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	idmv1 "github.com/m15ch4/go-identity-operator/api/v1"
+)
+
+// paused reports whether reconciliation of obj is paused by its spec or the paused annotation
+func paused(obj metav1.Object, specPaused bool) bool {
+	return specPaused || obj.GetAnnotations()[idmv1.AnnotationPaused] == "true"
+}
+
+// setPaused records in conditions whether reconciliation is paused. Objects that were
+// never paused do not get the condition at all.
+func setPaused(conditions *[]metav1.Condition, generation int64, paused bool) {
+	if paused {
+		meta.SetStatusCondition(conditions, metav1.Condition{
+			Type:               idmv1.ConditionPaused,
+			Status:             metav1.ConditionTrue,
+			ObservedGeneration: generation,
+			Reason:             "Paused",
+			Message:            "Reconciliation is paused",
+		})
+		return
+	}
+
+	if meta.FindStatusCondition(*conditions, idmv1.ConditionPaused) != nil {
+		meta.SetStatusCondition(conditions, metav1.Condition{
+			Type:               idmv1.ConditionPaused,
+			Status:             metav1.ConditionFalse,
+			ObservedGeneration: generation,
+			Reason:             "Resumed",
+			Message:            "Reconciliation is resumed",
+		})
+	}
+}
@@ -0,0 +1,133 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"fmt"
+
+	idmv1 "github.com/m15ch4/go-identity-operator/api/v1"
+	"github.com/m15ch4/go-identity-operator/internal/status"
+	idmsvc "github.com/m15ch4/go-identity-operator/pkg/identityclient"
+)
+
+// PlanAction is what the operator would do to the external object of a managed object
+type PlanAction string
+
+const (
+	// PlanActionCreate creates the external object
+	PlanActionCreate PlanAction = "create"
+	// PlanActionAdopt takes over the existing external object of the same name
+	PlanActionAdopt PlanAction = "adopt"
+	// PlanActionUpdate updates the drifted fields of the external object
+	PlanActionUpdate PlanAction = "update"
+	// PlanActionDelete deletes the external object
+	PlanActionDelete PlanAction = "delete"
+	// PlanActionNone leaves the external object as it is
+	PlanActionNone PlanAction = "none"
+	// PlanActionSkip leaves the object alone until it is fixed or resumed
+	PlanActionSkip PlanAction = "skip"
+)
+
+// PlannedChange is the change the next reconcile of a managed object would make in the
+// identity system
+type PlannedChange struct {
+	Action PlanAction
+	// Fields are the JSON names of the fields that would be updated
+	Fields []string
+	// Reason explains why the object is skipped
+	Reason string
+}
+
+// Plan computes what the next reconcile of the User would do in the identity system,
+// without changing either of them
+func (r *UserReconciler) Plan(ctx context.Context, user *idmv1.User) (*PlannedChange, error) {
+	if paused(user, user.Spec.Paused) {
+		return &PlannedChange{Action: PlanActionSkip, Reason: "reconciliation is paused"}, nil
+	}
+	if !user.DeletionTimestamp.IsZero() {
+		if user.Status.ID == "" || !containsString(user.GetFinalizers(), userFinalizer) || user.Spec.DeletionPolicy == idmv1.DeletionPolicyOrphan {
+			return &PlannedChange{Action: PlanActionNone}, nil
+		}
+		return &PlannedChange{Action: PlanActionDelete}, nil
+	}
+	if stalled := status.Stalled(user); stalled != nil && !resolvesConflict(user) {
+		return &PlannedChange{Action: PlanActionSkip, Reason: stalled.Message}, nil
+	}
+
+	diff, err := r.Diff(ctx, user)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case diff.External == nil && user.Status.ID != "":
+		return &PlannedChange{Action: PlanActionSkip, Reason: fmt.Sprintf("external user %s does not exist", user.Status.ID)}, nil
+	case diff.External == nil:
+		return &PlannedChange{Action: PlanActionCreate}, nil
+	case user.Status.ID == "" && !adoptionRequested(user):
+		return &PlannedChange{Action: PlanActionSkip, Reason: fmt.Sprintf("name %s is taken by external user %s", user.Spec.Name, diff.External.ID)}, nil
+	case user.Status.ID == "":
+		return &PlannedChange{Action: PlanActionAdopt, Fields: diff.Drifted}, nil
+	case len(diff.Drifted) > 0:
+		return &PlannedChange{Action: PlanActionUpdate, Fields: diff.Drifted}, nil
+	}
+	return &PlannedChange{Action: PlanActionNone}, nil
+}
+
+// Plan computes what the next reconcile of the Group would do in the identity system,
+// without changing either of them. Nesting is not planned.
+func (r *GroupReconciler) Plan(ctx context.Context, group *idmv1.Group) (*PlannedChange, error) {
+	if paused(group, group.Spec.Paused) {
+		return &PlannedChange{Action: PlanActionSkip, Reason: "reconciliation is paused"}, nil
+	}
+	if !group.DeletionTimestamp.IsZero() {
+		if group.Status.ID == "" || !containsString(group.GetFinalizers(), groupFinalizer) {
+			return &PlannedChange{Action: PlanActionNone}, nil
+		}
+		return &PlannedChange{Action: PlanActionDelete}, nil
+	}
+	if stalled := status.Stalled(group); stalled != nil {
+		return &PlannedChange{Action: PlanActionSkip, Reason: stalled.Message}, nil
+	}
+	if group.Status.ID == "" {
+		return &PlannedChange{Action: PlanActionCreate}, nil
+	}
+
+	svc, err := identityServiceFor(ctx, r.Client, group.Namespace, r.Options.Config.instanceRef(group.Spec.InstanceRef), r.IdentityService, r.CredentialsSecret)
+	if err != nil {
+		return nil, err
+	}
+	extGroup, err := svc.GetGroup(ctx, group.Status.ID)
+	if idmsvc.IsNotFound(err) {
+		return &PlannedChange{Action: PlanActionSkip, Reason: fmt.Sprintf("external group %s does not exist", group.Status.ID)}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	change := &PlannedChange{Action: PlanActionNone}
+	if extGroup.Name != group.Spec.Name {
+		change.Fields = append(change.Fields, "name")
+	}
+	if extGroup.Description != group.Spec.Description {
+		change.Fields = append(change.Fields, "description")
+	}
+	if len(change.Fields) > 0 {
+		change.Action = PlanActionUpdate
+	}
+	return change, nil
+}
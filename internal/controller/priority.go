@@ -0,0 +1,176 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"sync"
+
+	"k8s.io/client-go/util/workqueue"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	idmv1 "github.com/m15ch4/go-identity-operator/api/v1"
+)
+
+// Levels of the priority queue, lower levels are handed to the workqueue first
+const (
+	priorityHigh = iota
+	priorityNormal
+	priorityLow
+	priorityLevels
+)
+
+// userPriority returns the level of the priority annotation of the user
+func userPriority(user *idmv1.User) int {
+	switch user.Annotations[idmv1.AnnotationPriority] {
+	case idmv1.PriorityHigh:
+		return priorityHigh
+	case idmv1.PriorityLow:
+		return priorityLow
+	}
+	return priorityNormal
+}
+
+// priorityQueue holds the Users enqueued by watch events in front of the workqueue of the
+// controller and fills the workqueue only up to the number of workers, highest priority
+// first. High priority Users thereby overtake a backlog of regular ones, e.g. after an
+// IdentityInstance became ready. Events of high priority Users and requeues requested by
+// reconciles bypass it.
+type priorityQueue struct {
+	reader client.Reader
+	// limit is the length the workqueue is filled up to
+	limit int
+
+	mu      sync.Mutex
+	queue   workqueue.RateLimitingInterface
+	pending [priorityLevels][]reconcile.Request
+	levels  map[reconcile.Request]int
+}
+
+func newPriorityQueue(reader client.Reader, limit int) *priorityQueue {
+	if limit <= 0 {
+		limit = 1
+	}
+	return &priorityQueue{
+		reader: reader,
+		limit:  limit,
+		levels: map[reconcile.Request]int{},
+	}
+}
+
+// enqueue returns an event handler adding the requests mapped from the objects of the
+// events to the priority queue, like handler.EnqueueRequestsFromMapFunc
+func (p *priorityQueue) enqueue(mapFunc handler.MapFunc) handler.EventHandler {
+	return handler.Funcs{
+		CreateFunc: func(ctx context.Context, e event.CreateEvent, q workqueue.RateLimitingInterface) {
+			p.add(ctx, q, mapFunc(ctx, e.Object))
+		},
+		UpdateFunc: func(ctx context.Context, e event.UpdateEvent, q workqueue.RateLimitingInterface) {
+			p.add(ctx, q, mapFunc(ctx, e.ObjectNew))
+		},
+		DeleteFunc: func(ctx context.Context, e event.DeleteEvent, q workqueue.RateLimitingInterface) {
+			p.add(ctx, q, mapFunc(ctx, e.Object))
+		},
+		GenericFunc: func(ctx context.Context, e event.GenericEvent, q workqueue.RateLimitingInterface) {
+			p.add(ctx, q, mapFunc(ctx, e.Object))
+		},
+	}
+}
+
+// add queues the requests by the priority of their Users and fills up the workqueue.
+// A request already waiting keeps its place unless its priority was raised.
+func (p *priorityQueue) add(ctx context.Context, q workqueue.RateLimitingInterface, requests []reconcile.Request) {
+	levels := make([]int, len(requests))
+	for i, req := range requests {
+		levels[i] = priorityNormal
+		user := &idmv1.User{}
+		if err := p.reader.Get(ctx, req.NamespacedName, user); err == nil {
+			levels[i] = userPriority(user)
+		}
+	}
+
+	p.mu.Lock()
+	p.queue = q
+	for i, req := range requests {
+		level := levels[i]
+		if current, ok := p.levels[req]; ok {
+			if current <= level {
+				continue
+			}
+			p.remove(req, current)
+		}
+		p.pending[level] = append(p.pending[level], req)
+		p.levels[req] = level
+	}
+	p.mu.Unlock()
+
+	p.feed()
+}
+
+// remove drops the request from the given level; p.mu must be held
+func (p *priorityQueue) remove(req reconcile.Request, level int) {
+	pending := p.pending[level]
+	for i := range pending {
+		if pending[i] == req {
+			p.pending[level] = append(pending[:i], pending[i+1:]...)
+			break
+		}
+	}
+	delete(p.levels, req)
+}
+
+// feed moves the waiting requests of the highest priority into the workqueue until it
+// holds as many requests as there are workers
+func (p *priorityQueue) feed() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.queue == nil {
+		return
+	}
+	for level := 0; level < priorityLevels; level++ {
+		for len(p.pending[level]) > 0 && p.queue.Len() < p.limit {
+			req := p.pending[level][0]
+			p.pending[level] = p.pending[level][1:]
+			delete(p.levels, req)
+			p.queue.Add(req)
+		}
+	}
+}
+
+// feeding wraps the reconciler to fill up the workqueue after every reconcile
+func (p *priorityQueue) feeding(r reconcile.Reconciler) reconcile.Reconciler {
+	return reconcile.Func(func(ctx context.Context, req reconcile.Request) (reconcile.Result, error) {
+		defer p.feed()
+		return r.Reconcile(ctx, req)
+	})
+}
+
+// highPriority passes the events of high priority Users, which are enqueued right away
+var highPriority = predicate.NewPredicateFuncs(func(obj client.Object) bool {
+	user, ok := obj.(*idmv1.User)
+	return ok && userPriority(user) == priorityHigh
+})
+
+// requestFor maps an object to the request reconciling it
+func requestFor(_ context.Context, obj client.Object) []reconcile.Request {
+	return []reconcile.Request{{NamespacedName: client.ObjectKeyFromObject(obj)}}
+}
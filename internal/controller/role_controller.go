@@ -0,0 +1,214 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"reflect"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	idmv1 "github.com/m15ch4/go-identity-operator/api/v1"
+	"github.com/m15ch4/go-identity-operator/internal/status"
+	idmsvc "github.com/m15ch4/go-identity-operator/pkg/identityclient"
+)
+
+const roleFinalizer = "micze.io/role-finalizer"
+
+// RoleReconciler reconciles a Role object
+type RoleReconciler struct {
+	client.Client
+	Scheme *runtime.Scheme
+
+	// Recorder emits Events on Roles
+	Recorder record.EventRecorder
+
+	// IdentityService is the long-lived service used for Roles without an instanceRef
+	IdentityService idmsvc.IdentityAPI
+
+	// Options tunes the workers and the rate limiter of the controller
+	Options ControllerOptions
+
+	// DriftResyncPeriod is the interval after which a synced Role is compared with the
+	// external role again. Zero disables periodic resync.
+	DriftResyncPeriod time.Duration
+
+	// CredentialsSecret optionally references a Secret with IDM_USER and IDM_PASS keys
+	// used to log in to the identity system
+	CredentialsSecret types.NamespacedName
+}
+
+//+kubebuilder:rbac:groups=idm.micze.io,resources=roles,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=idm.micze.io,resources=roles/status,verbs=get;update;patch
+//+kubebuilder:rbac:groups=idm.micze.io,resources=roles/finalizers,verbs=update
+
+// Reconcile creates, updates and deletes the role in the identity system so that it
+// matches the Role spec.
+func (r *RoleReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	log := log.FromContext(ctx)
+
+	role := &idmv1.Role{}
+	err := r.Get(ctx, req.NamespacedName, role)
+	if err != nil {
+		if errors.IsNotFound(err) {
+			log.Info("Role resource not found. Ignoring since object must be deleted")
+			return ctrl.Result{}, nil
+		}
+		log.Error(err, "Failed to get Role")
+		return ctrl.Result{}, err
+	}
+	original := role.DeepCopy()
+
+	// Leave the identity system alone while paused, deletion waits for the resume
+	isPaused := paused(role, role.Spec.Paused)
+	setPaused(&role.Status.Conditions, role.Generation, isPaused)
+	if isPaused {
+		log.Info("Reconciliation is paused")
+		if !equality.Semantic.DeepEqual(original.Status, role.Status) {
+			err = patchStatus(ctx, r.Client, role, original)
+			if err != nil {
+				log.Info("Failed to update role status")
+				return ctrl.Result{}, err
+			}
+		}
+		return ctrl.Result{}, nil
+	}
+
+	svc, err := identityServiceFor(ctx, r.Client, role.Namespace, r.Options.Config.instanceRef(role.Spec.InstanceRef), r.IdentityService, r.CredentialsSecret)
+	if err != nil {
+		r.setDegraded(ctx, role, original, "ConfigurationFailed", err)
+		return requeueFor(ctx, err)
+	}
+	svc = withDryRun(svc, role, r.Recorder, r.Options)
+
+	// Remove the external role before letting the Role go
+	if !role.ObjectMeta.DeletionTimestamp.IsZero() {
+		if containsString(role.GetFinalizers(), roleFinalizer) {
+			if role.Status.ID != "" {
+				err := svc.DeleteRole(ctx, role.Status.ID)
+				if err != nil && !idmsvc.IsNotFound(err) {
+					r.setDegraded(ctx, role, original, "FinalizeFailed", err)
+					return requeueFor(ctx, err)
+				}
+			}
+
+			err = patchWithRetry(ctx, r.Client, role, func() {
+				controllerutil.RemoveFinalizer(role, roleFinalizer)
+			})
+			if err != nil {
+				return ctrl.Result{}, err
+			}
+		}
+		return ctrl.Result{}, nil
+	}
+
+	// Terminal errors are not retried until the spec changes
+	if stalled := status.Stalled(role); stalled != nil {
+		log.Info("Role is stalled, waiting for a spec change", "reason", stalled.Reason)
+		return ctrl.Result{}, nil
+	}
+
+	if role.Status.ID == "" {
+		log.Info("Creating role")
+		extRole, err := svc.CreateRole(ctx, &role.Spec)
+		if err != nil {
+			r.setDegraded(ctx, role, original, "CreateFailed", err)
+			return requeueFor(ctx, err)
+		}
+		role.Status.ID = extRole.ID
+		status.MarkSynced(role, "Created", "Role created in identity system")
+		r.Recorder.Eventf(role, corev1.EventTypeNormal, "RoleCreated", "Created role %s in identity system", extRole.ID)
+	} else {
+		extRole, err := svc.GetRole(ctx, role.Status.ID)
+		if err != nil {
+			r.setDegraded(ctx, role, original, "GetFailed", err)
+			return requeueFor(ctx, err)
+		}
+
+		// compare fields of the external role with the spec fields of the Role
+		if extRole.Name != role.Spec.Name || extRole.Description != role.Spec.Description || !samePermissions(extRole.Permissions, role.Spec.Permissions) {
+			log.Info("Updating role")
+			_, err = svc.UpdateRole(ctx, role.Status.ID, &role.Spec)
+			if err != nil {
+				r.setDegraded(ctx, role, original, "UpdateFailed", err)
+				return requeueFor(ctx, err)
+			}
+			status.MarkSynced(role, "Updated", "Role updated in identity system")
+			r.Recorder.Eventf(role, corev1.EventTypeNormal, "RoleUpdated", "Updated role %s in identity system", role.Status.ID)
+		} else {
+			status.MarkSynced(role, "UpToDate", "Role matches the identity system")
+		}
+	}
+
+	if !equality.Semantic.DeepEqual(original.Status, role.Status) {
+		err = patchStatus(ctx, r.Client, role, original)
+		if err != nil {
+			log.Info("Failed to update role status")
+			return ctrl.Result{}, err
+		}
+	}
+
+	if !containsString(role.GetFinalizers(), roleFinalizer) {
+		if err := patchWithRetry(ctx, r.Client, role, func() {
+			controllerutil.AddFinalizer(role, roleFinalizer)
+		}); err != nil {
+			return ctrl.Result{}, err
+		}
+	}
+
+	return ctrl.Result{RequeueAfter: r.Options.Config.driftResyncPeriod(r.DriftResyncPeriod)}, nil
+}
+
+// setDegraded records the failure on the role status; errors updating the status are only logged
+func (r *RoleReconciler) setDegraded(ctx context.Context, role, original *idmv1.Role, reason string, cause error) {
+	log := log.FromContext(ctx)
+
+	r.Recorder.Event(role, corev1.EventTypeWarning, "ExternalAPIError", cause.Error())
+
+	reason = failureReason(cause, reason)
+	status.MarkFailed(role, reason, cause.Error(), idmsvc.IsTerminal(cause))
+
+	if err := patchStatus(ctx, r.Client, role, original); err != nil {
+		log.Error(err, "Failed to update role status")
+	}
+}
+
+// samePermissions compares permission lists ignoring nil versus empty
+func samePermissions(a, b []string) bool {
+	if len(a) == 0 && len(b) == 0 {
+		return true
+	}
+	return reflect.DeepEqual(a, b)
+}
+
+// SetupWithManager sets up the controller with the Manager.
+func (r *RoleReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&idmv1.Role{}).
+		WithOptions(r.Options.controllerOptions()).
+		Complete(withDrain(withCorrelationID(r)))
+}
@@ -0,0 +1,118 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	idmv1 "github.com/m15ch4/go-identity-operator/api/v1"
+	"github.com/m15ch4/go-identity-operator/pkg/identityclient/fake"
+)
+
+var _ = Describe("Role controller", func() {
+	var (
+		ctx        context.Context
+		svc        *fake.IdentityService
+		reconciler *RoleReconciler
+		role       *idmv1.Role
+	)
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		svc = fake.NewIdentityService()
+		reconciler = &RoleReconciler{
+			Client:            k8sClient,
+			Scheme:            k8sClient.Scheme(),
+			Recorder:          record.NewFakeRecorder(100),
+			IdentityService:   svc,
+			DriftResyncPeriod: time.Minute,
+		}
+
+		role = &idmv1.Role{
+			ObjectMeta: metav1.ObjectMeta{GenerateName: "role-", Namespace: "default"},
+			Spec: idmv1.RoleSpec{
+				Name:        "auditor",
+				Description: "Reads the audit log",
+				Permissions: []string{"audit:read"},
+			},
+		}
+		Expect(k8sClient.Create(ctx, role)).To(Succeed())
+	})
+
+	AfterEach(func() {
+		current := &idmv1.Role{}
+		err := k8sClient.Get(ctx, client.ObjectKeyFromObject(role), current)
+		if errors.IsNotFound(err) {
+			return
+		}
+		Expect(err).NotTo(HaveOccurred())
+		current.SetFinalizers(nil)
+		Expect(k8sClient.Update(ctx, current)).To(Succeed())
+		Expect(client.IgnoreNotFound(k8sClient.Delete(ctx, current))).To(Succeed())
+	})
+
+	reconcileRole := func() *idmv1.Role {
+		result, err := reconciler.Reconcile(ctx, reconcile.Request{NamespacedName: client.ObjectKeyFromObject(role)})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(result).To(Equal(ctrl.Result{RequeueAfter: time.Minute}))
+		current := &idmv1.Role{}
+		Expect(k8sClient.Get(ctx, client.ObjectKeyFromObject(role), current)).To(Succeed())
+		return current
+	}
+
+	It("creates, updates and deletes the external role", func() {
+		By("creating the external role and adding the finalizer")
+		current := reconcileRole()
+		Expect(current.Finalizers).To(ContainElement(roleFinalizer))
+		Expect(meta.IsStatusConditionTrue(current.Status.Conditions, idmv1.ConditionReady)).To(BeTrue())
+		id := current.Status.ID
+		Expect(svc.Roles).To(HaveKey(id))
+		Expect(svc.Roles[id].Name).To(Equal("auditor"))
+		Expect(svc.Roles[id].Permissions).To(ConsistOf("audit:read"))
+
+		By("updating the external role when the spec changes")
+		current.Spec.Permissions = append(current.Spec.Permissions, "audit:export")
+		Expect(k8sClient.Update(ctx, current)).To(Succeed())
+		reconcileRole()
+		Expect(svc.Roles[id].Permissions).To(ConsistOf("audit:read", "audit:export"))
+		Expect(svc.Calls["UpdateRole"]).To(Equal(1))
+
+		By("leaving the role alone while it matches")
+		reconcileRole()
+		Expect(svc.Calls["UpdateRole"]).To(Equal(1))
+
+		By("deleting the external role with the Role")
+		Expect(k8sClient.Delete(ctx, current)).To(Succeed())
+		_, err := reconciler.Reconcile(ctx, reconcile.Request{NamespacedName: client.ObjectKeyFromObject(role)})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(svc.Roles).NotTo(HaveKey(id))
+		err = k8sClient.Get(ctx, client.ObjectKeyFromObject(role), current)
+		Expect(errors.IsNotFound(err)).To(BeTrue())
+	})
+})
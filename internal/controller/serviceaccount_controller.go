@@ -0,0 +1,248 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+
+	idmv1 "github.com/m15ch4/go-identity-operator/api/v1"
+	idmsvc "github.com/m15ch4/go-identity-operator/pkg/identityclient"
+)
+
+const serviceAccountFinalizer = "micze.io/serviceaccount-finalizer"
+
+// ServiceAccountReconciler provisions a machine user in the identity system for every
+// ServiceAccount annotated for sync, bridging the identities of the cluster and the
+// identity system. The ID of the machine user is written back in the external-id
+// annotation and its credentials are kept in a Secret owned by the ServiceAccount.
+type ServiceAccountReconciler struct {
+	client.Client
+	Scheme *runtime.Scheme
+
+	// Recorder emits Events on ServiceAccounts
+	Recorder record.EventRecorder
+
+	// IdentityService is the long-lived service used for ServiceAccounts without an
+	// instance annotation
+	IdentityService idmsvc.IdentityAPI
+
+	// Options tunes the workers and the rate limiter of the controller
+	Options ControllerOptions
+
+	// DriftResyncPeriod is the interval after which the machine user is checked for
+	// existence again. Zero disables periodic resync.
+	DriftResyncPeriod time.Duration
+
+	// CredentialsSecret optionally references a Secret with IDM_USER and IDM_PASS keys
+	// used to log in to the identity system
+	CredentialsSecret types.NamespacedName
+}
+
+//+kubebuilder:rbac:groups=core,resources=serviceaccounts,verbs=get;list;watch;update;patch
+//+kubebuilder:rbac:groups=core,resources=secrets,verbs=get;list;watch;create;update;patch
+
+// Reconcile creates the machine user of a ServiceAccount annotated for sync, or recreates
+// it when it was deleted out of band, and deletes it once the ServiceAccount is deleted or
+// no longer annotated.
+func (r *ServiceAccountReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	log := log.FromContext(ctx)
+
+	sa := &corev1.ServiceAccount{}
+	err := r.Get(ctx, req.NamespacedName, sa)
+	if err != nil {
+		if errors.IsNotFound(err) {
+			return ctrl.Result{}, nil
+		}
+		log.Error(err, "Failed to get ServiceAccount")
+		return ctrl.Result{}, err
+	}
+
+	// Delete the machine user of a deleted or opted out ServiceAccount
+	if !syncedServiceAccount(sa) {
+		if !containsString(sa.GetFinalizers(), serviceAccountFinalizer) {
+			return ctrl.Result{}, nil
+		}
+		if id := sa.Annotations[idmv1.AnnotationExternalID]; id != "" {
+			svc, err := r.identityService(ctx, sa)
+			if err != nil {
+				return requeueFor(ctx, err)
+			}
+			err = svc.DeleteUser(ctx, id)
+			if err != nil && !idmsvc.IsNotFound(err) {
+				r.Recorder.Event(sa, corev1.EventTypeWarning, "ExternalAPIError", err.Error())
+				return requeueFor(ctx, err)
+			}
+			log.Info("Deleted machine user", "id", id)
+			r.Recorder.Eventf(sa, corev1.EventTypeNormal, "MachineUserDeleted", "Deleted machine user %s from identity system", id)
+		}
+		err = patchWithRetry(ctx, r.Client, sa, func() {
+			controllerutil.RemoveFinalizer(sa, serviceAccountFinalizer)
+			delete(sa.Annotations, idmv1.AnnotationExternalID)
+		})
+		return ctrl.Result{}, client.IgnoreNotFound(err)
+	}
+
+	// The finalizer is added before the machine user is created, so it never leaks
+	if !containsString(sa.GetFinalizers(), serviceAccountFinalizer) {
+		err = patchWithRetry(ctx, r.Client, sa, func() {
+			controllerutil.AddFinalizer(sa, serviceAccountFinalizer)
+		})
+		if err != nil {
+			return ctrl.Result{}, err
+		}
+	}
+
+	svc, err := r.identityService(ctx, sa)
+	if err != nil {
+		return requeueFor(ctx, err)
+	}
+	resync := r.Options.Config.driftResyncPeriod(r.DriftResyncPeriod)
+
+	if id := sa.Annotations[idmv1.AnnotationExternalID]; id != "" {
+		_, err = svc.GetUser(ctx, id)
+		if err == nil {
+			return ctrl.Result{RequeueAfter: resync}, nil
+		}
+		if !idmsvc.IsNotFound(err) {
+			r.Recorder.Event(sa, corev1.EventTypeWarning, "ExternalAPIError", err.Error())
+			return requeueFor(ctx, err)
+		}
+		log.Info("Machine user was deleted from identity system, creating it again", "id", id)
+	}
+
+	// The credentials are stored before the machine user is created, so a password accepted
+	// by the identity system is never lost
+	policy, err := passwordPolicyFor(ctx, r.Client, r.instanceRef(sa))
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	password, err := generatePassword(policy)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	spec := &idmv1.UserSpec{
+		Name:        serviceAccountUsername(sa),
+		Password:    password,
+		DisplayName: fmt.Sprintf("ServiceAccount %s/%s", sa.Namespace, sa.Name),
+	}
+	err = r.writeCredentialsSecret(ctx, sa, spec)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+
+	extUser, err := svc.CreateUser(ctx, spec)
+	if err != nil {
+		r.Recorder.Event(sa, corev1.EventTypeWarning, "ExternalAPIError", err.Error())
+		return requeueFor(ctx, err)
+	}
+	err = patchWithRetry(ctx, r.Client, sa, func() {
+		if sa.Annotations == nil {
+			sa.Annotations = map[string]string{}
+		}
+		sa.Annotations[idmv1.AnnotationExternalID] = extUser.ID
+	})
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+
+	log.Info("Created machine user", "id", extUser.ID, "name", spec.Name)
+	r.Recorder.Eventf(sa, corev1.EventTypeNormal, "MachineUserCreated", "Created machine user %s in identity system", extUser.ID)
+	return ctrl.Result{RequeueAfter: resync}, nil
+}
+
+// syncedServiceAccount reports whether the ServiceAccount is annotated for sync and not deleted
+func syncedServiceAccount(sa *corev1.ServiceAccount) bool {
+	return sa.Annotations[idmv1.AnnotationServiceAccountSync] == "true" && sa.DeletionTimestamp.IsZero()
+}
+
+// serviceAccountUsername returns the name of the machine user of the ServiceAccount
+func serviceAccountUsername(sa *corev1.ServiceAccount) string {
+	if name := sa.Annotations[idmv1.AnnotationUsername]; name != "" {
+		return name
+	}
+	return sa.Namespace + "-" + sa.Name
+}
+
+// serviceAccountCredentialsSecretName returns the name of the Secret with the credentials
+// of the machine user of the ServiceAccount
+func serviceAccountCredentialsSecretName(sa *corev1.ServiceAccount) string {
+	return sa.Name + "-idm-credentials"
+}
+
+// instanceRef returns the IdentityInstance named by the instance annotation, or the
+// default instance of the operator configuration
+func (r *ServiceAccountReconciler) instanceRef(sa *corev1.ServiceAccount) *idmv1.IdentityInstanceReference {
+	var ref *idmv1.IdentityInstanceReference
+	if name := sa.Annotations[idmv1.AnnotationInstance]; name != "" {
+		ref = &idmv1.IdentityInstanceReference{Name: name}
+	}
+	return r.Options.Config.instanceRef(ref)
+}
+
+// identityService returns the identity service the machine user of the ServiceAccount is
+// managed in
+func (r *ServiceAccountReconciler) identityService(ctx context.Context, sa *corev1.ServiceAccount) (idmsvc.IdentityAPI, error) {
+	svc, err := identityServiceFor(ctx, r.Client, sa.Namespace, r.instanceRef(sa), r.IdentityService, r.CredentialsSecret)
+	if err != nil {
+		return nil, err
+	}
+	return withDryRun(svc, sa, r.Recorder, r.Options), nil
+}
+
+// writeCredentialsSecret stores the username and password of the machine user in a Secret
+// owned by the ServiceAccount
+func (r *ServiceAccountReconciler) writeCredentialsSecret(ctx context.Context, sa *corev1.ServiceAccount, spec *idmv1.UserSpec) error {
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Namespace: sa.Namespace, Name: serviceAccountCredentialsSecretName(sa)},
+	}
+	_, err := controllerutil.CreateOrUpdate(ctx, r.Client, secret, func() error {
+		secret.Data = map[string][]byte{
+			idmv1.CredentialsSecretUsernameKey: []byte(spec.Name),
+			idmv1.CredentialsSecretPasswordKey: []byte(spec.Password),
+		}
+		return controllerutil.SetControllerReference(sa, secret, r.Scheme)
+	})
+	return err
+}
+
+// SetupWithManager sets up the controller with the Manager. Only ServiceAccounts annotated
+// for sync or still holding the finalizer are reconciled.
+func (r *ServiceAccountReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		Named("serviceaccount").
+		For(&corev1.ServiceAccount{}, builder.WithPredicates(predicate.NewPredicateFuncs(func(obj client.Object) bool {
+			return obj.GetAnnotations()[idmv1.AnnotationServiceAccountSync] == "true" ||
+				containsString(obj.GetFinalizers(), serviceAccountFinalizer)
+		}))).
+		WithOptions(r.Options.controllerOptions()).
+		Complete(withDrain(withCorrelationID(r)))
+}
@@ -0,0 +1,87 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	idmv1 "github.com/m15ch4/go-identity-operator/api/v1"
+	"github.com/m15ch4/go-identity-operator/pkg/identityclient/fake"
+)
+
+var _ = Describe("ServiceAccount controller", func() {
+	var (
+		ctx context.Context
+		svc *fake.IdentityService
+	)
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		svc = fake.NewIdentityService()
+	})
+
+	It("provisions machine users for ServiceAccounts annotated for sync", func() {
+		serviceAccounts := &ServiceAccountReconciler{
+			Client:          k8sClient,
+			Scheme:          k8sClient.Scheme(),
+			Recorder:        record.NewFakeRecorder(100),
+			IdentityService: svc,
+		}
+		sa := &corev1.ServiceAccount{
+			ObjectMeta: metav1.ObjectMeta{
+				GenerateName: "robot-",
+				Namespace:    "default",
+				Annotations:  map[string]string{idmv1.AnnotationServiceAccountSync: "true"},
+			},
+		}
+		Expect(k8sClient.Create(ctx, sa)).To(Succeed())
+		key := types.NamespacedName{Namespace: sa.Namespace, Name: sa.Name}
+		reconcileServiceAccount := func() {
+			_, err := serviceAccounts.Reconcile(ctx, reconcile.Request{NamespacedName: key})
+			Expect(err).NotTo(HaveOccurred())
+		}
+
+		reconcileServiceAccount()
+		Expect(k8sClient.Get(ctx, key, sa)).To(Succeed())
+		id := sa.Annotations[idmv1.AnnotationExternalID]
+		Expect(svc.Users).To(HaveKey(id))
+		Expect(svc.Users[id].Name).To(Equal(sa.Namespace + "-" + sa.Name))
+		secret := &corev1.Secret{}
+		Expect(k8sClient.Get(ctx, types.NamespacedName{Namespace: sa.Namespace, Name: serviceAccountCredentialsSecretName(sa)}, secret)).To(Succeed())
+		Expect(string(secret.Data[idmv1.CredentialsSecretPasswordKey])).To(Equal(svc.Users[id].Password))
+
+		reconcileServiceAccount()
+		Expect(svc.Calls["CreateUser"]).To(Equal(1))
+
+		By("deleting the machine user with the ServiceAccount")
+		Expect(k8sClient.Delete(ctx, sa)).To(Succeed())
+		reconcileServiceAccount()
+		Expect(svc.Users).NotTo(HaveKey(id))
+		err := k8sClient.Get(ctx, key, sa)
+		Expect(errors.IsNotFound(err)).To(BeTrue())
+	})
+})
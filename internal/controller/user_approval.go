@@ -0,0 +1,81 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	idmv1 "github.com/m15ch4/go-identity-operator/api/v1"
+)
+
+// changesHash identifies the changes, so an approval does not carry over to other changes
+func changesHash(changes []idmv1.UserFieldChange) string {
+	data, _ := json.Marshal(changes)
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:8])
+}
+
+// approvalRequired reports whether the changes with the given hash wait for approval
+// under the Manual update policy
+func approvalRequired(user *idmv1.User, hash string) bool {
+	return user.Spec.UpdatePolicy == idmv1.UpdatePolicyManual && user.Annotations[idmv1.AnnotationApproveChanges] != hash
+}
+
+// awaitApproval records the changes in the status instead of updating the external user.
+// The user is compared again at the next resync, as the changes may resolve themselves.
+func (r *UserReconciler) awaitApproval(ctx context.Context, user, original *idmv1.User, changes []idmv1.UserFieldChange, hash string) (ctrl.Result, error) {
+	log := log.FromContext(ctx)
+
+	if user.Status.PendingChangesHash != hash {
+		log.Info("Changes wait for approval", "pendingChangesHash", hash)
+		r.Recorder.Eventf(user, corev1.EventTypeNormal, "ApprovalRequired", "Changes of user %s wait for approval, annotate with %s=%s to apply them", user.Status.ID, idmv1.AnnotationApproveChanges, hash)
+	}
+	user.Status.PendingChanges = changes
+	user.Status.PendingChangesHash = hash
+	user.Status.State = "PendingApproval"
+	r.setCondition(user, idmv1.ConditionPendingApproval, metav1.ConditionTrue, "ChangesPending", "Changes of the external user wait for approval")
+	r.setCondition(user, idmv1.ConditionReady, metav1.ConditionFalse, "PendingApproval", "Changes of the external user wait for approval")
+
+	if !equality.Semantic.DeepEqual(original.Status, user.Status) {
+		err := patchStatus(ctx, r.Client, user, original)
+		if err != nil {
+			log.Info("Failed to update user status")
+			return ctrl.Result{}, err
+		}
+	}
+	return ctrl.Result{RequeueAfter: r.resyncAfter(user)}, nil
+}
+
+// clearPendingChanges records that no changes wait for approval. Users that never waited
+// for approval do not get the condition at all.
+func (r *UserReconciler) clearPendingChanges(user *idmv1.User) {
+	user.Status.PendingChanges = nil
+	user.Status.PendingChangesHash = ""
+	if meta.FindStatusCondition(user.Status.Conditions, idmv1.ConditionPendingApproval) != nil {
+		r.setCondition(user, idmv1.ConditionPendingApproval, metav1.ConditionFalse, "NoChangesPending", "No changes of the external user wait for approval")
+	}
+}
@@ -0,0 +1,106 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/util/workqueue"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	idmv1 "github.com/m15ch4/go-identity-operator/api/v1"
+	idmsvc "github.com/m15ch4/go-identity-operator/pkg/identityclient"
+)
+
+// userBackoff backs off the Users failing with retryable errors by their UID. A permanently
+// broken User waits up to the maximum requeue delay between attempts while the others keep
+// reconciling at normal speed, and a User recreated under the same name starts over.
+type userBackoff struct {
+	limiter workqueue.RateLimiter
+
+	mu sync.Mutex
+	// delays holds the delay of the failed reconciliations until they return
+	delays map[types.NamespacedName]time.Duration
+}
+
+func newUserBackoff(o ControllerOptions) *userBackoff {
+	return &userBackoff{
+		limiter: newRateLimiter(o),
+		delays:  map[types.NamespacedName]time.Duration{},
+	}
+}
+
+// backoffKey identifies the user in the rate limiter, by name as long as it has no UID
+func backoffKey(user *idmv1.User) interface{} {
+	if user.UID != "" {
+		return user.UID
+	}
+	return types.NamespacedName{Namespace: user.Namespace, Name: user.Name}
+}
+
+// failed returns the delay before the next attempt of the user after another failure
+func (b *userBackoff) failed(user *idmv1.User) time.Duration {
+	delay := b.limiter.When(backoffKey(user))
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.delays[types.NamespacedName{Namespace: user.Namespace, Name: user.Name}] = delay
+	return delay
+}
+
+// forget resets the backoff of the user after it synced or went away
+func (b *userBackoff) forget(user *idmv1.User) {
+	b.limiter.Forget(backoffKey(user))
+}
+
+// take returns and clears the delay recorded by the reconciliation of req
+func (b *userBackoff) take(req ctrl.Request) (time.Duration, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	delay, ok := b.delays[req.NamespacedName]
+	delete(b.delays, req.NamespacedName)
+	return delay, ok
+}
+
+// itemBackoff returns the backoff of the Users, created on first use
+func (r *UserReconciler) itemBackoff() *userBackoff {
+	r.backoffOnce.Do(func() {
+		r.backoff = newUserBackoff(r.Options)
+	})
+	return r.backoff
+}
+
+// Reconcile reconciles the User and requeues it after its own backoff when it failed with
+// a retryable error, instead of handing the error to the rate limiter of the workqueue
+func (r *UserReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	result, err := r.reconcile(ctx, req)
+	if delay, ok := r.itemBackoff().take(req); ok && err != nil {
+		log.FromContext(ctx).Error(err, "Reconciliation failed, backing off", "after", delay)
+		return ctrl.Result{RequeueAfter: delay}, nil
+	}
+	return result, err
+}
+
+// retriedWithBackoff reports whether requeueFor retries err with exponential backoff
+func retriedWithBackoff(err error) bool {
+	return !waitsForInstance(err) && !isBackendUnavailable(err) && !idmsvc.IsCredentialsInvalid(err) &&
+		!isDryRun(err) && idmsvc.RetryAfter(err) <= 0 && !isTerminal(err)
+}
@@ -0,0 +1,141 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	idmv1 "github.com/m15ch4/go-identity-operator/api/v1"
+	idmsvc "github.com/m15ch4/go-identity-operator/pkg/identityclient"
+)
+
+// UserBatcher coalesces the user creations of concurrent reconciles into bulk requests, so
+// applying hundreds of Users at once results in a few requests to the identity system.
+// Backends without bulk support get the users created one by one.
+type UserBatcher struct {
+	// Window is how long the first creation of a batch waits for others to join it
+	Window time.Duration
+	// MaxSize sends a batch before its window passed once it holds that many users
+	MaxSize int
+
+	mu      sync.Mutex
+	pending map[idmsvc.IdentityAPI]*userBatch
+	// unsupported remembers the backends that rejected bulk requests
+	unsupported map[idmsvc.IdentityAPI]bool
+}
+
+// userBatch collects the users created in the same backend during a window
+type userBatch struct {
+	specs []*idmv1.UserSpec
+	// keys are the idempotency keys of the creations, passed to the bulk request and to the
+	// creations one by one alike
+	keys    []string
+	results []idmsvc.BulkResult
+	sent    bool
+	done    chan struct{}
+}
+
+// CreateUser creates the user as part of the batch of its backend and waits for the result.
+// A nil batcher, a zero window and dry-run services create the user right away.
+func (b *UserBatcher) CreateUser(ctx context.Context, svc idmsvc.IdentityAPI, spec *idmv1.UserSpec) (*idmsvc.IdentityUser, error) {
+	if _, ok := svc.(*dryRunService); ok || b == nil || b.Window <= 0 {
+		return svc.CreateUser(ctx, spec)
+	}
+
+	b.mu.Lock()
+	if b.unsupported[svc] {
+		b.mu.Unlock()
+		return svc.CreateUser(ctx, spec)
+	}
+	if b.pending == nil {
+		b.pending = map[idmsvc.IdentityAPI]*userBatch{}
+		b.unsupported = map[idmsvc.IdentityAPI]bool{}
+	}
+	batch, ok := b.pending[svc]
+	if !ok {
+		batch = &userBatch{done: make(chan struct{})}
+		b.pending[svc] = batch
+		time.AfterFunc(b.Window, func() { b.send(svc, batch) })
+	}
+	i := len(batch.specs)
+	batch.specs = append(batch.specs, spec)
+	batch.keys = append(batch.keys, idmsvc.IdempotencyKeyFrom(ctx))
+	full := b.MaxSize > 0 && len(batch.specs) >= b.MaxSize
+	b.mu.Unlock()
+
+	if full {
+		go b.send(svc, batch)
+	}
+
+	// a cancelled reconcile stops waiting; the next one finds the user created anyway by
+	// its idempotency key
+	select {
+	case <-batch.done:
+		return batch.results[i].User, batch.results[i].Err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// send creates the users of the batch once, whichever of the window and the size limit is
+// reached first. The request is not bound to the context of any of the waiting reconciles.
+func (b *UserBatcher) send(svc idmsvc.IdentityAPI, batch *userBatch) {
+	b.mu.Lock()
+	if batch.sent {
+		b.mu.Unlock()
+		return
+	}
+	batch.sent = true
+	if b.pending[svc] == batch {
+		delete(b.pending, svc)
+	}
+	b.mu.Unlock()
+
+	ctx := context.Background()
+	defer close(batch.done)
+
+	if len(batch.specs) > 1 {
+		results, err := svc.CreateUsers(idmsvc.WithIdempotencyKeys(ctx, batch.keys), batch.specs)
+		if err == nil && len(results) != len(batch.specs) {
+			err = fmt.Errorf("bulk request returned %d results for %d users", len(results), len(batch.specs))
+		}
+		if err == nil {
+			batch.results = results
+			return
+		}
+		if !errors.Is(err, idmsvc.ErrNotSupported) {
+			batch.results = make([]idmsvc.BulkResult, len(batch.specs))
+			for i := range batch.results {
+				batch.results[i].Err = err
+			}
+			return
+		}
+		b.mu.Lock()
+		b.unsupported[svc] = true
+		b.mu.Unlock()
+	}
+
+	batch.results = make([]idmsvc.BulkResult, len(batch.specs))
+	for i, spec := range batch.specs {
+		usr, err := svc.CreateUser(idmsvc.WithIdempotencyKey(ctx, batch.keys[i]), spec)
+		batch.results[i] = idmsvc.BulkResult{User: usr, Err: err}
+	}
+}
@@ -0,0 +1,99 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	idmv1 "github.com/m15ch4/go-identity-operator/api/v1"
+)
+
+// adoptionRequested reports whether the user takes over an existing external user of the
+// same name instead of creating one
+func adoptionRequested(user *idmv1.User) bool {
+	return user.Spec.AdoptExisting || user.Annotations[idmv1.AnnotationAdopt] == "true"
+}
+
+// resolvesConflict reports whether adoption was requested for a user whose name is taken,
+// which lifts the stall without a spec change
+func resolvesConflict(user *idmv1.User) bool {
+	return adoptionRequested(user) && meta.IsStatusConditionTrue(user.Status.Conditions, idmv1.ConditionConflict)
+}
+
+// nameConflict records that the identity system rejected the creation of the user because
+// its name is taken. The Conflict condition names the external user holding the name and
+// the user is stalled until its spec changes or adoption is requested.
+func (r *UserReconciler) nameConflict(ctx context.Context, user, original *idmv1.User, cause error) (ctrl.Result, error) {
+	log := log.FromContext(ctx)
+
+	message := fmt.Sprintf("User name %s is taken in the identity system", user.Spec.Name)
+	extUser, err := r.findUser(ctx, user)
+	if err != nil {
+		log.Error(err, "Failed to look up the external user holding the name")
+	} else if extUser != nil {
+		message = fmt.Sprintf("User name %s is taken by external user %s", user.Spec.Name, extUser.ID)
+	}
+
+	// warn once instead of on every reconcile until the conflict is resolved
+	if !meta.IsStatusConditionTrue(user.Status.Conditions, idmv1.ConditionConflict) {
+		r.Recorder.Eventf(user, corev1.EventTypeWarning, "NameConflict", "%s, change spec.name or set the %s annotation to adopt it", message, idmv1.AnnotationAdopt)
+	}
+	log.Info("User name is taken, waiting for a spec change or adoption", "name", user.Spec.Name)
+	r.setCondition(user, idmv1.ConditionConflict, metav1.ConditionTrue, "NameTaken", message)
+	r.setDegraded(ctx, user, original, "CreateFailed", cause)
+	return ctrl.Result{}, nil
+}
+
+// externalUserMissing handles an external user that was deleted out of band according to
+// the recreate policy of the user. With Recreate its ID is cleared, so the user is created
+// again right away; with Report the Missing condition is set and the user is left alone
+// until the external user is restored or the policy is changed.
+func (r *UserReconciler) externalUserMissing(ctx context.Context, user, original *idmv1.User, cause error) (ctrl.Result, error) {
+	log := log.FromContext(ctx)
+
+	id := user.Status.ID
+	if user.Spec.RecreatePolicy == idmv1.RecreatePolicyReport {
+		message := fmt.Sprintf("External user %s was deleted from the identity system", id)
+		// warn once instead of on every reconcile until the user is restored
+		if !meta.IsStatusConditionTrue(user.Status.Conditions, idmv1.ConditionMissing) {
+			r.Recorder.Event(user, corev1.EventTypeWarning, "UserMissing", message)
+		}
+		log.Info("External user is missing, waiting for its restore", "id", id)
+		r.setCondition(user, idmv1.ConditionMissing, metav1.ConditionTrue, "Deleted", message)
+		r.setDegraded(ctx, user, original, "UserMissing", cause)
+		return ctrl.Result{}, nil
+	}
+
+	log.Info("External user is missing, recreating it", "id", id)
+	r.Recorder.Eventf(user, corev1.EventTypeWarning, "UserMissing", "External user %s was deleted from the identity system, recreating it", id)
+	r.setCondition(user, idmv1.ConditionMissing, metav1.ConditionTrue, "Recreating", fmt.Sprintf("External user %s was deleted from the identity system", id))
+	user.Status.State = "Missing"
+	user.Status.ID = ""
+	user.Status.SyncedSpecHash = ""
+	user.Status.Recreations++
+	if err := patchStatus(ctx, r.Client, user, original); err != nil {
+		return ctrl.Result{}, err
+	}
+	return ctrl.Result{Requeue: true}, nil
+}
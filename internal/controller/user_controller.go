@@ -18,39 +18,117 @@ package controller
 
 import (
 	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
 
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
 	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
 	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
 
 	idmv1 "github.com/m15ch4/go-identity-operator/api/v1"
-	idmsvc "github.com/m15ch4/go-identity-operator/internal/service"
+	"github.com/m15ch4/go-identity-operator/internal/encryption"
+	"github.com/m15ch4/go-identity-operator/internal/notify"
+	"github.com/m15ch4/go-identity-operator/internal/status"
+	idmsvc "github.com/m15ch4/go-identity-operator/pkg/identityclient"
 )
 
 const userFinalizer = "micze.io/user-finalizer"
 
+// userInstanceRefIndex indexes Users by the name of the IdentityInstance they reference
+const userInstanceRefIndex = "spec.instanceRef.name"
+
 // UserReconciler reconciles a User object
 type UserReconciler struct {
 	client.Client
 	Scheme *runtime.Scheme
+
+	// Recorder emits Events on Users so that reconciliation outcomes show up in kubectl describe
+	Recorder record.EventRecorder
+
+	// IdentityService is the long-lived service used for Users without an instanceRef,
+	// so the login token is cached across reconciles
+	IdentityService idmsvc.IdentityAPI
+
+	// Options tunes the workers and the rate limiter of the controller
+	Options ControllerOptions
+
+	// DriftResyncPeriod is the interval after which a synced User is compared with the
+	// external user again. Zero disables periodic resync.
+	DriftResyncPeriod time.Duration
+
+	// DriftIgnoredAttributes are the custom attributes of external users that are not
+	// compared with spec.attributes, their values in the identity system are kept
+	DriftIgnoredAttributes []string
+
+	// CredentialsSecret optionally references a Secret with IDM_USER and IDM_PASS keys
+	// used to log in to the identity system. It takes precedence over the environment.
+	CredentialsSecret types.NamespacedName
+
+	// ForceFinalizeAfter is the time after which the finalizer of a deleted User is removed
+	// even though deleting the external user keeps failing. Zero waits forever.
+	ForceFinalizeAfter time.Duration
+
+	// Notifier optionally informs downstream systems about created, updated and deleted users
+	Notifier notify.Notifier
+
+	// ChangeFeed optionally receives change notifications of the identity system, the
+	// Users of changed external users are synced right away
+	ChangeFeed *ChangeFeed
+
+	// Encryption decrypts the passwords encrypted with idmctl encrypt. Encrypted
+	// passwords cannot be used when nil.
+	Encryption *encryption.Envelope
+
+	// Batcher optionally coalesces the creations of concurrently reconciled Users into
+	// bulk requests. Users are created one by one when nil.
+	Batcher *UserBatcher
+
+	// ClusterUserNamespace is the namespace of the Secrets and Roles referenced by
+	// cluster-scoped Users and of the Secrets created for them
+	ClusterUserNamespace string
+
+	// priorities orders the Users waiting to be reconciled, set up with the manager
+	priorities *priorityQueue
+
+	// backoff delays the next attempt of the Users failing with retryable errors
+	backoff     *userBackoff
+	backoffOnce sync.Once
 }
 
 //+kubebuilder:rbac:groups=idm.micze.io,resources=users,verbs=get;list;watch;create;update;patch;delete
 //+kubebuilder:rbac:groups=idm.micze.io,resources=users/status,verbs=get;update;patch
 //+kubebuilder:rbac:groups=idm.micze.io,resources=users/finalizers,verbs=update
+//+kubebuilder:rbac:groups=idm.micze.io,resources=identityinstances,verbs=get;list;watch
+//+kubebuilder:rbac:groups=idm.micze.io,resources=roles,verbs=get;list;watch
+//+kubebuilder:rbac:groups=idm.micze.io,resources=passwordpolicies,verbs=get;list;watch
+//+kubebuilder:rbac:groups=idm.micze.io,resources=userrolebindings,verbs=get;list;watch;delete
+//+kubebuilder:rbac:groups=core,resources=secrets,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=core,resources=events,verbs=create;patch
 
-// Reconcile is part of the main kubernetes reconciliation loop which aims to
+// reconcile is part of the main kubernetes reconciliation loop which aims to
 // move the current state of the cluster closer to the desired state.
-// TODO(user): Modify the Reconcile function to compare the state specified by
-// the User object against the actual cluster state, and then
-// perform operations to make the cluster state reflect the state specified by
-// the user.
 //
 // For more details, check Reconcile and its Result here:
 // - https://pkg.go.dev/sigs.k8s.io/controller-runtime@v0.16.3/pkg/reconcile
-func (r *UserReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+func (r *UserReconciler) reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
 	log := log.FromContext(ctx)
 
 	log.Info("Start reconciliation")
@@ -69,6 +147,29 @@ func (r *UserReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.
 		log.Error(err, "Failed to get User")
 		return ctrl.Result{}, nil
 	}
+	original := user.DeepCopy()
+
+	// Tag all further log messages with the ID of the user in the identity system
+	if user.Status.ID != "" {
+		log = log.WithValues("externalID", user.Status.ID)
+		ctx = logr.NewContext(ctx, log)
+	}
+
+	// Leave the identity system alone while paused, deletion waits for the resume
+	isPaused := paused(user, user.Spec.Paused)
+	setPaused(&user.Status.Conditions, user.Generation, isPaused)
+	if isPaused {
+		log.Info("Reconciliation is paused")
+		user.Status.State = "Paused"
+		if !equality.Semantic.DeepEqual(original.Status, user.Status) {
+			err = patchStatus(ctx, r.Client, user, original)
+			if err != nil {
+				log.Info("Failed to update user status")
+				return ctrl.Result{}, err
+			}
+		}
+		return ctrl.Result{}, nil
+	}
 
 	// Check if the instance is marked to be deleted, which is
 	// indicated by the deletion timestamp being set.
@@ -76,58 +177,268 @@ func (r *UserReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.
 		// If finalizer is present, run finalization logic
 		// then remove the finalizer from the list and update the object
 		if containsString(user.GetFinalizers(), userFinalizer) {
-			err := r.finalizeUser(ctx, user)
+			if !meta.IsStatusConditionTrue(user.Status.Conditions, idmv1.ConditionDeleting) {
+				user.Status.State = "Deleting"
+				r.setCondition(user, idmv1.ConditionDeleting, metav1.ConditionTrue, "Finalizing", "Deleting user from identity system")
+				r.setCondition(user, idmv1.ConditionReady, metav1.ConditionFalse, "Finalizing", "User is being deleted")
+				err := patchStatus(ctx, r.Client, user, original)
+				if err != nil {
+					log.Info("Failed to update user status")
+					return ctrl.Result{}, err
+				}
+			}
+
+			if user.Spec.DeletionPolicy == idmv1.DeletionPolicyOrphan {
+				log.Info("Orphaning user in identity system", "id", user.Status.ID)
+			} else {
+				err := r.finalizeUser(ctx, user)
+				if err != nil {
+					remaining := r.forceFinalizeIn(user)
+					forceFinalizeAfter := r.Options.Config.forceFinalizeAfter(r.ForceFinalizeAfter)
+					if remaining > 0 || forceFinalizeAfter <= 0 {
+						r.setDegraded(ctx, user, original, "FinalizeFailed", err)
+						result, err := requeueFor(ctx, err)
+						// errors that are not retried are given another chance once forcing is due
+						if err == nil && result.IsZero() && remaining > 0 {
+							result.RequeueAfter = remaining
+						}
+						return result, err
+					}
+					log.Error(err, "Removing finalizer without deleting the user from identity system", "forceFinalizeAfter", forceFinalizeAfter)
+					r.Recorder.Eventf(user, corev1.EventTypeWarning, "FinalizerForced", "Removed finalizer after failing to delete user %s from identity system for %s: %v", user.Status.ID, forceFinalizeAfter, err)
+				} else {
+					r.notify(notify.UserDeleted, user, nil)
+				}
+			}
+
+			// the credentials Secret and the bindings of the user go with it
+			err = r.deleteDependents(ctx, user)
 			if err != nil {
 				return ctrl.Result{}, err
 			}
 
-			user.SetFinalizers(removeString(user.GetFinalizers(), userFinalizer))
-			err = r.Update(ctx, user)
+			err = patchWithRetry(ctx, r.Client, user, func() {
+				controllerutil.RemoveFinalizer(user, userFinalizer)
+			})
 			if err != nil {
 				return ctrl.Result{}, err
 			}
+			r.itemBackoff().forget(user)
+		}
+		return ctrl.Result{}, nil
+	}
+
+	// Expired users are disabled or deleted instead of being synced
+	if expired(user) {
+		return r.expireUser(ctx, user, original)
+	}
+
+	// Terminal errors are not retried until the spec changes
+	if stalled := status.Stalled(user); stalled != nil && !resolvesConflict(user) {
+		log.Info("User is stalled, waiting for a spec change", "reason", stalled.Reason)
+		return ctrl.Result{}, nil
+	}
+
+	// Skip reading the external user while nothing changed since the last sync, neither the
+	// spec nor the external user according to the change feed
+	var hash string
+	if user.Status.ID != "" {
+		hash, err = r.specHash(ctx, user)
+		if err != nil {
+			r.setDegraded(ctx, user, original, "RoleNotReady", err)
+			return requeueFor(ctx, err)
+		}
+		changed := r.ChangeFeed.take(req.NamespacedName)
+		if delay, ok := r.skipSync(user, hash); ok && !changed {
+			log.Info("User is up to date, skipping sync", "resyncAfter", delay)
+			return ctrl.Result{RequeueAfter: delay}, nil
+		}
+	}
+
+	// If ID field is not set and the external ID is known, bind to that external user
+	if id := user.Annotations[idmv1.AnnotationExternalID]; user.Status.ID == "" && id != "" {
+		user.Status.ID = id
+		_, err := r.getUser(ctx, user)
+		if err != nil {
+			user.Status.ID = ""
+			r.setDegraded(ctx, user, original, "BindFailed", err)
+			return requeueFor(ctx, err)
+		}
+
+		log.Info("Binding to external user", "id", id)
+		user.Status.State = "Bound"
+		r.setSynced(user, "Bound", "User bound to the external user given by the external-id annotation")
+		err = patchStatus(ctx, r.Client, user, original)
+		if err != nil {
+			log.Info("Failed to update user status")
+			return ctrl.Result{}, err
 		}
+
+		// the next reconcile corrects any drift of the bound user
+		log.Info("User bound")
+		r.Recorder.Eventf(user, corev1.EventTypeNormal, "UserBound", "Bound to existing user %s in identity system", id)
 		return ctrl.Result{}, nil
 	}
 
+	// If ID field is not set and adoption is requested, take over an existing external user
+	if user.Status.ID == "" && adoptionRequested(user) {
+		extUser, err := r.findUser(ctx, user)
+		if err != nil {
+			r.setDegraded(ctx, user, original, "AdoptFailed", err)
+			return requeueFor(ctx, err)
+		}
+
+		if extUser != nil {
+			log.Info("Adopting existing user", "id", extUser.ID)
+			user.Status.State = "Adopted"
+			user.Status.ID = extUser.ID
+			r.setSynced(user, "Adopted", "Existing user adopted from identity system")
+			err = patchStatus(ctx, r.Client, user, original)
+			if err != nil {
+				log.Info("Failed to update user status")
+				return ctrl.Result{}, err
+			}
+
+			// the next reconcile corrects any drift of the adopted user
+			log.Info("User adopted")
+			return ctrl.Result{}, nil
+		}
+	}
+
 	// If ID field is not set, create a new user
 	if user.Status.ID == "" {
 		log.Info("Creating user")
 		extUser, err := r.createUser(ctx, user)
+		if idmsvc.IsConflict(err) {
+			return r.nameConflict(ctx, user, original, err)
+		}
 		if err != nil {
-			return ctrl.Result{}, err
+			r.setDegraded(ctx, user, original, "CreateFailed", err)
+			return requeueFor(ctx, err)
 		}
 
-		// Update the user status with the ID and State
+		// Update the user status with the ID, State and conditions
 		user.Status.State = "Created"
+		if !user.Spec.IsEnabled() {
+			user.Status.State = "Suspended"
+		}
 		user.Status.ID = extUser.ID
-		err = r.Status().Update(ctx, user)
+		r.setSynced(user, "Created", "User created in identity system")
+
+		// a failure to store the credentials must not lose the ID, the next reconcile retries
+		err = r.syncCredentialsSecret(ctx, user)
+		if err != nil {
+			log.Error(err, "Failed to write credentials secret")
+			r.Recorder.Eventf(user, corev1.EventTypeWarning, "CredentialsSecretFailed", "Failed to write secret %s: %v", credentialsSecretName(user), err)
+		}
+
+		err = patchStatus(ctx, r.Client, user, original)
 		if err != nil {
 			log.Info("Failed to update user status")
 			return ctrl.Result{}, err
 		}
 
 		log.Info("User created")
-		return ctrl.Result{}, nil
+		r.Recorder.Eventf(user, corev1.EventTypeNormal, "UserCreated", "Created user %s in identity system", extUser.ID)
+		r.notify(notify.UserCreated, user, nil)
+		return ctrl.Result{RequeueAfter: r.resyncAfter(user)}, nil
 	} else {
 		//Get the external user
-		extUser, err := r.getUser(ctx, user.Status.ID)
+		extUser, err := r.getUser(ctx, user)
+		if idmsvc.IsNotFound(err) {
+			return r.externalUserMissing(ctx, user, original, err)
+		}
 		if err != nil {
-			return ctrl.Result{}, err
+			r.setDegraded(ctx, user, original, "GetFailed", err)
+			return requeueFor(ctx, err)
+		}
+
+		// resolve the role, which may be given by a reference to a managed Role
+		role, err := r.desiredRole(ctx, user)
+		if err != nil {
+			r.setDegraded(ctx, user, original, "RoleNotReady", err)
+			return requeueFor(ctx, err)
 		}
 
 		// compare fields of the external user with the spec fields of user in the cluster (do not compare the status fields)
-		if extUser.Name != user.Spec.Name || extUser.Firstname != user.Spec.Firstname || extUser.Lastname != user.Spec.Lastname || extUser.Role != user.Spec.Role || extUser.Age != user.Spec.Age {
-			log.Info("Updating user")
-			_, err = r.updateUser(ctx, user, extUser)
+		desired := user.Spec.DeepCopy()
+		desired.Role = role
+		keepIgnoredAttributes(desired, extUser, r.Options.Config.driftIgnoredAttributes(r.DriftIgnoredAttributes))
+		if drifted := userDrift(desired, extUser); len(drifted) > 0 {
+			// under the Manual update policy the changes are applied once approved
+			changes := fieldChanges(desired, extUser, drifted)
+			if hash := changesHash(changes); approvalRequired(user, hash) {
+				return r.awaitApproval(ctx, user, original, changes, hash)
+			}
+
+			log.Info("Updating user", "driftedFields", drifted)
+			r.Recorder.Eventf(user, corev1.EventTypeNormal, "DriftDetected", "Fields %s of user %s drifted in identity system", strings.Join(drifted, ", "), user.Status.ID)
+			_, err = r.updateUser(ctx, user, extUser, drifted)
+			if err != nil {
+				r.setDegraded(ctx, user, original, "UpdateFailed", err)
+				return requeueFor(ctx, err)
+			}
+			user.Status.State = "Updated"
+			r.Recorder.Eventf(user, corev1.EventTypeNormal, "UserUpdated", "Updated user %s in identity system", user.Status.ID)
+			r.notify(notify.UserUpdated, user, drifted)
+			r.setSynced(user, "Updated", "User updated in identity system")
+		} else {
+			user.Status.State = "Synced"
+			r.setSynced(user, "UpToDate", "User matches the identity system")
+		}
+		r.clearPendingChanges(user)
+		// a disabled user is kept in the identity system but cannot log in
+		if !user.Spec.IsEnabled() {
+			user.Status.State = "Suspended"
+		}
+
+		if user.Status.CredentialsSecret == "" {
+			err = r.syncCredentialsSecret(ctx, user)
+			if err != nil {
+				r.setDegraded(ctx, user, original, "CredentialsSecretFailed", err)
+				return requeueFor(ctx, err)
+			}
+		}
+
+		// Replace the password once the rotation interval has passed
+		if rotationDue(user) {
+			err = r.rotatePassword(ctx, user)
+			if err != nil {
+				r.setDegraded(ctx, user, original, "PasswordRotationFailed", err)
+				return requeueFor(ctx, err)
+			}
+		}
+		user.Status.SyncedSpecHash = hash
+
+		if !equality.Semantic.DeepEqual(original.Status, user.Status) {
+			err = patchStatus(ctx, r.Client, user, original)
+			if err != nil {
+				log.Info("Failed to update user status")
+				return ctrl.Result{}, err
+			}
+		}
+
+		// the approval is used up once nothing waits for it
+		if _, ok := user.Annotations[idmv1.AnnotationApproveChanges]; ok {
+			err = patchWithRetry(ctx, r.Client, user, func() {
+				delete(user.Annotations, idmv1.AnnotationApproveChanges)
+			})
 			if err != nil {
 				return ctrl.Result{}, err
 			}
 		}
 	}
 
-	// Add finalizer for this CR
-	if !containsString(user.GetFinalizers(), userFinalizer) {
+	// Add finalizer for this CR, unless the external user is retained on deletion
+	if user.Spec.DeletionPolicy == idmv1.DeletionPolicyRetain {
+		if containsString(user.GetFinalizers(), userFinalizer) {
+			if err := patchWithRetry(ctx, r.Client, user, func() {
+				controllerutil.RemoveFinalizer(user, userFinalizer)
+			}); err != nil {
+				return ctrl.Result{}, err
+			}
+		}
+	} else if !containsString(user.GetFinalizers(), userFinalizer) {
 		if err := r.addFinalizer(ctx, user); err != nil {
 			return ctrl.Result{}, err
 		}
@@ -135,42 +446,247 @@ func (r *UserReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.
 
 	log.Info("Reconciliation finished")
 
-	return ctrl.Result{}, nil
+	// Re-check the external user periodically to correct out-of-band changes
+	// and to rotate the password in time
+	return ctrl.Result{RequeueAfter: r.resyncAfter(user)}, nil
+}
+
+// setCondition sets the given condition on the user status, observed at the current generation
+func (r *UserReconciler) setCondition(user *idmv1.User, conditionType string, conditionStatus metav1.ConditionStatus, reason, message string) {
+	status.SetCondition(user, conditionType, conditionStatus, reason, message)
+}
+
+// setSynced marks the user as ready and in sync with the identity system
+func (r *UserReconciler) setSynced(user *idmv1.User, reason, message string) {
+	status.MarkSynced(user, reason, message)
+	user.Status.LastAttemptTime = user.Status.LastSyncTime
+	user.Status.LastError = ""
+	user.Status.RetryCount = 0
+	user.Status.Backoff, user.Status.NextAttemptTime = nil, nil
+	r.itemBackoff().forget(user)
+	user.Status.ExternalName = user.Spec.Name
+
+	if meta.FindStatusCondition(user.Status.Conditions, idmv1.ConditionCredentialsInvalid) != nil {
+		r.setCondition(user, idmv1.ConditionCredentialsInvalid, metav1.ConditionFalse, "CredentialsAccepted", "Identity system accepted the credentials")
+	}
+	if meta.FindStatusCondition(user.Status.Conditions, idmv1.ConditionExpired) != nil {
+		r.setCondition(user, idmv1.ConditionExpired, metav1.ConditionFalse, "NotExpired", "Expiration time of the user has not passed")
+	}
+	if meta.FindStatusCondition(user.Status.Conditions, idmv1.ConditionConflict) != nil {
+		r.setCondition(user, idmv1.ConditionConflict, metav1.ConditionFalse, "NoConflict", "User name is not taken by another external user")
+	}
+	if meta.FindStatusCondition(user.Status.Conditions, idmv1.ConditionMissing) != nil {
+		r.setCondition(user, idmv1.ConditionMissing, metav1.ConditionFalse, "Found", "External user exists in the identity system")
+	}
+}
+
+// setDegraded records the failure on the user status; errors updating the status are only logged
+// so that the original error is returned to the caller
+func (r *UserReconciler) setDegraded(ctx context.Context, user, original *idmv1.User, reason string, cause error) {
+	log := log.FromContext(ctx)
+
+	credentialsInvalid := idmsvc.IsCredentialsInvalid(cause)
+	switch {
+	case reason == "FinalizeFailed":
+		r.Recorder.Event(user, corev1.EventTypeWarning, "FinalizeFailed", cause.Error())
+	case credentialsInvalid:
+		// warn once instead of on every retry until the credentials are fixed
+		if !meta.IsStatusConditionTrue(user.Status.Conditions, idmv1.ConditionCredentialsInvalid) {
+			r.Recorder.Event(user, corev1.EventTypeWarning, "CredentialsInvalid", cause.Error())
+		}
+	default:
+		r.Recorder.Event(user, corev1.EventTypeWarning, "ExternalAPIError", cause.Error())
+	}
+
+	reason = failureReason(cause, reason)
+	reconcileErrorsTotal.WithLabelValues(reason).Inc()
+	now := metav1.Now()
+	user.Status.State = "Degraded"
+	user.Status.LastError = cause.Error()
+	user.Status.RetryCount++
+	user.Status.LastAttemptTime = &now
+	user.Status.Backoff, user.Status.NextAttemptTime = nil, nil
+	if retriedWithBackoff(cause) {
+		delay := r.itemBackoff().failed(user)
+		next := metav1.NewTime(now.Add(delay))
+		user.Status.Backoff = &metav1.Duration{Duration: delay}
+		user.Status.NextAttemptTime = &next
+	}
+	r.setCondition(user, idmv1.ConditionDegraded, metav1.ConditionTrue, reason, cause.Error())
+	r.setCondition(user, idmv1.ConditionSynced, metav1.ConditionFalse, reason, cause.Error())
+	if !meta.IsStatusConditionTrue(user.Status.Conditions, idmv1.ConditionDeleting) {
+		r.setCondition(user, idmv1.ConditionReady, metav1.ConditionFalse, reason, cause.Error())
+	}
+	if isTerminal(cause) {
+		r.setCondition(user, idmv1.ConditionStalled, metav1.ConditionTrue, reason, cause.Error())
+	}
+	if credentialsInvalid {
+		r.setCondition(user, idmv1.ConditionCredentialsInvalid, metav1.ConditionTrue, reason, cause.Error())
+	}
+
+	if err := patchStatus(ctx, r.Client, user, original); err != nil {
+		log.Error(err, "Failed to update user status")
+	}
+}
+
+// requeueFor decides how a failed external operation is retried: rate limited requests
+// are requeued after the delay requested by the identity system, terminal errors are
+// not retried and all other errors are retried with exponential backoff
+func requeueFor(ctx context.Context, err error) (ctrl.Result, error) {
+	log := log.FromContext(ctx)
+
+	if waitsForInstance(err) {
+		log.Info("Waiting for the IdentityInstance to become ready", "error", err.Error())
+		return ctrl.Result{}, nil
+	}
+
+	if isBackendUnavailable(err) {
+		log.Info("Waiting for the identity system to become available", "error", err.Error())
+		return ctrl.Result{RequeueAfter: backendUnavailableRequeue}, nil
+	}
+
+	if idmsvc.IsCredentialsInvalid(err) {
+		log.Info("Waiting for the credentials of the identity system to be fixed", "error", err.Error())
+		return ctrl.Result{RequeueAfter: credentialsInvalidRequeue}, nil
+	}
+
+	if isDryRun(err) {
+		log.Info("Skipped change of the identity system", "reason", err.Error())
+		return ctrl.Result{}, nil
+	}
+
+	if delay := idmsvc.RetryAfter(err); delay > 0 {
+		log.Info("Identity system asked to retry later", "after", delay)
+		return ctrl.Result{RequeueAfter: delay}, nil
+	}
+
+	if isTerminal(err) {
+		log.Error(err, "Terminal error, not retrying until the spec changes")
+		return ctrl.Result{}, nil
+	}
+
+	return ctrl.Result{}, err
+}
+
+// failureReason maps errors returned by the identity service to a condition reason
+func failureReason(err error, fallback string) string {
+	switch {
+	case isBackendUnavailable(err):
+		return "BackendUnavailable"
+	case waitsForInstance(err):
+		return "InstanceNotReady"
+	case isDryRun(err):
+		return "DryRun"
+	case isPasswordPolicyViolation(err):
+		return "PasswordPolicyViolation"
+	case idmsvc.IsCredentialsInvalid(err):
+		return "CredentialsInvalid"
+	case idmsvc.IsUnauthorized(err):
+		return "Unauthorized"
+	case idmsvc.IsNotFound(err):
+		return "NotFound"
+	case idmsvc.IsConflict(err):
+		return "Conflict"
+	case idmsvc.IsRetryable(err):
+		return "BackendUnavailable"
+	case idmsvc.IsCircuitOpen(err):
+		return "CircuitOpen"
+	}
+	return fallback
+}
+
+// notify sends a lifecycle event of the user to the Notifier, if any
+func (r *UserReconciler) notify(eventType notify.EventType, user *idmv1.User, fields []string) {
+	if r.Notifier == nil {
+		return
+	}
+	r.Notifier.Notify(notify.Event{
+		Type:      eventType,
+		Time:      time.Now().UTC(),
+		Namespace: user.Namespace,
+		Name:      user.Name,
+		ID:        user.Status.ID,
+		Username:  user.Spec.Name,
+		Fields:    fields,
+	})
 }
 
 // finalizeUser removes object from external system
 func (r *UserReconciler) finalizeUser(ctx context.Context, user *idmv1.User) error {
 	_ = log.FromContext(ctx)
 
-	cfg := idmsvc.NewIdentityConfig()
-	svc := idmsvc.NewIdentityService(&cfg)
-
-	_, err := svc.GetToken()
+	svc, err := r.identityService(ctx, user)
 	if err != nil {
 		return err
 	}
 
-	err = svc.DeleteUser(user.Status.ID)
-	if err != nil {
+	// the user was never created or was already deleted out of band
+	if user.Status.ID == "" {
+		return nil
+	}
+	err = svc.DeleteUser(ctx, user.Status.ID)
+	if err != nil && !idmsvc.IsNotFound(err) {
 		return err
 	}
 
 	return nil
 }
 
+// forceFinalizeIn returns the time left until the finalizer of the deleted user is removed
+// regardless of failures, counted from when the user started being deleted
+func (r *UserReconciler) forceFinalizeIn(user *idmv1.User) time.Duration {
+	forceFinalizeAfter := r.Options.Config.forceFinalizeAfter(r.ForceFinalizeAfter)
+	if forceFinalizeAfter <= 0 {
+		return 0
+	}
+	deleting := meta.FindStatusCondition(user.Status.Conditions, idmv1.ConditionDeleting)
+	if deleting == nil {
+		return forceFinalizeAfter
+	}
+	return forceFinalizeAfter - time.Since(deleting.LastTransitionTime.Time)
+}
+
 // createUser creates a new user in external system
 func (r *UserReconciler) createUser(ctx context.Context, user *idmv1.User) (*idmsvc.IdentityUser, error) {
 	_ = log.FromContext(ctx)
 
-	cfg := idmsvc.NewIdentityConfig()
-	svc := idmsvc.NewIdentityService(&cfg)
+	spec, err := r.resolveSpec(ctx, user)
+	if err != nil {
+		return nil, err
+	}
+	if spec.Password == "" {
+		return nil, fmt.Errorf("password or passwordSecretRef must be set to create the user")
+	}
 
-	_, err := svc.GetToken()
+	policy, err := passwordPolicyFor(ctx, r.Client, r.Options.Config.instanceRef(user.Spec.InstanceRef))
 	if err != nil {
 		return nil, err
 	}
+	err = checkPasswordPolicy(policy, spec.Password)
+	if err != nil {
+		return nil, err
+	}
+
+	svc, err := r.identityService(ctx, user)
+	if err != nil {
+		return nil, err
+	}
+
+	// a previous attempt may have created the user without its ID being recorded, e.g. when
+	// the response timed out, so the user created with the same key is picked up instead
+	key := idempotencyKey(user)
+	if key != "" {
+		ctx = idmsvc.WithIdempotencyKey(ctx, key)
+		existing, err := svc.FindUserByIdempotencyKey(ctx, key)
+		if err != nil && !idmsvc.IsNotSupported(err) {
+			return nil, err
+		}
+		if existing != nil {
+			return existing, nil
+		}
+	}
 
-	usr, err := svc.CreateUser(&user.Spec)
+	usr, err := r.Batcher.CreateUser(ctx, svc, spec)
 	if err != nil {
 		return nil, err
 	}
@@ -178,19 +694,46 @@ func (r *UserReconciler) createUser(ctx context.Context, user *idmv1.User) (*idm
 	return usr, nil
 }
 
+// idempotencyKey returns the idempotency key of the creation of the user, derived from
+// the UID so a recreated User with the same name gets a new one, and from the number of
+// recreations so a user deleted out of band is not answered with the deleted one
+func idempotencyKey(user *idmv1.User) string {
+	if user.UID == "" {
+		return ""
+	}
+	if user.Status.Recreations > 0 {
+		return fmt.Sprintf("user-%s-%d", user.UID, user.Status.Recreations)
+	}
+	return "user-" + string(user.UID)
+}
+
 // getUser gets an existing user from external system
-func (r *UserReconciler) getUser(ctx context.Context, id string) (*idmsvc.IdentityUser, error) {
+func (r *UserReconciler) getUser(ctx context.Context, user *idmv1.User) (*idmsvc.IdentityUser, error) {
 	_ = log.FromContext(ctx)
 
-	cfg := idmsvc.NewIdentityConfig()
-	svc := idmsvc.NewIdentityService(&cfg)
+	svc, err := r.identityService(ctx, user)
+	if err != nil {
+		return nil, err
+	}
+
+	usr, err := svc.GetUser(ctx, user.Status.ID)
+	if err != nil {
+		return nil, err
+	}
 
-	_, err := svc.GetToken()
+	return usr, nil
+}
+
+// findUser looks up an existing user with the same name in external system
+func (r *UserReconciler) findUser(ctx context.Context, user *idmv1.User) (*idmsvc.IdentityUser, error) {
+	_ = log.FromContext(ctx)
+
+	svc, err := r.identityService(ctx, user)
 	if err != nil {
 		return nil, err
 	}
 
-	usr, err := svc.GetUser(id)
+	usr, err := svc.FindUserByName(ctx, user.Spec.Name)
 	if err != nil {
 		return nil, err
 	}
@@ -198,19 +741,33 @@ func (r *UserReconciler) getUser(ctx context.Context, id string) (*idmsvc.Identi
 	return usr, nil
 }
 
-// updateUser updates an existing user in external system
-func (r *UserReconciler) updateUser(ctx context.Context, user *idmv1.User, extUser *idmsvc.IdentityUser) (*idmsvc.IdentityUser, error) {
+// updateUser updates the drifted fields of an existing user in external system
+func (r *UserReconciler) updateUser(ctx context.Context, user *idmv1.User, extUser *idmsvc.IdentityUser, drifted []string) (*idmsvc.IdentityUser, error) {
 	_ = log.FromContext(ctx)
 
-	cfg := idmsvc.NewIdentityConfig()
-	svc := idmsvc.NewIdentityService(&cfg)
+	spec, err := r.resolveSpec(ctx, user)
+	if err != nil {
+		return nil, err
+	}
+
+	svc, err := r.identityService(ctx, user)
+	if err != nil {
+		return nil, err
+	}
+
+	keepIgnoredAttributes(spec, extUser, r.Options.Config.driftIgnoredAttributes(r.DriftIgnoredAttributes))
 
-	_, err := svc.GetToken()
+	// identity systems without partial updates get the whole user
+	caps, err := instanceCapabilities(ctx, r.Client, r.Options.Config.instanceRef(user.Spec.InstanceRef))
 	if err != nil {
 		return nil, err
 	}
+	if caps != nil && !caps.SupportsPATCH {
+		preserveUnmanaged(spec, extUser)
+		return svc.UpdateUser(ctx, extUser.ID, spec)
+	}
 
-	usr, err := svc.UpdateUser(extUser.ID, &user.Spec)
+	usr, err := svc.PatchUser(ctx, extUser.ID, spec, drifted)
 	if err != nil {
 		return nil, err
 	}
@@ -218,11 +775,106 @@ func (r *UserReconciler) updateUser(ctx context.Context, user *idmv1.User, extUs
 	return usr, nil
 }
 
+// identityService returns the identity service for the user
+func (r *UserReconciler) identityService(ctx context.Context, user *idmv1.User) (idmsvc.IdentityAPI, error) {
+	svc, err := identityServiceFor(ctx, r.Client, user.Namespace, r.Options.Config.instanceRef(user.Spec.InstanceRef), r.IdentityService, r.CredentialsSecret)
+	if err != nil {
+		return nil, err
+	}
+	return withDryRun(svc, user, r.Recorder, r.Options), nil
+}
+
+// desiredRole returns the role of the user, resolving spec.roleRef to the name of the
+// referenced Role once the Role exists in the identity system
+func (r *UserReconciler) desiredRole(ctx context.Context, user *idmv1.User) (string, error) {
+	if user.Spec.RoleRef == nil {
+		return user.Spec.Role, nil
+	}
+
+	role := &idmv1.Role{}
+	err := r.Get(ctx, types.NamespacedName{Namespace: r.referenceNamespace(user), Name: user.Spec.RoleRef.Name}, role)
+	if err != nil {
+		return "", err
+	}
+	if role.Status.ID == "" {
+		return "", fmt.Errorf("role %s/%s is not created in identity system yet", role.Namespace, role.Name)
+	}
+
+	return role.Spec.Name, nil
+}
+
+// resolveSpec returns a copy of the user spec with the password resolved from
+// either the plaintext field or the referenced Secret and the role resolved from
+// the referenced Role. Once rotated, the password is taken from the rotation Secret.
+// Expired users are resolved disabled.
+func (r *UserReconciler) resolveSpec(ctx context.Context, user *idmv1.User) (*idmv1.UserSpec, error) {
+	spec := user.Spec.DeepCopy()
+
+	role, err := r.desiredRole(ctx, user)
+	if err != nil {
+		return nil, err
+	}
+	spec.Role = role
+	spec.RoleRef = nil
+	if expired(user) {
+		disabled := false
+		spec.Enabled = &disabled
+	}
+
+	if rotationEnabled(user) && user.Status.LastPasswordRotation != nil {
+		password, err := r.secretValue(ctx, r.referenceNamespace(user), &spec.PasswordRotation.SecretRef)
+		if err != nil {
+			return nil, err
+		}
+		spec.Password = password
+		spec.PasswordSecretRef = nil
+		return spec, nil
+	}
+
+	if spec.PasswordSecretRef == nil {
+		spec.Password, err = r.Encryption.Decrypt(ctx, spec.Password)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decrypt the password: %w", err)
+		}
+		return spec, nil
+	}
+	if spec.Password != "" {
+		return nil, fmt.Errorf("password and passwordSecretRef are mutually exclusive")
+	}
+
+	password, err := r.secretValue(ctx, r.referenceNamespace(user), spec.PasswordSecretRef)
+	if err != nil {
+		return nil, err
+	}
+
+	spec.Password = password
+	spec.PasswordSecretRef = nil
+
+	return spec, nil
+}
+
+// secretValue reads the referenced key of a Secret in the given namespace
+func (r *UserReconciler) secretValue(ctx context.Context, namespace string, ref *idmv1.SecretKeyReference) (string, error) {
+	secret := &corev1.Secret{}
+	err := r.Get(ctx, types.NamespacedName{Namespace: namespace, Name: ref.Name}, secret)
+	if err != nil {
+		return "", err
+	}
+
+	value, ok := secret.Data[ref.Key]
+	if !ok {
+		return "", fmt.Errorf("key %q not found in secret %s/%s", ref.Key, namespace, ref.Name)
+	}
+
+	return string(value), nil
+}
+
 func (r *UserReconciler) addFinalizer(ctx context.Context, user *idmv1.User) error {
 	log := log.FromContext(ctx)
 	log.Info("Adding finalizer")
-	user.SetFinalizers(append(user.GetFinalizers(), userFinalizer))
-	return r.Update(ctx, user)
+	return patchWithRetry(ctx, r.Client, user, func() {
+		controllerutil.AddFinalizer(user, userFinalizer)
+	})
 }
 
 func containsString(slice []string, s string) bool {
@@ -234,18 +886,165 @@ func containsString(slice []string, s string) bool {
 	return false
 }
 
-func removeString(slice []string, s string) (result []string) {
-	for _, item := range slice {
-		if item != s {
-			result = append(result, item)
+// credentialsSecretToUsers enqueues all Users when the credentials Secret changes, and the
+// Users of a namespace when its NamespaceCredentialsSecret changes, so rotated or fixed
+// credentials are picked up without waiting for the next reconcile
+func (r *UserReconciler) credentialsSecretToUsers(ctx context.Context, obj client.Object) []reconcile.Request {
+	var opts []client.ListOption
+	switch {
+	case r.CredentialsSecret.Name != "" && obj.GetNamespace() == r.CredentialsSecret.Namespace && obj.GetName() == r.CredentialsSecret.Name:
+	case obj.GetName() == idmv1.NamespaceCredentialsSecret:
+		opts = append(opts, client.InNamespace(obj.GetNamespace()))
+	default:
+		return nil
+	}
+
+	users := &idmv1.UserList{}
+	if err := r.List(ctx, users, opts...); err != nil {
+		log.FromContext(ctx).Error(err, "Failed to list Users")
+		return nil
+	}
+
+	requests := make([]reconcile.Request, 0, len(users.Items))
+	for _, user := range users.Items {
+		requests = append(requests, reconcile.Request{
+			NamespacedName: types.NamespacedName{Namespace: user.Namespace, Name: user.Name},
+		})
+	}
+	return requests
+}
+
+// roleToUsers enqueues the Users referencing a Role, so they pick up its name
+// once it is created or renamed. Roles in the ClusterUserNamespace are referenced by the
+// cluster-scoped Users.
+func (r *UserReconciler) roleToUsers(ctx context.Context, obj client.Object) []reconcile.Request {
+	var opts []client.ListOption
+	if obj.GetNamespace() != r.ClusterUserNamespace {
+		opts = append(opts, client.InNamespace(obj.GetNamespace()))
+	}
+	users := &idmv1.UserList{}
+	if err := r.List(ctx, users, opts...); err != nil {
+		log.FromContext(ctx).Error(err, "Failed to list Users")
+		return nil
+	}
+
+	var requests []reconcile.Request
+	for i, user := range users.Items {
+		if r.referenceNamespace(&users.Items[i]) == obj.GetNamespace() && user.Spec.RoleRef != nil && user.Spec.RoleRef.Name == obj.GetName() {
+			requests = append(requests, reconcile.Request{
+				NamespacedName: types.NamespacedName{Namespace: user.Namespace, Name: user.Name},
+			})
 		}
 	}
-	return
+	return requests
+}
+
+// instanceToUsers enqueues the Users managed in an IdentityInstance, so they resume right
+// away once it becomes Ready. Users without an instanceRef are enqueued too if it is the
+// default instance of the IdentityOperatorConfig.
+func (r *UserReconciler) instanceToUsers(ctx context.Context, obj client.Object) []reconcile.Request {
+	users := &idmv1.UserList{}
+	if err := r.List(ctx, users, client.MatchingFields{userInstanceRefIndex: obj.GetName()}); err != nil {
+		log.FromContext(ctx).Error(err, "Failed to list Users")
+		return nil
+	}
+	if ref := r.Options.Config.instanceRef(nil); ref != nil && ref.Name == obj.GetName() {
+		defaulted := &idmv1.UserList{}
+		if err := r.List(ctx, defaulted); err != nil {
+			log.FromContext(ctx).Error(err, "Failed to list Users")
+			return nil
+		}
+		for _, user := range defaulted.Items {
+			if user.Spec.InstanceRef == nil {
+				users.Items = append(users.Items, user)
+			}
+		}
+	}
+
+	requests := make([]reconcile.Request, 0, len(users.Items))
+	for _, user := range users.Items {
+		requests = append(requests, reconcile.Request{
+			NamespacedName: types.NamespacedName{Namespace: user.Namespace, Name: user.Name},
+		})
+	}
+	return requests
+}
+
+// instanceBecameReady passes the updates of IdentityInstances whose Ready condition turned
+// True, e.g. once the identity system is reachable again or accepts the fixed credentials
+var instanceBecameReady = predicate.Funcs{
+	CreateFunc: func(event.CreateEvent) bool { return false },
+	DeleteFunc: func(event.DeleteEvent) bool { return false },
+	UpdateFunc: func(e event.UpdateEvent) bool {
+		oldInstance, ok := e.ObjectOld.(*idmv1.IdentityInstance)
+		if !ok {
+			return false
+		}
+		newInstance, ok := e.ObjectNew.(*idmv1.IdentityInstance)
+		if !ok {
+			return false
+		}
+		return !meta.IsStatusConditionTrue(oldInstance.Status.Conditions, idmv1.ConditionReady) &&
+			meta.IsStatusConditionTrue(newInstance.Status.Conditions, idmv1.ConditionReady)
+	},
+	GenericFunc: func(event.GenericEvent) bool { return false },
+}
+
+// ignoreFailureRecorded filters out the updates that only record another failed attempt
+// on the User status, which must not trigger a reconcile ahead of its backoff
+var ignoreFailureRecorded = predicate.Funcs{
+	UpdateFunc: func(e event.UpdateEvent) bool {
+		oldUser, ok := e.ObjectOld.(*idmv1.User)
+		if !ok {
+			return true
+		}
+		newUser, ok := e.ObjectNew.(*idmv1.User)
+		if !ok {
+			return true
+		}
+		return newUser.Generation != oldUser.Generation || newUser.Status.RetryCount <= oldUser.Status.RetryCount
+	},
 }
 
 // SetupWithManager sets up the controller with the Manager.
 func (r *UserReconciler) SetupWithManager(mgr ctrl.Manager) error {
-	return ctrl.NewControllerManagedBy(mgr).
-		For(&idmv1.User{}).
-		Complete(r)
+	err := metrics.Registry.Register(&userStateCollector{reader: mgr.GetClient()})
+	if err != nil {
+		return err
+	}
+
+	// Users are handed to the workers by their priority annotation
+	r.priorities = newPriorityQueue(mgr.GetClient(), r.Options.MaxConcurrentReconciles)
+	blder := ctrl.NewControllerManagedBy(mgr).
+		For(&idmv1.User{}, builder.WithPredicates(ignoreFailureRecorded, highPriority)).
+		WithOptions(r.Options.controllerOptions()).
+		Watches(&idmv1.User{}, r.priorities.enqueue(requestFor), builder.WithPredicates(ignoreFailureRecorded, predicate.Not(highPriority))).
+		Watches(&idmv1.Role{}, r.priorities.enqueue(r.roleToUsers)).
+		Watches(&corev1.Secret{}, r.priorities.enqueue(r.credentialsSecretToUsers)).
+		Watches(&idmv1.IdentityInstance{}, r.priorities.enqueue(r.instanceToUsers), builder.WithPredicates(instanceBecameReady))
+
+	err = mgr.GetFieldIndexer().IndexField(context.Background(), &idmv1.User{}, userInstanceRefIndex, func(obj client.Object) []string {
+		if ref := obj.(*idmv1.User).Spec.InstanceRef; ref != nil {
+			return []string{ref.Name}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if r.ChangeFeed != nil {
+		err = mgr.GetFieldIndexer().IndexField(context.Background(), &idmv1.User{}, userExternalIDIndex, func(obj client.Object) []string {
+			if id := obj.(*idmv1.User).Status.ID; id != "" {
+				return []string{id}
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		blder = blder.WatchesRawSource(r.ChangeFeed.source(), &handler.EnqueueRequestForObject{})
+	}
+
+	return blder.Complete(r.priorities.feeding(withDrain(withCorrelationID(r))))
 }
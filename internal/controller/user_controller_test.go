@@ -0,0 +1,1072 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"sync"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/client-go/util/workqueue"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	idmv1 "github.com/m15ch4/go-identity-operator/api/v1"
+	"github.com/m15ch4/go-identity-operator/internal/encryption"
+	"github.com/m15ch4/go-identity-operator/internal/notify"
+	idmsvc "github.com/m15ch4/go-identity-operator/pkg/identityclient"
+	"github.com/m15ch4/go-identity-operator/pkg/identityclient/fake"
+)
+
+// recordingNotifier keeps the notified events in order
+type recordingNotifier struct {
+	events []notify.Event
+}
+
+func (n *recordingNotifier) Notify(event notify.Event) {
+	n.events = append(n.events, event)
+}
+
+var _ = Describe("User controller", func() {
+	var (
+		ctx        context.Context
+		svc        *fake.IdentityService
+		reconciler *UserReconciler
+		user       *idmv1.User
+	)
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		svc = fake.NewIdentityService()
+		reconciler = &UserReconciler{
+			Client:            k8sClient,
+			Scheme:            k8sClient.Scheme(),
+			Recorder:          record.NewFakeRecorder(100),
+			IdentityService:   svc,
+			DriftResyncPeriod: time.Minute,
+		}
+
+		user = &idmv1.User{
+			ObjectMeta: metav1.ObjectMeta{
+				GenerateName: "user-",
+				Namespace:    "default",
+			},
+			Spec: idmv1.UserSpec{
+				Name:      "jackr",
+				Password:  "secret",
+				Firstname: "Jack",
+				Lastname:  "Reacher",
+				Role:      "admin",
+				Age:       33,
+				Email:     "jack.reacher@example.com",
+			},
+		}
+		Expect(k8sClient.Create(ctx, user)).To(Succeed())
+	})
+
+	AfterEach(func() {
+		// drop the finalizer so that every spec starts from a clean namespace
+		current := &idmv1.User{}
+		err := k8sClient.Get(ctx, types.NamespacedName{Namespace: user.Namespace, Name: user.Name}, current)
+		if errors.IsNotFound(err) {
+			return
+		}
+		Expect(err).NotTo(HaveOccurred())
+		current.SetFinalizers(nil)
+		Expect(k8sClient.Update(ctx, current)).To(Succeed())
+		Expect(client.IgnoreNotFound(k8sClient.Delete(ctx, current))).To(Succeed())
+	})
+
+	reconcileUser := func() (ctrl.Result, error) {
+		return reconciler.Reconcile(ctx, reconcile.Request{
+			NamespacedName: types.NamespacedName{Namespace: user.Namespace, Name: user.Name},
+		})
+	}
+
+	fetchUser := func() *idmv1.User {
+		current := &idmv1.User{}
+		Expect(k8sClient.Get(ctx, types.NamespacedName{Namespace: user.Namespace, Name: user.Name}, current)).To(Succeed())
+		return current
+	}
+
+	It("creates the external user and adds the finalizer", func() {
+		result, err := reconcileUser()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(result.RequeueAfter).To(Equal(time.Minute))
+
+		current := fetchUser()
+		Expect(current.Status.ID).NotTo(BeEmpty())
+		Expect(current.Status.State).To(Equal("Created"))
+		Expect(current.Status.ObservedGeneration).To(Equal(current.Generation))
+		Expect(current.Status.LastSyncTime).NotTo(BeNil())
+		Expect(current.Status.ExternalName).To(Equal("jackr"))
+		Expect(meta.IsStatusConditionTrue(current.Status.Conditions, idmv1.ConditionReady)).To(BeTrue())
+		Expect(svc.Users).To(HaveKey(current.Status.ID))
+		Expect(svc.Users[current.Status.ID].Firstname).To(Equal("Jack"))
+
+		_, err = reconcileUser()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(fetchUser().Finalizers).To(ContainElement(userFinalizer))
+		Expect(svc.Calls["CreateUser"]).To(Equal(1))
+	})
+
+	It("picks up the user created by an attempt whose response was lost", func() {
+		key := idempotencyKey(fetchUser())
+		Expect(key).NotTo(BeEmpty())
+		lost, err := svc.CreateUser(idmsvc.WithIdempotencyKey(ctx, key), &user.Spec)
+		Expect(err).NotTo(HaveOccurred())
+
+		_, err = reconcileUser()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(fetchUser().Status.ID).To(Equal(lost.ID))
+		Expect(svc.Users).To(HaveLen(1))
+		Expect(svc.Calls["CreateUser"]).To(Equal(1))
+	})
+
+	It("creates concurrently reconciled users with one bulk request", func() {
+		other := &idmv1.User{
+			ObjectMeta: metav1.ObjectMeta{GenerateName: "user-", Namespace: "default"},
+			Spec:       idmv1.UserSpec{Name: "janer", Password: "secret"},
+		}
+		Expect(k8sClient.Create(ctx, other)).To(Succeed())
+		DeferCleanup(func() {
+			Expect(client.IgnoreNotFound(k8sClient.Delete(ctx, other))).To(Succeed())
+		})
+		reconciler.Batcher = &UserBatcher{Window: 100 * time.Millisecond, MaxSize: 10}
+
+		var wg sync.WaitGroup
+		for _, u := range []*idmv1.User{user, other} {
+			wg.Add(1)
+			go func(u *idmv1.User) {
+				defer GinkgoRecover()
+				defer wg.Done()
+				_, err := reconciler.Reconcile(ctx, reconcile.Request{
+					NamespacedName: types.NamespacedName{Namespace: u.Namespace, Name: u.Name},
+				})
+				Expect(err).NotTo(HaveOccurred())
+			}(u)
+		}
+		wg.Wait()
+
+		Expect(svc.Calls["CreateUsers"]).To(Equal(1))
+		Expect(svc.Calls["CreateUser"]).To(Equal(0))
+		Expect(svc.Users).To(HaveLen(2))
+		current := fetchUser()
+		Expect(current.Status.ID).NotTo(BeEmpty())
+		Expect(svc.IdempotencyKeys).To(HaveKeyWithValue(idempotencyKey(current), current.Status.ID))
+	})
+
+	It("stalls users whose password breaks the policy of their instance", func() {
+		policy := &idmv1.PasswordPolicy{
+			ObjectMeta: metav1.ObjectMeta{GenerateName: "policy-"},
+			Spec:       idmv1.PasswordPolicySpec{MinLength: 12, RequireDigits: true},
+		}
+		Expect(k8sClient.Create(ctx, policy)).To(Succeed())
+		DeferCleanup(k8sClient.Delete, ctx, policy)
+		instance := &idmv1.IdentityInstance{
+			ObjectMeta: metav1.ObjectMeta{GenerateName: "instance-"},
+			Spec: idmv1.IdentityInstanceSpec{
+				Host:              "idm.example.test",
+				PasswordPolicyRef: &idmv1.PasswordPolicyReference{Name: policy.Name},
+			},
+		}
+		Expect(k8sClient.Create(ctx, instance)).To(Succeed())
+		DeferCleanup(k8sClient.Delete, ctx, instance)
+
+		current := fetchUser()
+		current.Spec.InstanceRef = &idmv1.IdentityInstanceReference{Name: instance.Name}
+		Expect(k8sClient.Update(ctx, current)).To(Succeed())
+
+		result, err := reconcileUser()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(result).To(Equal(ctrl.Result{}))
+
+		stalled := meta.FindStatusCondition(fetchUser().Status.Conditions, idmv1.ConditionStalled)
+		Expect(stalled).NotTo(BeNil())
+		Expect(stalled.Reason).To(Equal("PasswordPolicyViolation"))
+		Expect(stalled.Message).To(ContainSubstring("shorter than 12 characters"))
+		Expect(svc.Calls["CreateUser"]).To(Equal(0))
+	})
+
+	It("waits for the watch instead of requeueing while its instance is not ready", func() {
+		instance := &idmv1.IdentityInstance{
+			ObjectMeta: metav1.ObjectMeta{GenerateName: "instance-"},
+			Spec:       idmv1.IdentityInstanceSpec{Host: "idm.example.test"},
+		}
+		Expect(k8sClient.Create(ctx, instance)).To(Succeed())
+		DeferCleanup(k8sClient.Delete, ctx, instance)
+		notReady := instance.DeepCopy()
+		meta.SetStatusCondition(&notReady.Status.Conditions, metav1.Condition{
+			Type: idmv1.ConditionReady, Status: metav1.ConditionFalse, Reason: "LoginFailed", Message: "connection refused",
+		})
+		Expect(k8sClient.Status().Update(ctx, notReady)).To(Succeed())
+
+		current := fetchUser()
+		current.Spec.InstanceRef = &idmv1.IdentityInstanceReference{Name: instance.Name}
+		Expect(k8sClient.Update(ctx, current)).To(Succeed())
+
+		result, err := reconcileUser()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(result).To(Equal(ctrl.Result{}))
+		degraded := meta.FindStatusCondition(fetchUser().Status.Conditions, idmv1.ConditionDegraded)
+		Expect(degraded).NotTo(BeNil())
+		Expect(degraded.Reason).To(Equal("InstanceNotReady"))
+
+		ready := notReady.DeepCopy()
+		meta.SetStatusCondition(&ready.Status.Conditions, metav1.Condition{
+			Type: idmv1.ConditionReady, Status: metav1.ConditionTrue, Reason: "LoginSucceeded",
+		})
+		Expect(instanceBecameReady.Update(event.UpdateEvent{ObjectOld: notReady, ObjectNew: ready})).To(BeTrue())
+		Expect(instanceBecameReady.Update(event.UpdateEvent{ObjectOld: ready, ObjectNew: ready})).To(BeFalse())
+	})
+
+	It("decrypts passwords encrypted with the key of the operator", func() {
+		keySecret := &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{GenerateName: "encryption-", Namespace: user.Namespace},
+			Data:       map[string][]byte{"key": bytes.Repeat([]byte{7}, 32)},
+		}
+		Expect(k8sClient.Create(ctx, keySecret)).To(Succeed())
+		DeferCleanup(k8sClient.Delete, ctx, keySecret)
+
+		envelope := &encryption.Envelope{Wrapper: &encryption.SecretKeyWrapper{Reader: k8sClient, Secret: client.ObjectKeyFromObject(keySecret)}}
+		encrypted, err := envelope.Encrypt(ctx, "s3cret!")
+		Expect(err).NotTo(HaveOccurred())
+		user.Spec.Password = encrypted
+		Expect(k8sClient.Update(ctx, user)).To(Succeed())
+
+		reconciler.Encryption = envelope
+		_, err = reconcileUser()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(svc.Users[fetchUser().Status.ID].Password).To(Equal("s3cret!"))
+	})
+
+	It("writes the credentials of the created user into an owned Secret", func() {
+		_, err := reconcileUser()
+		Expect(err).NotTo(HaveOccurred())
+
+		current := fetchUser()
+		Expect(current.Status.CredentialsSecret).To(Equal(current.Name + "-credentials"))
+		secret := &corev1.Secret{}
+		Expect(k8sClient.Get(ctx, types.NamespacedName{Namespace: user.Namespace, Name: current.Status.CredentialsSecret}, secret)).To(Succeed())
+		Expect(secret.Data).To(HaveKeyWithValue(idmv1.CredentialsSecretUsernameKey, []byte("jackr")))
+		Expect(secret.Data).To(HaveKeyWithValue(idmv1.CredentialsSecretIDKey, []byte(current.Status.ID)))
+		Expect(secret.Data).To(HaveKeyWithValue(idmv1.CredentialsSecretPasswordKey, []byte("secret")))
+		Expect(metav1.IsControlledBy(secret, current)).To(BeTrue())
+	})
+
+	It("updates the external user when it drifted", func() {
+		_, err := reconcileUser()
+		Expect(err).NotTo(HaveOccurred())
+		id := fetchUser().Status.ID
+
+		drifted := svc.Users[id]
+		drifted.Firstname = "John"
+		drifted.Email = "john@example.com"
+		drifted.Password = "changed-elsewhere"
+		svc.Users[id] = drifted
+
+		_, err = reconcileUser()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(svc.Users[id].Firstname).To(Equal("Jack"))
+		Expect(svc.Users[id].Email).To(Equal("jack.reacher@example.com"))
+		Expect(svc.Users[id].Password).To(Equal("changed-elsewhere"))
+		Eventually(reconciler.Recorder.(*record.FakeRecorder).Events).Should(Receive(ContainSubstring("Fields firstname, email of user")))
+		Expect(fetchUser().Status.State).To(Equal("Updated"))
+
+		// let the drift resync period elapse
+		reconciler.DriftResyncPeriod = time.Nanosecond
+		_, err = reconcileUser()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(fetchUser().Status.State).To(Equal("Synced"))
+		Expect(svc.Calls["PatchUser"]).To(Equal(1))
+		Expect(svc.Calls["UpdateUser"]).To(BeZero())
+	})
+
+	It("leaves drift of fields outside managedFields alone", func() {
+		user.Spec.ManagedFields = []idmv1.UserField{"firstname"}
+		Expect(k8sClient.Update(ctx, user)).To(Succeed())
+		_, err := reconcileUser()
+		Expect(err).NotTo(HaveOccurred())
+
+		id := fetchUser().Status.ID
+		extUser := svc.Users[id]
+		extUser.Firstname = "Jim"
+		extUser.Phone = "+48123456789"
+		svc.Users[id] = extUser
+
+		current := fetchUser()
+		current.Spec.Lastname = "Reach"
+		Expect(k8sClient.Update(ctx, current)).To(Succeed())
+		_, err = reconcileUser()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(svc.Users[id].Firstname).To(Equal("Jack"))
+		Expect(svc.Users[id].Lastname).To(Equal("Reacher"))
+		Expect(svc.Users[id].Phone).To(Equal("+48123456789"))
+	})
+
+	It("syncs the custom attributes except the ignored ones", func() {
+		reconciler.DriftIgnoredAttributes = []string{"lastLogin"}
+		user.Spec.Attributes = map[string]string{"employeeID": "E100", "department": "Sales"}
+		Expect(k8sClient.Update(ctx, user)).To(Succeed())
+		_, err := reconcileUser()
+		Expect(err).NotTo(HaveOccurred())
+
+		id := fetchUser().Status.ID
+		Expect(svc.Users[id].Attributes).To(Equal(map[string]string{"employeeID": "E100", "department": "Sales"}))
+		extUser := svc.Users[id]
+		extUser.Attributes = map[string]string{"employeeID": "E200", "department": "Sales", "lastLogin": "2024-05-01"}
+		svc.Users[id] = extUser
+
+		current := fetchUser()
+		current.Spec.Attributes["department"] = "Marketing"
+		Expect(k8sClient.Update(ctx, current)).To(Succeed())
+		_, err = reconcileUser()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(svc.Users[id].Attributes).To(Equal(map[string]string{"employeeID": "E100", "department": "Marketing", "lastLogin": "2024-05-01"}))
+	})
+
+	It("skips reading the external user while the spec is unchanged", func() {
+		_, err := reconcileUser()
+		Expect(err).NotTo(HaveOccurred())
+		_, err = reconcileUser()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(fetchUser().Status.SyncedSpecHash).NotTo(BeEmpty())
+		gets := svc.Calls["GetUser"]
+
+		result, err := reconcileUser()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(svc.Calls["GetUser"]).To(Equal(gets))
+		Expect(result.RequeueAfter).To(BeNumerically(">", 0))
+		Expect(result.RequeueAfter).To(BeNumerically("<=", time.Minute))
+
+		current := fetchUser()
+		current.Spec.Lastname = "Smith"
+		Expect(k8sClient.Update(ctx, current)).To(Succeed())
+		_, err = reconcileUser()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(svc.Calls["GetUser"]).To(Equal(gets + 1))
+	})
+
+	It("reports drift and syncs on request while the spec is unchanged", func() {
+		_, err := reconcileUser()
+		Expect(err).NotTo(HaveOccurred())
+		_, err = reconcileUser()
+		Expect(err).NotTo(HaveOccurred())
+
+		current := fetchUser()
+		extUser := svc.Users[current.Status.ID]
+		extUser.Firstname = "Jim"
+		svc.Users[current.Status.ID] = extUser
+
+		diff, err := reconciler.Diff(ctx, current)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(diff.Drifted).To(Equal([]string{"firstname"}))
+		_, err = reconcileUser()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(svc.Users[current.Status.ID].Firstname).To(Equal("Jim"))
+
+		current = fetchUser()
+		current.Annotations = map[string]string{
+			idmv1.AnnotationSyncRequested: current.Status.LastSyncTime.Add(time.Second).Format(time.RFC3339),
+		}
+		Expect(k8sClient.Update(ctx, current)).To(Succeed())
+		_, err = reconcileUser()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(svc.Users[current.Status.ID].Firstname).To(Equal("Jack"))
+	})
+
+	It("corrects drift of external users reported by the change feed", func() {
+		reconciler.ChangeFeed = &ChangeFeed{}
+		_, err := reconcileUser()
+		Expect(err).NotTo(HaveOccurred())
+		_, err = reconcileUser()
+		Expect(err).NotTo(HaveOccurred())
+
+		current := fetchUser()
+		extUser := svc.Users[current.Status.ID]
+		extUser.Firstname = "Jim"
+		svc.Users[current.Status.ID] = extUser
+
+		reconciler.ChangeFeed.enqueue(current)
+		_, err = reconcileUser()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(svc.Users[current.Status.ID].Firstname).To(Equal("Jack"))
+	})
+
+	It("deletes the credentials Secret and the UserRoleBindings of a deleted User", func() {
+		_, err := reconcileUser()
+		Expect(err).NotTo(HaveOccurred())
+		_, err = reconcileUser()
+		Expect(err).NotTo(HaveOccurred())
+
+		bindings := &UserRoleBindingReconciler{
+			Client:          k8sClient,
+			Scheme:          k8sClient.Scheme(),
+			Recorder:        record.NewFakeRecorder(100),
+			IdentityService: svc,
+		}
+		binding := &idmv1.UserRoleBinding{
+			ObjectMeta: metav1.ObjectMeta{GenerateName: "binding-", Namespace: user.Namespace},
+			Spec: idmv1.UserRoleBindingSpec{
+				UserRef: idmv1.UserReference{Name: user.Name},
+				Roles:   []idmv1.BoundRole{{Name: "viewer"}},
+			},
+		}
+		Expect(k8sClient.Create(ctx, binding)).To(Succeed())
+		key := types.NamespacedName{Namespace: binding.Namespace, Name: binding.Name}
+		defer func() {
+			if k8sClient.Get(ctx, key, binding) == nil {
+				binding.SetFinalizers(nil)
+				Expect(k8sClient.Update(ctx, binding)).To(Succeed())
+				Expect(client.IgnoreNotFound(k8sClient.Delete(ctx, binding))).To(Succeed())
+			}
+		}()
+		_, err = bindings.Reconcile(ctx, reconcile.Request{NamespacedName: key})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(k8sClient.Get(ctx, key, binding)).To(Succeed())
+		Expect(ownedByUser(binding, fetchUser())).To(BeTrue())
+
+		Expect(k8sClient.Delete(ctx, fetchUser())).To(Succeed())
+		_, err = reconcileUser()
+		Expect(err).NotTo(HaveOccurred())
+
+		secret := &corev1.Secret{}
+		err = k8sClient.Get(ctx, types.NamespacedName{Namespace: user.Namespace, Name: credentialsSecretName(user)}, secret)
+		Expect(errors.IsNotFound(err)).To(BeTrue())
+		Expect(k8sClient.Get(ctx, key, binding)).To(Succeed())
+		Expect(binding.DeletionTimestamp).NotTo(BeNil())
+	})
+
+	It("suspends the external user instead of deleting it when disabled", func() {
+		_, err := reconcileUser()
+		Expect(err).NotTo(HaveOccurred())
+		_, err = reconcileUser()
+		Expect(err).NotTo(HaveOccurred())
+		id := fetchUser().Status.ID
+
+		current := fetchUser()
+		enabled := false
+		current.Spec.Enabled = &enabled
+		Expect(k8sClient.Update(ctx, current)).To(Succeed())
+		_, err = reconcileUser()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(svc.Users).To(HaveKey(id))
+		Expect(svc.Users[id].Enabled).To(HaveValue(BeFalse()))
+		Expect(fetchUser().Status.State).To(Equal("Suspended"))
+	})
+
+	It("disables expired users and deletes them with the Delete action", func() {
+		_, err := reconcileUser()
+		Expect(err).NotTo(HaveOccurred())
+		id := fetchUser().Status.ID
+
+		current := fetchUser()
+		current.Spec.Expiration = &idmv1.UserExpiration{At: &metav1.Time{Time: time.Now().Add(-time.Minute)}}
+		Expect(k8sClient.Update(ctx, current)).To(Succeed())
+		_, err = reconcileUser()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(svc.Users[id].Enabled).To(HaveValue(BeFalse()))
+		Expect(meta.IsStatusConditionTrue(fetchUser().Status.Conditions, idmv1.ConditionExpired)).To(BeTrue())
+		Expect(fetchUser().Status.State).To(Equal("Expired"))
+
+		current = fetchUser()
+		current.Spec.Expiration.Action = idmv1.ExpirationActionDelete
+		Expect(k8sClient.Update(ctx, current)).To(Succeed())
+		_, err = reconcileUser()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(svc.Users).NotTo(HaveKey(id))
+		Expect(fetchUser().Status.ID).To(BeEmpty())
+
+		By("not creating the user again while expired")
+		_, err = reconcileUser()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(svc.Calls["CreateUser"]).To(Equal(1))
+	})
+
+	It("hands high priority Users to the workers ahead of the waiting ones", func() {
+		prioritized := func(priority string) *idmv1.User {
+			other := &idmv1.User{
+				ObjectMeta: metav1.ObjectMeta{
+					GenerateName: "user-",
+					Namespace:    user.Namespace,
+					Annotations:  map[string]string{idmv1.AnnotationPriority: priority},
+				},
+				Spec: idmv1.UserSpec{Name: priority, Password: "secret"},
+			}
+			Expect(k8sClient.Create(ctx, other)).To(Succeed())
+			DeferCleanup(k8sClient.Delete, ctx, other)
+			return other
+		}
+		low, high := prioritized(idmv1.PriorityLow), prioritized(idmv1.PriorityHigh)
+
+		queue := workqueue.NewRateLimitingQueue(workqueue.DefaultControllerRateLimiter())
+		defer queue.ShutDown()
+		priorities := newPriorityQueue(k8sClient, 1)
+		handler := priorities.enqueue(requestFor)
+		for _, obj := range []*idmv1.User{low, user, high} {
+			handler.Create(ctx, event.CreateEvent{Object: obj}, queue)
+		}
+
+		worker := priorities.feeding(reconcile.Func(func(context.Context, reconcile.Request) (reconcile.Result, error) {
+			return reconcile.Result{}, nil
+		}))
+		var order []string
+		for i := 0; i < 3; i++ {
+			item, _ := queue.Get()
+			req := item.(reconcile.Request)
+			order = append(order, req.Name)
+			_, err := worker.Reconcile(ctx, req)
+			Expect(err).NotTo(HaveOccurred())
+			queue.Done(item)
+		}
+		Expect(order).To(Equal([]string{low.Name, high.Name, user.Name}))
+	})
+
+	It("binds to the external user given by the external-id annotation", func() {
+		existing, err := svc.CreateUser(ctx, &user.Spec)
+		Expect(err).NotTo(HaveOccurred())
+
+		current := fetchUser()
+		current.Annotations = map[string]string{idmv1.AnnotationExternalID: existing.ID}
+		Expect(k8sClient.Update(ctx, current)).To(Succeed())
+		_, err = reconcileUser()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(fetchUser().Status.ID).To(Equal(existing.ID))
+		Expect(fetchUser().Status.State).To(Equal("Bound"))
+
+		_, err = reconcileUser()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(svc.Users).To(HaveLen(1))
+		Expect(svc.Calls["CreateUser"]).To(Equal(1))
+	})
+
+	It("requires a password unless the User adopts an existing external user", func() {
+		bound := &idmv1.User{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:        "bound",
+				Namespace:   "default",
+				Annotations: map[string]string{idmv1.AnnotationExternalID: "ext-1"},
+			},
+			Spec: idmv1.UserSpec{Name: "bound"},
+		}
+		Expect(k8sClient.Create(ctx, bound)).NotTo(Succeed())
+
+		bound.Spec.AdoptExisting = true
+		Expect(k8sClient.Create(ctx, bound)).To(Succeed())
+		DeferCleanup(func() {
+			Expect(client.IgnoreNotFound(k8sClient.Delete(ctx, bound))).To(Succeed())
+		})
+	})
+
+	It("deletes the external user when the User is deleted", func() {
+		_, err := reconcileUser()
+		Expect(err).NotTo(HaveOccurred())
+		_, err = reconcileUser()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(svc.Users).To(HaveLen(1))
+
+		Expect(k8sClient.Delete(ctx, fetchUser())).To(Succeed())
+		_, err = reconcileUser()
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(svc.Users).To(BeEmpty())
+		err = k8sClient.Get(ctx, types.NamespacedName{Namespace: user.Namespace, Name: user.Name}, &idmv1.User{})
+		Expect(errors.IsNotFound(err)).To(BeTrue())
+	})
+
+	It("notifies about the lifecycle of the user", func() {
+		notifier := &recordingNotifier{}
+		reconciler.Notifier = notifier
+
+		_, err := reconcileUser()
+		Expect(err).NotTo(HaveOccurred())
+		id := fetchUser().Status.ID
+		drifted := svc.Users[id]
+		drifted.Lastname = "Smith"
+		svc.Users[id] = drifted
+		_, err = reconcileUser()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(k8sClient.Delete(ctx, fetchUser())).To(Succeed())
+		_, err = reconcileUser()
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(notifier.events).To(HaveLen(3))
+		Expect(notifier.events[0].Type).To(Equal(notify.UserCreated))
+		Expect(notifier.events[0].ID).To(Equal(id))
+		Expect(notifier.events[1].Type).To(Equal(notify.UserUpdated))
+		Expect(notifier.events[1].Fields).To(Equal([]string{"lastname"}))
+		Expect(notifier.events[2].Type).To(Equal(notify.UserDeleted))
+		Expect(notifier.events[2].Username).To(Equal("jackr"))
+	})
+
+	It("records the intended changes without applying them in dry-run mode", func() {
+		recorder := record.NewFakeRecorder(100)
+		reconciler.Recorder = recorder
+		reconciler.Options.DryRun = true
+
+		_, err := reconcileUser()
+		Expect(err).NotTo(HaveOccurred())
+
+		current := fetchUser()
+		Expect(current.Status.ID).To(BeEmpty())
+		Expect(svc.Users).To(BeEmpty())
+		Expect(recorder.Events).To(Receive(ContainSubstring("DryRun Would create user jackr")))
+		degraded := meta.FindStatusCondition(current.Status.Conditions, idmv1.ConditionDegraded)
+		Expect(degraded).NotTo(BeNil())
+		Expect(degraded.Reason).To(Equal("DryRun"))
+	})
+
+	It("keeps the finalizer when deleting the external user fails", func() {
+		_, err := reconcileUser()
+		Expect(err).NotTo(HaveOccurred())
+		_, err = reconcileUser()
+		Expect(err).NotTo(HaveOccurred())
+
+		svc.Errors["DeleteUser"] = &idmsvc.APIError{StatusCode: http.StatusServiceUnavailable, Retryable: true}
+		Expect(k8sClient.Delete(ctx, fetchUser())).To(Succeed())
+		result, err := reconcileUser()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(result.RequeueAfter).To(BeNumerically(">", 0))
+
+		current := fetchUser()
+		Expect(current.Finalizers).To(ContainElement(userFinalizer))
+		degraded := meta.FindStatusCondition(current.Status.Conditions, idmv1.ConditionDegraded)
+		Expect(degraded).NotTo(BeNil())
+		Expect(degraded.Reason).To(Equal("BackendUnavailable"))
+	})
+
+	It("removes the finalizer when the external user is already gone", func() {
+		_, err := reconcileUser()
+		Expect(err).NotTo(HaveOccurred())
+		_, err = reconcileUser()
+		Expect(err).NotTo(HaveOccurred())
+
+		delete(svc.Users, fetchUser().Status.ID)
+		Expect(k8sClient.Delete(ctx, fetchUser())).To(Succeed())
+		_, err = reconcileUser()
+		Expect(err).NotTo(HaveOccurred())
+
+		err = k8sClient.Get(ctx, types.NamespacedName{Namespace: user.Namespace, Name: user.Name}, &idmv1.User{})
+		Expect(errors.IsNotFound(err)).To(BeTrue())
+	})
+
+	It("forces finalization after deleting the external user kept failing", func() {
+		_, err := reconcileUser()
+		Expect(err).NotTo(HaveOccurred())
+		_, err = reconcileUser()
+		Expect(err).NotTo(HaveOccurred())
+
+		svc.Errors["DeleteUser"] = &idmsvc.APIError{StatusCode: http.StatusForbidden}
+		reconciler.ForceFinalizeAfter = time.Hour
+		Expect(k8sClient.Delete(ctx, fetchUser())).To(Succeed())
+		result, err := reconcileUser()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(result.RequeueAfter).To(BeNumerically("~", time.Hour, time.Minute))
+		Expect(fetchUser().Finalizers).To(ContainElement(userFinalizer))
+
+		reconciler.ForceFinalizeAfter = time.Nanosecond
+		_, err = reconcileUser()
+		Expect(err).NotTo(HaveOccurred())
+		err = k8sClient.Get(ctx, types.NamespacedName{Namespace: user.Namespace, Name: user.Name}, &idmv1.User{})
+		Expect(errors.IsNotFound(err)).To(BeTrue())
+		Expect(svc.Users).To(HaveLen(1))
+	})
+
+	It("retries retryable errors with backoff", func() {
+		svc.Errors["CreateUser"] = &idmsvc.APIError{StatusCode: http.StatusServiceUnavailable, Retryable: true}
+
+		first, err := reconcileUser()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(first.RequeueAfter).To(BeNumerically(">", 0))
+
+		current := fetchUser()
+		Expect(current.Status.State).To(Equal("Degraded"))
+		Expect(meta.IsStatusConditionTrue(current.Status.Conditions, idmv1.ConditionDegraded)).To(BeTrue())
+		Expect(meta.IsStatusConditionTrue(current.Status.Conditions, idmv1.ConditionStalled)).To(BeFalse())
+		Expect(current.Status.LastError).To(ContainSubstring("503"))
+		Expect(current.Status.LastAttemptTime).NotTo(BeNil())
+		Expect(current.Status.Backoff).NotTo(BeNil())
+		Expect(current.Status.Backoff.Duration).To(Equal(first.RequeueAfter))
+		Expect(current.Status.NextAttemptTime).NotTo(BeNil())
+
+		// the backoff of the user grows with every failure
+		second, err := reconcileUser()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(second.RequeueAfter).To(Equal(2 * first.RequeueAfter))
+		current = fetchUser()
+		Expect(current.Status.RetryCount).To(Equal(int32(2)))
+		Expect(current.Status.Backoff.Duration).To(Equal(second.RequeueAfter))
+
+		delete(svc.Errors, "CreateUser")
+		_, err = reconcileUser()
+		Expect(err).NotTo(HaveOccurred())
+		current = fetchUser()
+		Expect(current.Status.LastError).To(BeEmpty())
+		Expect(current.Status.RetryCount).To(BeZero())
+		Expect(current.Status.Backoff).To(BeNil())
+		Expect(current.Status.NextAttemptTime).To(BeNil())
+	})
+
+	It("backs off and warns once while the credentials are rejected", func() {
+		recorder := record.NewFakeRecorder(100)
+		reconciler.Recorder = recorder
+		svc.Errors["CreateUser"] = idmsvc.LoginError(&idmsvc.APIError{StatusCode: http.StatusUnauthorized})
+
+		for i := 0; i < 2; i++ {
+			result, err := reconcileUser()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(result.RequeueAfter).To(Equal(credentialsInvalidRequeue))
+		}
+		current := fetchUser()
+		Expect(meta.IsStatusConditionTrue(current.Status.Conditions, idmv1.ConditionCredentialsInvalid)).To(BeTrue())
+		Expect(meta.IsStatusConditionTrue(current.Status.Conditions, idmv1.ConditionStalled)).To(BeFalse())
+		Expect(recorder.Events).To(HaveLen(1))
+		Expect(recorder.Events).To(Receive(ContainSubstring("CredentialsInvalid")))
+
+		delete(svc.Errors, "CreateUser")
+		_, err := reconcileUser()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(meta.IsStatusConditionFalse(fetchUser().Status.Conditions, idmv1.ConditionCredentialsInvalid)).To(BeTrue())
+	})
+
+	It("requeues after the delay requested by the identity system", func() {
+		svc.Errors["CreateUser"] = &idmsvc.APIError{StatusCode: http.StatusTooManyRequests, Retryable: true, RetryAfter: 30 * time.Second}
+
+		result, err := reconcileUser()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(result.RequeueAfter).To(Equal(30 * time.Second))
+	})
+
+	It("rotates the password into the target Secret", func() {
+		current := fetchUser()
+		current.Spec.PasswordRotation = &idmv1.PasswordRotation{
+			Enabled:   true,
+			Interval:  metav1.Duration{Duration: time.Hour},
+			SecretRef: idmv1.SecretKeyReference{Name: current.Name + "-password", Key: "password"},
+		}
+		Expect(k8sClient.Update(ctx, current)).To(Succeed())
+
+		_, err := reconcileUser()
+		Expect(err).NotTo(HaveOccurred())
+		result, err := reconcileUser()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(result.RequeueAfter).To(Equal(time.Minute))
+
+		current = fetchUser()
+		Expect(current.Status.LastPasswordRotation).NotTo(BeNil())
+		secret := &corev1.Secret{}
+		Expect(k8sClient.Get(ctx, types.NamespacedName{Namespace: user.Namespace, Name: user.Name + "-password"}, secret)).To(Succeed())
+		Expect(secret.Data["password"]).To(HaveLen(generatedPasswordLength))
+		Expect(svc.Users[current.Status.ID].Password).To(Equal(string(secret.Data["password"])))
+
+		// not due again until the interval has passed
+		_, err = reconcileUser()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(svc.Calls["PatchUser"]).To(Equal(1))
+	})
+
+	It("retries finalizer patches on a stale User", func() {
+		stale := fetchUser()
+
+		current := fetchUser()
+		current.Labels = map[string]string{"team": "identity"}
+		Expect(k8sClient.Update(ctx, current)).To(Succeed())
+
+		Expect(reconciler.addFinalizer(ctx, stale)).To(Succeed())
+
+		current = fetchUser()
+		Expect(current.Finalizers).To(ContainElement(userFinalizer))
+		Expect(current.Labels).To(HaveKeyWithValue("team", "identity"))
+	})
+
+	It("skips paused Users until they are resumed", func() {
+		current := fetchUser()
+		current.Annotations = map[string]string{idmv1.AnnotationPaused: "true"}
+		Expect(k8sClient.Update(ctx, current)).To(Succeed())
+
+		result, err := reconcileUser()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(result).To(Equal(ctrl.Result{}))
+		Expect(svc.Calls["CreateUser"]).To(BeZero())
+		current = fetchUser()
+		Expect(current.Status.State).To(Equal("Paused"))
+		Expect(meta.IsStatusConditionTrue(current.Status.Conditions, idmv1.ConditionPaused)).To(BeTrue())
+
+		current.Annotations = nil
+		Expect(k8sClient.Update(ctx, current)).To(Succeed())
+		_, err = reconcileUser()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(svc.Calls["CreateUser"]).To(Equal(1))
+		Expect(meta.IsStatusConditionFalse(fetchUser().Status.Conditions, idmv1.ConditionPaused)).To(BeTrue())
+	})
+
+	It("stalls on terminal errors until the spec changes", func() {
+		svc.Errors["CreateUser"] = &idmsvc.APIError{StatusCode: http.StatusBadRequest}
+
+		result, err := reconcileUser()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(result).To(Equal(ctrl.Result{}))
+		Expect(meta.IsStatusConditionTrue(fetchUser().Status.Conditions, idmv1.ConditionStalled)).To(BeTrue())
+
+		_, err = reconcileUser()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(svc.Calls["CreateUser"]).To(Equal(1))
+	})
+
+	It("records name conflicts and adopts the external user once requested", func() {
+		existing, err := svc.CreateUser(ctx, &user.Spec)
+		Expect(err).NotTo(HaveOccurred())
+		svc.Errors["CreateUser"] = &idmsvc.APIError{StatusCode: http.StatusConflict}
+
+		result, err := reconcileUser()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(result).To(Equal(ctrl.Result{}))
+		conflict := meta.FindStatusCondition(fetchUser().Status.Conditions, idmv1.ConditionConflict)
+		Expect(conflict).NotTo(BeNil())
+		Expect(conflict.Status).To(Equal(metav1.ConditionTrue))
+		Expect(conflict.Message).To(ContainSubstring(existing.ID))
+		Expect(meta.IsStatusConditionTrue(fetchUser().Status.Conditions, idmv1.ConditionStalled)).To(BeTrue())
+
+		_, err = reconcileUser()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(svc.Calls["CreateUser"]).To(Equal(2))
+
+		By("adopting the external user once the adopt annotation is set")
+		current := fetchUser()
+		current.Annotations = map[string]string{idmv1.AnnotationAdopt: "true"}
+		Expect(k8sClient.Update(ctx, current)).To(Succeed())
+		_, err = reconcileUser()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(fetchUser().Status.ID).To(Equal(existing.ID))
+		Expect(meta.IsStatusConditionTrue(fetchUser().Status.Conditions, idmv1.ConditionConflict)).To(BeFalse())
+	})
+
+	It("compares the roles of the user as a set", func() {
+		user.Spec.Roles = []string{"tester"}
+		Expect(k8sClient.Update(ctx, user)).To(Succeed())
+		_, err := reconcileUser()
+		Expect(err).NotTo(HaveOccurred())
+		id := fetchUser().Status.ID
+		extUser := svc.Users[id]
+		Expect(extUser.AllRoles()).To(Equal([]string{"admin", "tester"}))
+
+		// the same roles in another order are no drift
+		reordered := svc.Users[id]
+		reordered.SetRoles([]string{"tester", "admin"})
+		svc.Users[id] = reordered
+		reconciler.DriftResyncPeriod = time.Nanosecond
+		_, err = reconcileUser()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(svc.Calls["PatchUser"]).To(BeZero())
+
+		withdrawn := svc.Users[id]
+		withdrawn.SetRoles([]string{"admin"})
+		svc.Users[id] = withdrawn
+		_, err = reconcileUser()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(svc.Calls["PatchUser"]).To(Equal(1))
+		extUser = svc.Users[id]
+		Expect(extUser.AllRoles()).To(ConsistOf("admin", "tester"))
+	})
+
+	It("holds back changes of the external user until they are approved", func() {
+		user.Spec.UpdatePolicy = idmv1.UpdatePolicyManual
+		Expect(k8sClient.Update(ctx, user)).To(Succeed())
+		_, err := reconcileUser()
+		Expect(err).NotTo(HaveOccurred())
+		id := fetchUser().Status.ID
+
+		drifted := svc.Users[id]
+		drifted.Email = "john@example.com"
+		svc.Users[id] = drifted
+
+		_, err = reconcileUser()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(svc.Calls["PatchUser"]).To(BeZero())
+		pending := fetchUser()
+		Expect(pending.Status.State).To(Equal("PendingApproval"))
+		Expect(pending.Status.PendingChanges).To(Equal([]idmv1.UserFieldChange{
+			{Field: "email", Current: "john@example.com", Desired: "jack.reacher@example.com"},
+		}))
+		Expect(meta.IsStatusConditionTrue(pending.Status.Conditions, idmv1.ConditionPendingApproval)).To(BeTrue())
+
+		By("ignoring an approval of other changes")
+		pending.Annotations = map[string]string{idmv1.AnnotationApproveChanges: "0123456789abcdef"}
+		Expect(k8sClient.Update(ctx, pending)).To(Succeed())
+		_, err = reconcileUser()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(svc.Calls["PatchUser"]).To(BeZero())
+
+		By("applying the changes once approved")
+		pending = fetchUser()
+		pending.Annotations[idmv1.AnnotationApproveChanges] = pending.Status.PendingChangesHash
+		Expect(k8sClient.Update(ctx, pending)).To(Succeed())
+		_, err = reconcileUser()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(svc.Users[id].Email).To(Equal("jack.reacher@example.com"))
+		applied := fetchUser()
+		Expect(applied.Status.State).To(Equal("Updated"))
+		Expect(applied.Status.PendingChanges).To(BeEmpty())
+		Expect(applied.Annotations).NotTo(HaveKey(idmv1.AnnotationApproveChanges))
+		Expect(meta.IsStatusConditionFalse(applied.Status.Conditions, idmv1.ConditionPendingApproval)).To(BeTrue())
+	})
+
+	It("plans the changes of a reconcile without making them", func() {
+		change, err := reconciler.Plan(ctx, fetchUser())
+		Expect(err).NotTo(HaveOccurred())
+		Expect(change.Action).To(Equal(PlanActionCreate))
+		Expect(svc.Calls["CreateUser"]).To(BeZero())
+
+		// the second reconcile adds the finalizer
+		for i := 0; i < 2; i++ {
+			_, err = reconcileUser()
+			Expect(err).NotTo(HaveOccurred())
+		}
+		change, err = reconciler.Plan(ctx, fetchUser())
+		Expect(err).NotTo(HaveOccurred())
+		Expect(change.Action).To(Equal(PlanActionNone))
+
+		id := fetchUser().Status.ID
+		drifted := svc.Users[id]
+		drifted.Email = "john@example.com"
+		svc.Users[id] = drifted
+		change, err = reconciler.Plan(ctx, fetchUser())
+		Expect(err).NotTo(HaveOccurred())
+		Expect(change.Action).To(Equal(PlanActionUpdate))
+		Expect(change.Fields).To(Equal([]string{"email"}))
+		Expect(svc.Users[id].Email).To(Equal("john@example.com"))
+
+		Expect(k8sClient.Delete(ctx, fetchUser())).To(Succeed())
+		change, err = reconciler.Plan(ctx, fetchUser())
+		Expect(err).NotTo(HaveOccurred())
+		Expect(change.Action).To(Equal(PlanActionDelete))
+		Expect(svc.Users).To(HaveKey(id))
+	})
+
+	It("resolves the references of cluster-scoped Users in the cluster user namespace", func() {
+		secret := &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{GenerateName: "cluster-user-", Namespace: "default"},
+			Data:       map[string][]byte{"password": []byte("from-secret")},
+		}
+		Expect(k8sClient.Create(ctx, secret)).To(Succeed())
+		reconciler.ClusterUserNamespace = "default"
+
+		clusterUser := &idmv1.User{
+			ObjectMeta: metav1.ObjectMeta{Name: "org-wide"},
+			Spec: idmv1.UserSpec{
+				Name:              "orgwide",
+				PasswordSecretRef: &idmv1.SecretKeyReference{Name: secret.Name, Key: "password"},
+			},
+		}
+		spec, err := reconciler.resolveSpec(ctx, clusterUser)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(spec.Password).To(Equal("from-secret"))
+
+		// Users of a namespace keep resolving theirs in it
+		Expect(reconciler.referenceNamespace(fetchUser())).To(Equal(user.Namespace))
+		Expect(k8sClient.Delete(ctx, secret)).To(Succeed())
+	})
+
+	It("drains in-flight reconciles on shutdown", func() {
+		previous := drain
+		drain = &shutdownDrain{drained: make(chan struct{})}
+		DeferCleanup(func() { drain = previous })
+		drain.enable()
+		req := reconcile.Request{NamespacedName: types.NamespacedName{Namespace: user.Namespace, Name: user.Name}}
+
+		By("finishing the reconcile running when the manager stops")
+		managerCtx, stop := context.WithCancel(ctx)
+		inFlight := reconcile.Func(func(ctx context.Context, req reconcile.Request) (reconcile.Result, error) {
+			stop()
+			drain.begin(time.Hour)
+			Expect(ctx.Err()).NotTo(HaveOccurred())
+			return reconciler.Reconcile(ctx, req)
+		})
+		_, err := withDrain(inFlight).Reconcile(managerCtx, req)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(fetchUser().Status.ID).NotTo(BeEmpty())
+
+		By("not starting new reconciles")
+		calls := svc.Calls["GetUser"]
+		result, err := withDrain(reconciler).Reconcile(ctx, req)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(result.Requeue).To(BeTrue())
+		Expect(svc.Calls["GetUser"]).To(Equal(calls))
+
+		By("writing the status once the drain timeout passed")
+		drain.finish()
+		drained := detachedContext{parent: ctx, done: drain.drained}
+		Expect(drained.Err()).To(MatchError(context.Canceled))
+		persistCtx, cancel := persistContext(drained)
+		defer cancel()
+		Expect(persistCtx.Err()).NotTo(HaveOccurred())
+	})
+
+	It("recreates the external user deleted out of band", func() {
+		_, err := reconcileUser()
+		Expect(err).NotTo(HaveOccurred())
+		deleted := fetchUser().Status.ID
+		delete(svc.Users, deleted)
+
+		result, err := reconcileUser()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(result.Requeue).To(BeTrue())
+		Expect(fetchUser().Status.ID).To(BeEmpty())
+
+		_, err = reconcileUser()
+		Expect(err).NotTo(HaveOccurred())
+		current := fetchUser()
+		Expect(current.Status.ID).NotTo(BeEmpty())
+		Expect(current.Status.ID).NotTo(Equal(deleted))
+		Expect(current.Status.Recreations).To(Equal(int32(1)))
+		Expect(svc.Users).To(HaveKey(current.Status.ID))
+		Expect(meta.IsStatusConditionFalse(current.Status.Conditions, idmv1.ConditionMissing)).To(BeTrue())
+	})
+
+	It("reports the external user deleted out of band under the Report recreate policy", func() {
+		_, err := reconcileUser()
+		Expect(err).NotTo(HaveOccurred())
+		current := fetchUser()
+		current.Spec.RecreatePolicy = idmv1.RecreatePolicyReport
+		Expect(k8sClient.Update(ctx, current)).To(Succeed())
+		deleted := current.Status.ID
+		delete(svc.Users, deleted)
+
+		result, err := reconcileUser()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(result).To(Equal(ctrl.Result{}))
+		current = fetchUser()
+		Expect(current.Status.ID).To(Equal(deleted))
+		Expect(meta.IsStatusConditionTrue(current.Status.Conditions, idmv1.ConditionMissing)).To(BeTrue())
+		Expect(meta.IsStatusConditionFalse(current.Status.Conditions, idmv1.ConditionReady)).To(BeTrue())
+		Expect(svc.Calls["CreateUser"]).To(Equal(1))
+	})
+
+})
@@ -0,0 +1,67 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+
+	idmv1 "github.com/m15ch4/go-identity-operator/api/v1"
+)
+
+// credentialsSecretName returns the name of the credentials Secret of the user
+func credentialsSecretName(user *idmv1.User) string {
+	return user.Name + "-credentials"
+}
+
+// syncCredentialsSecret writes the credentials Secret with the current password of the user
+func (r *UserReconciler) syncCredentialsSecret(ctx context.Context, user *idmv1.User) error {
+	spec, err := r.resolveSpec(ctx, user)
+	if err != nil {
+		return err
+	}
+	return r.writeCredentialsSecret(ctx, user, spec.Password)
+}
+
+// writeCredentialsSecret stores the username, ID and password of the user in its
+// credentials Secret, owned by the User so it is deleted together with it, and records
+// the Secret in the status. An empty password leaves the stored one untouched.
+func (r *UserReconciler) writeCredentialsSecret(ctx context.Context, user *idmv1.User, password string) error {
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Namespace: r.referenceNamespace(user), Name: credentialsSecretName(user)},
+	}
+	_, err := controllerutil.CreateOrUpdate(ctx, r.Client, secret, func() error {
+		if secret.Data == nil {
+			secret.Data = map[string][]byte{}
+		}
+		secret.Data[idmv1.CredentialsSecretUsernameKey] = []byte(user.Spec.Name)
+		secret.Data[idmv1.CredentialsSecretIDKey] = []byte(user.Status.ID)
+		if password != "" {
+			secret.Data[idmv1.CredentialsSecretPasswordKey] = []byte(password)
+		}
+		return controllerutil.SetControllerReference(user, secret, r.Scheme)
+	})
+	if err != nil {
+		return err
+	}
+
+	user.Status.CredentialsSecret = secret.Name
+	return nil
+}
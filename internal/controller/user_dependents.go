@@ -0,0 +1,100 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	idmv1 "github.com/m15ch4/go-identity-operator/api/v1"
+)
+
+// ownedByUser reports whether the object carries an owner reference to the User
+func ownedByUser(obj metav1.Object, user *idmv1.User) bool {
+	for _, ref := range obj.GetOwnerReferences() {
+		if ref.Kind == "User" && ref.Name == user.Name && ref.UID == user.UID {
+			return true
+		}
+	}
+	return false
+}
+
+// setUserOwner adds an owner reference to the User on the object derived from it, so the
+// object is garbage collected together with the User
+func setUserOwner(ctx context.Context, c client.Client, scheme *runtime.Scheme, user *idmv1.User, obj client.Object) error {
+	if ownedByUser(obj, user) {
+		return nil
+	}
+	var ownerErr error
+	err := patchWithRetry(ctx, c, obj, func() {
+		ownerErr = controllerutil.SetOwnerReference(user, obj, scheme)
+	})
+	if ownerErr != nil {
+		return ownerErr
+	}
+	return err
+}
+
+// deleteDependents deletes the Secrets and UserRoleBindings owned by the deleted User
+// instead of leaving them to the garbage collector, which keeps them until the User is
+// gone and not at all without a controller manager running it. Dependents are kept when
+// the User is deleted with orphan propagation.
+func (r *UserReconciler) deleteDependents(ctx context.Context, user *idmv1.User) error {
+	if containsString(user.GetFinalizers(), metav1.FinalizerOrphanDependents) {
+		return nil
+	}
+
+	secrets := &corev1.SecretList{}
+	err := r.List(ctx, secrets, client.InNamespace(r.referenceNamespace(user)))
+	if err != nil {
+		return err
+	}
+	bindings := &idmv1.UserRoleBindingList{}
+	err = r.List(ctx, bindings, client.InNamespace(r.referenceNamespace(user)))
+	if err != nil {
+		return err
+	}
+
+	for i := range secrets.Items {
+		err = r.deleteDependent(ctx, user, "Secret", &secrets.Items[i])
+		if err != nil {
+			return err
+		}
+	}
+	for i := range bindings.Items {
+		err = r.deleteDependent(ctx, user, "UserRoleBinding", &bindings.Items[i])
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// deleteDependent deletes the object of the given kind if it is owned by the User
+func (r *UserReconciler) deleteDependent(ctx context.Context, user *idmv1.User, kind string, obj client.Object) error {
+	if !ownedByUser(obj, user) || !obj.GetDeletionTimestamp().IsZero() {
+		return nil
+	}
+	log.FromContext(ctx).Info("Deleting dependent of User", "kind", kind, "name", obj.GetName())
+	return client.IgnoreNotFound(r.Delete(ctx, obj))
+}
@@ -0,0 +1,68 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+
+	idmv1 "github.com/m15ch4/go-identity-operator/api/v1"
+	idmsvc "github.com/m15ch4/go-identity-operator/pkg/identityclient"
+)
+
+// UserDiff is the result of comparing a User with its external user
+type UserDiff struct {
+	// Desired is the spec as it is synced, with the role resolved from spec.roleRef
+	Desired *idmv1.UserSpec
+	// External is the external user, nil if it does not exist
+	External *idmsvc.IdentityUser
+	// Drifted are the JSON names of the managed fields that differ
+	Drifted []string
+}
+
+// Diff compares the User with its external user the way Reconcile does, without changing
+// either of them. Users without an ID are looked up by name.
+func (r *UserReconciler) Diff(ctx context.Context, user *idmv1.User) (*UserDiff, error) {
+	svc, err := identityServiceFor(ctx, r.Client, user.Namespace, r.Options.Config.instanceRef(user.Spec.InstanceRef), r.IdentityService, r.CredentialsSecret)
+	if err != nil {
+		return nil, err
+	}
+
+	role, err := r.desiredRole(ctx, user)
+	if err != nil {
+		return nil, err
+	}
+	diff := &UserDiff{Desired: user.Spec.DeepCopy()}
+	diff.Desired.Role = role
+	diff.Desired.RoleRef = nil
+
+	if user.Status.ID != "" {
+		diff.External, err = svc.GetUser(ctx, user.Status.ID)
+		if idmsvc.IsNotFound(err) {
+			diff.External = nil
+			return diff, nil
+		}
+	} else {
+		diff.External, err = svc.FindUserByName(ctx, user.Spec.Name)
+	}
+	if err != nil || diff.External == nil {
+		return diff, err
+	}
+
+	keepIgnoredAttributes(diff.Desired, diff.External, r.Options.Config.driftIgnoredAttributes(r.DriftIgnoredAttributes))
+	diff.Drifted = userDrift(diff.Desired, diff.External)
+	return diff, nil
+}
@@ -0,0 +1,102 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	idmv1 "github.com/m15ch4/go-identity-operator/api/v1"
+	"github.com/m15ch4/go-identity-operator/internal/notify"
+)
+
+// expired reports whether the expiration time of the user has passed
+func expired(user *idmv1.User) bool {
+	at, ok := user.ExpiresAt()
+	return ok && !time.Now().Before(at)
+}
+
+// expireUser applies the expiration action of an expired user: the external user is
+// disabled or deleted, or the User itself is deleted. Disabled users are re-checked
+// periodically so they are not enabled again out of band.
+func (r *UserReconciler) expireUser(ctx context.Context, user, original *idmv1.User) (ctrl.Result, error) {
+	log := log.FromContext(ctx)
+
+	expiration := user.Spec.Expiration
+	if expiration.DeleteResource {
+		log.Info("Deleting expired User")
+		r.Recorder.Event(user, corev1.EventTypeNormal, "UserExpired", "User expired and is deleted")
+		return ctrl.Result{}, client.IgnoreNotFound(r.Delete(ctx, user))
+	}
+
+	var result ctrl.Result
+	switch {
+	case user.Status.ID == "":
+		// the user was never created or was already deleted on expiration
+	case expiration.Action == idmv1.ExpirationActionDelete:
+		err := r.finalizeUser(ctx, user)
+		if err != nil {
+			r.setDegraded(ctx, user, original, "ExpireFailed", err)
+			return requeueFor(ctx, err)
+		}
+		log.Info("Deleted expired user from identity system")
+		r.Recorder.Eventf(user, corev1.EventTypeNormal, "UserExpired", "Deleted expired user %s from identity system", user.Status.ID)
+		r.notify(notify.UserDeleted, user, nil)
+		user.Status.ID = ""
+		user.Status.SyncedSpecHash = ""
+	default:
+		extUser, err := r.getUser(ctx, user)
+		if err != nil {
+			r.setDegraded(ctx, user, original, "ExpireFailed", err)
+			return requeueFor(ctx, err)
+		}
+		if extUser.Enabled != nil && *extUser.Enabled {
+			_, err = r.updateUser(ctx, user, extUser, []string{"enabled"})
+			if err != nil {
+				r.setDegraded(ctx, user, original, "ExpireFailed", err)
+				return requeueFor(ctx, err)
+			}
+			log.Info("Disabled expired user in identity system")
+			r.Recorder.Eventf(user, corev1.EventTypeNormal, "UserExpired", "Disabled expired user %s in identity system", user.Status.ID)
+			r.notify(notify.UserUpdated, user, []string{"enabled"})
+		}
+		result.RequeueAfter = r.Options.Config.driftResyncPeriod(r.DriftResyncPeriod)
+	}
+
+	at, _ := user.ExpiresAt()
+	message := fmt.Sprintf("User expired at %s", at.UTC().Format(time.RFC3339))
+	user.Status.State = "Expired"
+	r.setCondition(user, idmv1.ConditionExpired, metav1.ConditionTrue, "Expired", message)
+	r.setCondition(user, idmv1.ConditionReady, metav1.ConditionFalse, "Expired", message)
+	r.setCondition(user, idmv1.ConditionDegraded, metav1.ConditionFalse, "Expired", message)
+	if !equality.Semantic.DeepEqual(original.Status, user.Status) {
+		err := patchStatus(ctx, r.Client, user, original)
+		if err != nil {
+			log.Info("Failed to update user status")
+			return ctrl.Result{}, err
+		}
+	}
+	return result, nil
+}
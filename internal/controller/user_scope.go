@@ -0,0 +1,65 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"fmt"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+
+	idmv1 "github.com/m15ch4/go-identity-operator/api/v1"
+)
+
+// UserScope selects whether the User CRD is installed namespaced, for the identities of a
+// team managed in its namespace, or cluster-scoped, for identities of the whole organization
+type UserScope string
+
+const (
+	// UserScopeNamespaced is the scope of the User CRD in config/crd
+	UserScopeNamespaced UserScope = "Namespaced"
+	// UserScopeCluster is the scope of the User CRD patched with
+	// config/crd/patches/cluster_scope_in_users.yaml
+	UserScopeCluster UserScope = "Cluster"
+)
+
+// CheckUserScope returns an error unless the User CRD is installed with the given scope, as
+// its scope cannot be changed once Users exist
+func CheckUserScope(mapper meta.RESTMapper, scope UserScope) error {
+	mapping, err := mapper.RESTMapping(schema.GroupKind{Group: idmv1.GroupVersion.Group, Kind: "User"}, idmv1.GroupVersion.Version)
+	if err != nil {
+		return err
+	}
+	installed := UserScopeNamespaced
+	if mapping.Scope.Name() == meta.RESTScopeNameRoot {
+		installed = UserScopeCluster
+	}
+	if installed != scope {
+		return fmt.Errorf("the User CRD is installed with scope %s, not %s", installed, scope)
+	}
+	return nil
+}
+
+// referenceNamespace returns the namespace of the Secrets and Roles referenced by the user
+// and of the Secrets created for it. Cluster-scoped Users have no namespace of their own and
+// use ClusterUserNamespace.
+func (r *UserReconciler) referenceNamespace(user *idmv1.User) string {
+	if user.Namespace != "" {
+		return user.Namespace
+	}
+	return r.ClusterUserNamespace
+}
@@ -0,0 +1,93 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"time"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	idmv1 "github.com/m15ch4/go-identity-operator/api/v1"
+)
+
+// specHash returns the hash of the spec as it is synced to the identity system, with the
+// role resolved from spec.roleRef. The password is left out of the hash, changing it in the
+// spec changes the generation anyway.
+func (r *UserReconciler) specHash(ctx context.Context, user *idmv1.User) (string, error) {
+	role, err := r.desiredRole(ctx, user)
+	if err != nil {
+		return "", err
+	}
+
+	spec := user.Spec.DeepCopy()
+	spec.Password = ""
+	spec.Role = role
+	data, err := json.Marshal(spec)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// skipSync reports whether the user is known to be up to date without reading the external
+// user: the spec is unchanged since the last successful sync, the finalizer and credentials
+// Secret are in place and neither the drift resync, a password rotation nor a requested
+// sync is due. It returns the delay until the user has to be synced again.
+func (r *UserReconciler) skipSync(user *idmv1.User, hash string) (time.Duration, bool) {
+	if user.Status.SyncedSpecHash != hash || user.Status.ObservedGeneration != user.Generation {
+		return 0, false
+	}
+	ready := meta.FindStatusCondition(user.Status.Conditions, idmv1.ConditionReady)
+	if ready == nil || ready.Status != metav1.ConditionTrue || ready.ObservedGeneration != user.Generation {
+		return 0, false
+	}
+	if user.Status.LastSyncTime == nil || user.Status.CredentialsSecret == "" || rotationDue(user) || syncRequested(user) {
+		return 0, false
+	}
+	if containsString(user.GetFinalizers(), userFinalizer) == (user.Spec.DeletionPolicy == idmv1.DeletionPolicyRetain) {
+		return 0, false
+	}
+
+	delay := r.resyncAfter(user)
+	if period := r.Options.Config.driftResyncPeriod(r.DriftResyncPeriod); period > 0 {
+		remaining := time.Until(user.Status.LastSyncTime.Add(period))
+		if remaining <= 0 {
+			return 0, false
+		}
+		if remaining < delay {
+			delay = remaining
+		}
+	}
+	return delay, true
+}
+
+// syncRequested reports whether the sync-requested annotation asks for a sync after the
+// last one. Values that are not RFC 3339 times are ignored.
+func syncRequested(user *idmv1.User) bool {
+	value, ok := user.GetAnnotations()[idmv1.AnnotationSyncRequested]
+	if !ok {
+		return false
+	}
+	requested, err := time.Parse(time.RFC3339, value)
+	return err == nil && requested.After(user.Status.LastSyncTime.Time)
+}
@@ -0,0 +1,349 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	idmv1 "github.com/m15ch4/go-identity-operator/api/v1"
+	"github.com/m15ch4/go-identity-operator/internal/status"
+	idmsvc "github.com/m15ch4/go-identity-operator/pkg/identityclient"
+)
+
+const userRoleBindingFinalizer = "micze.io/userrolebinding-finalizer"
+
+// UserRoleBindingReconciler reconciles a UserRoleBinding object
+type UserRoleBindingReconciler struct {
+	client.Client
+	Scheme *runtime.Scheme
+
+	// Recorder emits Events on UserRoleBindings
+	Recorder record.EventRecorder
+
+	// IdentityService is the long-lived service used for Users without an instanceRef
+	IdentityService idmsvc.IdentityAPI
+
+	// Options tunes the workers and the rate limiter of the controller
+	Options ControllerOptions
+
+	// DriftResyncPeriod is the interval after which the roles of the user are compared
+	// with the binding again. Zero disables periodic resync.
+	DriftResyncPeriod time.Duration
+
+	// CredentialsSecret optionally references a Secret with IDM_USER and IDM_PASS keys
+	// used to log in to the identity system
+	CredentialsSecret types.NamespacedName
+}
+
+//+kubebuilder:rbac:groups=idm.micze.io,resources=userrolebindings,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=idm.micze.io,resources=userrolebindings/status,verbs=get;update;patch
+//+kubebuilder:rbac:groups=idm.micze.io,resources=userrolebindings/finalizers,verbs=update
+//+kubebuilder:rbac:groups=idm.micze.io,resources=users,verbs=get;list;watch
+//+kubebuilder:rbac:groups=idm.micze.io,resources=roles,verbs=get;list;watch
+
+// Reconcile assigns the bound roles to the user in the identity system and removes the
+// roles that were bound before but no longer are. Only the scopes of the bound and the
+// previously assigned roles are read, roles assigned by other means are left untouched.
+func (r *UserRoleBindingReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	log := log.FromContext(ctx)
+
+	binding := &idmv1.UserRoleBinding{}
+	err := r.Get(ctx, req.NamespacedName, binding)
+	if err != nil {
+		if errors.IsNotFound(err) {
+			log.Info("UserRoleBinding resource not found. Ignoring since object must be deleted")
+			return ctrl.Result{}, nil
+		}
+		log.Error(err, "Failed to get UserRoleBinding")
+		return ctrl.Result{}, err
+	}
+	original := binding.DeepCopy()
+
+	// Leave the identity system alone while paused, deletion waits for the resume
+	isPaused := paused(binding, binding.Spec.Paused)
+	setPaused(&binding.Status.Conditions, binding.Generation, isPaused)
+	if isPaused {
+		log.Info("Reconciliation is paused")
+		return ctrl.Result{}, r.updateStatus(ctx, original, binding)
+	}
+
+	// Fetch the User, the binding is reconciled again once it exists in the identity system
+	user := &idmv1.User{}
+	err = r.Get(ctx, types.NamespacedName{Namespace: binding.Namespace, Name: binding.Spec.UserRef.Name}, user)
+	if err != nil && !errors.IsNotFound(err) {
+		return ctrl.Result{}, err
+	}
+	userReady := err == nil && user.Status.ID != "" && user.DeletionTimestamp.IsZero()
+
+	// Remove the roles assigned by the binding before letting it go
+	if !binding.ObjectMeta.DeletionTimestamp.IsZero() {
+		if containsString(binding.GetFinalizers(), userRoleBindingFinalizer) {
+			if userReady {
+				svc, err := identityServiceFor(ctx, r.Client, user.Namespace, r.Options.Config.instanceRef(user.Spec.InstanceRef), r.IdentityService, r.CredentialsSecret)
+				if err != nil {
+					return requeueFor(ctx, err)
+				}
+				svc = withDryRun(svc, binding, r.Recorder, r.Options)
+				for _, assigned := range binding.Status.Assigned {
+					err := svc.RemoveUserRole(ctx, user.Status.ID, assigned.Scope, assigned.Name)
+					if err != nil && !idmsvc.IsNotFound(err) {
+						r.setDegraded(ctx, binding, original, "FinalizeFailed", err)
+						return requeueFor(ctx, err)
+					}
+				}
+			}
+
+			err = patchWithRetry(ctx, r.Client, binding, func() {
+				controllerutil.RemoveFinalizer(binding, userRoleBindingFinalizer)
+			})
+			if err != nil {
+				return ctrl.Result{}, err
+			}
+		}
+		return ctrl.Result{}, nil
+	}
+
+	// The binding is owned by its User, deleting the User deletes the binding
+	if err == nil && user.DeletionTimestamp.IsZero() {
+		err = setUserOwner(ctx, r.Client, r.Scheme, user, binding)
+		if err != nil {
+			return ctrl.Result{}, err
+		}
+	}
+
+	if !userReady {
+		r.setCondition(binding, idmv1.ConditionReady, metav1.ConditionFalse, "UserNotReady",
+			fmt.Sprintf("User %s is not created in identity system yet", binding.Spec.UserRef.Name))
+		return ctrl.Result{}, r.updateStatus(ctx, original, binding)
+	}
+
+	svc, err := identityServiceFor(ctx, r.Client, user.Namespace, r.Options.Config.instanceRef(user.Spec.InstanceRef), r.IdentityService, r.CredentialsSecret)
+	if err != nil {
+		r.setDegraded(ctx, binding, original, "ConfigurationFailed", err)
+		return requeueFor(ctx, err)
+	}
+	svc = withDryRun(svc, binding, r.Recorder, r.Options)
+
+	// Resolve the bound roles to role names of the identity system
+	desired := map[idmv1.AssignedRole]bool{}
+	var pending []string
+	for _, bound := range binding.Spec.Roles {
+		name := bound.Name
+		if bound.RoleRef != nil {
+			role := &idmv1.Role{}
+			err := r.Get(ctx, types.NamespacedName{Namespace: binding.Namespace, Name: bound.RoleRef.Name}, role)
+			if err != nil && !errors.IsNotFound(err) {
+				return ctrl.Result{}, err
+			}
+			if err != nil || role.Status.ID == "" {
+				pending = append(pending, bound.RoleRef.Name)
+				continue
+			}
+			name = role.Spec.Name
+		}
+		desired[idmv1.AssignedRole{Name: name, Scope: bound.Scope}] = true
+	}
+
+	// Read the current roles of every scope involved
+	current := map[idmv1.AssignedRole]bool{}
+	scopes := map[string]bool{}
+	for role := range desired {
+		scopes[role.Scope] = true
+	}
+	for _, role := range binding.Status.Assigned {
+		scopes[role.Scope] = true
+	}
+	for scope := range scopes {
+		names, err := svc.ListUserRoles(ctx, user.Status.ID, scope)
+		if err != nil {
+			r.setDegraded(ctx, binding, original, "ListRolesFailed", err)
+			return requeueFor(ctx, err)
+		}
+		for _, name := range names {
+			current[idmv1.AssignedRole{Name: name, Scope: scope}] = true
+		}
+	}
+
+	// Assign the missing roles
+	added := 0
+	for role := range desired {
+		if current[role] {
+			continue
+		}
+		err := svc.AddUserRole(ctx, user.Status.ID, role.Scope, role.Name)
+		if err != nil {
+			r.setDegraded(ctx, binding, original, "AssignRoleFailed", err)
+			return requeueFor(ctx, err)
+		}
+		added++
+	}
+
+	// Remove the roles previously assigned by this binding that are no longer bound
+	removed := 0
+	for _, role := range binding.Status.Assigned {
+		if desired[role] || !current[role] {
+			continue
+		}
+		err := svc.RemoveUserRole(ctx, user.Status.ID, role.Scope, role.Name)
+		if err != nil && !idmsvc.IsNotFound(err) {
+			r.setDegraded(ctx, binding, original, "RemoveRoleFailed", err)
+			return requeueFor(ctx, err)
+		}
+		removed++
+	}
+
+	if added > 0 || removed > 0 {
+		log.Info("Updated role assignments", "added", added, "removed", removed)
+		r.Recorder.Eventf(binding, corev1.EventTypeNormal, "RolesUpdated", "Assigned %d and removed %d roles of user %s", added, removed, user.Status.ID)
+	}
+
+	binding.Status.Assigned = make([]idmv1.AssignedRole, 0, len(desired))
+	for role := range desired {
+		binding.Status.Assigned = append(binding.Status.Assigned, role)
+	}
+	sort.Slice(binding.Status.Assigned, func(i, j int) bool {
+		a, b := binding.Status.Assigned[i], binding.Status.Assigned[j]
+		if a.Scope != b.Scope {
+			return a.Scope < b.Scope
+		}
+		return a.Name < b.Name
+	})
+
+	if len(pending) > 0 {
+		r.setCondition(binding, idmv1.ConditionReady, metav1.ConditionFalse, "RolesPending",
+			fmt.Sprintf("Roles not created in identity system yet: %s", strings.Join(pending, ", ")))
+	} else {
+		r.setCondition(binding, idmv1.ConditionReady, metav1.ConditionTrue, "Bound", "All roles are assigned to the user")
+	}
+	r.setCondition(binding, idmv1.ConditionDegraded, metav1.ConditionFalse, "Bound", "Role assignments are in sync")
+
+	err = r.updateStatus(ctx, original, binding)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+
+	if !containsString(binding.GetFinalizers(), userRoleBindingFinalizer) {
+		if err := patchWithRetry(ctx, r.Client, binding, func() {
+			controllerutil.AddFinalizer(binding, userRoleBindingFinalizer)
+		}); err != nil {
+			return ctrl.Result{}, err
+		}
+	}
+
+	return ctrl.Result{RequeueAfter: r.Options.Config.driftResyncPeriod(r.DriftResyncPeriod)}, nil
+}
+
+// updateStatus writes the status of the binding if it changed
+func (r *UserRoleBindingReconciler) updateStatus(ctx context.Context, original, binding *idmv1.UserRoleBinding) error {
+	if equality.Semantic.DeepEqual(original.Status, binding.Status) {
+		return nil
+	}
+
+	err := patchStatus(ctx, r.Client, binding, original)
+	if err != nil {
+		log.FromContext(ctx).Info("Failed to update user role binding status")
+	}
+	return err
+}
+
+// setCondition sets the given condition on the binding status, observed at the current generation
+func (r *UserRoleBindingReconciler) setCondition(binding *idmv1.UserRoleBinding, conditionType string, conditionStatus metav1.ConditionStatus, reason, message string) {
+	status.SetCondition(binding, conditionType, conditionStatus, reason, message)
+}
+
+// setDegraded records the failure on the binding status; errors updating the status are only logged
+func (r *UserRoleBindingReconciler) setDegraded(ctx context.Context, binding, original *idmv1.UserRoleBinding, reason string, cause error) {
+	log := log.FromContext(ctx)
+
+	r.Recorder.Event(binding, corev1.EventTypeWarning, "ExternalAPIError", cause.Error())
+
+	reason = failureReason(cause, reason)
+	r.setCondition(binding, idmv1.ConditionReady, metav1.ConditionFalse, reason, cause.Error())
+	r.setCondition(binding, idmv1.ConditionDegraded, metav1.ConditionTrue, reason, cause.Error())
+
+	if err := patchStatus(ctx, r.Client, binding, original); err != nil {
+		log.Error(err, "Failed to update user role binding status")
+	}
+}
+
+// bindingsFor enqueues the UserRoleBindings in the namespace of obj that match
+func (r *UserRoleBindingReconciler) bindingsFor(ctx context.Context, obj client.Object, match func(*idmv1.UserRoleBinding) bool) []reconcile.Request {
+	bindings := &idmv1.UserRoleBindingList{}
+	if err := r.List(ctx, bindings, client.InNamespace(obj.GetNamespace())); err != nil {
+		log.FromContext(ctx).Error(err, "Failed to list UserRoleBindings")
+		return nil
+	}
+
+	var requests []reconcile.Request
+	for i := range bindings.Items {
+		if match(&bindings.Items[i]) {
+			requests = append(requests, reconcile.Request{
+				NamespacedName: types.NamespacedName{Namespace: bindings.Items[i].Namespace, Name: bindings.Items[i].Name},
+			})
+		}
+	}
+	return requests
+}
+
+// userToBindings enqueues the UserRoleBindings of a User, so they pick up its ID once it
+// is created
+func (r *UserRoleBindingReconciler) userToBindings(ctx context.Context, obj client.Object) []reconcile.Request {
+	return r.bindingsFor(ctx, obj, func(binding *idmv1.UserRoleBinding) bool {
+		return binding.Spec.UserRef.Name == obj.GetName()
+	})
+}
+
+// roleToBindings enqueues the UserRoleBindings referencing a Role, so they assign it once
+// it is created
+func (r *UserRoleBindingReconciler) roleToBindings(ctx context.Context, obj client.Object) []reconcile.Request {
+	return r.bindingsFor(ctx, obj, func(binding *idmv1.UserRoleBinding) bool {
+		for _, bound := range binding.Spec.Roles {
+			if bound.RoleRef != nil && bound.RoleRef.Name == obj.GetName() {
+				return true
+			}
+		}
+		return false
+	})
+}
+
+// SetupWithManager sets up the controller with the Manager.
+func (r *UserRoleBindingReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&idmv1.UserRoleBinding{}).
+		WithOptions(r.Options.controllerOptions()).
+		Watches(&idmv1.User{}, handler.EnqueueRequestsFromMapFunc(r.userToBindings)).
+		Watches(&idmv1.Role{}, handler.EnqueueRequestsFromMapFunc(r.roleToBindings)).
+		Complete(withDrain(withCorrelationID(r)))
+}
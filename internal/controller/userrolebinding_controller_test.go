@@ -0,0 +1,100 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	idmv1 "github.com/m15ch4/go-identity-operator/api/v1"
+	"github.com/m15ch4/go-identity-operator/pkg/identityclient/fake"
+)
+
+var _ = Describe("UserRoleBinding controller", func() {
+	var (
+		ctx context.Context
+		svc *fake.IdentityService
+	)
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		svc = fake.NewIdentityService()
+	})
+
+	It("assigns and withdraws the roles bound by a UserRoleBinding", func() {
+		extUser, err := svc.CreateUser(ctx, &idmv1.UserSpec{Name: "jackr", Role: "user"})
+		Expect(err).NotTo(HaveOccurred())
+		user := &idmv1.User{
+			ObjectMeta: metav1.ObjectMeta{GenerateName: "user-", Namespace: "default"},
+			Spec:       idmv1.UserSpec{Name: "jackr", Password: "secret", Role: "user"},
+		}
+		Expect(k8sClient.Create(ctx, user)).To(Succeed())
+		DeferCleanup(func() {
+			Expect(client.IgnoreNotFound(k8sClient.Delete(ctx, user))).To(Succeed())
+		})
+		user.Status.ID = extUser.ID
+		Expect(k8sClient.Status().Update(ctx, user)).To(Succeed())
+		id := user.Status.ID
+
+		bindings := &UserRoleBindingReconciler{
+			Client:          k8sClient,
+			Scheme:          k8sClient.Scheme(),
+			Recorder:        record.NewFakeRecorder(100),
+			IdentityService: svc,
+		}
+		binding := &idmv1.UserRoleBinding{
+			ObjectMeta: metav1.ObjectMeta{GenerateName: "binding-", Namespace: user.Namespace},
+			Spec: idmv1.UserRoleBindingSpec{
+				UserRef: idmv1.UserReference{Name: user.Name},
+				Roles:   []idmv1.BoundRole{{Name: "viewer"}, {Name: "editor", Scope: "reporting"}},
+			},
+		}
+		Expect(k8sClient.Create(ctx, binding)).To(Succeed())
+		key := types.NamespacedName{Namespace: binding.Namespace, Name: binding.Name}
+		defer func() {
+			Expect(k8sClient.Get(ctx, key, binding)).To(Succeed())
+			binding.SetFinalizers(nil)
+			Expect(k8sClient.Update(ctx, binding)).To(Succeed())
+			Expect(k8sClient.Delete(ctx, binding)).To(Succeed())
+		}()
+
+		_, err = bindings.Reconcile(ctx, reconcile.Request{NamespacedName: key})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(svc.UserRoles[id][""]).To(HaveKey("viewer"))
+		Expect(svc.UserRoles[id]["reporting"]).To(HaveKey("editor"))
+
+		Expect(k8sClient.Get(ctx, key, binding)).To(Succeed())
+		binding.Spec.Roles = binding.Spec.Roles[:1]
+		Expect(k8sClient.Update(ctx, binding)).To(Succeed())
+		_, err = bindings.Reconcile(ctx, reconcile.Request{NamespacedName: key})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(svc.UserRoles[id]["reporting"]).NotTo(HaveKey("editor"))
+
+		Expect(k8sClient.Get(ctx, key, binding)).To(Succeed())
+		Expect(binding.Status.Assigned).To(Equal([]idmv1.AssignedRole{{Name: "viewer"}}))
+		Expect(meta.IsStatusConditionTrue(binding.Status.Conditions, idmv1.ConditionReady)).To(BeTrue())
+	})
+})
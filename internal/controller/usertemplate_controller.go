@@ -0,0 +1,321 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	idmv1 "github.com/m15ch4/go-identity-operator/api/v1"
+)
+
+// UserTemplateReconciler reconciles a UserTemplate object
+type UserTemplateReconciler struct {
+	client.Client
+	Scheme *runtime.Scheme
+
+	// Recorder emits Events on UserTemplates
+	Recorder record.EventRecorder
+	// Options tunes the workers and the rate limiter of the controller
+	Options ControllerOptions
+}
+
+//+kubebuilder:rbac:groups=idm.micze.io,resources=usertemplates,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=idm.micze.io,resources=usertemplates/status,verbs=get;update;patch
+//+kubebuilder:rbac:groups=idm.micze.io,resources=usertemplates/finalizers,verbs=update
+//+kubebuilder:rbac:groups=idm.micze.io,resources=users,verbs=get;list;watch;create;update;patch;delete
+
+// Reconcile stamps out a User for every identity of the UserTemplate, updates the Users
+// when the template changes and deletes the Users of identities that were removed. The
+// Users are owned by the template and deleted together with it.
+func (r *UserTemplateReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	log := log.FromContext(ctx)
+
+	tmpl := &idmv1.UserTemplate{}
+	err := r.Get(ctx, req.NamespacedName, tmpl)
+	if err != nil {
+		if errors.IsNotFound(err) {
+			log.Info("UserTemplate resource not found. Ignoring since object must be deleted")
+			return ctrl.Result{}, nil
+		}
+		log.Error(err, "Failed to get UserTemplate")
+		return ctrl.Result{}, err
+	}
+	original := tmpl.DeepCopy()
+
+	isPaused := paused(tmpl, tmpl.Spec.Paused)
+	setPaused(&tmpl.Status.Conditions, tmpl.Generation, isPaused)
+	if isPaused {
+		log.Info("Reconciliation is paused")
+		if !equality.Semantic.DeepEqual(original.Status, tmpl.Status) {
+			err = patchStatus(ctx, r.Client, tmpl, original)
+			if err != nil {
+				log.Info("Failed to update UserTemplate status")
+				return ctrl.Result{}, err
+			}
+		}
+		return ctrl.Result{}, nil
+	}
+
+	syncErr := r.syncUsers(ctx, tmpl)
+	if syncErr != nil {
+		r.Recorder.Event(tmpl, corev1.EventTypeWarning, "SyncFailed", syncErr.Error())
+		r.setCondition(tmpl, idmv1.ConditionSynced, metav1.ConditionFalse, "SyncFailed", syncErr.Error())
+	} else {
+		r.setCondition(tmpl, idmv1.ConditionSynced, metav1.ConditionTrue, "Synced", fmt.Sprintf("%d users match the template", tmpl.Status.Users))
+	}
+	if syncErr == nil && tmpl.Status.ReadyUsers == tmpl.Status.Users {
+		r.setCondition(tmpl, idmv1.ConditionReady, metav1.ConditionTrue, "UsersReady", "All users are ready")
+	} else {
+		r.setCondition(tmpl, idmv1.ConditionReady, metav1.ConditionFalse, "UsersNotReady", fmt.Sprintf("%d of %d users are ready", tmpl.Status.ReadyUsers, tmpl.Status.Users))
+	}
+
+	if !equality.Semantic.DeepEqual(original.Status, tmpl.Status) {
+		err = patchStatus(ctx, r.Client, tmpl, original)
+		if err != nil {
+			log.Info("Failed to update UserTemplate status")
+			return ctrl.Result{}, err
+		}
+	}
+
+	return ctrl.Result{}, syncErr
+}
+
+// syncUsers creates or updates the User of every identity and deletes the owned Users
+// of identities no longer listed, then counts the Users in the status
+func (r *UserTemplateReconciler) syncUsers(ctx context.Context, tmpl *idmv1.UserTemplate) error {
+	log := log.FromContext(ctx)
+
+	identities, err := r.identities(ctx, tmpl)
+	if err != nil {
+		return err
+	}
+
+	existing := &idmv1.UserList{}
+	err = r.List(ctx, existing, client.InNamespace(tmpl.Namespace), client.MatchingLabels{idmv1.LabelUserTemplate: tmpl.Name})
+	if err != nil {
+		return err
+	}
+
+	desired := map[string]bool{}
+	var conflicts []string
+	for _, identity := range identities {
+		user := &idmv1.User{
+			ObjectMeta: metav1.ObjectMeta{
+				Namespace: tmpl.Namespace,
+				Name:      userObjectName(tmpl.Name + "-" + identity.Name),
+			},
+		}
+		desired[user.Name] = true
+
+		result, err := controllerutil.CreateOrUpdate(ctx, r.Client, user, func() error {
+			if !user.CreationTimestamp.IsZero() && !metav1.IsControlledBy(user, tmpl) {
+				return errUserNotOwned
+			}
+			stampUser(tmpl, identity, user)
+			return controllerutil.SetControllerReference(tmpl, user, r.Scheme)
+		})
+		if err == errUserNotOwned {
+			conflicts = append(conflicts, user.Name)
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if result != controllerutil.OperationResultNone {
+			log.Info("Stamped out user", "user", user.Name, "operation", result)
+		}
+	}
+
+	users, ready := 0, 0
+	for i := range existing.Items {
+		user := &existing.Items[i]
+		if !metav1.IsControlledBy(user, tmpl) {
+			continue
+		}
+		if !desired[user.Name] {
+			log.Info("Deleting user of removed identity", "user", user.Name)
+			err = r.Delete(ctx, user)
+			if client.IgnoreNotFound(err) != nil {
+				return err
+			}
+			continue
+		}
+		users++
+		if meta.IsStatusConditionTrue(user.Status.Conditions, idmv1.ConditionReady) {
+			ready++
+		}
+	}
+	// Users created above show up in the list once the cache caught up
+	if users < len(desired)-len(conflicts) {
+		users = len(desired) - len(conflicts)
+	}
+	tmpl.Status.Users = users
+	tmpl.Status.ReadyUsers = ready
+
+	if len(conflicts) > 0 {
+		return fmt.Errorf("users %s exist and are not owned by the template", strings.Join(conflicts, ", "))
+	}
+	return nil
+}
+
+// errUserNotOwned is returned for Users the template would stamp out that exist already
+// without being owned by it
+var errUserNotOwned = fmt.Errorf("user is not owned by the template")
+
+// identities returns the identities listed, read from the ConfigMap and generated by the
+// template. Later sources do not override identities of the same name.
+func (r *UserTemplateReconciler) identities(ctx context.Context, tmpl *idmv1.UserTemplate) ([]idmv1.UserTemplateIdentity, error) {
+	var identities []idmv1.UserTemplateIdentity
+	seen := map[string]bool{}
+	add := func(identity idmv1.UserTemplateIdentity) {
+		if identity.Name == "" || seen[identity.Name] {
+			return
+		}
+		seen[identity.Name] = true
+		identities = append(identities, identity)
+	}
+
+	for _, identity := range tmpl.Spec.Identities {
+		add(identity)
+	}
+
+	if ref := tmpl.Spec.IdentitiesFrom; ref != nil {
+		configMap := &corev1.ConfigMap{}
+		err := r.Get(ctx, types.NamespacedName{Namespace: tmpl.Namespace, Name: ref.Name}, configMap)
+		if err != nil {
+			return nil, err
+		}
+		value, ok := configMap.Data[ref.Key]
+		if !ok {
+			return nil, fmt.Errorf("key %q not found in configmap %s", ref.Key, ref.Name)
+		}
+		for _, line := range strings.Split(value, "\n") {
+			line = strings.TrimSpace(line)
+			if line == "" || strings.HasPrefix(line, "#") {
+				continue
+			}
+			add(idmv1.UserTemplateIdentity{Name: line})
+		}
+	}
+
+	if gen := tmpl.Spec.Generator; gen != nil {
+		for i := 0; i < gen.Count; i++ {
+			name := strings.ReplaceAll(gen.NamePattern, "{index}", strconv.Itoa(gen.StartIndex+i))
+			add(idmv1.UserTemplateIdentity{Name: name})
+		}
+	}
+
+	return identities, nil
+}
+
+// stampUser sets the labels, annotations and spec of the User from the template and the identity
+func stampUser(tmpl *idmv1.UserTemplate, identity idmv1.UserTemplateIdentity, user *idmv1.User) {
+	if user.Labels == nil {
+		user.Labels = map[string]string{}
+	}
+	for key, value := range tmpl.Spec.Template.Labels {
+		user.Labels[key] = value
+	}
+	user.Labels[idmv1.LabelUserTemplate] = tmpl.Name
+
+	if len(tmpl.Spec.Template.Annotations) > 0 && user.Annotations == nil {
+		user.Annotations = map[string]string{}
+	}
+	for key, value := range tmpl.Spec.Template.Annotations {
+		user.Annotations[key] = value
+	}
+
+	spec := tmpl.Spec.Template.Spec.DeepCopy()
+	spec.Name = identity.Name
+	if identity.Firstname != "" {
+		spec.Firstname = identity.Firstname
+	}
+	if identity.Lastname != "" {
+		spec.Lastname = identity.Lastname
+	}
+	if identity.Email != "" {
+		spec.Email = identity.Email
+	}
+	if identity.DisplayName != "" {
+		spec.DisplayName = identity.DisplayName
+	}
+	if ref := spec.PasswordSecretRef; ref != nil {
+		ref.Name = strings.ReplaceAll(ref.Name, idmv1.TemplateNamePlaceholder, identity.Name)
+	}
+	if rotation := spec.PasswordRotation; rotation != nil {
+		rotation.SecretRef.Name = strings.ReplaceAll(rotation.SecretRef.Name, idmv1.TemplateNamePlaceholder, identity.Name)
+	}
+	user.Spec = *spec
+}
+
+// setCondition sets the given condition on the template status, observed at the current generation
+func (r *UserTemplateReconciler) setCondition(tmpl *idmv1.UserTemplate, conditionType string, status metav1.ConditionStatus, reason, message string) {
+	meta.SetStatusCondition(&tmpl.Status.Conditions, metav1.Condition{
+		Type:               conditionType,
+		Status:             status,
+		ObservedGeneration: tmpl.Generation,
+		Reason:             reason,
+		Message:            message,
+	})
+}
+
+// configMapToTemplates maps a ConfigMap to the UserTemplates reading identities from it
+func (r *UserTemplateReconciler) configMapToTemplates(ctx context.Context, obj client.Object) []reconcile.Request {
+	templates := &idmv1.UserTemplateList{}
+	if err := r.List(ctx, templates, client.InNamespace(obj.GetNamespace())); err != nil {
+		log.FromContext(ctx).Error(err, "Failed to list UserTemplates")
+		return nil
+	}
+
+	var requests []reconcile.Request
+	for _, tmpl := range templates.Items {
+		if ref := tmpl.Spec.IdentitiesFrom; ref != nil && ref.Name == obj.GetName() {
+			requests = append(requests, reconcile.Request{
+				NamespacedName: types.NamespacedName{Namespace: tmpl.Namespace, Name: tmpl.Name},
+			})
+		}
+	}
+	return requests
+}
+
+// SetupWithManager sets up the controller with the Manager.
+func (r *UserTemplateReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&idmv1.UserTemplate{}).
+		Owns(&idmv1.User{}).
+		Watches(&corev1.ConfigMap{}, handler.EnqueueRequestsFromMapFunc(r.configMapToTemplates)).
+		WithOptions(r.Options.controllerOptions()).
+		Complete(withDrain(withCorrelationID(r)))
+}
@@ -0,0 +1,115 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	idmv1 "github.com/m15ch4/go-identity-operator/api/v1"
+)
+
+var _ = Describe("UserTemplate controller", func() {
+	var (
+		ctx        context.Context
+		reconciler *UserTemplateReconciler
+		tmpl       *idmv1.UserTemplate
+	)
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		reconciler = &UserTemplateReconciler{
+			Client:   k8sClient,
+			Scheme:   k8sClient.Scheme(),
+			Recorder: record.NewFakeRecorder(100),
+		}
+
+		tmpl = &idmv1.UserTemplate{
+			ObjectMeta: metav1.ObjectMeta{GenerateName: "tmpl-", Namespace: "default"},
+			Spec: idmv1.UserTemplateSpec{
+				Template: idmv1.UserTemplateUser{
+					Labels: map[string]string{"team": "support"},
+					Spec:   idmv1.UserSpec{Password: "secret", Role: "user"},
+				},
+				Identities: []idmv1.UserTemplateIdentity{
+					{Name: "anna", Email: "anna@example.com"},
+					{Name: "bert", Email: "bert@example.com"},
+				},
+			},
+		}
+		Expect(k8sClient.Create(ctx, tmpl)).To(Succeed())
+	})
+
+	AfterEach(func() {
+		// the test environment runs no garbage collector for the owned Users
+		Expect(k8sClient.DeleteAllOf(ctx, &idmv1.User{}, client.InNamespace("default"),
+			client.MatchingLabels{idmv1.LabelUserTemplate: tmpl.Name})).To(Succeed())
+		Expect(client.IgnoreNotFound(k8sClient.Delete(ctx, tmpl))).To(Succeed())
+	})
+
+	reconcileTemplate := func() *idmv1.UserTemplate {
+		_, err := reconciler.Reconcile(ctx, reconcile.Request{NamespacedName: client.ObjectKeyFromObject(tmpl)})
+		Expect(err).NotTo(HaveOccurred())
+		current := &idmv1.UserTemplate{}
+		Expect(k8sClient.Get(ctx, client.ObjectKeyFromObject(tmpl), current)).To(Succeed())
+		return current
+	}
+
+	stampedUsers := func() map[string]idmv1.User {
+		users := &idmv1.UserList{}
+		Expect(k8sClient.List(ctx, users, client.InNamespace("default"),
+			client.MatchingLabels{idmv1.LabelUserTemplate: tmpl.Name})).To(Succeed())
+		byName := map[string]idmv1.User{}
+		for _, user := range users.Items {
+			byName[user.Spec.Name] = user
+		}
+		return byName
+	}
+
+	It("stamps out, updates and deletes the Users of its identities", func() {
+		By("creating a User owned by the template for every identity")
+		current := reconcileTemplate()
+		Expect(current.Status.Users).To(Equal(2))
+		Expect(meta.IsStatusConditionTrue(current.Status.Conditions, idmv1.ConditionSynced)).To(BeTrue())
+		users := stampedUsers()
+		Expect(users).To(HaveLen(2))
+		anna := users["anna"]
+		Expect(anna.Spec.Email).To(Equal("anna@example.com"))
+		Expect(anna.Labels).To(HaveKeyWithValue("team", "support"))
+		Expect(metav1.IsControlledBy(&anna, current)).To(BeTrue())
+
+		By("updating the Users when the template changes")
+		current.Spec.Template.Spec.Role = "admin"
+		current.Spec.Identities = current.Spec.Identities[:1]
+		Expect(k8sClient.Update(ctx, current)).To(Succeed())
+		current = reconcileTemplate()
+		users = stampedUsers()
+		Expect(users["anna"].Spec.Role).To(Equal("admin"))
+
+		By("deleting the Users of removed identities")
+		Expect(users).NotTo(HaveKey("bert"))
+		Expect(current.Status.Users).To(Equal(1))
+	})
+})
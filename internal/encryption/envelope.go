@@ -0,0 +1,163 @@
+// Package encryption protects sensitive fields of the custom resources, e.g. initial
+// passwords, with envelope encryption for clusters without encryption of etcd at rest.
+// Every value is encrypted with its own data key, which is wrapped by a key encryption
+// key kept in a Secret or in a key management service.
+package encryption
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+
+	idmv1 "github.com/m15ch4/go-identity-operator/api/v1"
+)
+
+// dataKeySize is the size of the AES-256 data keys
+const dataKeySize = 32
+
+// ErrNotConfigured is returned when an encrypted value is read by an operator without
+// a key encryption key
+var ErrNotConfigured = errors.New("value is encrypted but no encryption key is configured")
+
+// InvalidError is returned for encrypted values that are malformed or cannot be decrypted
+// with the configured key, retrying does not help
+type InvalidError struct {
+	Reason string
+}
+
+func (e *InvalidError) Error() string {
+	return "cannot decrypt value: " + e.Reason
+}
+
+// IsPermanent reports whether err will not go away without a change of the encrypted value
+// or of the encryption configuration
+func IsPermanent(err error) bool {
+	var invalid *InvalidError
+	return errors.Is(err, ErrNotConfigured) || errors.As(err, &invalid)
+}
+
+// IsEncrypted reports whether the value was produced by Envelope.Encrypt
+func IsEncrypted(value string) bool {
+	return strings.HasPrefix(value, idmv1.EncryptedValuePrefix)
+}
+
+// KeyWrapper encrypts and decrypts data keys with a key encryption key
+type KeyWrapper interface {
+	// Name identifies the wrapper in the encrypted values, e.g. secret or vault
+	Name() string
+	// Wrap encrypts the data key and returns the ID of the key encryption key used
+	Wrap(ctx context.Context, dataKey []byte) (keyID string, wrapped []byte, err error)
+	// Unwrap decrypts a data key wrapped with the key encryption key of the given ID
+	Unwrap(ctx context.Context, keyID string, wrapped []byte) ([]byte, error)
+}
+
+// Envelope encrypts values with AES-256-GCM under a random data key wrapped by the
+// KeyWrapper. Encrypted values have the form
+// enc:v1:<wrapper>:<key ID>:<wrapped data key>:<nonce and ciphertext>, binary parts
+// encoded with unpadded URL-safe base64.
+type Envelope struct {
+	Wrapper KeyWrapper
+}
+
+// Encrypt returns the encrypted form of the plaintext
+func (e *Envelope) Encrypt(ctx context.Context, plaintext string) (string, error) {
+	if e == nil || e.Wrapper == nil {
+		return "", ErrNotConfigured
+	}
+
+	dataKey := make([]byte, dataKeySize)
+	if _, err := rand.Read(dataKey); err != nil {
+		return "", err
+	}
+	sealed, err := seal(dataKey, []byte(plaintext))
+	if err != nil {
+		return "", err
+	}
+	keyID, wrapped, err := e.Wrapper.Wrap(ctx, dataKey)
+	if err != nil {
+		return "", fmt.Errorf("failed to wrap the data key: %w", err)
+	}
+
+	return idmv1.EncryptedValuePrefix + strings.Join([]string{
+		e.Wrapper.Name(),
+		keyID,
+		base64.RawURLEncoding.EncodeToString(wrapped),
+		base64.RawURLEncoding.EncodeToString(sealed),
+	}, ":"), nil
+}
+
+// Decrypt returns the plaintext of an encrypted value. Values that are not encrypted are
+// returned unchanged, so fields can hold either form.
+func (e *Envelope) Decrypt(ctx context.Context, value string) (string, error) {
+	if !IsEncrypted(value) {
+		return value, nil
+	}
+	if e == nil || e.Wrapper == nil {
+		return "", ErrNotConfigured
+	}
+
+	parts := strings.Split(strings.TrimPrefix(value, idmv1.EncryptedValuePrefix), ":")
+	if len(parts) != 4 {
+		return "", &InvalidError{Reason: "malformed value"}
+	}
+	if parts[0] != e.Wrapper.Name() {
+		return "", &InvalidError{Reason: fmt.Sprintf("value was encrypted with %s keys, the operator uses %s keys", parts[0], e.Wrapper.Name())}
+	}
+	wrapped, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return "", &InvalidError{Reason: "malformed data key"}
+	}
+	sealed, err := base64.RawURLEncoding.DecodeString(parts[3])
+	if err != nil {
+		return "", &InvalidError{Reason: "malformed ciphertext"}
+	}
+
+	dataKey, err := e.Wrapper.Unwrap(ctx, parts[1], wrapped)
+	if err != nil {
+		return "", err
+	}
+	plaintext, err := open(dataKey, sealed)
+	if err != nil {
+		return "", &InvalidError{Reason: "ciphertext does not match its data key"}
+	}
+	return string(plaintext), nil
+}
+
+// seal encrypts the plaintext with AES-GCM and returns the nonce followed by the ciphertext
+func seal(key, plaintext []byte) ([]byte, error) {
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(nonce, nonce, plaintext, nil), nil
+}
+
+// open decrypts the output of seal
+func open(key, sealed []byte) ([]byte, error) {
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(sealed) < aead.NonceSize() {
+		return nil, errors.New("ciphertext too short")
+	}
+	nonce, ciphertext := sealed[:aead.NonceSize()], sealed[aead.NonceSize():]
+	return aead.Open(nil, nonce, ciphertext, nil)
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
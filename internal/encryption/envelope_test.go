@@ -0,0 +1,149 @@
+package encryption
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+var _ = Describe("Envelope", func() {
+	ctx := context.Background()
+
+	var (
+		secret  *corev1.Secret
+		wrapper *SecretKeyWrapper
+	)
+
+	BeforeEach(func() {
+		secret = &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "idm-system", Name: "idm-encryption"},
+			Data: map[string][]byte{
+				"old": bytes.Repeat([]byte{1}, dataKeySize),
+				"new": bytes.Repeat([]byte{2}, dataKeySize),
+			},
+		}
+		wrapper = &SecretKeyWrapper{
+			Reader:  fake.NewClientBuilder().WithObjects(secret).Build(),
+			Secret:  types.NamespacedName{Namespace: secret.Namespace, Name: secret.Name},
+			KeyName: "old",
+		}
+	})
+
+	It("decrypts what it encrypted", func() {
+		envelope := &Envelope{Wrapper: wrapper}
+		encrypted, err := envelope.Encrypt(ctx, "s3cret!")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(IsEncrypted(encrypted)).To(BeTrue())
+		Expect(encrypted).NotTo(ContainSubstring("s3cret!"))
+
+		decrypted, err := envelope.Decrypt(ctx, encrypted)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(decrypted).To(Equal("s3cret!"))
+
+		again, err := envelope.Encrypt(ctx, "s3cret!")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(again).NotTo(Equal(encrypted))
+	})
+
+	It("keeps decrypting values of a rotated key", func() {
+		envelope := &Envelope{Wrapper: wrapper}
+		encrypted, err := envelope.Encrypt(ctx, "s3cret!")
+		Expect(err).NotTo(HaveOccurred())
+
+		wrapper.KeyName = "new"
+		decrypted, err := envelope.Decrypt(ctx, encrypted)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(decrypted).To(Equal("s3cret!"))
+		rotated, err := envelope.Encrypt(ctx, "s3cret!")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(rotated).To(ContainSubstring(":new:"))
+	})
+
+	It("returns values that are not encrypted unchanged", func() {
+		var envelope *Envelope
+		decrypted, err := envelope.Decrypt(ctx, "plain")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(decrypted).To(Equal("plain"))
+	})
+
+	It("rejects values it cannot decrypt permanently", func() {
+		envelope := &Envelope{Wrapper: wrapper}
+		encrypted, err := envelope.Encrypt(ctx, "s3cret!")
+		Expect(err).NotTo(HaveOccurred())
+
+		By("without a key encryption key")
+		_, err = (&Envelope{}).Decrypt(ctx, encrypted)
+		Expect(err).To(MatchError(ErrNotConfigured))
+		Expect(IsPermanent(err)).To(BeTrue())
+
+		By("with a tampered ciphertext")
+		parts := strings.Split(encrypted, ":")
+		sealed, err := base64.RawURLEncoding.DecodeString(parts[len(parts)-1])
+		Expect(err).NotTo(HaveOccurred())
+		sealed[len(sealed)-1] ^= 1
+		parts[len(parts)-1] = base64.RawURLEncoding.EncodeToString(sealed)
+		_, err = envelope.Decrypt(ctx, strings.Join(parts, ":"))
+		Expect(err).To(MatchError(ContainSubstring("ciphertext does not match")))
+		Expect(IsPermanent(err)).To(BeTrue())
+
+		By("with a missing key")
+		delete(secret.Data, "old")
+		wrapper.Reader = fake.NewClientBuilder().WithObjects(secret).Build()
+		_, err = envelope.Decrypt(ctx, encrypted)
+		Expect(IsPermanent(err)).To(BeTrue())
+		Expect(err.Error()).To(ContainSubstring(`key "old" not found`))
+
+		By("with keys of another wrapper")
+		_, err = (&Envelope{Wrapper: &VaultTransitWrapper{}}).Decrypt(ctx, encrypted)
+		Expect(IsPermanent(err)).To(BeTrue())
+	})
+
+	It("wraps data keys with a Vault transit key", func() {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			Expect(req.Header.Get("X-Vault-Token")).To(Equal("token"))
+			body := map[string]string{}
+			Expect(json.NewDecoder(req.Body).Decode(&body)).To(Succeed())
+			switch req.URL.Path {
+			case "/v1/transit/encrypt/idm":
+				_ = json.NewEncoder(w).Encode(map[string]interface{}{
+					"data": map[string]string{"ciphertext": "vault:v1:" + body["plaintext"]},
+				})
+			case "/v1/transit/decrypt/idm":
+				if !strings.HasPrefix(body["ciphertext"], "vault:v1:") {
+					http.Error(w, "invalid ciphertext", http.StatusBadRequest)
+					return
+				}
+				_ = json.NewEncoder(w).Encode(map[string]interface{}{
+					"data": map[string]string{"plaintext": strings.TrimPrefix(body["ciphertext"], "vault:v1:")},
+				})
+			default:
+				http.NotFound(w, req)
+			}
+		}))
+		DeferCleanup(server.Close)
+		vault := &VaultTransitWrapper{Address: server.URL, Key: "idm", Token: "token"}
+		envelope := &Envelope{Wrapper: vault}
+
+		encrypted, err := envelope.Encrypt(ctx, "s3cret!")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(encrypted).To(HavePrefix("enc:v1:vault:idm:"))
+		decrypted, err := envelope.Decrypt(ctx, encrypted)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(decrypted).To(Equal("s3cret!"))
+
+		_, err = vault.Unwrap(ctx, "idm", []byte(base64.StdEncoding.EncodeToString([]byte("garbage"))))
+		Expect(IsPermanent(err)).To(BeTrue())
+	})
+})
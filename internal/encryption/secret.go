@@ -0,0 +1,75 @@
+package encryption
+
+import (
+	"context"
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// DefaultSecretKeyName is the key of the Secret holding the current key encryption key
+const DefaultSecretKeyName = "key"
+
+// SecretKeyWrapper wraps data keys with 32 byte AES keys kept in a Secret of the cluster.
+// The Secret is read on every use, so keys can be rotated by adding a new key, switching
+// KeyName to it and keeping the old one until the values encrypted with it are replaced.
+type SecretKeyWrapper struct {
+	Reader client.Reader
+	Secret types.NamespacedName
+	// KeyName is the key of the Secret new values are encrypted with
+	KeyName string
+}
+
+var _ KeyWrapper = &SecretKeyWrapper{}
+
+func (w *SecretKeyWrapper) Name() string {
+	return "secret"
+}
+
+// Wrap encrypts the data key with the current key of the Secret
+func (w *SecretKeyWrapper) Wrap(ctx context.Context, dataKey []byte) (string, []byte, error) {
+	keyName := w.KeyName
+	if keyName == "" {
+		keyName = DefaultSecretKeyName
+	}
+	kek, err := w.key(ctx, keyName)
+	if err != nil {
+		return "", nil, err
+	}
+	wrapped, err := seal(kek, dataKey)
+	if err != nil {
+		return "", nil, err
+	}
+	return keyName, wrapped, nil
+}
+
+// Unwrap decrypts the data key with the key of the Secret it was wrapped with
+func (w *SecretKeyWrapper) Unwrap(ctx context.Context, keyID string, wrapped []byte) ([]byte, error) {
+	kek, err := w.key(ctx, keyID)
+	if err != nil {
+		return nil, err
+	}
+	dataKey, err := open(kek, wrapped)
+	if err != nil {
+		return nil, &InvalidError{Reason: fmt.Sprintf("data key was not wrapped with key %q of secret %s", keyID, w.Secret)}
+	}
+	return dataKey, nil
+}
+
+// key reads the key encryption key of the given name from the Secret
+func (w *SecretKeyWrapper) key(ctx context.Context, name string) ([]byte, error) {
+	secret := &corev1.Secret{}
+	if err := w.Reader.Get(ctx, w.Secret, secret); err != nil {
+		return nil, err
+	}
+	kek, ok := secret.Data[name]
+	if !ok {
+		return nil, &InvalidError{Reason: fmt.Sprintf("key %q not found in secret %s", name, w.Secret)}
+	}
+	if len(kek) != dataKeySize {
+		return nil, &InvalidError{Reason: fmt.Sprintf("key %q of secret %s must be %d bytes", name, w.Secret, dataKeySize)}
+	}
+	return kek, nil
+}
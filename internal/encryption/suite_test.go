@@ -0,0 +1,14 @@
+package encryption
+
+import (
+	"testing"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+func TestEncryption(t *testing.T) {
+	RegisterFailHandler(Fail)
+
+	RunSpecs(t, "Encryption Suite")
+}
@@ -0,0 +1,112 @@
+package encryption
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// DefaultVaultMount is the path the transit secrets engine is mounted at by default
+const DefaultVaultMount = "transit"
+
+// VaultTransitWrapper wraps data keys with a key of the transit secrets engine of
+// HashiCorp Vault, or of compatible key management services, so the key encryption key
+// never leaves the service
+type VaultTransitWrapper struct {
+	// Address of Vault, e.g. https://vault.example.com:8200
+	Address string
+	// Mount is the path of the transit secrets engine, DefaultVaultMount when empty
+	Mount string
+	// Key is the name of the transit key new values are encrypted with
+	Key string
+	// Token authenticates the requests
+	Token string
+	// Client sends the requests, http.DefaultClient when nil
+	Client *http.Client
+}
+
+var _ KeyWrapper = &VaultTransitWrapper{}
+
+func (w *VaultTransitWrapper) Name() string {
+	return "vault"
+}
+
+// Wrap encrypts the data key with the transit key
+func (w *VaultTransitWrapper) Wrap(ctx context.Context, dataKey []byte) (string, []byte, error) {
+	var resp struct {
+		Data struct {
+			Ciphertext string `json:"ciphertext"`
+		} `json:"data"`
+	}
+	err := w.call(ctx, "encrypt", w.Key, map[string]string{"plaintext": base64.StdEncoding.EncodeToString(dataKey)}, &resp)
+	if err != nil {
+		return "", nil, err
+	}
+	return w.Key, []byte(resp.Data.Ciphertext), nil
+}
+
+// Unwrap decrypts the data key with the transit key it was wrapped with
+func (w *VaultTransitWrapper) Unwrap(ctx context.Context, keyID string, wrapped []byte) ([]byte, error) {
+	var resp struct {
+		Data struct {
+			Plaintext string `json:"plaintext"`
+		} `json:"data"`
+	}
+	err := w.call(ctx, "decrypt", keyID, map[string]string{"ciphertext": string(wrapped)}, &resp)
+	if err != nil {
+		return nil, err
+	}
+	dataKey, err := base64.StdEncoding.DecodeString(resp.Data.Plaintext)
+	if err != nil {
+		return nil, &InvalidError{Reason: "vault returned a malformed data key"}
+	}
+	return dataKey, nil
+}
+
+// call posts the request to the encrypt or decrypt endpoint of the transit key
+func (w *VaultTransitWrapper) call(ctx context.Context, operation, key string, in, out interface{}) error {
+	mount := w.Mount
+	if mount == "" {
+		mount = DefaultVaultMount
+	}
+	body, err := json.Marshal(in)
+	if err != nil {
+		return err
+	}
+	url := strings.TrimSuffix(w.Address, "/") + "/v1/" + strings.Trim(mount, "/") + "/" + operation + "/" + key
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Vault-Token", w.Token)
+
+	httpClient := w.Client
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		err := fmt.Errorf("vault %s with key %s returned status %d: %s", operation, key, resp.StatusCode, strings.TrimSpace(string(data)))
+		// a ciphertext Vault cannot decrypt is rejected with 400
+		if operation == "decrypt" && resp.StatusCode == http.StatusBadRequest {
+			return &InvalidError{Reason: err.Error()}
+		}
+		return err
+	}
+	return json.Unmarshal(data, out)
+}
@@ -0,0 +1,187 @@
+// Package notify sends lifecycle events of the managed identities to downstream systems,
+// e.g. HR or ticketing, so they learn about changes without polling.
+package notify
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+// EventType is the kind of change of an identity
+type EventType string
+
+const (
+	UserCreated EventType = "UserCreated"
+	UserUpdated EventType = "UserUpdated"
+	UserDeleted EventType = "UserDeleted"
+)
+
+// Event describes a change of an identity in the identity system
+type Event struct {
+	Type EventType `json:"type"`
+	Time time.Time `json:"time"`
+	// Namespace and Name of the User
+	Namespace string `json:"namespace"`
+	Name      string `json:"name"`
+	// ID and Username of the user in the identity system
+	ID       string `json:"id,omitempty"`
+	Username string `json:"username,omitempty"`
+	// Fields lists the changed fields of an update
+	Fields []string `json:"fields,omitempty"`
+}
+
+// Notifier delivers events. Notify must not block the caller.
+type Notifier interface {
+	Notify(event Event)
+}
+
+// SignatureHeader carries the hex encoded HMAC-SHA256 of the request body, prefixed with
+// "sha256=", when the webhook has a secret
+const SignatureHeader = "X-Signature-256"
+
+const (
+	// queueSize is the number of events buffered for delivery, further events are dropped
+	queueSize = 1000
+	// deliveryAttempts is the number of attempts to deliver an event
+	deliveryAttempts = 3
+	// retryDelay is the delay before the first retry, doubled for every further retry
+	retryDelay = time.Second
+)
+
+// notificationsTotal counts the events sent to the webhook by result
+var notificationsTotal = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "identity_notifications_total",
+		Help: "Number of lifecycle events sent to the notification webhook by result.",
+	},
+	[]string{"result"},
+)
+
+func init() {
+	metrics.Registry.MustRegister(notificationsTotal)
+}
+
+// Webhook posts events as JSON to a URL in the background, retrying failed deliveries.
+// It runs as a manager runnable on the leader, which is the replica producing events.
+type Webhook struct {
+	url    string
+	secret []byte
+	client *http.Client
+	queue  chan Event
+}
+
+var _ manager.LeaderElectionRunnable = &Webhook{}
+
+// NewWebhook returns a webhook notifier posting to url, signing the requests with secret
+// unless it is empty
+func NewWebhook(url string, secret []byte) *Webhook {
+	return &Webhook{
+		url:    url,
+		secret: secret,
+		client: &http.Client{Timeout: 10 * time.Second},
+		queue:  make(chan Event, queueSize),
+	}
+}
+
+// Notify queues the event for delivery, dropping it when the queue is full
+func (w *Webhook) Notify(event Event) {
+	select {
+	case w.queue <- event:
+	default:
+		notificationsTotal.WithLabelValues("dropped").Inc()
+	}
+}
+
+// Start delivers the queued events until the context is cancelled
+func (w *Webhook) Start(ctx context.Context) error {
+	log := log.FromContext(ctx).WithName("notify")
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case event := <-w.queue:
+			if err := w.deliver(ctx, event); err != nil {
+				log.Error(err, "Failed to deliver notification", "type", event.Type, "namespace", event.Namespace, "name", event.Name)
+			}
+		}
+	}
+}
+
+// NeedLeaderElection is true, only the leader reconciles and produces events
+func (w *Webhook) NeedLeaderElection() bool {
+	return true
+}
+
+// deliver sends the event, retrying with an exponential backoff
+func (w *Webhook) deliver(ctx context.Context, event Event) error {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	delay := retryDelay
+	for attempt := 1; ; attempt++ {
+		err = w.send(ctx, body)
+		if err == nil {
+			notificationsTotal.WithLabelValues("success").Inc()
+			return nil
+		}
+		if attempt == deliveryAttempts {
+			notificationsTotal.WithLabelValues("failure").Inc()
+			return err
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(delay):
+		}
+		delay *= 2
+	}
+}
+
+// send posts the body once
+func (w *Webhook) send(ctx context.Context, body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, "POST", w.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if len(w.secret) > 0 {
+		req.Header.Set(SignatureHeader, "sha256="+Sign(w.secret, body))
+	}
+
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, resp.Body)
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("notification webhook returned status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// Sign returns the hex encoded HMAC-SHA256 of body, receivers compare it with the
+// SignatureHeader to verify the event was sent by the operator
+func Sign(secret, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,103 @@
+package notify
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Webhook", func() {
+	// received is a request received by the test server
+	type received struct {
+		signature string
+		event     Event
+	}
+
+	var (
+		requests chan received
+		failures int32
+		server   *httptest.Server
+	)
+
+	BeforeEach(func() {
+		requests = make(chan received, 10)
+		atomic.StoreInt32(&failures, 0)
+		server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			if atomic.AddInt32(&failures, -1) >= 0 {
+				w.WriteHeader(http.StatusServiceUnavailable)
+				return
+			}
+			body, err := io.ReadAll(req.Body)
+			Expect(err).NotTo(HaveOccurred())
+			r := received{signature: req.Header.Get(SignatureHeader)}
+			Expect(json.Unmarshal(body, &r.event)).To(Succeed())
+			if r.signature != "" {
+				Expect(r.signature).To(Equal("sha256=" + Sign([]byte("secret"), body)))
+			}
+			requests <- r
+		}))
+		DeferCleanup(server.Close)
+	})
+
+	start := func(w *Webhook) {
+		ctx, cancel := context.WithCancel(context.Background())
+		DeferCleanup(cancel)
+		go func() {
+			defer GinkgoRecover()
+			Expect(w.Start(ctx)).To(Succeed())
+		}()
+	}
+
+	It("posts signed events", func() {
+		w := NewWebhook(server.URL, []byte("secret"))
+		start(w)
+		w.Notify(Event{Type: UserUpdated, Namespace: "default", Name: "jackr", ID: "1", Fields: []string{"email"}})
+
+		var r received
+		Eventually(requests).Should(Receive(&r))
+		Expect(r.signature).NotTo(BeEmpty())
+		Expect(r.event.Type).To(Equal(UserUpdated))
+		Expect(r.event.Fields).To(Equal([]string{"email"}))
+	})
+
+	It("posts unsigned events without a secret", func() {
+		w := NewWebhook(server.URL, nil)
+		start(w)
+		w.Notify(Event{Type: UserCreated, Namespace: "default", Name: "jackr"})
+
+		var r received
+		Eventually(requests).Should(Receive(&r))
+		Expect(r.signature).To(BeEmpty())
+	})
+
+	It("retries failed deliveries", func() {
+		atomic.StoreInt32(&failures, 1)
+		w := NewWebhook(server.URL, nil)
+		start(w)
+		w.Notify(Event{Type: UserDeleted, Namespace: "default", Name: "jackr"})
+
+		var r received
+		Eventually(requests, 3*retryDelay).Should(Receive(&r))
+		Expect(r.event.Type).To(Equal(UserDeleted))
+	})
+
+	It("drops events once the queue is full instead of blocking", func() {
+		w := NewWebhook(server.URL, nil)
+		done := make(chan struct{})
+		go func() {
+			for i := 0; i <= queueSize; i++ {
+				w.Notify(Event{Type: UserCreated, Time: time.Now()})
+			}
+			close(done)
+		}()
+		Eventually(done).Should(BeClosed())
+		Expect(w.queue).To(HaveLen(queueSize))
+	})
+})
@@ -0,0 +1,70 @@
+// Package status maintains the status pattern shared by the managed objects: the ID in
+// the identity system, the Ready, Synced, Degraded and Stalled conditions observed at a
+// generation, and the generation and time of the last successful sync.
+package status
+
+import (
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	idmv1 "github.com/m15ch4/go-identity-operator/api/v1"
+)
+
+// Object is a managed object whose status holds conditions
+type Object interface {
+	client.Object
+	GetConditions() []metav1.Condition
+	SetConditions(conditions []metav1.Condition)
+}
+
+// SyncedObject is a managed object whose status records its last successful sync
+type SyncedObject interface {
+	Object
+	SetLastSync(generation int64, at metav1.Time)
+}
+
+// SetCondition sets the given condition on the status of obj, observed at its current generation
+func SetCondition(obj Object, conditionType string, status metav1.ConditionStatus, reason, message string) {
+	conditions := obj.GetConditions()
+	meta.SetStatusCondition(&conditions, metav1.Condition{
+		Type:               conditionType,
+		Status:             status,
+		ObservedGeneration: obj.GetGeneration(),
+		Reason:             reason,
+		Message:            message,
+	})
+	obj.SetConditions(conditions)
+}
+
+// MarkSynced marks obj as ready and in sync with the identity system at its current generation
+func MarkSynced(obj SyncedObject, reason, message string) {
+	obj.SetLastSync(obj.GetGeneration(), metav1.Now())
+	SetCondition(obj, idmv1.ConditionReady, metav1.ConditionTrue, reason, message)
+	SetCondition(obj, idmv1.ConditionSynced, metav1.ConditionTrue, reason, message)
+	SetCondition(obj, idmv1.ConditionDegraded, metav1.ConditionFalse, reason, message)
+	SetCondition(obj, idmv1.ConditionStalled, metav1.ConditionFalse, reason, message)
+}
+
+// MarkFailed records a failed sync of obj. Terminal failures also stall obj until its spec
+// changes. Ready is left alone while obj is being deleted.
+func MarkFailed(obj Object, reason, message string, terminal bool) {
+	SetCondition(obj, idmv1.ConditionDegraded, metav1.ConditionTrue, reason, message)
+	SetCondition(obj, idmv1.ConditionSynced, metav1.ConditionFalse, reason, message)
+	if !meta.IsStatusConditionTrue(obj.GetConditions(), idmv1.ConditionDeleting) {
+		SetCondition(obj, idmv1.ConditionReady, metav1.ConditionFalse, reason, message)
+	}
+	if terminal {
+		SetCondition(obj, idmv1.ConditionStalled, metav1.ConditionTrue, reason, message)
+	}
+}
+
+// Stalled returns the Stalled condition of obj if it stalled at its current generation,
+// i.e. a terminal failure that is not retried until the spec changes
+func Stalled(obj Object) *metav1.Condition {
+	stalled := meta.FindStatusCondition(obj.GetConditions(), idmv1.ConditionStalled)
+	if stalled == nil || stalled.Status != metav1.ConditionTrue || stalled.ObservedGeneration != obj.GetGeneration() {
+		return nil
+	}
+	return stalled
+}
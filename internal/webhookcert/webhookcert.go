@@ -0,0 +1,329 @@
+// Package webhookcert provides the serving certificate of the webhook server in clusters
+// without cert-manager. A self-signed CA and a serving certificate for the webhook Service
+// are kept in a Secret shared by all replicas, written to the certificate directory the
+// webhook server watches and injected as CA bundle into the webhook configurations and
+// the conversion webhooks of the CustomResourceDefinitions that call the Service.
+package webhookcert
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"time"
+
+	admissionregistrationv1 "k8s.io/api/admissionregistration/v1"
+	corev1 "k8s.io/api/core/v1"
+	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+// Keys of the Secret and names of the files in the certificate directory, the same as in
+// the Secrets issued by cert-manager
+const (
+	CertName = "tls.crt"
+	KeyName  = "tls.key"
+	CAName   = "ca.crt"
+)
+
+// DefaultValidity is the validity of the generated certificates
+const DefaultValidity = 365 * 24 * time.Hour
+
+// checkInterval is the interval at which the certificate is checked for rotation
+const checkInterval = time.Hour
+
+// Mounted reports whether dir holds a serving certificate, e.g. one issued by cert-manager
+// and mounted from its Secret
+func Mounted(dir string) bool {
+	_, err := os.Stat(filepath.Join(dir, CertName))
+	return err == nil
+}
+
+//+kubebuilder:rbac:groups=admissionregistration.k8s.io,resources=validatingwebhookconfigurations;mutatingwebhookconfigurations,verbs=get;list;patch
+//+kubebuilder:rbac:groups=apiextensions.k8s.io,resources=customresourcedefinitions,verbs=get;list;patch
+
+// Generator keeps the self-signed serving certificate of the webhook Service. Certificates
+// are replaced once less than a third of their validity is left; the webhook server picks
+// up the new files without a restart.
+type Generator struct {
+	// Client reads and writes the Secret, the webhook configurations and the
+	// CustomResourceDefinitions without a cache
+	Client client.Client
+	// Secret holds the CA and the serving certificate
+	Secret types.NamespacedName
+	// Service is the webhook Service the certificate is issued for
+	Service types.NamespacedName
+	// CertDir is the directory the webhook server reads the certificate from
+	CertDir string
+	// Validity of the certificates, DefaultValidity when zero
+	Validity time.Duration
+}
+
+// Ensure makes sure a valid certificate is in the Secret and in CertDir and that the CA
+// bundle of every webhook calling the Service is the CA of the certificate. It is called
+// before the webhook server starts, which needs the files.
+func (g *Generator) Ensure(ctx context.Context) error {
+	secret := &corev1.Secret{}
+	err := g.Client.Get(ctx, g.Secret, secret)
+	if errors.IsNotFound(err) {
+		secret = &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Namespace: g.Secret.Namespace, Name: g.Secret.Name},
+			Type:       corev1.SecretTypeTLS,
+		}
+		secret.Data, err = g.generate()
+		if err != nil {
+			return err
+		}
+		err = g.Client.Create(ctx, secret)
+		// another replica was faster, use its certificate
+		if errors.IsAlreadyExists(err) {
+			return g.Ensure(ctx)
+		}
+	} else if err == nil && !g.valid(secret.Data) {
+		secret.Data, err = g.generate()
+		if err != nil {
+			return err
+		}
+		err = g.Client.Update(ctx, secret)
+		if errors.IsConflict(err) {
+			return g.Ensure(ctx)
+		}
+		if err == nil {
+			log.FromContext(ctx).Info("Rotated webhook serving certificate", "secret", g.Secret)
+		}
+	}
+	if err != nil {
+		return err
+	}
+
+	if err := g.writeFiles(secret.Data); err != nil {
+		return err
+	}
+	return g.injectCABundle(ctx, secret.Data[CAName])
+}
+
+// Start checks the certificate periodically until ctx is done, so it is rotated in time
+// and webhook configurations applied later get the CA bundle
+func (g *Generator) Start(ctx context.Context) error {
+	ticker := time.NewTicker(checkInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+			if err := g.Ensure(ctx); err != nil {
+				log.FromContext(ctx).Error(err, "Failed to ensure webhook serving certificate")
+			}
+		}
+	}
+}
+
+// NeedLeaderElection is false, every replica serves webhooks and needs the files
+func (g *Generator) NeedLeaderElection() bool {
+	return false
+}
+
+func (g *Generator) validity() time.Duration {
+	if g.Validity > 0 {
+		return g.Validity
+	}
+	return DefaultValidity
+}
+
+// valid reports whether data holds a certificate of the Service that is not due for rotation
+func (g *Generator) valid(data map[string][]byte) bool {
+	pair, err := tls.X509KeyPair(data[CertName], data[KeyName])
+	if err != nil || len(data[CAName]) == 0 {
+		return false
+	}
+	cert, err := x509.ParseCertificate(pair.Certificate[0])
+	if err != nil {
+		return false
+	}
+	if time.Until(cert.NotAfter) < cert.NotAfter.Sub(cert.NotBefore)/3 {
+		return false
+	}
+	return cert.VerifyHostname(g.dnsNames()[2]) == nil
+}
+
+// dnsNames are the names the Service is reached by
+func (g *Generator) dnsNames() []string {
+	name, namespace := g.Service.Name, g.Service.Namespace
+	return []string{
+		name,
+		name + "." + namespace,
+		name + "." + namespace + ".svc",
+		name + "." + namespace + ".svc.cluster.local",
+	}
+}
+
+// generate creates a CA and a serving certificate of the Service signed by it
+func (g *Generator) generate() (map[string][]byte, error) {
+	notBefore := time.Now().Add(-time.Hour)
+	notAfter := notBefore.Add(g.validity())
+
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	caTemplate := &x509.Certificate{
+		SerialNumber:          serialNumber(),
+		Subject:               pkix.Name{CommonName: g.Service.Name + "-ca"},
+		NotBefore:             notBefore,
+		NotAfter:              notAfter,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	caDER, err := x509.CreateCertificate(rand.Reader, caTemplate, caTemplate, &caKey.PublicKey, caKey)
+	if err != nil {
+		return nil, err
+	}
+	ca, err := x509.ParseCertificate(caDER)
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	template := &x509.Certificate{
+		SerialNumber: serialNumber(),
+		Subject:      pkix.Name{CommonName: g.dnsNames()[2]},
+		DNSNames:     g.dnsNames(),
+		NotBefore:    notBefore,
+		NotAfter:     notAfter,
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	certDER, err := x509.CreateCertificate(rand.Reader, template, ca, &key.PublicKey, caKey)
+	if err != nil {
+		return nil, err
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return map[string][]byte{
+		CertName: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certDER}),
+		KeyName:  pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}),
+		CAName:   pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: caDER}),
+	}, nil
+}
+
+func serialNumber() *big.Int {
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return big.NewInt(time.Now().UnixNano())
+	}
+	return serial
+}
+
+// writeFiles writes the certificate to CertDir unless the files are up to date. The key is
+// written before the certificate, which the webhook server watches.
+func (g *Generator) writeFiles(data map[string][]byte) error {
+	if err := os.MkdirAll(g.CertDir, 0o700); err != nil {
+		return err
+	}
+	for _, name := range []string{KeyName, CAName, CertName} {
+		path := filepath.Join(g.CertDir, name)
+		current, err := os.ReadFile(path)
+		if err == nil && bytes.Equal(current, data[name]) {
+			continue
+		}
+		if err := os.WriteFile(path, data[name], 0o600); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// injectCABundle sets the CA bundle of the webhooks and conversion webhooks calling the
+// Service
+func (g *Generator) injectCABundle(ctx context.Context, caBundle []byte) error {
+	calls := func(config *admissionregistrationv1.WebhookClientConfig) bool {
+		return config.Service != nil && config.Service.Name == g.Service.Name && config.Service.Namespace == g.Service.Namespace &&
+			!bytes.Equal(config.CABundle, caBundle)
+	}
+
+	validating := &admissionregistrationv1.ValidatingWebhookConfigurationList{}
+	if err := g.Client.List(ctx, validating); err != nil {
+		return err
+	}
+	for i := range validating.Items {
+		config := &validating.Items[i]
+		patch := client.MergeFrom(config.DeepCopy())
+		changed := false
+		for j := range config.Webhooks {
+			if calls(&config.Webhooks[j].ClientConfig) {
+				config.Webhooks[j].ClientConfig.CABundle = caBundle
+				changed = true
+			}
+		}
+		if changed {
+			if err := g.Client.Patch(ctx, config, patch); err != nil {
+				return fmt.Errorf("failed to inject CA bundle into %s: %w", config.Name, err)
+			}
+		}
+	}
+
+	mutating := &admissionregistrationv1.MutatingWebhookConfigurationList{}
+	if err := g.Client.List(ctx, mutating); err != nil {
+		return err
+	}
+	for i := range mutating.Items {
+		config := &mutating.Items[i]
+		patch := client.MergeFrom(config.DeepCopy())
+		changed := false
+		for j := range config.Webhooks {
+			if calls(&config.Webhooks[j].ClientConfig) {
+				config.Webhooks[j].ClientConfig.CABundle = caBundle
+				changed = true
+			}
+		}
+		if changed {
+			if err := g.Client.Patch(ctx, config, patch); err != nil {
+				return fmt.Errorf("failed to inject CA bundle into %s: %w", config.Name, err)
+			}
+		}
+	}
+
+	crds := &apiextensionsv1.CustomResourceDefinitionList{}
+	if err := g.Client.List(ctx, crds); err != nil {
+		return err
+	}
+	for i := range crds.Items {
+		crd := &crds.Items[i]
+		conversion := crd.Spec.Conversion
+		if conversion == nil || conversion.Webhook == nil || conversion.Webhook.ClientConfig == nil {
+			continue
+		}
+		service := conversion.Webhook.ClientConfig.Service
+		if service == nil || service.Name != g.Service.Name || service.Namespace != g.Service.Namespace ||
+			bytes.Equal(conversion.Webhook.ClientConfig.CABundle, caBundle) {
+			continue
+		}
+		patch := client.MergeFrom(crd.DeepCopy())
+		conversion.Webhook.ClientConfig.CABundle = caBundle
+		if err := g.Client.Patch(ctx, crd, patch); err != nil {
+			return fmt.Errorf("failed to inject CA bundle into %s: %w", crd.Name, err)
+		}
+	}
+	return nil
+}
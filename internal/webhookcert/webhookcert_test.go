@@ -0,0 +1,143 @@
+package webhookcert
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	admissionregistrationv1 "k8s.io/api/admissionregistration/v1"
+	corev1 "k8s.io/api/core/v1"
+	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+var _ = Describe("Generator", func() {
+	ctx := context.Background()
+	service := types.NamespacedName{Namespace: "idm-system", Name: "webhook-service"}
+
+	var (
+		c         client.Client
+		generator *Generator
+	)
+
+	// webhookConfig returns a webhook configuration calling the Service with the given name
+	webhookConfig := func(name, serviceName string) *admissionregistrationv1.ValidatingWebhookConfiguration {
+		none := admissionregistrationv1.SideEffectClassNone
+		return &admissionregistrationv1.ValidatingWebhookConfiguration{
+			ObjectMeta: metav1.ObjectMeta{Name: name},
+			Webhooks: []admissionregistrationv1.ValidatingWebhook{{
+				Name: name + ".kb.io",
+				ClientConfig: admissionregistrationv1.WebhookClientConfig{
+					Service: &admissionregistrationv1.ServiceReference{Namespace: service.Namespace, Name: serviceName},
+				},
+				SideEffects:             &none,
+				AdmissionReviewVersions: []string{"v1"},
+			}},
+		}
+	}
+
+	BeforeEach(func() {
+		scheme := runtime.NewScheme()
+		Expect(clientgoscheme.AddToScheme(scheme)).To(Succeed())
+		Expect(apiextensionsv1.AddToScheme(scheme)).To(Succeed())
+		crd := &apiextensionsv1.CustomResourceDefinition{
+			ObjectMeta: metav1.ObjectMeta{Name: "users.idm.micze.io"},
+			Spec: apiextensionsv1.CustomResourceDefinitionSpec{
+				Conversion: &apiextensionsv1.CustomResourceConversion{
+					Strategy: apiextensionsv1.WebhookConverter,
+					Webhook: &apiextensionsv1.WebhookConversion{
+						ClientConfig: &apiextensionsv1.WebhookClientConfig{
+							Service: &apiextensionsv1.ServiceReference{Namespace: service.Namespace, Name: service.Name},
+						},
+					},
+				},
+			},
+		}
+		c = fake.NewClientBuilder().
+			WithScheme(scheme).
+			WithObjects(crd, webhookConfig("validating", service.Name), webhookConfig("other", "other-service")).
+			Build()
+		generator = &Generator{
+			Client:  c,
+			Secret:  types.NamespacedName{Namespace: service.Namespace, Name: "webhook-cert"},
+			Service: service,
+			CertDir: GinkgoT().TempDir(),
+		}
+	})
+
+	readSecret := func() map[string][]byte {
+		secret := &corev1.Secret{}
+		Expect(c.Get(ctx, generator.Secret, secret)).To(Succeed())
+		return secret.Data
+	}
+
+	It("issues a certificate of the Service and injects its CA bundle", func() {
+		Expect(Mounted(generator.CertDir)).To(BeFalse())
+		Expect(generator.Ensure(ctx)).To(Succeed())
+		Expect(Mounted(generator.CertDir)).To(BeTrue())
+
+		data := readSecret()
+		for _, name := range []string{CertName, KeyName, CAName} {
+			written, err := os.ReadFile(filepath.Join(generator.CertDir, name))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(written).To(Equal(data[name]))
+		}
+
+		roots := x509.NewCertPool()
+		Expect(roots.AppendCertsFromPEM(data[CAName])).To(BeTrue())
+		block, _ := pem.Decode(data[CertName])
+		cert, err := x509.ParseCertificate(block.Bytes)
+		Expect(err).NotTo(HaveOccurred())
+		_, err = cert.Verify(x509.VerifyOptions{DNSName: "webhook-service.idm-system.svc", Roots: roots})
+		Expect(err).NotTo(HaveOccurred())
+
+		config := &admissionregistrationv1.ValidatingWebhookConfiguration{}
+		Expect(c.Get(ctx, types.NamespacedName{Name: "validating"}, config)).To(Succeed())
+		Expect(config.Webhooks[0].ClientConfig.CABundle).To(Equal(data[CAName]))
+		Expect(c.Get(ctx, types.NamespacedName{Name: "other"}, config)).To(Succeed())
+		Expect(config.Webhooks[0].ClientConfig.CABundle).To(BeEmpty())
+		crd := &apiextensionsv1.CustomResourceDefinition{}
+		Expect(c.Get(ctx, types.NamespacedName{Name: "users.idm.micze.io"}, crd)).To(Succeed())
+		Expect(crd.Spec.Conversion.Webhook.ClientConfig.CABundle).To(Equal(data[CAName]))
+	})
+
+	It("keeps a valid certificate and rotates one that is due", func() {
+		Expect(generator.Ensure(ctx)).To(Succeed())
+		issued := readSecret()
+		Expect(generator.Ensure(ctx)).To(Succeed())
+		Expect(readSecret()).To(Equal(issued))
+
+		By("rotating an expired certificate")
+		Expect(c.Delete(ctx, &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Namespace: generator.Secret.Namespace, Name: generator.Secret.Name}})).To(Succeed())
+		generator.Validity = time.Hour
+		Expect(generator.Ensure(ctx)).To(Succeed())
+		expired := readSecret()
+		Expect(generator.valid(expired)).To(BeFalse())
+
+		generator.Validity = 0
+		Expect(generator.Ensure(ctx)).To(Succeed())
+		rotated := readSecret()
+		Expect(rotated[CertName]).NotTo(Equal(expired[CertName]))
+		Expect(generator.valid(rotated)).To(BeTrue())
+	})
+
+	It("does not accept the certificate of another Service", func() {
+		Expect(generator.Ensure(ctx)).To(Succeed())
+		data := readSecret()
+
+		other := *generator
+		other.Service = types.NamespacedName{Namespace: service.Namespace, Name: "other-service"}
+		Expect(other.valid(data)).To(BeFalse())
+	})
+})
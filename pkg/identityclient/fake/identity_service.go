@@ -0,0 +1,606 @@
+// Package fake provides an in-memory implementation of the identity API for tests.
+package fake
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+
+	v1 "github.com/m15ch4/go-identity-operator/api/v1"
+	idmsvc "github.com/m15ch4/go-identity-operator/pkg/identityclient"
+)
+
+// IdentityService keeps users, roles and groups in memory. Unknown IDs result in the
+// same 404 APIError the identity app returns.
+type IdentityService struct {
+	mu sync.Mutex
+
+	Users   map[string]idmsvc.IdentityUser
+	Roles   map[string]idmsvc.IdentityRole
+	Groups  map[string]idmsvc.IdentityGroup
+	Members map[string]map[string]bool
+	// Parents maps the IDs of nested groups to the IDs of their parent groups
+	Parents map[string]string
+	// UserRoles maps the IDs of users to their roles per scope
+	UserRoles map[string]map[string]map[string]bool
+	APIKeys   map[string]idmsvc.IdentityAPIKey
+	// IdempotencyKeys maps the idempotency keys of creations to the IDs of the created users
+	IdempotencyKeys map[string]string
+
+	// BackendInfo is returned by Info
+	BackendInfo idmsvc.BackendInfo
+
+	// Errors makes the operation with the given name, e.g. "CreateUser", fail with the error
+	Errors map[string]error
+	// Calls counts the invocations of each operation
+	Calls map[string]int
+
+	nextID int
+}
+
+var _ idmsvc.IdentityAPI = &IdentityService{}
+
+func NewIdentityService() *IdentityService {
+	return &IdentityService{
+		Users:           map[string]idmsvc.IdentityUser{},
+		Roles:           map[string]idmsvc.IdentityRole{},
+		Groups:          map[string]idmsvc.IdentityGroup{},
+		Members:         map[string]map[string]bool{},
+		Parents:         map[string]string{},
+		UserRoles:       map[string]map[string]map[string]bool{},
+		APIKeys:         map[string]idmsvc.IdentityAPIKey{},
+		IdempotencyKeys: map[string]string{},
+		Errors:          map[string]error{},
+		Calls:           map[string]int{},
+		BackendInfo: idmsvc.BackendInfo{
+			Version:        "fake",
+			SupportsPatch:  true,
+			SupportsGroups: true,
+		},
+	}
+}
+
+// NotFound returns the error the identity app responds with for unknown IDs
+func NotFound() error {
+	return &idmsvc.APIError{StatusCode: http.StatusNotFound}
+}
+
+// call records the invocation of operation and returns the error configured for it; s.mu must be held
+func (s *IdentityService) call(operation string) error {
+	s.Calls[operation]++
+	return s.Errors[operation]
+}
+
+// newID returns the next external ID; s.mu must be held
+func (s *IdentityService) newID() string {
+	s.nextID++
+	return strconv.Itoa(s.nextID)
+}
+
+func (s *IdentityService) GetToken(ctx context.Context) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.call("GetToken"); err != nil {
+		return "", err
+	}
+	return "fake-token", nil
+}
+
+func (s *IdentityService) SetCredentials(user, pass string) {}
+
+func (s *IdentityService) Info(ctx context.Context) (*idmsvc.BackendInfo, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.call("Info"); err != nil {
+		return nil, err
+	}
+	info := s.BackendInfo
+	return &info, nil
+}
+
+func (s *IdentityService) CreateUser(ctx context.Context, user *v1.UserSpec) (*idmsvc.IdentityUser, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.call("CreateUser"); err != nil {
+		return nil, err
+	}
+	// a repeated creation with the same idempotency key returns the user created first
+	key := idmsvc.IdempotencyKeyFrom(ctx)
+	if usr, ok := s.Users[s.IdempotencyKeys[key]]; ok && key != "" {
+		return &usr, nil
+	}
+	usr := identityUserFor(s.newID(), user)
+	s.Users[usr.ID] = usr
+	if key != "" {
+		s.IdempotencyKeys[key] = usr.ID
+	}
+	return &usr, nil
+}
+
+// CreateUsers creates the users in one call, set Errors["CreateUsers"] to ErrNotSupported
+// to simulate a backend without bulk support
+func (s *IdentityService) CreateUsers(ctx context.Context, users []*v1.UserSpec) ([]idmsvc.BulkResult, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.call("CreateUsers"); err != nil {
+		return nil, err
+	}
+	results := make([]idmsvc.BulkResult, len(users))
+	for i, user := range users {
+		usr := identityUserFor(s.newID(), user)
+		s.Users[usr.ID] = usr
+		if key := idmsvc.IdempotencyKeysFrom(ctx, i); key != "" {
+			s.IdempotencyKeys[key] = usr.ID
+		}
+		results[i].User = &usr
+	}
+	return results, nil
+}
+
+func (s *IdentityService) GetUser(ctx context.Context, userID string) (*idmsvc.IdentityUser, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.call("GetUser"); err != nil {
+		return nil, err
+	}
+	usr, ok := s.Users[userID]
+	if !ok {
+		return nil, NotFound()
+	}
+	return &usr, nil
+}
+
+func (s *IdentityService) FindUserByName(ctx context.Context, name string) (*idmsvc.IdentityUser, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.call("FindUserByName"); err != nil {
+		return nil, err
+	}
+	for _, usr := range s.Users {
+		if usr.Name == name {
+			return &usr, nil
+		}
+	}
+	return nil, nil
+}
+
+func (s *IdentityService) FindUserByIdempotencyKey(ctx context.Context, key string) (*idmsvc.IdentityUser, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.call("FindUserByIdempotencyKey"); err != nil {
+		return nil, err
+	}
+	if usr, ok := s.Users[s.IdempotencyKeys[key]]; ok {
+		return &usr, nil
+	}
+	return nil, nil
+}
+
+// ListUsers pages through the users sorted by ID by offset, the cursor is not supported
+func (s *IdentityService) ListUsers(ctx context.Context, filter idmsvc.UserFilter, pageOpts idmsvc.PageOptions) (*idmsvc.UserPage, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.call("ListUsers"); err != nil {
+		return nil, err
+	}
+	users := make([]idmsvc.IdentityUser, 0, len(s.Users))
+	for _, usr := range s.Users {
+		if filter.Name == "" || usr.Name == filter.Name {
+			users = append(users, usr)
+		}
+	}
+	sort.Slice(users, func(i, j int) bool { return users[i].ID < users[j].ID })
+
+	result := &idmsvc.UserPage{}
+	if pageOpts.Offset < len(users) {
+		users = users[pageOpts.Offset:]
+	} else {
+		users = nil
+	}
+	if pageOpts.Limit > 0 && len(users) > pageOpts.Limit {
+		users = users[:pageOpts.Limit]
+		result.Next = &idmsvc.PageOptions{Limit: pageOpts.Limit, Offset: pageOpts.Offset + pageOpts.Limit}
+	}
+	result.Users = users
+	return result, nil
+}
+
+func (s *IdentityService) UpdateUser(ctx context.Context, userID string, user *v1.UserSpec) (*idmsvc.IdentityUser, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.call("UpdateUser"); err != nil {
+		return nil, err
+	}
+	if _, ok := s.Users[userID]; !ok {
+		return nil, NotFound()
+	}
+	usr := identityUserFor(userID, user)
+	s.Users[userID] = usr
+	return &usr, nil
+}
+
+// PatchUser overwrites only the given fields of the user
+func (s *IdentityService) PatchUser(ctx context.Context, userID string, user *v1.UserSpec, fields []string) (*idmsvc.IdentityUser, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.call("PatchUser"); err != nil {
+		return nil, err
+	}
+	usr, ok := s.Users[userID]
+	if !ok {
+		return nil, NotFound()
+	}
+
+	// merge the patch into the JSON representation of the stored user
+	current, err := json.Marshal(usr)
+	if err != nil {
+		return nil, err
+	}
+	merged := map[string]interface{}{}
+	if err := json.Unmarshal(current, &merged); err != nil {
+		return nil, err
+	}
+	for name, value := range idmsvc.UserPatch(user, fields) {
+		merged[name] = value
+	}
+	patched, err := json.Marshal(merged)
+	if err != nil {
+		return nil, err
+	}
+	usr = idmsvc.IdentityUser{}
+	if err := json.Unmarshal(patched, &usr); err != nil {
+		return nil, err
+	}
+	usr.ID = userID
+
+	s.Users[userID] = usr
+	return &usr, nil
+}
+
+func (s *IdentityService) DeleteUser(ctx context.Context, userID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.call("DeleteUser"); err != nil {
+		return err
+	}
+	if _, ok := s.Users[userID]; !ok {
+		return NotFound()
+	}
+	delete(s.Users, userID)
+	return nil
+}
+
+func (s *IdentityService) CreateRole(ctx context.Context, role *v1.RoleSpec) (*idmsvc.IdentityRole, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.call("CreateRole"); err != nil {
+		return nil, err
+	}
+	rol := idmsvc.IdentityRole{ID: s.newID(), Name: role.Name, Description: role.Description, Permissions: role.Permissions}
+	s.Roles[rol.ID] = rol
+	return &rol, nil
+}
+
+func (s *IdentityService) GetRole(ctx context.Context, roleID string) (*idmsvc.IdentityRole, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.call("GetRole"); err != nil {
+		return nil, err
+	}
+	rol, ok := s.Roles[roleID]
+	if !ok {
+		return nil, NotFound()
+	}
+	return &rol, nil
+}
+
+func (s *IdentityService) UpdateRole(ctx context.Context, roleID string, role *v1.RoleSpec) (*idmsvc.IdentityRole, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.call("UpdateRole"); err != nil {
+		return nil, err
+	}
+	if _, ok := s.Roles[roleID]; !ok {
+		return nil, NotFound()
+	}
+	rol := idmsvc.IdentityRole{ID: roleID, Name: role.Name, Description: role.Description, Permissions: role.Permissions}
+	s.Roles[roleID] = rol
+	return &rol, nil
+}
+
+func (s *IdentityService) DeleteRole(ctx context.Context, roleID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.call("DeleteRole"); err != nil {
+		return err
+	}
+	if _, ok := s.Roles[roleID]; !ok {
+		return NotFound()
+	}
+	delete(s.Roles, roleID)
+	return nil
+}
+
+func (s *IdentityService) ListUserRoles(ctx context.Context, userID, scope string) ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.call("ListUserRoles"); err != nil {
+		return nil, err
+	}
+	if _, ok := s.Users[userID]; !ok {
+		return nil, NotFound()
+	}
+	roles := make([]string, 0, len(s.UserRoles[userID][scope]))
+	for role := range s.UserRoles[userID][scope] {
+		roles = append(roles, role)
+	}
+	sort.Strings(roles)
+	return roles, nil
+}
+
+func (s *IdentityService) AddUserRole(ctx context.Context, userID, scope, role string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.call("AddUserRole"); err != nil {
+		return err
+	}
+	if _, ok := s.Users[userID]; !ok {
+		return NotFound()
+	}
+	if s.UserRoles[userID] == nil {
+		s.UserRoles[userID] = map[string]map[string]bool{}
+	}
+	if s.UserRoles[userID][scope] == nil {
+		s.UserRoles[userID][scope] = map[string]bool{}
+	}
+	s.UserRoles[userID][scope][role] = true
+	return nil
+}
+
+func (s *IdentityService) RemoveUserRole(ctx context.Context, userID, scope, role string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.call("RemoveUserRole"); err != nil {
+		return err
+	}
+	if !s.UserRoles[userID][scope][role] {
+		return NotFound()
+	}
+	delete(s.UserRoles[userID][scope], role)
+	return nil
+}
+
+func (s *IdentityService) CreateGroup(ctx context.Context, group *v1.GroupSpec) (*idmsvc.IdentityGroup, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.call("CreateGroup"); err != nil {
+		return nil, err
+	}
+	grp := idmsvc.IdentityGroup{ID: s.newID(), Name: group.Name, Description: group.Description}
+	s.Groups[grp.ID] = grp
+	s.Members[grp.ID] = map[string]bool{}
+	return &grp, nil
+}
+
+func (s *IdentityService) GetGroup(ctx context.Context, groupID string) (*idmsvc.IdentityGroup, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.call("GetGroup"); err != nil {
+		return nil, err
+	}
+	grp, ok := s.Groups[groupID]
+	if !ok {
+		return nil, NotFound()
+	}
+	return &grp, nil
+}
+
+func (s *IdentityService) UpdateGroup(ctx context.Context, groupID string, group *v1.GroupSpec) (*idmsvc.IdentityGroup, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.call("UpdateGroup"); err != nil {
+		return nil, err
+	}
+	if _, ok := s.Groups[groupID]; !ok {
+		return nil, NotFound()
+	}
+	grp := idmsvc.IdentityGroup{ID: groupID, Name: group.Name, Description: group.Description}
+	s.Groups[groupID] = grp
+	return &grp, nil
+}
+
+func (s *IdentityService) DeleteGroup(ctx context.Context, groupID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.call("DeleteGroup"); err != nil {
+		return err
+	}
+	if _, ok := s.Groups[groupID]; !ok {
+		return NotFound()
+	}
+	delete(s.Groups, groupID)
+	delete(s.Members, groupID)
+	delete(s.Parents, groupID)
+	return nil
+}
+
+func (s *IdentityService) ListGroupMembers(ctx context.Context, groupID string) ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.call("ListGroupMembers"); err != nil {
+		return nil, err
+	}
+	members, ok := s.Members[groupID]
+	if !ok {
+		return nil, NotFound()
+	}
+	ids := make([]string, 0, len(members))
+	for id := range members {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids, nil
+}
+
+func (s *IdentityService) AddGroupMember(ctx context.Context, groupID, userID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.call("AddGroupMember"); err != nil {
+		return err
+	}
+	members, ok := s.Members[groupID]
+	if !ok {
+		return NotFound()
+	}
+	members[userID] = true
+	return nil
+}
+
+func (s *IdentityService) RemoveGroupMember(ctx context.Context, groupID, userID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.call("RemoveGroupMember"); err != nil {
+		return err
+	}
+	members, ok := s.Members[groupID]
+	if !ok || !members[userID] {
+		return NotFound()
+	}
+	delete(members, userID)
+	return nil
+}
+
+func (s *IdentityService) AddChildGroup(ctx context.Context, parentID, groupID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.call("AddChildGroup"); err != nil {
+		return err
+	}
+	_, parentOK := s.Groups[parentID]
+	_, groupOK := s.Groups[groupID]
+	if !parentOK || !groupOK {
+		return NotFound()
+	}
+	s.Parents[groupID] = parentID
+	return nil
+}
+
+func (s *IdentityService) RemoveChildGroup(ctx context.Context, parentID, groupID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.call("RemoveChildGroup"); err != nil {
+		return err
+	}
+	if s.Parents[groupID] != parentID {
+		return NotFound()
+	}
+	delete(s.Parents, groupID)
+	return nil
+}
+
+func (s *IdentityService) CreateAPIKey(ctx context.Context, key *v1.ApiKeySpec) (*idmsvc.IdentityAPIKey, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.call("CreateAPIKey"); err != nil {
+		return nil, err
+	}
+	apiKey := idmsvc.IdentityAPIKey{ID: s.newID(), Name: key.Name, Description: key.Description}
+	apiKey.Key = "key-" + apiKey.ID + "-" + s.newID()
+	s.APIKeys[apiKey.ID] = apiKey
+	return &apiKey, nil
+}
+
+func (s *IdentityService) RotateAPIKey(ctx context.Context, keyID string) (*idmsvc.IdentityAPIKey, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.call("RotateAPIKey"); err != nil {
+		return nil, err
+	}
+	apiKey, ok := s.APIKeys[keyID]
+	if !ok {
+		return nil, NotFound()
+	}
+	apiKey.Key = "key-" + apiKey.ID + "-" + s.newID()
+	s.APIKeys[keyID] = apiKey
+	return &apiKey, nil
+}
+
+func (s *IdentityService) DeleteAPIKey(ctx context.Context, keyID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.call("DeleteAPIKey"); err != nil {
+		return err
+	}
+	if _, ok := s.APIKeys[keyID]; !ok {
+		return NotFound()
+	}
+	delete(s.APIKeys, keyID)
+	return nil
+}
+
+// identityUserFor converts the User spec into the user stored by the fake
+func identityUserFor(id string, user *v1.UserSpec) idmsvc.IdentityUser {
+	enabled := user.IsEnabled()
+	return idmsvc.IdentityUser{
+		ID:        id,
+		Name:      user.Name,
+		Password:  user.Password,
+		Firstname: user.Firstname,
+		Lastname:  user.Lastname,
+		Role:      user.Role,
+		Age:       user.Age,
+		Roles:     append([]string(nil), user.Roles...),
+
+		Email:       user.Email,
+		Phone:       user.Phone,
+		DisplayName: user.DisplayName,
+		Enabled:     &enabled,
+		Attributes:  copyAttributes(user.Attributes),
+	}
+}
+
+// copyAttributes returns a copy of the attributes, so the stored user does not share them
+// with the spec
+func copyAttributes(attributes map[string]string) map[string]string {
+	if attributes == nil {
+		return nil
+	}
+	copied := make(map[string]string, len(attributes))
+	for key, value := range attributes {
+		copied[key] = value
+	}
+	return copied
+}
@@ -0,0 +1,279 @@
+// Package graph implements the identity API against Microsoft Graph, managing the users,
+// groups and directory role assignments of a Microsoft Entra ID tenant. It authenticates
+// with access tokens of an app registration obtained with the client credentials grant.
+package graph
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	neturl "net/url"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	idmsvc "github.com/m15ch4/go-identity-operator/pkg/identityclient"
+)
+
+const (
+	// tokenExpiryLeeway renews tokens slightly before they expire
+	tokenExpiryLeeway = 30 * time.Second
+
+	// requests referencing an object created within the consistency window are retried on
+	// 404 with exponential backoff
+	consistencyWindow    = 2 * time.Minute
+	consistencyRetries   = 5
+	consistencyBaseDelay = 500 * time.Millisecond
+)
+
+type organization struct {
+	ID string `json:"id"`
+}
+
+// page is a page of a Graph collection, the next page is given by its URL
+type page[T any] struct {
+	Value    []T    `json:"value"`
+	NextLink string `json:"@odata.nextLink,omitempty"`
+}
+
+// reference is the body of requests adding a directory object to a collection
+type reference struct {
+	ODataID string `json:"@odata.id"`
+}
+
+// Service manages users, groups and directory role assignments of an Entra ID tenant
+type Service struct {
+	config *idmsvc.IdentityConfig
+	client *idmsvc.Client
+
+	// mu guards the cached access token and the recently created objects
+	mu          sync.Mutex
+	token       string
+	tokenExpiry time.Time
+	created     map[string]time.Time
+
+	// roles caches the role definitions by display name, which every role assignment
+	// looks up
+	roles *idmsvc.LookupCache[*roleDefinition]
+}
+
+var _ idmsvc.IdentityAPI = &Service{}
+
+func NewService(config *idmsvc.IdentityConfig) *Service {
+	return &Service{
+		config:  config,
+		client:  idmsvc.NewClient(config).WithUserSchema(userSchema),
+		created: map[string]time.Time{},
+		roles:   idmsvc.NewLookupCache[*roleDefinition]("graph_find_role", config.LookupCacheTTL()),
+	}
+}
+
+// GetToken returns an access token of the app registration obtained with the client
+// credentials grant, or the static bearer token from the configuration
+func (s *Service) GetToken(ctx context.Context) (string, error) {
+	if token := s.config.Token(); token != "" {
+		return token, nil
+	}
+	if !s.config.UsesClientCredentials() {
+		return "", &idmsvc.CredentialsError{Err: errors.New("microsoft graph requires clientCredentials or a bearer token")}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.token != "" && time.Now().Add(tokenExpiryLeeway).Before(s.tokenExpiry) {
+		return s.token, nil
+	}
+	token, expiry, err := s.client.ClientCredentialsToken(ctx)
+	if err != nil {
+		return "", err
+	}
+	s.token, s.tokenExpiry = token, expiry
+	return token, nil
+}
+
+// Info checks the access token by reading the organization of the tenant. Graph reports
+// no version, users are updated partially with PATCH.
+func (s *Service) Info(ctx context.Context) (*idmsvc.BackendInfo, error) {
+	var found page[organization]
+	err := s.call(ctx, "graph_get_organization", "GET", apiPath("organization")+"?$select=id", nil, &found)
+	if err != nil {
+		return nil, err
+	}
+	return &idmsvc.BackendInfo{
+		SupportsPatch:  s.config.PatchUpdates(),
+		SupportsGroups: true,
+	}, nil
+}
+
+// SetCredentials is a no-op, the credentials of Graph services come from their IdentityInstance
+func (s *Service) SetCredentials(user, pass string) {}
+
+// invalidateToken drops the cached token when Graph rejected it
+func (s *Service) invalidateToken(token string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.token == token {
+		s.token = ""
+	}
+}
+
+// apiPath returns the path of the Graph API joined with the escaped elements
+func apiPath(elem ...string) string {
+	for i := range elem {
+		elem[i] = neturl.PathEscape(elem[i])
+	}
+	return "/" + path.Join(elem...)
+}
+
+// objectURL returns the URL of a directory object used to reference it in request bodies
+func (s *Service) objectURL(id string) string {
+	return s.client.BaseURL() + apiPath("directoryObjects", id)
+}
+
+// odataString quotes a string literal of an OData filter
+func odataString(value string) string {
+	return "'" + strings.ReplaceAll(value, "'", "''") + "'"
+}
+
+// recordCreated remembers the object created moments ago, so requests referencing it are
+// retried while Graph has not replicated it yet
+func (s *Service) recordCreated(id string) {
+	if id == "" {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	for created, at := range s.created {
+		if now.Sub(at) > consistencyWindow {
+			delete(s.created, created)
+		}
+	}
+	s.created[id] = now
+}
+
+// recentlyCreated reports whether the request references an object created within the
+// consistency window
+func (s *Service) recentlyCreated(request string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for id, at := range s.created {
+		if time.Since(at) <= consistencyWindow && strings.Contains(request, id) {
+			return true
+		}
+	}
+	return false
+}
+
+// call makes an authenticated Graph request. The in value, if not nil, is sent as JSON
+// request body and the JSON response body is decoded into out, if not nil. Requests whose
+// path or body references an object created moments ago are retried on 404, Graph
+// replicates new objects with a delay.
+func (s *Service) call(ctx context.Context, operation, method, path string, in, out interface{}) error {
+	// prepare request body
+	var body []byte
+	if in != nil {
+		var err error
+		body, err = json.Marshal(in)
+		if err != nil {
+			return err
+		}
+	}
+
+	delay := consistencyBaseDelay
+	for attempt := 1; ; attempt++ {
+		err := s.do(ctx, operation, method, path, body, out)
+		if !idmsvc.IsNotFound(err) || attempt >= consistencyRetries || !s.recentlyCreated(path+string(body)) {
+			return err
+		}
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+		delay *= 2
+	}
+}
+
+// do makes a single authenticated Graph request with the JSON request body, if not nil
+func (s *Service) do(ctx context.Context, operation, method, path string, in []byte, out interface{}) error {
+	var reqBody io.Reader
+	if in != nil {
+		reqBody = bytes.NewReader(in)
+	}
+
+	// prepare request
+	req, err := http.NewRequestWithContext(ctx, method, s.client.BaseURL()+path, reqBody)
+	if err != nil {
+		return err
+	}
+
+	// set authorization header with cached token
+	token, err := s.GetToken(ctx)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+
+	// set content type and accept headers
+	if in != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	req.Header.Set("Accept", "application/json")
+
+	// make REST API call
+	resp, err := s.client.Do(operation, req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusUnauthorized {
+		s.invalidateToken(token)
+	}
+
+	// read response body
+	body, err := idmsvc.ReadBody(resp)
+	if err != nil {
+		return err
+	}
+
+	// check response status code
+	err = idmsvc.CheckResponse(resp, body)
+	if err != nil {
+		return err
+	}
+
+	// unmarshal response body
+	if out != nil && len(body) > 0 {
+		return idmsvc.DecodeJSON(resp, body, out)
+	}
+	return nil
+}
+
+// nextPath returns the path of the next page of a collection given by its next link
+func (s *Service) nextPath(nextLink string) string {
+	if nextLink == "" {
+		return ""
+	}
+	next, err := neturl.Parse(nextLink)
+	if err != nil {
+		return ""
+	}
+	base, err := neturl.Parse(s.client.BaseURL())
+	if err != nil {
+		return ""
+	}
+	return strings.TrimPrefix(next.RequestURI(), base.Path)
+}
@@ -0,0 +1,199 @@
+package graph
+
+import (
+	"context"
+	"net/url"
+	"strconv"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	v1 "github.com/m15ch4/go-identity-operator/api/v1"
+	idmsvc "github.com/m15ch4/go-identity-operator/pkg/identityclient"
+)
+
+var _ = Describe("Service", func() {
+	ctx := context.Background()
+
+	var (
+		entra *tenant
+		svc   *Service
+	)
+
+	// newService returns a service of the tenant, with the options of the spec
+	newService := func(opts ...idmsvc.ConfigOpts) *Service {
+		u, err := url.Parse(entra.URL)
+		Expect(err).NotTo(HaveOccurred())
+		port, err := strconv.Atoi(u.Port())
+		Expect(err).NotTo(HaveOccurred())
+		cfg := idmsvc.NewIdentityConfig(append([]idmsvc.ConfigOpts{
+			idmsvc.WithScheme(u.Scheme),
+			idmsvc.WithHost(u.Hostname()),
+			idmsvc.WithPort(port),
+			idmsvc.WithClientCredentials(entra.URL+"/token", "app", "s3cret", []string{"https://graph.microsoft.com/.default"}),
+			idmsvc.WithRetry(1, 0, 0),
+		}, opts...)...)
+		return NewService(&cfg)
+	}
+
+	BeforeEach(func() {
+		entra = newTenant()
+		DeferCleanup(entra.Close)
+		svc = newService()
+	})
+
+	It("authenticates with an access token of the client credentials grant", func() {
+		info, err := svc.Info(ctx)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(info.SupportsGroups).To(BeTrue())
+		_, err = svc.Info(ctx)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(entra.tokens).To(Equal(1), "the access token is reused")
+
+		_, err = newService(idmsvc.WithClientCredentials(entra.URL+"/token", "app", "wrong",
+			[]string{"https://graph.microsoft.com/.default"})).GetToken(ctx)
+		Expect(idmsvc.IsCredentialsInvalid(err)).To(BeTrue())
+		_, err = newService(idmsvc.WithClientCredentials("", "", "", nil)).GetToken(ctx)
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("creates users with a mail nickname and password and finds them by user principal name", func() {
+		created, err := svc.CreateUser(ctx, &v1.UserSpec{
+			Name: "jackr@example.com", Password: "pw", Firstname: "Jack", Role: "admin", Roles: []string{"dev"},
+			Attributes: map[string]string{"extensionAttribute1": "ops", "team": "ignored"},
+		})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(created.Name).To(Equal("jackr@example.com"))
+		Expect(created.Firstname).To(Equal("Jack"))
+		Expect(created.DisplayName).To(BeEmpty(), "the display name defaults to the user principal name")
+		Expect(created.AllRoles()).To(ConsistOf("admin", "dev"))
+		Expect(created.Attributes).To(Equal(map[string]string{"extensionAttribute1": "ops"}))
+		Expect(entra.objects["/users/"+created.ID]).To(HaveKeyWithValue("mailNickname", "jackr"))
+
+		found, err := svc.FindUserByName(ctx, "JACKR@example.com")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(found.ID).To(Equal(created.ID))
+		found, err = svc.FindUserByName(ctx, "janed@example.com")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(found).To(BeNil())
+	})
+
+	It("lists the users page by page following the next links", func() {
+		for _, name := range []string{"a", "b", "c"} {
+			_, err := svc.CreateUser(ctx, &v1.UserSpec{Name: name})
+			Expect(err).NotTo(HaveOccurred())
+		}
+
+		page, err := svc.ListUsers(ctx, idmsvc.UserFilter{}, idmsvc.PageOptions{Limit: 2})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(page.Users).To(HaveLen(2))
+		Expect(page.Next).NotTo(BeNil())
+		page, err = svc.ListUsers(ctx, idmsvc.UserFilter{}, *page.Next)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(page.Users).To(HaveLen(1))
+		Expect(page.Next).To(BeNil())
+	})
+
+	It("updates all properties of users, clearing the unset ones, and deletes them", func() {
+		created, err := svc.CreateUser(ctx, &v1.UserSpec{
+			Name: "jackr", Firstname: "Jack", Phone: "555-0100",
+			Attributes: map[string]string{"extensionAttribute1": "ops"},
+		})
+		Expect(err).NotTo(HaveOccurred())
+
+		disabled := false
+		updated, err := svc.UpdateUser(ctx, created.ID, &v1.UserSpec{
+			Name: "jackr", Lastname: "Reacher", Password: "new", Enabled: &disabled,
+			Attributes: map[string]string{"extensionAttribute2": "berlin"},
+		})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(updated.Firstname).To(BeEmpty())
+		Expect(updated.Phone).To(BeEmpty())
+		Expect(updated.Lastname).To(Equal("Reacher"))
+		Expect(*updated.Enabled).To(BeFalse())
+		Expect(updated.Attributes).To(Equal(map[string]string{"extensionAttribute2": "berlin"}))
+		Expect(entra.objects["/users/"+created.ID]).To(HaveKeyWithValue("password", "new"))
+
+		// reading the deleted user again would be retried, it was created moments ago
+		Expect(svc.DeleteUser(ctx, created.ID)).To(Succeed())
+		Expect(entra.objects).NotTo(HaveKey("/users/" + created.ID))
+	})
+
+	It("patches only the changed properties", func() {
+		created, err := svc.CreateUser(ctx, &v1.UserSpec{Name: "jackr", Firstname: "Jack", Lastname: "Reacher", Phone: "555-0100"})
+		Expect(err).NotTo(HaveOccurred())
+
+		patched, err := svc.PatchUser(ctx, created.ID, &v1.UserSpec{Name: "jackr", Firstname: "Jacques"},
+			[]string{"firstname", "phone"})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(patched.Firstname).To(Equal("Jacques"))
+		Expect(patched.Phone).To(BeEmpty())
+		Expect(patched.Lastname).To(Equal("Reacher"), "properties that did not change are kept")
+	})
+
+	It("creates, updates and deletes security groups and manages their members", func() {
+		created, err := svc.CreateGroup(ctx, &v1.GroupSpec{Name: "devs", Description: "Developers"})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(entra.objects["/groups/"+created.ID]).To(HaveKeyWithValue("securityEnabled", true))
+
+		updated, err := svc.UpdateGroup(ctx, created.ID, &v1.GroupSpec{Name: "developers", Description: "All developers"})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(updated.Name).To(Equal("developers"))
+
+		usr, err := svc.CreateUser(ctx, &v1.UserSpec{Name: "jackr"})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(svc.AddGroupMember(ctx, created.ID, usr.ID)).To(Succeed())
+		members, err := svc.ListGroupMembers(ctx, created.ID)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(members).To(ConsistOf(usr.ID))
+		Expect(svc.RemoveGroupMember(ctx, created.ID, usr.ID)).To(Succeed())
+		members, err = svc.ListGroupMembers(ctx, created.ID)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(members).To(BeEmpty())
+
+		Expect(svc.DeleteGroup(ctx, created.ID)).To(Succeed())
+		Expect(entra.objects).NotTo(HaveKey("/groups/" + created.ID))
+	})
+
+	Context("while the tenant replicates new objects", func() {
+		BeforeEach(func() {
+			entra.lag = 1
+		})
+
+		It("retries the requests referencing a new object on 404", func() {
+			start := time.Now()
+			created, err := svc.CreateUser(ctx, &v1.UserSpec{Name: "jackr", Firstname: "Jack"})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(created.Firstname).To(Equal("Jack"))
+			Expect(entra.requests).To(HaveLen(3), "the user is read again after the 404")
+			Expect(time.Since(start)).To(BeNumerically(">=", consistencyBaseDelay))
+
+			group, err := svc.CreateGroup(ctx, &v1.GroupSpec{Name: "devs"})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(svc.AddGroupMember(ctx, group.ID, created.ID)).To(Succeed())
+			Expect(entra.members[group.ID]).To(ConsistOf(created.ID))
+		})
+
+		It("does not retry 404 of objects it did not create", func() {
+			_, err := svc.CreateUser(ctx, &v1.UserSpec{Name: "jackr"})
+			Expect(err).NotTo(HaveOccurred())
+			requests := len(entra.requests)
+
+			_, err = svc.GetUser(ctx, "deleted")
+			Expect(idmsvc.IsNotFound(err)).To(BeTrue())
+			Expect(entra.requests).To(HaveLen(requests + 1))
+		})
+
+		It("finds new users with the default queries instead of the eventual consistency index", func() {
+			_, err := svc.CreateUser(ctx, &v1.UserSpec{Name: "jackr"})
+			Expect(err).NotTo(HaveOccurred())
+
+			found, err := svc.FindUserByName(ctx, "jackr")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(found).NotTo(BeNil())
+			Expect(entra.requests).NotTo(ContainElement(HaveSuffix(" eventual")),
+				"no request asks for ConsistencyLevel: eventual")
+		})
+	})
+})
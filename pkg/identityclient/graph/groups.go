@@ -0,0 +1,127 @@
+package graph
+
+import (
+	"context"
+
+	v1 "github.com/m15ch4/go-identity-operator/api/v1"
+	idmsvc "github.com/m15ch4/go-identity-operator/pkg/identityclient"
+)
+
+type group struct {
+	ID              string `json:"id,omitempty"`
+	DisplayName     string `json:"displayName"`
+	Description     string `json:"description,omitempty"`
+	MailEnabled     *bool  `json:"mailEnabled,omitempty"`
+	MailNickname    string `json:"mailNickname,omitempty"`
+	SecurityEnabled *bool  `json:"securityEnabled,omitempty"`
+}
+
+type directoryObject struct {
+	ID string `json:"id"`
+}
+
+// CreateGroup creates a security group
+func (s *Service) CreateGroup(ctx context.Context, spec *v1.GroupSpec) (*idmsvc.IdentityGroup, error) {
+	mailEnabled, securityEnabled := false, true
+	body := groupFor(spec)
+	body.MailEnabled = &mailEnabled
+	body.MailNickname = mailNickname(spec.Name)
+	body.SecurityEnabled = &securityEnabled
+
+	var created group
+	err := s.call(ctx, "graph_create_group", "POST", apiPath("groups"), body, &created)
+	if err != nil {
+		return nil, err
+	}
+	s.recordCreated(created.ID)
+	return identityGroup(&created), nil
+}
+
+// GetGroup reads the group by ID
+func (s *Service) GetGroup(ctx context.Context, groupID string) (*idmsvc.IdentityGroup, error) {
+	var found group
+	err := s.call(ctx, "graph_get_group", "GET", apiPath("groups", groupID), nil, &found)
+	if err != nil {
+		return nil, err
+	}
+	return identityGroup(&found), nil
+}
+
+// UpdateGroup sets the name and description of the group
+func (s *Service) UpdateGroup(ctx context.Context, groupID string, spec *v1.GroupSpec) (*idmsvc.IdentityGroup, error) {
+	err := s.call(ctx, "graph_update_group", "PATCH", apiPath("groups", groupID), groupFor(spec), nil)
+	if err != nil {
+		return nil, err
+	}
+	return s.GetGroup(ctx, groupID)
+}
+
+// DeleteGroup deletes the group by ID
+func (s *Service) DeleteGroup(ctx context.Context, groupID string) error {
+	return s.call(ctx, "graph_delete_group", "DELETE", apiPath("groups", groupID), nil, nil)
+}
+
+// ListGroupMembers returns the IDs of the users among the direct members of the group,
+// following the next links of the pages
+func (s *Service) ListGroupMembers(ctx context.Context, groupID string) ([]string, error) {
+	var ids []string
+	path := apiPath("groups", groupID, "members", "microsoft.graph.user") + "?$select=id"
+	for path != "" {
+		var found page[directoryObject]
+		err := s.call(ctx, "graph_list_group_members", "GET", path, nil, &found)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, member := range found.Value {
+			ids = append(ids, member.ID)
+		}
+		path = s.nextPath(found.NextLink)
+	}
+	return ids, nil
+}
+
+// AddGroupMember adds the user to the group
+func (s *Service) AddGroupMember(ctx context.Context, groupID, userID string) error {
+	return s.addMember(ctx, "graph_add_group_member", groupID, userID)
+}
+
+// RemoveGroupMember removes the user from the group
+func (s *Service) RemoveGroupMember(ctx context.Context, groupID, userID string) error {
+	return s.removeMember(ctx, "graph_remove_group_member", groupID, userID)
+}
+
+// AddChildGroup makes the group a member of the parent group
+func (s *Service) AddChildGroup(ctx context.Context, parentID, groupID string) error {
+	return s.addMember(ctx, "graph_add_child_group", parentID, groupID)
+}
+
+// RemoveChildGroup removes the group from the members of the parent group
+func (s *Service) RemoveChildGroup(ctx context.Context, parentID, groupID string) error {
+	return s.removeMember(ctx, "graph_remove_child_group", parentID, groupID)
+}
+
+// addMember adds the directory object to the members of the group
+func (s *Service) addMember(ctx context.Context, operation, groupID, memberID string) error {
+	body := &reference{ODataID: s.objectURL(memberID)}
+	return s.call(ctx, operation, "POST", apiPath("groups", groupID, "members", "$ref"), body, nil)
+}
+
+// removeMember removes the directory object from the members of the group
+func (s *Service) removeMember(ctx context.Context, operation, groupID, memberID string) error {
+	return s.call(ctx, operation, "DELETE", apiPath("groups", groupID, "members", memberID, "$ref"), nil, nil)
+}
+
+// groupFor converts the Group spec into a Graph group
+func groupFor(spec *v1.GroupSpec) *group {
+	return &group{DisplayName: spec.Name, Description: spec.Description}
+}
+
+// identityGroup converts a Graph group into the group of the identity API
+func identityGroup(g *group) *idmsvc.IdentityGroup {
+	return &idmsvc.IdentityGroup{
+		ID:          g.ID,
+		Name:        g.DisplayName,
+		Description: g.Description,
+	}
+}
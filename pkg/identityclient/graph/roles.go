@@ -0,0 +1,205 @@
+package graph
+
+import (
+	"context"
+	"net/http"
+	neturl "net/url"
+
+	v1 "github.com/m15ch4/go-identity-operator/api/v1"
+	idmsvc "github.com/m15ch4/go-identity-operator/pkg/identityclient"
+)
+
+// tenantScope is the directory scope of role assignments that apply to the whole tenant
+const tenantScope = "/"
+
+type rolePermission struct {
+	AllowedResourceActions []string `json:"allowedResourceActions"`
+}
+
+type roleDefinition struct {
+	ID              string           `json:"id,omitempty"`
+	DisplayName     string           `json:"displayName"`
+	Description     string           `json:"description,omitempty"`
+	IsEnabled       bool             `json:"isEnabled"`
+	RolePermissions []rolePermission `json:"rolePermissions"`
+}
+
+type roleAssignment struct {
+	ID               string          `json:"id,omitempty"`
+	PrincipalID      string          `json:"principalId"`
+	RoleDefinitionID string          `json:"roleDefinitionId"`
+	DirectoryScopeID string          `json:"directoryScopeId"`
+	RoleDefinition   *roleDefinition `json:"roleDefinition,omitempty"`
+}
+
+// rolesPath returns the path of the directory role management joined with the elements
+func rolesPath(elem ...string) string {
+	return apiPath(append([]string{"roleManagement", "directory"}, elem...)...)
+}
+
+// CreateRole creates a custom directory role whose permissions are resource actions,
+// e.g. microsoft.directory/users/basic/update
+func (s *Service) CreateRole(ctx context.Context, spec *v1.RoleSpec) (*idmsvc.IdentityRole, error) {
+	defer s.roles.Invalidate()
+	var created roleDefinition
+	err := s.call(ctx, "graph_create_role", "POST", rolesPath("roleDefinitions"), roleDefinitionFor(spec), &created)
+	if err != nil {
+		return nil, err
+	}
+	s.recordCreated(created.ID)
+	return identityRole(&created), nil
+}
+
+// GetRole reads the role definition by ID
+func (s *Service) GetRole(ctx context.Context, roleID string) (*idmsvc.IdentityRole, error) {
+	var found roleDefinition
+	err := s.call(ctx, "graph_get_role", "GET", rolesPath("roleDefinitions", roleID), nil, &found)
+	if err != nil {
+		return nil, err
+	}
+	return identityRole(&found), nil
+}
+
+// UpdateRole sets the name, description and permissions of the custom role
+func (s *Service) UpdateRole(ctx context.Context, roleID string, spec *v1.RoleSpec) (*idmsvc.IdentityRole, error) {
+	defer s.roles.Invalidate()
+	err := s.call(ctx, "graph_update_role", "PATCH", rolesPath("roleDefinitions", roleID), roleDefinitionFor(spec), nil)
+	if err != nil {
+		return nil, err
+	}
+	return s.GetRole(ctx, roleID)
+}
+
+// DeleteRole deletes the custom role by ID
+func (s *Service) DeleteRole(ctx context.Context, roleID string) error {
+	defer s.roles.Invalidate()
+	return s.call(ctx, "graph_delete_role", "DELETE", rolesPath("roleDefinitions", roleID), nil, nil)
+}
+
+// ListUserRoles returns the names of the directory roles assigned to the user in the
+// directory scope, e.g. /administrativeUnits/<id>, or in the whole tenant when omitted
+func (s *Service) ListUserRoles(ctx context.Context, userID, scope string) ([]string, error) {
+	assignments, err := s.userRoles(ctx, userID, scope)
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, 0, len(assignments))
+	for _, assignment := range assignments {
+		if assignment.RoleDefinition != nil {
+			names = append(names, assignment.RoleDefinition.DisplayName)
+		}
+	}
+	return names, nil
+}
+
+// AddUserRole assigns the directory role with the given name to the user in the directory scope
+func (s *Service) AddUserRole(ctx context.Context, userID, scope, roleName string) error {
+	definition, err := s.roleByName(ctx, roleName)
+	if err != nil {
+		return err
+	}
+	body := &roleAssignment{
+		PrincipalID:      userID,
+		RoleDefinitionID: definition.ID,
+		DirectoryScopeID: directoryScope(scope),
+	}
+	return s.call(ctx, "graph_assign_role", "POST", rolesPath("roleAssignments"), body, nil)
+}
+
+// RemoveUserRole removes the assignment of the directory role with the given name to the
+// user in the directory scope
+func (s *Service) RemoveUserRole(ctx context.Context, userID, scope, roleName string) error {
+	assignments, err := s.userRoles(ctx, userID, scope)
+	if err != nil {
+		return err
+	}
+	for _, assignment := range assignments {
+		if assignment.RoleDefinition != nil && assignment.RoleDefinition.DisplayName == roleName {
+			return s.call(ctx, "graph_unassign_role", "DELETE", rolesPath("roleAssignments", assignment.ID), nil, nil)
+		}
+	}
+	return &idmsvc.APIError{StatusCode: http.StatusNotFound, Body: "role " + roleName + " not assigned"}
+}
+
+// userRoles returns the role assignments of the user in the directory scope together
+// with their role definitions
+func (s *Service) userRoles(ctx context.Context, userID, scope string) ([]roleAssignment, error) {
+	var assignments []roleAssignment
+	filter := neturl.QueryEscape("principalId eq " + odataString(userID) + " and directoryScopeId eq " + odataString(directoryScope(scope)))
+	path := rolesPath("roleAssignments") + "?$filter=" + filter + "&$expand=roleDefinition"
+	for path != "" {
+		var found page[roleAssignment]
+		err := s.call(ctx, "graph_list_user_roles", "GET", path, nil, &found)
+		if err != nil {
+			return nil, err
+		}
+		assignments = append(assignments, found.Value...)
+		path = s.nextPath(found.NextLink)
+	}
+	return assignments, nil
+}
+
+// roleByName looks up the built-in or custom role definition by its display name,
+// reusing the result for the lookup cache TTL
+func (s *Service) roleByName(ctx context.Context, name string) (*roleDefinition, error) {
+	return s.roles.Get(ctx, name, func(ctx context.Context) (*roleDefinition, error) {
+		var found page[roleDefinition]
+		filter := neturl.QueryEscape("displayName eq " + odataString(name))
+		err := s.call(ctx, "graph_find_role", "GET", rolesPath("roleDefinitions")+"?$filter="+filter, nil, &found)
+		if err != nil {
+			return nil, err
+		}
+		for i := range found.Value {
+			if found.Value[i].DisplayName == name {
+				return &found.Value[i], nil
+			}
+		}
+		return nil, &idmsvc.APIError{StatusCode: http.StatusNotFound, Body: "role " + name + " not found"}
+	})
+}
+
+// directoryScope returns the directory scope of role assignments for the scope of a binding
+func directoryScope(scope string) string {
+	if scope == "" {
+		return tenantScope
+	}
+	return scope
+}
+
+// roleDefinitionFor converts the Role spec into a custom role definition
+func roleDefinitionFor(spec *v1.RoleSpec) *roleDefinition {
+	return &roleDefinition{
+		DisplayName:     spec.Name,
+		Description:     spec.Description,
+		IsEnabled:       true,
+		RolePermissions: []rolePermission{{AllowedResourceActions: spec.Permissions}},
+	}
+}
+
+// identityRole converts a role definition into the role of the identity API
+func identityRole(r *roleDefinition) *idmsvc.IdentityRole {
+	var permissions []string
+	for _, permission := range r.RolePermissions {
+		permissions = append(permissions, permission.AllowedResourceActions...)
+	}
+	return &idmsvc.IdentityRole{
+		ID:          r.ID,
+		Name:        r.DisplayName,
+		Description: r.Description,
+		Permissions: permissions,
+	}
+}
+
+// Secrets of app registrations are not managed, API keys are not supported
+
+func (s *Service) CreateAPIKey(ctx context.Context, spec *v1.ApiKeySpec) (*idmsvc.IdentityAPIKey, error) {
+	return nil, idmsvc.ErrNotSupported
+}
+
+func (s *Service) RotateAPIKey(ctx context.Context, keyID string) (*idmsvc.IdentityAPIKey, error) {
+	return nil, idmsvc.ErrNotSupported
+}
+
+func (s *Service) DeleteAPIKey(ctx context.Context, keyID string) error {
+	return idmsvc.ErrNotSupported
+}
@@ -0,0 +1,16 @@
+package graph
+
+import (
+	"testing"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+// The service is tested against an in-memory tenant served with httptest
+
+func TestGraph(t *testing.T) {
+	RegisterFailHandler(Fail)
+
+	RunSpecs(t, "Graph Suite")
+}
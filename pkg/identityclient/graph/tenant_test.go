@@ -0,0 +1,245 @@
+package graph
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+// tenant is an in-memory Entra ID tenant. Like Graph, it replicates new objects with a
+// delay: requests referencing an object answer 404 until the object is replicated, and
+// the advanced queries sent with ConsistencyLevel: eventual search an index that misses
+// the objects not replicated yet.
+type tenant struct {
+	*httptest.Server
+
+	mu sync.Mutex
+	// objects are the users and groups by their path, e.g. /users/1
+	objects map[string]map[string]interface{}
+	// members are the IDs of the members of the groups by ID
+	members map[string][]string
+	nextID  int
+	// lag is the number of requests referencing a new object that answer 404, and
+	// unreplicated counts them down by object ID
+	lag          int
+	unreplicated map[string]int
+	// tokens counts the access tokens issued
+	tokens int
+	// requests records the method, path and ConsistencyLevel of each request
+	requests []string
+}
+
+func newTenant() *tenant {
+	t := &tenant{
+		objects:      map[string]map[string]interface{}{},
+		members:      map[string][]string{},
+		unreplicated: map[string]int{},
+	}
+	t.Server = httptest.NewServer(http.HandlerFunc(t.serve))
+	return t
+}
+
+func (t *tenant) serve(w http.ResponseWriter, req *http.Request) {
+	defer GinkgoRecover()
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if req.URL.Path == "/token" {
+		client, secret, _ := req.BasicAuth()
+		Expect(req.ParseForm()).To(Succeed())
+		Expect(req.PostForm.Get("scope")).To(Equal("https://graph.microsoft.com/.default"))
+		if client != "app" || secret != "s3cret" {
+			t.reply(w, http.StatusUnauthorized, map[string]interface{}{"error": "invalid_client"})
+			return
+		}
+		t.tokens++
+		t.reply(w, http.StatusOK, map[string]interface{}{"access_token": "token-" + strconv.Itoa(t.tokens), "expires_in": 3600})
+		return
+	}
+
+	consistency := req.Header.Get("ConsistencyLevel")
+	t.requests = append(t.requests, strings.TrimSpace(req.Method+" "+req.URL.Path+" "+consistency))
+	if req.Header.Get("Authorization") != "Bearer token-"+strconv.Itoa(t.tokens) {
+		t.reply(w, http.StatusUnauthorized, graphError("InvalidAuthenticationToken"))
+		return
+	}
+
+	body, err := io.ReadAll(req.Body)
+	Expect(err).NotTo(HaveOccurred())
+	if t.replicating(req.URL.Path + string(body)) {
+		t.reply(w, http.StatusNotFound, graphError("Request_ResourceNotFound"))
+		return
+	}
+
+	elem := strings.Split(strings.TrimPrefix(req.URL.Path, "/"), "/")
+	switch {
+	case req.URL.Path == "/organization":
+		t.reply(w, http.StatusOK, map[string]interface{}{"value": []interface{}{map[string]interface{}{"id": "org"}}})
+	case len(elem) == 1 && req.Method == "POST":
+		t.create(w, elem[0], body)
+	case elem[0] == "users" && len(elem) == 1:
+		t.listUsers(w, req, consistency == "eventual")
+	case elem[0] == "groups" && len(elem) == 4 && elem[3] == "microsoft.graph.user":
+		values := []interface{}{}
+		for _, id := range t.members[elem[1]] {
+			values = append(values, map[string]interface{}{"id": id})
+		}
+		t.reply(w, http.StatusOK, map[string]interface{}{"value": values})
+	case elem[0] == "groups" && len(elem) == 4 && elem[3] == "$ref":
+		var ref reference
+		Expect(json.Unmarshal(body, &ref)).To(Succeed())
+		memberID := ref.ODataID[strings.LastIndex(ref.ODataID, "/")+1:]
+		Expect(t.objects).To(HaveKey("/users/"+memberID), "members reference existing users")
+		t.members[elem[1]] = append(t.members[elem[1]], memberID)
+		w.WriteHeader(http.StatusNoContent)
+	case elem[0] == "groups" && len(elem) == 5 && elem[4] == "$ref":
+		t.members[elem[1]] = remove(t.members[elem[1]], elem[3])
+		w.WriteHeader(http.StatusNoContent)
+	case len(elem) == 2:
+		t.serveObject(w, req.Method, req.URL.Path, body)
+	default:
+		t.reply(w, http.StatusNotFound, graphError("Request_ResourceNotFound"))
+	}
+}
+
+// replicating reports whether the request references an object that is not replicated
+// yet, counting down the requests until it is
+func (t *tenant) replicating(request string) bool {
+	for id, remaining := range t.unreplicated {
+		if strings.Contains(request, "/"+id) {
+			if remaining <= 1 {
+				delete(t.unreplicated, id)
+			} else {
+				t.unreplicated[id]--
+			}
+			return true
+		}
+	}
+	return false
+}
+
+// create stores the object of the collection under a new ID
+func (t *tenant) create(w http.ResponseWriter, collection string, body []byte) {
+	var object map[string]interface{}
+	Expect(json.Unmarshal(body, &object)).To(Succeed())
+	for _, value := range object {
+		Expect(value).NotTo(BeNil(), "objects are created without null properties")
+	}
+	t.nextID++
+	id := "0000000" + strconv.Itoa(t.nextID)
+	object["id"] = id
+	delete(object, "passwordProfile")
+	t.objects["/"+collection+"/"+id] = object
+	if t.lag > 0 {
+		t.unreplicated[id] = t.lag
+	}
+	t.reply(w, http.StatusCreated, object)
+}
+
+// listUsers answers a page of the users ordered by ID, filtered by user principal name,
+// with the link to the next page. The eventual consistency index misses the users not
+// replicated yet.
+func (t *tenant) listUsers(w http.ResponseWriter, req *http.Request, eventual bool) {
+	query := req.URL.Query()
+	name := strings.Trim(strings.TrimPrefix(query.Get("$filter"), "userPrincipalName eq "), "'")
+
+	var ids []string
+	for path, object := range t.objects {
+		id := strings.TrimPrefix(path, "/users/")
+		if id == path || (eventual && t.unreplicated[id] > 0) {
+			continue
+		}
+		if name == "" || strings.EqualFold(object["userPrincipalName"].(string), name) {
+			ids = append(ids, id)
+		}
+	}
+	sort.Strings(ids)
+
+	if skip := query.Get("$skiptoken"); skip != "" {
+		for len(ids) > 0 && ids[0] <= skip {
+			ids = ids[1:]
+		}
+	}
+	result := map[string]interface{}{}
+	if top, _ := strconv.Atoi(query.Get("$top")); top > 0 && len(ids) > top {
+		ids = ids[:top]
+		next := *req.URL
+		q := next.Query()
+		q.Set("$skiptoken", ids[len(ids)-1])
+		next.RawQuery = q.Encode()
+		result["@odata.nextLink"] = t.URL + next.RequestURI()
+	}
+	values := []interface{}{}
+	for _, id := range ids {
+		values = append(values, t.objects["/users/"+id])
+	}
+	result["value"] = values
+	t.reply(w, http.StatusOK, result)
+}
+
+// serveObject reads, updates or deletes the object. Properties set to null are cleared.
+func (t *tenant) serveObject(w http.ResponseWriter, method, path string, body []byte) {
+	object, found := t.objects[path]
+	if !found {
+		t.reply(w, http.StatusNotFound, graphError("Request_ResourceNotFound"))
+		return
+	}
+	switch method {
+	case "GET":
+		t.reply(w, http.StatusOK, object)
+	case "PATCH":
+		var update map[string]interface{}
+		Expect(json.Unmarshal(body, &update)).To(Succeed())
+		for property, value := range update {
+			switch {
+			case property == "passwordProfile":
+				object["password"] = value.(map[string]interface{})["password"]
+			case property == "onPremisesExtensionAttributes":
+				attributes, _ := object[property].(map[string]interface{})
+				if attributes == nil {
+					attributes = map[string]interface{}{}
+				}
+				for attribute, v := range value.(map[string]interface{}) {
+					attributes[attribute] = v
+				}
+				object[property] = attributes
+			case value == nil:
+				delete(object, property)
+			default:
+				object[property] = value
+			}
+		}
+		w.WriteHeader(http.StatusNoContent)
+	case "DELETE":
+		delete(t.objects, path)
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func (t *tenant) reply(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	Expect(json.NewEncoder(w).Encode(body)).To(Succeed())
+}
+
+func graphError(code string) map[string]interface{} {
+	return map[string]interface{}{"error": map[string]interface{}{"code": code}}
+}
+
+func remove(values []string, value string) []string {
+	var kept []string
+	for _, v := range values {
+		if v != value {
+			kept = append(kept, v)
+		}
+	}
+	return kept
+}
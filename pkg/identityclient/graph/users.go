@@ -0,0 +1,322 @@
+package graph
+
+import (
+	"context"
+	"fmt"
+	neturl "net/url"
+	"strconv"
+	"strings"
+
+	v1 "github.com/m15ch4/go-identity-operator/api/v1"
+	idmsvc "github.com/m15ch4/go-identity-operator/pkg/identityclient"
+)
+
+// listPageSize is the number of users requested per page when listing users
+const listPageSize = 999
+
+// userSelect lists the properties of the users read from Graph, which returns only a
+// default set of properties otherwise
+const userSelect = "id,userPrincipalName,givenName,surname,mail,mobilePhone,displayName,jobTitle,accountEnabled,onPremisesExtensionAttributes"
+
+// userProperties maps the fields of the User spec to the properties of the Graph user.
+// The roles are kept comma separated in the jobTitle, custom attributes are the extension attributes
+// extensionAttribute1 to extensionAttribute15. Graph has no property for the age, it is
+// not stored.
+var userProperties = map[string]string{
+	"name":        "userPrincipalName",
+	"firstname":   "givenName",
+	"lastname":    "surname",
+	"email":       "mail",
+	"phone":       "mobilePhone",
+	"displayName": "displayName",
+	"role":        "jobTitle",
+	"enabled":     "accountEnabled",
+}
+
+// userSchema describes the users of Graph for the attribute mapping
+var userSchema = &idmsvc.UserSchema{
+	Paths: map[string]string{
+		"firstname": "givenName", "lastname": "surname", "email": "mail",
+		"phone": "mobilePhone", "displayName": "displayName",
+	},
+	Operations: map[string]bool{
+		"graph_create_user": true, "graph_get_user": true, "graph_find_user": true,
+		"graph_list_users": true, "graph_update_user": true, "graph_patch_user": true,
+	},
+	Collections: []string{"value"},
+}
+
+// extensionAttributes is the number of extension attributes of Graph users
+const extensionAttributes = 15
+
+type passwordProfile struct {
+	Password                      string `json:"password"`
+	ForceChangePasswordNextSignIn bool   `json:"forceChangePasswordNextSignIn"`
+}
+
+type user struct {
+	ID                            string            `json:"id,omitempty"`
+	UserPrincipalName             string            `json:"userPrincipalName,omitempty"`
+	GivenName                     string            `json:"givenName,omitempty"`
+	Surname                       string            `json:"surname,omitempty"`
+	Mail                          string            `json:"mail,omitempty"`
+	MobilePhone                   string            `json:"mobilePhone,omitempty"`
+	DisplayName                   string            `json:"displayName,omitempty"`
+	JobTitle                      string            `json:"jobTitle,omitempty"`
+	AccountEnabled                *bool             `json:"accountEnabled,omitempty"`
+	OnPremisesExtensionAttributes map[string]string `json:"onPremisesExtensionAttributes,omitempty"`
+}
+
+// CreateUser creates the user with its password. Graph has no idempotency keys, a lost
+// response leaves a user that is found by name when adopting existing users.
+func (s *Service) CreateUser(ctx context.Context, spec *v1.UserSpec) (*idmsvc.IdentityUser, error) {
+	body := userFor(spec)
+	body["mailNickname"] = mailNickname(spec.Name)
+	body["passwordProfile"] = &passwordProfile{Password: spec.Password}
+	if len(spec.Attributes) > 0 {
+		body["onPremisesExtensionAttributes"] = extensionAttributesFor(nil, spec.Attributes)
+	}
+	for property, value := range body {
+		if value == nil {
+			delete(body, property)
+		}
+	}
+
+	var created user
+	err := s.call(ctx, "graph_create_user", "POST", apiPath("users"), body, &created)
+	if err != nil {
+		return nil, err
+	}
+	s.recordCreated(created.ID)
+	return s.GetUser(ctx, created.ID)
+}
+
+// GetUser reads the user
+func (s *Service) GetUser(ctx context.Context, userID string) (*idmsvc.IdentityUser, error) {
+	var found user
+	err := s.call(ctx, "graph_get_user", "GET", apiPath("users", userID)+"?$select="+userSelect, nil, &found)
+	if err != nil {
+		return nil, err
+	}
+	return identityUser(&found), nil
+}
+
+// FindUserByName looks up the user by exact user principal name and returns nil without
+// error when there is none
+func (s *Service) FindUserByName(ctx context.Context, name string) (*idmsvc.IdentityUser, error) {
+	var found page[user]
+	filter := neturl.QueryEscape("userPrincipalName eq " + odataString(name))
+	err := s.call(ctx, "graph_find_user", "GET", apiPath("users")+"?$filter="+filter+"&$select="+userSelect, nil, &found)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range found.Value {
+		if strings.EqualFold(found.Value[i].UserPrincipalName, name) {
+			return identityUser(&found.Value[i]), nil
+		}
+	}
+	return nil, nil
+}
+
+// Graph has no idempotency keys, creations are not deduplicated
+
+func (s *Service) FindUserByIdempotencyKey(ctx context.Context, key string) (*idmsvc.IdentityUser, error) {
+	return nil, idmsvc.ErrNotSupported
+}
+
+// ListUsers returns a page of the users of the tenant, paginated by cursor. The cursor is
+// the next link of the previous page.
+func (s *Service) ListUsers(ctx context.Context, filter idmsvc.UserFilter, pageOpts idmsvc.PageOptions) (*idmsvc.UserPage, error) {
+	limit := pageOpts.Limit
+	if limit <= 0 {
+		limit = listPageSize
+	}
+	path := apiPath("users") + "?$top=" + strconv.Itoa(limit) + "&$select=" + userSelect
+	if filter.Name != "" {
+		path += "&$filter=" + neturl.QueryEscape("userPrincipalName eq "+odataString(filter.Name))
+	}
+	if pageOpts.Cursor != "" {
+		if !strings.HasPrefix(pageOpts.Cursor, apiPath("users")+"?") {
+			return nil, fmt.Errorf("invalid cursor %q", pageOpts.Cursor)
+		}
+		path = pageOpts.Cursor
+	}
+
+	var found page[user]
+	err := s.call(ctx, "graph_list_users", "GET", path, nil, &found)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &idmsvc.UserPage{}
+	for i := range found.Value {
+		if filter.Name == "" || strings.EqualFold(found.Value[i].UserPrincipalName, filter.Name) {
+			result.Users = append(result.Users, *identityUser(&found.Value[i]))
+		}
+	}
+	if next := s.nextPath(found.NextLink); next != "" {
+		result.Next = &idmsvc.PageOptions{Limit: limit, Cursor: next}
+	}
+	return result, nil
+}
+
+// UpdateUser sets all properties of the user and its password, Graph updates users only
+// partially
+func (s *Service) UpdateUser(ctx context.Context, userID string, spec *v1.UserSpec) (*idmsvc.IdentityUser, error) {
+	body := userFor(spec)
+	if spec.Password != "" {
+		body["passwordProfile"] = &passwordProfile{Password: spec.Password}
+	}
+	current, err := s.GetUser(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	body["onPremisesExtensionAttributes"] = extensionAttributesFor(current.Attributes, spec.Attributes)
+
+	err = s.call(ctx, "graph_update_user", "PATCH", apiPath("users", userID), body, nil)
+	if err != nil {
+		return nil, err
+	}
+	return s.GetUser(ctx, userID)
+}
+
+// PatchUser sends only the given fields of the user, Graph keeps the others. Extension
+// attributes missing from the spec are cleared. Falls back to UpdateUser for instances
+// configured without partial updates.
+func (s *Service) PatchUser(ctx context.Context, userID string, spec *v1.UserSpec, fields []string) (*idmsvc.IdentityUser, error) {
+	if !s.config.PatchUpdates() {
+		return s.UpdateUser(ctx, userID, spec)
+	}
+
+	desired := userFor(spec)
+	body := map[string]interface{}{}
+	for _, field := range fields {
+		switch field {
+		case "password":
+			if spec.Password != "" {
+				body["passwordProfile"] = &passwordProfile{Password: spec.Password}
+			}
+		case "attributes":
+			current, err := s.GetUser(ctx, userID)
+			if err != nil {
+				return nil, err
+			}
+			body["onPremisesExtensionAttributes"] = extensionAttributesFor(current.Attributes, spec.Attributes)
+		default:
+			if property, ok := userProperties[field]; ok {
+				// unset fields are cleared
+				body[property] = desired[property]
+			}
+		}
+	}
+
+	if len(body) > 0 {
+		err := s.call(ctx, "graph_patch_user", "PATCH", apiPath("users", userID), body, nil)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return s.GetUser(ctx, userID)
+}
+
+// DeleteUser deletes the user, Graph keeps it restorable among the deleted items for 30 days
+func (s *Service) DeleteUser(ctx context.Context, userID string) error {
+	return s.call(ctx, "graph_delete_user", "DELETE", apiPath("users", userID), nil, nil)
+}
+
+// Graph batches report the created users, but are limited to 20 requests without
+// deduplication, users are created one by one
+
+func (s *Service) CreateUsers(ctx context.Context, specs []*v1.UserSpec) ([]idmsvc.BulkResult, error) {
+	return nil, idmsvc.ErrNotSupported
+}
+
+// userFor converts the User spec into the properties of a Graph user. Unset properties
+// are null, which clears them. The display name is required, the user principal name
+// stands in for it.
+func userFor(spec *v1.UserSpec) map[string]interface{} {
+	body := map[string]interface{}{}
+	for _, property := range userProperties {
+		body[property] = nil
+	}
+	body["userPrincipalName"] = spec.Name
+	body["accountEnabled"] = spec.IsEnabled()
+	setString(body, "givenName", spec.Firstname)
+	setString(body, "surname", spec.Lastname)
+	setString(body, "mail", spec.Email)
+	setString(body, "mobilePhone", spec.Phone)
+	setString(body, "jobTitle", idmsvc.JoinRoles(spec))
+	body["displayName"] = spec.DisplayName
+	if spec.DisplayName == "" {
+		body["displayName"] = spec.Name
+	}
+	return body
+}
+
+// setString sets the property, unset properties are cleared with null
+func setString(body map[string]interface{}, property, value string) {
+	if value != "" {
+		body[property] = value
+	}
+}
+
+// extensionAttributesFor returns the extension attributes setting the desired ones and
+// clearing the current ones missing from them. Other attributes have no place in Graph.
+func extensionAttributesFor(current, desired map[string]string) map[string]interface{} {
+	attributes := map[string]interface{}{}
+	for attribute := range current {
+		attributes[attribute] = nil
+	}
+	for attribute, value := range desired {
+		if isExtensionAttribute(attribute) {
+			attributes[attribute] = value
+		}
+	}
+	return attributes
+}
+
+// isExtensionAttribute reports whether the attribute is one of the extension attributes
+// of Graph users
+func isExtensionAttribute(attribute string) bool {
+	n, err := strconv.Atoi(strings.TrimPrefix(attribute, "extensionAttribute"))
+	return err == nil && strings.HasPrefix(attribute, "extensionAttribute") && n >= 1 && n <= extensionAttributes
+}
+
+// mailNickname returns the mail alias Graph requires for new users, the local part of the
+// user principal name
+func mailNickname(name string) string {
+	if at := strings.IndexByte(name, '@'); at > 0 {
+		return name[:at]
+	}
+	return name
+}
+
+// identityUser converts a Graph user into the user of the identity API. The display name
+// defaulted to the user principal name reads back as unset.
+func identityUser(u *user) *idmsvc.IdentityUser {
+	usr := &idmsvc.IdentityUser{
+		ID:          u.ID,
+		Name:        u.UserPrincipalName,
+		Firstname:   u.GivenName,
+		Lastname:    u.Surname,
+		Email:       u.Mail,
+		Phone:       u.MobilePhone,
+		DisplayName: u.DisplayName,
+		Enabled:     u.AccountEnabled,
+	}
+	usr.SetRoles(idmsvc.SplitRoles(u.JobTitle))
+	if usr.DisplayName == usr.Name {
+		usr.DisplayName = ""
+	}
+	for attribute, value := range u.OnPremisesExtensionAttributes {
+		if value == "" {
+			continue
+		}
+		if usr.Attributes == nil {
+			usr.Attributes = map[string]string{}
+		}
+		usr.Attributes[attribute] = value
+	}
+	return usr
+}
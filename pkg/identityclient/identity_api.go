@@ -0,0 +1,75 @@
+// Package identityclient is the Go client of the identity API the operator manages users,
+// roles, groups and API keys with. Every operation takes a context, which bounds the
+// request and carries its request ID and idempotency key. The client is configured with
+// functional options, e.g.
+//
+//	client := identityclient.New(
+//		identityclient.WithScheme("https"),
+//		identityclient.WithHost("idm.example.com"),
+//		identityclient.WithToken(token),
+//		identityclient.WithCABundle(caBundle),
+//		identityclient.WithRetry(5, 100*time.Millisecond, 5*time.Second),
+//	)
+//
+// Requests are sent with the RoundTripper given with WithTransport, or one built from the
+// TLS, proxy and connection pool options, wrapped with the middlewares given with
+// WithMiddleware. A client is safe for concurrent use, e.g. by the workers of a controller.
+//
+// The subpackages implement the same IdentityAPI against Keycloak, Okta, SCIM service
+// providers and Microsoft Graph, the fake subpackage keeps everything in memory for tests.
+package identityclient
+
+import (
+	"context"
+
+	v1 "github.com/m15ch4/go-identity-operator/api/v1"
+)
+
+// IdentityAPI is the set of identity app operations used by the controllers.
+// IdentityService implements it against the REST API, tests use the in-memory fake.
+type IdentityAPI interface {
+	GetToken(ctx context.Context) (string, error)
+	SetCredentials(user, pass string)
+	Info(ctx context.Context) (*BackendInfo, error)
+
+	CreateUser(ctx context.Context, user *v1.UserSpec) (*IdentityUser, error)
+	CreateUsers(ctx context.Context, users []*v1.UserSpec) ([]BulkResult, error)
+	GetUser(ctx context.Context, userID string) (*IdentityUser, error)
+	FindUserByName(ctx context.Context, name string) (*IdentityUser, error)
+	FindUserByIdempotencyKey(ctx context.Context, key string) (*IdentityUser, error)
+	ListUsers(ctx context.Context, filter UserFilter, pageOpts PageOptions) (*UserPage, error)
+	UpdateUser(ctx context.Context, userID string, user *v1.UserSpec) (*IdentityUser, error)
+	PatchUser(ctx context.Context, userID string, user *v1.UserSpec, fields []string) (*IdentityUser, error)
+	DeleteUser(ctx context.Context, userID string) error
+
+	CreateRole(ctx context.Context, role *v1.RoleSpec) (*IdentityRole, error)
+	GetRole(ctx context.Context, roleID string) (*IdentityRole, error)
+	UpdateRole(ctx context.Context, roleID string, role *v1.RoleSpec) (*IdentityRole, error)
+	DeleteRole(ctx context.Context, roleID string) error
+	ListUserRoles(ctx context.Context, userID, scope string) ([]string, error)
+	AddUserRole(ctx context.Context, userID, scope, role string) error
+	RemoveUserRole(ctx context.Context, userID, scope, role string) error
+
+	CreateGroup(ctx context.Context, group *v1.GroupSpec) (*IdentityGroup, error)
+	GetGroup(ctx context.Context, groupID string) (*IdentityGroup, error)
+	UpdateGroup(ctx context.Context, groupID string, group *v1.GroupSpec) (*IdentityGroup, error)
+	DeleteGroup(ctx context.Context, groupID string) error
+	ListGroupMembers(ctx context.Context, groupID string) ([]string, error)
+	AddGroupMember(ctx context.Context, groupID, userID string) error
+	RemoveGroupMember(ctx context.Context, groupID, userID string) error
+	AddChildGroup(ctx context.Context, parentID, groupID string) error
+	RemoveChildGroup(ctx context.Context, parentID, groupID string) error
+
+	CreateAPIKey(ctx context.Context, key *v1.ApiKeySpec) (*IdentityAPIKey, error)
+	RotateAPIKey(ctx context.Context, keyID string) (*IdentityAPIKey, error)
+	DeleteAPIKey(ctx context.Context, keyID string) error
+}
+
+var _ IdentityAPI = &IdentityService{}
+
+// New returns the client of the REST API of the identity app configured with the options.
+// Options not given fall back to the IDM_* environment variables and then to the defaults.
+func New(opts ...ConfigOpts) IdentityAPI {
+	cfg := NewIdentityConfig(opts...)
+	return NewIdentityService(&cfg)
+}
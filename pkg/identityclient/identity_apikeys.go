@@ -0,0 +1,43 @@
+package identityclient
+
+import (
+	"context"
+
+	v1 "github.com/m15ch4/go-identity-operator/api/v1"
+)
+
+// IdentityAPIKey is the credential of a machine identity. Key is only returned when the
+// key is created or rotated.
+type IdentityAPIKey struct {
+	ID          string `json:"id,omitempty"`
+	Name        string `json:"name,omitempty"`
+	Description string `json:"description,omitempty"`
+	Key         string `json:"key,omitempty"`
+}
+
+// CreateAPIKey makes REST API call to /apikeys of identity app and returns the generated key.
+// REST API call uses POST HTTP method.
+func (s *IdentityService) CreateAPIKey(ctx context.Context, key *v1.ApiKeySpec) (*IdentityAPIKey, error) {
+	var keyResponse IdentityAPIKey
+	err := s.call(ctx, "create_apikey", "POST", "/apikeys", &IdentityAPIKey{Name: key.Name, Description: key.Description}, &keyResponse)
+	if err != nil {
+		return nil, err
+	}
+	return &keyResponse, nil
+}
+
+// RotateAPIKey replaces the key with the given ID by a newly generated one, which is returned.
+// The previous key stops working.
+func (s *IdentityService) RotateAPIKey(ctx context.Context, keyID string) (*IdentityAPIKey, error) {
+	var keyResponse IdentityAPIKey
+	err := s.call(ctx, "rotate_apikey", "POST", "/apikeys/"+keyID+"/rotate", nil, &keyResponse)
+	if err != nil {
+		return nil, err
+	}
+	return &keyResponse, nil
+}
+
+// DeleteAPIKey revokes the key with the given ID in external identity app using REST API call.
+func (s *IdentityService) DeleteAPIKey(ctx context.Context, keyID string) error {
+	return s.call(ctx, "delete_apikey", "DELETE", "/apikeys/"+keyID, nil, nil)
+}
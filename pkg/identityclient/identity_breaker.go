@@ -0,0 +1,186 @@
+package identityclient
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// CircuitState is the state of the circuit breaker guarding an identity app
+type CircuitState int
+
+const (
+	// CircuitClosed lets all requests through
+	CircuitClosed CircuitState = iota
+	// CircuitHalfOpen lets a single trial request through after the cool-down
+	CircuitHalfOpen
+	// CircuitOpen fails all requests fast until the cool-down elapses
+	CircuitOpen
+)
+
+func (s CircuitState) String() string {
+	switch s {
+	case CircuitHalfOpen:
+		return "HalfOpen"
+	case CircuitOpen:
+		return "Open"
+	default:
+		return "Closed"
+	}
+}
+
+// CircuitOpenError is returned without contacting the identity app while its circuit breaker is open
+type CircuitOpenError struct {
+	Endpoint string
+	// RetryAfter is the remaining cool-down
+	RetryAfter time.Duration
+}
+
+func (e *CircuitOpenError) Error() string {
+	return fmt.Sprintf("circuit breaker for %s is open, retry in %s", e.Endpoint, e.RetryAfter.Round(time.Second))
+}
+
+// IsCircuitOpen reports whether err was returned because the circuit breaker is open
+func IsCircuitOpen(err error) bool {
+	var circuitErr *CircuitOpenError
+	return errors.As(err, &circuitErr)
+}
+
+// breaker counts consecutive failed requests to an identity app and opens once they reach
+// the threshold. After the cool-down one trial request is let through, its outcome closes
+// or reopens the circuit.
+type breaker struct {
+	endpoint  string
+	threshold int
+	coolDown  time.Duration
+
+	mu       sync.Mutex
+	state    CircuitState
+	failures int
+	openedAt time.Time
+}
+
+var (
+	// breakers are shared by all clients of the same identity app, so the state survives
+	// the short-lived services built for every reconciliation
+	breakersMu sync.Mutex
+	breakers   = map[string]*breaker{}
+)
+
+// breakerFor returns the circuit breaker of the identity app at endpoint, creating it on
+// first use. The settings of the latest client apply, so changing them on an
+// IdentityInstance takes effect without restarting the operator.
+func breakerFor(endpoint string, threshold int, coolDown time.Duration) *breaker {
+	breakersMu.Lock()
+	b, ok := breakers[endpoint]
+	if !ok {
+		b = &breaker{endpoint: endpoint}
+		breakers[endpoint] = b
+		circuitState.WithLabelValues(endpoint).Set(float64(CircuitClosed))
+	}
+	breakersMu.Unlock()
+
+	b.mu.Lock()
+	b.threshold, b.coolDown = threshold, coolDown
+	b.mu.Unlock()
+	return b
+}
+
+// CircuitBreakerState returns the state of the circuit breaker of the identity app at endpoint
+func CircuitBreakerState(endpoint string) CircuitState {
+	breakersMu.Lock()
+	b, ok := breakers[endpoint]
+	breakersMu.Unlock()
+	if !ok {
+		return CircuitClosed
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.state
+}
+
+// allow returns a CircuitOpenError if the request must not be sent
+func (b *breaker) allow() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.state {
+	case CircuitOpen:
+		if elapsed := time.Since(b.openedAt); elapsed < b.coolDown {
+			return &CircuitOpenError{Endpoint: b.endpoint, RetryAfter: b.coolDown - elapsed}
+		}
+		b.setState(CircuitHalfOpen)
+		return nil
+	case CircuitHalfOpen:
+		// a trial request is in flight
+		return &CircuitOpenError{Endpoint: b.endpoint, RetryAfter: b.coolDown}
+	}
+	return nil
+}
+
+// record counts the outcome of a request let through by allow. A cancelled request tells
+// nothing about the identity app, a cancelled trial request reopens the circuit so the
+// next request after the cool-down is tried instead.
+func (b *breaker) record(outcome requestOutcome) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch outcome {
+	case outcomeCancelled:
+		if b.state == CircuitHalfOpen {
+			b.setState(CircuitOpen)
+		}
+		return
+	case outcomeSucceeded:
+		b.failures = 0
+		b.setState(CircuitClosed)
+		return
+	}
+
+	b.failures++
+	if b.state == CircuitHalfOpen || b.failures >= b.threshold {
+		b.openedAt = time.Now()
+		b.setState(CircuitOpen)
+	}
+}
+
+// setState changes the state and updates its metric; b.mu must be held
+func (b *breaker) setState(state CircuitState) {
+	b.state = state
+	circuitState.WithLabelValues(b.endpoint).Set(float64(state))
+}
+
+// breaker returns the circuit breaker of the client, or nil if it is disabled
+func (c *Client) breaker() *breaker {
+	if c.config.breakerThreshold <= 0 {
+		return nil
+	}
+	return breakerFor(c.config.BaseURL(), c.config.breakerThreshold, c.config.breakerCoolDown)
+}
+
+// requestOutcome is the outcome of a request as counted by the circuit breaker and the
+// FailureRate
+type requestOutcome int
+
+const (
+	outcomeSucceeded requestOutcome = iota
+	// outcomeFailed is a request that could not reach the identity app or failed with a
+	// server side error
+	outcomeFailed
+	// outcomeCancelled is a request abandoned by the caller, which counts as neither
+	outcomeCancelled
+)
+
+// outcomeOf returns the outcome of a request
+func outcomeOf(req *http.Request, resp *http.Response, err error) requestOutcome {
+	switch {
+	case err != nil && req.Context().Err() != nil:
+		return outcomeCancelled
+	case err != nil || resp.StatusCode >= 500:
+		return outcomeFailed
+	}
+	return outcomeSucceeded
+}
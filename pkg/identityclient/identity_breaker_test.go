@@ -0,0 +1,80 @@
+package identityclient_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	idmsvc "github.com/m15ch4/go-identity-operator/pkg/identityclient"
+)
+
+var _ = Describe("Circuit breaker", func() {
+	var server *httptest.Server
+
+	BeforeEach(func() {
+		server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			switch req.URL.Path {
+			case "/fail":
+				w.WriteHeader(http.StatusInternalServerError)
+			case "/hang":
+				<-req.Context().Done()
+			}
+		}))
+		DeferCleanup(server.Close)
+	})
+
+	// send sends a GET request for path with the client and reports whether it succeeded
+	send := func(ctx context.Context, c *idmsvc.Client, path string) bool {
+		req, err := http.NewRequestWithContext(ctx, "GET", server.URL+path, nil)
+		Expect(err).NotTo(HaveOccurred())
+		resp, err := c.Do("get", req)
+		if err != nil {
+			return false
+		}
+		resp.Body.Close()
+		return resp.StatusCode < 500
+	}
+
+	newClient := func(threshold int, coolDown time.Duration) (*idmsvc.Client, string) {
+		cfg := idmsvc.NewIdentityConfig(append(serverOpts(server.URL),
+			idmsvc.WithRetry(1, 0, 0), idmsvc.WithCircuitBreaker(threshold, coolDown))...)
+		return idmsvc.NewClient(&cfg), cfg.BaseURL()
+	}
+
+	It("reopens the circuit when the trial request is cancelled", func() {
+		c, endpoint := newClient(1, 50*time.Millisecond)
+		Expect(send(context.Background(), c, "/fail")).To(BeFalse())
+		Expect(idmsvc.CircuitBreakerState(endpoint)).To(Equal(idmsvc.CircuitOpen))
+		time.Sleep(60 * time.Millisecond)
+
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+		defer cancel()
+		Expect(send(ctx, c, "/hang")).To(BeFalse())
+		Expect(idmsvc.CircuitBreakerState(endpoint)).To(Equal(idmsvc.CircuitOpen))
+
+		// the cool-down already elapsed, the next request is the trial
+		Expect(send(context.Background(), c, "/")).To(BeTrue())
+		Expect(idmsvc.CircuitBreakerState(endpoint)).To(Equal(idmsvc.CircuitClosed))
+	})
+
+	It("applies the settings of the latest client of the identity app", func() {
+		c, endpoint := newClient(5, time.Minute)
+		Expect(send(context.Background(), c, "/fail")).To(BeFalse())
+		Expect(idmsvc.CircuitBreakerState(endpoint)).To(Equal(idmsvc.CircuitClosed))
+
+		c, _ = newClient(2, time.Minute)
+		Expect(send(context.Background(), c, "/fail")).To(BeFalse())
+		Expect(idmsvc.CircuitBreakerState(endpoint)).To(Equal(idmsvc.CircuitOpen))
+
+		err := func() error {
+			req, _ := http.NewRequest("GET", server.URL+"/", nil)
+			_, err := c.Do("get", req)
+			return err
+		}()
+		Expect(idmsvc.IsCircuitOpen(err)).To(BeTrue())
+	})
+})
@@ -0,0 +1,21 @@
+package identityclient
+
+import (
+	"context"
+
+	v1 "github.com/m15ch4/go-identity-operator/api/v1"
+)
+
+// BulkResult is the outcome of one operation of a bulk request
+type BulkResult struct {
+	// User is the created user, nil if the operation failed
+	User *IdentityUser
+	// Err is the reason the operation failed
+	Err error
+}
+
+// The identity app has no bulk endpoint, users are created one by one
+
+func (s *IdentityService) CreateUsers(ctx context.Context, users []*v1.UserSpec) ([]BulkResult, error) {
+	return nil, ErrNotSupported
+}
@@ -0,0 +1,642 @@
+package identityclient
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type ConfigOpts func(IdentityConfig) IdentityConfig
+
+type IdentityConfig struct {
+	scheme string
+	host   string
+	port   int
+	user   string
+	pass   string
+
+	// err is the error of reading the configuration from the environment, e.g. a CA file
+	// that cannot be read
+	err error
+
+	// credentialsDir is the directory the credentials are read from, re-read when the
+	// identity app rejects them
+	credentialsDir string
+
+	// hostSet is true once the host was set with IDM_HOST or WithHost
+	hostSet bool
+
+	// basePath is prepended to the path of every request, e.g. /scim/v2
+	basePath string
+	// realm is the realm managed in backends with multiple realms, e.g. Keycloak
+	realm string
+	// token authenticates requests to backends using a static bearer token
+	token string
+	// ldap locates the users and groups in LDAP directories
+	ldap LDAPConfig
+
+	// oauth2 client credentials used to obtain access tokens instead of logging in
+	// with user and password, when oauth2TokenURL is set
+	oauth2TokenURL     string
+	oauth2ClientID     string
+	oauth2ClientSecret string
+	oauth2Scopes       []string
+
+	// tokenTTL is used when the login response carries no expiry information
+	tokenTTL time.Duration
+
+	// requestTimeout bounds each HTTP request to the identity app, zero disables it
+	requestTimeout time.Duration
+
+	// lookupCacheTTL is how long backends keep the results of repeated lookups, such as
+	// the ID of a role by name
+	lookupCacheTTL time.Duration
+
+	// retryAttempts is the maximum number of attempts of a request, retries are delayed
+	// by an exponential backoff with jitter between retryBaseDelay and retryMaxDelay
+	retryAttempts  int
+	retryBaseDelay time.Duration
+	retryMaxDelay  time.Duration
+
+	// breakerThreshold consecutive failed requests open the circuit breaker of the identity app,
+	// which then fails requests fast for breakerCoolDown; a zero threshold disables the breaker
+	breakerThreshold int
+	breakerCoolDown  time.Duration
+
+	// patchUpdates sends only the changed fields of a user with PATCH instead of
+	// replacing the user with PUT, for backends supporting partial updates
+	patchUpdates bool
+
+	// attributeMapping moves fields of the users to nonstandard attributes of the backend
+	attributeMapping AttributeMapping
+
+	// connection pool settings of the HTTP transport, idle connections are kept alive
+	// for reuse by later requests until idleConnTimeout
+	maxIdleConns        int
+	maxIdleConnsPerHost int
+	idleConnTimeout     time.Duration
+	// maxConnsPerHost limits the connections to the identity app, idle or in use; zero
+	// means no limit
+	maxConnsPerHost int
+	// http2 negotiates HTTP/2 over TLS, some appliances misbehave with it
+	http2 bool
+	// tlsSessionCacheSize is the number of TLS sessions kept for resumption, which saves
+	// full handshakes on new connections; zero disables resumption
+	tlsSessionCacheSize int
+
+	// proxyURL is the forward proxy requests are sent through, the HTTP_PROXY, HTTPS_PROXY
+	// and NO_PROXY environment variables apply when it is empty
+	proxyURL  string
+	proxyUser string
+	proxyPass string
+	// noProxy lists the hosts, domains and CIDR ranges reached without proxyURL
+	noProxy []string
+
+	// TLS settings used when scheme is https
+	caBundle           []byte
+	insecureSkipVerify bool
+	clientCert         []byte
+	clientKey          []byte
+
+	// transport sends the requests instead of a transport built from the TLS, proxy and
+	// connection pool settings above
+	transport http.RoundTripper
+	// middlewares wrap the transport, the first one outermost
+	middlewares []Middleware
+}
+
+func WithScheme(scheme string) ConfigOpts {
+	return func(cfg IdentityConfig) IdentityConfig {
+		cfg.scheme = scheme
+		return cfg
+	}
+}
+
+func WithHost(host string) ConfigOpts {
+	return func(cfg IdentityConfig) IdentityConfig {
+		cfg.host = host
+		cfg.hostSet = host != ""
+		return cfg
+	}
+}
+
+func WithPort(port int) ConfigOpts {
+	return func(cfg IdentityConfig) IdentityConfig {
+		cfg.port = port
+		return cfg
+	}
+}
+
+func WithUser(user string) ConfigOpts {
+	return func(cfg IdentityConfig) IdentityConfig {
+		cfg.user = user
+		return cfg
+	}
+}
+
+func WithPass(pass string) ConfigOpts {
+	return func(cfg IdentityConfig) IdentityConfig {
+		cfg.pass = pass
+		return cfg
+	}
+}
+
+func WithBasePath(basePath string) ConfigOpts {
+	return func(cfg IdentityConfig) IdentityConfig {
+		cfg.basePath = basePath
+		return cfg
+	}
+}
+
+func WithRealm(realm string) ConfigOpts {
+	return func(cfg IdentityConfig) IdentityConfig {
+		cfg.realm = realm
+		return cfg
+	}
+}
+
+// LDAPConfig locates the users and groups in an LDAP directory
+type LDAPConfig struct {
+	// UsersDN is the DN of the entry users are created under
+	UsersDN string
+	// GroupsDN is the DN of the entry groups are created under
+	GroupsDN string
+	// ActiveDirectory selects the user and group entries of Active Directory instead of
+	// inetOrgPerson and groupOfNames entries
+	ActiveDirectory bool
+}
+
+// WithLDAP sets where the users and groups are kept in LDAP directories
+func WithLDAP(ldap LDAPConfig) ConfigOpts {
+	return func(cfg IdentityConfig) IdentityConfig {
+		cfg.ldap = ldap
+		return cfg
+	}
+}
+
+func WithToken(token string) ConfigOpts {
+	return func(cfg IdentityConfig) IdentityConfig {
+		cfg.token = token
+		return cfg
+	}
+}
+
+// WithClientCredentials authenticates with access tokens obtained from the OAuth2 token
+// endpoint with the client credentials grant, instead of logging in with user and password
+func WithClientCredentials(tokenURL, clientID, clientSecret string, scopes []string) ConfigOpts {
+	return func(cfg IdentityConfig) IdentityConfig {
+		cfg.oauth2TokenURL = tokenURL
+		cfg.oauth2ClientID = clientID
+		cfg.oauth2ClientSecret = clientSecret
+		cfg.oauth2Scopes = scopes
+		return cfg
+	}
+}
+
+// WithCredentialsDir reads the user, password and token from the IDM_USER, IDM_PASS and
+// IDM_TOKEN files in dir, e.g. a mounted Secret. Missing files leave the current values untouched.
+// The files are read again when the identity app rejects the login, so rotated credentials
+// of the Secret are picked up without a restart.
+func WithCredentialsDir(dir string) ConfigOpts {
+	return func(cfg IdentityConfig) IdentityConfig {
+		cfg.credentialsDir = dir
+		if user, err := os.ReadFile(filepath.Join(dir, "IDM_USER")); err == nil {
+			cfg.user = strings.TrimRight(string(user), "\r\n")
+		}
+		if pass, err := os.ReadFile(filepath.Join(dir, "IDM_PASS")); err == nil {
+			cfg.pass = strings.TrimRight(string(pass), "\r\n")
+		}
+		if token, err := os.ReadFile(filepath.Join(dir, "IDM_TOKEN")); err == nil {
+			cfg.token = strings.TrimRight(string(token), "\r\n")
+		}
+		return cfg
+	}
+}
+
+func WithTokenTTL(ttl time.Duration) ConfigOpts {
+	return func(cfg IdentityConfig) IdentityConfig {
+		cfg.tokenTTL = ttl
+		return cfg
+	}
+}
+
+// WithRequestTimeout sets the timeout of each HTTP request to the identity app
+func WithRequestTimeout(timeout time.Duration) ConfigOpts {
+	return func(cfg IdentityConfig) IdentityConfig {
+		cfg.requestTimeout = timeout
+		return cfg
+	}
+}
+
+// WithLookupCacheTTL sets how long the results of lookups repeated by the reconciles of
+// many objects, such as the ID of a role by name, are reused; zero disables the cache
+func WithLookupCacheTTL(ttl time.Duration) ConfigOpts {
+	return func(cfg IdentityConfig) IdentityConfig {
+		cfg.lookupCacheTTL = ttl
+		return cfg
+	}
+}
+
+// WithRetry sets the maximum number of attempts of a request and the bounds of the
+// backoff between them; one attempt disables retries
+func WithRetry(attempts int, baseDelay, maxDelay time.Duration) ConfigOpts {
+	return func(cfg IdentityConfig) IdentityConfig {
+		cfg.retryAttempts = attempts
+		cfg.retryBaseDelay = baseDelay
+		cfg.retryMaxDelay = maxDelay
+		return cfg
+	}
+}
+
+// WithCircuitBreaker sets the number of consecutive failed requests after which requests
+// to the identity app fail fast for the cool-down period; a zero threshold disables it
+func WithCircuitBreaker(threshold int, coolDown time.Duration) ConfigOpts {
+	return func(cfg IdentityConfig) IdentityConfig {
+		cfg.breakerThreshold = threshold
+		cfg.breakerCoolDown = coolDown
+		return cfg
+	}
+}
+
+// WithConnectionPool sets the number of idle connections kept alive in total and per
+// host of the identity app, and how long an idle connection is kept
+func WithConnectionPool(maxIdleConns, maxIdleConnsPerHost int, idleConnTimeout time.Duration) ConfigOpts {
+	return func(cfg IdentityConfig) IdentityConfig {
+		cfg.maxIdleConns = maxIdleConns
+		cfg.maxIdleConnsPerHost = maxIdleConnsPerHost
+		cfg.idleConnTimeout = idleConnTimeout
+		return cfg
+	}
+}
+
+// WithIdleConnTimeout sets how long an idle connection to the identity app is kept
+func WithIdleConnTimeout(timeout time.Duration) ConfigOpts {
+	return func(cfg IdentityConfig) IdentityConfig {
+		cfg.idleConnTimeout = timeout
+		return cfg
+	}
+}
+
+// WithMaxConnsPerHost limits the connections to the identity app, including those in use,
+// so requests beyond the limit wait for a connection; zero means no limit
+func WithMaxConnsPerHost(maxConnsPerHost int) ConfigOpts {
+	return func(cfg IdentityConfig) IdentityConfig {
+		cfg.maxConnsPerHost = maxConnsPerHost
+		return cfg
+	}
+}
+
+// WithHTTP2 selects whether HTTP/2 is negotiated with identity apps served over TLS,
+// requests are sent with HTTP/1.1 otherwise
+func WithHTTP2(enabled bool) ConfigOpts {
+	return func(cfg IdentityConfig) IdentityConfig {
+		cfg.http2 = enabled
+		return cfg
+	}
+}
+
+// WithTLSSessionCache keeps up to size TLS sessions for resumption, so new connections to
+// the identity app skip the full handshake; zero disables resumption
+func WithTLSSessionCache(size int) ConfigOpts {
+	return func(cfg IdentityConfig) IdentityConfig {
+		cfg.tlsSessionCacheSize = size
+		return cfg
+	}
+}
+
+// WithPatchUpdates selects whether users are updated with PATCH requests carrying only
+// the changed fields or with PUT requests replacing the whole user
+func WithPatchUpdates(enabled bool) ConfigOpts {
+	return func(cfg IdentityConfig) IdentityConfig {
+		cfg.patchUpdates = enabled
+		return cfg
+	}
+}
+
+// WithCABundle sets PEM encoded CA certificates used to verify the identity app
+func WithCABundle(caBundle []byte) ConfigOpts {
+	return func(cfg IdentityConfig) IdentityConfig {
+		cfg.caBundle = caBundle
+		return cfg
+	}
+}
+
+// WithInsecureSkipVerify disables verification of the identity app certificate
+func WithInsecureSkipVerify(insecureSkipVerify bool) ConfigOpts {
+	return func(cfg IdentityConfig) IdentityConfig {
+		cfg.insecureSkipVerify = insecureSkipVerify
+		return cfg
+	}
+}
+
+// WithClientCertificate sets the PEM encoded client certificate and key used for mutual TLS
+func WithClientCertificate(cert, key []byte) ConfigOpts {
+	return func(cfg IdentityConfig) IdentityConfig {
+		cfg.clientCert = cert
+		cfg.clientKey = key
+		return cfg
+	}
+}
+
+// WithProxy sends requests through the forward proxy at proxyURL, except for requests to
+// the hosts, domains and CIDR ranges in noProxy
+func WithProxy(proxyURL string, noProxy []string) ConfigOpts {
+	return func(cfg IdentityConfig) IdentityConfig {
+		cfg.proxyURL = proxyURL
+		cfg.noProxy = noProxy
+		return cfg
+	}
+}
+
+// WithTransport sends the requests with the given RoundTripper instead of a transport built
+// from the TLS, proxy and connection pool settings, e.g. to share one transport between
+// clients or to stub the identity app in tests
+func WithTransport(transport http.RoundTripper) ConfigOpts {
+	return func(cfg IdentityConfig) IdentityConfig {
+		cfg.transport = transport
+		return cfg
+	}
+}
+
+// WithMiddleware wraps the transport with the given middlewares, the first one outermost.
+// They see the requests before the Authorization header is added.
+func WithMiddleware(middlewares ...Middleware) ConfigOpts {
+	return func(cfg IdentityConfig) IdentityConfig {
+		cfg.middlewares = append(append([]Middleware(nil), cfg.middlewares...), middlewares...)
+		return cfg
+	}
+}
+
+// WithProxyCredentials sets the user and password used to authenticate to the proxy
+func WithProxyCredentials(user, pass string) ConfigOpts {
+	return func(cfg IdentityConfig) IdentityConfig {
+		cfg.proxyUser = user
+		cfg.proxyPass = pass
+		return cfg
+	}
+}
+
+// BaseURL returns the URL of the identity app all request paths are relative to
+func (cfg *IdentityConfig) BaseURL() string {
+	return cfg.scheme + "://" + cfg.host + ":" + strconv.Itoa(cfg.port) + strings.TrimRight(cfg.basePath, "/")
+}
+
+// Credentials returns the user and password used to authenticate to the identity app
+func (cfg *IdentityConfig) Credentials() (user, pass string) {
+	return cfg.user, cfg.pass
+}
+
+// UsesClientCredentials reports whether access tokens are obtained with the OAuth2 client
+// credentials grant
+func (cfg *IdentityConfig) UsesClientCredentials() bool {
+	return cfg.oauth2TokenURL != ""
+}
+
+// Realm returns the realm managed in backends with multiple realms
+func (cfg *IdentityConfig) Realm() string {
+	return cfg.realm
+}
+
+// LDAP returns where the users and groups are kept in LDAP directories
+func (cfg *IdentityConfig) LDAP() LDAPConfig {
+	return cfg.ldap
+}
+
+// Address returns the host and port of the identity system, for backends not speaking HTTP
+func (cfg *IdentityConfig) Address() string {
+	return net.JoinHostPort(cfg.host, strconv.Itoa(cfg.port))
+}
+
+// UsesTLS reports whether the connections to the identity system are secured with TLS
+func (cfg *IdentityConfig) UsesTLS() bool {
+	return cfg.scheme == "https"
+}
+
+// RequestTimeout returns the timeout of each request to the identity system
+func (cfg *IdentityConfig) RequestTimeout() time.Duration {
+	return cfg.requestTimeout
+}
+
+// Err returns the error of reading the configuration from the environment, e.g. an
+// IDM_CA_FILE that cannot be read, so the operator fails at startup instead of at the
+// first request
+func (cfg *IdentityConfig) Err() error {
+	return cfg.err
+}
+
+// PatchUpdates reports whether users are updated with PATCH instead of PUT
+func (cfg *IdentityConfig) PatchUpdates() bool {
+	return cfg.patchUpdates
+}
+
+// LookupCacheTTL returns how long the results of repeated lookups are reused
+func (cfg *IdentityConfig) LookupCacheTTL() time.Duration {
+	return cfg.lookupCacheTTL
+}
+
+// Token returns the static bearer token used to authenticate to the identity app, if any
+func (cfg *IdentityConfig) Token() string {
+	return cfg.token
+}
+
+// HasHost reports whether the host of the identity app was configured, rather than left
+// at the default 127.0.0.1
+func (cfg *IdentityConfig) HasHost() bool {
+	return cfg.hostSet
+}
+
+func NewIdentityConfig(opts ...ConfigOpts) IdentityConfig {
+	cfg := IdentityConfig{
+		scheme: "http",
+		host:   "127.0.0.1",
+		port:   8080,
+		user:   "John",
+		pass:   "VMw@re1!",
+
+		tokenTTL:       5 * time.Minute,
+		requestTimeout: 30 * time.Second,
+		lookupCacheTTL: 10 * time.Second,
+		retryAttempts:  3,
+		retryBaseDelay: 100 * time.Millisecond,
+		retryMaxDelay:  5 * time.Second,
+
+		breakerThreshold: 5,
+		breakerCoolDown:  30 * time.Second,
+
+		patchUpdates: true,
+
+		maxIdleConns:        100,
+		maxIdleConnsPerHost: 10,
+		idleConnTimeout:     90 * time.Second,
+		http2:               true,
+	}
+
+	//read scheme from env
+	scheme := os.Getenv("IDM_SCHEME")
+	if scheme != "" {
+		cfg.scheme = scheme
+	}
+
+	//read host from env
+	host := os.Getenv("IDM_HOST")
+	if host != "" {
+		cfg.host = host
+		cfg.hostSet = true
+	}
+
+	//read port from env
+	port := os.Getenv("IDM_PORT")
+	if port != "" {
+		cfg.port, _ = strconv.Atoi(port)
+	}
+
+	//read user from env
+	user := os.Getenv("IDM_USER")
+	if user != "" {
+		cfg.user = user
+	}
+
+	//read pass from env
+	pass := os.Getenv("IDM_PASS")
+	if pass != "" {
+		cfg.pass = pass
+	}
+
+	//read request timeout from env
+	requestTimeout := os.Getenv("IDM_REQUEST_TIMEOUT")
+	if requestTimeout != "" {
+		if timeout, err := time.ParseDuration(requestTimeout); err == nil {
+			cfg.requestTimeout = timeout
+		}
+	}
+
+	//read lookup cache TTL from env
+	lookupCacheTTL := os.Getenv("IDM_LOOKUP_CACHE_TTL")
+	if lookupCacheTTL != "" {
+		if ttl, err := time.ParseDuration(lookupCacheTTL); err == nil {
+			cfg.lookupCacheTTL = ttl
+		}
+	}
+
+	//read retry attempts from env
+	retryAttempts := os.Getenv("IDM_RETRY_ATTEMPTS")
+	if retryAttempts != "" {
+		cfg.retryAttempts, _ = strconv.Atoi(retryAttempts)
+	}
+
+	//read circuit breaker settings from env
+	breakerThreshold := os.Getenv("IDM_BREAKER_THRESHOLD")
+	if breakerThreshold != "" {
+		cfg.breakerThreshold, _ = strconv.Atoi(breakerThreshold)
+	}
+	breakerCoolDown := os.Getenv("IDM_BREAKER_COOLDOWN")
+	if breakerCoolDown != "" {
+		if coolDown, err := time.ParseDuration(breakerCoolDown); err == nil {
+			cfg.breakerCoolDown = coolDown
+		}
+	}
+
+	//read connection pool settings from env
+	maxIdleConns := os.Getenv("IDM_MAX_IDLE_CONNS")
+	if maxIdleConns != "" {
+		cfg.maxIdleConns, _ = strconv.Atoi(maxIdleConns)
+	}
+	maxIdleConnsPerHost := os.Getenv("IDM_MAX_IDLE_CONNS_PER_HOST")
+	if maxIdleConnsPerHost != "" {
+		cfg.maxIdleConnsPerHost, _ = strconv.Atoi(maxIdleConnsPerHost)
+	}
+	idleConnTimeout := os.Getenv("IDM_IDLE_CONN_TIMEOUT")
+	if idleConnTimeout != "" {
+		if timeout, err := time.ParseDuration(idleConnTimeout); err == nil {
+			cfg.idleConnTimeout = timeout
+		}
+	}
+	maxConnsPerHost := os.Getenv("IDM_MAX_CONNS_PER_HOST")
+	if maxConnsPerHost != "" {
+		cfg.maxConnsPerHost, _ = strconv.Atoi(maxConnsPerHost)
+	}
+	http2 := os.Getenv("IDM_HTTP2")
+	if http2 != "" {
+		if enabled, err := strconv.ParseBool(http2); err == nil {
+			cfg.http2 = enabled
+		}
+	}
+	tlsSessionCacheSize := os.Getenv("IDM_TLS_SESSION_CACHE_SIZE")
+	if tlsSessionCacheSize != "" {
+		cfg.tlsSessionCacheSize, _ = strconv.Atoi(tlsSessionCacheSize)
+	}
+
+	//read update method from env
+	patchUpdates := os.Getenv("IDM_PATCH_UPDATES")
+	if patchUpdates != "" {
+		cfg.patchUpdates, _ = strconv.ParseBool(patchUpdates)
+	}
+
+	//read token from env
+	token := os.Getenv("IDM_TOKEN")
+	if token != "" {
+		cfg.token = token
+	}
+
+	//read OAuth2 client credentials from env
+	oauth2TokenURL := os.Getenv("IDM_OAUTH2_TOKEN_URL")
+	if oauth2TokenURL != "" {
+		var scopes []string
+		if value := os.Getenv("IDM_OAUTH2_SCOPES"); value != "" {
+			scopes = strings.Fields(value)
+		}
+		cfg = WithClientCredentials(oauth2TokenURL, os.Getenv("IDM_OAUTH2_CLIENT_ID"), os.Getenv("IDM_OAUTH2_CLIENT_SECRET"), scopes)(cfg)
+	}
+
+	//read CA bundle from file
+	caFile := os.Getenv("IDM_CA_FILE")
+	if caFile != "" {
+		caBundle, err := os.ReadFile(caFile)
+		if err != nil {
+			cfg.err = errors.Join(cfg.err, fmt.Errorf("unable to read IDM_CA_FILE: %w", err))
+		}
+		cfg.caBundle = caBundle
+	}
+
+	//read insecure skip verify from env
+	insecureSkipVerify := os.Getenv("IDM_INSECURE_SKIP_VERIFY")
+	if insecureSkipVerify != "" {
+		cfg.insecureSkipVerify, _ = strconv.ParseBool(insecureSkipVerify)
+	}
+
+	//read client certificate from files
+	clientCertFile := os.Getenv("IDM_CLIENT_CERT_FILE")
+	clientKeyFile := os.Getenv("IDM_CLIENT_KEY_FILE")
+	switch {
+	case clientCertFile != "" && clientKeyFile != "":
+		clientCert, err := os.ReadFile(clientCertFile)
+		if err != nil {
+			cfg.err = errors.Join(cfg.err, fmt.Errorf("unable to read IDM_CLIENT_CERT_FILE: %w", err))
+		}
+		clientKey, err := os.ReadFile(clientKeyFile)
+		if err != nil {
+			cfg.err = errors.Join(cfg.err, fmt.Errorf("unable to read IDM_CLIENT_KEY_FILE: %w", err))
+		}
+		cfg.clientCert, cfg.clientKey = clientCert, clientKey
+	case clientCertFile != "" || clientKeyFile != "":
+		cfg.err = errors.Join(cfg.err, errors.New("IDM_CLIENT_CERT_FILE and IDM_CLIENT_KEY_FILE must be set together"))
+	}
+
+	//read credentials from mounted secret
+	credentialsDir := os.Getenv("IDM_CREDENTIALS_DIR")
+	if credentialsDir != "" {
+		cfg = WithCredentialsDir(credentialsDir)(cfg)
+	}
+
+	for _, opt := range opts {
+		cfg = opt(cfg)
+	}
+
+	return cfg
+}
@@ -0,0 +1,180 @@
+package identityclient
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// ErrNotSupported is returned by backends for operations they cannot perform, e.g.
+// managing roles in a backend without a notion of roles
+var ErrNotSupported = errors.New("operation not supported by the identity backend")
+
+// APIError is returned when the identity app responds with a non-2xx status code
+type APIError struct {
+	StatusCode int
+	// Body of the response, truncated for readability
+	Body      string
+	Retryable bool
+	// RetryAfter is the delay requested by the Retry-After header, if any
+	RetryAfter time.Duration
+}
+
+func (e *APIError) Error() string {
+	if e.Body == "" {
+		return fmt.Sprintf("identity api returned status %d", e.StatusCode)
+	}
+	return fmt.Sprintf("identity api returned status %d: %s", e.StatusCode, e.Body)
+}
+
+// CredentialsError is returned when the identity system rejects the credentials the
+// operator logs in with, as opposed to an expired token
+type CredentialsError struct {
+	Err error
+}
+
+func (e *CredentialsError) Error() string {
+	return fmt.Sprintf("identity system rejected the credentials: %v", e.Err)
+}
+
+func (e *CredentialsError) Unwrap() error {
+	return e.Err
+}
+
+// LoginError returns the error of a login as a CredentialsError if the identity system
+// rejected the credentials with 400, 401 or 403, e.g. for a wrong password or an unknown
+// OAuth2 client. Other errors are returned as is.
+func LoginError(err error) error {
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) {
+		return err
+	}
+	switch apiErr.StatusCode {
+	case http.StatusBadRequest, http.StatusUnauthorized, http.StatusForbidden:
+		return &CredentialsError{Err: err}
+	}
+	return err
+}
+
+// newAPIError builds an APIError for the given response and response body.
+// Timeouts, throttling and server side errors are considered retryable.
+func newAPIError(resp *http.Response, body []byte) *APIError {
+	apiErr := NewAPIError(resp.StatusCode, body)
+	apiErr.RetryAfter = parseRetryAfter(resp.Header.Get("Retry-After"))
+	return apiErr
+}
+
+// NewAPIError builds an APIError for a status code reported without an HTTP response of
+// its own, e.g. for a single operation of a bulk request
+func NewAPIError(statusCode int, body []byte) *APIError {
+	return &APIError{
+		StatusCode: statusCode,
+		Body:       truncate(body),
+		Retryable:  statusCode == http.StatusRequestTimeout || statusCode == http.StatusTooManyRequests || statusCode >= 500,
+	}
+}
+
+// parseRetryAfter parses a Retry-After header given either in seconds or as an HTTP date
+func parseRetryAfter(value string) time.Duration {
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if date, err := http.ParseTime(value); err == nil {
+		if delay := time.Until(date); delay > 0 {
+			return delay
+		}
+	}
+	return 0
+}
+
+// CheckResponse returns an APIError if the status code is not 2xx
+func CheckResponse(resp *http.Response, body []byte) error {
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return newAPIError(resp, body)
+	}
+	return nil
+}
+
+// IsNotSupported reports whether err is ErrNotSupported, possibly wrapped
+func IsNotSupported(err error) bool {
+	return errors.Is(err, ErrNotSupported)
+}
+
+// IsStatus reports whether err is an APIError with the given status code
+func IsStatus(err error, statusCode int) bool {
+	var apiErr *APIError
+	return errors.As(err, &apiErr) && apiErr.StatusCode == statusCode
+}
+
+// IsNotFound reports whether err is a 404 returned by the identity app
+func IsNotFound(err error) bool {
+	return IsStatus(err, http.StatusNotFound)
+}
+
+// IsUnauthorized reports whether err is a 401 returned by the identity app
+func IsUnauthorized(err error) bool {
+	return IsStatus(err, http.StatusUnauthorized)
+}
+
+// IsConflict reports whether err is a 409 returned by the identity app
+func IsConflict(err error) bool {
+	return IsStatus(err, http.StatusConflict)
+}
+
+// IsCredentialsInvalid reports whether err is a login rejected by the identity system
+func IsCredentialsInvalid(err error) bool {
+	var credentialsErr *CredentialsError
+	return errors.As(err, &credentialsErr)
+}
+
+// IsRetryable reports whether err is an APIError worth retrying
+func IsRetryable(err error) bool {
+	var apiErr *APIError
+	return errors.As(err, &apiErr) && apiErr.Retryable
+}
+
+// IsUnavailable reports whether err indicates the identity app could not be reached or
+// failed to serve the request, as opposed to rejecting it
+func IsUnavailable(err error) bool {
+	if err == nil || errors.Is(err, ErrNotSupported) {
+		return false
+	}
+	var apiErr *APIError
+	if errors.As(err, &apiErr) {
+		return apiErr.StatusCode >= 500
+	}
+	return true
+}
+
+// IsTerminal reports whether err will not succeed without a change on the caller's side,
+// i.e. an unsupported operation or a client error other than an expired token or throttling.
+// Rejected credentials are not terminal, they succeed once the credentials are fixed.
+func IsTerminal(err error) bool {
+	if IsCredentialsInvalid(err) {
+		return false
+	}
+	if errors.Is(err, ErrNotSupported) {
+		return true
+	}
+	var apiErr *APIError
+	return errors.As(err, &apiErr) && !apiErr.Retryable && apiErr.StatusCode != http.StatusUnauthorized
+}
+
+// RetryAfter returns the delay requested by the identity app for err, or the remaining
+// cool-down of an open circuit breaker, or zero
+func RetryAfter(err error) time.Duration {
+	var apiErr *APIError
+	if errors.As(err, &apiErr) {
+		return apiErr.RetryAfter
+	}
+	var circuitErr *CircuitOpenError
+	if errors.As(err, &circuitErr) {
+		return circuitErr.RetryAfter
+	}
+	return 0
+}
@@ -0,0 +1,99 @@
+package identityclient
+
+import (
+	"context"
+	neturl "net/url"
+
+	v1 "github.com/m15ch4/go-identity-operator/api/v1"
+)
+
+type IdentityGroup struct {
+	ID          string `json:"id,omitempty"`
+	Name        string `json:"name,omitempty"`
+	Description string `json:"description,omitempty"`
+}
+
+type groupMember struct {
+	ID string `json:"id"`
+}
+
+// CreateGroup makes REST API call to /groups of identity app and returns the IdentityGroup object.
+// REST API call uses POST HTTP method.
+func (s *IdentityService) CreateGroup(ctx context.Context, group *v1.GroupSpec) (*IdentityGroup, error) {
+	var groupResponse IdentityGroup
+	err := s.call(ctx, "create_group", "POST", "/groups", identityGroupFor(group), &groupResponse)
+	if err != nil {
+		return nil, err
+	}
+	return &groupResponse, nil
+}
+
+// GetGroup retrieves the group with the given ID from external identity app using REST API call.
+func (s *IdentityService) GetGroup(ctx context.Context, groupID string) (*IdentityGroup, error) {
+	var groupResponse IdentityGroup
+	err := s.call(ctx, "get_group", "GET", "/groups/"+groupID, nil, &groupResponse)
+	if err != nil {
+		return nil, err
+	}
+	return &groupResponse, nil
+}
+
+// UpdateGroup replaces the group with the given ID in external identity app using REST API call.
+// REST API call uses PUT HTTP method.
+func (s *IdentityService) UpdateGroup(ctx context.Context, groupID string, group *v1.GroupSpec) (*IdentityGroup, error) {
+	var groupResponse IdentityGroup
+	err := s.call(ctx, "update_group", "PUT", "/groups/"+groupID, identityGroupFor(group), &groupResponse)
+	if err != nil {
+		return nil, err
+	}
+	return &groupResponse, nil
+}
+
+// DeleteGroup removes the group with the given ID from external identity app using REST API call.
+func (s *IdentityService) DeleteGroup(ctx context.Context, groupID string) error {
+	return s.call(ctx, "delete_group", "DELETE", "/groups/"+groupID, nil, nil)
+}
+
+// ListGroupMembers returns the IDs of the users that are members of the group with the given ID.
+func (s *IdentityService) ListGroupMembers(ctx context.Context, groupID string) ([]string, error) {
+	var members []groupMember
+	err := s.call(ctx, "list_group_members", "GET", "/groups/"+groupID+"/members", nil, &members)
+	if err != nil {
+		return nil, err
+	}
+
+	ids := make([]string, 0, len(members))
+	for _, member := range members {
+		ids = append(ids, member.ID)
+	}
+	return ids, nil
+}
+
+// AddGroupMember adds the user with the given ID to the group with the given ID.
+// REST API call uses PUT HTTP method, so adding an existing member is not an error.
+func (s *IdentityService) AddGroupMember(ctx context.Context, groupID, userID string) error {
+	return s.call(ctx, "add_group_member", "PUT", "/groups/"+groupID+"/members/"+neturl.PathEscape(userID), nil, nil)
+}
+
+// RemoveGroupMember removes the user with the given ID from the group with the given ID.
+func (s *IdentityService) RemoveGroupMember(ctx context.Context, groupID, userID string) error {
+	return s.call(ctx, "remove_group_member", "DELETE", "/groups/"+groupID+"/members/"+neturl.PathEscape(userID), nil, nil)
+}
+
+// The identity app has no nested groups
+
+func (s *IdentityService) AddChildGroup(ctx context.Context, parentID, groupID string) error {
+	return ErrNotSupported
+}
+
+func (s *IdentityService) RemoveChildGroup(ctx context.Context, parentID, groupID string) error {
+	return ErrNotSupported
+}
+
+// identityGroupFor converts the Group spec into the request body of the identity app
+func identityGroupFor(group *v1.GroupSpec) *IdentityGroup {
+	return &IdentityGroup{
+		Name:        group.Name,
+		Description: group.Description,
+	}
+}
@@ -0,0 +1,53 @@
+package identityclient
+
+import (
+	"context"
+	"net/http"
+)
+
+// IdempotencyKeyHeader carries the idempotency key of a creation, identity systems
+// supporting it answer a repeated request with the same key with the original response
+const IdempotencyKeyHeader = "Idempotency-Key"
+
+type idempotencyKeyKey struct{}
+
+// WithIdempotencyKey returns a context whose creations carry key in the Idempotency-Key
+// header, so retrying a creation whose response was lost does not create a duplicate
+func WithIdempotencyKey(ctx context.Context, key string) context.Context {
+	return context.WithValue(ctx, idempotencyKeyKey{}, key)
+}
+
+// IdempotencyKeyFrom returns the idempotency key stored in ctx, or an empty string
+func IdempotencyKeyFrom(ctx context.Context) string {
+	key, _ := ctx.Value(idempotencyKeyKey{}).(string)
+	return key
+}
+
+type idempotencyKeysKey struct{}
+
+// WithIdempotencyKeys returns a context whose bulk creations store keys[i] as the idempotency
+// key of the i-th user, the same way a creation stores the key of WithIdempotencyKey
+func WithIdempotencyKeys(ctx context.Context, keys []string) context.Context {
+	return context.WithValue(ctx, idempotencyKeysKey{}, keys)
+}
+
+// IdempotencyKeysFrom returns the idempotency key of the i-th user of a bulk creation stored
+// in ctx, or an empty string
+func IdempotencyKeysFrom(ctx context.Context, i int) string {
+	keys, _ := ctx.Value(idempotencyKeysKey{}).([]string)
+	if i < 0 || i >= len(keys) {
+		return ""
+	}
+	return keys[i]
+}
+
+// setIdempotencyKey sets the Idempotency-Key header of POST requests from the request
+// context unless the caller already did
+func setIdempotencyKey(req *http.Request) {
+	if req.Method != http.MethodPost || req.Header.Get(IdempotencyKeyHeader) != "" {
+		return
+	}
+	if key := IdempotencyKeyFrom(req.Context()); key != "" {
+		req.Header.Set(IdempotencyKeyHeader, key)
+	}
+}
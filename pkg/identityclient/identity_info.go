@@ -0,0 +1,36 @@
+package identityclient
+
+import (
+	"context"
+)
+
+// BackendInfo describes the identity system and the optional operations it supports
+type BackendInfo struct {
+	// Version of the identity system, empty when it does not report one
+	Version string
+	// SupportsPatch reports whether users can be updated field by field
+	SupportsPatch bool
+	// SupportsGroups reports whether the identity system manages groups
+	SupportsGroups bool
+}
+
+type versionResponse struct {
+	Version string `json:"version"`
+}
+
+// Info reads the version of the identity app from /version. Identity apps without the
+// endpoint are reported without a version.
+func (s *IdentityService) Info(ctx context.Context) (*BackendInfo, error) {
+	info := &BackendInfo{
+		SupportsPatch:  s.config.PatchUpdates(),
+		SupportsGroups: true,
+	}
+
+	var version versionResponse
+	err := s.call(ctx, "version", "GET", "/version", nil, &version)
+	if err != nil && !IsNotFound(err) {
+		return nil, err
+	}
+	info.Version = version.Version
+	return info, nil
+}
@@ -0,0 +1,107 @@
+package identityclient
+
+import (
+	"context"
+	neturl "net/url"
+	"strconv"
+)
+
+// UserFilter selects the users returned by ListUsers, the zero value selects every user
+type UserFilter struct {
+	// Name selects the user with exactly this name
+	Name string
+}
+
+// PageOptions selects the page of users returned by ListUsers. Identity systems paginate
+// either by offset or by cursor, the Next options of a page continue the listing either way.
+type PageOptions struct {
+	// Limit is the maximum number of users of the page, the identity system decides when 0
+	Limit int
+	// Offset is the number of users skipped, for identity systems paginating by offset
+	Offset int
+	// Cursor is the opaque position returned with the previous page, for identity systems
+	// paginating by cursor
+	Cursor string
+}
+
+// UserPage is a page of the users selected by a filter
+type UserPage struct {
+	Users []IdentityUser
+	// Next selects the following page, nil on the last page
+	Next *PageOptions
+}
+
+// EachUser calls fn with every user selected by the filter, requesting the users page by
+// page. It stops at the first error of the identity system or fn.
+func EachUser(ctx context.Context, api IdentityAPI, filter UserFilter, fn func(*IdentityUser) error) error {
+	next := &PageOptions{}
+	for next != nil {
+		page, err := api.ListUsers(ctx, filter, *next)
+		if err != nil {
+			return err
+		}
+		for i := range page.Users {
+			if err := fn(&page.Users[i]); err != nil {
+				return err
+			}
+		}
+		next = page.Next
+		// a page without users ends the listing even if more are announced
+		if len(page.Users) == 0 {
+			next = nil
+		}
+	}
+	return nil
+}
+
+// ListUsers makes REST API call to /users and returns a page of the users of the identity
+// app. The identity app paginates by offset, without a limit it returns every user at once.
+func (s *IdentityService) ListUsers(ctx context.Context, filter UserFilter, pageOpts PageOptions) (*UserPage, error) {
+	return s.listUsers(ctx, "list_users", filter, pageOpts)
+}
+
+// FindUserByName looks up the user with the given name in external identity app using REST API call.
+// It returns nil without error when no such user exists.
+func (s *IdentityService) FindUserByName(ctx context.Context, name string) (*IdentityUser, error) {
+	page, err := s.listUsers(ctx, "find", UserFilter{Name: name}, PageOptions{})
+	if err != nil {
+		return nil, err
+	}
+	if len(page.Users) == 0 {
+		return nil, nil
+	}
+	return &page.Users[0], nil
+}
+
+func (s *IdentityService) listUsers(ctx context.Context, operation string, filter UserFilter, pageOpts PageOptions) (*UserPage, error) {
+	query := neturl.Values{}
+	if filter.Name != "" {
+		query.Set("name", filter.Name)
+	}
+	if pageOpts.Limit > 0 {
+		query.Set("offset", strconv.Itoa(pageOpts.Offset))
+		query.Set("limit", strconv.Itoa(pageOpts.Limit))
+	}
+	path := "/users"
+	if len(query) > 0 {
+		path += "?" + query.Encode()
+	}
+
+	var usersResponse []IdentityUser
+	err := s.call(ctx, operation, "GET", path, nil, &usersResponse)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &UserPage{}
+	if pageOpts.Limit > 0 && len(usersResponse) == pageOpts.Limit {
+		result.Next = &PageOptions{Limit: pageOpts.Limit, Offset: pageOpts.Offset + pageOpts.Limit}
+	}
+	// the name filter may match partially, keep the users with exactly the given name
+	for _, usr := range usersResponse {
+		if filter.Name == "" || usr.Name == filter.Name {
+			result.Users = append(result.Users, usr)
+		}
+	}
+	return result, nil
+}
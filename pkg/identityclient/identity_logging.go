@@ -0,0 +1,72 @@
+package identityclient
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"time"
+
+	"sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+// RequestIDHeader carries the correlation ID of a request to the identity app
+const RequestIDHeader = "X-Request-ID"
+
+type requestIDKey struct{}
+
+// WithRequestID returns a context whose identity API requests carry id in the X-Request-ID header
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, id)
+}
+
+// RequestIDFrom returns the request ID stored in ctx, or an empty string
+func RequestIDFrom(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// NewRequestID returns a random request ID
+func NewRequestID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return ""
+	}
+	return hex.EncodeToString(b)
+}
+
+// setRequestID sets the X-Request-ID header of the request unless the caller already did,
+// using the ID from the request context or a fresh one, and returns the ID sent
+func setRequestID(req *http.Request) string {
+	if id := req.Header.Get(RequestIDHeader); id != "" {
+		return id
+	}
+	id := RequestIDFrom(req.Context())
+	if id == "" {
+		id = NewRequestID()
+	}
+	req.Header.Set(RequestIDHeader, id)
+	return id
+}
+
+// logRequests logs every request at debug level with the request ID sent to the identity app
+func logRequests(next http.RoundTripper) http.RoundTripper {
+	return RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		log := log.FromContext(req.Context()).V(1).WithValues(
+			"operation", OperationFrom(req.Context()),
+			"method", req.Method,
+			"path", req.URL.Path,
+			"requestID", req.Header.Get(RequestIDHeader),
+		)
+
+		start := time.Now()
+		resp, err := next.RoundTrip(req)
+		duration := time.Since(start)
+		if err != nil {
+			log.Info("Identity API request failed", "duration", duration, "error", err.Error())
+			return nil, err
+		}
+		log.Info("Identity API request", "status", resp.StatusCode, "duration", duration)
+		return resp, nil
+	})
+}
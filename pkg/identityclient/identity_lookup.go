@@ -0,0 +1,104 @@
+package identityclient
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+// lookupsTotal counts the lookups of a LookupCache by operation and whether they were
+// answered from the cache
+var lookupsTotal = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "identity_api_lookup_cache_lookups_total",
+		Help: "Number of lookups in the identity app, such as the ID of a role by name, by operation and result: hit or miss.",
+	},
+	[]string{"operation", "result"},
+)
+
+func init() {
+	metrics.Registry.MustRegister(lookupsTotal)
+}
+
+// LookupCache is a read-through cache of lookups that many reconciles repeat in a burst,
+// such as the ID of a role by its name when hundreds of users get the same role. Results
+// are kept for a short TTL and concurrent lookups of the same key share one request.
+// Failed lookups are not cached. A zero TTL only shares the concurrent lookups.
+type LookupCache[V any] struct {
+	operation string
+	ttl       time.Duration
+
+	mu      sync.Mutex
+	entries map[string]*lookupEntry[V]
+}
+
+type lookupEntry[V any] struct {
+	// done is closed once value and err are set
+	done    chan struct{}
+	value   V
+	err     error
+	expires time.Time
+}
+
+// NewLookupCache returns a cache of the lookups of the operation, e.g. keycloak_get_role,
+// keeping results for ttl
+func NewLookupCache[V any](operation string, ttl time.Duration) *LookupCache[V] {
+	return &LookupCache[V]{
+		operation: operation,
+		ttl:       ttl,
+		entries:   map[string]*lookupEntry[V]{},
+	}
+}
+
+// Get returns the cached value of key or loads it with load. Callers waiting for the
+// lookup of another caller stop waiting when their ctx is done.
+func (c *LookupCache[V]) Get(ctx context.Context, key string, load func(ctx context.Context) (V, error)) (V, error) {
+	c.mu.Lock()
+	entry, ok := c.entries[key]
+	if ok {
+		select {
+		case <-entry.done:
+			if time.Now().After(entry.expires) {
+				ok = false
+			}
+		default:
+		}
+	}
+	if ok {
+		c.mu.Unlock()
+		lookupsTotal.WithLabelValues(c.operation, "hit").Inc()
+		select {
+		case <-entry.done:
+			return entry.value, entry.err
+		case <-ctx.Done():
+			var zero V
+			return zero, ctx.Err()
+		}
+	}
+	entry = &lookupEntry[V]{done: make(chan struct{})}
+	c.entries[key] = entry
+	c.mu.Unlock()
+	lookupsTotal.WithLabelValues(c.operation, "miss").Inc()
+
+	entry.value, entry.err = load(ctx)
+	entry.expires = time.Now().Add(c.ttl)
+	c.mu.Lock()
+	if entry.err != nil || c.ttl <= 0 {
+		if c.entries[key] == entry {
+			delete(c.entries, key)
+		}
+	}
+	c.mu.Unlock()
+	close(entry.done)
+	return entry.value, entry.err
+}
+
+// Invalidate drops all cached values, e.g. after a role was renamed or deleted
+func (c *LookupCache[V]) Invalidate() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries = map[string]*lookupEntry[V]{}
+}
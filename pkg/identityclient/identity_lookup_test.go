@@ -0,0 +1,61 @@
+package identityclient_test
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	idmsvc "github.com/m15ch4/go-identity-operator/pkg/identityclient"
+)
+
+var _ = Describe("LookupCache", func() {
+	ctx := context.Background()
+
+	It("shares a lookup between concurrent callers", func() {
+		cache := idmsvc.NewLookupCache[string]("get_role", time.Minute)
+		release := make(chan struct{})
+		var mu sync.Mutex
+		loads := 0
+		load := func(context.Context) (string, error) {
+			mu.Lock()
+			loads++
+			mu.Unlock()
+			<-release
+			return "role-id", nil
+		}
+
+		var wg sync.WaitGroup
+		ids := make([]string, 10)
+		for i := range ids {
+			wg.Add(1)
+			go func(i int) {
+				defer GinkgoRecover()
+				defer wg.Done()
+				id, err := cache.Get(ctx, "admin", load)
+				Expect(err).NotTo(HaveOccurred())
+				ids[i] = id
+			}(i)
+		}
+		Eventually(func() int {
+			mu.Lock()
+			defer mu.Unlock()
+			return loads
+		}).Should(Equal(1))
+		close(release)
+		wg.Wait()
+		Expect(ids).To(HaveEach("role-id"))
+
+		id, err := cache.Get(ctx, "admin", load)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(id).To(Equal("role-id"))
+		Expect(loads).To(Equal(1))
+
+		cache.Invalidate()
+		_, err = cache.Get(ctx, "admin", load)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(loads).To(Equal(2))
+	})
+})
@@ -0,0 +1,227 @@
+package identityclient
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// AttributeMapping maps fields of the User spec, by their JSON name, to the attributes
+// holding them in the users of the identity system, given as dot separated paths into the
+// JSON representation of a user, e.g. firstname to givenName or to profile.given_name.
+// Values are moved as they are, so the mapped attribute must have the type the identity
+// system uses for the field by default.
+type AttributeMapping map[string]string
+
+// UserSchema describes where an identity system keeps the fields of its users, so an
+// AttributeMapping can be applied to the request and response bodies holding users
+type UserSchema struct {
+	// Paths are the default paths of the fields that can be mapped
+	Paths map[string]string
+	// Operations are the operations whose request and response bodies hold users
+	Operations map[string]bool
+	// Collections are the paths of the users in bodies holding several of them, e.g.
+	// Resources in SCIM list responses. Other bodies are a user or an array of users.
+	Collections []string
+}
+
+// WithAttributeMapping maps fields of the users to nonstandard attributes of the identity
+// system, fields not mapped keep the attributes of the identity system type
+func WithAttributeMapping(mapping AttributeMapping) ConfigOpts {
+	return func(cfg IdentityConfig) IdentityConfig {
+		cfg.attributeMapping = mapping
+		return cfg
+	}
+}
+
+// AttributeMapping returns the attributes the fields of the users are mapped to
+func (cfg *IdentityConfig) AttributeMapping() AttributeMapping {
+	return cfg.attributeMapping
+}
+
+// Path returns the path of the field in the users of the identity system, the mapped one
+// or the given default
+func (m AttributeMapping) Path(field, defaultPath string) string {
+	if path, ok := m[field]; ok {
+		return path
+	}
+	return defaultPath
+}
+
+// validate returns an error for mapped fields the schema has no default path for
+func (m AttributeMapping) validate(schema *UserSchema) error {
+	for field := range m {
+		if schema == nil || schema.Paths[field] == "" {
+			return fmt.Errorf("field %s cannot be mapped to another attribute of this identity system", field)
+		}
+	}
+	return nil
+}
+
+// mapAttributes moves the mapped fields of the users in the bodies of the user operations of
+// the schema from their default paths to the mapped ones and back in the responses
+func mapAttributes(mapping AttributeMapping, schema *UserSchema) Middleware {
+	return func(next http.RoundTripper) http.RoundTripper {
+		if len(mapping) == 0 || schema == nil {
+			return next
+		}
+		return RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			if !schema.Operations[OperationFrom(req.Context())] {
+				return next.RoundTrip(req)
+			}
+
+			if req.Body != nil && req.Body != http.NoBody {
+				body, err := io.ReadAll(req.Body)
+				req.Body.Close()
+				if err != nil {
+					return nil, err
+				}
+				body = schema.mapBody(body, mapping, false)
+
+				// a RoundTripper must not modify the request
+				mapped := req.Clone(req.Context())
+				mapped.Body = io.NopCloser(bytes.NewReader(body))
+				mapped.GetBody = func() (io.ReadCloser, error) { return io.NopCloser(bytes.NewReader(body)), nil }
+				mapped.ContentLength = int64(len(body))
+				req = mapped
+			}
+
+			resp, err := next.RoundTrip(req)
+			if err != nil || resp.StatusCode >= http.StatusMultipleChoices || !isJSON(resp.Header.Get("Content-Type")) {
+				return resp, err
+			}
+			body, err := io.ReadAll(resp.Body)
+			resp.Body.Close()
+			if err != nil {
+				return nil, err
+			}
+			body = schema.mapBody(body, mapping, true)
+			resp.Body = io.NopCloser(bytes.NewReader(body))
+			resp.ContentLength = int64(len(body))
+			resp.Header.Del("Content-Length")
+			return resp, nil
+		})
+	}
+}
+
+// mapBody applies the mapping to the users in the JSON body, from the default paths to the
+// mapped ones or back for responses. Bodies that are not JSON are returned unchanged.
+func (s *UserSchema) mapBody(body []byte, mapping AttributeMapping, response bool) []byte {
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.UseNumber()
+	var root interface{}
+	if err := decoder.Decode(&root); err != nil {
+		return body
+	}
+
+	for _, user := range s.users(root) {
+		from, to := map[string]string{}, map[string]string{}
+		for field, path := range mapping {
+			from[field], to[field] = s.Paths[field], path
+			if response {
+				from[field], to[field] = path, s.Paths[field]
+			}
+		}
+
+		// all values are taken before any is put, so fields may swap their attributes
+		values := map[string]interface{}{}
+		for field, path := range from {
+			if value, ok := takePath(user, path); ok {
+				values[field] = value
+			}
+		}
+		// attributes of the identity system not holding the mapped field in this
+		// configuration are not read as the field
+		if response {
+			for _, path := range to {
+				takePath(user, path)
+			}
+		}
+		for field, value := range values {
+			putPath(user, to[field], value)
+		}
+	}
+
+	mapped, err := json.Marshal(root)
+	if err != nil {
+		return body
+	}
+	return mapped
+}
+
+// users returns the users in the JSON body, at the collection paths or else the body itself
+func (s *UserSchema) users(root interface{}) []map[string]interface{} {
+	var found []interface{}
+	for _, path := range s.Collections {
+		found = append(found, lookupPath(root, strings.Split(path, "."))...)
+	}
+	if len(found) == 0 {
+		found = lookupPath(root, nil)
+	}
+
+	var users []map[string]interface{}
+	for _, node := range found {
+		if user, ok := node.(map[string]interface{}); ok {
+			users = append(users, user)
+		}
+	}
+	return users
+}
+
+// lookupPath returns the values at the path, descending into every element of arrays
+func lookupPath(node interface{}, path []string) []interface{} {
+	if elements, ok := node.([]interface{}); ok {
+		var found []interface{}
+		for _, element := range elements {
+			found = append(found, lookupPath(element, path)...)
+		}
+		return found
+	}
+	if len(path) == 0 {
+		return []interface{}{node}
+	}
+	object, ok := node.(map[string]interface{})
+	if !ok {
+		return nil
+	}
+	child, ok := object[path[0]]
+	if !ok {
+		return nil
+	}
+	return lookupPath(child, path[1:])
+}
+
+// takePath removes the value at the dot separated path from the object and returns it,
+// including explicit nulls
+func takePath(object map[string]interface{}, path string) (interface{}, bool) {
+	elements := strings.Split(path, ".")
+	for _, element := range elements[:len(elements)-1] {
+		child, ok := object[element].(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		object = child
+	}
+	last := elements[len(elements)-1]
+	value, ok := object[last]
+	delete(object, last)
+	return value, ok
+}
+
+// putPath sets the value at the dot separated path of the object, creating the objects
+// on the way
+func putPath(object map[string]interface{}, path string, value interface{}) {
+	elements := strings.Split(path, ".")
+	for _, element := range elements[:len(elements)-1] {
+		child, ok := object[element].(map[string]interface{})
+		if !ok {
+			child = map[string]interface{}{}
+			object[element] = child
+		}
+		object = child
+	}
+	object[elements[len(elements)-1]] = value
+}
@@ -0,0 +1,47 @@
+package identityclient_test
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	v1 "github.com/m15ch4/go-identity-operator/api/v1"
+	idmsvc "github.com/m15ch4/go-identity-operator/pkg/identityclient"
+)
+
+var _ = Describe("Attribute mapping", func() {
+	ctx := context.Background()
+
+	It("maps user fields to the configured attributes", func() {
+		var sent map[string]interface{}
+		transport := idmsvc.RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			Expect(json.NewDecoder(req.Body).Decode(&sent)).To(Succeed())
+			return &http.Response{
+				StatusCode: http.StatusCreated,
+				Header:     http.Header{"Content-Type": []string{"application/json"}},
+				Body:       io.NopCloser(strings.NewReader(`{"id":"1","name":"jackr","firstname":"ignored","profile":{"given":"Jack"}}`)),
+				Request:    req,
+			}, nil
+		})
+		api := idmsvc.New(idmsvc.WithHost("idm.example.com"), idmsvc.WithToken("token"), idmsvc.WithTransport(transport),
+			idmsvc.WithAttributeMapping(idmsvc.AttributeMapping{"firstname": "profile.given"}))
+
+		created, err := api.CreateUser(ctx, &v1.UserSpec{Name: "jackr", Firstname: "Jack", Lastname: "Reacher"})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(sent).NotTo(HaveKey("firstname"))
+		Expect(sent).To(HaveKeyWithValue("profile", map[string]interface{}{"given": "Jack"}))
+		Expect(sent).To(HaveKeyWithValue("lastname", "Reacher"))
+		Expect(created.Firstname).To(Equal("Jack"))
+
+		By("rejecting fields the identity system cannot map")
+		api = idmsvc.New(idmsvc.WithHost("idm.example.com"), idmsvc.WithToken("token"), idmsvc.WithTransport(transport),
+			idmsvc.WithAttributeMapping(idmsvc.AttributeMapping{"role": "groups"}))
+		_, err = api.CreateUser(ctx, &v1.UserSpec{Name: "jackr"})
+		Expect(err).To(MatchError(ContainSubstring("field role cannot be mapped")))
+	})
+})
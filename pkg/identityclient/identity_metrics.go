@@ -0,0 +1,78 @@
+package identityclient
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+var (
+	// requestsTotal counts REST API calls to the identity app by operation and status code
+	requestsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "identity_api_requests_total",
+			Help: "Number of REST API calls to the identity app by operation and status code.",
+		},
+		[]string{"operation", "code"},
+	)
+
+	// requestDuration observes the latency of REST API calls to the identity app
+	requestDuration = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "identity_api_request_duration_seconds",
+			Help:    "Latency of REST API calls to the identity app by operation.",
+			Buckets: prometheus.DefBuckets,
+		},
+		[]string{"operation"},
+	)
+
+	// circuitState reports the circuit breaker state of each identity app
+	circuitState = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "identity_api_circuit_breaker_state",
+			Help: "State of the circuit breaker of the identity app: 0 closed, 1 half-open, 2 open.",
+		},
+		[]string{"endpoint"},
+	)
+
+	// tokenRefreshesTotal counts logins to the identity app by result
+	tokenRefreshesTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "identity_api_token_refreshes_total",
+			Help: "Number of logins to the identity app by result.",
+		},
+		[]string{"result"},
+	)
+)
+
+func init() {
+	metrics.Registry.MustRegister(requestsTotal, requestDuration, circuitState, tokenRefreshesTotal)
+}
+
+// measureRequests records the latency and the status code of every request under its operation
+func measureRequests(next http.RoundTripper) http.RoundTripper {
+	return RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		operation := OperationFrom(req.Context())
+
+		start := time.Now()
+		resp, err := next.RoundTrip(req)
+		requestDuration.WithLabelValues(operation).Observe(time.Since(start).Seconds())
+		if err != nil {
+			requestsTotal.WithLabelValues(operation, "error").Inc()
+			return nil, err
+		}
+		requestsTotal.WithLabelValues(operation, strconv.Itoa(resp.StatusCode)).Inc()
+		return resp, nil
+	})
+}
+
+// RecordRequest records the latency and the outcome of a request sent without the HTTP
+// client, e.g. an LDAP operation, under its operation. The code is the HTTP status code
+// the outcome corresponds to, or "error" when no response was received.
+func RecordRequest(operation, code string, elapsed time.Duration) {
+	requestDuration.WithLabelValues(operation).Observe(elapsed.Seconds())
+	requestsTotal.WithLabelValues(operation, code).Inc()
+}
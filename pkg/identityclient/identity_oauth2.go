@@ -0,0 +1,74 @@
+package identityclient
+
+import (
+	"context"
+	"net/http"
+	neturl "net/url"
+	"strings"
+	"time"
+)
+
+type clientCredentialsResponse struct {
+	AccessToken string `json:"access_token"`
+	ExpiresIn   int    `json:"expires_in"`
+}
+
+// ClientCredentialsToken obtains an access token with the OAuth2 client credentials grant
+// from the token endpoint of the configuration and returns it with its expiry. Tokens
+// without expires_in expire after the configured token TTL.
+func (c *Client) ClientCredentialsToken(ctx context.Context) (token string, expiry time.Time, err error) {
+	// count the login by result
+	defer func() {
+		result := "success"
+		if err != nil {
+			result = "failure"
+		}
+		tokenRefreshesTotal.WithLabelValues(result).Inc()
+	}()
+
+	form := neturl.Values{"grant_type": {"client_credentials"}}
+	if len(c.config.oauth2Scopes) > 0 {
+		form.Set("scope", strings.Join(c.config.oauth2Scopes, " "))
+	}
+
+	// prepare request
+	req, err := http.NewRequestWithContext(ctx, "POST", c.config.oauth2TokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	// the client credentials are form encoded before basic auth, see RFC 6749 section 2.3.1
+	req.SetBasicAuth(neturl.QueryEscape(c.config.oauth2ClientID), neturl.QueryEscape(c.config.oauth2ClientSecret))
+
+	// make REST API call
+	resp, err := c.Do("oauth2_token", req)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	defer resp.Body.Close()
+
+	// read response body
+	body, err := ReadBody(resp)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+
+	// check response status code
+	err = CheckResponse(resp, body)
+	if err != nil {
+		return "", time.Time{}, LoginError(err)
+	}
+
+	// extract the access token
+	var tokenResponse clientCredentialsResponse
+	err = DecodeJSON(resp, body, &tokenResponse)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+
+	expiry = time.Now().Add(c.config.tokenTTL)
+	if tokenResponse.ExpiresIn > 0 {
+		expiry = time.Now().Add(time.Duration(tokenResponse.ExpiresIn) * time.Second)
+	}
+	return tokenResponse.AccessToken, expiry, nil
+}
@@ -0,0 +1,77 @@
+package identityclient
+
+import (
+	"sync"
+	"time"
+)
+
+// outcomeBucket counts the outcomes of the requests sent within one bucket period
+type outcomeBucket struct {
+	start     int64
+	succeeded int
+	failed    int
+}
+
+// outcomeWindow counts the outcomes of requests to the identity apps in buckets covering
+// the last outcomeBuckets times outcomeBucketPeriod
+type outcomeWindow struct {
+	mu      sync.Mutex
+	buckets [outcomeBuckets]outcomeBucket
+}
+
+const (
+	outcomeBuckets      = 10
+	outcomeBucketPeriod = 30 * time.Second
+)
+
+// FailureWindow is the period FailureRate is computed over
+const FailureWindow = outcomeBuckets * outcomeBucketPeriod
+
+// recentOutcomes tracks the requests of all clients, like the circuit breakers it
+// survives the short-lived services built for every reconciliation
+var recentOutcomes = &outcomeWindow{}
+
+// record counts the outcome of a request sent at now
+func (w *outcomeWindow) record(now time.Time, failed bool) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	start := now.UnixNano() / int64(outcomeBucketPeriod)
+	bucket := &w.buckets[start%outcomeBuckets]
+	if bucket.start != start {
+		*bucket = outcomeBucket{start: start}
+	}
+	if failed {
+		bucket.failed++
+	} else {
+		bucket.succeeded++
+	}
+}
+
+// rate returns the share of failed requests within the window ending at now and the
+// number of requests it is based on
+func (w *outcomeWindow) rate(now time.Time) (float64, int) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	current := now.UnixNano() / int64(outcomeBucketPeriod)
+	var succeeded, failed int
+	for _, bucket := range w.buckets {
+		if current-bucket.start < outcomeBuckets {
+			succeeded += bucket.succeeded
+			failed += bucket.failed
+		}
+	}
+	total := succeeded + failed
+	if total == 0 {
+		return 0, 0
+	}
+	return float64(failed) / float64(total), total
+}
+
+// FailureRate returns the share of requests to the identity apps within the last
+// FailureWindow that could not reach them or failed with a server side error, and the
+// number of requests it is based on
+func FailureRate() (float64, int) {
+	return recentOutcomes.rate(time.Now())
+}
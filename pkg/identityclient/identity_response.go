@@ -0,0 +1,82 @@
+package identityclient
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+)
+
+// MaxResponseBodySize bounds the response bodies read from the identity system, so a
+// misbehaving backend cannot exhaust the memory of the operator
+const MaxResponseBodySize = 4 << 20
+
+// maxErrorBodySize bounds the response body quoted in errors
+const maxErrorBodySize = 512
+
+// ResponseError is returned when a 2xx response of the identity system cannot be decoded
+type ResponseError struct {
+	// ContentType of the response
+	ContentType string
+	// Body of the response, truncated for readability
+	Body string
+	// Err is the reason the response was rejected
+	Err error
+}
+
+func (e *ResponseError) Error() string {
+	return fmt.Sprintf("invalid response from identity api (content type %q): %v: %s", e.ContentType, e.Err, e.Body)
+}
+
+func (e *ResponseError) Unwrap() error {
+	return e.Err
+}
+
+// ReadBody reads the response body up to MaxResponseBodySize and fails for larger bodies
+func ReadBody(resp *http.Response) ([]byte, error) {
+	body, err := io.ReadAll(io.LimitReader(resp.Body, MaxResponseBodySize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(body) > MaxResponseBodySize {
+		return nil, fmt.Errorf("identity api response exceeds %d bytes", MaxResponseBodySize)
+	}
+	return body, nil
+}
+
+// DecodeJSON unmarshals the body of a 2xx response into out. Responses that are not JSON,
+// e.g. the HTML error page of a proxy, are rejected with a ResponseError quoting the body.
+func DecodeJSON(resp *http.Response, body []byte, out interface{}) error {
+	contentType := resp.Header.Get("Content-Type")
+	if !isJSON(contentType) {
+		return &ResponseError{ContentType: contentType, Body: truncate(body), Err: errors.New("expected a JSON response")}
+	}
+	if err := json.Unmarshal(body, out); err != nil {
+		return &ResponseError{ContentType: contentType, Body: truncate(body), Err: err}
+	}
+	return nil
+}
+
+// isJSON reports whether the content type is application/json or a JSON based type such as
+// application/scim+json. A missing content type is accepted, as some backends omit it.
+func isJSON(contentType string) bool {
+	if contentType == "" {
+		return true
+	}
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	return mediaType == "application/json" || strings.HasSuffix(mediaType, "+json")
+}
+
+// truncate returns the body for quoting in errors, shortened to maxErrorBodySize bytes
+func truncate(body []byte) string {
+	if len(body) <= maxErrorBodySize {
+		return string(body)
+	}
+	return string(body[:maxErrorBodySize]) + "... (truncated)"
+}
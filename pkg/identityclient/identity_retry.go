@@ -0,0 +1,123 @@
+package identityclient
+
+import (
+	"io"
+	"math/rand"
+	"net/http"
+	"time"
+)
+
+// Do sends the request, retrying transient failures. Idempotent requests are retried
+// on transport errors and retryable status codes, all others only when the identity
+// app rejected them with 429 or 503 and thus did not process them. A Retry-After
+// delay longer than the maximum backoff is left to the caller. While the circuit
+// breaker of the identity app is open the request fails fast with a CircuitOpenError.
+// The outcome is counted in the FailureRate unless the request was cancelled.
+func (c *Client) Do(operation string, req *http.Request) (*http.Response, error) {
+	breaker := c.breaker()
+	if breaker != nil {
+		if err := breaker.allow(); err != nil {
+			requestsTotal.WithLabelValues(operation, "circuit_open").Inc()
+			return nil, err
+		}
+	}
+
+	resp, err := c.do(operation, req)
+	outcome := outcomeOf(req, resp, err)
+	if outcome != outcomeCancelled {
+		recentOutcomes.record(time.Now(), outcome == outcomeFailed)
+	}
+	if breaker != nil {
+		breaker.record(outcome)
+	}
+	return resp, err
+}
+
+// do sends the request with retries
+func (c *Client) do(operation string, req *http.Request) (*http.Response, error) {
+	// all attempts share the request ID so the identity app can correlate them, and
+	// the idempotency key so a retried creation is not applied twice
+	setRequestID(req)
+	setIdempotencyKey(req)
+
+	for attempt := 1; ; attempt++ {
+		resp, err := c.send(operation, req)
+
+		if attempt >= c.config.retryAttempts || !c.shouldRetry(req, resp, err) {
+			return resp, err
+		}
+
+		delay := c.retryDelay(attempt)
+		if resp != nil {
+			if retryAfter := parseRetryAfter(resp.Header.Get("Retry-After")); retryAfter > 0 {
+				if retryAfter > c.config.retryMaxDelay {
+					return resp, nil
+				}
+				delay = retryAfter
+			}
+			// drain the body so the connection can be reused
+			_, _ = io.Copy(io.Discard, resp.Body)
+			resp.Body.Close()
+		}
+
+		// rewind the request body
+		if req.GetBody != nil {
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, err
+			}
+			req.Body = body
+		}
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-req.Context().Done():
+			timer.Stop()
+			return nil, req.Context().Err()
+		case <-timer.C:
+		}
+	}
+}
+
+// shouldRetry reports whether the outcome of a request is worth another attempt
+func (c *Client) shouldRetry(req *http.Request, resp *http.Response, err error) bool {
+	if req.Context().Err() != nil {
+		return false
+	}
+	if req.Body != nil && req.GetBody == nil {
+		return false
+	}
+
+	if err != nil {
+		return isIdempotent(req.Method)
+	}
+
+	switch resp.StatusCode {
+	case http.StatusTooManyRequests, http.StatusServiceUnavailable:
+		return true
+	case http.StatusRequestTimeout, http.StatusBadGateway, http.StatusGatewayTimeout, http.StatusInternalServerError:
+		return isIdempotent(req.Method)
+	}
+	return false
+}
+
+// retryDelay returns the exponential backoff with full jitter before the next attempt
+func (c *Client) retryDelay(attempt int) time.Duration {
+	delay := c.config.retryBaseDelay << (attempt - 1)
+	if delay <= 0 || delay > c.config.retryMaxDelay {
+		delay = c.config.retryMaxDelay
+	}
+	if delay <= 0 {
+		return 0
+	}
+	return time.Duration(rand.Int63n(int64(delay)) + 1)
+}
+
+// isIdempotent reports whether repeating a request with the method has no additional effect
+func isIdempotent(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodPut, http.MethodDelete:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,74 @@
+package identityclient
+
+import (
+	"context"
+
+	v1 "github.com/m15ch4/go-identity-operator/api/v1"
+)
+
+type IdentityRole struct {
+	ID          string   `json:"id,omitempty"`
+	Name        string   `json:"name,omitempty"`
+	Description string   `json:"description,omitempty"`
+	Permissions []string `json:"permissions,omitempty"`
+}
+
+// CreateRole makes REST API call to /roles of identity app and returns the IdentityRole object.
+// REST API call uses POST HTTP method.
+func (s *IdentityService) CreateRole(ctx context.Context, role *v1.RoleSpec) (*IdentityRole, error) {
+	var roleResponse IdentityRole
+	err := s.call(ctx, "create_role", "POST", "/roles", identityRoleFor(role), &roleResponse)
+	if err != nil {
+		return nil, err
+	}
+	return &roleResponse, nil
+}
+
+// GetRole retrieves the role with the given ID from external identity app using REST API call.
+func (s *IdentityService) GetRole(ctx context.Context, roleID string) (*IdentityRole, error) {
+	var roleResponse IdentityRole
+	err := s.call(ctx, "get_role", "GET", "/roles/"+roleID, nil, &roleResponse)
+	if err != nil {
+		return nil, err
+	}
+	return &roleResponse, nil
+}
+
+// UpdateRole replaces the role with the given ID in external identity app using REST API call.
+// REST API call uses PUT HTTP method.
+func (s *IdentityService) UpdateRole(ctx context.Context, roleID string, role *v1.RoleSpec) (*IdentityRole, error) {
+	var roleResponse IdentityRole
+	err := s.call(ctx, "update_role", "PUT", "/roles/"+roleID, identityRoleFor(role), &roleResponse)
+	if err != nil {
+		return nil, err
+	}
+	return &roleResponse, nil
+}
+
+// DeleteRole removes the role with the given ID from external identity app using REST API call.
+func (s *IdentityService) DeleteRole(ctx context.Context, roleID string) error {
+	return s.call(ctx, "delete_role", "DELETE", "/roles/"+roleID, nil, nil)
+}
+
+// The identity app has a single role per user, kept in the role attribute of the user
+
+func (s *IdentityService) ListUserRoles(ctx context.Context, userID, scope string) ([]string, error) {
+	return nil, ErrNotSupported
+}
+
+func (s *IdentityService) AddUserRole(ctx context.Context, userID, scope, role string) error {
+	return ErrNotSupported
+}
+
+func (s *IdentityService) RemoveUserRole(ctx context.Context, userID, scope, role string) error {
+	return ErrNotSupported
+}
+
+// identityRoleFor converts the Role spec into the request body of the identity app
+func identityRoleFor(role *v1.RoleSpec) *IdentityRole {
+	return &IdentityRole{
+		Name:        role.Name,
+		Description: role.Description,
+		Permissions: role.Permissions,
+	}
+}
@@ -0,0 +1,486 @@
+package identityclient
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+
+	v1 "github.com/m15ch4/go-identity-operator/api/v1"
+)
+
+type IdentityUser struct {
+	ID        string `json:"id,omitempty"`
+	Name      string `json:"name,omitempty"`
+	Password  string `json:"password,omitempty"`
+	Firstname string `json:"firstname,omitempty"`
+	Lastname  string `json:"lastname,omitempty"`
+	Role      string `json:"role,omitempty"`
+	Age       int    `json:"age,omitempty"`
+
+	// Roles are the roles of the user next to Role, for identity systems assigning
+	// several roles per user
+	Roles []string `json:"roles,omitempty"`
+
+	Email       string `json:"email,omitempty"`
+	Phone       string `json:"phone,omitempty"`
+	DisplayName string `json:"displayName,omitempty"`
+
+	// Enabled is false for suspended users, identity systems without a notion of
+	// suspension leave it unset
+	Enabled *bool `json:"enabled,omitempty"`
+
+	// Attributes are the custom attributes of the user, e.g. its employee ID or department
+	Attributes map[string]string `json:"attributes,omitempty"`
+}
+
+// AllRoles returns Role followed by Roles, without duplicates
+func (u *IdentityUser) AllRoles() []string {
+	spec := v1.UserSpec{Role: u.Role, Roles: u.Roles}
+	return spec.AllRoles()
+}
+
+// SetRoles keeps the first of the roles in Role and the others in Roles
+func (u *IdentityUser) SetRoles(roles []string) {
+	u.Role, u.Roles = "", nil
+	if len(roles) > 0 {
+		u.Role = roles[0]
+	}
+	if len(roles) > 1 {
+		u.Roles = append([]string(nil), roles[1:]...)
+	}
+}
+
+// identityUserFor returns the fields of the User spec the identity app keeps, so the
+// references and policies of the spec that only the operator acts upon are not sent
+func identityUserFor(spec *v1.UserSpec) *IdentityUser {
+	return &IdentityUser{
+		Name:        spec.Name,
+		Password:    spec.Password,
+		Firstname:   spec.Firstname,
+		Lastname:    spec.Lastname,
+		Role:        spec.Role,
+		Age:         spec.Age,
+		Roles:       spec.Roles,
+		Email:       spec.Email,
+		Phone:       spec.Phone,
+		DisplayName: spec.DisplayName,
+		Enabled:     spec.Enabled,
+		Attributes:  spec.Attributes,
+	}
+}
+
+// JoinRoles returns the roles of the spec as one comma separated value, for identity systems
+// keeping the role of a user in a single text field
+func JoinRoles(spec *v1.UserSpec) string {
+	return strings.Join(spec.AllRoles(), ",")
+}
+
+// SplitRoles returns the roles of a value written by JoinRoles
+func SplitRoles(value string) []string {
+	var roles []string
+	for _, role := range strings.Split(value, ",") {
+		if role = strings.TrimSpace(role); role != "" {
+			roles = append(roles, role)
+		}
+	}
+	return roles
+}
+
+type LoginRequestBody struct {
+	Name     string `json:"name"`
+	Password string `json:"password"`
+}
+
+type LoginResponse struct {
+	Token     string `json:"token,omitempty"`
+	ExpiresIn int    `json:"expires_in,omitempty"`
+}
+
+type IdentityService struct {
+	config *IdentityConfig
+
+	// mu guards the credentials in config and the cached token
+	mu          sync.Mutex
+	token       string
+	tokenExpiry time.Time
+
+	client *Client
+}
+
+// userSchema describes the users of the identity app for the attribute mapping
+var userSchema = &UserSchema{
+	Paths: map[string]string{
+		"firstname": "firstname", "lastname": "lastname", "email": "email",
+		"phone": "phone", "displayName": "displayName", "age": "age",
+	},
+	Operations: map[string]bool{"create": true, "get": true, "update": true, "patch": true, "find": true, "list_users": true},
+}
+
+func NewIdentityService(config *IdentityConfig) *IdentityService {
+	return &IdentityService{
+		config: config,
+		client: NewClient(config).WithUserSchema(userSchema),
+	}
+}
+
+// GetToken makes REST API call to /login of identity app described by config property and returns the refresh token.
+// The token is cached and reused by subsequent calls until it expires.
+func (s *IdentityService) GetToken(ctx context.Context) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.login(ctx)
+}
+
+// login obtains a new token and caches it together with its expiry; s.mu must be held
+func (s *IdentityService) login(ctx context.Context) (token string, err error) {
+	if s.config.UsesClientCredentials() {
+		s.token, s.tokenExpiry, err = s.client.ClientCredentialsToken(ctx)
+		if err != nil {
+			s.token = ""
+			return "", err
+		}
+		return s.token, nil
+	}
+
+	// count the login by result
+	defer func() {
+		result := "success"
+		if err != nil {
+			result = "failure"
+		}
+		tokenRefreshesTotal.WithLabelValues(result).Inc()
+	}()
+
+	// prepare request url
+	url := s.config.BaseURL() + "/login"
+
+	// prepare request body
+	reqBody := LoginRequestBody{
+		Name:     s.config.user,
+		Password: s.config.pass,
+	}
+	// encode request body
+	jsonReqBody, err := json.Marshal(reqBody)
+	if err != nil {
+		return "", err
+	}
+	// prepare request
+	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewBuffer(jsonReqBody))
+	if err != nil {
+		return "", err
+	}
+
+	// make rest api call
+	resp, err := s.client.Do("login", req)
+	if err != nil {
+		return "", err
+	}
+	// close response body
+	defer resp.Body.Close()
+
+	// read response body
+	body, err := ReadBody(resp)
+	if err != nil {
+		return "", err
+	}
+
+	// check response status code
+	err = CheckResponse(resp, body)
+	if err != nil {
+		return "", LoginError(err)
+	}
+
+	// extract the token field from the response body JSON object
+	var loginResponse LoginResponse
+	err = DecodeJSON(resp, body, &loginResponse)
+	if err != nil {
+		return "", err
+	}
+
+	// save the token in the service
+	s.token = loginResponse.Token
+	s.tokenExpiry = s.tokenExpiryFor(&loginResponse)
+
+	// return the token
+	return s.token, nil
+}
+
+// CreateUser makes REST API call to /users of identity app described by config property and returns the IdentityUser object.
+// Request's body contains the IdentityUser of the spec in JSON format.
+// REST API call uses POST HTTP method.
+func (s *IdentityService) CreateUser(ctx context.Context, user *v1.UserSpec) (*IdentityUser, error) {
+	// prepare request url
+	url := s.config.BaseURL() + "/users"
+
+	// prepare request body
+	body, err := json.Marshal(identityUserFor(user))
+	if err != nil {
+		return nil, err
+	}
+
+	// prepare request
+	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewBuffer(body))
+	if err != nil {
+		return nil, err
+	}
+
+	// set content type header
+	req.Header.Set("Content-Type", "application/json")
+
+	// make authenticated REST API call
+	resp, err := s.doWithAuth(ctx, "create", req)
+	if err != nil {
+		return nil, err
+	}
+	// close the response body
+	defer resp.Body.Close()
+
+	// read response body
+	body, err = ReadBody(resp)
+	if err != nil {
+		return nil, err
+	}
+
+	// check response status code
+	err = CheckResponse(resp, body)
+	if err != nil {
+		return nil, err
+	}
+
+	// parse response body
+	var userResponse IdentityUser
+	err = DecodeJSON(resp, body, &userResponse)
+	if err != nil {
+		return nil, err
+	}
+
+	// return the IdentityUser object
+	return &userResponse, nil
+}
+
+// GetUser retrieves the user with the given ID from external identity app using REST API call.
+func (s *IdentityService) GetUser(ctx context.Context, userID string) (*IdentityUser, error) {
+	// prepare request URL
+	url := s.config.BaseURL() + "/users/" + userID
+
+	// create request
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+	// set accept header to JSON
+	req.Header.Set("Accept", "application/json")
+
+	// make authenticated REST API call
+	resp, err := s.doWithAuth(ctx, "get", req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	// read response body
+	body, err := ReadBody(resp)
+	if err != nil {
+		return nil, err
+	}
+
+	// check response status code
+	err = CheckResponse(resp, body)
+	if err != nil {
+		return nil, err
+	}
+
+	// unmarshal response body
+	var userResponse IdentityUser
+	err = DecodeJSON(resp, body, &userResponse)
+	if err != nil {
+		return nil, err
+	}
+
+	// return the user object
+	return &userResponse, nil
+}
+
+// FindUserByIdempotencyKey is not supported, the identity app answers a repeated creation
+// with the same Idempotency-Key header with the original response instead
+func (s *IdentityService) FindUserByIdempotencyKey(ctx context.Context, key string) (*IdentityUser, error) {
+	return nil, ErrNotSupported
+}
+
+func (s *IdentityService) DeleteUser(ctx context.Context, userID string) error {
+	// prepare request URL
+	url := s.config.BaseURL() + "/users/" + userID
+
+	// create request
+	req, err := http.NewRequestWithContext(ctx, "DELETE", url, nil)
+	if err != nil {
+		return err
+	}
+
+	// make authenticated REST API call
+	resp, err := s.doWithAuth(ctx, "delete", req)
+	if err != nil {
+		return err
+	}
+	// close the response body
+	defer resp.Body.Close()
+
+	// read response body
+	body, err := ReadBody(resp)
+	if err != nil {
+		return err
+	}
+
+	// check response status code
+	return CheckResponse(resp, body)
+}
+
+func (s *IdentityService) UpdateUser(ctx context.Context, userID string, user *v1.UserSpec) (*IdentityUser, error) {
+	// prepare request URL
+	url := s.config.BaseURL() + "/users/" + userID
+
+	// prepare request body
+	body, err := json.Marshal(identityUserFor(user))
+	if err != nil {
+		return nil, err
+	}
+
+	// prepare request
+	req, err := http.NewRequestWithContext(ctx, "PUT", url, bytes.NewBuffer(body))
+	if err != nil {
+		return nil, err
+	}
+
+	// set content type header
+	req.Header.Set("Content-Type", "application/json")
+
+	// make authenticated REST API call
+	resp, err := s.doWithAuth(ctx, "update", req)
+	if err != nil {
+		return nil, err
+	}
+	// close the response body
+	defer resp.Body.Close()
+
+	// read response body
+	body, err = ReadBody(resp)
+	if err != nil {
+		return nil, err
+	}
+
+	// check response status code
+	err = CheckResponse(resp, body)
+	if err != nil {
+		return nil, err
+	}
+
+	// unmarshal response body
+	var userResponse IdentityUser
+	err = DecodeJSON(resp, body, &userResponse)
+	if err != nil {
+		return nil, err
+	}
+
+	// return the user object
+	return &userResponse, nil
+}
+
+// PatchUser updates only the given fields of the user with PATCH /users/{id}, leaving
+// fields the operator does not manage untouched. Falls back to UpdateUser for identity
+// apps configured without PATCH support.
+func (s *IdentityService) PatchUser(ctx context.Context, userID string, user *v1.UserSpec, fields []string) (*IdentityUser, error) {
+	if !s.config.PatchUpdates() {
+		return s.UpdateUser(ctx, userID, user)
+	}
+
+	var userResponse IdentityUser
+	err := s.call(ctx, "patch", "PATCH", "/users/"+userID, UserPatch(user, fields), &userResponse)
+	if err != nil {
+		return nil, err
+	}
+	return &userResponse, nil
+}
+
+// UserPatch returns the given fields of the user spec keyed by their JSON names. Unlike
+// the JSON representation of the spec, fields set to their zero value are included.
+func UserPatch(user *v1.UserSpec, fields []string) map[string]interface{} {
+	selected := map[string]bool{}
+	for _, field := range fields {
+		selected[field] = true
+	}
+	// the roles drift together with the role
+	selected["roles"] = selected["role"]
+
+	patch := map[string]interface{}{}
+	spec := reflect.ValueOf(user).Elem()
+	for i := 0; i < spec.NumField(); i++ {
+		name, _, _ := strings.Cut(spec.Type().Field(i).Tag.Get("json"), ",")
+		if selected[name] {
+			patch[name] = spec.Field(i).Interface()
+		}
+	}
+	return patch
+}
+
+// call makes an authenticated REST API call to path of the identity app. The in value,
+// if not nil, is sent as JSON request body and the JSON response body is decoded into
+// out, if not nil.
+func (s *IdentityService) call(ctx context.Context, operation, method, path string, in, out interface{}) error {
+	// prepare request url
+	url := s.config.BaseURL() + path
+
+	// prepare request body
+	var reqBody io.Reader
+	if in != nil {
+		body, err := json.Marshal(in)
+		if err != nil {
+			return err
+		}
+		reqBody = bytes.NewBuffer(body)
+	}
+
+	// prepare request
+	req, err := http.NewRequestWithContext(ctx, method, url, reqBody)
+	if err != nil {
+		return err
+	}
+
+	// set content type and accept headers
+	if in != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	req.Header.Set("Accept", "application/json")
+
+	// make authenticated REST API call
+	resp, err := s.doWithAuth(ctx, operation, req)
+	if err != nil {
+		return err
+	}
+	// close the response body
+	defer resp.Body.Close()
+
+	// read response body
+	body, err := ReadBody(resp)
+	if err != nil {
+		return err
+	}
+
+	// check response status code
+	err = CheckResponse(resp, body)
+	if err != nil {
+		return err
+	}
+
+	// unmarshal response body
+	if out == nil || len(body) == 0 {
+		return nil
+	}
+	return DecodeJSON(resp, body, out)
+}
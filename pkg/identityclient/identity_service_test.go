@@ -0,0 +1,91 @@
+package identityclient_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	v1 "github.com/m15ch4/go-identity-operator/api/v1"
+	idmsvc "github.com/m15ch4/go-identity-operator/pkg/identityclient"
+)
+
+var _ = Describe("IdentityService", func() {
+	ctx := context.Background()
+
+	It("sends only the fields of the user the identity app keeps", func() {
+		bodies := make(chan map[string]interface{}, 2)
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			w.Header().Set("Content-Type", "application/json")
+			if req.URL.Path == "/login" {
+				_, _ = w.Write([]byte(`{"token":"token"}`))
+				return
+			}
+			body := map[string]interface{}{}
+			Expect(json.NewDecoder(req.Body).Decode(&body)).To(Succeed())
+			bodies <- body
+			_, _ = w.Write([]byte(`{"id":"1","name":"jackr"}`))
+		}))
+		DeferCleanup(server.Close)
+
+		spec := &v1.UserSpec{
+			Name:           "jackr",
+			Firstname:      "Jack",
+			Role:           "admin",
+			InstanceRef:    &v1.IdentityInstanceReference{Name: "keycloak"},
+			DeletionPolicy: v1.DeletionPolicyOrphan,
+			AdoptExisting:  true,
+		}
+		api := idmsvc.New(serverOpts(server.URL)...)
+		_, err := api.CreateUser(ctx, spec)
+		Expect(err).NotTo(HaveOccurred())
+		_, err = api.UpdateUser(ctx, "1", spec)
+		Expect(err).NotTo(HaveOccurred())
+
+		for i := 0; i < 2; i++ {
+			body := <-bodies
+			Expect(body).To(Equal(map[string]interface{}{"name": "jackr", "firstname": "Jack", "role": "admin"}))
+		}
+	})
+	It("reads the rotated credentials of the credentials directory when the login is rejected", func() {
+		dir := GinkgoT().TempDir()
+		Expect(os.WriteFile(filepath.Join(dir, "IDM_USER"), []byte("operator\n"), 0o600)).To(Succeed())
+		Expect(os.WriteFile(filepath.Join(dir, "IDM_PASS"), []byte("old\n"), 0o600)).To(Succeed())
+
+		logins := make(chan string, 3)
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			w.Header().Set("Content-Type", "application/json")
+			body := map[string]string{}
+			Expect(json.NewDecoder(req.Body).Decode(&body)).To(Succeed())
+			logins <- body["password"]
+			if body["password"] != "new" {
+				w.WriteHeader(http.StatusUnauthorized)
+				_, _ = w.Write([]byte(`{"message":"invalid credentials"}`))
+				return
+			}
+			_, _ = w.Write([]byte(`{"token":"token"}`))
+		}))
+		DeferCleanup(server.Close)
+
+		cfg := idmsvc.NewIdentityConfig(append(serverOpts(server.URL), idmsvc.WithCredentialsDir(dir))...)
+		svc := idmsvc.NewIdentityService(&cfg)
+
+		// the credentials were not rotated, the login fails without a second attempt
+		_, err := svc.Token(ctx)
+		Expect(idmsvc.IsCredentialsInvalid(err)).To(BeTrue())
+		Expect(logins).To(Receive(Equal("old")))
+		Expect(logins).NotTo(Receive())
+
+		Expect(os.WriteFile(filepath.Join(dir, "IDM_PASS"), []byte("new\n"), 0o600)).To(Succeed())
+		token, err := svc.Token(ctx)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(token).To(Equal("token"))
+		Expect(logins).To(Receive(Equal("old")))
+		Expect(logins).To(Receive(Equal("new")))
+	})
+})
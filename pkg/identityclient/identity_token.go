@@ -0,0 +1,184 @@
+package identityclient
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// tokenExpiryLeeway renews tokens slightly before they expire to avoid racing the backend
+const tokenExpiryLeeway = 30 * time.Second
+
+// Token returns the cached token, logging in when there is none or it is about to expire
+func (s *IdentityService) Token(ctx context.Context) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.token != "" && time.Now().Add(tokenExpiryLeeway).Before(s.tokenExpiry) {
+		return s.token, nil
+	}
+
+	token, err := s.login(ctx)
+	if IsCredentialsInvalid(err) && s.reloadCredentials() {
+		return s.login(ctx)
+	}
+	return token, err
+}
+
+// reloadCredentials reads the credentials from the credentials directory again, e.g. after
+// the mounted Secret was rotated, and reports whether the user or password changed.
+// It must be called with s.mu held.
+func (s *IdentityService) reloadCredentials() bool {
+	if s.config.credentialsDir == "" {
+		return false
+	}
+
+	reloaded := WithCredentialsDir(s.config.credentialsDir)(*s.config)
+	if reloaded.user == s.config.user && reloaded.pass == s.config.pass {
+		return false
+	}
+
+	s.config.user = reloaded.user
+	s.config.pass = reloaded.pass
+	s.token = ""
+	return true
+}
+
+// SetCredentials replaces the login credentials, dropping the cached token if they changed
+func (s *IdentityService) SetCredentials(user, pass string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.config.user == user && s.config.pass == pass {
+		return
+	}
+
+	s.config.user = user
+	s.config.pass = pass
+	s.token = ""
+}
+
+// doWithAuth sends the request with the cached token. When the identity app rejects the
+// token with 401, e.g. because it expired early or the app restarted, it logs in again and
+// retries the request once with the new token.
+func (s *IdentityService) doWithAuth(ctx context.Context, operation string, req *http.Request) (*http.Response, error) {
+	token, err := s.Token(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := s.client.Do(operation, req.WithContext(WithAuthorization(ctx, "Bearer "+token)))
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusUnauthorized {
+		return resp, nil
+	}
+	s.invalidateTokenOn401(resp, token)
+
+	// the body of the request cannot be sent twice
+	if req.Body != nil && req.GetBody == nil {
+		return resp, nil
+	}
+
+	token, err = s.Token(ctx)
+	if err != nil {
+		resp.Body.Close()
+		return nil, err
+	}
+
+	// drain the body so the connection can be reused
+	_, _ = io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+
+	retry := req.Clone(WithAuthorization(ctx, "Bearer "+token))
+	if req.GetBody != nil {
+		body, err := req.GetBody()
+		if err != nil {
+			return nil, err
+		}
+		retry.Body = body
+	}
+
+	return s.client.Do(operation, retry)
+}
+
+// invalidateTokenOn401 drops the cached token when the identity app rejected it,
+// so the next call logs in again
+func (s *IdentityService) invalidateTokenOn401(resp *http.Response, token string) {
+	if resp.StatusCode != http.StatusUnauthorized {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// another call may have already replaced the token
+	if s.token == token {
+		s.token = ""
+	}
+}
+
+// tokenExpiryFor determines when the token of a login response expires. The expires_in
+// field takes precedence, then the exp claim of a JWT, then the configured token TTL.
+func (s *IdentityService) tokenExpiryFor(loginResponse *LoginResponse) time.Time {
+	if loginResponse.ExpiresIn > 0 {
+		return time.Now().Add(time.Duration(loginResponse.ExpiresIn) * time.Second)
+	}
+
+	if exp, ok := jwtExpiry(loginResponse.Token); ok {
+		return exp
+	}
+
+	return time.Now().Add(s.config.tokenTTL)
+}
+
+// jwtExpiry extracts the exp claim from a JWT without verifying its signature
+func jwtExpiry(token string) (time.Time, bool) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return time.Time{}, false
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return time.Time{}, false
+	}
+
+	var claims struct {
+		Exp int64 `json:"exp"`
+	}
+	if err := json.Unmarshal(payload, &claims); err != nil || claims.Exp == 0 {
+		return time.Time{}, false
+	}
+
+	return time.Unix(claims.Exp, 0), true
+}
+
+type authorizationKey struct{}
+
+// WithAuthorization returns a context whose requests to the identity app carry value in the
+// Authorization header, e.g. "Bearer " followed by a token
+func WithAuthorization(ctx context.Context, value string) context.Context {
+	return context.WithValue(ctx, authorizationKey{}, value)
+}
+
+// authorize sets the Authorization header of the requests from their context. The header
+// is not kept on the request of the caller, so middlewares further out do not see it.
+func authorize(next http.RoundTripper) http.RoundTripper {
+	return RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		value, _ := req.Context().Value(authorizationKey{}).(string)
+		if value == "" || req.Header.Get("Authorization") != "" {
+			return next.RoundTrip(req)
+		}
+
+		// a RoundTripper must not modify the request
+		authorized := req.Clone(req.Context())
+		authorized.Header.Set("Authorization", value)
+		return next.RoundTrip(authorized)
+	})
+}
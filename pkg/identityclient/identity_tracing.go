@@ -0,0 +1,31 @@
+package identityclient
+
+import (
+	"net/http"
+
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// traceRequests records a client span of every request under its operation with otelhttp, a
+// child of the span of the reconciliation, and sends the trace context so the identity app
+// can continue the trace. Every attempt of a retried request gets its own span.
+func traceRequests(next http.RoundTripper) http.RoundTripper {
+	annotate := RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		// the URL without user info and query, which may carry credentials or filters
+		target := *req.URL
+		target.User = nil
+		target.RawQuery = ""
+
+		trace.SpanFromContext(req.Context()).SetAttributes(
+			attribute.String("identity.operation", OperationFrom(req.Context())),
+			attribute.String("identity.request_id", req.Header.Get(RequestIDHeader)),
+			attribute.String("http.url", target.String()),
+		)
+		return next.RoundTrip(req)
+	})
+	return otelhttp.NewTransport(annotate, otelhttp.WithSpanNameFormatter(func(_ string, req *http.Request) string {
+		return req.Method + " " + OperationFrom(req.Context())
+	}))
+}
@@ -0,0 +1,192 @@
+package identityclient
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+
+	"golang.org/x/net/http/httpproxy"
+)
+
+// Client sends requests to an identity backend using the TLS, timeout and retry settings
+// of an IdentityConfig and records their metrics. Backend adapters use it for their REST calls.
+type Client struct {
+	config *IdentityConfig
+
+	// userSchema describes the users of the backend for the attribute mapping of config
+	userSchema *UserSchema
+
+	// httpClient is built once from the TLS settings in config
+	once       sync.Once
+	httpClient *http.Client
+	err        error
+}
+
+func NewClient(config *IdentityConfig) *Client {
+	return &Client{
+		config: config,
+	}
+}
+
+// WithUserSchema sets where the backend keeps the fields of its users, so the attribute
+// mapping of the configuration can be applied. It must be called before the first request.
+func (c *Client) WithUserSchema(schema *UserSchema) *Client {
+	c.userSchema = schema
+	return c
+}
+
+// BaseURL returns the URL of the identity backend all request paths are relative to
+func (c *Client) BaseURL() string {
+	return c.config.BaseURL()
+}
+
+// client returns the HTTP client, building it on first use
+func (c *Client) client() (*http.Client, error) {
+	c.once.Do(func() {
+		if err := c.config.attributeMapping.validate(c.userSchema); err != nil {
+			c.err = err
+			return
+		}
+		tlsConfig, err := c.config.TLSConfig()
+		if err != nil {
+			c.err = err
+			return
+		}
+		proxy, err := c.config.proxy()
+		if err != nil {
+			c.err = err
+			return
+		}
+
+		transport := c.config.transport
+		if transport == nil {
+			built := http.DefaultTransport.(*http.Transport).Clone()
+			built.TLSClientConfig = tlsConfig
+			built.Proxy = proxy
+			built.MaxIdleConns = c.config.maxIdleConns
+			built.MaxIdleConnsPerHost = c.config.maxIdleConnsPerHost
+			built.IdleConnTimeout = c.config.idleConnTimeout
+			built.MaxConnsPerHost = c.config.maxConnsPerHost
+			if !c.config.http2 {
+				// a non-nil empty map keeps the transport from upgrading to HTTP/2
+				built.ForceAttemptHTTP2 = false
+				built.TLSNextProto = map[string]func(string, *tls.Conn) http.RoundTripper{}
+			}
+			transport = built
+		}
+
+		// the middlewares of the configuration come first, the metrics are closest to the wire
+		middlewares := append(append([]Middleware(nil), c.config.middlewares...),
+			mapAttributes(c.config.attributeMapping, c.userSchema), authorize, traceRequests, logRequests, measureRequests)
+		c.httpClient = &http.Client{
+			Transport: chain(transport, middlewares...),
+			Timeout:   c.config.requestTimeout,
+		}
+	})
+
+	return c.httpClient, c.err
+}
+
+// send sends the request once, logged and counted under the given operation
+func (c *Client) send(operation string, req *http.Request) (*http.Response, error) {
+	client, err := c.client()
+	if err != nil {
+		return nil, err
+	}
+	return client.Do(req.WithContext(withOperation(req.Context(), operation)))
+}
+
+// Middleware wraps the RoundTripper sending the requests to the identity app. A Middleware
+// is shared by all requests of a client, so it must be safe for concurrent use.
+type Middleware func(next http.RoundTripper) http.RoundTripper
+
+// RoundTripperFunc is an http.RoundTripper implemented by a function
+type RoundTripperFunc func(*http.Request) (*http.Response, error)
+
+func (f RoundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+// chain wraps the transport with the middlewares, the first one outermost
+func chain(transport http.RoundTripper, middlewares ...Middleware) http.RoundTripper {
+	for i := len(middlewares) - 1; i >= 0; i-- {
+		transport = middlewares[i](transport)
+	}
+	return transport
+}
+
+type operationKey struct{}
+
+// withOperation returns a context whose requests are logged and counted under operation
+func withOperation(ctx context.Context, operation string) context.Context {
+	return context.WithValue(ctx, operationKey{}, operation)
+}
+
+// OperationFrom returns the operation a request to the identity app is sent for, e.g.
+// create or login, for middlewares labelling requests
+func OperationFrom(ctx context.Context) string {
+	operation, _ := ctx.Value(operationKey{}).(string)
+	return operation
+}
+
+// TLSConfig builds the TLS client configuration from the CA bundle, client certificate
+// and verification settings
+func (cfg *IdentityConfig) TLSConfig() (*tls.Config, error) {
+	tlsConfig := &tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		InsecureSkipVerify: cfg.insecureSkipVerify, //nolint:gosec // explicit opt-in
+	}
+
+	if len(cfg.caBundle) > 0 {
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(cfg.caBundle) {
+			return nil, fmt.Errorf("no valid PEM certificates found in CA bundle")
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	if cfg.tlsSessionCacheSize > 0 {
+		tlsConfig.ClientSessionCache = tls.NewLRUClientSessionCache(cfg.tlsSessionCacheSize)
+	}
+
+	if len(cfg.clientCert) > 0 || len(cfg.clientKey) > 0 {
+		cert, err := tls.X509KeyPair(cfg.clientCert, cfg.clientKey)
+		if err != nil {
+			return nil, fmt.Errorf("invalid client certificate: %w", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	return tlsConfig, nil
+}
+
+// proxy returns the proxy selection of the transport. Without an explicit proxy the
+// HTTP_PROXY, HTTPS_PROXY and NO_PROXY environment variables apply.
+func (cfg *IdentityConfig) proxy() (func(*http.Request) (*url.URL, error), error) {
+	if cfg.proxyURL == "" {
+		return http.ProxyFromEnvironment, nil
+	}
+
+	proxyURL, err := url.Parse(cfg.proxyURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid proxy URL: %w", err)
+	}
+	// the transport sends the user info of the proxy URL as Proxy-Authorization header
+	if cfg.proxyUser != "" {
+		proxyURL.User = url.UserPassword(cfg.proxyUser, cfg.proxyPass)
+	}
+
+	proxyFunc := (&httpproxy.Config{
+		HTTPProxy:  proxyURL.String(),
+		HTTPSProxy: proxyURL.String(),
+		NoProxy:    strings.Join(cfg.noProxy, ","),
+	}).ProxyFunc()
+	return func(req *http.Request) (*url.URL, error) {
+		return proxyFunc(req.URL)
+	}, nil
+}
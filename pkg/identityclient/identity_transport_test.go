@@ -0,0 +1,104 @@
+package identityclient_test
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	idmsvc "github.com/m15ch4/go-identity-operator/pkg/identityclient"
+	"github.com/m15ch4/go-identity-operator/pkg/tracing"
+)
+
+var _ = Describe("Transport", func() {
+	ctx := context.Background()
+
+	It("sends requests with HTTP/1.1 when HTTP/2 is disabled", func() {
+		protocols := make(chan string, 2)
+		server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			if req.URL.Path == "/users/1" {
+				protocols <- req.Proto
+			}
+			w.Header().Set("Content-Type", "application/json")
+			_, _ = w.Write([]byte(`{"id":"1","name":"jackr"}`))
+		}))
+		server.EnableHTTP2 = true
+		server.StartTLS()
+		DeferCleanup(server.Close)
+
+		for _, http2 := range []bool{true, false} {
+			api := idmsvc.New(append(serverOpts(server.URL),
+				idmsvc.WithInsecureSkipVerify(true), idmsvc.WithHTTP2(http2),
+				idmsvc.WithMaxConnsPerHost(1), idmsvc.WithTLSSessionCache(8))...)
+			_, err := api.GetUser(ctx, "1")
+			Expect(err).NotTo(HaveOccurred())
+		}
+		Expect(<-protocols).To(Equal("HTTP/2.0"))
+		Expect(<-protocols).To(Equal("HTTP/1.1"))
+	})
+
+	It("reports the TLS files of the environment that cannot be read", func() {
+		dir := GinkgoT().TempDir()
+		GinkgoT().Setenv("IDM_CA_FILE", filepath.Join(dir, "ca.crt"))
+		GinkgoT().Setenv("IDM_CLIENT_CERT_FILE", filepath.Join(dir, "tls.crt"))
+		cfg := idmsvc.NewIdentityConfig()
+		Expect(cfg.Err()).To(MatchError(ContainSubstring("unable to read IDM_CA_FILE")))
+		Expect(cfg.Err()).To(MatchError(ContainSubstring("must be set together")))
+
+		GinkgoT().Setenv("IDM_CLIENT_KEY_FILE", filepath.Join(dir, "tls.key"))
+		cfg = idmsvc.NewIdentityConfig()
+		Expect(cfg.Err()).To(MatchError(ContainSubstring("unable to read IDM_CLIENT_CERT_FILE")))
+		Expect(cfg.Err()).To(MatchError(ContainSubstring("unable to read IDM_CLIENT_KEY_FILE")))
+
+		GinkgoT().Setenv("IDM_CA_FILE", "")
+		GinkgoT().Setenv("IDM_CLIENT_CERT_FILE", "")
+		GinkgoT().Setenv("IDM_CLIENT_KEY_FILE", "")
+		cfg = idmsvc.NewIdentityConfig()
+		Expect(cfg.Err()).NotTo(HaveOccurred())
+	})
+
+	It("sends the trace context of the reconciliation and exports a span per request", func() {
+		traceparents := make(chan string, 1)
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			if req.URL.Path == "/users/1" {
+				traceparents <- req.Header.Get("traceparent")
+			}
+			w.Header().Set("Content-Type", "application/json")
+			_, _ = w.Write([]byte(`{"id":"1","name":"jackr"}`))
+		}))
+		DeferCleanup(server.Close)
+
+		bodies := make(chan string, 1)
+		collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			body, _ := io.ReadAll(req.Body)
+			bodies <- string(body)
+		}))
+		DeferCleanup(collector.Close)
+
+		GinkgoT().Setenv("OTEL_EXPORTER_OTLP_ENDPOINT", collector.URL)
+		provider, err := tracing.NewProviderFromEnvironment(ctx)
+		Expect(err).NotTo(HaveOccurred())
+		tracing.SetProvider(provider)
+		DeferCleanup(tracing.SetProvider, (*tracing.Provider)(nil))
+
+		reconcileCtx, span := tracing.Tracer().Start(ctx, "UserReconciler.Reconcile")
+		_, err = idmsvc.New(serverOpts(server.URL)...).GetUser(reconcileCtx, "1")
+		Expect(err).NotTo(HaveOccurred())
+		span.End()
+		traceID := span.SpanContext().TraceID()
+		Expect(<-traceparents).To(HavePrefix("00-" + traceID.String() + "-"))
+
+		Expect(provider.Shutdown(ctx)).To(Succeed())
+		var body string
+		Eventually(bodies).Should(Receive(&body))
+		// the spans are exported as protobuf, their strings and IDs appear as they are
+		Expect(body).To(ContainSubstring("GET get"))
+		Expect(body).To(ContainSubstring("UserReconciler.Reconcile"))
+		Expect(body).To(ContainSubstring(string(traceID[:])))
+		Expect(body).To(ContainSubstring(server.URL + "/users/1"))
+	})
+})
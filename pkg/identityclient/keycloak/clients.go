@@ -0,0 +1,87 @@
+package keycloak
+
+import (
+	"context"
+	"net/http"
+	neturl "net/url"
+
+	v1 "github.com/m15ch4/go-identity-operator/api/v1"
+	idmsvc "github.com/m15ch4/go-identity-operator/pkg/identityclient"
+)
+
+type oidcClient struct {
+	ID                     string `json:"id,omitempty"`
+	ClientID               string `json:"clientId"`
+	Description            string `json:"description,omitempty"`
+	PublicClient           bool   `json:"publicClient"`
+	ServiceAccountsEnabled bool   `json:"serviceAccountsEnabled"`
+	StandardFlowEnabled    bool   `json:"standardFlowEnabled"`
+}
+
+type clientSecret struct {
+	Type  string `json:"type,omitempty"`
+	Value string `json:"value,omitempty"`
+}
+
+// CreateAPIKey creates a confidential client with a service account, whose client secret
+// is the key of the machine identity
+func (s *Service) CreateAPIKey(ctx context.Context, spec *v1.ApiKeySpec) (*idmsvc.IdentityAPIKey, error) {
+	body := &oidcClient{
+		ClientID:               spec.Name,
+		Description:            spec.Description,
+		ServiceAccountsEnabled: true,
+	}
+	s.clients.Invalidate()
+	id, err := s.call(ctx, "keycloak_create_client", "POST", s.realmPath("clients"), body, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var secret clientSecret
+	_, err = s.call(ctx, "keycloak_get_client_secret", "GET", s.realmPath("clients", id, "client-secret"), nil, &secret)
+	if err != nil {
+		return nil, err
+	}
+	return &idmsvc.IdentityAPIKey{ID: id, Name: spec.Name, Description: spec.Description, Key: secret.Value}, nil
+}
+
+// RotateAPIKey regenerates the client secret
+func (s *Service) RotateAPIKey(ctx context.Context, keyID string) (*idmsvc.IdentityAPIKey, error) {
+	var found oidcClient
+	_, err := s.call(ctx, "keycloak_get_client", "GET", s.realmPath("clients", keyID), nil, &found)
+	if err != nil {
+		return nil, err
+	}
+
+	var secret clientSecret
+	_, err = s.call(ctx, "keycloak_regenerate_client_secret", "POST", s.realmPath("clients", keyID, "client-secret"), nil, &secret)
+	if err != nil {
+		return nil, err
+	}
+	return &idmsvc.IdentityAPIKey{ID: keyID, Name: found.ClientID, Description: found.Description, Key: secret.Value}, nil
+}
+
+// DeleteAPIKey deletes the client together with its service account
+func (s *Service) DeleteAPIKey(ctx context.Context, keyID string) error {
+	defer s.clients.Invalidate()
+	_, err := s.call(ctx, "keycloak_delete_client", "DELETE", s.realmPath("clients", keyID), nil, nil)
+	return err
+}
+
+// clientByClientID returns the ID of the client with the given clientId, or a 404 APIError
+// when there is none. The result is reused for the lookup cache TTL.
+func (s *Service) clientByClientID(ctx context.Context, clientID string) (string, error) {
+	return s.clients.Get(ctx, clientID, func(ctx context.Context) (string, error) {
+		var found []oidcClient
+		_, err := s.call(ctx, "keycloak_find_client", "GET", s.realmPath("clients")+"?clientId="+neturl.QueryEscape(clientID), nil, &found)
+		if err != nil {
+			return "", err
+		}
+		for _, c := range found {
+			if c.ClientID == clientID {
+				return c.ID, nil
+			}
+		}
+		return "", &idmsvc.APIError{StatusCode: http.StatusNotFound, Body: "client " + clientID + " not found"}
+	})
+}
@@ -0,0 +1,125 @@
+package keycloak
+
+import (
+	"context"
+
+	v1 "github.com/m15ch4/go-identity-operator/api/v1"
+	idmsvc "github.com/m15ch4/go-identity-operator/pkg/identityclient"
+)
+
+type group struct {
+	ID         string              `json:"id,omitempty"`
+	Name       string              `json:"name"`
+	Attributes map[string][]string `json:"attributes,omitempty"`
+	ParentID   string              `json:"parentId,omitempty"`
+}
+
+// CreateGroup creates a top level group, keeping its description in the description attribute
+func (s *Service) CreateGroup(ctx context.Context, spec *v1.GroupSpec) (*idmsvc.IdentityGroup, error) {
+	id, err := s.call(ctx, "keycloak_create_group", "POST", s.realmPath("groups"), groupFor(spec), nil)
+	if err != nil {
+		return nil, err
+	}
+	return s.GetGroup(ctx, id)
+}
+
+// GetGroup reads the group by ID
+func (s *Service) GetGroup(ctx context.Context, groupID string) (*idmsvc.IdentityGroup, error) {
+	var found group
+	_, err := s.call(ctx, "keycloak_get_group", "GET", s.realmPath("groups", groupID), nil, &found)
+	if err != nil {
+		return nil, err
+	}
+	return identityGroup(&found), nil
+}
+
+// UpdateGroup updates the group by ID
+func (s *Service) UpdateGroup(ctx context.Context, groupID string, spec *v1.GroupSpec) (*idmsvc.IdentityGroup, error) {
+	_, err := s.call(ctx, "keycloak_update_group", "PUT", s.realmPath("groups", groupID), groupFor(spec), nil)
+	if err != nil {
+		return nil, err
+	}
+	return s.GetGroup(ctx, groupID)
+}
+
+// DeleteGroup deletes the group by ID
+func (s *Service) DeleteGroup(ctx context.Context, groupID string) error {
+	_, err := s.call(ctx, "keycloak_delete_group", "DELETE", s.realmPath("groups", groupID), nil, nil)
+	return err
+}
+
+// ListGroupMembers returns the IDs of the direct members of the group
+func (s *Service) ListGroupMembers(ctx context.Context, groupID string) ([]string, error) {
+	var members []user
+	_, err := s.call(ctx, "keycloak_list_group_members", "GET", s.realmPath("groups", groupID, "members")+"?briefRepresentation=true&max=-1", nil, &members)
+	if err != nil {
+		return nil, err
+	}
+
+	ids := make([]string, 0, len(members))
+	for _, member := range members {
+		ids = append(ids, member.ID)
+	}
+	return ids, nil
+}
+
+// AddGroupMember joins the user to the group
+func (s *Service) AddGroupMember(ctx context.Context, groupID, userID string) error {
+	_, err := s.call(ctx, "keycloak_add_group_member", "PUT", s.realmPath("users", userID, "groups", groupID), nil, nil)
+	return err
+}
+
+// RemoveGroupMember removes the user from the group
+func (s *Service) RemoveGroupMember(ctx context.Context, groupID, userID string) error {
+	_, err := s.call(ctx, "keycloak_remove_group_member", "DELETE", s.realmPath("users", userID, "groups", groupID), nil, nil)
+	return err
+}
+
+// AddChildGroup moves the group below the parent group, making it a subgroup
+func (s *Service) AddChildGroup(ctx context.Context, parentID, groupID string) error {
+	var found group
+	_, err := s.call(ctx, "keycloak_get_group", "GET", s.realmPath("groups", groupID), nil, &found)
+	if err != nil {
+		return err
+	}
+	_, err = s.call(ctx, "keycloak_add_child_group", "POST", s.realmPath("groups", parentID, "children"), &found, nil)
+	return err
+}
+
+// RemoveChildGroup moves the subgroup of the parent group back to the top level
+func (s *Service) RemoveChildGroup(ctx context.Context, parentID, groupID string) error {
+	var found group
+	_, err := s.call(ctx, "keycloak_get_group", "GET", s.realmPath("groups", groupID), nil, &found)
+	if err != nil {
+		return err
+	}
+	if found.ParentID != parentID {
+		return nil
+	}
+	found.ParentID = ""
+	_, err = s.call(ctx, "keycloak_remove_child_group", "POST", s.realmPath("groups"), &found, nil)
+	return err
+}
+
+// groupFor converts the Group spec into a Keycloak group
+func groupFor(spec *v1.GroupSpec) *group {
+	g := &group{
+		Name: spec.Name,
+	}
+	if spec.Description != "" {
+		g.Attributes = map[string][]string{"description": {spec.Description}}
+	}
+	return g
+}
+
+// identityGroup converts a Keycloak group into the group of the identity API
+func identityGroup(g *group) *idmsvc.IdentityGroup {
+	grp := &idmsvc.IdentityGroup{
+		ID:   g.ID,
+		Name: g.Name,
+	}
+	if description := g.Attributes["description"]; len(description) > 0 {
+		grp.Description = description[0]
+	}
+	return grp
+}
@@ -0,0 +1,250 @@
+// Package keycloak implements the identity API against the admin REST API of Keycloak.
+// Users, realm roles and groups of the configured realm are managed.
+package keycloak
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	neturl "net/url"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	idmsvc "github.com/m15ch4/go-identity-operator/pkg/identityclient"
+)
+
+const (
+	// adminRealm and adminClientID are used to obtain admin tokens with the password grant
+	adminRealm    = "master"
+	adminClientID = "admin-cli"
+
+	// tokenExpiryLeeway renews tokens slightly before they expire
+	tokenExpiryLeeway = 30 * time.Second
+)
+
+type tokenResponse struct {
+	AccessToken string `json:"access_token"`
+	ExpiresIn   int    `json:"expires_in"`
+}
+
+type serverInfo struct {
+	SystemInfo struct {
+		Version string `json:"version"`
+	} `json:"systemInfo"`
+}
+
+// Service manages users, realm roles and groups of a Keycloak realm
+type Service struct {
+	config *idmsvc.IdentityConfig
+	client *idmsvc.Client
+
+	// mu guards the cached admin token
+	mu          sync.Mutex
+	token       string
+	tokenExpiry time.Time
+
+	// roles and clients cache the realm roles by name and the IDs of the clients by
+	// clientId, which the role mappings of every user look up
+	roles   *idmsvc.LookupCache[*role]
+	clients *idmsvc.LookupCache[string]
+}
+
+var _ idmsvc.IdentityAPI = &Service{}
+
+func NewService(config *idmsvc.IdentityConfig) *Service {
+	return &Service{
+		config:  config,
+		client:  idmsvc.NewClient(config).WithUserSchema(userSchema),
+		roles:   idmsvc.NewLookupCache[*role]("keycloak_get_role", config.LookupCacheTTL()),
+		clients: idmsvc.NewLookupCache[string]("keycloak_find_client", config.LookupCacheTTL()),
+	}
+}
+
+// GetToken logs in to the admin realm and returns the admin access token. A static
+// bearer token from the configuration is returned as is.
+func (s *Service) GetToken(ctx context.Context) (string, error) {
+	if token := s.config.Token(); token != "" {
+		return token, nil
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.token != "" && time.Now().Add(tokenExpiryLeeway).Before(s.tokenExpiry) {
+		return s.token, nil
+	}
+
+	// a service account client authenticates with its own credentials
+	if s.config.UsesClientCredentials() {
+		token, expiry, err := s.client.ClientCredentialsToken(ctx)
+		if err != nil {
+			return "", err
+		}
+		s.token, s.tokenExpiry = token, expiry
+		return token, nil
+	}
+
+	// prepare request body
+	user, pass := s.config.Credentials()
+	form := neturl.Values{
+		"grant_type": {"password"},
+		"client_id":  {adminClientID},
+		"username":   {user},
+		"password":   {pass},
+	}
+
+	// prepare request
+	url := s.client.BaseURL() + "/realms/" + adminRealm + "/protocol/openid-connect/token"
+	req, err := http.NewRequestWithContext(ctx, "POST", url, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	// make REST API call
+	resp, err := s.client.Do("keycloak_login", req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	// read response body
+	body, err := idmsvc.ReadBody(resp)
+	if err != nil {
+		return "", err
+	}
+
+	// check response status code
+	err = idmsvc.CheckResponse(resp, body)
+	if err != nil {
+		return "", idmsvc.LoginError(err)
+	}
+
+	// extract the access token
+	var token tokenResponse
+	err = idmsvc.DecodeJSON(resp, body, &token)
+	if err != nil {
+		return "", err
+	}
+
+	s.token = token.AccessToken
+	s.tokenExpiry = time.Now().Add(time.Duration(token.ExpiresIn) * time.Second)
+
+	return s.token, nil
+}
+
+// Info reads the version of Keycloak from the server info. The admin API has no PATCH,
+// partial updates are PUT requests carrying the changed fields only.
+func (s *Service) Info(ctx context.Context) (*idmsvc.BackendInfo, error) {
+	var info serverInfo
+	_, err := s.call(ctx, "keycloak_server_info", "GET", "/admin/serverinfo", nil, &info)
+	if err != nil {
+		return nil, err
+	}
+	return &idmsvc.BackendInfo{
+		Version:        info.SystemInfo.Version,
+		SupportsPatch:  s.config.PatchUpdates(),
+		SupportsGroups: true,
+	}, nil
+}
+
+// SetCredentials is a no-op, the credentials of Keycloak services come from their IdentityInstance
+func (s *Service) SetCredentials(user, pass string) {}
+
+// invalidateToken drops the cached token when Keycloak rejected it
+func (s *Service) invalidateToken(token string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.token == token {
+		s.token = ""
+	}
+}
+
+// realmPath returns the admin API path of the configured realm joined with elem
+func (s *Service) realmPath(elem ...string) string {
+	for i := range elem {
+		elem[i] = neturl.PathEscape(elem[i])
+	}
+	return "/admin/realms/" + neturl.PathEscape(s.config.Realm()) + "/" + path.Join(elem...)
+}
+
+// call makes an authenticated admin API request. The in value, if not nil, is sent as
+// JSON request body and the JSON response body is decoded into out, if not nil.
+// The ID of a created resource is taken from the Location header and returned.
+func (s *Service) call(ctx context.Context, operation, method, path string, in, out interface{}) (string, error) {
+	if s.config.Realm() == "" {
+		return "", fmt.Errorf("realm must be set for Keycloak")
+	}
+
+	// prepare request body
+	var reqBody io.Reader
+	if in != nil {
+		body, err := json.Marshal(in)
+		if err != nil {
+			return "", err
+		}
+		reqBody = bytes.NewBuffer(body)
+	}
+
+	// prepare request
+	req, err := http.NewRequestWithContext(ctx, method, s.client.BaseURL()+path, reqBody)
+	if err != nil {
+		return "", err
+	}
+
+	// set authorization header with cached token
+	token, err := s.GetToken(ctx)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+
+	// set content type and accept headers
+	if in != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	req.Header.Set("Accept", "application/json")
+
+	// make REST API call
+	resp, err := s.client.Do(operation, req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusUnauthorized {
+		s.invalidateToken(token)
+	}
+
+	// read response body
+	body, err := idmsvc.ReadBody(resp)
+	if err != nil {
+		return "", err
+	}
+
+	// check response status code
+	err = idmsvc.CheckResponse(resp, body)
+	if err != nil {
+		return "", err
+	}
+
+	// unmarshal response body
+	if out != nil && len(body) > 0 {
+		err = idmsvc.DecodeJSON(resp, body, out)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	// Keycloak responds to creates with 201 and the URL of the new resource
+	id := ""
+	if location := resp.Header.Get("Location"); location != "" {
+		id = location[strings.LastIndex(location, "/")+1:]
+	}
+	return id, nil
+}
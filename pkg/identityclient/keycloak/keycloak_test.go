@@ -0,0 +1,189 @@
+package keycloak
+
+import (
+	"context"
+	"net/url"
+	"strconv"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	v1 "github.com/m15ch4/go-identity-operator/api/v1"
+	idmsvc "github.com/m15ch4/go-identity-operator/pkg/identityclient"
+)
+
+var _ = Describe("Service", func() {
+	ctx := context.Background()
+
+	var (
+		kc  *server
+		svc *Service
+	)
+
+	// newService returns a service of the server, with the options of the spec
+	newService := func(opts ...idmsvc.ConfigOpts) *Service {
+		u, err := url.Parse(kc.URL)
+		Expect(err).NotTo(HaveOccurred())
+		port, err := strconv.Atoi(u.Port())
+		Expect(err).NotTo(HaveOccurred())
+		cfg := idmsvc.NewIdentityConfig(append([]idmsvc.ConfigOpts{
+			idmsvc.WithScheme(u.Scheme),
+			idmsvc.WithHost(u.Hostname()),
+			idmsvc.WithPort(port),
+			idmsvc.WithRealm(realm),
+			idmsvc.WithUser("admin"),
+			idmsvc.WithPass("s3cret"),
+			idmsvc.WithRetry(1, 0, 0),
+			idmsvc.WithLookupCacheTTL(0),
+		}, opts...)...)
+		return NewService(&cfg)
+	}
+
+	BeforeEach(func() {
+		kc = newServer()
+		DeferCleanup(kc.Close)
+		svc = newService()
+	})
+
+	// createRoles creates the realm roles with the given names
+	createRoles := func(names ...string) {
+		for _, name := range names {
+			_, err := svc.CreateRole(ctx, &v1.RoleSpec{Name: name})
+			Expect(err).NotTo(HaveOccurred())
+		}
+	}
+
+	It("logs in to the master realm, reuses the token and logs in again once it is rejected", func() {
+		_, err := svc.GetToken(ctx)
+		Expect(err).NotTo(HaveOccurred())
+		info, err := svc.Info(ctx)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(info.Version).To(Equal("24.0.1"))
+		Expect(kc.logins).To(Equal(1))
+
+		kc.revoked = true
+		_, err = svc.GetGroup(ctx, "id-1")
+		Expect(idmsvc.IsUnauthorized(err)).To(BeTrue())
+		_, err = svc.GetGroup(ctx, "id-1")
+		Expect(idmsvc.IsNotFound(err)).To(BeTrue())
+		Expect(kc.logins).To(Equal(2))
+
+		_, err = newService(idmsvc.WithPass("wrong")).GetToken(ctx)
+		Expect(idmsvc.IsCredentialsInvalid(err)).To(BeTrue())
+	})
+
+	It("creates users with their password and realm roles and finds them", func() {
+		createRoles("admin", "dev")
+		created, err := svc.CreateUser(idmsvc.WithIdempotencyKey(ctx, "key-1"), &v1.UserSpec{
+			Name: "jackr", Password: "pw", Firstname: "Jack", Email: "jackr@example.com",
+			Phone: "555-0100", Age: 40, Role: "admin", Roles: []string{"dev"},
+		})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(kc.passwords).To(HaveKeyWithValue(created.ID, "pw"))
+		Expect(kc.roleMappings[created.ID]).To(ConsistOf("admin", "dev"))
+		Expect(created.Name).To(Equal("jackr"))
+		Expect(created.Phone).To(Equal("555-0100"))
+		Expect(created.Age).To(Equal(40))
+		Expect(created.AllRoles()).To(ConsistOf("admin", "dev"), "the default roles of the realm are left out")
+
+		found, err := svc.FindUserByName(ctx, "jackr")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(found.ID).To(Equal(created.ID))
+		found, err = svc.FindUserByIdempotencyKey(ctx, "key-1")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(found.ID).To(Equal(created.ID))
+		Expect(found.Attributes).To(BeEmpty(), "the idempotency key is not a custom attribute")
+		found, err = svc.FindUserByName(ctx, "janed")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(found).To(BeNil())
+	})
+
+	It("updates users, resetting their password and replacing their roles, and deletes them", func() {
+		createRoles("admin", "dev")
+		created, err := svc.CreateUser(ctx, &v1.UserSpec{Name: "jackr", Password: "pw", Role: "admin"})
+		Expect(err).NotTo(HaveOccurred())
+
+		updated, err := svc.UpdateUser(ctx, created.ID, &v1.UserSpec{Name: "jackr", Password: "new", Lastname: "Reacher", Role: "dev"})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(updated.Lastname).To(Equal("Reacher"))
+		Expect(updated.AllRoles()).To(ConsistOf("dev"))
+		Expect(kc.passwords).To(HaveKeyWithValue(created.ID, "new"))
+
+		Expect(svc.DeleteUser(ctx, created.ID)).To(Succeed())
+		_, err = svc.GetUser(ctx, created.ID)
+		Expect(idmsvc.IsNotFound(err)).To(BeTrue())
+	})
+
+	It("patches the changed fields and merges the changed attributes into the current ones", func() {
+		createRoles("admin")
+		created, err := svc.CreateUser(ctx, &v1.UserSpec{
+			Name: "jackr", Password: "pw", Firstname: "Jack", Lastname: "Reacher",
+			Phone: "555-0100", DisplayName: "Jack", Role: "admin",
+		})
+		Expect(err).NotTo(HaveOccurred())
+		requests := len(kc.requests)
+
+		patched, err := svc.PatchUser(ctx, created.ID, &v1.UserSpec{Name: "jackr", Firstname: "Jacques"},
+			[]string{"firstname", "phone"})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(patched.Firstname).To(Equal("Jacques"))
+		Expect(patched.Lastname).To(Equal("Reacher"), "fields missing from the update are kept")
+		Expect(patched.Phone).To(BeEmpty())
+		Expect(patched.DisplayName).To(Equal("Jack"), "attributes that did not change are kept")
+		Expect(patched.AllRoles()).To(ConsistOf("admin"))
+		Expect(kc.passwords).To(HaveKeyWithValue(created.ID, "pw"))
+		Expect(kc.requests[requests:]).NotTo(ContainElement(HaveSuffix("/reset-password")))
+		Expect(kc.requests[requests:]).NotTo(ContainElement(HavePrefix("POST")))
+	})
+
+	It("creates, updates and deletes realm roles and maps them to users", func() {
+		created, err := svc.CreateRole(ctx, &v1.RoleSpec{Name: "auditor", Description: "Reads audit logs"})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(created.ID).NotTo(BeEmpty())
+		Expect(created.Description).To(Equal("Reads audit logs"))
+
+		updated, err := svc.UpdateRole(ctx, created.ID, &v1.RoleSpec{Name: "auditor", Description: "Reads everything"})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(updated.Description).To(Equal("Reads everything"))
+
+		usr, err := svc.CreateUser(ctx, &v1.UserSpec{Name: "jackr"})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(svc.AddUserRole(ctx, usr.ID, "", "auditor")).To(Succeed())
+		roles, err := svc.ListUserRoles(ctx, usr.ID, "")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(roles).To(ConsistOf("auditor"))
+		Expect(svc.RemoveUserRole(ctx, usr.ID, "", "auditor")).To(Succeed())
+		roles, err = svc.ListUserRoles(ctx, usr.ID, "")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(roles).To(BeEmpty())
+
+		Expect(svc.DeleteRole(ctx, created.ID)).To(Succeed())
+		_, err = svc.GetRole(ctx, created.ID)
+		Expect(idmsvc.IsNotFound(err)).To(BeTrue())
+	})
+
+	It("creates, updates and deletes groups and manages their members", func() {
+		created, err := svc.CreateGroup(ctx, &v1.GroupSpec{Name: "devs", Description: "Developers"})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(created.Description).To(Equal("Developers"))
+
+		updated, err := svc.UpdateGroup(ctx, created.ID, &v1.GroupSpec{Name: "developers"})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(updated.Name).To(Equal("developers"))
+
+		usr, err := svc.CreateUser(ctx, &v1.UserSpec{Name: "jackr"})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(svc.AddGroupMember(ctx, created.ID, usr.ID)).To(Succeed())
+		members, err := svc.ListGroupMembers(ctx, created.ID)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(members).To(ConsistOf(usr.ID))
+		Expect(svc.RemoveGroupMember(ctx, created.ID, usr.ID)).To(Succeed())
+		members, err = svc.ListGroupMembers(ctx, created.ID)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(members).To(BeEmpty())
+
+		Expect(svc.DeleteGroup(ctx, created.ID)).To(Succeed())
+		_, err = svc.GetGroup(ctx, created.ID)
+		Expect(idmsvc.IsNotFound(err)).To(BeTrue())
+	})
+})
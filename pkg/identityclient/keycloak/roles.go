@@ -0,0 +1,162 @@
+package keycloak
+
+import (
+	"context"
+
+	v1 "github.com/m15ch4/go-identity-operator/api/v1"
+	idmsvc "github.com/m15ch4/go-identity-operator/pkg/identityclient"
+)
+
+type role struct {
+	ID          string              `json:"id,omitempty"`
+	Name        string              `json:"name"`
+	Description string              `json:"description,omitempty"`
+	Attributes  map[string][]string `json:"attributes,omitempty"`
+}
+
+// CreateRole creates the realm role, keeping its permissions in the permissions attribute
+func (s *Service) CreateRole(ctx context.Context, spec *v1.RoleSpec) (*idmsvc.IdentityRole, error) {
+	s.roles.Invalidate()
+	_, err := s.call(ctx, "keycloak_create_role", "POST", s.realmPath("roles"), roleFor(spec), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	// realm roles are created by name, look up the ID
+	created, err := s.roleByName(ctx, spec.Name)
+	if err != nil {
+		return nil, err
+	}
+	return identityRole(created), nil
+}
+
+// GetRole reads the realm role by ID
+func (s *Service) GetRole(ctx context.Context, roleID string) (*idmsvc.IdentityRole, error) {
+	var found role
+	_, err := s.call(ctx, "keycloak_get_role", "GET", s.realmPath("roles-by-id", roleID), nil, &found)
+	if err != nil {
+		return nil, err
+	}
+	return identityRole(&found), nil
+}
+
+// UpdateRole updates the realm role by ID
+func (s *Service) UpdateRole(ctx context.Context, roleID string, spec *v1.RoleSpec) (*idmsvc.IdentityRole, error) {
+	defer s.roles.Invalidate()
+	_, err := s.call(ctx, "keycloak_update_role", "PUT", s.realmPath("roles-by-id", roleID), roleFor(spec), nil)
+	if err != nil {
+		return nil, err
+	}
+	return s.GetRole(ctx, roleID)
+}
+
+// DeleteRole deletes the realm role by ID
+func (s *Service) DeleteRole(ctx context.Context, roleID string) error {
+	defer s.roles.Invalidate()
+	_, err := s.call(ctx, "keycloak_delete_role", "DELETE", s.realmPath("roles-by-id", roleID), nil, nil)
+	return err
+}
+
+// roleByName reads the realm role by name, reusing the result for the lookup cache TTL
+func (s *Service) roleByName(ctx context.Context, name string) (*role, error) {
+	return s.roles.Get(ctx, name, func(ctx context.Context) (*role, error) {
+		var found role
+		_, err := s.call(ctx, "keycloak_get_role", "GET", s.realmPath("roles", name), nil, &found)
+		if err != nil {
+			return nil, err
+		}
+		return &found, nil
+	})
+}
+
+// ListUserRoles returns the realm roles of the user without the default roles, or its
+// roles of the client whose clientId is the scope
+func (s *Service) ListUserRoles(ctx context.Context, userID, scope string) ([]string, error) {
+	mappings := s.realmPath("users", userID, "role-mappings", "realm")
+	if scope != "" {
+		clientID, err := s.clientByClientID(ctx, scope)
+		if err != nil {
+			return nil, err
+		}
+		mappings = s.realmPath("users", userID, "role-mappings", "clients", clientID)
+	}
+
+	var roles []role
+	_, err := s.call(ctx, "keycloak_get_user_roles", "GET", mappings, nil, &roles)
+	if err != nil {
+		return nil, err
+	}
+
+	var names []string
+	for _, r := range roles {
+		if scope == "" && (defaultRoles[r.Name] || r.Name == "default-roles-"+s.config.Realm()) {
+			continue
+		}
+		names = append(names, r.Name)
+	}
+	return names, nil
+}
+
+// AddUserRole maps the realm role, or the role of the client whose clientId is the scope,
+// to the user
+func (s *Service) AddUserRole(ctx context.Context, userID, scope, roleName string) error {
+	r, mappings, err := s.scopedRole(ctx, userID, scope, roleName)
+	if err != nil {
+		return err
+	}
+	_, err = s.call(ctx, "keycloak_add_user_role", "POST", mappings, []role{*r}, nil)
+	return err
+}
+
+// RemoveUserRole removes the mapping of the realm role, or the role of the client whose
+// clientId is the scope, from the user
+func (s *Service) RemoveUserRole(ctx context.Context, userID, scope, roleName string) error {
+	r, mappings, err := s.scopedRole(ctx, userID, scope, roleName)
+	if err != nil {
+		return err
+	}
+	_, err = s.call(ctx, "keycloak_remove_user_role", "DELETE", mappings, []role{*r}, nil)
+	return err
+}
+
+// scopedRole reads the realm role, or the role of the client whose clientId is the scope,
+// and returns it together with the path of the matching role mappings of the user
+func (s *Service) scopedRole(ctx context.Context, userID, scope, roleName string) (*role, string, error) {
+	if scope == "" {
+		r, err := s.roleByName(ctx, roleName)
+		return r, s.realmPath("users", userID, "role-mappings", "realm"), err
+	}
+
+	clientID, err := s.clientByClientID(ctx, scope)
+	if err != nil {
+		return nil, "", err
+	}
+	var found role
+	_, err = s.call(ctx, "keycloak_get_client_role", "GET", s.realmPath("clients", clientID, "roles", roleName), nil, &found)
+	if err != nil {
+		return nil, "", err
+	}
+	return &found, s.realmPath("users", userID, "role-mappings", "clients", clientID), nil
+}
+
+// roleFor converts the Role spec into a Keycloak realm role
+func roleFor(spec *v1.RoleSpec) *role {
+	r := &role{
+		Name:        spec.Name,
+		Description: spec.Description,
+	}
+	if len(spec.Permissions) > 0 {
+		r.Attributes = map[string][]string{"permissions": spec.Permissions}
+	}
+	return r
+}
+
+// identityRole converts a Keycloak realm role into the role of the identity API
+func identityRole(r *role) *idmsvc.IdentityRole {
+	return &idmsvc.IdentityRole{
+		ID:          r.ID,
+		Name:        r.Name,
+		Description: r.Description,
+		Permissions: r.Attributes["permissions"],
+	}
+}
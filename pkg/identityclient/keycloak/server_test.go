@@ -0,0 +1,220 @@
+package keycloak
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+// server is an in-memory Keycloak admin API of a single realm. Like Keycloak, it answers
+// creates with the Location of the new resource and keeps the fields missing from the
+// representation of a PUT.
+type server struct {
+	*httptest.Server
+
+	mu sync.Mutex
+	// resources are the users, groups and roles-by-id by their admin API path
+	resources map[string]map[string]interface{}
+	// passwords are the passwords of the users by ID
+	passwords map[string]string
+	// roleMappings are the names of the realm roles of the users by ID
+	roleMappings map[string][]string
+	// members are the IDs of the members of the groups by ID
+	members map[string][]string
+	nextID  int
+	// logins counts the token requests, tokens are valid until revoked
+	logins  int
+	revoked bool
+	// requests records the method and path of each admin API request
+	requests []string
+}
+
+const realm = "demo"
+
+func newServer() *server {
+	s := &server{
+		resources:    map[string]map[string]interface{}{},
+		passwords:    map[string]string{},
+		roleMappings: map[string][]string{},
+		members:      map[string][]string{},
+	}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serve))
+	return s
+}
+
+func (s *server) serve(w http.ResponseWriter, req *http.Request) {
+	defer GinkgoRecover()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if req.URL.Path == "/realms/master/protocol/openid-connect/token" {
+		Expect(req.ParseForm()).To(Succeed())
+		if req.PostForm.Get("username") != "admin" || req.PostForm.Get("password") != "s3cret" {
+			s.reply(w, http.StatusUnauthorized, map[string]interface{}{"error": "invalid_grant"})
+			return
+		}
+		s.logins++
+		s.revoked = false
+		s.reply(w, http.StatusOK, map[string]interface{}{
+			"access_token": "token-" + strconv.Itoa(s.logins), "expires_in": 300,
+		})
+		return
+	}
+
+	s.requests = append(s.requests, req.Method+" "+req.URL.Path)
+	if s.revoked || req.Header.Get("Authorization") != "Bearer token-"+strconv.Itoa(s.logins) {
+		s.reply(w, http.StatusUnauthorized, map[string]interface{}{"error": "HTTP 401 Unauthorized"})
+		return
+	}
+
+	if req.URL.Path == "/admin/serverinfo" {
+		s.reply(w, http.StatusOK, map[string]interface{}{"systemInfo": map[string]interface{}{"version": "24.0.1"}})
+		return
+	}
+
+	path := strings.TrimPrefix(req.URL.Path, "/admin/realms/"+realm)
+	elem := strings.Split(strings.TrimPrefix(path, "/"), "/")
+	switch {
+	case req.Method == "POST" && (path == "/users" || path == "/groups" || path == "/roles"):
+		s.create(w, req, path)
+	case req.Method == "GET" && path == "/users":
+		s.findUsers(w, req)
+	case elem[0] == "roles" && len(elem) == 2:
+		for p, r := range s.resources {
+			if strings.HasPrefix(p, "/roles-by-id/") && r["name"] == elem[1] {
+				s.reply(w, http.StatusOK, r)
+				return
+			}
+		}
+		s.reply(w, http.StatusNotFound, map[string]interface{}{"error": "Could not find role"})
+	case elem[0] == "users" && len(elem) == 3 && elem[2] == "reset-password":
+		var c credential
+		Expect(json.NewDecoder(req.Body).Decode(&c)).To(Succeed())
+		s.passwords[elem[1]] = c.Value
+		w.WriteHeader(http.StatusNoContent)
+	case elem[0] == "users" && len(elem) == 4 && elem[2] == "role-mappings":
+		s.mapRoles(w, req, elem[1])
+	case elem[0] == "users" && len(elem) == 4 && elem[2] == "groups":
+		if req.Method == "PUT" {
+			s.members[elem[3]] = append(s.members[elem[3]], elem[1])
+		} else {
+			s.members[elem[3]] = remove(s.members[elem[3]], elem[1])
+		}
+		w.WriteHeader(http.StatusNoContent)
+	case elem[0] == "groups" && len(elem) == 3 && elem[2] == "members":
+		members := []interface{}{}
+		for _, id := range s.members[elem[1]] {
+			members = append(members, s.resources["/users/"+id])
+		}
+		s.reply(w, http.StatusOK, members)
+	default:
+		s.serveResource(w, req, path)
+	}
+}
+
+// create stores the resource under a new ID, roles are read by ID from /roles-by-id
+func (s *server) create(w http.ResponseWriter, req *http.Request, path string) {
+	var resource map[string]interface{}
+	Expect(json.NewDecoder(req.Body).Decode(&resource)).To(Succeed())
+	s.nextID++
+	id := "id-" + strconv.Itoa(s.nextID)
+	resource["id"] = id
+	if credentials, ok := resource["credentials"].([]interface{}); ok {
+		s.passwords[id] = credentials[0].(map[string]interface{})["value"].(string)
+		delete(resource, "credentials")
+	}
+	if path == "/roles" {
+		path = "/roles-by-id"
+	}
+	s.resources[path+"/"+id] = resource
+	w.Header().Set("Location", s.URL+"/admin/realms/"+realm+path+"/"+id)
+	w.WriteHeader(http.StatusCreated)
+}
+
+// findUsers answers the users with the exact username or the attribute of the q query
+func (s *server) findUsers(w http.ResponseWriter, req *http.Request) {
+	username := req.URL.Query().Get("username")
+	attribute, value, _ := strings.Cut(req.URL.Query().Get("q"), ":")
+
+	users := []interface{}{}
+	for p, u := range s.resources {
+		if !strings.HasPrefix(p, "/users/") {
+			continue
+		}
+		attributes, _ := u["attributes"].(map[string]interface{})
+		values, _ := attributes[attribute].([]interface{})
+		if (username != "" && u["username"] == username) || (attribute != "" && len(values) > 0 && values[0] == value) {
+			users = append(users, u)
+		}
+	}
+	s.reply(w, http.StatusOK, users)
+}
+
+// mapRoles lists, adds or removes the realm role mappings of the user
+func (s *server) mapRoles(w http.ResponseWriter, req *http.Request, userID string) {
+	if req.Method == "GET" {
+		roles := []interface{}{map[string]interface{}{"name": "default-roles-" + realm}}
+		for _, name := range s.roleMappings[userID] {
+			roles = append(roles, map[string]interface{}{"name": name})
+		}
+		s.reply(w, http.StatusOK, roles)
+		return
+	}
+
+	var roles []role
+	Expect(json.NewDecoder(req.Body).Decode(&roles)).To(Succeed())
+	for _, r := range roles {
+		Expect(s.resources).To(HaveKey("/roles-by-id/"+r.ID), "roles are mapped by their representation")
+		if req.Method == "POST" {
+			s.roleMappings[userID] = append(s.roleMappings[userID], r.Name)
+		} else {
+			s.roleMappings[userID] = remove(s.roleMappings[userID], r.Name)
+		}
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// serveResource reads, updates or deletes the resource at the path
+func (s *server) serveResource(w http.ResponseWriter, req *http.Request, path string) {
+	resource, found := s.resources[path]
+	if !found {
+		s.reply(w, http.StatusNotFound, map[string]interface{}{"error": "Resource not found"})
+		return
+	}
+	switch req.Method {
+	case "GET":
+		s.reply(w, http.StatusOK, resource)
+	case "PUT":
+		var update map[string]interface{}
+		Expect(json.NewDecoder(req.Body).Decode(&update)).To(Succeed())
+		for field, value := range update {
+			resource[field] = value
+		}
+		w.WriteHeader(http.StatusNoContent)
+	case "DELETE":
+		delete(s.resources, path)
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func (s *server) reply(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	Expect(json.NewEncoder(w).Encode(body)).To(Succeed())
+}
+
+func remove(values []string, value string) []string {
+	var kept []string
+	for _, v := range values {
+		if v != value {
+			kept = append(kept, v)
+		}
+	}
+	return kept
+}
@@ -0,0 +1,16 @@
+package keycloak
+
+import (
+	"testing"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+// The service is tested against an in-memory admin API served with httptest
+
+func TestKeycloak(t *testing.T) {
+	RegisterFailHandler(Fail)
+
+	RunSpecs(t, "Keycloak Suite")
+}
@@ -0,0 +1,462 @@
+package keycloak
+
+import (
+	"context"
+	neturl "net/url"
+	"strconv"
+
+	v1 "github.com/m15ch4/go-identity-operator/api/v1"
+	idmsvc "github.com/m15ch4/go-identity-operator/pkg/identityclient"
+)
+
+// listPageSize is the number of users requested per page when listing users
+const listPageSize = 100
+
+// defaultRoles are assigned to every user by Keycloak and not managed by the operator
+var defaultRoles = map[string]bool{
+	"offline_access":    true,
+	"uma_authorization": true,
+}
+
+type credential struct {
+	Type      string `json:"type"`
+	Value     string `json:"value"`
+	Temporary bool   `json:"temporary"`
+}
+
+type user struct {
+	ID          string              `json:"id,omitempty"`
+	Username    string              `json:"username"`
+	FirstName   string              `json:"firstName,omitempty"`
+	LastName    string              `json:"lastName,omitempty"`
+	Email       string              `json:"email,omitempty"`
+	Enabled     bool                `json:"enabled"`
+	Attributes  map[string][]string `json:"attributes,omitempty"`
+	Credentials []credential        `json:"credentials,omitempty"`
+}
+
+// userSchema describes the users of Keycloak for the attribute mapping, the fields without
+// a dedicated property are kept in attributes
+var userSchema = &idmsvc.UserSchema{
+	Paths: map[string]string{
+		"firstname": "firstName", "lastname": "lastName", "email": "email",
+		"phone": "attributes.phoneNumber", "displayName": "attributes.displayName", "age": "attributes.age",
+	},
+	Operations: map[string]bool{
+		"keycloak_create_user": true, "keycloak_get_user": true, "keycloak_find_user": true,
+		"keycloak_list_users": true, "keycloak_update_user": true, "keycloak_patch_user": true,
+	},
+}
+
+// idempotencyKeyAttribute is the user attribute holding the idempotency key of the creation
+const idempotencyKeyAttribute = "idempotencyKey"
+
+// CreateUser creates the user with its password and assigns its realm roles. The idempotency
+// key of the context is stored in an attribute, so FindUserByIdempotencyKey finds the user.
+func (s *Service) CreateUser(ctx context.Context, spec *v1.UserSpec) (*idmsvc.IdentityUser, error) {
+	body := userFor(spec)
+	if spec.Password != "" {
+		body.Credentials = []credential{{Type: "password", Value: spec.Password}}
+	}
+	if key := idmsvc.IdempotencyKeyFrom(ctx); key != "" {
+		if body.Attributes == nil {
+			body.Attributes = map[string][]string{}
+		}
+		body.Attributes[idempotencyKeyAttribute] = []string{key}
+	}
+
+	id, err := s.call(ctx, "keycloak_create_user", "POST", s.realmPath("users"), body, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.setUserRoles(ctx, id, nil, spec.AllRoles())
+	if err != nil {
+		return nil, err
+	}
+
+	return s.GetUser(ctx, id)
+}
+
+// GetUser reads the user together with its realm roles
+func (s *Service) GetUser(ctx context.Context, userID string) (*idmsvc.IdentityUser, error) {
+	var found user
+	_, err := s.call(ctx, "keycloak_get_user", "GET", s.realmPath("users", userID), nil, &found)
+	if err != nil {
+		return nil, err
+	}
+
+	roles, err := s.userRoles(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	usr := identityUser(&found)
+	usr.SetRoles(roles)
+	return usr, nil
+}
+
+// FindUserByName looks up the user by exact username and returns nil without error when there is none
+func (s *Service) FindUserByName(ctx context.Context, name string) (*idmsvc.IdentityUser, error) {
+	var found []user
+	_, err := s.call(ctx, "keycloak_find_user", "GET", s.realmPath("users")+"?exact=true&username="+neturl.QueryEscape(name), nil, &found)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range found {
+		if found[i].Username == name {
+			return s.GetUser(ctx, found[i].ID)
+		}
+	}
+	return nil, nil
+}
+
+// FindUserByIdempotencyKey looks up the user created with the given idempotency key by
+// its attribute. It returns nil without error when no such user exists.
+func (s *Service) FindUserByIdempotencyKey(ctx context.Context, key string) (*idmsvc.IdentityUser, error) {
+	var found []user
+	query := neturl.QueryEscape(idempotencyKeyAttribute + ":" + key)
+	_, err := s.call(ctx, "keycloak_find_user", "GET", s.realmPath("users")+"?q="+query, nil, &found)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range found {
+		for _, value := range found[i].Attributes[idempotencyKeyAttribute] {
+			if value == key {
+				return s.GetUser(ctx, found[i].ID)
+			}
+		}
+	}
+	return nil, nil
+}
+
+// ListUsers returns a page of the users of the realm, paginated by offset. Roles are not
+// resolved, as that takes a request per user.
+func (s *Service) ListUsers(ctx context.Context, filter idmsvc.UserFilter, pageOpts idmsvc.PageOptions) (*idmsvc.UserPage, error) {
+	limit := pageOpts.Limit
+	if limit <= 0 {
+		limit = listPageSize
+	}
+	path := s.realmPath("users") + "?briefRepresentation=true&first=" + strconv.Itoa(pageOpts.Offset) + "&max=" + strconv.Itoa(limit)
+	if filter.Name != "" {
+		path += "&exact=true&username=" + neturl.QueryEscape(filter.Name)
+	}
+
+	var found []user
+	_, err := s.call(ctx, "keycloak_list_users", "GET", path, nil, &found)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &idmsvc.UserPage{}
+	for i := range found {
+		if filter.Name == "" || found[i].Username == filter.Name {
+			result.Users = append(result.Users, *identityUser(&found[i]))
+		}
+	}
+	if len(found) == limit {
+		result.Next = &idmsvc.PageOptions{Limit: limit, Offset: pageOpts.Offset + limit}
+	}
+	return result, nil
+}
+
+// UpdateUser updates the user, resets its password and replaces its realm roles
+func (s *Service) UpdateUser(ctx context.Context, userID string, spec *v1.UserSpec) (*idmsvc.IdentityUser, error) {
+	_, err := s.call(ctx, "keycloak_update_user", "PUT", s.realmPath("users", userID), userFor(spec), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	if spec.Password != "" {
+		_, err = s.call(ctx, "keycloak_reset_password", "PUT", s.realmPath("users", userID, "reset-password"),
+			credential{Type: "password", Value: spec.Password}, nil)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	roles, err := s.userRoles(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	err = s.setUserRoles(ctx, userID, roles, spec.AllRoles())
+	if err != nil {
+		return nil, err
+	}
+
+	return s.GetUser(ctx, userID)
+}
+
+// userAttributes maps the fields of the User spec kept in Keycloak user attributes to their attribute names
+var userAttributes = map[string]string{
+	"age":         "age",
+	"phone":       "phoneNumber",
+	"displayName": "displayName",
+}
+
+// PatchUser updates only the given fields of the user. Keycloak keeps the profile fields
+// missing from the representation, the attributes are merged into the current ones and
+// the password and realm roles are only replaced when listed. Falls back to UpdateUser for
+// instances configured without partial updates.
+func (s *Service) PatchUser(ctx context.Context, userID string, spec *v1.UserSpec, fields []string) (*idmsvc.IdentityUser, error) {
+	if !s.config.PatchUpdates() {
+		return s.UpdateUser(ctx, userID, spec)
+	}
+
+	desired := userFor(spec)
+	changed := map[string]bool{}
+	for _, field := range fields {
+		changed[field] = true
+	}
+
+	body := map[string]interface{}{}
+	if changed["name"] {
+		body["username"] = desired.Username
+	}
+	if changed["firstname"] {
+		body["firstName"] = desired.FirstName
+	}
+	if changed["lastname"] {
+		body["lastName"] = desired.LastName
+	}
+	if changed["email"] {
+		body["email"] = desired.Email
+	}
+	if changed["enabled"] {
+		body["enabled"] = desired.Enabled
+	}
+	for field, attribute := range userAttributes {
+		if !changed[field] {
+			continue
+		}
+		attributes, err := s.currentAttributes(ctx, userID, body)
+		if err != nil {
+			return nil, err
+		}
+		if value, ok := desired.Attributes[attribute]; ok {
+			attributes[attribute] = value
+		} else {
+			delete(attributes, attribute)
+		}
+	}
+	if changed["attributes"] {
+		attributes, err := s.currentAttributes(ctx, userID, body)
+		if err != nil {
+			return nil, err
+		}
+		for attribute := range attributes {
+			if !reservedAttribute(attribute) {
+				delete(attributes, attribute)
+			}
+		}
+		for attribute, value := range desired.Attributes {
+			if !reservedAttribute(attribute) {
+				attributes[attribute] = value
+			}
+		}
+	}
+	if len(body) > 0 {
+		_, err := s.call(ctx, "keycloak_patch_user", "PUT", s.realmPath("users", userID), body, nil)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if changed["password"] && spec.Password != "" {
+		_, err := s.call(ctx, "keycloak_reset_password", "PUT", s.realmPath("users", userID, "reset-password"),
+			credential{Type: "password", Value: spec.Password}, nil)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if changed["role"] {
+		roles, err := s.userRoles(ctx, userID)
+		if err != nil {
+			return nil, err
+		}
+		err = s.setUserRoles(ctx, userID, roles, spec.AllRoles())
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return s.GetUser(ctx, userID)
+}
+
+// DeleteUser deletes the user
+func (s *Service) DeleteUser(ctx context.Context, userID string) error {
+	_, err := s.call(ctx, "keycloak_delete_user", "DELETE", s.realmPath("users", userID), nil, nil)
+	return err
+}
+
+// The admin API of Keycloak has no bulk endpoint that reports the created users, users are
+// created one by one
+
+func (s *Service) CreateUsers(ctx context.Context, specs []*v1.UserSpec) ([]idmsvc.BulkResult, error) {
+	return nil, idmsvc.ErrNotSupported
+}
+
+// userRoles returns the realm roles of the user that are not default roles
+func (s *Service) userRoles(ctx context.Context, userID string) ([]string, error) {
+	var roles []role
+	_, err := s.call(ctx, "keycloak_get_user_roles", "GET", s.realmPath("users", userID, "role-mappings", "realm"), nil, &roles)
+	if err != nil {
+		return nil, err
+	}
+
+	var names []string
+	for _, r := range roles {
+		if !defaultRoles[r.Name] && r.Name != "default-roles-"+s.config.Realm() {
+			names = append(names, r.Name)
+		}
+	}
+	return names, nil
+}
+
+// setUserRoles replaces the realm role mappings of the user from the current to the desired
+// roles, leaving the roles in both alone
+func (s *Service) setUserRoles(ctx context.Context, userID string, current, desired []string) error {
+	mappings := s.realmPath("users", userID, "role-mappings", "realm")
+
+	removed, err := s.rolesByName(ctx, current, desired)
+	if err != nil {
+		return err
+	}
+	if len(removed) > 0 {
+		_, err = s.call(ctx, "keycloak_remove_user_role", "DELETE", mappings, removed, nil)
+		if err != nil {
+			return err
+		}
+	}
+
+	added, err := s.rolesByName(ctx, desired, current)
+	if err != nil {
+		return err
+	}
+	if len(added) > 0 {
+		_, err = s.call(ctx, "keycloak_add_user_role", "POST", mappings, added, nil)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// rolesByName looks up the realm roles with the given names, except those in skipped
+func (s *Service) rolesByName(ctx context.Context, names, skipped []string) ([]role, error) {
+	skip := map[string]bool{}
+	for _, name := range skipped {
+		skip[name] = true
+	}
+
+	var roles []role
+	for _, name := range names {
+		if skip[name] {
+			continue
+		}
+		r, err := s.roleByName(ctx, name)
+		if err != nil {
+			return nil, err
+		}
+		roles = append(roles, *r)
+	}
+	return roles, nil
+}
+
+// currentAttributes returns the attributes of the patch body, starting from the current
+// attributes of the user as Keycloak replaces them as a whole
+func (s *Service) currentAttributes(ctx context.Context, userID string, body map[string]interface{}) (map[string][]string, error) {
+	if attributes, ok := body["attributes"]; ok {
+		return attributes.(map[string][]string), nil
+	}
+	var current user
+	_, err := s.call(ctx, "keycloak_get_user", "GET", s.realmPath("users", userID), nil, &current)
+	if err != nil {
+		return nil, err
+	}
+	if current.Attributes == nil {
+		current.Attributes = map[string][]string{}
+	}
+	body["attributes"] = current.Attributes
+	return current.Attributes, nil
+}
+
+// reservedAttribute reports whether the Keycloak user attribute holds a field of the User
+// spec or the idempotency key, custom attributes of the same name are ignored
+func reservedAttribute(attribute string) bool {
+	if attribute == idempotencyKeyAttribute {
+		return true
+	}
+	for _, reserved := range userAttributes {
+		if attribute == reserved {
+			return true
+		}
+	}
+	return false
+}
+
+// userFor converts the User spec into a Keycloak user without credentials
+func userFor(spec *v1.UserSpec) *user {
+	u := &user{
+		Username:  spec.Name,
+		FirstName: spec.Firstname,
+		LastName:  spec.Lastname,
+		Email:     spec.Email,
+		Enabled:   spec.IsEnabled(),
+	}
+	// Keycloak has no dedicated fields for the rest of the profile
+	attributes := map[string][]string{}
+	if spec.Age != 0 {
+		attributes["age"] = []string{strconv.Itoa(spec.Age)}
+	}
+	if spec.Phone != "" {
+		attributes["phoneNumber"] = []string{spec.Phone}
+	}
+	if spec.DisplayName != "" {
+		attributes["displayName"] = []string{spec.DisplayName}
+	}
+	for attribute, value := range spec.Attributes {
+		if !reservedAttribute(attribute) {
+			attributes[attribute] = []string{value}
+		}
+	}
+	if len(attributes) > 0 {
+		u.Attributes = attributes
+	}
+	return u
+}
+
+// identityUser converts a Keycloak user into the user of the identity API
+func identityUser(u *user) *idmsvc.IdentityUser {
+	usr := &idmsvc.IdentityUser{
+		ID:        u.ID,
+		Name:      u.Username,
+		Firstname: u.FirstName,
+		Lastname:  u.LastName,
+		Email:     u.Email,
+		Enabled:   &u.Enabled,
+	}
+	if age := u.Attributes["age"]; len(age) > 0 {
+		usr.Age, _ = strconv.Atoi(age[0])
+	}
+	if phone := u.Attributes["phoneNumber"]; len(phone) > 0 {
+		usr.Phone = phone[0]
+	}
+	if displayName := u.Attributes["displayName"]; len(displayName) > 0 {
+		usr.DisplayName = displayName[0]
+	}
+	for attribute, values := range u.Attributes {
+		if reservedAttribute(attribute) || len(values) == 0 {
+			continue
+		}
+		if usr.Attributes == nil {
+			usr.Attributes = map[string]string{}
+		}
+		usr.Attributes[attribute] = values[0]
+	}
+	return usr
+}
@@ -0,0 +1,571 @@
+package ldap
+
+import (
+	"bufio"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	ber "github.com/go-asn1-ber/asn1-ber"
+	ldapv3 "github.com/go-ldap/ldap/v3"
+)
+
+// directory is an in-memory LDAP server for the specs. It keeps the entries with the
+// attributes they were added with, maintains memberOf like the memberof overlay and updates
+// the members of groups when their entries are renamed or deleted. The messages are encoded
+// and decoded with asn1-ber, the BER library of go-ldap.
+type directory struct {
+	listener net.Listener
+	bindDN   string
+	password string
+
+	mu      sync.Mutex
+	entries map[string]*directoryEntry
+	conns   map[net.Conn]bool
+	nextID  int
+	// requests counts the requests by protocol operation
+	requests map[ber.Tag]int
+}
+
+type directoryEntry struct {
+	dn         string
+	attributes map[string][]string
+}
+
+// reply is a response to a request, with the controls of the response
+type reply struct {
+	op       *ber.Packet
+	controls []ldapv3.Control
+}
+
+// operationalAttributes are only returned when requested by name
+var operationalAttributes = map[string]bool{"entryuuid": true, "memberof": true}
+
+// newDirectory serves a directory with organizational units of the DNs, over TLS if
+// tlsConfig is set
+func newDirectory(bindDN, password string, tlsConfig *tls.Config, dns ...string) *directory {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		panic(err)
+	}
+	if tlsConfig != nil {
+		listener = tls.NewListener(listener, tlsConfig)
+	}
+	d := &directory{
+		listener: listener,
+		bindDN:   bindDN,
+		password: password,
+		entries:  map[string]*directoryEntry{},
+		conns:    map[net.Conn]bool{},
+		requests: map[ber.Tag]int{},
+	}
+	for _, dn := range dns {
+		d.entries[strings.ToLower(dn)] = &directoryEntry{dn: dn, attributes: map[string][]string{"objectClass": {"organizationalUnit"}}}
+	}
+	go d.serve()
+	return d
+}
+
+func (d *directory) port() int {
+	return d.listener.Addr().(*net.TCPAddr).Port
+}
+
+func (d *directory) close() {
+	_ = d.listener.Close()
+	d.disconnect()
+}
+
+// disconnect closes the connections of the clients, as servers do with idle ones
+func (d *directory) disconnect() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for c := range d.conns {
+		_ = c.Close()
+	}
+}
+
+// attributes returns the attributes of the entry with the DN, nil if there is none
+func (d *directory) attributes(dn string) map[string][]string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if e, ok := d.entries[strings.ToLower(dn)]; ok {
+		return e.attributes
+	}
+	return nil
+}
+
+// count returns the number of requests of the protocol operation
+func (d *directory) count(op ber.Tag) int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.requests[op]
+}
+
+func (d *directory) serve() {
+	for {
+		c, err := d.listener.Accept()
+		if err != nil {
+			return
+		}
+		d.mu.Lock()
+		d.conns[c] = true
+		d.mu.Unlock()
+		go d.handle(c)
+	}
+}
+
+// handle answers the requests of a connection until it is closed or unbound
+func (d *directory) handle(c net.Conn) {
+	defer func() {
+		d.mu.Lock()
+		delete(d.conns, c)
+		d.mu.Unlock()
+		_ = c.Close()
+	}()
+
+	reader := bufio.NewReader(c)
+	bound := false
+	for {
+		msg, err := ber.ReadPacket(reader)
+		if err != nil || len(msg.Children) < 2 {
+			return
+		}
+		op := msg.Children[1]
+		if op.Tag == ldapv3.ApplicationUnbindRequest {
+			return
+		}
+		var controls []*ber.Packet
+		if len(msg.Children) > 2 {
+			controls = msg.Children[2].Children
+		}
+
+		d.mu.Lock()
+		d.requests[op.Tag]++
+		var replies []reply
+		switch {
+		case op.Tag == ldapv3.ApplicationBindRequest:
+			bound = str(op.Children[1]) == d.bindDN && str(op.Children[2]) == d.password
+			code := uint16(ldapv3.LDAPResultSuccess)
+			if !bound {
+				code = ldapv3.LDAPResultInvalidCredentials
+			}
+			replies = []reply{{op: result(ldapv3.ApplicationBindResponse, code, "")}}
+		case !bound:
+			replies = []reply{{op: result(responseTag(op.Tag), ldapv3.LDAPResultInsufficientAccessRights, "bind required")}}
+		default:
+			replies = d.apply(op, controls)
+		}
+		d.mu.Unlock()
+
+		for _, r := range replies {
+			response := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "")
+			response.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagInteger, msg.Children[0].Value, ""))
+			response.AppendChild(r.op)
+			if r.controls != nil {
+				encoded := ber.Encode(ber.ClassContext, ber.TypeConstructed, 0, nil, "")
+				for _, control := range r.controls {
+					encoded.AppendChild(control.Encode())
+				}
+				response.AppendChild(encoded)
+			}
+			if _, err := c.Write(response.Bytes()); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// apply applies the request to the entries and returns the responses
+func (d *directory) apply(op *ber.Packet, controls []*ber.Packet) []reply {
+	tag := responseTag(op.Tag)
+	var err error
+	switch op.Tag {
+	case ldapv3.ApplicationSearchRequest:
+		return d.search(op, controls)
+	case ldapv3.ApplicationAddRequest:
+		err = d.add(op)
+	case ldapv3.ApplicationModifyRequest:
+		err = d.modify(op)
+	case ldapv3.ApplicationModifyDNRequest:
+		err = d.rename(op)
+	case ldapv3.ApplicationDelRequest:
+		err = d.del(str(op))
+	default:
+		err = failure(ldapv3.LDAPResultUnwillingToPerform, fmt.Sprintf("unsupported operation %d", op.Tag))
+	}
+	if err != nil {
+		res := err.(*ldapv3.Error)
+		return []reply{{op: result(tag, res.ResultCode, res.Err.Error())}}
+	}
+	return []reply{{op: result(tag, ldapv3.LDAPResultSuccess, "")}}
+}
+
+func (d *directory) search(op *ber.Packet, controls []*ber.Packet) []reply {
+	base, scope, filter := str(op.Children[0]), op.Children[1].Value.(int64), op.Children[6]
+	var requested []string
+	for _, a := range op.Children[7].Children {
+		requested = append(requested, str(a))
+	}
+
+	if base == "" && scope == ldapv3.ScopeBaseObject {
+		rootDSE := &directoryEntry{attributes: map[string][]string{"vendorVersion": {"directory 1.0"}}}
+		return []reply{
+			{op: d.encodeEntry(rootDSE, requested)},
+			{op: result(ldapv3.ApplicationSearchResultDone, ldapv3.LDAPResultSuccess, "")},
+		}
+	}
+	if _, ok := d.entries[strings.ToLower(base)]; !ok {
+		return []reply{{op: result(ldapv3.ApplicationSearchResultDone, ldapv3.LDAPResultNoSuchObject, "no base "+base)}}
+	}
+
+	var matched []*directoryEntry
+	for _, e := range d.entries {
+		dn := strings.ToLower(e.dn)
+		inScope := dn == strings.ToLower(base) ||
+			(scope == ldapv3.ScopeWholeSubtree && strings.HasSuffix(dn, ","+strings.ToLower(base)))
+		if inScope && d.matches(e, filter) {
+			matched = append(matched, e)
+		}
+	}
+	// sorted by DN, so pages are stable
+	sort.Slice(matched, func(i, j int) bool { return matched[i].dn < matched[j].dn })
+
+	// paged results continue at the offset given as cookie
+	var done reply
+	for _, packet := range controls {
+		control, err := ldapv3.DecodeControl(packet)
+		paging, ok := control.(*ldapv3.ControlPaging)
+		if err != nil || !ok {
+			continue
+		}
+		size := int(paging.PagingSize)
+		offset, _ := strconv.Atoi(string(paging.Cookie))
+		matched = matched[offset:]
+		response := ldapv3.NewControlPaging(0)
+		if len(matched) > size {
+			matched = matched[:size]
+			response.SetCookie([]byte(strconv.Itoa(offset + size)))
+		}
+		done.controls = []ldapv3.Control{response}
+	}
+
+	var replies []reply
+	for _, e := range matched {
+		replies = append(replies, reply{op: d.encodeEntry(e, requested)})
+	}
+	done.op = result(ldapv3.ApplicationSearchResultDone, ldapv3.LDAPResultSuccess, "")
+	return append(replies, done)
+}
+
+// matches evaluates the and, equality and presence filters
+func (d *directory) matches(e *directoryEntry, f *ber.Packet) bool {
+	switch f.Tag {
+	case ldapv3.FilterAnd:
+		for _, child := range f.Children {
+			if !d.matches(e, child) {
+				return false
+			}
+		}
+		return true
+	case ldapv3.FilterEqualityMatch:
+		for _, value := range d.values(e, str(f.Children[0])) {
+			if strings.EqualFold(value, str(f.Children[1])) {
+				return true
+			}
+		}
+		return false
+	case ldapv3.FilterPresent:
+		return len(d.values(e, str(f))) > 0
+	}
+	return false
+}
+
+// values returns the values of the attribute of the entry, memberOf lists the groups
+// having the entry as member
+func (d *directory) values(e *directoryEntry, name string) []string {
+	if strings.EqualFold(name, "memberOf") {
+		var groups []string
+		for _, group := range d.entries {
+			for _, member := range lookup(group.attributes, memberAttribute) {
+				if strings.EqualFold(member, e.dn) {
+					groups = append(groups, group.dn)
+				}
+			}
+		}
+		return groups
+	}
+	return lookup(e.attributes, name)
+}
+
+// encodeEntry returns the search result entry with the requested attributes
+func (d *directory) encodeEntry(e *directoryEntry, requested []string) *ber.Packet {
+	all := false
+	names := map[string]string{}
+	for _, name := range requested {
+		if name == allAttributes {
+			all = true
+		} else if name != noAttributes {
+			names[strings.ToLower(name)] = name
+		}
+	}
+	if all {
+		for name := range e.attributes {
+			if !operationalAttributes[strings.ToLower(name)] {
+				names[strings.ToLower(name)] = name
+			}
+		}
+	}
+
+	attributes := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "")
+	for _, name := range names {
+		values := d.values(e, name)
+		if len(values) == 0 {
+			continue
+		}
+		set := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSet, nil, "")
+		for _, value := range values {
+			set.AppendChild(octetString(value))
+		}
+		attribute := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "")
+		attribute.AppendChild(octetString(canonicalName(e.attributes, name)))
+		attribute.AppendChild(set)
+		attributes.AppendChild(attribute)
+	}
+	packet := ber.Encode(ber.ClassApplication, ber.TypeConstructed, ldapv3.ApplicationSearchResultEntry, nil, "")
+	packet.AppendChild(octetString(e.dn))
+	packet.AppendChild(attributes)
+	return packet
+}
+
+func (d *directory) add(op *ber.Packet) error {
+	dn := str(op.Children[0])
+	if _, ok := d.entries[strings.ToLower(dn)]; ok {
+		return failure(ldapv3.LDAPResultEntryAlreadyExists, dn)
+	}
+	if _, ok := d.entries[strings.ToLower(parentDN(dn))]; !ok {
+		return failure(ldapv3.LDAPResultNoSuchObject, "no parent of "+dn)
+	}
+
+	d.nextID++
+	e := &directoryEntry{dn: dn, attributes: map[string][]string{
+		"entryUUID":  {fmt.Sprintf("00000000-0000-4000-8000-%012d", d.nextID)},
+		"objectGUID": {fmt.Sprintf("guid%012d", d.nextID)},
+	}}
+	for _, a := range op.Children[1].Children {
+		e.attributes[str(a.Children[0])] = packetValues(a.Children[1])
+	}
+	for _, class := range lookup(e.attributes, "objectClass") {
+		if class == "person" {
+			e.attributes["objectCategory"] = []string{"person"}
+		}
+	}
+	d.entries[strings.ToLower(dn)] = e
+	return nil
+}
+
+func (d *directory) modify(op *ber.Packet) error {
+	dn := str(op.Children[0])
+	e, ok := d.entries[strings.ToLower(dn)]
+	if !ok {
+		return failure(ldapv3.LDAPResultNoSuchObject, dn)
+	}
+
+	// the changes are applied to a copy, all of them or none
+	attributes := map[string][]string{}
+	for name, values := range e.attributes {
+		attributes[name] = values
+	}
+	for _, ch := range op.Children[1].Children {
+		name, values := str(ch.Children[1].Children[0]), packetValues(ch.Children[1].Children[1])
+		name = canonicalName(attributes, name)
+		current := attributes[name]
+		switch uint(ch.Children[0].Value.(int64)) {
+		case ldapv3.AddAttribute:
+			for _, value := range values {
+				if contains(current, value) {
+					return failure(ldapv3.LDAPResultAttributeOrValueExists, name)
+				}
+				current = append(current, value)
+			}
+			attributes[name] = current
+		case ldapv3.DeleteAttribute:
+			if len(current) == 0 {
+				return failure(ldapv3.LDAPResultNoSuchAttribute, name)
+			}
+			if len(values) == 0 {
+				delete(attributes, name)
+				continue
+			}
+			var kept []string
+			for _, value := range current {
+				if !contains(values, value) {
+					kept = append(kept, value)
+				}
+			}
+			if len(kept) != len(current)-len(values) {
+				return failure(ldapv3.LDAPResultNoSuchAttribute, name)
+			}
+			attributes[name] = kept
+		case ldapv3.ReplaceAttribute:
+			if len(values) == 0 {
+				delete(attributes, name)
+				continue
+			}
+			attributes[name] = values
+		}
+	}
+	e.attributes = attributes
+	return nil
+}
+
+func (d *directory) rename(op *ber.Packet) error {
+	dn, rdn := str(op.Children[0]), str(op.Children[1])
+	e, ok := d.entries[strings.ToLower(dn)]
+	if !ok {
+		return failure(ldapv3.LDAPResultNoSuchObject, dn)
+	}
+	newDN := rdn + "," + parentDN(dn)
+	if _, ok := d.entries[strings.ToLower(newDN)]; ok {
+		return failure(ldapv3.LDAPResultEntryAlreadyExists, newDN)
+	}
+
+	name, value, _ := strings.Cut(rdn, "=")
+	name = canonicalName(e.attributes, name)
+	oldName, oldValue, _ := strings.Cut(dn[:len(dn)-len(parentDN(dn))-1], "=")
+	e.attributes[canonicalName(e.attributes, oldName)] = remove(lookup(e.attributes, oldName), unescape(oldValue))
+	e.attributes[name] = append(lookup(e.attributes, name), unescape(value))
+
+	delete(d.entries, strings.ToLower(dn))
+	e.dn = newDN
+	d.entries[strings.ToLower(newDN)] = e
+	d.replaceMember(dn, newDN)
+	return nil
+}
+
+func (d *directory) del(dn string) error {
+	if _, ok := d.entries[strings.ToLower(dn)]; !ok {
+		return failure(ldapv3.LDAPResultNoSuchObject, dn)
+	}
+	delete(d.entries, strings.ToLower(dn))
+	d.replaceMember(dn, "")
+	return nil
+}
+
+// replaceMember replaces the DN among the members of the groups, or removes it
+func (d *directory) replaceMember(dn, newDN string) {
+	for _, group := range d.entries {
+		name := canonicalName(group.attributes, memberAttribute)
+		members := group.attributes[name]
+		if !contains(members, dn) {
+			continue
+		}
+		members = remove(members, dn)
+		if newDN != "" {
+			members = append(members, newDN)
+		}
+		group.attributes[name] = members
+	}
+}
+
+// lookup returns the values of the attribute, whose name is case insensitive
+func lookup(attributes map[string][]string, name string) []string {
+	return attributes[canonicalName(attributes, name)]
+}
+
+// canonicalName returns the name of the attribute as it is kept
+func canonicalName(attributes map[string][]string, name string) string {
+	for kept := range attributes {
+		if strings.EqualFold(kept, name) {
+			return kept
+		}
+	}
+	return name
+}
+
+func packetValues(set *ber.Packet) []string {
+	var values []string
+	for _, value := range set.Children {
+		values = append(values, str(value))
+	}
+	return values
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if strings.EqualFold(v, value) {
+			return true
+		}
+	}
+	return false
+}
+
+func remove(values []string, value string) []string {
+	var kept []string
+	for _, v := range values {
+		if !strings.EqualFold(v, value) {
+			kept = append(kept, v)
+		}
+	}
+	return kept
+}
+
+// parentDN returns the DN of the parent of the entry
+func parentDN(dn string) string {
+	for i := 0; i < len(dn); i++ {
+		switch dn[i] {
+		case '\\':
+			i++
+		case ',':
+			return dn[i+1:]
+		}
+	}
+	return ""
+}
+
+// unescape removes the escaping of the value of a relative DN
+func unescape(value string) string {
+	var b strings.Builder
+	for i := 0; i < len(value); i++ {
+		if value[i] == '\\' && i+1 < len(value) {
+			i++
+		}
+		b.WriteByte(value[i])
+	}
+	return b.String()
+}
+
+// str returns the content of a primitive element, e.g. an octet string
+func str(p *ber.Packet) string {
+	return p.Data.String()
+}
+
+func octetString(value string) *ber.Packet {
+	return ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, value, "")
+}
+
+// failure returns the result of a failed operation
+func failure(code uint16, message string) error {
+	return ldapv3.NewError(code, errors.New(message))
+}
+
+// responseTag returns the tag of the response to the request
+func responseTag(request ber.Tag) ber.Tag {
+	if request == ldapv3.ApplicationDelRequest {
+		return ldapv3.ApplicationDelResponse
+	}
+	return request + 1
+}
+
+// result returns the response with the result code
+func result(tag ber.Tag, code uint16, message string) *ber.Packet {
+	packet := ber.Encode(ber.ClassApplication, ber.TypeConstructed, tag, nil, "")
+	packet.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagEnumerated, int64(code), ""))
+	packet.AppendChild(octetString(""))
+	packet.AppendChild(octetString(message))
+	return packet
+}
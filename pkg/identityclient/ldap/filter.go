@@ -0,0 +1,47 @@
+package ldap
+
+import (
+	"strings"
+
+	ldapv3 "github.com/go-ldap/ldap/v3"
+)
+
+// Attribute selectors of a search requesting all user attributes or none (RFC 4511,
+// section 4.5.1.8)
+const (
+	allAttributes = "*"
+	noAttributes  = "1.1"
+)
+
+// and matches the entries matching all the filters
+func and(filters ...string) string {
+	return "(&" + strings.Join(filters, "") + ")"
+}
+
+// equal matches the entries with the value of the attribute, which is escaped
+func equal(attribute, value string) string {
+	return "(" + attribute + "=" + ldapv3.EscapeFilter(value) + ")"
+}
+
+// present matches the entries with any value of the attribute
+func present(attribute string) string {
+	return "(" + attribute + "=*)"
+}
+
+// childDN returns the DN of the entry named by the value of the attribute below the parent
+func childDN(attribute, value, parent string) string {
+	return attribute + "=" + ldapv3.EscapeDN(value) + "," + parent
+}
+
+// renamedDN returns the DN of the entry once renamed to the relative DN
+func renamedDN(dn, rdn string) (string, error) {
+	parsed, err := ldapv3.ParseDN(dn)
+	if err != nil {
+		return "", ldapv3.NewError(ldapv3.LDAPResultInvalidDNSyntax, err)
+	}
+	if len(parsed.RDNs) < 2 {
+		return rdn, nil
+	}
+	parent := &ldapv3.DN{RDNs: parsed.RDNs[1:]}
+	return rdn + "," + parent.String(), nil
+}
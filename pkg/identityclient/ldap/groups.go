@@ -0,0 +1,189 @@
+package ldap
+
+import (
+	"context"
+
+	ldapv3 "github.com/go-ldap/ldap/v3"
+
+	v1 "github.com/m15ch4/go-identity-operator/api/v1"
+	idmsvc "github.com/m15ch4/go-identity-operator/pkg/identityclient"
+)
+
+// memberAttribute lists the DNs of the members of a group
+const memberAttribute = "member"
+
+// CreateGroup adds the entry of the group below the groups DN. groupOfNames requires a
+// member, new groups have the empty DN as member, which is no user or group.
+func (s *Service) CreateGroup(ctx context.Context, spec *v1.GroupSpec) (*idmsvc.IdentityGroup, error) {
+	dn := childDN("cn", spec.Name, s.groupsDN())
+	req := ldapv3.NewAddRequest(dn, nil)
+	req.Attribute("objectClass", s.schema.groupClasses)
+	req.Attribute("cn", []string{spec.Name})
+	if s.schema.activeDirectory {
+		req.Attribute("sAMAccountName", []string{spec.Name})
+	} else {
+		req.Attribute(memberAttribute, []string{""})
+	}
+	if spec.Description != "" {
+		req.Attribute("description", []string{spec.Description})
+	}
+
+	var created *ldapv3.Entry
+	err := s.do(ctx, "ldap_create_group", func(c *ldapv3.Conn) error {
+		if err := c.Add(req); err != nil {
+			return err
+		}
+		var err error
+		created, err = readEntry(c, dn, s.groupAttributes())
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return s.identityGroup(created), nil
+}
+
+// GetGroup reads the group by ID
+func (s *Service) GetGroup(ctx context.Context, groupID string) (*idmsvc.IdentityGroup, error) {
+	var found *ldapv3.Entry
+	err := s.do(ctx, "ldap_get_group", func(c *ldapv3.Conn) error {
+		var err error
+		found, err = s.findEntry(c, s.groupsDN(), s.schema.groupFilter, groupID, s.groupAttributes())
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return s.identityGroup(found), nil
+}
+
+// UpdateGroup renames the entry of the group if its name changed and replaces its description
+func (s *Service) UpdateGroup(ctx context.Context, groupID string, spec *v1.GroupSpec) (*idmsvc.IdentityGroup, error) {
+	var updated *ldapv3.Entry
+	err := s.do(ctx, "ldap_update_group", func(c *ldapv3.Conn) error {
+		current, err := s.findEntry(c, s.groupsDN(), s.schema.groupFilter, groupID, s.groupAttributes())
+		if err != nil {
+			return err
+		}
+
+		dn := current.DN
+		if current.GetEqualFoldAttributeValue("cn") != spec.Name {
+			if dn, err = rename(c, dn, "cn", spec.Name); err != nil {
+				return err
+			}
+		}
+
+		req := ldapv3.NewModifyRequest(dn, nil)
+		req.Replace("description", optional(spec.Description))
+		if s.schema.activeDirectory {
+			req.Replace("sAMAccountName", []string{spec.Name})
+		}
+		if err := c.Modify(req); err != nil {
+			return err
+		}
+		updated, err = readEntry(c, dn, s.groupAttributes())
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return s.identityGroup(updated), nil
+}
+
+// DeleteGroup deletes the entry of the group
+func (s *Service) DeleteGroup(ctx context.Context, groupID string) error {
+	return s.do(ctx, "ldap_delete_group", func(c *ldapv3.Conn) error {
+		found, err := s.findEntry(c, s.groupsDN(), s.schema.groupFilter, groupID, []string{noAttributes})
+		if err != nil {
+			return err
+		}
+		return c.Del(ldapv3.NewDelRequest(found.DN, nil))
+	})
+}
+
+// ListGroupMembers returns the IDs of the users that are members of the group by their
+// memberOf attribute, which the server maintains from the members of the groups
+func (s *Service) ListGroupMembers(ctx context.Context, groupID string) ([]string, error) {
+	var ids []string
+	err := s.do(ctx, "ldap_list_group_members", func(c *ldapv3.Conn) error {
+		group, err := s.findEntry(c, s.groupsDN(), s.schema.groupFilter, groupID, []string{noAttributes})
+		if err != nil {
+			return err
+		}
+		members, err := c.Search(ldapv3.NewSearchRequest(s.usersDN(), ldapv3.ScopeWholeSubtree, ldapv3.NeverDerefAliases,
+			0, 0, false, and(s.schema.userFilter, equal("memberOf", group.DN)), []string{s.schema.idAttribute}, nil))
+		if err != nil {
+			return err
+		}
+		for _, member := range members.Entries {
+			ids = append(ids, s.schema.id(member))
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return ids, nil
+}
+
+// AddGroupMember adds the DN of the user to the members of the group
+func (s *Service) AddGroupMember(ctx context.Context, groupID, userID string) error {
+	return s.changeMember(ctx, "ldap_add_group_member", ldapv3.AddAttribute, groupID, s.usersDN(), s.schema.userFilter, userID)
+}
+
+// RemoveGroupMember removes the DN of the user from the members of the group
+func (s *Service) RemoveGroupMember(ctx context.Context, groupID, userID string) error {
+	return s.changeMember(ctx, "ldap_remove_group_member", ldapv3.DeleteAttribute, groupID, s.usersDN(), s.schema.userFilter, userID)
+}
+
+// AddChildGroup adds the DN of the group to the members of the parent group
+func (s *Service) AddChildGroup(ctx context.Context, parentID, groupID string) error {
+	return s.changeMember(ctx, "ldap_add_child_group", ldapv3.AddAttribute, parentID, s.groupsDN(), s.schema.groupFilter, groupID)
+}
+
+// RemoveChildGroup removes the DN of the group from the members of the parent group
+func (s *Service) RemoveChildGroup(ctx context.Context, parentID, groupID string) error {
+	return s.changeMember(ctx, "ldap_remove_child_group", ldapv3.DeleteAttribute, parentID, s.groupsDN(), s.schema.groupFilter, groupID)
+}
+
+// changeMember adds or deletes the DN of the member, an entry of the filter below the base
+// DN, to or from the members of the group. Adding a member twice or removing one that is not
+// a member succeeds; Active Directory reports existing members as existing entries.
+func (s *Service) changeMember(ctx context.Context, operation string, modification uint, groupID, baseDN, selector, memberID string) error {
+	return s.do(ctx, operation, func(c *ldapv3.Conn) error {
+		group, err := s.findEntry(c, s.groupsDN(), s.schema.groupFilter, groupID, []string{noAttributes})
+		if err != nil {
+			return err
+		}
+		member, err := s.findEntry(c, baseDN, selector, memberID, []string{noAttributes})
+		if err != nil {
+			return err
+		}
+
+		req := ldapv3.NewModifyRequest(group.DN, nil)
+		req.Changes = []ldapv3.Change{{Operation: modification, Modification: ldapv3.PartialAttribute{Type: memberAttribute, Vals: []string{member.DN}}}}
+		err = c.Modify(req)
+		switch {
+		case modification == ldapv3.AddAttribute &&
+			ldapv3.IsErrorAnyOf(err, ldapv3.LDAPResultAttributeOrValueExists, ldapv3.LDAPResultEntryAlreadyExists):
+			return nil
+		case modification == ldapv3.DeleteAttribute && ldapv3.IsErrorWithCode(err, ldapv3.LDAPResultNoSuchAttribute):
+			return nil
+		}
+		return err
+	})
+}
+
+// groupAttributes returns the attributes read from the entries of groups
+func (s *Service) groupAttributes() []string {
+	return []string{"cn", "description", s.schema.idAttribute}
+}
+
+// identityGroup converts the entry of a group into the group of the identity API
+func (s *Service) identityGroup(e *ldapv3.Entry) *idmsvc.IdentityGroup {
+	return &idmsvc.IdentityGroup{
+		ID:          s.schema.id(e),
+		Name:        e.GetEqualFoldAttributeValue("cn"),
+		Description: e.GetEqualFoldAttributeValue("description"),
+	}
+}
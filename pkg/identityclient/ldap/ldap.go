@@ -0,0 +1,268 @@
+// Package ldap implements the identity API against an LDAP directory, such as OpenLDAP or
+// Active Directory. Users and groups are entries below the DNs of the configuration, group
+// membership is read back through the memberOf attribute of the users.
+package ldap
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	ldapv3 "github.com/go-ldap/ldap/v3"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+
+	idmsvc "github.com/m15ch4/go-identity-operator/pkg/identityclient"
+	"github.com/m15ch4/go-identity-operator/pkg/tracing"
+)
+
+// Service manages the users and groups of an LDAP directory. It binds with the DN and
+// password of the configuration and sends the operations one at a time over a go-ldap
+// connection kept open between them.
+type Service struct {
+	config *idmsvc.IdentityConfig
+	schema *schema
+
+	// mu guards the credentials and the connection, nil until the first operation or
+	// after it broke
+	mu       sync.Mutex
+	bindDN   string
+	password string
+	conn     *ldapv3.Conn
+}
+
+var _ idmsvc.IdentityAPI = &Service{}
+
+func NewService(config *idmsvc.IdentityConfig) *Service {
+	bindDN, password := config.Credentials()
+	s := &Service{
+		config:   config,
+		schema:   inetOrgPersonSchema,
+		bindDN:   bindDN,
+		password: password,
+	}
+	if config.LDAP().ActiveDirectory {
+		s.schema = activeDirectorySchema
+	}
+	return s
+}
+
+// GetToken binds with the credentials to check them. LDAP has no tokens, the returned one
+// is always empty.
+func (s *Service) GetToken(ctx context.Context) (string, error) {
+	err := s.do(ctx, "ldap_bind", func(c *ldapv3.Conn) error {
+		return s.bind(c)
+	})
+	return "", err
+}
+
+// Info reads the version of the server from the root DSE, if it publishes one. Entries
+// are modified attribute by attribute, so users are always patched unless configured not to.
+func (s *Service) Info(ctx context.Context) (*idmsvc.BackendInfo, error) {
+	var version string
+	err := s.do(ctx, "ldap_get_root_dse", func(c *ldapv3.Conn) error {
+		root, err := readEntry(c, "", []string{"vendorVersion"})
+		if err != nil {
+			return err
+		}
+		version = root.GetEqualFoldAttributeValue("vendorVersion")
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &idmsvc.BackendInfo{
+		Version:        version,
+		SupportsPatch:  s.config.PatchUpdates(),
+		SupportsGroups: true,
+	}, nil
+}
+
+// SetCredentials sets the DN and password to bind with, the next operation binds again
+// with them
+func (s *Service) SetCredentials(user, pass string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.bindDN, s.password = user, pass
+	if s.conn != nil {
+		_ = s.conn.Close()
+		s.conn = nil
+	}
+}
+
+// do runs the operation over the connection, dialing and binding first if there is none.
+// A connection that was kept open may have been closed by the server in the meantime, the
+// operation is run once more over a new one if it fails without a result. The operation
+// is counted in the identity_api_* metrics and traced under the name.
+func (s *Service) do(ctx context.Context, operation string, fn func(*ldapv3.Conn) error) error {
+	host, _, _ := net.SplitHostPort(s.config.Address())
+	ctx, span := tracing.Tracer().Start(ctx, "LDAP "+operation, trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			attribute.String("identity.operation", operation),
+			attribute.String("server.address", host),
+		))
+	defer span.End()
+
+	start := time.Now()
+	err := s.run(ctx, fn)
+	code := "error"
+	if status := statusCode(err); status != 0 {
+		code = strconv.Itoa(status)
+	}
+	idmsvc.RecordRequest(operation, code, time.Since(start))
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	return apiError(err)
+}
+
+// run runs fn over the connection, see do
+func (s *Service) run(ctx context.Context, fn func(*ldapv3.Conn) error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.conn != nil && s.conn.IsClosing() {
+		s.conn = nil
+	}
+	reused := s.conn != nil
+	for {
+		if s.conn == nil {
+			c, err := s.dial()
+			if err != nil {
+				return err
+			}
+			if err := interruptible(ctx, c, func() error { return s.bind(c) }); err != nil {
+				_ = c.Close()
+				return err
+			}
+			s.conn = c
+		}
+
+		err := interruptible(ctx, s.conn, func() error { return fn(s.conn) })
+		_, isResult := resultCode(err)
+		rejected := errors.As(err, new(*idmsvc.CredentialsError))
+		if err == nil || (isResult && !rejected) {
+			return err
+		}
+		// the connection is in an unknown state after anything but a result, and anonymous
+		// after a rejected bind
+		_ = s.conn.Close()
+		s.conn = nil
+		if !reused || rejected || ctx.Err() != nil {
+			return err
+		}
+		reused = false
+	}
+}
+
+// dial connects to the server of the configuration, over LDAPS if it uses TLS. Each
+// operation is bounded by the request timeout.
+func (s *Service) dial() (*ldapv3.Conn, error) {
+	opts := []ldapv3.DialOpt{ldapv3.DialWithDialer(&net.Dialer{Timeout: s.config.RequestTimeout()})}
+	scheme := "ldap://"
+	if s.config.UsesTLS() {
+		tlsConfig, err := s.config.TLSConfig()
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, ldapv3.DialWithTLSConfig(tlsConfig))
+		scheme = "ldaps://"
+	}
+	c, err := ldapv3.DialURL(scheme+s.config.Address(), opts...)
+	if err != nil {
+		return nil, err
+	}
+	if timeout := s.config.RequestTimeout(); timeout > 0 {
+		c.SetTimeout(timeout)
+	}
+	return c, nil
+}
+
+// interruptible runs fn and closes the connection when the context is done before it
+// returns, go-ldap has no contexts. The error of the context is returned instead of the
+// one of the interrupted operation.
+func interruptible(ctx context.Context, c *ldapv3.Conn, fn func() error) error {
+	done, stopped := make(chan struct{}), make(chan struct{})
+	go func() {
+		defer close(stopped)
+		select {
+		case <-ctx.Done():
+			_ = c.Close()
+		case <-done:
+		}
+	}()
+	err := fn()
+	close(done)
+	<-stopped
+
+	if ctxErr := ctx.Err(); err != nil && ctxErr != nil {
+		return ctxErr
+	}
+	return err
+}
+
+// bind binds the connection with the credentials, rejected ones are a CredentialsError
+func (s *Service) bind(c *ldapv3.Conn) error {
+	err := c.Bind(s.bindDN, s.password)
+	if ldapv3.IsErrorAnyOf(err, ldapv3.LDAPResultInvalidCredentials, ldapv3.ErrorEmptyPassword) {
+		return &idmsvc.CredentialsError{Err: err}
+	}
+	return err
+}
+
+// resultCode returns the code of the result the server completed the operation with, false
+// for errors without a result such as broken connections
+func resultCode(err error) (uint16, bool) {
+	var ldapErr *ldapv3.Error
+	if !errors.As(err, &ldapErr) || ldapErr.ResultCode >= ldapv3.ErrorNetwork {
+		return 0, false
+	}
+	return ldapErr.ResultCode, true
+}
+
+// apiError converts the result of an operation into the APIError of the HTTP status code it
+// corresponds to, so the controllers handle it like the errors of the other identity systems.
+// Rejected credentials and errors without a result are returned as they are.
+func apiError(err error) error {
+	if _, ok := resultCode(err); !ok || errors.As(err, new(*idmsvc.CredentialsError)) {
+		return err
+	}
+	return idmsvc.NewAPIError(statusCode(err), []byte(err.Error()))
+}
+
+// statusCode returns the HTTP status code the result of an operation corresponds to, or 0
+// for operations without a result
+func statusCode(err error) int {
+	code, isResult := resultCode(err)
+	switch {
+	case err == nil:
+		return http.StatusOK
+	case errors.As(err, new(*idmsvc.CredentialsError)):
+		return http.StatusUnauthorized
+	case !isResult:
+		return 0
+	}
+
+	switch code {
+	case ldapv3.LDAPResultNoSuchObject:
+		return http.StatusNotFound
+	case ldapv3.LDAPResultEntryAlreadyExists, ldapv3.LDAPResultAttributeOrValueExists:
+		return http.StatusConflict
+	case ldapv3.LDAPResultInsufficientAccessRights:
+		return http.StatusForbidden
+	case ldapv3.LDAPResultNoSuchAttribute, ldapv3.LDAPResultUndefinedAttributeType,
+		ldapv3.LDAPResultConstraintViolation, ldapv3.LDAPResultInvalidAttributeSyntax,
+		ldapv3.LDAPResultInvalidDNSyntax, ldapv3.LDAPResultObjectClassViolation,
+		ldapv3.LDAPResultUnwillingToPerform:
+		return http.StatusBadRequest
+	case ldapv3.LDAPResultBusy, ldapv3.LDAPResultUnavailable:
+		return http.StatusServiceUnavailable
+	}
+	return http.StatusInternalServerError
+}
@@ -0,0 +1,304 @@
+package ldap
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"time"
+
+	ldapv3 "github.com/go-ldap/ldap/v3"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	v1 "github.com/m15ch4/go-identity-operator/api/v1"
+	idmsvc "github.com/m15ch4/go-identity-operator/pkg/identityclient"
+)
+
+var _ = Describe("Service", func() {
+	ctx := context.Background()
+
+	const (
+		bindDN   = "cn=admin,dc=example,dc=com"
+		password = "s3cret"
+		baseDN   = "dc=example,dc=com"
+		usersDN  = "ou=people,dc=example,dc=com"
+		groupsDN = "ou=groups,dc=example,dc=com"
+	)
+
+	var (
+		dir *directory
+		svc *Service
+	)
+
+	// newService returns a service of the directory, with the options of the spec
+	newService := func(opts ...idmsvc.ConfigOpts) *Service {
+		cfg := idmsvc.NewIdentityConfig(append([]idmsvc.ConfigOpts{
+			idmsvc.WithHost("127.0.0.1"),
+			idmsvc.WithPort(dir.port()),
+			idmsvc.WithUser(bindDN),
+			idmsvc.WithPass(password),
+			idmsvc.WithLDAP(idmsvc.LDAPConfig{UsersDN: usersDN, GroupsDN: groupsDN}),
+		}, opts...)...)
+		return NewService(&cfg)
+	}
+
+	BeforeEach(func() {
+		dir = newDirectory(bindDN, password, nil, baseDN, usersDN, groupsDN)
+		DeferCleanup(dir.close)
+		svc = newService()
+	})
+
+	It("binds with the credentials and reports rejected ones", func() {
+		_, err := svc.GetToken(ctx)
+		Expect(err).NotTo(HaveOccurred())
+
+		_, err = newService(idmsvc.WithPass("wrong")).GetToken(ctx)
+		Expect(idmsvc.IsCredentialsInvalid(err)).To(BeTrue())
+	})
+
+	It("reports the version of the root DSE", func() {
+		info, err := svc.Info(ctx)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(info.Version).To(Equal("directory 1.0"))
+		Expect(info.SupportsGroups).To(BeTrue())
+	})
+
+	It("creates inetOrgPerson users and reads them back", func() {
+		created, err := svc.CreateUser(ctx, &v1.UserSpec{
+			Name: "jackr", Password: "pw", Firstname: "Jack", Email: "jackr@example.com",
+			Role: "admin", Roles: []string{"dev"},
+		})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(created.ID).NotTo(BeEmpty())
+		Expect(created.Name).To(Equal("jackr"))
+		Expect(created.Firstname).To(Equal("Jack"))
+		Expect(created.Lastname).To(BeEmpty(), "the surname required by inetOrgPerson is no last name")
+		Expect(created.AllRoles()).To(Equal([]string{"admin", "dev"}))
+		Expect(created.Enabled).To(BeNil())
+		Expect(created.Attributes).To(BeEmpty())
+
+		attributes := dir.attributes("uid=jackr," + usersDN)
+		Expect(attributes).To(HaveKeyWithValue("objectClass", ContainElement("inetOrgPerson")))
+		Expect(attributes).To(HaveKeyWithValue("cn", []string{"jackr"}))
+		Expect(attributes).To(HaveKeyWithValue("sn", []string{"jackr"}))
+		Expect(attributes).To(HaveKeyWithValue("userPassword", []string{"pw"}))
+		Expect(attributes).To(HaveKeyWithValue("employeeType", []string{"admin,dev"}))
+
+		found, err := svc.GetUser(ctx, created.ID)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(found).To(Equal(created))
+
+		found, err = svc.FindUserByName(ctx, "jackr")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(found.ID).To(Equal(created.ID))
+
+		found, err = svc.FindUserByName(ctx, "nobody")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(found).To(BeNil())
+	})
+
+	It("renames the entries of renamed users and patches single fields", func() {
+		created, err := svc.CreateUser(ctx, &v1.UserSpec{Name: "jackr", Firstname: "Jack", Email: "jackr@example.com"})
+		Expect(err).NotTo(HaveOccurred())
+
+		updated, err := svc.UpdateUser(ctx, created.ID, &v1.UserSpec{Name: "jack.r", Firstname: "Jack", Lastname: "Reacher", Email: "jackr@example.com"})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(updated.ID).To(Equal(created.ID))
+		Expect(updated.Name).To(Equal("jack.r"))
+		Expect(updated.Lastname).To(Equal("Reacher"))
+		Expect(dir.attributes("uid=jackr," + usersDN)).To(BeNil())
+		Expect(dir.attributes("uid=jack.r," + usersDN)).To(HaveKeyWithValue("cn", []string{"jack.r"}))
+
+		patched, err := svc.PatchUser(ctx, created.ID, &v1.UserSpec{Name: "jack.r", Firstname: "John", Email: "jack@example.com"}, []string{"email"})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(patched.Email).To(Equal("jack@example.com"))
+		Expect(patched.Firstname).To(Equal("Jack"), "fields not patched are kept")
+		Expect(patched.Lastname).To(Equal("Reacher"))
+
+		Expect(svc.DeleteUser(ctx, created.ID)).To(Succeed())
+		_, err = svc.GetUser(ctx, created.ID)
+		Expect(idmsvc.IsNotFound(err)).To(BeTrue())
+		Expect(idmsvc.IsNotFound(svc.DeleteUser(ctx, created.ID))).To(BeTrue())
+	})
+
+	It("keeps custom attributes and clears the ones removed from the spec", func() {
+		created, err := svc.CreateUser(ctx, &v1.UserSpec{Name: "jackr", Attributes: map[string]string{"employeeNumber": "42", "departmentNumber": "7"}})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(created.Attributes).To(Equal(map[string]string{"employeeNumber": "42", "departmentNumber": "7"}))
+
+		patched, err := svc.PatchUser(ctx, created.ID, &v1.UserSpec{Name: "jackr", Attributes: map[string]string{"employeeNumber": "43"}}, []string{"attributes"})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(patched.Attributes).To(Equal(map[string]string{"employeeNumber": "43"}))
+	})
+
+	It("keeps the age in the attribute it is mapped to", func() {
+		svc = newService(idmsvc.WithAttributeMapping(idmsvc.AttributeMapping{"age": "roomNumber"}))
+		created, err := svc.CreateUser(ctx, &v1.UserSpec{Name: "jackr", Age: 30})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(created.Age).To(Equal(30))
+		Expect(created.Attributes).To(BeEmpty())
+		Expect(dir.attributes("uid=jackr," + usersDN)).To(HaveKeyWithValue("roomNumber", []string{"30"}))
+	})
+
+	It("lists the users page by page", func() {
+		for _, name := range []string{"anna", "bert", "carl"} {
+			_, err := svc.CreateUser(ctx, &v1.UserSpec{Name: name})
+			Expect(err).NotTo(HaveOccurred())
+		}
+
+		page, err := svc.ListUsers(ctx, idmsvc.UserFilter{}, idmsvc.PageOptions{Limit: 2})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(page.Users).To(HaveLen(2))
+		Expect(page.Next).NotTo(BeNil())
+
+		page, err = svc.ListUsers(ctx, idmsvc.UserFilter{}, *page.Next)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(page.Users).To(HaveLen(1))
+		Expect(page.Next).To(BeNil())
+
+		var names []string
+		Expect(idmsvc.EachUser(ctx, svc, idmsvc.UserFilter{Name: "bert"}, func(u *idmsvc.IdentityUser) error {
+			names = append(names, u.Name)
+			return nil
+		})).To(Succeed())
+		Expect(names).To(Equal([]string{"bert"}))
+	})
+
+	It("manages groups and reads their members back through memberOf", func() {
+		user, err := svc.CreateUser(ctx, &v1.UserSpec{Name: "jackr"})
+		Expect(err).NotTo(HaveOccurred())
+		group, err := svc.CreateGroup(ctx, &v1.GroupSpec{Name: "devs", Description: "Developers"})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(group.Name).To(Equal("devs"))
+		Expect(group.Description).To(Equal("Developers"))
+		Expect(dir.attributes("cn=devs," + groupsDN)).To(HaveKeyWithValue("member", []string{""}))
+
+		Expect(svc.AddGroupMember(ctx, group.ID, user.ID)).To(Succeed())
+		Expect(svc.AddGroupMember(ctx, group.ID, user.ID)).To(Succeed(), "adding a member twice succeeds")
+		Expect(svc.ListGroupMembers(ctx, group.ID)).To(Equal([]string{user.ID}))
+
+		_, err = svc.UpdateUser(ctx, user.ID, &v1.UserSpec{Name: "jack.r"})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(svc.ListGroupMembers(ctx, group.ID)).To(Equal([]string{user.ID}), "renamed members stay members")
+
+		Expect(svc.RemoveGroupMember(ctx, group.ID, user.ID)).To(Succeed())
+		Expect(svc.RemoveGroupMember(ctx, group.ID, user.ID)).To(Succeed(), "removing a former member succeeds")
+		Expect(svc.ListGroupMembers(ctx, group.ID)).To(BeEmpty())
+
+		parent, err := svc.CreateGroup(ctx, &v1.GroupSpec{Name: "engineering"})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(svc.AddChildGroup(ctx, parent.ID, group.ID)).To(Succeed())
+		Expect(dir.attributes("cn=engineering," + groupsDN)).To(HaveKeyWithValue("member", ContainElement("cn=devs,"+groupsDN)))
+		Expect(svc.RemoveChildGroup(ctx, parent.ID, group.ID)).To(Succeed())
+		Expect(dir.attributes("cn=engineering," + groupsDN)).To(HaveKeyWithValue("member", []string{""}))
+
+		updated, err := svc.UpdateGroup(ctx, group.ID, &v1.GroupSpec{Name: "developers"})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(updated.ID).To(Equal(group.ID))
+		Expect(updated.Name).To(Equal("developers"))
+		Expect(updated.Description).To(BeEmpty())
+
+		Expect(svc.DeleteGroup(ctx, group.ID)).To(Succeed())
+		_, err = svc.GetGroup(ctx, group.ID)
+		Expect(idmsvc.IsNotFound(err)).To(BeTrue())
+	})
+
+	It("binds a new connection once the server closed the previous one", func() {
+		created, err := svc.CreateUser(ctx, &v1.UserSpec{Name: "jackr"})
+		Expect(err).NotTo(HaveOccurred())
+		binds := dir.count(ldapv3.ApplicationBindRequest)
+
+		dir.disconnect()
+		found, err := svc.GetUser(ctx, created.ID)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(found.Name).To(Equal("jackr"))
+		Expect(dir.count(ldapv3.ApplicationBindRequest)).To(Equal(binds + 1))
+	})
+
+	It("does not support roles, API keys and bulk creation", func() {
+		_, err := svc.CreateRole(ctx, &v1.RoleSpec{Name: "admin"})
+		Expect(idmsvc.IsNotSupported(err)).To(BeTrue())
+		Expect(idmsvc.IsNotSupported(svc.AddUserRole(ctx, "id", "", "admin"))).To(BeTrue())
+		_, err = svc.CreateAPIKey(ctx, &v1.ApiKeySpec{Name: "ci"})
+		Expect(idmsvc.IsNotSupported(err)).To(BeTrue())
+		_, err = svc.CreateUsers(ctx, []*v1.UserSpec{{Name: "jackr"}})
+		Expect(idmsvc.IsNotSupported(err)).To(BeTrue())
+		_, err = svc.FindUserByIdempotencyKey(ctx, "key")
+		Expect(idmsvc.IsNotSupported(err)).To(BeTrue())
+	})
+
+	Context("with Active Directory", func() {
+		activeDirectory := idmsvc.WithLDAP(idmsvc.LDAPConfig{UsersDN: usersDN, ActiveDirectory: true})
+
+		It("sets passwords over LDAPS and suspends users in their userAccountControl", func() {
+			tlsConfig, caBundle := selfSignedTLS()
+			dir = newDirectory(bindDN, password, tlsConfig, baseDN, usersDN)
+			DeferCleanup(dir.close)
+			svc = newService(activeDirectory, idmsvc.WithScheme("https"), idmsvc.WithCABundle(caBundle))
+
+			disabled := false
+			created, err := svc.CreateUser(ctx, &v1.UserSpec{Name: "jackr", Password: "pw", Enabled: &disabled})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(created.ID).To(HaveLen(32), "objectGUIDs are given in hex")
+			Expect(created.Name).To(Equal("jackr"))
+			Expect(*created.Enabled).To(BeFalse())
+
+			attributes := dir.attributes("cn=jackr," + usersDN)
+			Expect(attributes).To(HaveKeyWithValue("sAMAccountName", []string{"jackr"}))
+			Expect(attributes).To(HaveKeyWithValue("unicodePwd", []string{"\"\x00p\x00w\x00\"\x00"}))
+			Expect(attributes).To(HaveKeyWithValue("userAccountControl", []string{"514"}))
+			Expect(attributes).NotTo(HaveKey("sn"), "Active Directory requires no surname")
+
+			patched, err := svc.PatchUser(ctx, created.ID, &v1.UserSpec{Name: "jackr"}, []string{"enabled"})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(*patched.Enabled).To(BeTrue())
+			Expect(dir.attributes("cn=jackr," + usersDN)).To(HaveKeyWithValue("userAccountControl", []string{"512"}))
+
+			group, err := svc.CreateGroup(ctx, &v1.GroupSpec{Name: "devs"})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(dir.attributes("cn=devs," + usersDN)).NotTo(HaveKey("member"))
+			Expect(svc.AddGroupMember(ctx, group.ID, created.ID)).To(Succeed())
+			Expect(svc.ListGroupMembers(ctx, group.ID)).To(Equal([]string{created.ID}))
+		})
+
+		It("refuses to send passwords without TLS", func() {
+			svc = newService(activeDirectory)
+			_, err := svc.CreateUser(ctx, &v1.UserSpec{Name: "jackr", Password: "pw"})
+			Expect(err).To(MatchError(ContainSubstring("ldaps")))
+			Expect(dir.count(ldapv3.ApplicationAddRequest)).To(BeZero())
+		})
+	})
+})
+
+// selfSignedTLS returns the TLS configuration of a server with a self-signed certificate
+// for 127.0.0.1 and the certificate as CA bundle
+func selfSignedTLS() (*tls.Config, []byte) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	Expect(err).NotTo(HaveOccurred())
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "127.0.0.1"},
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	Expect(err).NotTo(HaveOccurred())
+
+	tlsConfig := &tls.Config{
+		MinVersion:   tls.VersionTLS12,
+		Certificates: []tls.Certificate{{Certificate: [][]byte{der}, PrivateKey: key}},
+	}
+	return tlsConfig, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+}
@@ -0,0 +1,53 @@
+package ldap
+
+import (
+	"context"
+
+	v1 "github.com/m15ch4/go-identity-operator/api/v1"
+	idmsvc "github.com/m15ch4/go-identity-operator/pkg/identityclient"
+)
+
+// The roles of the user are kept in its employeeType. LDAP has no roles of its own,
+// access is granted with groups.
+
+func (s *Service) CreateRole(ctx context.Context, spec *v1.RoleSpec) (*idmsvc.IdentityRole, error) {
+	return nil, idmsvc.ErrNotSupported
+}
+
+func (s *Service) GetRole(ctx context.Context, roleID string) (*idmsvc.IdentityRole, error) {
+	return nil, idmsvc.ErrNotSupported
+}
+
+func (s *Service) UpdateRole(ctx context.Context, roleID string, spec *v1.RoleSpec) (*idmsvc.IdentityRole, error) {
+	return nil, idmsvc.ErrNotSupported
+}
+
+func (s *Service) DeleteRole(ctx context.Context, roleID string) error {
+	return idmsvc.ErrNotSupported
+}
+
+func (s *Service) ListUserRoles(ctx context.Context, userID, scope string) ([]string, error) {
+	return nil, idmsvc.ErrNotSupported
+}
+
+func (s *Service) AddUserRole(ctx context.Context, userID, scope, roleName string) error {
+	return idmsvc.ErrNotSupported
+}
+
+func (s *Service) RemoveUserRole(ctx context.Context, userID, scope, roleName string) error {
+	return idmsvc.ErrNotSupported
+}
+
+// LDAP authenticates with passwords, API keys are not supported
+
+func (s *Service) CreateAPIKey(ctx context.Context, spec *v1.ApiKeySpec) (*idmsvc.IdentityAPIKey, error) {
+	return nil, idmsvc.ErrNotSupported
+}
+
+func (s *Service) RotateAPIKey(ctx context.Context, keyID string) (*idmsvc.IdentityAPIKey, error) {
+	return nil, idmsvc.ErrNotSupported
+}
+
+func (s *Service) DeleteAPIKey(ctx context.Context, keyID string) error {
+	return idmsvc.ErrNotSupported
+}
@@ -0,0 +1,116 @@
+package ldap
+
+import (
+	"encoding/hex"
+	"errors"
+
+	ldapv3 "github.com/go-ldap/ldap/v3"
+)
+
+// schema names the object classes and attributes of the users and groups of a directory
+type schema struct {
+	// userClasses are the object classes of new users, userFilter selects the users
+	userClasses []string
+	userFilter  string
+	// rdnAttribute names the entries of users, nameAttribute holds their name. Both and
+	// the common name hold the name of the user. Groups are named by their common name.
+	rdnAttribute  string
+	nameAttribute string
+	// groupClasses are the object classes of new groups, groupFilter selects the groups
+	groupClasses []string
+	groupFilter  string
+	// idAttribute holds the ID of entries, which is kept when they are renamed
+	idAttribute string
+	// activeDirectory keeps passwords in unicodePwd and suspends users in their
+	// userAccountControl, its IDs are binary
+	activeDirectory bool
+}
+
+// inetOrgPersonSchema keeps users in inetOrgPerson entries named by their uid and groups in
+// groupOfNames entries, e.g. in OpenLDAP with the memberof overlay
+var inetOrgPersonSchema = &schema{
+	userClasses:   []string{"top", "person", "organizationalPerson", "inetOrgPerson"},
+	userFilter:    equal("objectClass", "inetOrgPerson"),
+	rdnAttribute:  "uid",
+	nameAttribute: "uid",
+	groupClasses:  []string{"top", "groupOfNames"},
+	groupFilter:   equal("objectClass", "groupOfNames"),
+	idAttribute:   "entryUUID",
+}
+
+// activeDirectorySchema keeps users and groups in the user and group entries of Active
+// Directory, named by their common name. Users log in with their sAMAccountName.
+var activeDirectorySchema = &schema{
+	userClasses:     []string{"top", "person", "organizationalPerson", "user"},
+	userFilter:      and(equal("objectClass", "user"), equal("objectCategory", "person")),
+	rdnAttribute:    "cn",
+	nameAttribute:   "sAMAccountName",
+	groupClasses:    []string{"top", "group"},
+	groupFilter:     equal("objectClass", "group"),
+	idAttribute:     "objectGUID",
+	activeDirectory: true,
+}
+
+// id returns the ID of the entry, the binary objectGUIDs of Active Directory in hex
+func (sc *schema) id(e *ldapv3.Entry) string {
+	if sc.activeDirectory {
+		return hex.EncodeToString(e.GetEqualFoldRawAttributeValue(sc.idAttribute))
+	}
+	return e.GetEqualFoldAttributeValue(sc.idAttribute)
+}
+
+// idFilter selects the entry with the ID
+func (sc *schema) idFilter(id string) (string, error) {
+	if !sc.activeDirectory {
+		return equal(sc.idAttribute, id), nil
+	}
+	guid, err := hex.DecodeString(id)
+	if err != nil || len(guid) != 16 {
+		return "", ldapv3.NewError(ldapv3.LDAPResultNoSuchObject, errors.New("invalid objectGUID "+id))
+	}
+	return equal(sc.idAttribute, string(guid)), nil
+}
+
+// usersDN returns the DN of the entry users are created and searched below
+func (s *Service) usersDN() string {
+	return s.config.LDAP().UsersDN
+}
+
+// groupsDN returns the DN of the entry groups are created and searched below, the users
+// DN unless configured otherwise
+func (s *Service) groupsDN() string {
+	if dn := s.config.LDAP().GroupsDN; dn != "" {
+		return dn
+	}
+	return s.usersDN()
+}
+
+// findEntry returns the entry with the ID among the entries of the filter below the base DN
+func (s *Service) findEntry(c *ldapv3.Conn, baseDN, selector, id string, attributes []string) (*ldapv3.Entry, error) {
+	idFilter, err := s.schema.idFilter(id)
+	if err != nil {
+		return nil, err
+	}
+	result, err := c.Search(ldapv3.NewSearchRequest(baseDN, ldapv3.ScopeWholeSubtree, ldapv3.NeverDerefAliases,
+		0, 0, false, and(selector, idFilter), attributes, nil))
+	if err != nil {
+		return nil, err
+	}
+	if len(result.Entries) == 0 {
+		return nil, ldapv3.NewError(ldapv3.LDAPResultNoSuchObject, errors.New("no entry with "+s.schema.idAttribute+" "+id))
+	}
+	return result.Entries[0], nil
+}
+
+// readEntry returns the entry with the DN, the root DSE for the empty DN
+func readEntry(c *ldapv3.Conn, dn string, attributes []string) (*ldapv3.Entry, error) {
+	result, err := c.Search(ldapv3.NewSearchRequest(dn, ldapv3.ScopeBaseObject, ldapv3.NeverDerefAliases,
+		0, 0, false, present("objectClass"), attributes, nil))
+	if err != nil {
+		return nil, err
+	}
+	if len(result.Entries) == 0 {
+		return nil, ldapv3.NewError(ldapv3.LDAPResultNoSuchObject, errors.New("no entry "+dn))
+	}
+	return result.Entries[0], nil
+}
@@ -0,0 +1,16 @@
+package ldap
+
+import (
+	"testing"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+// The service is tested against an in-memory directory served over a local listener
+
+func TestLDAP(t *testing.T) {
+	RegisterFailHandler(Fail)
+
+	RunSpecs(t, "LDAP Suite")
+}
@@ -0,0 +1,451 @@
+package ldap
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+
+	ldapv3 "github.com/go-ldap/ldap/v3"
+
+	v1 "github.com/m15ch4/go-identity-operator/api/v1"
+	idmsvc "github.com/m15ch4/go-identity-operator/pkg/identityclient"
+)
+
+// listPageSize is the number of users requested per page when listing users
+const listPageSize = 200
+
+// rolesAttribute keeps the roles of the user comma separated
+const rolesAttribute = "employeeType"
+
+// userAccountControl flags of Active Directory users
+const (
+	accountDisabled = 0x2
+	normalAccount   = 0x200
+)
+
+// userFields are the fields of the User spec kept in the entry of the user
+var userFields = []string{"name", "password", "firstname", "lastname", "email", "phone", "displayName", "role", "age", "enabled", "attributes"}
+
+// fieldAttributes are the attributes of the fields of the User spec unless mapped to others
+// with the attribute mapping. There is no standard attribute for the age, it is only kept
+// when mapped to one.
+var fieldAttributes = map[string]string{
+	"firstname":   "givenName",
+	"lastname":    "sn",
+	"email":       "mail",
+	"phone":       "mobile",
+	"displayName": "displayName",
+	"age":         "",
+}
+
+// baseAttributes lists the attributes of users, in lower case, that are not read as custom
+// attributes: the ones of the fields of the User spec, the naming and operational ones and
+// those Active Directory maintains itself
+var baseAttributes = map[string]bool{
+	"objectclass": true, "uid": true, "cn": true, "sn": true, "givenname": true, "mail": true,
+	"mobile": true, "displayname": true, "employeetype": true, "userpassword": true,
+	"entryuuid": true, "memberof": true, "name": true, "distinguishedname": true,
+	"objectguid": true, "objectsid": true, "objectcategory": true, "samaccountname": true,
+	"samaccounttype": true, "userprincipalname": true, "useraccountcontrol": true,
+	"unicodepwd": true, "primarygroupid": true, "instancetype": true, "whencreated": true,
+	"whenchanged": true, "usncreated": true, "usnchanged": true, "pwdlastset": true,
+	"accountexpires": true, "logoncount": true, "badpwdcount": true, "badpasswordtime": true,
+	"lastlogon": true, "lastlogoff": true, "lastlogontimestamp": true, "codepage": true,
+	"countrycode": true, "dscorepropagationdata": true,
+}
+
+// CreateUser adds the entry of the user below the users DN. LDAP has no idempotency keys,
+// a lost response leaves a user that is found by name when adopting existing users.
+func (s *Service) CreateUser(ctx context.Context, spec *v1.UserSpec) (*idmsvc.IdentityUser, error) {
+	if err := s.checkPassword(spec); err != nil {
+		return nil, err
+	}
+
+	dn := childDN(s.schema.rdnAttribute, spec.Name, s.usersDN())
+	req := ldapv3.NewAddRequest(dn, nil)
+	req.Attribute("objectClass", s.schema.userClasses)
+	for _, ch := range s.userChanges(nil, spec, userFields) {
+		req.Attribute(ch.Modification.Type, ch.Modification.Vals)
+	}
+
+	var created *ldapv3.Entry
+	err := s.do(ctx, "ldap_create_user", func(c *ldapv3.Conn) error {
+		if err := c.Add(req); err != nil {
+			return err
+		}
+		var err error
+		created, err = readEntry(c, dn, s.userAttributes())
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return s.identityUser(created), nil
+}
+
+// GetUser reads the user
+func (s *Service) GetUser(ctx context.Context, userID string) (*idmsvc.IdentityUser, error) {
+	var found *ldapv3.Entry
+	err := s.do(ctx, "ldap_get_user", func(c *ldapv3.Conn) error {
+		var err error
+		found, err = s.findEntry(c, s.usersDN(), s.schema.userFilter, userID, s.userAttributes())
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return s.identityUser(found), nil
+}
+
+// FindUserByName looks up the user by exact name and returns nil without error when there is none
+func (s *Service) FindUserByName(ctx context.Context, name string) (*idmsvc.IdentityUser, error) {
+	var found []*ldapv3.Entry
+	err := s.do(ctx, "ldap_find_user", func(c *ldapv3.Conn) error {
+		result, err := c.Search(ldapv3.NewSearchRequest(s.usersDN(), ldapv3.ScopeWholeSubtree, ldapv3.NeverDerefAliases,
+			0, 0, false, and(s.schema.userFilter, equal(s.schema.nameAttribute, name)), s.userAttributes(), nil))
+		if err != nil {
+			return err
+		}
+		found = result.Entries
+		return nil
+	})
+	if err != nil || len(found) == 0 {
+		return nil, err
+	}
+	return s.identityUser(found[0]), nil
+}
+
+// LDAP has no idempotency keys, creations are not deduplicated
+
+func (s *Service) FindUserByIdempotencyKey(ctx context.Context, key string) (*idmsvc.IdentityUser, error) {
+	return nil, idmsvc.ErrNotSupported
+}
+
+// ListUsers returns a page of the users below the users DN, paginated by cursor with the
+// paged results control. The cursor is the cookie of the control, which servers may only
+// accept on the connection that returned it.
+func (s *Service) ListUsers(ctx context.Context, filter idmsvc.UserFilter, pageOpts idmsvc.PageOptions) (*idmsvc.UserPage, error) {
+	limit := pageOpts.Limit
+	if limit <= 0 {
+		limit = listPageSize
+	}
+	cookie, err := base64.StdEncoding.DecodeString(pageOpts.Cursor)
+	if err != nil {
+		return nil, fmt.Errorf("invalid cursor %q", pageOpts.Cursor)
+	}
+	selector := s.schema.userFilter
+	if filter.Name != "" {
+		selector = and(selector, equal(s.schema.nameAttribute, filter.Name))
+	}
+
+	paging := ldapv3.NewControlPaging(uint32(limit))
+	paging.SetCookie(cookie)
+	var found []*ldapv3.Entry
+	err = s.do(ctx, "ldap_list_users", func(c *ldapv3.Conn) error {
+		result, err := c.Search(ldapv3.NewSearchRequest(s.usersDN(), ldapv3.ScopeWholeSubtree, ldapv3.NeverDerefAliases,
+			0, 0, false, selector, s.userAttributes(), []ldapv3.Control{paging}))
+		if err != nil {
+			return err
+		}
+		found, cookie = result.Entries, nil
+		if control, ok := ldapv3.FindControl(result.Controls, ldapv3.ControlTypePaging).(*ldapv3.ControlPaging); ok {
+			cookie = control.Cookie
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	result := &idmsvc.UserPage{}
+	for _, e := range found {
+		result.Users = append(result.Users, *s.identityUser(e))
+	}
+	if len(cookie) > 0 {
+		result.Next = &idmsvc.PageOptions{Limit: limit, Cursor: base64.StdEncoding.EncodeToString(cookie)}
+	}
+	return result, nil
+}
+
+// UpdateUser replaces all fields of the user, renaming its entry if its name changed
+func (s *Service) UpdateUser(ctx context.Context, userID string, spec *v1.UserSpec) (*idmsvc.IdentityUser, error) {
+	return s.modifyUser(ctx, "ldap_update_user", userID, spec, userFields)
+}
+
+// PatchUser replaces only the given fields of the user. Falls back to UpdateUser for
+// instances configured without partial updates.
+func (s *Service) PatchUser(ctx context.Context, userID string, spec *v1.UserSpec, fields []string) (*idmsvc.IdentityUser, error) {
+	if !s.config.PatchUpdates() {
+		return s.UpdateUser(ctx, userID, spec)
+	}
+	return s.modifyUser(ctx, "ldap_patch_user", userID, spec, fields)
+}
+
+// DeleteUser deletes the entry of the user
+func (s *Service) DeleteUser(ctx context.Context, userID string) error {
+	return s.do(ctx, "ldap_delete_user", func(c *ldapv3.Conn) error {
+		found, err := s.findEntry(c, s.usersDN(), s.schema.userFilter, userID, []string{noAttributes})
+		if err != nil {
+			return err
+		}
+		return c.Del(ldapv3.NewDelRequest(found.DN, nil))
+	})
+}
+
+// LDAP has no bulk operations, users are created one by one
+
+func (s *Service) CreateUsers(ctx context.Context, specs []*v1.UserSpec) ([]idmsvc.BulkResult, error) {
+	return nil, idmsvc.ErrNotSupported
+}
+
+// modifyUser renames the entry of the user if the name is among the fields and replaces the
+// attributes of the other fields
+func (s *Service) modifyUser(ctx context.Context, operation, userID string, spec *v1.UserSpec, fields []string) (*idmsvc.IdentityUser, error) {
+	if err := s.checkPassword(spec); err != nil {
+		return nil, err
+	}
+
+	var modified *ldapv3.Entry
+	err := s.do(ctx, operation, func(c *ldapv3.Conn) error {
+		current, err := s.findEntry(c, s.usersDN(), s.schema.userFilter, userID, s.userAttributes())
+		if err != nil {
+			return err
+		}
+
+		dn := current.DN
+		if hasField(fields, "name") && current.GetEqualFoldAttributeValue(s.schema.rdnAttribute) != spec.Name {
+			if dn, err = rename(c, dn, s.schema.rdnAttribute, spec.Name); err != nil {
+				return err
+			}
+		}
+
+		if changes := s.userChanges(current, spec, fields); len(changes) > 0 {
+			req := ldapv3.NewModifyRequest(dn, nil)
+			req.Changes = changes
+			if err := c.Modify(req); err != nil {
+				return err
+			}
+		}
+		modified, err = readEntry(c, dn, s.userAttributes())
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return s.identityUser(modified), nil
+}
+
+// userChanges returns the changes replacing the attributes of the fields with the values of
+// the spec. For a new user, without a current entry, they are the attributes of its entry
+// and unset fields are left out.
+func (s *Service) userChanges(current *ldapv3.Entry, spec *v1.UserSpec, fields []string) []ldapv3.Change {
+	var changes []ldapv3.Change
+	index := map[string]int{}
+	set := func(name string, values ...string) {
+		if name == "" || (current == nil && len(values) == 0) {
+			return
+		}
+		// the relative DN of an existing entry is changed by renaming it
+		if current != nil && strings.EqualFold(name, s.schema.rdnAttribute) {
+			return
+		}
+		ch := ldapv3.Change{Operation: ldapv3.ReplaceAttribute, Modification: ldapv3.PartialAttribute{Type: name, Vals: values}}
+		if i, ok := index[strings.ToLower(name)]; ok {
+			changes[i] = ch
+			return
+		}
+		index[strings.ToLower(name)] = len(changes)
+		changes = append(changes, ch)
+	}
+
+	// inetOrgPerson requires a surname, users without a last name have their name instead
+	surname := s.attribute("lastname") == "sn" && !s.schema.activeDirectory
+	for _, field := range fields {
+		switch field {
+		case "name":
+			set(s.schema.rdnAttribute, spec.Name)
+			set(s.schema.nameAttribute, spec.Name)
+			set("cn", spec.Name)
+			if !s.schema.activeDirectory && (!surname || spec.Lastname == "") {
+				set("sn", spec.Name)
+			}
+		case "lastname":
+			if surname && spec.Lastname == "" {
+				set("sn", spec.Name)
+				continue
+			}
+			set(s.attribute(field), optional(spec.Lastname)...)
+		case "firstname":
+			set(s.attribute(field), optional(spec.Firstname)...)
+		case "email":
+			set(s.attribute(field), optional(spec.Email)...)
+		case "phone":
+			set(s.attribute(field), optional(spec.Phone)...)
+		case "displayName":
+			set(s.attribute(field), optional(spec.DisplayName)...)
+		case "age":
+			if spec.Age != 0 {
+				set(s.attribute(field), strconv.Itoa(spec.Age))
+				continue
+			}
+			set(s.attribute(field))
+		case "role":
+			set(rolesAttribute, optional(idmsvc.JoinRoles(spec))...)
+		case "password":
+			if spec.Password != "" {
+				set(s.passwordAttribute(spec.Password))
+			}
+		case "enabled":
+			if !s.schema.activeDirectory {
+				continue
+			}
+			control := normalAccount
+			if current != nil {
+				control, _ = strconv.Atoi(current.GetEqualFoldAttributeValue("userAccountControl"))
+			}
+			if spec.IsEnabled() {
+				control &^= accountDisabled
+			} else {
+				control |= accountDisabled
+			}
+			set("userAccountControl", strconv.Itoa(control))
+		case "attributes":
+			if spec.Attributes == nil {
+				continue
+			}
+			if current != nil {
+				for _, a := range current.Attributes {
+					if _, ok := spec.Attributes[a.Name]; !ok && !s.isBaseAttribute(a.Name) {
+						set(a.Name)
+					}
+				}
+			}
+			names := make([]string, 0, len(spec.Attributes))
+			for name := range spec.Attributes {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+			for _, name := range names {
+				if !s.isBaseAttribute(name) {
+					set(name, spec.Attributes[name])
+				}
+			}
+		}
+	}
+	return changes
+}
+
+// checkPassword returns an error for passwords Active Directory would reject for the
+// connection, it only accepts them over TLS
+func (s *Service) checkPassword(spec *v1.UserSpec) error {
+	if s.schema.activeDirectory && spec.Password != "" && !s.config.UsesTLS() {
+		return errors.New("active directory only accepts passwords over ldaps, enable the tls of the instance")
+	}
+	return nil
+}
+
+// passwordAttribute returns the attribute setting the password and its value. Active
+// Directory expects the quoted password in UTF-16.
+func (s *Service) passwordAttribute(password string) (string, string) {
+	if !s.schema.activeDirectory {
+		return "userPassword", password
+	}
+	encoded := utf16.Encode([]rune(`"` + password + `"`))
+	value := make([]byte, 2*len(encoded))
+	for i, unit := range encoded {
+		binary.LittleEndian.PutUint16(value[2*i:], unit)
+	}
+	return "unicodePwd", string(value)
+}
+
+// attribute returns the attribute of the field of the User spec, the mapped one or the default
+func (s *Service) attribute(field string) string {
+	return s.config.AttributeMapping().Path(field, fieldAttributes[field])
+}
+
+// isBaseAttribute reports whether the attribute is not a custom attribute of the users
+func (s *Service) isBaseAttribute(name string) bool {
+	if baseAttributes[strings.ToLower(name)] {
+		return true
+	}
+	for field := range fieldAttributes {
+		if strings.EqualFold(name, s.attribute(field)) {
+			return true
+		}
+	}
+	return false
+}
+
+// userAttributes returns the attributes read from the entries of users, the ID is an
+// operational attribute in OpenLDAP that is only returned when requested
+func (s *Service) userAttributes() []string {
+	return []string{allAttributes, s.schema.idAttribute}
+}
+
+// identityUser converts the entry of a user into the user of the identity API
+func (s *Service) identityUser(e *ldapv3.Entry) *idmsvc.IdentityUser {
+	usr := &idmsvc.IdentityUser{
+		ID:          s.schema.id(e),
+		Name:        e.GetEqualFoldAttributeValue(s.schema.nameAttribute),
+		Firstname:   e.GetEqualFoldAttributeValue(s.attribute("firstname")),
+		Lastname:    e.GetEqualFoldAttributeValue(s.attribute("lastname")),
+		Email:       e.GetEqualFoldAttributeValue(s.attribute("email")),
+		Phone:       e.GetEqualFoldAttributeValue(s.attribute("phone")),
+		DisplayName: e.GetEqualFoldAttributeValue(s.attribute("displayName")),
+	}
+	if usr.Lastname == usr.Name && s.attribute("lastname") == "sn" && !s.schema.activeDirectory {
+		// the surname required for users without a last name
+		usr.Lastname = ""
+	}
+	if attribute := s.attribute("age"); attribute != "" {
+		usr.Age, _ = strconv.Atoi(e.GetEqualFoldAttributeValue(attribute))
+	}
+	usr.SetRoles(idmsvc.SplitRoles(e.GetEqualFoldAttributeValue(rolesAttribute)))
+	if s.schema.activeDirectory {
+		control, _ := strconv.Atoi(e.GetEqualFoldAttributeValue("userAccountControl"))
+		enabled := control&accountDisabled == 0
+		usr.Enabled = &enabled
+	}
+	for _, a := range e.Attributes {
+		if len(a.Values) > 0 && !s.isBaseAttribute(a.Name) {
+			if usr.Attributes == nil {
+				usr.Attributes = map[string]string{}
+			}
+			usr.Attributes[a.Name] = a.Values[0]
+		}
+	}
+	return usr
+}
+
+// rename renames the entry to the value of the attribute and returns its new DN
+func rename(c *ldapv3.Conn, dn, attribute, value string) (string, error) {
+	rdn := attribute + "=" + ldapv3.EscapeDN(value)
+	if err := c.ModifyDN(ldapv3.NewModifyDNRequest(dn, rdn, true, "")); err != nil {
+		return "", err
+	}
+	return renamedDN(dn, rdn)
+}
+
+// optional returns the value as the only value of an attribute, or no values for an empty one
+func optional(value string) []string {
+	if value == "" {
+		return nil
+	}
+	return []string{value}
+}
+
+// hasField reports whether the field is among the fields
+func hasField(fields []string, field string) bool {
+	for _, f := range fields {
+		if f == field {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,110 @@
+package okta
+
+import (
+	"context"
+
+	v1 "github.com/m15ch4/go-identity-operator/api/v1"
+	idmsvc "github.com/m15ch4/go-identity-operator/pkg/identityclient"
+)
+
+type groupProfile struct {
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+}
+
+type group struct {
+	ID      string       `json:"id,omitempty"`
+	Profile groupProfile `json:"profile"`
+}
+
+// CreateGroup creates an Okta group
+func (s *Service) CreateGroup(ctx context.Context, spec *v1.GroupSpec) (*idmsvc.IdentityGroup, error) {
+	var created group
+	_, err := s.call(ctx, "okta_create_group", "POST", apiPath("groups"), groupFor(spec), &created)
+	if err != nil {
+		return nil, err
+	}
+	return identityGroup(&created), nil
+}
+
+// GetGroup reads the group by ID
+func (s *Service) GetGroup(ctx context.Context, groupID string) (*idmsvc.IdentityGroup, error) {
+	var found group
+	_, err := s.call(ctx, "okta_get_group", "GET", apiPath("groups", groupID), nil, &found)
+	if err != nil {
+		return nil, err
+	}
+	return identityGroup(&found), nil
+}
+
+// UpdateGroup replaces the profile of the group
+func (s *Service) UpdateGroup(ctx context.Context, groupID string, spec *v1.GroupSpec) (*idmsvc.IdentityGroup, error) {
+	var updated group
+	_, err := s.call(ctx, "okta_update_group", "PUT", apiPath("groups", groupID), groupFor(spec), &updated)
+	if err != nil {
+		return nil, err
+	}
+	return identityGroup(&updated), nil
+}
+
+// DeleteGroup deletes the group by ID
+func (s *Service) DeleteGroup(ctx context.Context, groupID string) error {
+	_, err := s.call(ctx, "okta_delete_group", "DELETE", apiPath("groups", groupID), nil, nil)
+	return err
+}
+
+// ListGroupMembers returns the IDs of the members of the group, following the Link
+// headers of the pages
+func (s *Service) ListGroupMembers(ctx context.Context, groupID string) ([]string, error) {
+	var ids []string
+	path := apiPath("groups", groupID, "users")
+	for path != "" {
+		var page []user
+		header, err := s.call(ctx, "okta_list_group_members", "GET", path, nil, &page)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, member := range page {
+			ids = append(ids, member.ID)
+		}
+		path = s.nextPath(header)
+	}
+	return ids, nil
+}
+
+// AddGroupMember adds the user to the group
+func (s *Service) AddGroupMember(ctx context.Context, groupID, userID string) error {
+	_, err := s.call(ctx, "okta_add_group_member", "PUT", apiPath("groups", groupID, "users", userID), nil, nil)
+	return err
+}
+
+// RemoveGroupMember removes the user from the group
+func (s *Service) RemoveGroupMember(ctx context.Context, groupID, userID string) error {
+	_, err := s.call(ctx, "okta_remove_group_member", "DELETE", apiPath("groups", groupID, "users", userID), nil, nil)
+	return err
+}
+
+// Okta groups cannot be nested
+
+func (s *Service) AddChildGroup(ctx context.Context, parentID, groupID string) error {
+	return idmsvc.ErrNotSupported
+}
+
+func (s *Service) RemoveChildGroup(ctx context.Context, parentID, groupID string) error {
+	return idmsvc.ErrNotSupported
+}
+
+// groupFor converts the Group spec into an Okta group
+func groupFor(spec *v1.GroupSpec) *group {
+	return &group{Profile: groupProfile{Name: spec.Name, Description: spec.Description}}
+}
+
+// identityGroup converts an Okta group into the group of the identity API
+func identityGroup(g *group) *idmsvc.IdentityGroup {
+	return &idmsvc.IdentityGroup{
+		ID:          g.ID,
+		Name:        g.Profile.Name,
+		Description: g.Profile.Description,
+	}
+}
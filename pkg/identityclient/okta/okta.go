@@ -0,0 +1,230 @@
+// Package okta implements the identity API against the management API of Okta. Users,
+// groups, administrator roles and application assignments of the organization are managed.
+package okta
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	neturl "net/url"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	idmsvc "github.com/m15ch4/go-identity-operator/pkg/identityclient"
+)
+
+// Okta reports the rate limit of the endpoint of every response in these headers
+const (
+	rateLimitRemainingHeader = "X-Rate-Limit-Remaining"
+	rateLimitResetHeader     = "X-Rate-Limit-Reset"
+)
+
+// rateLimit is the state of the rate limit of an endpoint reported by the latest response
+type rateLimit struct {
+	remaining int
+	reset     time.Time
+}
+
+// Service manages users, groups and application assignments of an Okta organization
+type Service struct {
+	config *idmsvc.IdentityConfig
+	client *idmsvc.Client
+
+	// mu guards the rate limits by operation
+	mu         sync.Mutex
+	rateLimits map[string]rateLimit
+}
+
+var _ idmsvc.IdentityAPI = &Service{}
+
+func NewService(config *idmsvc.IdentityConfig) *Service {
+	return &Service{
+		config:     config,
+		client:     idmsvc.NewClient(config).WithUserSchema(userSchema),
+		rateLimits: map[string]rateLimit{},
+	}
+}
+
+// GetToken returns the API token of the organization, given as IDM_TOKEN in the
+// credentials Secret. Okta has no login with the credentials of a user.
+func (s *Service) GetToken(ctx context.Context) (string, error) {
+	token := s.config.Token()
+	if token == "" {
+		return "", &idmsvc.CredentialsError{Err: errors.New("okta requires an API token")}
+	}
+	return token, nil
+}
+
+// Info checks the API token by reading its own user. Okta reports no version, users are
+// updated partially by posting the changed profile fields.
+func (s *Service) Info(ctx context.Context) (*idmsvc.BackendInfo, error) {
+	_, err := s.call(ctx, "okta_get_current_user", "GET", apiPath("users", "me"), nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	return &idmsvc.BackendInfo{
+		SupportsPatch:  s.config.PatchUpdates(),
+		SupportsGroups: true,
+	}, nil
+}
+
+// SetCredentials is a no-op, the API token of Okta services comes from their IdentityInstance
+func (s *Service) SetCredentials(user, pass string) {}
+
+// apiPath returns the path of the management API joined with the escaped elements
+func apiPath(elem ...string) string {
+	for i := range elem {
+		elem[i] = neturl.PathEscape(elem[i])
+	}
+	return "/api/v1/" + path.Join(elem...)
+}
+
+// call makes an authenticated management API request. The in value, if not nil, is sent
+// as JSON request body and the JSON response body is decoded into out, if not nil. The
+// headers of the response are returned for pagination. Requests to an endpoint whose
+// rate limit is exhausted fail with a 429 APIError asking to retry once it is reset.
+func (s *Service) call(ctx context.Context, operation, method, path string, in, out interface{}) (http.Header, error) {
+	if err := s.checkRateLimit(operation); err != nil {
+		return nil, err
+	}
+
+	// prepare request body
+	var reqBody io.Reader
+	if in != nil {
+		body, err := json.Marshal(in)
+		if err != nil {
+			return nil, err
+		}
+		reqBody = bytes.NewBuffer(body)
+	}
+
+	// prepare request
+	req, err := http.NewRequestWithContext(ctx, method, s.client.BaseURL()+path, reqBody)
+	if err != nil {
+		return nil, err
+	}
+
+	// set authorization header with the API token
+	token, err := s.GetToken(ctx)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "SSWS "+token)
+
+	// set content type and accept headers
+	if in != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	req.Header.Set("Accept", "application/json")
+
+	// make REST API call
+	resp, err := s.client.Do(operation, req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	reset := s.recordRateLimit(operation, resp.Header)
+
+	// read response body
+	body, err := idmsvc.ReadBody(resp)
+	if err != nil {
+		return nil, err
+	}
+
+	// check response status code
+	err = idmsvc.CheckResponse(resp, body)
+	if err != nil {
+		var apiErr *idmsvc.APIError
+		if errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusTooManyRequests && apiErr.RetryAfter == 0 {
+			apiErr.RetryAfter = time.Until(reset)
+		}
+		if resp.StatusCode == http.StatusUnauthorized {
+			// the API token is static, it was revoked or expired
+			return nil, &idmsvc.CredentialsError{Err: err}
+		}
+		return nil, err
+	}
+
+	// unmarshal response body
+	if out != nil && len(body) > 0 {
+		err = idmsvc.DecodeJSON(resp, body, out)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return resp.Header, nil
+}
+
+// checkRateLimit returns a 429 APIError while the rate limit of the operation is exhausted
+func (s *Service) checkRateLimit(operation string) error {
+	s.mu.Lock()
+	limit, ok := s.rateLimits[operation]
+	s.mu.Unlock()
+
+	if !ok || limit.remaining > 0 {
+		return nil
+	}
+	if wait := time.Until(limit.reset); wait > 0 {
+		return &idmsvc.APIError{
+			StatusCode: http.StatusTooManyRequests,
+			Body:       fmt.Sprintf("rate limit of %s exhausted until %s", operation, limit.reset.UTC().Format(time.RFC3339)),
+			Retryable:  true,
+			RetryAfter: wait,
+		}
+	}
+	return nil
+}
+
+// recordRateLimit keeps the rate limit reported in the headers of a response to the
+// operation and returns the time it is reset
+func (s *Service) recordRateLimit(operation string, header http.Header) time.Time {
+	remaining, err := strconv.Atoi(header.Get(rateLimitRemainingHeader))
+	if err != nil {
+		return time.Time{}
+	}
+	seconds, err := strconv.ParseInt(header.Get(rateLimitResetHeader), 10, 64)
+	if err != nil {
+		return time.Time{}
+	}
+	reset := time.Unix(seconds, 0)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.rateLimits[operation] = rateLimit{remaining: remaining, reset: reset}
+	return reset
+}
+
+// nextPath returns the path of the next page given by the Link header of a list response,
+// or an empty string on the last page
+func (s *Service) nextPath(header http.Header) string {
+	for _, link := range header.Values("Link") {
+		for _, value := range strings.Split(link, ",") {
+			parts := strings.Split(value, ";")
+			if len(parts) < 2 || strings.TrimSpace(parts[1]) != `rel="next"` {
+				continue
+			}
+			next, err := neturl.Parse(strings.Trim(strings.TrimSpace(parts[0]), "<>"))
+			if err != nil {
+				return ""
+			}
+			return strings.TrimPrefix(next.RequestURI(), strings.TrimSuffix(s.basePath(), "/"))
+		}
+	}
+	return ""
+}
+
+// basePath returns the path prefix of the base URL, which the Link headers include
+func (s *Service) basePath() string {
+	base, err := neturl.Parse(s.client.BaseURL())
+	if err != nil {
+		return ""
+	}
+	return base.Path
+}
@@ -0,0 +1,205 @@
+package okta
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	v1 "github.com/m15ch4/go-identity-operator/api/v1"
+	idmsvc "github.com/m15ch4/go-identity-operator/pkg/identityclient"
+)
+
+var _ = Describe("Service", func() {
+	ctx := context.Background()
+
+	var (
+		okta *org
+		svc  *Service
+	)
+
+	// newService returns a service of the organization, with the options of the spec
+	newService := func(opts ...idmsvc.ConfigOpts) *Service {
+		u, err := url.Parse(okta.URL)
+		Expect(err).NotTo(HaveOccurred())
+		port, err := strconv.Atoi(u.Port())
+		Expect(err).NotTo(HaveOccurred())
+		cfg := idmsvc.NewIdentityConfig(append([]idmsvc.ConfigOpts{
+			idmsvc.WithScheme(u.Scheme),
+			idmsvc.WithHost(u.Hostname()),
+			idmsvc.WithPort(port),
+			idmsvc.WithToken(apiToken),
+			idmsvc.WithRetry(1, 0, 0),
+		}, opts...)...)
+		return NewService(&cfg)
+	}
+
+	BeforeEach(func() {
+		okta = newOrg()
+		DeferCleanup(okta.Close)
+		svc = newService()
+	})
+
+	It("authenticates with the API token and reports a missing or revoked one", func() {
+		info, err := svc.Info(ctx)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(info.SupportsGroups).To(BeTrue())
+
+		_, err = newService(idmsvc.WithToken("")).GetToken(ctx)
+		Expect(idmsvc.IsCredentialsInvalid(err)).To(BeTrue())
+		_, err = newService(idmsvc.WithToken("revoked")).GetUser(ctx, "00u1")
+		Expect(idmsvc.IsCredentialsInvalid(err)).To(BeTrue())
+	})
+
+	It("creates activated users with their password and suspends disabled ones", func() {
+		created, err := svc.CreateUser(ctx, &v1.UserSpec{
+			Name: "jackr@example.com", Password: "pw", Firstname: "Jack", Age: 40,
+			Role: "admin", Roles: []string{"dev"}, Attributes: map[string]string{"costCenter": "ignored", "team": "ops"},
+		})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(*created.Enabled).To(BeTrue())
+		Expect(created.Firstname).To(Equal("Jack"))
+		Expect(created.Age).To(Equal(40))
+		Expect(created.AllRoles()).To(ConsistOf("admin", "dev"))
+		Expect(created.Attributes).To(Equal(map[string]string{"team": "ops"}), "base profile attributes are not custom attributes")
+		Expect(okta.users[created.ID].Credentials.Password.Value).To(Equal("pw"))
+
+		disabled := false
+		suspended, err := svc.CreateUser(ctx, &v1.UserSpec{Name: "janed@example.com", Enabled: &disabled})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(*suspended.Enabled).To(BeFalse())
+		Expect(okta.requests).To(ContainElement("POST /api/v1/users/" + suspended.ID + "/lifecycle/suspend"))
+
+		found, err := svc.FindUserByName(ctx, "jackr@example.com")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(found.ID).To(Equal(created.ID))
+		found, err = svc.FindUserByName(ctx, "nobody@example.com")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(found).To(BeNil())
+	})
+
+	It("lists the users page by page following the Link headers", func() {
+		for _, name := range []string{"a", "b", "c"} {
+			_, err := svc.CreateUser(ctx, &v1.UserSpec{Name: name})
+			Expect(err).NotTo(HaveOccurred())
+		}
+
+		page, err := svc.ListUsers(ctx, idmsvc.UserFilter{}, idmsvc.PageOptions{Limit: 2})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(page.Users).To(HaveLen(2))
+		Expect(page.Next).NotTo(BeNil())
+
+		page, err = svc.ListUsers(ctx, idmsvc.UserFilter{}, *page.Next)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(page.Users).To(HaveLen(1))
+		Expect(page.Users[0].Name).To(Equal("c"))
+		Expect(page.Next).To(BeNil())
+
+		_, err = svc.ListUsers(ctx, idmsvc.UserFilter{}, idmsvc.PageOptions{Cursor: "/api/v1/groups"})
+		Expect(err).To(MatchError(ContainSubstring("invalid cursor")))
+	})
+
+	It("replaces the profile on update and unsuspends enabled users", func() {
+		disabled := false
+		created, err := svc.CreateUser(ctx, &v1.UserSpec{Name: "jackr", Firstname: "Jack", Enabled: &disabled})
+		Expect(err).NotTo(HaveOccurred())
+
+		updated, err := svc.UpdateUser(ctx, created.ID, &v1.UserSpec{Name: "jackr", Lastname: "Reacher", Password: "new"})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(updated.Firstname).To(BeEmpty())
+		Expect(updated.Lastname).To(Equal("Reacher"))
+		Expect(*updated.Enabled).To(BeTrue())
+		Expect(okta.users[created.ID].Credentials.Password.Value).To(Equal("new"))
+	})
+
+	It("posts only the changed profile attributes and clears the unset ones", func() {
+		created, err := svc.CreateUser(ctx, &v1.UserSpec{
+			Name: "jackr", Firstname: "Jack", Lastname: "Reacher", Phone: "555-0100",
+			Attributes: map[string]string{"team": "ops"},
+		})
+		Expect(err).NotTo(HaveOccurred())
+
+		patched, err := svc.PatchUser(ctx, created.ID, &v1.UserSpec{
+			Name: "jackr", Firstname: "Jacques", Attributes: map[string]string{"site": "berlin"},
+		}, []string{"firstname", "phone", "attributes"})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(patched.Firstname).To(Equal("Jacques"))
+		Expect(patched.Lastname).To(Equal("Reacher"), "attributes that did not change are kept")
+		Expect(patched.Phone).To(BeEmpty())
+		Expect(patched.Attributes).To(Equal(map[string]string{"site": "berlin"}))
+		Expect(okta.requests).To(ContainElement("POST /api/v1/users/" + created.ID))
+		Expect(okta.requests).NotTo(ContainElement("PUT /api/v1/users/" + created.ID))
+	})
+
+	It("deactivates users before deleting them", func() {
+		created, err := svc.CreateUser(ctx, &v1.UserSpec{Name: "jackr"})
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(svc.DeleteUser(ctx, created.ID)).To(Succeed())
+		Expect(okta.requests).To(ContainElement("POST /api/v1/users/" + created.ID + "/lifecycle/deactivate"))
+		_, err = svc.GetUser(ctx, created.ID)
+		Expect(idmsvc.IsNotFound(err)).To(BeTrue())
+	})
+
+	It("creates, updates and deletes groups and manages their members", func() {
+		created, err := svc.CreateGroup(ctx, &v1.GroupSpec{Name: "devs", Description: "Developers"})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(created.Description).To(Equal("Developers"))
+
+		updated, err := svc.UpdateGroup(ctx, created.ID, &v1.GroupSpec{Name: "developers"})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(updated.Name).To(Equal("developers"))
+
+		Expect(svc.AddGroupMember(ctx, created.ID, "00u7")).To(Succeed())
+		members, err := svc.ListGroupMembers(ctx, created.ID)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(members).To(ConsistOf("00u7"))
+		Expect(svc.RemoveGroupMember(ctx, created.ID, "00u7")).To(Succeed())
+		members, err = svc.ListGroupMembers(ctx, created.ID)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(members).To(BeEmpty())
+
+		Expect(svc.DeleteGroup(ctx, created.ID)).To(Succeed())
+		_, err = svc.GetGroup(ctx, created.ID)
+		Expect(idmsvc.IsNotFound(err)).To(BeTrue())
+	})
+
+	It("holds back the requests of an endpoint whose rate limit is exhausted until it is reset", func() {
+		created, err := svc.CreateUser(ctx, &v1.UserSpec{Name: "jackr"})
+		Expect(err).NotTo(HaveOccurred())
+
+		okta.remaining, okta.reset = 0, time.Now().Add(time.Minute)
+		_, err = svc.GetUser(ctx, created.ID)
+		Expect(err).NotTo(HaveOccurred())
+		requests := len(okta.requests)
+
+		_, err = svc.GetUser(ctx, created.ID)
+		Expect(idmsvc.IsStatus(err, http.StatusTooManyRequests)).To(BeTrue())
+		Expect(idmsvc.IsRetryable(err)).To(BeTrue())
+		Expect(idmsvc.RetryAfter(err)).To(BeNumerically("~", time.Minute, 2*time.Second))
+		Expect(okta.requests).To(HaveLen(requests), "no request is sent while the rate limit is exhausted")
+
+		// the rate limits are kept per endpoint
+		_, err = svc.FindUserByName(ctx, "jackr")
+		Expect(err).NotTo(HaveOccurred())
+
+		// the endpoint is requested again once the rate limit is reset
+		okta.remaining, okta.reset = 10, time.Now().Add(time.Minute)
+		svc.rateLimits["okta_get_user"] = rateLimit{remaining: 0, reset: time.Now().Add(-time.Second)}
+		_, err = svc.GetUser(ctx, created.ID)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(svc.rateLimits["okta_get_user"].remaining).To(Equal(10))
+	})
+
+	It("asks to retry a throttled request once the rate limit is reset", func() {
+		okta.remaining, okta.reset = 0, time.Now().Add(30*time.Second)
+		okta.tooManyRequests = true
+		_, err := svc.GetUser(ctx, "00u1")
+		Expect(idmsvc.IsStatus(err, http.StatusTooManyRequests)).To(BeTrue())
+		Expect(idmsvc.RetryAfter(err)).To(BeNumerically("~", 30*time.Second, 2*time.Second))
+	})
+})
@@ -0,0 +1,245 @@
+package okta
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+// org is an in-memory Okta organization. Like Okta, it merges the profile posted to a user,
+// pages lists with Link headers and reports the rate limit of every response.
+type org struct {
+	*httptest.Server
+
+	mu     sync.Mutex
+	users  map[string]*user
+	groups map[string]*group
+	// members are the IDs of the members of the groups by ID
+	members map[string][]string
+	nextID  int
+	// remaining is the rate limit left of the next response, unlimited if negative, and
+	// tooManyRequests answers the next request with 429
+	remaining       int
+	reset           time.Time
+	tooManyRequests bool
+	// requests records the method and path of each request
+	requests []string
+}
+
+const apiToken = "00token"
+
+func newOrg() *org {
+	o := &org{
+		users:     map[string]*user{},
+		groups:    map[string]*group{},
+		members:   map[string][]string{},
+		remaining: -1,
+	}
+	o.Server = httptest.NewServer(http.HandlerFunc(o.serve))
+	return o
+}
+
+func (o *org) serve(w http.ResponseWriter, req *http.Request) {
+	defer GinkgoRecover()
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	o.requests = append(o.requests, req.Method+" "+req.URL.Path)
+	if o.remaining >= 0 {
+		w.Header().Set(rateLimitRemainingHeader, strconv.Itoa(o.remaining))
+		w.Header().Set(rateLimitResetHeader, strconv.FormatInt(o.reset.Unix(), 10))
+		o.remaining = -1
+	}
+	if o.tooManyRequests {
+		o.tooManyRequests = false
+		o.reply(w, http.StatusTooManyRequests, map[string]interface{}{"errorCode": "E0000047"})
+		return
+	}
+	if req.Header.Get("Authorization") != "SSWS "+apiToken {
+		o.reply(w, http.StatusUnauthorized, map[string]interface{}{"errorCode": "E0000011"})
+		return
+	}
+
+	elem := strings.Split(strings.TrimPrefix(req.URL.Path, "/api/v1/"), "/")
+	switch {
+	case elem[0] == "users" && len(elem) == 1 && req.Method == "POST":
+		var created user
+		Expect(json.NewDecoder(req.Body).Decode(&created)).To(Succeed())
+		Expect(req.URL.Query().Get("activate")).To(Equal("true"))
+		o.nextID++
+		created.ID = "00u" + strconv.Itoa(o.nextID)
+		created.Status = "ACTIVE"
+		o.users[created.ID] = &created
+		o.reply(w, http.StatusOK, &created)
+	case elem[0] == "users" && len(elem) == 1:
+		o.listUsers(w, req)
+	case elem[0] == "users" && len(elem) == 4 && elem[2] == "lifecycle":
+		o.lifecycle(w, elem[1], elem[3])
+	case elem[0] == "users" && len(elem) == 2 && elem[1] == "me":
+		o.reply(w, http.StatusOK, &user{ID: "00u0", Status: "ACTIVE", Profile: map[string]interface{}{"login": "operator"}})
+	case elem[0] == "users" && len(elem) == 2:
+		o.serveUser(w, req, elem[1])
+	case elem[0] == "groups" && len(elem) == 1:
+		var created group
+		Expect(json.NewDecoder(req.Body).Decode(&created)).To(Succeed())
+		o.nextID++
+		created.ID = "00g" + strconv.Itoa(o.nextID)
+		o.groups[created.ID] = &created
+		o.reply(w, http.StatusOK, &created)
+	case elem[0] == "groups" && len(elem) == 2:
+		o.serveGroup(w, req, elem[1])
+	case elem[0] == "groups" && len(elem) == 3:
+		var members []user
+		for _, id := range o.members[elem[1]] {
+			members = append(members, user{ID: id})
+		}
+		o.reply(w, http.StatusOK, members)
+	case elem[0] == "groups" && len(elem) == 4:
+		if req.Method == "PUT" {
+			o.members[elem[1]] = append(o.members[elem[1]], elem[3])
+		} else {
+			o.members[elem[1]] = remove(o.members[elem[1]], elem[3])
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		o.notFound(w)
+	}
+}
+
+// listUsers answers a page of the users ordered by ID, searched by exact login, with the
+// link to the next page
+func (o *org) listUsers(w http.ResponseWriter, req *http.Request) {
+	query := req.URL.Query()
+	login := strings.Trim(strings.TrimPrefix(query.Get("search"), "profile.login eq "), `"`)
+
+	var ids []string
+	for id, u := range o.users {
+		if login == "" || u.Profile["login"] == login {
+			ids = append(ids, id)
+		}
+	}
+	sort.Strings(ids)
+
+	limit, _ := strconv.Atoi(query.Get("limit"))
+	if after := query.Get("after"); after != "" {
+		for len(ids) > 0 && ids[0] <= after {
+			ids = ids[1:]
+		}
+	}
+	if limit > 0 && len(ids) > limit {
+		ids = ids[:limit]
+		next := *req.URL
+		q := next.Query()
+		q.Set("after", ids[len(ids)-1])
+		next.RawQuery = q.Encode()
+		w.Header().Add("Link", `<`+o.URL+req.URL.RequestURI()+`>; rel="self"`)
+		w.Header().Add("Link", `<`+o.URL+next.RequestURI()+`>; rel="next"`)
+	}
+
+	users := []*user{}
+	for _, id := range ids {
+		users = append(users, o.users[id])
+	}
+	o.reply(w, http.StatusOK, users)
+}
+
+// serveUser reads, replaces, merges the profile of or deletes the user. Only deactivated
+// users can be deleted.
+func (o *org) serveUser(w http.ResponseWriter, req *http.Request, id string) {
+	u, found := o.users[id]
+	if !found {
+		o.notFound(w)
+		return
+	}
+	switch req.Method {
+	case "GET":
+		o.reply(w, http.StatusOK, u)
+	case "PUT", "POST":
+		var update user
+		Expect(json.NewDecoder(req.Body).Decode(&update)).To(Succeed())
+		if req.Method == "PUT" {
+			u.Profile = map[string]interface{}{}
+		}
+		for attribute, value := range update.Profile {
+			if value == nil {
+				delete(u.Profile, attribute)
+			} else {
+				u.Profile[attribute] = value
+			}
+		}
+		if update.Credentials != nil {
+			u.Credentials = update.Credentials
+		}
+		o.reply(w, http.StatusOK, u)
+	case "DELETE":
+		Expect(u.Status).To(Equal(statusDeprovisioned), "only deactivated users are deleted")
+		delete(o.users, id)
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// lifecycle applies the lifecycle operation to the user
+func (o *org) lifecycle(w http.ResponseWriter, id, operation string) {
+	u, found := o.users[id]
+	if !found {
+		o.notFound(w)
+		return
+	}
+	switch operation {
+	case "suspend":
+		u.Status = statusSuspended
+	case "unsuspend":
+		u.Status = "ACTIVE"
+	case "deactivate":
+		u.Status = statusDeprovisioned
+	}
+	o.reply(w, http.StatusOK, map[string]interface{}{})
+}
+
+// serveGroup reads, replaces or deletes the group
+func (o *org) serveGroup(w http.ResponseWriter, req *http.Request, id string) {
+	g, found := o.groups[id]
+	if !found {
+		o.notFound(w)
+		return
+	}
+	switch req.Method {
+	case "GET":
+		o.reply(w, http.StatusOK, g)
+	case "PUT":
+		Expect(json.NewDecoder(req.Body).Decode(g)).To(Succeed())
+		g.ID = id
+		o.reply(w, http.StatusOK, g)
+	case "DELETE":
+		delete(o.groups, id)
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func (o *org) notFound(w http.ResponseWriter) {
+	o.reply(w, http.StatusNotFound, map[string]interface{}{"errorCode": "E0000007"})
+}
+
+func (o *org) reply(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	Expect(json.NewEncoder(w).Encode(body)).To(Succeed())
+}
+
+func remove(values []string, value string) []string {
+	var kept []string
+	for _, v := range values {
+		if v != value {
+			kept = append(kept, v)
+		}
+	}
+	return kept
+}
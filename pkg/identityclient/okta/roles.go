@@ -0,0 +1,139 @@
+package okta
+
+import (
+	"context"
+	"net/http"
+
+	v1 "github.com/m15ch4/go-identity-operator/api/v1"
+	idmsvc "github.com/m15ch4/go-identity-operator/pkg/identityclient"
+)
+
+// ScopeApps is the scope of role assignments that assign the user to the application
+// with the ID given as role name, instead of an administrator role
+const ScopeApps = "apps"
+
+type roleAssignment struct {
+	ID   string `json:"id,omitempty"`
+	Type string `json:"type"`
+}
+
+type appAssignment struct {
+	ID    string `json:"id"`
+	Scope string `json:"scope,omitempty"`
+}
+
+// The roles of the user are kept in its userType. Administrator roles of Okta are
+// predefined, managed Roles are not supported.
+
+func (s *Service) CreateRole(ctx context.Context, spec *v1.RoleSpec) (*idmsvc.IdentityRole, error) {
+	return nil, idmsvc.ErrNotSupported
+}
+
+func (s *Service) GetRole(ctx context.Context, roleID string) (*idmsvc.IdentityRole, error) {
+	return nil, idmsvc.ErrNotSupported
+}
+
+func (s *Service) UpdateRole(ctx context.Context, roleID string, spec *v1.RoleSpec) (*idmsvc.IdentityRole, error) {
+	return nil, idmsvc.ErrNotSupported
+}
+
+func (s *Service) DeleteRole(ctx context.Context, roleID string) error {
+	return idmsvc.ErrNotSupported
+}
+
+// ListUserRoles returns the types of the administrator roles of the user, or the IDs of
+// the applications it is assigned to for the apps scope
+func (s *Service) ListUserRoles(ctx context.Context, userID, scope string) ([]string, error) {
+	if scope == ScopeApps {
+		var links []struct {
+			AppInstanceID string `json:"appInstanceId"`
+		}
+		_, err := s.call(ctx, "okta_list_user_apps", "GET", apiPath("users", userID, "appLinks"), nil, &links)
+		if err != nil {
+			return nil, err
+		}
+		var names []string
+		seen := map[string]bool{}
+		for _, link := range links {
+			if !seen[link.AppInstanceID] {
+				seen[link.AppInstanceID] = true
+				names = append(names, link.AppInstanceID)
+			}
+		}
+		return names, nil
+	}
+	if scope != "" {
+		return nil, idmsvc.ErrNotSupported
+	}
+
+	roles, err := s.userRoles(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, 0, len(roles))
+	for _, role := range roles {
+		names = append(names, role.Type)
+	}
+	return names, nil
+}
+
+// AddUserRole assigns the administrator role of the given type to the user, or assigns
+// the user to the application with the given ID for the apps scope
+func (s *Service) AddUserRole(ctx context.Context, userID, scope, roleName string) error {
+	switch scope {
+	case ScopeApps:
+		_, err := s.call(ctx, "okta_assign_app", "POST", apiPath("apps", roleName, "users"), &appAssignment{ID: userID, Scope: "USER"}, nil)
+		return err
+	case "":
+		_, err := s.call(ctx, "okta_assign_role", "POST", apiPath("users", userID, "roles"), &roleAssignment{Type: roleName}, nil)
+		return err
+	}
+	return idmsvc.ErrNotSupported
+}
+
+// RemoveUserRole unassigns the administrator role of the given type from the user, or
+// unassigns the user from the application with the given ID for the apps scope
+func (s *Service) RemoveUserRole(ctx context.Context, userID, scope, roleName string) error {
+	switch scope {
+	case ScopeApps:
+		_, err := s.call(ctx, "okta_unassign_app", "DELETE", apiPath("apps", roleName, "users", userID), nil, nil)
+		return err
+	case "":
+		roles, err := s.userRoles(ctx, userID)
+		if err != nil {
+			return err
+		}
+		for _, role := range roles {
+			if role.Type == roleName {
+				_, err = s.call(ctx, "okta_unassign_role", "DELETE", apiPath("users", userID, "roles", role.ID), nil, nil)
+				return err
+			}
+		}
+		return &idmsvc.APIError{StatusCode: http.StatusNotFound, Body: "role " + roleName + " not assigned"}
+	}
+	return idmsvc.ErrNotSupported
+}
+
+// userRoles returns the administrator role assignments of the user
+func (s *Service) userRoles(ctx context.Context, userID string) ([]roleAssignment, error) {
+	var roles []roleAssignment
+	_, err := s.call(ctx, "okta_list_user_roles", "GET", apiPath("users", userID, "roles"), nil, &roles)
+	if err != nil {
+		return nil, err
+	}
+	return roles, nil
+}
+
+// Okta API tokens are created in the admin console, API keys are not supported
+
+func (s *Service) CreateAPIKey(ctx context.Context, spec *v1.ApiKeySpec) (*idmsvc.IdentityAPIKey, error) {
+	return nil, idmsvc.ErrNotSupported
+}
+
+func (s *Service) RotateAPIKey(ctx context.Context, keyID string) (*idmsvc.IdentityAPIKey, error) {
+	return nil, idmsvc.ErrNotSupported
+}
+
+func (s *Service) DeleteAPIKey(ctx context.Context, keyID string) error {
+	return idmsvc.ErrNotSupported
+}
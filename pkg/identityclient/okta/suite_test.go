@@ -0,0 +1,16 @@
+package okta
+
+import (
+	"testing"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+// The service is tested against an in-memory management API served with httptest
+
+func TestOkta(t *testing.T) {
+	RegisterFailHandler(Fail)
+
+	RunSpecs(t, "Okta Suite")
+}
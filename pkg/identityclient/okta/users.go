@@ -0,0 +1,347 @@
+package okta
+
+import (
+	"context"
+	"fmt"
+	neturl "net/url"
+	"strconv"
+	"strings"
+
+	v1 "github.com/m15ch4/go-identity-operator/api/v1"
+	idmsvc "github.com/m15ch4/go-identity-operator/pkg/identityclient"
+)
+
+// listPageSize is the number of users requested per page when listing users
+const listPageSize = 200
+
+// Statuses of Okta users that cannot log in
+const (
+	statusSuspended     = "SUSPENDED"
+	statusDeprovisioned = "DEPROVISIONED"
+)
+
+// profileFields maps the fields of the User spec to the attributes of the Okta user
+// profile. The roles are kept comma separated in the userType, age is a custom attribute that has to be
+// added to the profile schema like the custom attributes of the spec.
+var profileFields = map[string]string{
+	"name":        "login",
+	"firstname":   "firstName",
+	"lastname":    "lastName",
+	"email":       "email",
+	"phone":       "mobilePhone",
+	"displayName": "displayName",
+	"role":        "userType",
+	"age":         "age",
+}
+
+// userSchema describes the users of Okta for the attribute mapping
+var userSchema = &idmsvc.UserSchema{
+	Paths: map[string]string{
+		"firstname": "profile.firstName", "lastname": "profile.lastName", "email": "profile.email",
+		"phone": "profile.mobilePhone", "displayName": "profile.displayName", "age": "profile.age",
+	},
+	Operations: map[string]bool{
+		"okta_create_user": true, "okta_get_user": true, "okta_find_user": true,
+		"okta_list_users": true, "okta_update_user": true, "okta_patch_user": true,
+	},
+}
+
+// baseProfile lists the attributes of the base Okta user profile, which are not read as
+// custom attributes
+var baseProfile = map[string]bool{
+	"login": true, "email": true, "secondEmail": true, "firstName": true, "lastName": true,
+	"middleName": true, "honorificPrefix": true, "honorificSuffix": true, "title": true,
+	"displayName": true, "nickName": true, "profileUrl": true, "primaryPhone": true,
+	"mobilePhone": true, "streetAddress": true, "city": true, "state": true, "zipCode": true,
+	"postalAddress": true, "countryCode": true, "preferredLanguage": true, "locale": true,
+	"timezone": true, "userType": true, "employeeNumber": true, "costCenter": true,
+	"organization": true, "division": true, "department": true, "managerId": true,
+	"manager": true, "age": true,
+}
+
+type password struct {
+	Value string `json:"value"`
+}
+
+type credentials struct {
+	Password *password `json:"password,omitempty"`
+}
+
+type user struct {
+	ID          string                 `json:"id,omitempty"`
+	Status      string                 `json:"status,omitempty"`
+	Profile     map[string]interface{} `json:"profile"`
+	Credentials *credentials           `json:"credentials,omitempty"`
+}
+
+// CreateUser creates and activates the user with its password. Disabled users are
+// suspended right after. Okta has no idempotency keys, a lost response leaves a user that
+// is found by name when adopting existing users.
+func (s *Service) CreateUser(ctx context.Context, spec *v1.UserSpec) (*idmsvc.IdentityUser, error) {
+	body := &user{Profile: profileFor(spec)}
+	if spec.Password != "" {
+		body.Credentials = &credentials{Password: &password{Value: spec.Password}}
+	}
+
+	var created user
+	_, err := s.call(ctx, "okta_create_user", "POST", apiPath("users")+"?activate=true", body, &created)
+	if err != nil {
+		return nil, err
+	}
+
+	if !spec.IsEnabled() {
+		err = s.setEnabled(ctx, created.ID, false)
+		if err != nil {
+			return nil, err
+		}
+		return s.GetUser(ctx, created.ID)
+	}
+	return identityUser(&created), nil
+}
+
+// GetUser reads the user
+func (s *Service) GetUser(ctx context.Context, userID string) (*idmsvc.IdentityUser, error) {
+	var found user
+	_, err := s.call(ctx, "okta_get_user", "GET", apiPath("users", userID), nil, &found)
+	if err != nil {
+		return nil, err
+	}
+	return identityUser(&found), nil
+}
+
+// FindUserByName looks up the user by exact login and returns nil without error when there is none
+func (s *Service) FindUserByName(ctx context.Context, name string) (*idmsvc.IdentityUser, error) {
+	var found []user
+	filter := neturl.QueryEscape(fmt.Sprintf("profile.login eq %s", strconv.Quote(name)))
+	_, err := s.call(ctx, "okta_find_user", "GET", apiPath("users")+"?search="+filter, nil, &found)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range found {
+		if found[i].Profile["login"] == name {
+			return identityUser(&found[i]), nil
+		}
+	}
+	return nil, nil
+}
+
+// Okta has no idempotency keys, creations are not deduplicated
+
+func (s *Service) FindUserByIdempotencyKey(ctx context.Context, key string) (*idmsvc.IdentityUser, error) {
+	return nil, idmsvc.ErrNotSupported
+}
+
+// ListUsers returns a page of the users of the organization, paginated by cursor. The
+// cursor is the next link of the Link header of the previous page.
+func (s *Service) ListUsers(ctx context.Context, filter idmsvc.UserFilter, pageOpts idmsvc.PageOptions) (*idmsvc.UserPage, error) {
+	limit := pageOpts.Limit
+	if limit <= 0 {
+		limit = listPageSize
+	}
+	path := apiPath("users") + "?limit=" + strconv.Itoa(limit)
+	if filter.Name != "" {
+		path += "&search=" + neturl.QueryEscape(fmt.Sprintf("profile.login eq %s", strconv.Quote(filter.Name)))
+	}
+	if pageOpts.Cursor != "" {
+		if !strings.HasPrefix(pageOpts.Cursor, apiPath("users")+"?") {
+			return nil, fmt.Errorf("invalid cursor %q", pageOpts.Cursor)
+		}
+		path = pageOpts.Cursor
+	}
+
+	var found []user
+	header, err := s.call(ctx, "okta_list_users", "GET", path, nil, &found)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &idmsvc.UserPage{}
+	for i := range found {
+		if filter.Name == "" || found[i].Profile["login"] == filter.Name {
+			result.Users = append(result.Users, *identityUser(&found[i]))
+		}
+	}
+	if next := s.nextPath(header); next != "" {
+		result.Next = &idmsvc.PageOptions{Limit: limit, Cursor: next}
+	}
+	return result, nil
+}
+
+// UpdateUser replaces the profile of the user, sets its password and suspends or
+// unsuspends it
+func (s *Service) UpdateUser(ctx context.Context, userID string, spec *v1.UserSpec) (*idmsvc.IdentityUser, error) {
+	body := &user{Profile: profileFor(spec)}
+	if spec.Password != "" {
+		body.Credentials = &credentials{Password: &password{Value: spec.Password}}
+	}
+
+	var updated user
+	_, err := s.call(ctx, "okta_update_user", "PUT", apiPath("users", userID), body, &updated)
+	if err != nil {
+		return nil, err
+	}
+
+	enabled := updated.Status != statusSuspended
+	if enabled != spec.IsEnabled() {
+		err = s.setEnabled(ctx, userID, spec.IsEnabled())
+		if err != nil {
+			return nil, err
+		}
+	}
+	return s.GetUser(ctx, userID)
+}
+
+// PatchUser posts only the given fields of the profile, Okta keeps the others. Custom
+// attributes missing from the spec are cleared. Falls back to UpdateUser for instances
+// configured without partial updates.
+func (s *Service) PatchUser(ctx context.Context, userID string, spec *v1.UserSpec, fields []string) (*idmsvc.IdentityUser, error) {
+	if !s.config.PatchUpdates() {
+		return s.UpdateUser(ctx, userID, spec)
+	}
+
+	desired := profileFor(spec)
+	body := &user{Profile: map[string]interface{}{}}
+	enabledChanged := false
+	for _, field := range fields {
+		switch field {
+		case "password":
+			if spec.Password != "" {
+				body.Credentials = &credentials{Password: &password{Value: spec.Password}}
+			}
+		case "enabled":
+			enabledChanged = true
+		case "attributes":
+			current, err := s.GetUser(ctx, userID)
+			if err != nil {
+				return nil, err
+			}
+			for attribute := range current.Attributes {
+				body.Profile[attribute] = nil
+			}
+			for attribute, value := range spec.Attributes {
+				if !baseProfile[attribute] {
+					body.Profile[attribute] = value
+				}
+			}
+		default:
+			if attribute, ok := profileFields[field]; ok {
+				// unset fields are cleared
+				body.Profile[attribute] = desired[attribute]
+			}
+		}
+	}
+
+	if len(body.Profile) > 0 || body.Credentials != nil {
+		_, err := s.call(ctx, "okta_patch_user", "POST", apiPath("users", userID), body, nil)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if enabledChanged {
+		err := s.setEnabled(ctx, userID, spec.IsEnabled())
+		if err != nil {
+			return nil, err
+		}
+	}
+	return s.GetUser(ctx, userID)
+}
+
+// DeleteUser deactivates the user and deletes it, Okta only deletes deactivated users
+func (s *Service) DeleteUser(ctx context.Context, userID string) error {
+	found, err := s.GetUser(ctx, userID)
+	if err != nil {
+		return err
+	}
+	if found.Enabled == nil || *found.Enabled {
+		_, err = s.call(ctx, "okta_deactivate_user", "POST", apiPath("users", userID, "lifecycle", "deactivate"), nil, nil)
+		if err != nil {
+			return err
+		}
+	}
+	_, err = s.call(ctx, "okta_delete_user", "DELETE", apiPath("users", userID), nil, nil)
+	return err
+}
+
+// Okta has no bulk endpoint that reports the created users, users are created one by one
+
+func (s *Service) CreateUsers(ctx context.Context, specs []*v1.UserSpec) ([]idmsvc.BulkResult, error) {
+	return nil, idmsvc.ErrNotSupported
+}
+
+// setEnabled unsuspends or suspends the user
+func (s *Service) setEnabled(ctx context.Context, userID string, enabled bool) error {
+	if enabled {
+		_, err := s.call(ctx, "okta_unsuspend_user", "POST", apiPath("users", userID, "lifecycle", "unsuspend"), nil, nil)
+		return err
+	}
+	_, err := s.call(ctx, "okta_suspend_user", "POST", apiPath("users", userID, "lifecycle", "suspend"), nil, nil)
+	return err
+}
+
+// profileFor converts the User spec into the profile of an Okta user
+func profileFor(spec *v1.UserSpec) map[string]interface{} {
+	profile := map[string]interface{}{}
+	for attribute, value := range spec.Attributes {
+		if !baseProfile[attribute] {
+			profile[attribute] = value
+		}
+	}
+	profile["login"] = spec.Name
+	setString(profile, "firstName", spec.Firstname)
+	setString(profile, "lastName", spec.Lastname)
+	setString(profile, "email", spec.Email)
+	setString(profile, "mobilePhone", spec.Phone)
+	setString(profile, "displayName", spec.DisplayName)
+	setString(profile, "userType", idmsvc.JoinRoles(spec))
+	if spec.Age != 0 {
+		profile["age"] = spec.Age
+	}
+	return profile
+}
+
+// setString sets the profile attribute, Okta clears attributes set to null
+func setString(profile map[string]interface{}, attribute, value string) {
+	if value == "" {
+		profile[attribute] = nil
+		return
+	}
+	profile[attribute] = value
+}
+
+// identityUser converts an Okta user into the user of the identity API
+func identityUser(u *user) *idmsvc.IdentityUser {
+	enabled := u.Status != statusSuspended && u.Status != statusDeprovisioned
+	usr := &idmsvc.IdentityUser{
+		ID:          u.ID,
+		Name:        profileString(u.Profile, "login"),
+		Firstname:   profileString(u.Profile, "firstName"),
+		Lastname:    profileString(u.Profile, "lastName"),
+		Email:       profileString(u.Profile, "email"),
+		Phone:       profileString(u.Profile, "mobilePhone"),
+		DisplayName: profileString(u.Profile, "displayName"),
+		Enabled:     &enabled,
+	}
+	usr.SetRoles(idmsvc.SplitRoles(profileString(u.Profile, "userType")))
+	if age, ok := u.Profile["age"].(float64); ok {
+		usr.Age = int(age)
+	}
+	for attribute, value := range u.Profile {
+		if baseProfile[attribute] {
+			continue
+		}
+		if s, ok := value.(string); ok {
+			if usr.Attributes == nil {
+				usr.Attributes = map[string]string{}
+			}
+			usr.Attributes[attribute] = s
+		}
+	}
+	return usr
+}
+
+// profileString returns the string attribute of the profile, empty if unset
+func profileString(profile map[string]interface{}, attribute string) string {
+	value, _ := profile[attribute].(string)
+	return value
+}
@@ -0,0 +1,167 @@
+package scim
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+// provider is an in-memory SCIM service provider keeping the resources as decoded JSON
+// objects. It supports the requests of the service, filters by userName and externalId
+// and patch operations on plain attribute paths.
+type provider struct {
+	*httptest.Server
+
+	mu        sync.Mutex
+	resources map[string]map[string]interface{}
+	nextID    int
+	// requests records the method and path of each request
+	requests []string
+	// patches records the operations of each PATCH request
+	patches [][]operation
+}
+
+func newProvider() *provider {
+	p := &provider{resources: map[string]map[string]interface{}{}}
+	p.Server = httptest.NewServer(http.HandlerFunc(p.serve))
+	return p
+}
+
+func (p *provider) serve(w http.ResponseWriter, req *http.Request) {
+	defer GinkgoRecover()
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.requests = append(p.requests, req.Method+" "+req.URL.Path)
+	Expect(req.Header.Get("Accept")).To(Equal(contentType))
+	user, pass, ok := req.BasicAuth()
+	if !ok || user != "operator" || pass != "s3cret" {
+		p.reply(w, http.StatusUnauthorized, map[string]interface{}{"detail": "invalid credentials"})
+		return
+	}
+
+	path := strings.TrimPrefix(req.URL.Path, "/scim/v2")
+	if path == "/ServiceProviderConfig" {
+		p.reply(w, http.StatusOK, map[string]interface{}{"patch": map[string]interface{}{"supported": true}})
+		return
+	}
+	if path == "/Users" && req.Method == "GET" {
+		p.list(w, req.URL.Query().Get("filter"))
+		return
+	}
+	if path == "/Users" || path == "/Groups" {
+		var resource map[string]interface{}
+		Expect(json.NewDecoder(req.Body).Decode(&resource)).To(Succeed())
+		p.nextID++
+		resource["id"] = strconv.Itoa(p.nextID)
+		p.resources[path+"/"+strconv.Itoa(p.nextID)] = resource
+		p.reply(w, http.StatusCreated, resource)
+		return
+	}
+
+	resource, found := p.resources[path]
+	if !found {
+		p.reply(w, http.StatusNotFound, map[string]interface{}{"detail": "resource not found"})
+		return
+	}
+	switch req.Method {
+	case "GET":
+		p.reply(w, http.StatusOK, resource)
+	case "PUT":
+		var replaced map[string]interface{}
+		Expect(json.NewDecoder(req.Body).Decode(&replaced)).To(Succeed())
+		replaced["id"] = resource["id"]
+		p.resources[path] = replaced
+		p.reply(w, http.StatusOK, replaced)
+	case "PATCH":
+		var patch patchOp
+		Expect(json.NewDecoder(req.Body).Decode(&patch)).To(Succeed())
+		Expect(patch.Schemas).To(ConsistOf(patchSchema))
+		p.patches = append(p.patches, patch.Operations)
+		for _, op := range patch.Operations {
+			apply(resource, op)
+		}
+		w.WriteHeader(http.StatusNoContent)
+	case "DELETE":
+		delete(p.resources, path)
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// list answers the users matching a filter like `userName eq "jackr"`
+func (p *provider) list(w http.ResponseWriter, filter string) {
+	attribute, value, _ := strings.Cut(filter, " eq ")
+	value = strings.Trim(value, `"`)
+
+	resources := []interface{}{}
+	for path, resource := range p.resources {
+		if strings.HasPrefix(path, "/Users/") && (filter == "" || resource[attribute] == value) {
+			resources = append(resources, resource)
+		}
+	}
+	p.reply(w, http.StatusOK, map[string]interface{}{"totalResults": len(resources), "Resources": resources})
+}
+
+func (p *provider) reply(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", contentType)
+	w.WriteHeader(status)
+	Expect(json.NewEncoder(w).Encode(body)).To(Succeed())
+}
+
+// apply applies a patch operation to the resource. Paths with a value filter, like
+// members[value eq "1"], remove the matching values.
+func apply(resource map[string]interface{}, op operation) {
+	if attribute, filter, ok := strings.Cut(op.Path, "["); ok {
+		value := strings.Trim(strings.TrimSuffix(strings.TrimPrefix(filter, `value eq `), "]"), `"`)
+		values, _ := resource[attribute].([]interface{})
+		var kept []interface{}
+		for _, v := range values {
+			if v.(map[string]interface{})["value"] != value {
+				kept = append(kept, v)
+			}
+		}
+		resource[attribute] = kept
+		return
+	}
+
+	// attributes of the extension schema are addressed by the URN of the schema
+	target, attribute := resource, op.Path
+	if strings.HasPrefix(op.Path, extensionSchema+":") {
+		target = nested(resource, extensionSchema)
+		attribute = strings.TrimPrefix(op.Path, extensionSchema+":")
+	} else if parent, child, ok := strings.Cut(op.Path, "."); ok {
+		target, attribute = nested(resource, parent), child
+	}
+
+	switch op.Op {
+	case "add":
+		values, _ := target[attribute].([]interface{})
+		added, _ := json.Marshal(op.Value)
+		var addedValues []interface{}
+		Expect(json.Unmarshal(added, &addedValues)).To(Succeed())
+		target[attribute] = append(values, addedValues...)
+	case "replace":
+		encoded, _ := json.Marshal(op.Value)
+		var value interface{}
+		Expect(json.Unmarshal(encoded, &value)).To(Succeed())
+		target[attribute] = value
+	case "remove":
+		delete(target, attribute)
+	}
+}
+
+// nested returns the object of the attribute, creating it if needed
+func nested(resource map[string]interface{}, attribute string) map[string]interface{} {
+	object, ok := resource[attribute].(map[string]interface{})
+	if !ok {
+		object = map[string]interface{}{}
+		resource[attribute] = object
+	}
+	return object
+}
@@ -0,0 +1,754 @@
+// Package scim implements the identity API against SCIM 2.0 service providers
+// such as Okta, Azure AD or Keycloak.
+package scim
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	neturl "net/url"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	v1 "github.com/m15ch4/go-identity-operator/api/v1"
+	idmsvc "github.com/m15ch4/go-identity-operator/pkg/identityclient"
+)
+
+const (
+	userSchema  = "urn:ietf:params:scim:schemas:core:2.0:User"
+	groupSchema = "urn:ietf:params:scim:schemas:core:2.0:Group"
+	patchSchema = "urn:ietf:params:scim:api:messages:2.0:PatchOp"
+	bulkSchema  = "urn:ietf:params:scim:api:messages:2.0:BulkRequest"
+
+	// extensionSchema carries the attributes of the identity app without a SCIM counterpart
+	extensionSchema = "urn:ietf:params:scim:schemas:extension:micze:2.0:Identity"
+
+	contentType = "application/scim+json"
+
+	// tokenExpiryLeeway renews access tokens slightly before they expire
+	tokenExpiryLeeway = 30 * time.Second
+)
+
+// mappingSchema describes the users of the service provider for the attribute mapping. The
+// age is kept in the extension schema, whose URN cannot be part of a dot separated path.
+// Patch operations are mapped by PatchUser, they name the attributes in their paths.
+var mappingSchema = &idmsvc.UserSchema{
+	Paths: map[string]string{
+		"firstname": "name.givenName", "lastname": "name.familyName", "email": "emails",
+		"phone": "phoneNumbers", "displayName": "displayName",
+	},
+	Operations: map[string]bool{
+		"scim_create_user": true, "scim_get_user": true, "scim_find_user": true,
+		"scim_list_users": true, "scim_update_user": true, "scim_bulk_create_users": true,
+	},
+	Collections: []string{"Resources", "Operations.data"},
+}
+
+type name struct {
+	GivenName  string `json:"givenName,omitempty"`
+	FamilyName string `json:"familyName,omitempty"`
+}
+
+type multiValue struct {
+	Value   string `json:"value"`
+	Display string `json:"display,omitempty"`
+	Type    string `json:"type,omitempty"`
+	Primary bool   `json:"primary,omitempty"`
+}
+
+type extension struct {
+	Age         int               `json:"age,omitempty"`
+	Description string            `json:"description,omitempty"`
+	Attributes  map[string]string `json:"attributes,omitempty"`
+}
+
+type user struct {
+	Schemas      []string     `json:"schemas,omitempty"`
+	ID           string       `json:"id,omitempty"`
+	ExternalID   string       `json:"externalId,omitempty"`
+	UserName     string       `json:"userName"`
+	Name         *name        `json:"name,omitempty"`
+	DisplayName  string       `json:"displayName,omitempty"`
+	Password     string       `json:"password,omitempty"`
+	Active       *bool        `json:"active,omitempty"`
+	Emails       []multiValue `json:"emails,omitempty"`
+	PhoneNumbers []multiValue `json:"phoneNumbers,omitempty"`
+	Roles        []multiValue `json:"roles,omitempty"`
+	Extension    *extension   `json:"urn:ietf:params:scim:schemas:extension:micze:2.0:Identity,omitempty"`
+}
+
+type group struct {
+	Schemas     []string     `json:"schemas,omitempty"`
+	ID          string       `json:"id,omitempty"`
+	DisplayName string       `json:"displayName"`
+	Members     []multiValue `json:"members,omitempty"`
+	Extension   *extension   `json:"urn:ietf:params:scim:schemas:extension:micze:2.0:Identity,omitempty"`
+}
+
+type serviceProviderConfig struct {
+	Patch struct {
+		Supported bool `json:"supported"`
+	} `json:"patch"`
+}
+
+type listResponse struct {
+	TotalResults int    `json:"totalResults"`
+	Resources    []user `json:"Resources"`
+}
+
+type patchOp struct {
+	Schemas    []string    `json:"schemas"`
+	Operations []operation `json:"Operations"`
+}
+
+type operation struct {
+	Op    string      `json:"op"`
+	Path  string      `json:"path,omitempty"`
+	Value interface{} `json:"value,omitempty"`
+}
+
+type bulkRequest struct {
+	Schemas    []string        `json:"schemas"`
+	Operations []bulkOperation `json:"Operations"`
+}
+
+type bulkOperation struct {
+	Method string `json:"method"`
+	Path   string `json:"path,omitempty"`
+	BulkID string `json:"bulkId,omitempty"`
+	Data   *user  `json:"data,omitempty"`
+}
+
+type bulkResponse struct {
+	Operations []bulkResult `json:"Operations"`
+}
+
+type bulkResult struct {
+	BulkID   string          `json:"bulkId"`
+	Location string          `json:"location"`
+	Status   bulkStatus      `json:"status"`
+	Response json.RawMessage `json:"response,omitempty"`
+}
+
+// bulkStatus is the HTTP status code of a bulk operation. RFC 7644 specifies it as a
+// string, some service providers send a number.
+type bulkStatus int
+
+func (s *bulkStatus) UnmarshalJSON(data []byte) error {
+	code, err := strconv.Atoi(strings.Trim(string(data), `"`))
+	if err != nil {
+		return err
+	}
+	*s = bulkStatus(code)
+	return nil
+}
+
+// Service manages users and groups of a SCIM 2.0 service provider. SCIM has no notion
+// of roles resources, the role of a user is kept in its roles attribute.
+type Service struct {
+	config *idmsvc.IdentityConfig
+	client *idmsvc.Client
+
+	// mu guards the access token cached for OAuth2 client credentials
+	mu          sync.Mutex
+	token       string
+	tokenExpiry time.Time
+}
+
+var _ idmsvc.IdentityAPI = &Service{}
+
+func NewService(config *idmsvc.IdentityConfig) *Service {
+	return &Service{
+		config: config,
+		client: idmsvc.NewClient(config).WithUserSchema(mappingSchema),
+	}
+}
+
+// GetToken verifies the service provider is reachable with the configured credentials
+// by reading its configuration and returns the static bearer token, if any.
+func (s *Service) GetToken(ctx context.Context) (string, error) {
+	err := s.call(ctx, "scim_service_provider_config", "GET", "/ServiceProviderConfig", nil, nil)
+	if err != nil {
+		return "", idmsvc.LoginError(err)
+	}
+	return s.config.Token(), nil
+}
+
+// Info reads the PATCH support from the configuration of the service provider. SCIM
+// does not expose the version of the service provider.
+func (s *Service) Info(ctx context.Context) (*idmsvc.BackendInfo, error) {
+	var config serviceProviderConfig
+	err := s.call(ctx, "scim_service_provider_config", "GET", "/ServiceProviderConfig", nil, &config)
+	if err != nil {
+		return nil, err
+	}
+	return &idmsvc.BackendInfo{
+		SupportsPatch:  config.Patch.Supported && s.config.PatchUpdates(),
+		SupportsGroups: true,
+	}, nil
+}
+
+// SetCredentials is a no-op, the credentials of SCIM services come from their IdentityInstance
+func (s *Service) SetCredentials(user, pass string) {}
+
+// CreateUser creates the user with POST /Users. The idempotency key of the context is
+// stored as the externalId of the user, so FindUserByIdempotencyKey finds it.
+func (s *Service) CreateUser(ctx context.Context, spec *v1.UserSpec) (*idmsvc.IdentityUser, error) {
+	body := userFor(spec)
+	body.ExternalID = idmsvc.IdempotencyKeyFrom(ctx)
+
+	var created user
+	err := s.call(ctx, "scim_create_user", "POST", "/Users", body, &created)
+	if err != nil {
+		return nil, err
+	}
+	return identityUser(&created), nil
+}
+
+// CreateUsers creates the users with a single POST /Bulk request. Service providers without
+// bulk support answer with 404 or 501, which is reported as ErrNotSupported. Like CreateUser,
+// the idempotency keys of the context are stored as the externalId of the users.
+func (s *Service) CreateUsers(ctx context.Context, specs []*v1.UserSpec) ([]idmsvc.BulkResult, error) {
+	body := &bulkRequest{Schemas: []string{bulkSchema}}
+	for i, spec := range specs {
+		data := userFor(spec)
+		data.ExternalID = idmsvc.IdempotencyKeysFrom(ctx, i)
+		body.Operations = append(body.Operations, bulkOperation{
+			Method: "POST",
+			Path:   "/Users",
+			BulkID: strconv.Itoa(i),
+			Data:   data,
+		})
+	}
+
+	var resp bulkResponse
+	err := s.call(ctx, "scim_bulk_create_users", "POST", "/Bulk", body, &resp)
+	if idmsvc.IsNotFound(err) || idmsvc.IsStatus(err, http.StatusNotImplemented) {
+		return nil, idmsvc.ErrNotSupported
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// operations the response does not account for are reported as failed
+	results := make([]idmsvc.BulkResult, len(specs))
+	for i := range results {
+		results[i].Err = fmt.Errorf("bulk response misses the operation of user %s", specs[i].Name)
+	}
+	for _, op := range resp.Operations {
+		i, err := strconv.Atoi(op.BulkID)
+		if err != nil || i < 0 || i >= len(specs) {
+			continue
+		}
+		results[i] = s.bulkResult(ctx, &op)
+	}
+	return results, nil
+}
+
+// bulkResult converts the result of a bulk operation. Service providers may omit the created
+// user from the response, it is then read from its location.
+func (s *Service) bulkResult(ctx context.Context, op *bulkResult) idmsvc.BulkResult {
+	if op.Status < 200 || op.Status > 299 {
+		return idmsvc.BulkResult{Err: idmsvc.NewAPIError(int(op.Status), op.Response)}
+	}
+	var created user
+	if len(op.Response) > 0 && json.Unmarshal(op.Response, &created) == nil && created.ID != "" {
+		return idmsvc.BulkResult{User: identityUser(&created)}
+	}
+	usr, err := s.GetUser(ctx, op.Location[strings.LastIndex(op.Location, "/")+1:])
+	return idmsvc.BulkResult{User: usr, Err: err}
+}
+
+// GetUser reads the user with GET /Users/{id}
+func (s *Service) GetUser(ctx context.Context, userID string) (*idmsvc.IdentityUser, error) {
+	var found user
+	err := s.call(ctx, "scim_get_user", "GET", "/Users/"+neturl.PathEscape(userID), nil, &found)
+	if err != nil {
+		return nil, err
+	}
+	return identityUser(&found), nil
+}
+
+// FindUserByName filters the users by userName and returns nil without error when there is none
+func (s *Service) FindUserByName(ctx context.Context, userName string) (*idmsvc.IdentityUser, error) {
+	filter := `userName eq "` + strings.ReplaceAll(userName, `"`, `\"`) + `"`
+
+	var list listResponse
+	err := s.call(ctx, "scim_find_user", "GET", "/Users?filter="+neturl.QueryEscape(filter), nil, &list)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range list.Resources {
+		if list.Resources[i].UserName == userName {
+			return identityUser(&list.Resources[i]), nil
+		}
+	}
+	return nil, nil
+}
+
+// FindUserByIdempotencyKey looks up the user created with the given idempotency key by
+// its externalId. It returns nil without error when no such user exists.
+func (s *Service) FindUserByIdempotencyKey(ctx context.Context, key string) (*idmsvc.IdentityUser, error) {
+	filter := `externalId eq "` + strings.ReplaceAll(key, `"`, `\"`) + `"`
+
+	var list listResponse
+	err := s.call(ctx, "scim_find_user", "GET", "/Users?filter="+neturl.QueryEscape(filter), nil, &list)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range list.Resources {
+		if list.Resources[i].ExternalID == key {
+			return identityUser(&list.Resources[i]), nil
+		}
+	}
+	return nil, nil
+}
+
+// ListUsers returns a page of the users, paginated by offset with startIndex and count
+func (s *Service) ListUsers(ctx context.Context, filter idmsvc.UserFilter, pageOpts idmsvc.PageOptions) (*idmsvc.UserPage, error) {
+	path := "/Users?startIndex=" + strconv.Itoa(pageOpts.Offset+1)
+	if pageOpts.Limit > 0 {
+		path += "&count=" + strconv.Itoa(pageOpts.Limit)
+	}
+	if filter.Name != "" {
+		path += "&filter=" + neturl.QueryEscape(`userName eq "`+strings.ReplaceAll(filter.Name, `"`, `\"`)+`"`)
+	}
+
+	var list listResponse
+	err := s.call(ctx, "scim_list_users", "GET", path, nil, &list)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &idmsvc.UserPage{}
+	for i := range list.Resources {
+		if filter.Name == "" || list.Resources[i].UserName == filter.Name {
+			result.Users = append(result.Users, *identityUser(&list.Resources[i]))
+		}
+	}
+	if listed := pageOpts.Offset + len(list.Resources); len(list.Resources) > 0 && listed < list.TotalResults {
+		result.Next = &idmsvc.PageOptions{Limit: pageOpts.Limit, Offset: listed}
+	}
+	return result, nil
+}
+
+// UpdateUser replaces the user with PUT /Users/{id}
+func (s *Service) UpdateUser(ctx context.Context, userID string, spec *v1.UserSpec) (*idmsvc.IdentityUser, error) {
+	var updated user
+	err := s.call(ctx, "scim_update_user", "PUT", "/Users/"+neturl.PathEscape(userID), userFor(spec), &updated)
+	if err != nil {
+		return nil, err
+	}
+	return identityUser(&updated), nil
+}
+
+// PatchUser replaces only the given fields of the user with PATCH /Users/{id}, fields
+// cleared in the spec are removed. Falls back to UpdateUser for service providers
+// configured without PATCH support.
+func (s *Service) PatchUser(ctx context.Context, userID string, spec *v1.UserSpec, fields []string) (*idmsvc.IdentityUser, error) {
+	if !s.config.PatchUpdates() {
+		return s.UpdateUser(ctx, userID, spec)
+	}
+
+	u := userFor(spec)
+	var operations []operation
+	for _, field := range fields {
+		var path string
+		var value interface{}
+		switch field {
+		case "name":
+			path, value = "userName", u.UserName
+		case "password":
+			path, value = "password", u.Password
+		case "firstname":
+			path, value = "name.givenName", u.Name.GivenName
+		case "lastname":
+			path, value = "name.familyName", u.Name.FamilyName
+		case "displayName":
+			path, value = "displayName", u.DisplayName
+		case "email":
+			path, value = "emails", u.Emails
+		case "phone":
+			path, value = "phoneNumbers", u.PhoneNumbers
+		case "role":
+			path, value = "roles", u.Roles
+		case "age":
+			path, value = extensionSchema+":age", u.Extension.Age
+		case "attributes":
+			path, value = extensionSchema+":attributes", u.Extension.Attributes
+		case "enabled":
+			// removing active would not deactivate the user, it is always replaced
+			operations = append(operations, operation{Op: "replace", Path: "active", Value: *u.Active})
+			continue
+		default:
+			continue
+		}
+		if _, ok := mappingSchema.Paths[field]; ok {
+			path = s.config.AttributeMapping().Path(field, path)
+		}
+		if reflect.ValueOf(value).IsZero() {
+			operations = append(operations, operation{Op: "remove", Path: path})
+		} else {
+			operations = append(operations, operation{Op: "replace", Path: path, Value: value})
+		}
+	}
+	if len(operations) == 0 {
+		return s.GetUser(ctx, userID)
+	}
+
+	err := s.patchUser(ctx, "scim_patch_user", userID, operations...)
+	if err != nil {
+		return nil, err
+	}
+	// service providers may answer with 204 No Content
+	return s.GetUser(ctx, userID)
+}
+
+// DeleteUser deletes the user with DELETE /Users/{id}
+func (s *Service) DeleteUser(ctx context.Context, userID string) error {
+	return s.call(ctx, "scim_delete_user", "DELETE", "/Users/"+neturl.PathEscape(userID), nil, nil)
+}
+
+func (s *Service) CreateRole(ctx context.Context, role *v1.RoleSpec) (*idmsvc.IdentityRole, error) {
+	return nil, idmsvc.ErrNotSupported
+}
+
+func (s *Service) GetRole(ctx context.Context, roleID string) (*idmsvc.IdentityRole, error) {
+	return nil, idmsvc.ErrNotSupported
+}
+
+func (s *Service) UpdateRole(ctx context.Context, roleID string, role *v1.RoleSpec) (*idmsvc.IdentityRole, error) {
+	return nil, idmsvc.ErrNotSupported
+}
+
+func (s *Service) DeleteRole(ctx context.Context, roleID string) error {
+	return idmsvc.ErrNotSupported
+}
+
+// ListUserRoles reads the roles attribute of the user and returns the values of the roles
+// whose type is the scope
+func (s *Service) ListUserRoles(ctx context.Context, userID, scope string) ([]string, error) {
+	var found user
+	err := s.call(ctx, "scim_list_user_roles", "GET", "/Users/"+neturl.PathEscape(userID)+"?attributes=roles", nil, &found)
+	if err != nil {
+		return nil, err
+	}
+
+	var roles []string
+	for _, r := range found.Roles {
+		if r.Type == scope {
+			roles = append(roles, r.Value)
+		}
+	}
+	return roles, nil
+}
+
+// AddUserRole adds the role with the scope as its type to the roles of the user with a
+// PATCH add operation
+func (s *Service) AddUserRole(ctx context.Context, userID, scope, role string) error {
+	return s.patchUser(ctx, "scim_add_user_role", userID,
+		operation{Op: "add", Path: "roles", Value: []multiValue{{Value: role, Type: scope}}},
+	)
+}
+
+// RemoveUserRole removes the role with the scope as its type from the roles of the user
+// with a PATCH remove operation
+func (s *Service) RemoveUserRole(ctx context.Context, userID, scope, role string) error {
+	filter := `value eq "` + strings.ReplaceAll(role, `"`, `\"`) + `"`
+	if scope != "" {
+		filter += ` and type eq "` + strings.ReplaceAll(scope, `"`, `\"`) + `"`
+	}
+	return s.patchUser(ctx, "scim_remove_user_role", userID,
+		operation{Op: "remove", Path: "roles[" + filter + "]"},
+	)
+}
+
+// CreateGroup creates the group with POST /Groups
+func (s *Service) CreateGroup(ctx context.Context, spec *v1.GroupSpec) (*idmsvc.IdentityGroup, error) {
+	body := &group{
+		Schemas:     []string{groupSchema, extensionSchema},
+		DisplayName: spec.Name,
+		Extension:   &extension{Description: spec.Description},
+	}
+
+	var created group
+	err := s.call(ctx, "scim_create_group", "POST", "/Groups", body, &created)
+	if err != nil {
+		return nil, err
+	}
+	return identityGroup(&created), nil
+}
+
+// GetGroup reads the group without its members with GET /Groups/{id}
+func (s *Service) GetGroup(ctx context.Context, groupID string) (*idmsvc.IdentityGroup, error) {
+	var found group
+	err := s.call(ctx, "scim_get_group", "GET", "/Groups/"+neturl.PathEscape(groupID)+"?excludedAttributes=members", nil, &found)
+	if err != nil {
+		return nil, err
+	}
+	return identityGroup(&found), nil
+}
+
+// UpdateGroup replaces the name and description of the group with PATCH /Groups/{id},
+// leaving its members untouched
+func (s *Service) UpdateGroup(ctx context.Context, groupID string, spec *v1.GroupSpec) (*idmsvc.IdentityGroup, error) {
+	err := s.patchGroup(ctx, "scim_update_group", groupID,
+		operation{Op: "replace", Path: "displayName", Value: spec.Name},
+		operation{Op: "replace", Path: extensionSchema + ":description", Value: spec.Description},
+	)
+	if err != nil {
+		return nil, err
+	}
+	return s.GetGroup(ctx, groupID)
+}
+
+// DeleteGroup deletes the group with DELETE /Groups/{id}
+func (s *Service) DeleteGroup(ctx context.Context, groupID string) error {
+	return s.call(ctx, "scim_delete_group", "DELETE", "/Groups/"+neturl.PathEscape(groupID), nil, nil)
+}
+
+// ListGroupMembers reads the members attribute of the group
+func (s *Service) ListGroupMembers(ctx context.Context, groupID string) ([]string, error) {
+	var found group
+	err := s.call(ctx, "scim_list_group_members", "GET", "/Groups/"+neturl.PathEscape(groupID)+"?attributes=members", nil, &found)
+	if err != nil {
+		return nil, err
+	}
+
+	ids := make([]string, 0, len(found.Members))
+	for _, member := range found.Members {
+		ids = append(ids, member.Value)
+	}
+	return ids, nil
+}
+
+// AddGroupMember adds the user to the members of the group with a PATCH add operation
+func (s *Service) AddGroupMember(ctx context.Context, groupID, userID string) error {
+	return s.patchGroup(ctx, "scim_add_group_member", groupID,
+		operation{Op: "add", Path: "members", Value: []multiValue{{Value: userID}}},
+	)
+}
+
+// RemoveGroupMember removes the user from the members of the group with a PATCH remove operation
+func (s *Service) RemoveGroupMember(ctx context.Context, groupID, userID string) error {
+	return s.patchGroup(ctx, "scim_remove_group_member", groupID,
+		operation{Op: "remove", Path: `members[value eq "` + strings.ReplaceAll(userID, `"`, `\"`) + `"]`},
+	)
+}
+
+// AddChildGroup adds the group to the members of the parent group with a PATCH add operation
+func (s *Service) AddChildGroup(ctx context.Context, parentID, groupID string) error {
+	return s.patchGroup(ctx, "scim_add_child_group", parentID,
+		operation{Op: "add", Path: "members", Value: []multiValue{{Value: groupID, Type: "Group"}}},
+	)
+}
+
+// RemoveChildGroup removes the group from the members of the parent group with a PATCH remove operation
+func (s *Service) RemoveChildGroup(ctx context.Context, parentID, groupID string) error {
+	return s.patchGroup(ctx, "scim_remove_child_group", parentID,
+		operation{Op: "remove", Path: `members[value eq "` + strings.ReplaceAll(groupID, `"`, `\"`) + `"]`},
+	)
+}
+
+// SCIM has no notion of machine credentials
+
+func (s *Service) CreateAPIKey(ctx context.Context, key *v1.ApiKeySpec) (*idmsvc.IdentityAPIKey, error) {
+	return nil, idmsvc.ErrNotSupported
+}
+
+func (s *Service) RotateAPIKey(ctx context.Context, keyID string) (*idmsvc.IdentityAPIKey, error) {
+	return nil, idmsvc.ErrNotSupported
+}
+
+func (s *Service) DeleteAPIKey(ctx context.Context, keyID string) error {
+	return idmsvc.ErrNotSupported
+}
+
+// patchGroup applies the operations to the group with PATCH /Groups/{id}
+func (s *Service) patchGroup(ctx context.Context, metric, groupID string, operations ...operation) error {
+	body := &patchOp{
+		Schemas:    []string{patchSchema},
+		Operations: operations,
+	}
+	return s.call(ctx, metric, "PATCH", "/Groups/"+neturl.PathEscape(groupID), body, nil)
+}
+
+// patchUser applies the operations to the user
+func (s *Service) patchUser(ctx context.Context, metric, userID string, operations ...operation) error {
+	body := &patchOp{
+		Schemas:    []string{patchSchema},
+		Operations: operations,
+	}
+	return s.call(ctx, metric, "PATCH", "/Users/"+neturl.PathEscape(userID), body, nil)
+}
+
+// accessToken returns the cached OAuth2 access token, obtaining a new one when there is
+// none or it is about to expire
+func (s *Service) accessToken(ctx context.Context) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.token != "" && time.Now().Add(tokenExpiryLeeway).Before(s.tokenExpiry) {
+		return s.token, nil
+	}
+
+	token, expiry, err := s.client.ClientCredentialsToken(ctx)
+	if err != nil {
+		return "", err
+	}
+	s.token, s.tokenExpiry = token, expiry
+	return token, nil
+}
+
+// call makes an authenticated SCIM request. The in value, if not nil, is sent as JSON
+// request body and the JSON response body is decoded into out, if not nil.
+func (s *Service) call(ctx context.Context, operation, method, path string, in, out interface{}) error {
+	// prepare request body
+	var reqBody io.Reader
+	if in != nil {
+		body, err := json.Marshal(in)
+		if err != nil {
+			return err
+		}
+		reqBody = bytes.NewBuffer(body)
+	}
+
+	// prepare request
+	req, err := http.NewRequestWithContext(ctx, method, s.client.BaseURL()+path, reqBody)
+	if err != nil {
+		return err
+	}
+
+	// authenticate with the bearer token or an OAuth2 access token, or with basic auth without one
+	if token := s.config.Token(); token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	} else if s.config.UsesClientCredentials() {
+		token, err := s.accessToken(ctx)
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Authorization", "Bearer "+token)
+	} else {
+		req.SetBasicAuth(s.config.Credentials())
+	}
+
+	// set content type and accept headers
+	if in != nil {
+		req.Header.Set("Content-Type", contentType)
+	}
+	req.Header.Set("Accept", contentType)
+
+	// make REST API call
+	resp, err := s.client.Do(operation, req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	// read response body
+	body, err := idmsvc.ReadBody(resp)
+	if err != nil {
+		return err
+	}
+
+	// check response status code
+	err = idmsvc.CheckResponse(resp, body)
+	if err != nil {
+		return err
+	}
+
+	// unmarshal response body
+	if out == nil || len(body) == 0 {
+		return nil
+	}
+	return idmsvc.DecodeJSON(resp, body, out)
+}
+
+// userFor converts the User spec into a SCIM user
+func userFor(spec *v1.UserSpec) *user {
+	u := &user{
+		Schemas:  []string{userSchema, extensionSchema},
+		UserName: spec.Name,
+		Name: &name{
+			GivenName:  spec.Firstname,
+			FamilyName: spec.Lastname,
+		},
+		DisplayName: spec.DisplayName,
+		Password:    spec.Password,
+		Extension:   &extension{Age: spec.Age, Attributes: spec.Attributes},
+	}
+	active := spec.IsEnabled()
+	u.Active = &active
+	if spec.Email != "" {
+		u.Emails = []multiValue{{Value: spec.Email, Type: "work", Primary: true}}
+	}
+	if spec.Phone != "" {
+		u.PhoneNumbers = []multiValue{{Value: spec.Phone, Type: "work", Primary: true}}
+	}
+	for _, role := range spec.AllRoles() {
+		u.Roles = append(u.Roles, multiValue{Value: role})
+	}
+	return u
+}
+
+// identityUser converts a SCIM user into the user of the identity API
+func identityUser(u *user) *idmsvc.IdentityUser {
+	usr := &idmsvc.IdentityUser{
+		ID:          u.ID,
+		Name:        u.UserName,
+		DisplayName: u.DisplayName,
+		Email:       primaryValue(u.Emails),
+		Phone:       primaryValue(u.PhoneNumbers),
+		Enabled:     u.Active,
+	}
+	if u.Name != nil {
+		usr.Firstname = u.Name.GivenName
+		usr.Lastname = u.Name.FamilyName
+	}
+	// roles with a type are assigned by UserRoleBindings in that scope
+	var roles []string
+	for _, r := range u.Roles {
+		if r.Type == "" {
+			roles = append(roles, r.Value)
+		}
+	}
+	usr.SetRoles(roles)
+	if u.Extension != nil {
+		usr.Age = u.Extension.Age
+		usr.Attributes = u.Extension.Attributes
+	}
+	return usr
+}
+
+// primaryValue returns the primary value of a multi-valued attribute, or its first value
+func primaryValue(values []multiValue) string {
+	for _, v := range values {
+		if v.Primary {
+			return v.Value
+		}
+	}
+	if len(values) > 0 {
+		return values[0].Value
+	}
+	return ""
+}
+
+// identityGroup converts a SCIM group into the group of the identity API
+func identityGroup(g *group) *idmsvc.IdentityGroup {
+	grp := &idmsvc.IdentityGroup{
+		ID:   g.ID,
+		Name: g.DisplayName,
+	}
+	if g.Extension != nil {
+		grp.Description = g.Extension.Description
+	}
+	return grp
+}
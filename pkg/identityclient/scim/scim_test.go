@@ -0,0 +1,187 @@
+package scim
+
+import (
+	"context"
+	"net/url"
+	"strconv"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	v1 "github.com/m15ch4/go-identity-operator/api/v1"
+	idmsvc "github.com/m15ch4/go-identity-operator/pkg/identityclient"
+)
+
+var _ = Describe("Service", func() {
+	ctx := context.Background()
+
+	var (
+		sp  *provider
+		svc *Service
+	)
+
+	// newService returns a service of the provider, with the options of the spec
+	newService := func(opts ...idmsvc.ConfigOpts) *Service {
+		u, err := url.Parse(sp.URL)
+		Expect(err).NotTo(HaveOccurred())
+		port, err := strconv.Atoi(u.Port())
+		Expect(err).NotTo(HaveOccurred())
+		cfg := idmsvc.NewIdentityConfig(append([]idmsvc.ConfigOpts{
+			idmsvc.WithScheme(u.Scheme),
+			idmsvc.WithHost(u.Hostname()),
+			idmsvc.WithPort(port),
+			idmsvc.WithBasePath("/scim/v2"),
+			idmsvc.WithUser("operator"),
+			idmsvc.WithPass("s3cret"),
+			idmsvc.WithRetry(1, 0, 0),
+		}, opts...)...)
+		return NewService(&cfg)
+	}
+
+	BeforeEach(func() {
+		sp = newProvider()
+		DeferCleanup(sp.Close)
+		svc = newService()
+	})
+
+	It("reads the configuration of the service provider and reports rejected credentials", func() {
+		_, err := svc.GetToken(ctx)
+		Expect(err).NotTo(HaveOccurred())
+		info, err := svc.Info(ctx)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(info.SupportsPatch).To(BeTrue())
+
+		_, err = newService(idmsvc.WithPass("wrong")).GetToken(ctx)
+		Expect(idmsvc.IsCredentialsInvalid(err)).To(BeTrue())
+	})
+
+	It("creates users and finds them by name and idempotency key", func() {
+		created, err := svc.CreateUser(idmsvc.WithIdempotencyKey(ctx, "key-1"), &v1.UserSpec{
+			Name: "jackr", Password: "pw", Firstname: "Jack", Lastname: "Reacher",
+			Email: "jackr@example.com", Role: "admin", Age: 40,
+		})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(created.ID).To(Equal("1"))
+		Expect(sp.resources["/Users/1"]).To(HaveKeyWithValue("externalId", "key-1"))
+		Expect(sp.resources["/Users/1"]).To(HaveKeyWithValue("active", true))
+
+		found, err := svc.GetUser(ctx, "1")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(found.Name).To(Equal("jackr"))
+		Expect(found.Firstname).To(Equal("Jack"))
+		Expect(found.Lastname).To(Equal("Reacher"))
+		Expect(found.Email).To(Equal("jackr@example.com"))
+		Expect(found.Age).To(Equal(40))
+		Expect(found.AllRoles()).To(ConsistOf("admin"))
+
+		found, err = svc.FindUserByName(ctx, "jackr")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(found.ID).To(Equal("1"))
+		found, err = svc.FindUserByIdempotencyKey(ctx, "key-1")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(found.ID).To(Equal("1"))
+		found, err = svc.FindUserByName(ctx, "janed")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(found).To(BeNil())
+	})
+
+	It("replaces users with PUT and deletes them", func() {
+		_, err := svc.CreateUser(ctx, &v1.UserSpec{Name: "jackr", Firstname: "Jack"})
+		Expect(err).NotTo(HaveOccurred())
+
+		disabled := false
+		updated, err := svc.UpdateUser(ctx, "1", &v1.UserSpec{Name: "jackr", Lastname: "Reacher", Enabled: &disabled})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(updated.Firstname).To(BeEmpty())
+		Expect(updated.Lastname).To(Equal("Reacher"))
+		Expect(*updated.Enabled).To(BeFalse())
+		Expect(sp.requests).To(ContainElement("PUT /scim/v2/Users/1"))
+
+		Expect(svc.DeleteUser(ctx, "1")).To(Succeed())
+		_, err = svc.GetUser(ctx, "1")
+		Expect(idmsvc.IsNotFound(err)).To(BeTrue())
+		Expect(idmsvc.IsNotFound(svc.DeleteUser(ctx, "1"))).To(BeTrue())
+	})
+
+	It("patches the changed fields, removing the cleared ones", func() {
+		_, err := svc.CreateUser(ctx, &v1.UserSpec{Name: "jackr", Firstname: "Jack", Phone: "555-0100", Age: 40})
+		Expect(err).NotTo(HaveOccurred())
+
+		patched, err := svc.PatchUser(ctx, "1", &v1.UserSpec{Name: "jackr", Firstname: "Jacques", Age: 41},
+			[]string{"firstname", "phone", "age"})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(sp.patches).To(HaveLen(1))
+		Expect(sp.patches[0]).To(Equal([]operation{
+			{Op: "replace", Path: "name.givenName", Value: "Jacques"},
+			{Op: "remove", Path: "phoneNumbers"},
+			{Op: "replace", Path: extensionSchema + ":age", Value: float64(41)},
+		}))
+		Expect(patched.Firstname).To(Equal("Jacques"))
+		Expect(patched.Phone).To(BeEmpty())
+		Expect(patched.Age).To(Equal(41))
+	})
+
+	It("replaces users with PUT when PATCH updates are disabled", func() {
+		svc = newService(idmsvc.WithPatchUpdates(false))
+		_, err := svc.CreateUser(ctx, &v1.UserSpec{Name: "jackr"})
+		Expect(err).NotTo(HaveOccurred())
+
+		_, err = svc.PatchUser(ctx, "1", &v1.UserSpec{Name: "jackr", Firstname: "Jack"}, []string{"firstname"})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(sp.patches).To(BeEmpty())
+		Expect(sp.requests).To(ContainElement("PUT /scim/v2/Users/1"))
+	})
+
+	It("keeps the roles of a scope as typed values of the roles attribute", func() {
+		_, err := svc.CreateUser(ctx, &v1.UserSpec{Name: "jackr", Role: "admin"})
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(svc.AddUserRole(ctx, "1", "billing", "viewer")).To(Succeed())
+		roles, err := svc.ListUserRoles(ctx, "1", "billing")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(roles).To(ConsistOf("viewer"))
+
+		found, err := svc.GetUser(ctx, "1")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(found.AllRoles()).To(ConsistOf("admin"), "the roles of a scope are not the roles of the user")
+
+		Expect(svc.RemoveUserRole(ctx, "1", "billing", "viewer")).To(Succeed())
+		Expect(sp.patches[len(sp.patches)-1]).To(Equal([]operation{
+			{Op: "remove", Path: `roles[value eq "viewer" and type eq "billing"]`},
+		}))
+	})
+
+	It("creates, renames and deletes groups and manages their members", func() {
+		created, err := svc.CreateGroup(ctx, &v1.GroupSpec{Name: "devs", Description: "Developers"})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(created.Name).To(Equal("devs"))
+		Expect(created.Description).To(Equal("Developers"))
+
+		updated, err := svc.UpdateGroup(ctx, created.ID, &v1.GroupSpec{Name: "developers", Description: "All developers"})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(updated.Name).To(Equal("developers"))
+		Expect(updated.Description).To(Equal("All developers"))
+
+		Expect(svc.AddGroupMember(ctx, created.ID, "7")).To(Succeed())
+		Expect(svc.AddGroupMember(ctx, created.ID, "8")).To(Succeed())
+		members, err := svc.ListGroupMembers(ctx, created.ID)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(members).To(ConsistOf("7", "8"))
+
+		Expect(svc.RemoveGroupMember(ctx, created.ID, "7")).To(Succeed())
+		members, err = svc.ListGroupMembers(ctx, created.ID)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(members).To(ConsistOf("8"))
+
+		Expect(svc.DeleteGroup(ctx, created.ID)).To(Succeed())
+		_, err = svc.GetGroup(ctx, created.ID)
+		Expect(idmsvc.IsNotFound(err)).To(BeTrue())
+	})
+
+	It("does not support roles and API keys", func() {
+		_, err := svc.CreateRole(ctx, &v1.RoleSpec{Name: "admin"})
+		Expect(idmsvc.IsNotSupported(err)).To(BeTrue())
+		_, err = svc.CreateAPIKey(ctx, &v1.ApiKeySpec{})
+		Expect(idmsvc.IsNotSupported(err)).To(BeTrue())
+	})
+})
@@ -0,0 +1,16 @@
+package scim
+
+import (
+	"testing"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+// The service is tested against an in-memory service provider served with httptest
+
+func TestSCIM(t *testing.T) {
+	RegisterFailHandler(Fail)
+
+	RunSpecs(t, "SCIM Suite")
+}
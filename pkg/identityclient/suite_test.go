@@ -0,0 +1,29 @@
+package identityclient_test
+
+import (
+	neturl "net/url"
+	"strconv"
+	"testing"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	idmsvc "github.com/m15ch4/go-identity-operator/pkg/identityclient"
+)
+
+// The clients are tested against httptest servers standing in for the identity systems
+
+func TestIdentityClient(t *testing.T) {
+	RegisterFailHandler(Fail)
+
+	RunSpecs(t, "Identity Client Suite")
+}
+
+// serverOpts returns the options of a client of the identity app served by url
+func serverOpts(url string) []idmsvc.ConfigOpts {
+	u, err := neturl.Parse(url)
+	Expect(err).NotTo(HaveOccurred())
+	port, err := strconv.Atoi(u.Port())
+	Expect(err).NotTo(HaveOccurred())
+	return []idmsvc.ConfigOpts{idmsvc.WithScheme(u.Scheme), idmsvc.WithHost(u.Hostname()), idmsvc.WithPort(port)}
+}
@@ -0,0 +1,113 @@
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.24.0"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+)
+
+const (
+	// defaultEndpoint is the OTLP/HTTP endpoint of a local collector
+	defaultEndpoint = "localhost:4318"
+	// defaultServiceName is the service.name of the spans unless configured otherwise
+	defaultServiceName = "go-identity-operator"
+	// shutdownTimeout bounds the export of the spans still queued when the manager stops
+	shutdownTimeout = 10 * time.Second
+)
+
+// Provider is the tracer provider of the OpenTelemetry SDK, exporting the sampled spans in
+// batches to an OTLP/HTTP collector. It runs as a manager runnable on every replica and
+// exports the queued spans when the manager stops.
+type Provider struct {
+	*sdktrace.TracerProvider
+	endpoint string
+}
+
+var _ manager.LeaderElectionRunnable = &Provider{}
+
+// NewProviderFromEnvironment returns the provider configured by the standard OpenTelemetry
+// environment variables, or nil when tracing is disabled. Tracing is enabled by an
+// OTEL_EXPORTER_OTLP_ENDPOINT or OTEL_EXPORTER_OTLP_TRACES_ENDPOINT, or by
+// OTEL_TRACES_EXPORTER=otlp for a collector on localhost. Only the http/protobuf protocol
+// is supported. The exporter, the sampler and the batching read their own variables.
+func NewProviderFromEnvironment(ctx context.Context) (*Provider, error) {
+	if strings.EqualFold(os.Getenv("OTEL_SDK_DISABLED"), "true") {
+		return nil, nil
+	}
+
+	endpoint := lookup("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT", "OTEL_EXPORTER_OTLP_ENDPOINT")
+	switch exporter := os.Getenv("OTEL_TRACES_EXPORTER"); exporter {
+	case "":
+		if endpoint == "" {
+			return nil, nil
+		}
+	case "none":
+		return nil, nil
+	case "otlp":
+		if endpoint == "" {
+			endpoint = defaultEndpoint
+		}
+	default:
+		return nil, fmt.Errorf("unsupported OTEL_TRACES_EXPORTER %q, expected otlp or none", exporter)
+	}
+	protocol := lookup("OTEL_EXPORTER_OTLP_TRACES_PROTOCOL", "OTEL_EXPORTER_OTLP_PROTOCOL")
+	if protocol != "" && protocol != "http/protobuf" {
+		return nil, fmt.Errorf("unsupported OTLP protocol %q, expected http/protobuf", protocol)
+	}
+
+	exporter, err := otlptracehttp.New(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("unable to create the OTLP exporter: %w", err)
+	}
+	// OTEL_SERVICE_NAME and OTEL_RESOURCE_ATTRIBUTES take precedence over the default name
+	res, err := resource.New(ctx,
+		resource.WithAttributes(semconv.ServiceName(defaultServiceName)),
+		resource.WithTelemetrySDK(),
+		resource.WithFromEnv(),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("invalid OTEL_RESOURCE_ATTRIBUTES: %w", err)
+	}
+
+	return &Provider{
+		TracerProvider: sdktrace.NewTracerProvider(sdktrace.WithBatcher(exporter), sdktrace.WithResource(res)),
+		endpoint:       endpoint,
+	}, nil
+}
+
+// Endpoint returns the endpoint the spans are exported to, as configured
+func (p *Provider) Endpoint() string {
+	return p.endpoint
+}
+
+// Start waits until the context is cancelled, then exports the spans still queued and shuts
+// the provider down
+func (p *Provider) Start(ctx context.Context) error {
+	<-ctx.Done()
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	return p.Shutdown(shutdownCtx)
+}
+
+// NeedLeaderElection is false, every replica exports its own spans
+func (p *Provider) NeedLeaderElection() bool {
+	return false
+}
+
+// lookup returns the value of the first of the environment variables that is set
+func lookup(names ...string) string {
+	for _, name := range names {
+		if value := os.Getenv(name); value != "" {
+			return value
+		}
+	}
+	return ""
+}
@@ -0,0 +1,14 @@
+package tracing
+
+import (
+	"testing"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+func TestTracing(t *testing.T) {
+	RegisterFailHandler(Fail)
+
+	RunSpecs(t, "Tracing Suite")
+}
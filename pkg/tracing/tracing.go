@@ -0,0 +1,31 @@
+// Package tracing sets up the OpenTelemetry SDK recording spans of the reconciliations and
+// of the requests to the identity systems, so the latency of an identity system can be traced
+// per resource. Spans are exported over OTLP/HTTP as configured by the standard OTEL_*
+// environment variables, and the W3C trace context is propagated to the identity systems.
+package tracing
+
+import (
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/noop"
+)
+
+// instrumentationName names the tracer of the spans of the operator
+const instrumentationName = "github.com/m15ch4/go-identity-operator"
+
+// Tracer returns the tracer of the spans of the operator, of the provider that is set
+func Tracer() trace.Tracer {
+	return otel.Tracer(instrumentationName)
+}
+
+// SetProvider sets the provider of the spans and propagates the trace context of requests
+// in W3C traceparent headers. Without a provider spans are not recorded.
+func SetProvider(p *Provider) {
+	if p == nil {
+		otel.SetTracerProvider(noop.NewTracerProvider())
+		return
+	}
+	otel.SetTracerProvider(p.TracerProvider)
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+}
@@ -0,0 +1,164 @@
+package tracing
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+	collectortrace "go.opentelemetry.io/proto/otlp/collector/trace/v1"
+	tracepb "go.opentelemetry.io/proto/otlp/trace/v1"
+	"google.golang.org/protobuf/proto"
+)
+
+var _ = Describe("Provider", func() {
+	ctx := context.Background()
+
+	It("is disabled without an endpoint or exporter", func() {
+		p, err := NewProviderFromEnvironment(ctx)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(p).To(BeNil())
+
+		GinkgoT().Setenv("OTEL_EXPORTER_OTLP_ENDPOINT", "http://collector:4318")
+		GinkgoT().Setenv("OTEL_TRACES_EXPORTER", "none")
+		p, err = NewProviderFromEnvironment(ctx)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(p).To(BeNil())
+
+		GinkgoT().Setenv("OTEL_TRACES_EXPORTER", "")
+		GinkgoT().Setenv("OTEL_SDK_DISABLED", "true")
+		p, err = NewProviderFromEnvironment(ctx)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(p).To(BeNil())
+	})
+
+	It("exports to a local collector when only the exporter is set", func() {
+		GinkgoT().Setenv("OTEL_TRACES_EXPORTER", "otlp")
+		p, err := NewProviderFromEnvironment(ctx)
+		Expect(err).NotTo(HaveOccurred())
+		DeferCleanup(p.Shutdown, ctx)
+		Expect(p.Endpoint()).To(Equal("localhost:4318"))
+	})
+
+	It("rejects unsupported exporters and protocols", func() {
+		GinkgoT().Setenv("OTEL_TRACES_EXPORTER", "zipkin")
+		_, err := NewProviderFromEnvironment(ctx)
+		Expect(err).To(MatchError(ContainSubstring("OTEL_TRACES_EXPORTER")))
+
+		GinkgoT().Setenv("OTEL_TRACES_EXPORTER", "otlp")
+		GinkgoT().Setenv("OTEL_EXPORTER_OTLP_PROTOCOL", "grpc")
+		_, err = NewProviderFromEnvironment(ctx)
+		Expect(err).To(MatchError(ContainSubstring("protocol")))
+	})
+})
+
+var _ = Describe("Tracer", func() {
+	ctx := context.Background()
+
+	It("records no spans and sends no trace context without a provider", func() {
+		SetProvider(nil)
+		spanCtx, span := Tracer().Start(ctx, "reconcile")
+		Expect(span.IsRecording()).To(BeFalse())
+
+		header := http.Header{}
+		otel.GetTextMapPropagator().Inject(spanCtx, propagation.HeaderCarrier(header))
+		Expect(header).To(BeEmpty())
+		span.End()
+	})
+
+	It("exports the spans with the trace context of their parent when the provider stops", func() {
+		exports := make(chan *collectortrace.ExportTraceServiceRequest, 10)
+		collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			defer GinkgoRecover()
+			Expect(req.URL.Path).To(Equal("/v1/traces"))
+			Expect(req.Header.Get("Content-Type")).To(Equal("application/x-protobuf"))
+			Expect(req.Header.Get("X-Tenant")).To(Equal("idm"))
+			body, err := io.ReadAll(req.Body)
+			Expect(err).NotTo(HaveOccurred())
+			export := &collectortrace.ExportTraceServiceRequest{}
+			Expect(proto.Unmarshal(body, export)).To(Succeed())
+			exports <- export
+		}))
+		DeferCleanup(collector.Close)
+
+		GinkgoT().Setenv("OTEL_EXPORTER_OTLP_ENDPOINT", collector.URL)
+		GinkgoT().Setenv("OTEL_EXPORTER_OTLP_HEADERS", "x-tenant=idm")
+		GinkgoT().Setenv("OTEL_SERVICE_NAME", "idm")
+		p, err := NewProviderFromEnvironment(ctx)
+		Expect(err).NotTo(HaveOccurred())
+		SetProvider(p)
+		DeferCleanup(SetProvider, (*Provider)(nil))
+
+		parentCtx, parent := Tracer().Start(ctx, "UserReconciler.Reconcile",
+			trace.WithAttributes(attribute.String("k8s.object.name", "jackr")))
+		childCtx, child := Tracer().Start(parentCtx, "POST create_user", trace.WithSpanKind(trace.SpanKindClient))
+		Expect(child.SpanContext().TraceID()).To(Equal(parent.SpanContext().TraceID()))
+
+		header := http.Header{}
+		otel.GetTextMapPropagator().Inject(childCtx, propagation.HeaderCarrier(header))
+		Expect(header.Get("traceparent")).To(Equal(
+			"00-" + parent.SpanContext().TraceID().String() + "-" + child.SpanContext().SpanID().String() + "-01"))
+
+		child.SetStatus(codes.Error, "503 Service Unavailable")
+		child.End()
+		parent.RecordError(errors.New("identity app unavailable"))
+		parent.End()
+
+		// the queued spans are exported when the provider stops
+		stopCtx, stop := context.WithCancel(ctx)
+		stop()
+		Expect(p.Start(stopCtx)).To(Succeed())
+
+		var export *collectortrace.ExportTraceServiceRequest
+		Eventually(exports).Should(Receive(&export))
+		Expect(export.ResourceSpans).To(HaveLen(1))
+		var serviceName string
+		for _, a := range export.ResourceSpans[0].Resource.Attributes {
+			if a.Key == "service.name" {
+				serviceName = a.Value.GetStringValue()
+			}
+		}
+		Expect(serviceName).To(Equal("idm"))
+
+		spans := export.ResourceSpans[0].ScopeSpans[0].Spans
+		Expect(spans).To(HaveLen(2))
+		Expect(spans[0].Name).To(Equal("POST create_user"))
+		Expect(spans[0].Kind).To(Equal(tracepb.Span_SPAN_KIND_CLIENT))
+		Expect(spans[0].ParentSpanId).To(Equal(spans[1].SpanId))
+		Expect(spans[0].Status.Code).To(Equal(tracepb.Status_STATUS_CODE_ERROR))
+		Expect(spans[0].Status.Message).To(Equal("503 Service Unavailable"))
+
+		Expect(spans[1].Name).To(Equal("UserReconciler.Reconcile"))
+		traceID := parent.SpanContext().TraceID()
+		Expect(spans[1].TraceId).To(Equal(traceID[:]))
+		Expect(spans[1].ParentSpanId).To(BeEmpty())
+		Expect(spans[1].Events).To(HaveLen(1), "the error is recorded as exception event")
+		Expect(spans[1].Attributes[0].Value.GetStringValue()).To(Equal("jackr"))
+	})
+
+	It("samples the traces with the sampler of the environment", func() {
+		GinkgoT().Setenv("OTEL_TRACES_EXPORTER", "otlp")
+		GinkgoT().Setenv("OTEL_TRACES_SAMPLER", "always_off")
+		p, err := NewProviderFromEnvironment(ctx)
+		Expect(err).NotTo(HaveOccurred())
+		DeferCleanup(p.Shutdown, ctx)
+		SetProvider(p)
+		DeferCleanup(SetProvider, (*Provider)(nil))
+
+		spanCtx, span := Tracer().Start(ctx, "reconcile")
+		Expect(span.SpanContext().TraceID().IsValid()).To(BeTrue())
+		Expect(span.SpanContext().IsSampled()).To(BeFalse())
+		header := http.Header{}
+		otel.GetTextMapPropagator().Inject(spanCtx, propagation.HeaderCarrier(header))
+		Expect(header.Get("traceparent")).To(HaveSuffix("-00"))
+		span.End()
+	})
+})
@@ -0,0 +1,148 @@
+//go:build e2e
+
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package e2e
+
+import (
+	"context"
+	"net"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"testing"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/scheme"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/log/zap"
+	metricsserver "sigs.k8s.io/controller-runtime/pkg/metrics/server"
+
+	idmv1 "github.com/m15ch4/go-identity-operator/api/v1"
+	"github.com/m15ch4/go-identity-operator/internal/controller"
+	idmsvc "github.com/m15ch4/go-identity-operator/pkg/identityclient"
+	"github.com/m15ch4/go-identity-operator/test/fakeidm"
+)
+
+// The end-to-end specs run the controllers against the cluster of the current kubeconfig,
+// e.g. a kind cluster with the CRDs installed by make test-e2e, and against the fake
+// identity app served in-process, whose faults the specs inject.
+
+var (
+	k8sClient client.Client
+	idm       *fakeidm.Server
+	namespace string
+	cancel    context.CancelFunc
+	idmServer *httptest.Server
+)
+
+func TestE2E(t *testing.T) {
+	RegisterFailHandler(Fail)
+
+	RunSpecs(t, "End-to-end Suite")
+}
+
+var _ = BeforeSuite(func() {
+	logf.SetLogger(zap.New(zap.WriteTo(GinkgoWriter), zap.UseDevMode(true)))
+	SetDefaultEventuallyTimeout(2 * time.Minute)
+	SetDefaultEventuallyPollingInterval(time.Second)
+
+	Expect(idmv1.AddToScheme(scheme.Scheme)).To(Succeed())
+	cfg, err := ctrl.GetConfig()
+	Expect(err).NotTo(HaveOccurred())
+	k8sClient, err = client.New(cfg, client.Options{Scheme: scheme.Scheme})
+	Expect(err).NotTo(HaveOccurred())
+
+	By("starting the fake identity app")
+	idm = fakeidm.NewServer("operator", "operator-secret")
+	idmServer = httptest.NewServer(idm)
+	endpoint, err := url.Parse(idmServer.URL)
+	Expect(err).NotTo(HaveOccurred())
+	host, portValue, err := net.SplitHostPort(endpoint.Host)
+	Expect(err).NotTo(HaveOccurred())
+	port, err := strconv.Atoi(portValue)
+	Expect(err).NotTo(HaveOccurred())
+
+	identityConfig := idmsvc.NewIdentityConfig(
+		idmsvc.WithHost(host),
+		idmsvc.WithPort(port),
+		idmsvc.WithUser("operator"),
+		idmsvc.WithPass("operator-secret"),
+		idmsvc.WithRequestTimeout(2*time.Second),
+		idmsvc.WithRetry(3, 50*time.Millisecond, 500*time.Millisecond),
+		idmsvc.WithCircuitBreaker(0, 0),
+	)
+
+	By("starting the controllers")
+	mgr, err := ctrl.NewManager(cfg, ctrl.Options{
+		Scheme:                 scheme.Scheme,
+		Metrics:                metricsserver.Options{BindAddress: "0"},
+		HealthProbeBindAddress: "0",
+	})
+	Expect(err).NotTo(HaveOccurred())
+
+	options := controller.ControllerOptions{
+		MaxConcurrentReconciles: 4,
+		RequeueBaseDelay:        100 * time.Millisecond,
+		RequeueMaxDelay:         5 * time.Second,
+	}
+	Expect((&controller.UserReconciler{
+		Client:            mgr.GetClient(),
+		Scheme:            mgr.GetScheme(),
+		Recorder:          mgr.GetEventRecorderFor("user-controller"),
+		IdentityService:   idmsvc.NewIdentityService(&identityConfig),
+		Options:           options,
+		DriftResyncPeriod: 5 * time.Second,
+	}).SetupWithManager(mgr)).To(Succeed())
+
+	var ctx context.Context
+	ctx, cancel = context.WithCancel(context.Background())
+	go func() {
+		defer GinkgoRecover()
+		Expect(mgr.Start(ctx)).To(Succeed())
+	}()
+
+	ns := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{GenerateName: "idm-e2e-"}}
+	Expect(k8sClient.Create(context.Background(), ns)).To(Succeed())
+	namespace = ns.Name
+})
+
+var _ = AfterSuite(func() {
+	if k8sClient == nil {
+		return
+	}
+	By("removing the test namespace while the controllers finalize its Users")
+	idm.SetFaults(fakeidm.Faults{})
+	ctx := context.Background()
+	Expect(k8sClient.DeleteAllOf(ctx, &idmv1.User{}, client.InNamespace(namespace))).To(Succeed())
+	Eventually(func() ([]idmv1.User, error) {
+		users := &idmv1.UserList{}
+		err := k8sClient.List(ctx, users, client.InNamespace(namespace))
+		return users.Items, err
+	}).Should(BeEmpty())
+	Expect(k8sClient.Delete(ctx, &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: namespace}})).To(Succeed())
+
+	cancel()
+	idmServer.Close()
+})
@@ -0,0 +1,192 @@
+//go:build e2e
+
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package e2e
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	utilrand "k8s.io/apimachinery/pkg/util/rand"
+
+	idmv1 "github.com/m15ch4/go-identity-operator/api/v1"
+	idmsvc "github.com/m15ch4/go-identity-operator/pkg/identityclient"
+	"github.com/m15ch4/go-identity-operator/test/fakeidm"
+)
+
+var _ = Describe("User lifecycle", func() {
+	var ctx context.Context
+
+	BeforeEach(func() {
+		ctx = context.Background()
+	})
+
+	AfterEach(func() {
+		idm.SetFaults(fakeidm.Faults{})
+	})
+
+	// newUser creates a User whose external name is unique across the specs
+	newUser := func(prefix string) *idmv1.User {
+		name := prefix + "-" + utilrand.String(5)
+		user := &idmv1.User{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace},
+			Spec: idmv1.UserSpec{
+				Name:      name,
+				Password:  "Secret123!",
+				Firstname: "Jack",
+				Lastname:  "Reacher",
+				Role:      "user",
+			},
+		}
+		Expect(k8sClient.Create(ctx, user)).To(Succeed())
+		return user
+	}
+
+	// externalUsers returns the users of the fake identity app with the given name
+	externalUsers := func(name string) []idmsvc.IdentityUser {
+		var found []idmsvc.IdentityUser
+		for _, usr := range idm.Users() {
+			if usr.Name == name {
+				found = append(found, usr)
+			}
+		}
+		return found
+	}
+
+	fetch := func(user *idmv1.User) func() (*idmv1.User, error) {
+		return func() (*idmv1.User, error) {
+			current := &idmv1.User{}
+			err := k8sClient.Get(ctx, types.NamespacedName{Namespace: user.Namespace, Name: user.Name}, current)
+			return current, err
+		}
+	}
+
+	ready := func(user *idmv1.User) bool {
+		return user.Status.ID != "" && meta.IsStatusConditionTrue(user.Status.Conditions, idmv1.ConditionReady)
+	}
+
+	It("creates, updates, corrects and deletes the external user", func() {
+		user := newUser("lifecycle")
+		Eventually(fetch(user)).Should(Satisfy(ready))
+		Expect(externalUsers(user.Name)).To(HaveLen(1))
+
+		By("applying a change of the spec")
+		Eventually(func() error {
+			current, err := fetch(user)()
+			if err != nil {
+				return err
+			}
+			current.Spec.Firstname = "Jim"
+			return k8sClient.Update(ctx, current)
+		}).Should(Succeed())
+		Eventually(func() string {
+			return externalUsers(user.Name)[0].Firstname
+		}).Should(Equal("Jim"))
+
+		By("correcting drift caused in the identity app")
+		drifted := externalUsers(user.Name)[0]
+		drifted.Lastname = "Smith"
+		idm.SetUser(drifted)
+		Eventually(func() string {
+			return externalUsers(user.Name)[0].Lastname
+		}).Should(Equal("Reacher"))
+
+		By("deleting the external user with the User")
+		Expect(k8sClient.Delete(ctx, user)).To(Succeed())
+		Eventually(func() error {
+			_, err := fetch(user)()
+			return err
+		}).Should(Satisfy(errors.IsNotFound))
+		Expect(externalUsers(user.Name)).To(BeEmpty())
+	})
+
+	It("converges while the identity app is slow and fails a third of the requests", func() {
+		idm.SetFaults(fakeidm.Faults{Latency: 50 * time.Millisecond, ErrorRate: 0.3})
+
+		var users []*idmv1.User
+		for i := 0; i < 10; i++ {
+			users = append(users, newUser(fmt.Sprintf("flaky%d", i)))
+		}
+		for _, user := range users {
+			Eventually(fetch(user)).Should(Satisfy(ready))
+			Expect(externalUsers(user.Name)).To(HaveLen(1))
+		}
+		Expect(idm.Stats().Injected).To(BeNumerically(">", 0))
+	})
+
+	It("creates every user once when responses are lost", func() {
+		idm.SetFaults(fakeidm.Faults{LostResponseRate: 0.5})
+
+		var users []*idmv1.User
+		for i := 0; i < 5; i++ {
+			users = append(users, newUser(fmt.Sprintf("lost%d", i)))
+		}
+		for _, user := range users {
+			Eventually(fetch(user)).Should(Satisfy(ready))
+			current, err := fetch(user)()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(externalUsers(user.Name)).To(ConsistOf(HaveField("ID", current.Status.ID)))
+		}
+	})
+
+	It("logs in again after the identity app invalidated its tokens", func() {
+		user := newUser("relogin")
+		Eventually(fetch(user)).Should(Satisfy(ready))
+		logins := idm.Stats().Requests["POST /login"]
+
+		idm.ExpireTokens()
+		Eventually(func() error {
+			current, err := fetch(user)()
+			if err != nil {
+				return err
+			}
+			current.Spec.Lastname = "Reach"
+			return k8sClient.Update(ctx, current)
+		}).Should(Succeed())
+		Eventually(func() string {
+			return externalUsers(user.Name)[0].Lastname
+		}).Should(Equal("Reach"))
+		Expect(idm.Stats().Requests["POST /login"]).To(BeNumerically(">", logins))
+	})
+
+	It("reports an outage of the identity app and resumes once it recovers", func() {
+		idm.SetFaults(fakeidm.Faults{ErrorRate: 1, ErrorStatus: http.StatusInternalServerError})
+
+		user := newUser("outage")
+		Eventually(func() (bool, error) {
+			current, err := fetch(user)()
+			return meta.IsStatusConditionTrue(current.Status.Conditions, idmv1.ConditionDegraded), err
+		}).Should(BeTrue())
+		Consistently(func() []idmsvc.IdentityUser {
+			return externalUsers(user.Name)
+		}, 3*time.Second).Should(BeEmpty())
+
+		idm.SetFaults(fakeidm.Faults{})
+		Eventually(fetch(user)).Should(Satisfy(ready))
+		Expect(externalUsers(user.Name)).To(HaveLen(1))
+	})
+})
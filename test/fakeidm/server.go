@@ -0,0 +1,588 @@
+// Package fakeidm serves an in-memory identity app over HTTP for end-to-end tests. It
+// speaks the REST API of the identity app and injects latency, errors, lost responses and
+// early token expiry on demand, so the operator can be exercised under failure conditions.
+package fakeidm
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	mathrand "math/rand"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	idmsvc "github.com/m15ch4/go-identity-operator/pkg/identityclient"
+)
+
+// Faults configures the failures injected into the requests served
+type Faults struct {
+	// Latency delays every response
+	Latency time.Duration
+	// ErrorRate is the fraction of requests rejected with ErrorStatus before they are applied
+	ErrorRate float64
+	// ErrorStatus is the status code of the injected errors, 503 when zero
+	ErrorStatus int
+	// LostResponseRate is the fraction of requests that are applied but answered with a
+	// 504, as if the response was lost on its way back
+	LostResponseRate float64
+	// TokenTTL is the lifetime of the issued tokens, one hour when zero
+	TokenTTL time.Duration
+}
+
+// Stats counts the requests served
+type Stats struct {
+	// Requests counts the requests by method and path pattern, e.g. "POST /users"
+	Requests map[string]int
+	// Injected counts the requests failed on purpose
+	Injected int
+	// Replayed counts the creations answered from the idempotency cache
+	Replayed int
+}
+
+// Server is the fake identity app. Its zero value is not usable, use NewServer.
+type Server struct {
+	user, pass string
+
+	mu      sync.Mutex
+	faults  Faults
+	rand    *mathrand.Rand
+	stats   Stats
+	tokens  map[string]time.Time
+	users   map[string]idmsvc.IdentityUser
+	roles   map[string]idmsvc.IdentityRole
+	groups  map[string]idmsvc.IdentityGroup
+	members map[string]map[string]bool
+	apiKeys map[string]idmsvc.IdentityAPIKey
+	// replies keeps the response of each creation by its idempotency key
+	replies map[string]reply
+	nextID  int
+}
+
+// reply is a recorded response
+type reply struct {
+	status int
+	body   []byte
+}
+
+// NewServer returns a fake identity app accepting logins with the given credentials
+func NewServer(user, pass string) *Server {
+	return &Server{
+		user:    user,
+		pass:    pass,
+		rand:    mathrand.New(mathrand.NewSource(time.Now().UnixNano())),
+		stats:   Stats{Requests: map[string]int{}},
+		tokens:  map[string]time.Time{},
+		users:   map[string]idmsvc.IdentityUser{},
+		roles:   map[string]idmsvc.IdentityRole{},
+		groups:  map[string]idmsvc.IdentityGroup{},
+		members: map[string]map[string]bool{},
+		apiKeys: map[string]idmsvc.IdentityAPIKey{},
+		replies: map[string]reply{},
+	}
+}
+
+// SetFaults replaces the injected faults, the zero value disables them
+func (s *Server) SetFaults(faults Faults) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.faults = faults
+}
+
+// ExpireTokens invalidates all issued tokens, as a restart of the identity app does
+func (s *Server) ExpireTokens() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.tokens = map[string]time.Time{}
+}
+
+// Users returns the users of the identity app
+func (s *Server) Users() []idmsvc.IdentityUser {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	users := make([]idmsvc.IdentityUser, 0, len(s.users))
+	for _, usr := range s.users {
+		users = append(users, usr)
+	}
+	return users
+}
+
+// SetUser overwrites a user, e.g. to simulate drift caused by an administrator
+func (s *Server) SetUser(usr idmsvc.IdentityUser) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.users[usr.ID] = usr
+}
+
+// Stats returns a copy of the request counters
+func (s *Server) Stats() Stats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stats := s.stats
+	stats.Requests = make(map[string]int, len(s.stats.Requests))
+	for key, count := range s.stats.Requests {
+		stats.Requests[key] = count
+	}
+	return stats
+}
+
+// ServeHTTP injects the configured faults and serves the request
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	faults := s.faults
+	s.stats.Requests[r.Method+" "+pattern(r.URL.Path)]++
+	inject := faults.ErrorRate > 0 && s.rand.Float64() < faults.ErrorRate
+	lose := faults.LostResponseRate > 0 && s.rand.Float64() < faults.LostResponseRate
+	if inject || lose {
+		s.stats.Injected++
+	}
+	s.mu.Unlock()
+
+	if faults.Latency > 0 {
+		select {
+		case <-time.After(faults.Latency):
+		case <-r.Context().Done():
+			return
+		}
+	}
+
+	if inject {
+		status := faults.ErrorStatus
+		if status == 0 {
+			status = http.StatusServiceUnavailable
+		}
+		writeError(w, status, "injected failure")
+		return
+	}
+
+	if lose {
+		s.serve(&discardWriter{header: http.Header{}}, r)
+		writeError(w, http.StatusGatewayTimeout, "injected lost response")
+		return
+	}
+
+	s.serve(w, r)
+}
+
+// serve routes the request to its handler
+func (s *Server) serve(w http.ResponseWriter, r *http.Request) {
+	path := strings.Trim(r.URL.Path, "/")
+	if path == "login" && r.Method == http.MethodPost {
+		s.login(w, r)
+		return
+	}
+	if !s.authenticated(r) {
+		writeError(w, http.StatusUnauthorized, "invalid or expired token")
+		return
+	}
+
+	segments := strings.Split(path, "/")
+	switch segments[0] {
+	case "version":
+		writeJSON(w, http.StatusOK, map[string]string{"version": "fakeidm"})
+	case "users":
+		s.serveUsers(w, r, segments[1:])
+	case "roles":
+		s.serveRoles(w, r, segments[1:])
+	case "groups":
+		s.serveGroups(w, r, segments[1:])
+	case "apikeys":
+		s.serveAPIKeys(w, r, segments[1:])
+	default:
+		writeError(w, http.StatusNotFound, "not found")
+	}
+}
+
+// login issues a token for the configured credentials
+func (s *Server) login(w http.ResponseWriter, r *http.Request) {
+	var body idmsvc.LoginRequestBody
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if body.Name != s.user || body.Password != s.pass {
+		writeError(w, http.StatusUnauthorized, "invalid credentials")
+		return
+	}
+
+	s.mu.Lock()
+	ttl := s.faults.TokenTTL
+	if ttl == 0 {
+		ttl = time.Hour
+	}
+	token := randomString()
+	s.tokens[token] = time.Now().Add(ttl)
+	s.mu.Unlock()
+
+	writeJSON(w, http.StatusOK, idmsvc.LoginResponse{Token: token, ExpiresIn: int(ttl.Seconds())})
+}
+
+// authenticated reports whether the request carries a token that did not expire
+func (s *Server) authenticated(r *http.Request) bool {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok {
+		return false
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	expiry, ok := s.tokens[token]
+	return ok && time.Now().Before(expiry)
+}
+
+// create answers a repeated creation with the response recorded for its idempotency key,
+// otherwise it records the response written by apply
+func (s *Server) create(w http.ResponseWriter, r *http.Request, apply func() (int, interface{})) {
+	key := r.Header.Get(idmsvc.IdempotencyKeyHeader)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if recorded, ok := s.replies[key]; ok && key != "" {
+		s.stats.Replayed++
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(recorded.status)
+		_, _ = w.Write(recorded.body)
+		return
+	}
+
+	status, out := apply()
+	body, _ := json.Marshal(out)
+	if key != "" && status < 300 {
+		s.replies[key] = reply{status: status, body: body}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_, _ = w.Write(body)
+}
+
+func (s *Server) serveUsers(w http.ResponseWriter, r *http.Request, segments []string) {
+	if len(segments) == 0 {
+		switch r.Method {
+		case http.MethodGet:
+			query := r.URL.Query()
+			name := query.Get("name")
+			s.mu.Lock()
+			users := []idmsvc.IdentityUser{}
+			for _, usr := range s.users {
+				if name == "" || usr.Name == name {
+					users = append(users, usr)
+				}
+			}
+			s.mu.Unlock()
+			// users are paginated by offset and limit when a limit is given
+			sort.Slice(users, func(i, j int) bool { return users[i].ID < users[j].ID })
+			if limit, err := strconv.Atoi(query.Get("limit")); err == nil && limit > 0 {
+				offset, _ := strconv.Atoi(query.Get("offset"))
+				if offset > len(users) {
+					offset = len(users)
+				}
+				users = users[offset:]
+				if len(users) > limit {
+					users = users[:limit]
+				}
+			}
+			writeJSON(w, http.StatusOK, users)
+		case http.MethodPost:
+			var usr idmsvc.IdentityUser
+			if !decode(w, r, &usr) {
+				return
+			}
+			s.create(w, r, func() (int, interface{}) {
+				for _, existing := range s.users {
+					if existing.Name == usr.Name {
+						return http.StatusConflict, map[string]string{"error": "user " + usr.Name + " exists"}
+					}
+				}
+				usr.ID = s.newID()
+				usr.Password = ""
+				s.users[usr.ID] = usr
+				return http.StatusCreated, usr
+			})
+		default:
+			writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		}
+		return
+	}
+
+	id := segments[0]
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	usr, ok := s.users[id]
+	if !ok {
+		writeError(w, http.StatusNotFound, "user "+id+" not found")
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		writeJSON(w, http.StatusOK, usr)
+	case http.MethodPut:
+		var replaced idmsvc.IdentityUser
+		if !decode(w, r, &replaced) {
+			return
+		}
+		replaced.ID, replaced.Password = id, ""
+		s.users[id] = replaced
+		writeJSON(w, http.StatusOK, replaced)
+	case http.MethodPatch:
+		// merge the patch into the JSON representation of the stored user
+		current, _ := json.Marshal(usr)
+		merged := map[string]interface{}{}
+		_ = json.Unmarshal(current, &merged)
+		patch := map[string]interface{}{}
+		if !decode(w, r, &patch) {
+			return
+		}
+		for name, value := range patch {
+			merged[name] = value
+		}
+		body, _ := json.Marshal(merged)
+		var patched idmsvc.IdentityUser
+		if err := json.Unmarshal(body, &patched); err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		patched.ID, patched.Password = id, ""
+		s.users[id] = patched
+		writeJSON(w, http.StatusOK, patched)
+	case http.MethodDelete:
+		delete(s.users, id)
+		for _, members := range s.members {
+			delete(members, id)
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+	}
+}
+
+func (s *Server) serveRoles(w http.ResponseWriter, r *http.Request, segments []string) {
+	if len(segments) == 0 {
+		if r.Method != http.MethodPost {
+			writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		var role idmsvc.IdentityRole
+		if !decode(w, r, &role) {
+			return
+		}
+		s.create(w, r, func() (int, interface{}) {
+			role.ID = s.newID()
+			s.roles[role.ID] = role
+			return http.StatusCreated, role
+		})
+		return
+	}
+
+	id := segments[0]
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	role, ok := s.roles[id]
+	if !ok {
+		writeError(w, http.StatusNotFound, "role "+id+" not found")
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		writeJSON(w, http.StatusOK, role)
+	case http.MethodPut:
+		if !decode(w, r, &role) {
+			return
+		}
+		role.ID = id
+		s.roles[id] = role
+		writeJSON(w, http.StatusOK, role)
+	case http.MethodDelete:
+		delete(s.roles, id)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+	}
+}
+
+func (s *Server) serveGroups(w http.ResponseWriter, r *http.Request, segments []string) {
+	if len(segments) == 0 {
+		if r.Method != http.MethodPost {
+			writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		var group idmsvc.IdentityGroup
+		if !decode(w, r, &group) {
+			return
+		}
+		s.create(w, r, func() (int, interface{}) {
+			group.ID = s.newID()
+			s.groups[group.ID] = group
+			s.members[group.ID] = map[string]bool{}
+			return http.StatusCreated, group
+		})
+		return
+	}
+
+	id := segments[0]
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	group, ok := s.groups[id]
+	if !ok {
+		writeError(w, http.StatusNotFound, "group "+id+" not found")
+		return
+	}
+
+	// /groups/{id}/members[/{userID}]
+	if len(segments) > 1 && segments[1] == "members" {
+		s.serveMembers(w, r, id, segments[2:])
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		writeJSON(w, http.StatusOK, group)
+	case http.MethodPut:
+		if !decode(w, r, &group) {
+			return
+		}
+		group.ID = id
+		s.groups[id] = group
+		writeJSON(w, http.StatusOK, group)
+	case http.MethodDelete:
+		delete(s.groups, id)
+		delete(s.members, id)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+	}
+}
+
+// serveMembers serves the members of a group; s.mu must be held
+func (s *Server) serveMembers(w http.ResponseWriter, r *http.Request, groupID string, segments []string) {
+	if len(segments) == 0 {
+		if r.Method != http.MethodGet {
+			writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		members := []map[string]string{}
+		for userID := range s.members[groupID] {
+			members = append(members, map[string]string{"id": userID})
+		}
+		writeJSON(w, http.StatusOK, members)
+		return
+	}
+
+	userID := segments[0]
+	switch r.Method {
+	case http.MethodPut:
+		if _, ok := s.users[userID]; !ok {
+			writeError(w, http.StatusNotFound, "user "+userID+" not found")
+			return
+		}
+		s.members[groupID][userID] = true
+		w.WriteHeader(http.StatusNoContent)
+	case http.MethodDelete:
+		if !s.members[groupID][userID] {
+			writeError(w, http.StatusNotFound, "user "+userID+" is not a member")
+			return
+		}
+		delete(s.members[groupID], userID)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+	}
+}
+
+func (s *Server) serveAPIKeys(w http.ResponseWriter, r *http.Request, segments []string) {
+	if len(segments) == 0 {
+		if r.Method != http.MethodPost {
+			writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		var key idmsvc.IdentityAPIKey
+		if !decode(w, r, &key) {
+			return
+		}
+		s.create(w, r, func() (int, interface{}) {
+			key.ID = s.newID()
+			key.Key = randomString()
+			s.apiKeys[key.ID] = idmsvc.IdentityAPIKey{ID: key.ID, Name: key.Name, Description: key.Description}
+			return http.StatusCreated, key
+		})
+		return
+	}
+
+	id := segments[0]
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	key, ok := s.apiKeys[id]
+	if !ok {
+		writeError(w, http.StatusNotFound, "api key "+id+" not found")
+		return
+	}
+
+	switch {
+	case len(segments) > 1 && segments[1] == "rotate" && r.Method == http.MethodPost:
+		key.Key = randomString()
+		writeJSON(w, http.StatusOK, key)
+	case len(segments) == 1 && r.Method == http.MethodDelete:
+		delete(s.apiKeys, id)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+	}
+}
+
+// newID returns the next ID; s.mu must be held
+func (s *Server) newID() string {
+	s.nextID++
+	return strconv.Itoa(s.nextID)
+}
+
+// pattern replaces the IDs in the path, so requests are counted per endpoint
+func pattern(path string) string {
+	segments := strings.Split(strings.Trim(path, "/"), "/")
+	for i := 1; i < len(segments); i += 2 {
+		segments[i] = "{id}"
+	}
+	return "/" + strings.Join(segments, "/")
+}
+
+// decode reads the JSON request body into out and answers 400 if it is malformed
+func decode(w http.ResponseWriter, r *http.Request, out interface{}) bool {
+	if err := json.NewDecoder(r.Body).Decode(out); err != nil && err != io.EOF {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return false
+	}
+	return true
+}
+
+func writeJSON(w http.ResponseWriter, status int, out interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(out)
+}
+
+func writeError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, map[string]string{"error": message})
+}
+
+// randomString returns a random token
+func randomString() string {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// discardWriter swallows the response of a request whose response is lost
+type discardWriter struct {
+	header http.Header
+}
+
+func (w *discardWriter) Header() http.Header         { return w.header }
+func (w *discardWriter) Write(b []byte) (int, error) { return len(b), nil }
+func (w *discardWriter) WriteHeader(int)             {}